@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/director74/dz8_shop/pkg/config"
 )
 
@@ -10,6 +12,19 @@ type Config struct {
 	Postgres config.PostgresConfig
 	RabbitMQ config.RabbitMQConfig
 	JWT      config.JWTConfig
+	Outbox   config.OutboxConfig
+	Ledger   LedgerConfig
+	Currency CurrencyConfig
+}
+
+// LedgerConfig содержит настройки сверки балансов с главной книгой
+type LedgerConfig struct {
+	ReconciliationInterval time.Duration // Интервал фоновой сверки балансов
+}
+
+// CurrencyConfig содержит настройки курсов обмена валют
+type CurrencyConfig struct {
+	ExchangeRatesFile string // JSON файл с курсами, загружаемый при запуске; пустой - курсы задаются через API
 }
 
 func NewConfig() (*Config, error) {
@@ -24,5 +39,12 @@ func NewConfig() (*Config, error) {
 		Postgres: commonConfig.Postgres,
 		RabbitMQ: commonConfig.RabbitMQ,
 		JWT:      *jwtConfig,
+		Outbox:   *config.LoadOutboxConfig(),
+		Ledger: LedgerConfig{
+			ReconciliationInterval: config.GetEnvAsDuration("BILLING_RECONCILIATION_INTERVAL", 10*time.Minute),
+		},
+		Currency: CurrencyConfig{
+			ExchangeRatesFile: config.GetEnv("BILLING_EXCHANGE_RATES_FILE", ""),
+		},
 	}, nil
 }
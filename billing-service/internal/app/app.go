@@ -21,7 +21,10 @@ import (
 	"github.com/director74/dz8_shop/pkg/database"
 	"github.com/director74/dz8_shop/pkg/errors"
 	"github.com/director74/dz8_shop/pkg/messaging"
+	pkgMiddleware "github.com/director74/dz8_shop/pkg/middleware"
+	"github.com/director74/dz8_shop/pkg/outbox"
 	"github.com/director74/dz8_shop/pkg/rabbitmq"
+	"github.com/director74/dz8_shop/pkg/sagahandler"
 )
 
 // App представляет приложение
@@ -31,6 +34,9 @@ type App struct {
 	db         *gorm.DB
 	rabbitMQ   *rabbitmq.RabbitMQ
 	jwtManager *auth.JWTManager
+
+	stopRelay          context.CancelFunc
+	stopReconciliation context.CancelFunc
 }
 
 func NewApp(config *config.Config) (*App, error) {
@@ -88,29 +94,61 @@ func NewApp(config *config.Config) (*App, error) {
 
 	// Создаем репозитории
 	billingRepo := repo.NewBillingRepository(db)
-	billingUseCase := usecase.NewBillingUseCase(billingRepo, rmq, "billing_events")
+	ledgerRepo := repo.NewLedgerRepository(db)
+	exchangeRateRepo := repo.NewExchangeRateRepository(db)
+
+	// Загружаем курсы обмена из файла, если он задан
+	if config.Currency.ExchangeRatesFile != "" {
+		loaded, err := exchangeRateRepo.LoadFile(context.Background(), config.Currency.ExchangeRatesFile)
+		if err != nil {
+			database.CloseDB(db)
+			rmq.Close()
+			return nil, errors.AppendPrefix(err, "не удалось загрузить курсы обмена")
+		}
+		log.Printf("Загружено курсов обмена: %d", loaded)
+	}
+
+	// События биллинга сохраняются в outbox в транзакции операции и отправляются relay
+	outboxPublisher := outbox.NewPublisher(db)
+	billingUseCase := usecase.NewBillingUseCase(billingRepo, ledgerRepo, exchangeRateRepo, outboxPublisher, "billing_events")
+
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	go outbox.NewRelay(db, rmq, config.Outbox, nil).Run(relayCtx)
+
+	// Фоновая сверка балансов аккаунтов с главной книгой
+	reconciliationCtx, stopReconciliation := context.WithCancel(context.Background())
+	go billingUseCase.RunReconciliation(reconciliationCtx, config.Ledger.ReconciliationInterval)
 
 	// Настраиваем обработчик сообщений из очереди заказов
 	err = rmq.ConsumeMessages("order_billing_queue", "billing-service", func(data []byte) error {
 		return billingUseCase.HandleOrderCreatedEvent(data)
 	})
 	if err != nil {
+		stopReconciliation()
+		stopRelay()
 		database.CloseDB(db)
 		rmq.Close()
 		return nil, errors.AppendPrefix(err, "ошибка при настройке обработчика сообщений")
 	}
 
-	// Создаем и настраиваем SagaConsumer
-	sagaConsumer := rmqController.NewSagaConsumer(billingUseCase, rmq)
+	// Создаем и настраиваем обработчики шагов саги: блокировку средств и их списание
+	sagaLedger := sagahandler.NewLedger(db)
+	sagaConsumer := rmqController.NewSagaConsumer(billingUseCase, rmq, sagaLedger)
+	captureConsumer := rmqController.NewCaptureConsumer(billingUseCase, rmq, sagaLedger)
 	go func() {
 		if err := sagaConsumer.Setup(); err != nil {
 			log.Printf("Ошибка при настройке обработчика саги для биллинга: %v", err)
 		} else {
 			log.Println("Обработчик саги для биллинга успешно настроен")
 		}
+		if err := captureConsumer.Setup(); err != nil {
+			log.Printf("Ошибка при настройке обработчика списания средств в саге: %v", err)
+		} else {
+			log.Println("Обработчик списания средств в саге успешно настроен")
+		}
 	}()
 
-	billingHandler := httpController.NewBillingHandler(billingUseCase, authMiddleware)
+	billingHandler := httpController.NewBillingHandler(billingUseCase, authMiddleware, pkgMiddleware.NewIdempotencyMiddleware(db))
 
 	// Инициализируем Gin роутер
 	router := gin.Default()
@@ -125,6 +163,7 @@ func NewApp(config *config.Config) (*App, error) {
 
 	// Регистрируем эндпоинты
 	billingHandler.RegisterRoutes(router)
+	messaging.RegisterDeadLetterAdmin(router, rmq)
 
 	httpServer := &http.Server{
 		Addr:         ":" + config.HTTP.Port,
@@ -139,6 +178,9 @@ func NewApp(config *config.Config) (*App, error) {
 		db:         db,
 		rabbitMQ:   rmq,
 		jwtManager: jwtManager,
+
+		stopRelay:          stopRelay,
+		stopReconciliation: stopReconciliation,
 	}, nil
 }
 
@@ -174,6 +216,14 @@ func (a *App) Run() error {
 func (a *App) Shutdown() error {
 	errGroup := errors.NewErrorGroup()
 
+	// Останавливаем relay outbox и сверку балансов до закрытия соединений
+	if a.stopRelay != nil {
+		a.stopRelay()
+	}
+	if a.stopReconciliation != nil {
+		a.stopReconciliation()
+	}
+
 	// Закрываем HTTP сервер
 	if a.httpServer != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -1,25 +1,35 @@
 package http
 
 import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 
 	"github.com/director74/dz8_shop/billing-service/internal/entity"
 	"github.com/director74/dz8_shop/billing-service/internal/usecase"
 	"github.com/director74/dz8_shop/pkg/auth"
+	pkgMiddleware "github.com/director74/dz8_shop/pkg/middleware"
+	"github.com/director74/dz8_shop/pkg/money"
 )
 
 type BillingHandler struct {
 	billingUseCase *usecase.BillingUseCase
 	authMiddleware *auth.AuthMiddleware
+	idempotency    *pkgMiddleware.IdempotencyMiddleware
 }
 
-func NewBillingHandler(billingUseCase *usecase.BillingUseCase, authMiddleware *auth.AuthMiddleware) *BillingHandler {
+func NewBillingHandler(billingUseCase *usecase.BillingUseCase, authMiddleware *auth.AuthMiddleware, idempotency *pkgMiddleware.IdempotencyMiddleware) *BillingHandler {
 	return &BillingHandler{
 		billingUseCase: billingUseCase,
 		authMiddleware: authMiddleware,
+		idempotency:    idempotency,
 	}
 }
 
@@ -40,10 +50,32 @@ func (h *BillingHandler) RegisterRoutes(router *gin.Engine) {
 			auth.GET("/account", h.GetCurrentAccount)
 
 			// Пополнение баланса для своего аккаунта
-			auth.POST("/deposit", h.Deposit)
-			auth.POST("/withdraw", h.Withdraw)
+			// Повтор запроса с тем же Idempotency-Key не приводит к повторному списанию или пополнению
+			auth.POST("/deposit", h.idempotency.Handler(), h.Deposit)
+			auth.POST("/withdraw", h.idempotency.Handler(), h.Withdraw)
+
+			// История транзакций и месячные выписки своего аккаунта
+			auth.GET("/transactions", h.ListTransactions)
+			auth.GET("/statements/:period", h.GetStatement)
 		}
 	}
+
+	// Внутренние маршруты для службы поддержки: история и выписки любого пользователя
+	internal := router.Group("/internal/billing/users/:user_id", pkgMiddleware.NewInternalAuthMiddleware(nil).Required())
+	{
+		internal.GET("/transactions", h.ListTransactions)
+		internal.GET("/statements/:period", h.GetStatement)
+	}
+
+	// Сверка балансов аккаунтов с главной книгой по запросу
+	router.GET("/internal/billing/reconciliation", pkgMiddleware.NewInternalAuthMiddleware(nil).Required(), h.Reconcile)
+
+	// Курсы обмена валют для оплаты заказов в валюте, отличной от валюты счета
+	rates := router.Group("/internal/billing/exchange-rates", pkgMiddleware.NewInternalAuthMiddleware(nil).Required())
+	{
+		rates.GET("", h.ListExchangeRates)
+		rates.PUT("", h.SetExchangeRate)
+	}
 }
 
 func (h *BillingHandler) HealthCheck(c *gin.Context) {
@@ -59,7 +91,7 @@ func (h *BillingHandler) CreateAccount(c *gin.Context) {
 
 	resp, err := h.billingUseCase.CreateAccount(c.Request.Context(), req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(operationErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -119,9 +151,15 @@ func (h *BillingHandler) Deposit(c *gin.Context) {
 		email = auth.GetEmail(c)
 	}
 
-	resp, err := h.billingUseCase.Deposit(c.Request.Context(), userID, req.Amount, email)
+	currency, err := requestCurrency(req.Currency)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.billingUseCase.Deposit(c.Request.Context(), userID, req.Amount, currency, email)
+	if err != nil {
+		c.JSON(operationErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -148,9 +186,15 @@ func (h *BillingHandler) Withdraw(c *gin.Context) {
 		email = auth.GetEmail(c)
 	}
 
-	resp, err := h.billingUseCase.Withdraw(c.Request.Context(), userID, req.Amount, email)
+	currency, err := requestCurrency(req.Currency)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.billingUseCase.Withdraw(c.Request.Context(), userID, req.Amount, currency, email)
+	if err != nil {
+		c.JSON(operationErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -161,3 +205,176 @@ func (h *BillingHandler) Withdraw(c *gin.Context) {
 
 	c.JSON(http.StatusOK, resp)
 }
+
+// ListTransactions возвращает историю транзакций текущего пользователя
+// (или пользователя из пути для внутреннего API)
+func (h *BillingHandler) ListTransactions(c *gin.Context) {
+	userID, ok := h.statementUserID(c)
+	if !ok {
+		return
+	}
+
+	var query entity.ListTransactionsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := h.billingUseCase.ListTransactions(c.Request.Context(), userID, query)
+	if err != nil {
+		h.handleStatementError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetStatement возвращает выписку за месяц в JSON или, при format=csv, файлом CSV
+func (h *BillingHandler) GetStatement(c *gin.Context) {
+	userID, ok := h.statementUserID(c)
+	if !ok {
+		return
+	}
+
+	statement, err := h.billingUseCase.GetStatement(c.Request.Context(), userID, c.Param("period"))
+	if err != nil {
+		h.handleStatementError(c, err)
+		return
+	}
+
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, statement)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=statement-%s.csv", statement.Period))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := writeStatementCSV(c.Writer, statement); err != nil {
+		_ = c.Error(err)
+	}
+}
+
+// Reconcile сверяет балансы аккаунтов с главной книгой и возвращает отчет о расхождениях
+func (h *BillingHandler) Reconcile(c *gin.Context) {
+	report, err := h.billingUseCase.Reconcile(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ListExchangeRates возвращает заданные курсы обмена валют
+func (h *BillingHandler) ListExchangeRates(c *gin.Context) {
+	rates, err := h.billingUseCase.ListExchangeRates(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rates": rates})
+}
+
+// SetExchangeRate задает курс обмена для пары валют
+func (h *BillingHandler) SetExchangeRate(c *gin.Context) {
+	var req entity.SetExchangeRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rate, err := h.billingUseCase.SetExchangeRate(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(operationErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rate)
+}
+
+// requestCurrency разбирает необязательную валюту запроса. Пустая валюта означает валюту счета.
+func requestCurrency(currency money.Currency) (money.Currency, error) {
+	if currency == "" {
+		return "", nil
+	}
+	return money.ParseCurrency(string(currency))
+}
+
+// operationErrorStatus возвращает HTTP статус для ошибки операции со счетом:
+// ошибки валюты и курса обмена - 422, остальные - 500
+func operationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, money.ErrInvalidCurrency),
+		errors.Is(err, money.ErrCurrencyMismatch),
+		errors.Is(err, money.ErrInvalidRate),
+		errors.Is(err, money.ErrRateNotFound):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// statementUserID определяет пользователя: из пути для внутреннего API, иначе из JWT токена
+func (h *BillingHandler) statementUserID(c *gin.Context) (uint, bool) {
+	if param := c.Param("user_id"); param != "" {
+		userID, err := strconv.ParseUint(param, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID пользователя"})
+			return 0, false
+		}
+		return uint(userID), true
+	}
+
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "невозможно определить пользователя"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// handleStatementError преобразует ошибку истории транзакций или выписки в HTTP ответ
+func (h *BillingHandler) handleStatementError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrInvalidPeriod):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// writeStatementCSV записывает выписку в CSV: заголовок с балансами и строки транзакций
+func writeStatementCSV(w io.Writer, statement entity.StatementResponse) error {
+	writer := csv.NewWriter(w)
+
+	records := [][]string{
+		{"period", statement.Period},
+		{"account_id", strconv.FormatUint(uint64(statement.AccountID), 10)},
+		{"currency", string(statement.Currency)},
+		{"opening_balance", statement.OpeningBalance.String()},
+		{"total_deposits", statement.TotalDeposits.String()},
+		{"total_withdrawals", statement.TotalWithdrawals.String()},
+		{"closing_balance", statement.ClosingBalance.String()},
+		{},
+		{"id", "created_at", "type", "status", "amount", "balance_after"},
+	}
+	for _, line := range statement.Transactions {
+		records = append(records, []string{
+			strconv.FormatUint(uint64(line.ID), 10),
+			line.CreatedAt.Format(time.RFC3339),
+			line.Type,
+			line.Status,
+			line.Amount.String(),
+			line.BalanceAfter.String(),
+		})
+	}
+
+	if err := writer.WriteAll(records); err != nil {
+		return fmt.Errorf("ошибка формирования CSV выписки: %w", err)
+	}
+	return nil
+}
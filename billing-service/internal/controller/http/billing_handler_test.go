@@ -0,0 +1,86 @@
+package http
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/director74/dz8_shop/billing-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// TestWriteStatementCSV тестирует заголовок с балансами и строки транзакций CSV выписки
+func TestWriteStatementCSV(t *testing.T) {
+	createdAt := time.Date(2026, time.March, 3, 12, 30, 0, 0, time.UTC)
+	statement := entity.StatementResponse{
+		AccountID:        7,
+		Period:           "2026-03",
+		Currency:         money.DefaultCurrency,
+		OpeningBalance:   80000,
+		ClosingBalance:   115000,
+		TotalDeposits:    50000,
+		TotalWithdrawals: 15000,
+		Transactions: []entity.StatementLine{
+			{
+				TransactionResponse: entity.TransactionResponse{
+					ID: 3, Amount: 50000, Type: entity.TransactionTypeDeposit, Status: entity.TransactionStatusSuccess, CreatedAt: createdAt,
+				},
+				BalanceAfter: 130000,
+			},
+			{
+				TransactionResponse: entity.TransactionResponse{
+					ID: 5, Amount: 15000, Type: entity.TransactionTypeWithdrawal, Status: entity.TransactionStatusSuccess, CreatedAt: createdAt.AddDate(0, 0, 17),
+				},
+				BalanceAfter: 115000,
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeStatementCSV(&buf, statement))
+
+	reader := csv.NewReader(&buf)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	assert.NoError(t, err)
+
+	// Пустая строка-разделитель пропускается при чтении CSV
+	assert.Equal(t, [][]string{
+		{"period", "2026-03"},
+		{"account_id", "7"},
+		{"currency", "RUB"},
+		{"opening_balance", "800.00"},
+		{"total_deposits", "500.00"},
+		{"total_withdrawals", "150.00"},
+		{"closing_balance", "1150.00"},
+		{"id", "created_at", "type", "status", "amount", "balance_after"},
+		{"3", "2026-03-03T12:30:00Z", "deposit", "success", "500.00", "1300.00"},
+		{"5", "2026-03-20T12:30:00Z", "withdrawal", "success", "150.00", "1150.00"},
+	}, records)
+}
+
+// TestWriteStatementCSV_NoTransactions тестирует выписку без транзакций: только заголовок
+func TestWriteStatementCSV_NoTransactions(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeStatementCSV(&buf, entity.StatementResponse{
+		AccountID:      1,
+		Period:         "2026-02",
+		Currency:       money.DefaultCurrency,
+		OpeningBalance: 50000,
+		ClosingBalance: 50000,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "period,2026-02\n"+
+		"account_id,1\n"+
+		"currency,RUB\n"+
+		"opening_balance,500.00\n"+
+		"total_deposits,0.00\n"+
+		"total_withdrawals,0.00\n"+
+		"closing_balance,500.00\n"+
+		"\n"+
+		"id,created_at,type,status,amount,balance_after\n", buf.String())
+}
@@ -0,0 +1,143 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/director74/dz8_shop/billing-service/internal/usecase"
+	"github.com/director74/dz8_shop/pkg/rabbitmq"
+	"github.com/director74/dz8_shop/pkg/sagahandler"
+)
+
+// CaptureConsumer обработчик шага capture_billing: списывает средства, заблокированные на шаге process_billing,
+// после резервирования товаров и доставки, а при компенсации возвращает их на счет
+type CaptureConsumer struct {
+	sagahandler.BaseSagaConsumer
+	billingUseCase *usecase.BillingUseCase
+}
+
+// NewCaptureConsumer создает обработчик шага списания заблокированных средств
+func NewCaptureConsumer(billingUseCase *usecase.BillingUseCase, rabbitMQ *rabbitmq.RabbitMQ, ledger *sagahandler.Ledger) *CaptureConsumer {
+	return &CaptureConsumer{
+		BaseSagaConsumer: sagahandler.BaseSagaConsumer{
+			RabbitMQ: rabbitMQ,
+			Logger:   log.New(log.Writer(), "[BillingService] [Saga] ", log.LstdFlags),
+			Step:     "capture_billing",
+			Ledger:   ledger,
+		},
+		billingUseCase: billingUseCase,
+	}
+}
+
+// Setup настраивает обработчик событий саги
+func (c *CaptureConsumer) Setup() error {
+	return c.SetupQueues(
+		"saga_exchange",                    // exchangeName
+		"billing_capture_queue",            // executeQueueName
+		"billing_capture_compensate_queue", // compensateQueueName
+		c.handleCaptureBilling,             // handleExecute
+		c.handleCompensateCapture,          // handleCompensate
+	)
+}
+
+// handleCaptureBilling списывает средства, заблокированные под заказ.
+// Если блокировки нет, шаг завершается ошибкой и сага компенсируется. Прочие ошибки возвращаются,
+// чтобы сообщение было доставлено повторно.
+func (c *CaptureConsumer) handleCaptureBilling(data []byte) error {
+	message, err := sagahandler.ParseSagaMessage(data)
+	if err != nil {
+		c.Logger.Printf("[ERROR] Ошибка парсинга сообщения execute: %v", err)
+		return err
+	}
+
+	c.Logger.Printf("SagaID=%s: Получено сообщение execute для шага %s", message.SagaID, message.StepName)
+
+	var sagaData sagahandler.SagaData
+	if err := json.Unmarshal(message.Data, &sagaData); err != nil {
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка десериализации данных: %v", message.SagaID, err)
+		return c.PublishFailureResult(message.SagaID,
+			fmt.Sprintf("ошибка десериализации данных заказа: %v", err))
+	}
+
+	reference := usecase.OrderReference(sagaData.OrderID)
+	transaction, err := c.billingUseCase.Capture(context.Background(), sagaData.UserID, reference)
+	if err != nil {
+		if errors.Is(err, usecase.ErrHoldNotFound) {
+			c.Logger.Printf("[ERROR] SagaID=%s: Нет заблокированных средств для списания по %s", message.SagaID, reference)
+			return c.PublishFailureResultWithData(message.SagaID,
+				fmt.Sprintf("ошибка списания средств: %v", err), message.Data)
+		}
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка списания средств по %s: %v", message.SagaID, reference, err)
+		return err
+	}
+
+	c.Logger.Printf("SagaID=%s: Списание средств для UserID=%d выполнено успешно. TransactionID=%d",
+		message.SagaID, sagaData.UserID, transaction.ID)
+
+	if sagaData.BillingInfo == nil {
+		sagaData.BillingInfo = &sagahandler.BillingInfo{}
+	}
+	sagaData.Status = "billing_processed"
+	sagaData.BillingInfo.TransactionID = fmt.Sprintf("%d", transaction.ID)
+	sagaData.BillingInfo.Amount = transaction.Amount
+	sagaData.BillingInfo.Currency = transaction.Currency
+	sagaData.BillingInfo.Status = transaction.Status
+
+	updatedData, err := json.Marshal(sagaData)
+	if err != nil {
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка сериализации данных после успешного списания: %v", message.SagaID, err)
+		return c.PublishFailureResult(message.SagaID,
+			fmt.Sprintf("ошибка сериализации обновленных данных: %v", err))
+	}
+
+	c.Logger.Printf("SagaID=%s: Отправка успешного результата шага %s", message.SagaID, c.Step)
+	return c.PublishSuccessResult(message.SagaID, updatedData)
+}
+
+// handleCompensateCapture возвращает на счет средства, списанные на шаге capture_billing.
+// Повторная компенсация не возвращает средства второй раз.
+func (c *CaptureConsumer) handleCompensateCapture(data []byte) error {
+	message, err := sagahandler.ParseSagaMessage(data)
+	if err != nil {
+		c.Logger.Printf("[ERROR] Ошибка парсинга сообщения compensate: %v", err)
+		return err
+	}
+
+	c.Logger.Printf("SagaID=%s: Получено сообщение compensate для шага %s", message.SagaID, message.StepName)
+
+	var sagaData sagahandler.SagaData
+	if err := json.Unmarshal(message.Data, &sagaData); err != nil {
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка десериализации данных компенсации: %v", message.SagaID, err)
+		return err
+	}
+
+	if sagaData.BillingInfo == nil || sagaData.BillingInfo.TransactionID == "" {
+		c.Logger.Printf("[WARN] SagaID=%s: Нет данных о списании для компенсации (OrderID=%d). Считаем компенсацию выполненной.",
+			message.SagaID, sagaData.OrderID)
+	} else {
+		reference := usecase.OrderReference(sagaData.OrderID)
+		// Возвращается сумма, фактически списанная со счета, в валюте счета
+		_, err := c.billingUseCase.Refund(context.Background(), sagaData.UserID, sagaData.BillingInfo.Amount, sagaData.BillingInfo.Currency, reference)
+		if err != nil {
+			c.Logger.Printf("[ERROR] SagaID=%s: Ошибка возврата средств для UserID=%d, Amount=%s (%s): %v",
+				message.SagaID, sagaData.UserID, sagaData.BillingInfo.Amount, reference, err)
+			return err
+		}
+		c.Logger.Printf("SagaID=%s: Возврат средств для UserID=%d выполнен успешно (компенсация списания %s)",
+			message.SagaID, sagaData.UserID, sagaData.BillingInfo.TransactionID)
+	}
+
+	sagaData.Status = "billing_compensation_completed"
+
+	updatedData, err := json.Marshal(sagaData)
+	if err != nil {
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка сериализации данных после компенсации: %v", message.SagaID, err)
+		return err
+	}
+
+	c.Logger.Printf("SagaID=%s: Отправка результата compensate/compensated шага %s", message.SagaID, c.Step)
+	return c.PublishCompensationResult(message.SagaID, updatedData)
+}
@@ -6,11 +6,15 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/director74/dz8_shop/billing-service/internal/entity"
 	"github.com/director74/dz8_shop/billing-service/internal/usecase"
 	"github.com/director74/dz8_shop/pkg/rabbitmq"
 	"github.com/director74/dz8_shop/pkg/sagahandler"
 )
 
+// billingStatusHeld статус оплаты в данных саги: средства заблокированы и ждут списания
+const billingStatusHeld = "held"
+
 // SagaConsumer обработчик сообщений саги для биллинга
 type SagaConsumer struct {
 	sagahandler.BaseSagaConsumer
@@ -18,12 +22,13 @@ type SagaConsumer struct {
 }
 
 // NewSagaConsumer создает новый обработчик сообщений саги для биллинга
-func NewSagaConsumer(billingUseCase *usecase.BillingUseCase, rabbitMQ *rabbitmq.RabbitMQ) *SagaConsumer {
+func NewSagaConsumer(billingUseCase *usecase.BillingUseCase, rabbitMQ *rabbitmq.RabbitMQ, ledger *sagahandler.Ledger) *SagaConsumer {
 	return &SagaConsumer{
 		BaseSagaConsumer: sagahandler.BaseSagaConsumer{
 			RabbitMQ: rabbitMQ,
 			Logger:   log.New(log.Writer(), "[BillingService] [Saga] ", log.LstdFlags),
 			Step:     "process_billing",
+			Ledger:   ledger,
 		},
 		billingUseCase: billingUseCase,
 	}
@@ -40,7 +45,7 @@ func (c *SagaConsumer) Setup() error {
 	)
 }
 
-// handleProcessBilling обрабатывает сообщение для проведения платежа
+// handleProcessBilling блокирует на счете пользователя сумму заказа
 func (c *SagaConsumer) handleProcessBilling(data []byte) error {
 	message, err := sagahandler.ParseSagaMessage(data)
 	if err != nil {
@@ -61,35 +66,39 @@ func (c *SagaConsumer) handleProcessBilling(data []byte) error {
 		sagaData.CompensatedSteps = make(map[string]bool)
 	}
 
-	c.Logger.Printf("SagaID=%s: Обработка биллинга для OrderID=%d, UserID=%d, Amount=%.2f",
-		message.SagaID, sagaData.OrderID, sagaData.UserID, sagaData.Amount)
+	c.Logger.Printf("SagaID=%s: Обработка биллинга для OrderID=%d, UserID=%d, Amount=%s %s",
+		message.SagaID, sagaData.OrderID, sagaData.UserID, sagaData.Amount, sagaData.Currency.OrDefault())
 
 	if sagaData.Amount <= 0 {
-		c.Logger.Printf("[ERROR] SagaID=%s: Некорректная сумма заказа (<= 0): %.2f", message.SagaID, sagaData.Amount)
+		c.Logger.Printf("[ERROR] SagaID=%s: Некорректная сумма заказа (<= 0): %s", message.SagaID, sagaData.Amount)
 		return c.PublishFailureResultWithData(message.SagaID,
 			"сумма заказа должна быть больше нуля", message.Data)
 	}
 
-	transaction, err := c.billingUseCase.Withdraw(context.Background(), sagaData.UserID, sagaData.Amount, "")
+	// Сумма заказа только блокируется на счете в валюте счета; списание выполняется на шаге capture_billing
+	hold, err := c.billingUseCase.Hold(context.Background(), sagaData.UserID, sagaData.Amount, sagaData.Currency.OrDefault(), usecase.OrderReference(sagaData.OrderID))
 	if err != nil {
-		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка вызова Withdraw для UserID=%d: %v", message.SagaID, sagaData.UserID, err)
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка блокировки средств для UserID=%d: %v", message.SagaID, sagaData.UserID, err)
 		return c.PublishFailureResultWithData(message.SagaID,
-			fmt.Sprintf("ошибка списания средств: %v", err), message.Data)
+			fmt.Sprintf("ошибка блокировки средств: %v", err), message.Data)
+	}
+
+	if sagaData.BillingInfo == nil {
+		sagaData.BillingInfo = &sagahandler.BillingInfo{}
 	}
+	sagaData.BillingInfo.Amount = hold.Amount
+	sagaData.BillingInfo.Currency = hold.Currency
+	sagaData.BillingInfo.ExchangeRate = hold.ExchangeRate
 
-	if !transaction.Success {
-		c.Logger.Printf("[WARN] SagaID=%s: Списание средств не выполнено для UserID=%d (недостаточно средств?) TransactionID=%d",
-			message.SagaID, sagaData.UserID, transaction.Transaction.ID)
+	if !hold.Success {
+		c.Logger.Printf("[WARN] SagaID=%s: Недостаточно средств для блокировки у UserID=%d, TransactionID=%d",
+			message.SagaID, sagaData.UserID, hold.TransactionID)
 		sagaData.Status = "billing_failed"
-		if sagaData.BillingInfo == nil {
-			sagaData.BillingInfo = &sagahandler.BillingInfo{}
-		}
-		sagaData.BillingInfo.TransactionID = fmt.Sprintf("%d", transaction.Transaction.ID)
-		sagaData.BillingInfo.Amount = transaction.Transaction.Amount
-		sagaData.BillingInfo.Status = transaction.Transaction.Status
+		sagaData.BillingInfo.TransactionID = fmt.Sprintf("%d", hold.TransactionID)
+		sagaData.BillingInfo.Status = entity.TransactionStatusFailed
 		updatedData, err := json.Marshal(sagaData)
 		if err != nil {
-			c.Logger.Printf("[ERROR] SagaID=%s: Ошибка сериализации данных после неудачного списания: %v", message.SagaID, err)
+			c.Logger.Printf("[ERROR] SagaID=%s: Ошибка сериализации данных после неудачной блокировки: %v", message.SagaID, err)
 			return c.PublishFailureResultWithData(message.SagaID,
 				fmt.Sprintf("недостаточно средств на счете пользователя %d", sagaData.UserID), message.Data)
 		}
@@ -97,20 +106,14 @@ func (c *SagaConsumer) handleProcessBilling(data []byte) error {
 			fmt.Sprintf("недостаточно средств на счете пользователя %d", sagaData.UserID), updatedData)
 	}
 
-	c.Logger.Printf("SagaID=%s: Списание средств для UserID=%d выполнено успешно. TransactionID=%d",
-		message.SagaID, sagaData.UserID, transaction.Transaction.ID)
+	c.Logger.Printf("SagaID=%s: Средства UserID=%d заблокированы: %s %s", message.SagaID, sagaData.UserID, hold.Amount, hold.Currency)
 
-	if sagaData.BillingInfo == nil {
-		sagaData.BillingInfo = &sagahandler.BillingInfo{}
-	}
-	sagaData.Status = "billing_processed"
-	sagaData.BillingInfo.TransactionID = fmt.Sprintf("%d", transaction.Transaction.ID)
-	sagaData.BillingInfo.Amount = transaction.Transaction.Amount
-	sagaData.BillingInfo.Status = transaction.Transaction.Status
+	sagaData.Status = "billing_held"
+	sagaData.BillingInfo.Status = billingStatusHeld
 
 	updatedData, err := json.Marshal(sagaData)
 	if err != nil {
-		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка сериализации данных после успешного списания: %v", message.SagaID, err)
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка сериализации данных после блокировки средств: %v", message.SagaID, err)
 		return c.PublishFailureResult(message.SagaID,
 			fmt.Sprintf("ошибка сериализации обновленных данных: %v", err))
 	}
@@ -119,7 +122,7 @@ func (c *SagaConsumer) handleProcessBilling(data []byte) error {
 	return c.PublishSuccessResult(message.SagaID, updatedData)
 }
 
-// handleCompensateBilling обрабатывает сообщение для компенсации платежа
+// handleCompensateBilling снимает блокировку средств заказа
 func (c *SagaConsumer) handleCompensateBilling(data []byte) error {
 	message, err := sagahandler.ParseSagaMessage(data)
 	if err != nil {
@@ -143,28 +146,14 @@ func (c *SagaConsumer) handleCompensateBilling(data []byte) error {
 	c.Logger.Printf("SagaID=%s: Обработка компенсации биллинга для OrderID=%d, UserID=%d",
 		message.SagaID, sagaData.OrderID, sagaData.UserID)
 
-	if sagaData.BillingInfo == nil || sagaData.BillingInfo.TransactionID == "" {
-		c.Logger.Printf("[WARN] SagaID=%s: Нет данных о транзакции для компенсации (OrderID=%d). Считаем компенсацию выполненной.",
-			message.SagaID, sagaData.OrderID)
-		sagaData.Status = "billing_compensation_completed"
-		updatedData, err := json.Marshal(sagaData)
-		if err != nil {
-			c.Logger.Printf("[ERROR] SagaID=%s: Ошибка сериализации данных после компенсации (нет транзакции): %v", message.SagaID, err)
-			return err
-		}
-		return c.PublishCompensationResult(message.SagaID, updatedData)
-	}
-
-	amount := sagaData.BillingInfo.Amount
-	transactionID := sagaData.BillingInfo.TransactionID
-	_, err = c.billingUseCase.Deposit(context.Background(), sagaData.UserID, amount, "")
-	if err != nil {
-		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка возврата средств (Deposit) для UserID=%d, Amount=%.2f (исходная транзакция: %s): %v",
-			message.SagaID, sagaData.UserID, amount, transactionID, err)
-	} else {
-		c.Logger.Printf("SagaID=%s: Возврат средств для UserID=%d выполнен успешно (компенсация транзакции %s)",
-			message.SagaID, sagaData.UserID, transactionID)
+	// Средства, уже списанные на шаге capture_billing, возвращаются компенсацией этого шага
+	reference := usecase.OrderReference(sagaData.OrderID)
+	if err := c.billingUseCase.Release(context.Background(), sagaData.UserID, reference); err != nil {
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка снятия блокировки средств для UserID=%d (%s): %v",
+			message.SagaID, sagaData.UserID, reference, err)
+		return err
 	}
+	c.Logger.Printf("SagaID=%s: Блокировка средств UserID=%d по %s снята", message.SagaID, sagaData.UserID, reference)
 
 	sagaData.Status = "billing_compensation_completed"
 
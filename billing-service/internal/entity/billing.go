@@ -2,34 +2,46 @@ package entity
 
 import (
 	"time"
+
+	"github.com/director74/dz8_shop/pkg/money"
 )
 
 // Account хранит информацию о финансовом аккаунте пользователя и его балансе
 type Account struct {
-	ID        uint       `json:"id" gorm:"primaryKey"`
-	UserID    uint       `json:"user_id" gorm:"column:user_id;type:integer;not null"`
-	Balance   float64    `json:"balance" gorm:"type:decimal(12,2);not null;default:0"`
-	CreatedAt time.Time  `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
-	UpdatedAt time.Time  `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
-	DeletedAt *time.Time `json:"deleted_at" gorm:"index"`
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	UserID    uint           `json:"user_id" gorm:"column:user_id;type:integer;not null"`
+	Balance   money.Amount   `json:"balance" gorm:"type:decimal(12,2);not null;default:0"`
+	Held      money.Amount   `json:"held" gorm:"type:decimal(12,2);not null;default:0"` // Часть баланса, заблокированная под заказы
+	Currency  money.Currency `json:"currency" gorm:"type:varchar(3);not null;default:'RUB'"`
+	CreatedAt time.Time      `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time      `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	DeletedAt *time.Time     `json:"deleted_at" gorm:"index"`
 }
 
-// Transaction содержит запись о движении средств с типами deposit или withdrawal
+// Transaction содержит запись о движении средств с типами deposit, withdrawal или refund.
+// Успешная транзакция ссылается на операцию главной книги, в которой она проведена.
 type Transaction struct {
-	ID        uint       `json:"id" gorm:"primaryKey"`
-	AccountID uint       `json:"account_id" gorm:"index:idx_transactions_account_id"`
-	Amount    float64    `json:"amount" gorm:"type:decimal(12,2);not null"`
-	Type      string     `json:"type" gorm:"index:idx_transactions_type;type:varchar(20);not null"`     // deposit, withdrawal
-	Status    string     `json:"status" gorm:"index:idx_transactions_status;type:varchar(20);not null"` // success, failed
-	CreatedAt time.Time  `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
-	UpdatedAt time.Time  `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
-	DeletedAt *time.Time `json:"deleted_at" gorm:"index"`
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	AccountID      uint           `json:"account_id" gorm:"index:idx_transactions_account_id"`
+	Amount         money.Amount   `json:"amount" gorm:"type:decimal(12,2);not null"`
+	Currency       money.Currency `json:"currency" gorm:"type:varchar(3);not null;default:'RUB'"`                // Валюта счета
+	Type           string         `json:"type" gorm:"index:idx_transactions_type;type:varchar(20);not null"`     // deposit, withdrawal, refund
+	Status         string         `json:"status" gorm:"index:idx_transactions_status;type:varchar(20);not null"` // success, failed
+	JournalEntryID *uint          `json:"journal_entry_id,omitempty"`
+	// Сумма и валюта заказа и курс, по которому она переведена в валюту счета, если валюты различаются
+	OriginalAmount   money.Amount   `json:"original_amount,omitempty" gorm:"type:decimal(12,2);not null;default:0"`
+	OriginalCurrency money.Currency `json:"original_currency,omitempty" gorm:"type:varchar(3);not null;default:''"`
+	ExchangeRate     string         `json:"exchange_rate,omitempty" gorm:"type:varchar(32);not null;default:''"`
+	CreatedAt        time.Time      `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt        time.Time      `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	DeletedAt        *time.Time     `json:"deleted_at" gorm:"index"`
 }
 
 // Типы транзакций
 const (
 	TransactionTypeDeposit    = "deposit"
 	TransactionTypeWithdrawal = "withdrawal"
+	TransactionTypeRefund     = "refund"
 )
 
 // Статусы транзакций
@@ -39,39 +51,50 @@ const (
 )
 
 type CreateAccountRequest struct {
-	UserID uint `json:"user_id" binding:"required"`
+	UserID   uint           `json:"user_id" binding:"required"`
+	Currency money.Currency `json:"currency" binding:"omitempty,len=3"` // Валюта счета, по умолчанию RUB
 }
 
 type CreateAccountResponse struct {
-	ID      uint    `json:"id"`
-	UserID  uint    `json:"user_id"`
-	Balance float64 `json:"balance"`
+	ID       uint           `json:"id"`
+	UserID   uint           `json:"user_id"`
+	Balance  money.Amount   `json:"balance"`
+	Currency money.Currency `json:"currency"`
 }
 
 type GetAccountResponse struct {
-	ID        uint      `json:"id"`
-	UserID    uint      `json:"user_id"`
-	Balance   float64   `json:"balance"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        uint           `json:"id"`
+	UserID    uint           `json:"user_id"`
+	Balance   money.Amount   `json:"balance"`
+	Held      money.Amount   `json:"held"`      // Заблокировано под заказы
+	Available money.Amount   `json:"available"` // Доступно для списания
+	Currency  money.Currency `json:"currency"`
+	CreatedAt time.Time      `json:"created_at"`
 }
 
 type DepositRequest struct {
-	Amount float64 `json:"amount" binding:"required,gt=0"`
-	Email  string  `json:"email" binding:"omitempty,email"`
+	Amount   money.Amount   `json:"amount" binding:"required,gt=0"`
+	Currency money.Currency `json:"currency" binding:"omitempty,len=3"` // Должна совпадать с валютой счета
+	Email    string         `json:"email" binding:"omitempty,email"`
 }
 
 type WithdrawRequest struct {
-	Amount float64 `json:"amount" binding:"required,gt=0"`
-	Email  string  `json:"email" binding:"omitempty,email"`
+	Amount   money.Amount   `json:"amount" binding:"required,gt=0"`
+	Currency money.Currency `json:"currency" binding:"omitempty,len=3"` // Должна совпадать с валютой счета
+	Email    string         `json:"email" binding:"omitempty,email"`
 }
 
 type TransactionResponse struct {
-	ID        uint      `json:"id"`
-	AccountID uint      `json:"account_id"`
-	Amount    float64   `json:"amount"`
-	Type      string    `json:"type"`
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"created_at"`
+	ID               uint           `json:"id"`
+	AccountID        uint           `json:"account_id"`
+	Amount           money.Amount   `json:"amount"`
+	Currency         money.Currency `json:"currency"`
+	OriginalAmount   money.Amount   `json:"original_amount,omitempty"`
+	OriginalCurrency money.Currency `json:"original_currency,omitempty"`
+	ExchangeRate     string         `json:"exchange_rate,omitempty"`
+	Type             string         `json:"type"`
+	Status           string         `json:"status"`
+	CreatedAt        time.Time      `json:"created_at"`
 }
 
 type WithdrawResponse struct {
@@ -83,3 +106,54 @@ type DepositResponse struct {
 	Transaction TransactionResponse `json:"transaction"`
 	Success     bool                `json:"success"`
 }
+
+// TransactionFilter параметры выборки транзакций аккаунта
+type TransactionFilter struct {
+	AccountID uint
+	Type      string
+	Status    string
+	From      time.Time // Начало периода включительно, нулевое значение - без ограничения
+	To        time.Time // Конец периода не включительно, нулевое значение - без ограничения
+	Limit     int       // 0 - без ограничения
+	Offset    int
+	Ascending bool // Хронологический порядок вместо обратного
+}
+
+// ListTransactionsQuery параметры запроса истории транзакций
+type ListTransactionsQuery struct {
+	Type   string    `form:"type" binding:"omitempty,oneof=deposit withdrawal refund"`
+	Status string    `form:"status" binding:"omitempty,oneof=success failed"`
+	From   time.Time `form:"from" time_format:"2006-01-02"` // Дата начала периода включительно
+	To     time.Time `form:"to" time_format:"2006-01-02"`   // Дата окончания периода включительно
+	Limit  int       `form:"limit" binding:"omitempty,min=1"`
+	Offset int       `form:"offset" binding:"omitempty,min=0"`
+}
+
+// ListTransactionsResponse страница истории транзакций
+type ListTransactionsResponse struct {
+	Transactions []TransactionResponse `json:"transactions"`
+	Total        int64                 `json:"total"`
+	Limit        int                   `json:"limit"`
+	Offset       int                   `json:"offset"`
+}
+
+// StatementLine строка выписки: транзакция и баланс после нее
+type StatementLine struct {
+	TransactionResponse
+	BalanceAfter money.Amount `json:"balance_after"`
+}
+
+// StatementResponse выписка по аккаунту за месяц
+type StatementResponse struct {
+	AccountID        uint            `json:"account_id"`
+	UserID           uint            `json:"user_id"`
+	Period           string          `json:"period"` // Месяц выписки в формате YYYY-MM
+	Currency         money.Currency  `json:"currency"`
+	From             time.Time       `json:"from"`
+	To               time.Time       `json:"to"`
+	OpeningBalance   money.Amount    `json:"opening_balance"`
+	ClosingBalance   money.Amount    `json:"closing_balance"`
+	TotalDeposits    money.Amount    `json:"total_deposits"`
+	TotalWithdrawals money.Amount    `json:"total_withdrawals"`
+	Transactions     []StatementLine `json:"transactions"`
+}
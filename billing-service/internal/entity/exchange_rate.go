@@ -0,0 +1,22 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// ExchangeRate курс обмена валют, по которому биллинг переводит сумму заказа в валюту счета
+type ExchangeRate struct {
+	BaseCurrency  money.Currency `json:"base_currency" gorm:"primaryKey;type:varchar(3)"`
+	QuoteCurrency money.Currency `json:"quote_currency" gorm:"primaryKey;type:varchar(3)"`
+	Rate          string         `json:"rate" gorm:"type:varchar(32);not null"` // Единиц QuoteCurrency за единицу BaseCurrency
+	UpdatedAt     time.Time      `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+// SetExchangeRateRequest запрос на установку курса обмена
+type SetExchangeRateRequest struct {
+	From money.Currency `json:"from" binding:"required,len=3"`
+	To   money.Currency `json:"to" binding:"required,len=3"`
+	Rate string         `json:"rate" binding:"required"`
+}
@@ -0,0 +1,141 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// LedgerAccountKind вид счета главной книги
+type LedgerAccountKind string
+
+const (
+	LedgerAccountCustomerAvailable LedgerAccountKind = "customer_available" // Доступные средства клиента
+	LedgerAccountCustomerHeld      LedgerAccountKind = "customer_held"      // Средства клиента, заблокированные под заказ
+	LedgerAccountExternal          LedgerAccountKind = "external"           // Внешние поступления (пополнения)
+	LedgerAccountSales             LedgerAccountKind = "sales"              // Выручка от оплаченных заказов
+	LedgerAccountEquity            LedgerAccountKind = "equity"             // Входящие остатки, перенесенные при запуске книги
+)
+
+// Префиксы кодов системных счетов главной книги. Системные счета ведутся в каждой валюте отдельно,
+// код счета - префикс и код валюты, например system:sales:RUB.
+const (
+	LedgerCodeExternal       = "system:external"
+	LedgerCodeSales          = "system:sales"
+	LedgerCodeOpeningBalance = "system:opening_balance"
+)
+
+// PostingDirection сторона проводки
+type PostingDirection string
+
+const (
+	PostingDebit  PostingDirection = "debit"
+	PostingCredit PostingDirection = "credit"
+)
+
+// JournalEntryType тип операции в журнале
+type JournalEntryType string
+
+const (
+	JournalEntryDeposit        JournalEntryType = "deposit"         // Пополнение: external -> available
+	JournalEntryWithdrawal     JournalEntryType = "withdrawal"      // Списание: available -> sales
+	JournalEntryRefund         JournalEntryType = "refund"          // Возврат списания: sales -> available
+	JournalEntryHold           JournalEntryType = "hold"            // Блокировка под заказ: available -> held
+	JournalEntryCapture        JournalEntryType = "capture"         // Списание заблокированных средств: held -> sales
+	JournalEntryRelease        JournalEntryType = "release"         // Снятие блокировки: held -> available
+	JournalEntryOpeningBalance JournalEntryType = "opening_balance" // Перенос баланса, накопленного до запуска книги
+)
+
+// LedgerAccount счет главной книги. Клиентские счета привязаны к аккаунту биллинга,
+// их баланс - обязательство перед клиентом: кредит увеличивает его, дебет уменьшает.
+type LedgerAccount struct {
+	ID        uint              `json:"id" gorm:"primaryKey"`
+	Code      string            `json:"code" gorm:"type:varchar(100);not null;uniqueIndex"`
+	Kind      LedgerAccountKind `json:"kind" gorm:"type:varchar(30);not null"`
+	AccountID *uint             `json:"account_id,omitempty" gorm:"index"`
+	Currency  money.Currency    `json:"currency" gorm:"type:varchar(3);not null;default:'RUB'"`
+	CreatedAt time.Time         `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+// JournalEntry операция в журнале двойной записи. Сумма дебетовых проводок операции равна сумме кредитовых.
+type JournalEntry struct {
+	ID          uint             `json:"id" gorm:"primaryKey"`
+	Type        JournalEntryType `json:"type" gorm:"type:varchar(30);not null"`
+	Reference   string           `json:"reference" gorm:"type:varchar(255);index"` // Внешний идентификатор операции (заказ, исходная транзакция)
+	Description string           `json:"description" gorm:"type:text"`
+	Postings    []Posting        `json:"postings" gorm:"foreignKey:JournalEntryID"`
+	CreatedAt   time.Time        `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+// Posting проводка по счету главной книги. Сумма всегда положительна, направление задает Direction.
+type Posting struct {
+	ID              uint             `json:"id" gorm:"primaryKey"`
+	JournalEntryID  uint             `json:"journal_entry_id" gorm:"not null;index"`
+	LedgerAccountID uint             `json:"ledger_account_id" gorm:"not null;index"`
+	Direction       PostingDirection `json:"direction" gorm:"type:varchar(10);not null"`
+	Amount          money.Amount     `json:"amount" gorm:"type:decimal(12,2);not null"`
+	CreatedAt       time.Time        `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+// HoldResponse результат блокировки средств под операцию
+type HoldResponse struct {
+	Reference    string         `json:"reference"`
+	Amount       money.Amount   `json:"amount"` // Заблокированная сумма в валюте счета
+	Currency     money.Currency `json:"currency"`
+	ExchangeRate string         `json:"exchange_rate,omitempty"` // Курс валюты операции к валюте счета, если они различаются
+	// Неуспешная транзакция списания, сохраненная при недостатке средств
+	TransactionID uint `json:"transaction_id,omitempty"`
+	Success       bool `json:"success"`
+}
+
+// LedgerLeg сторона операции для проводки: счет и сумма
+type LedgerLeg struct {
+	Code      string
+	Kind      LedgerAccountKind
+	AccountID *uint
+	Currency  money.Currency
+	Direction PostingDirection
+	Amount    money.Amount
+}
+
+// CustomerLedgerBalance остатки клиентских счетов аккаунта биллинга, рассчитанные по проводкам
+type CustomerLedgerBalance struct {
+	AccountID uint
+	Available money.Amount
+	Held      money.Amount
+}
+
+// BalanceDrift расхождение баланса аккаунта с главной книгой
+type BalanceDrift struct {
+	AccountID     uint         `json:"account_id"`
+	UserID        uint         `json:"user_id"`
+	StoredBalance money.Amount `json:"stored_balance"` // Баланс в таблице accounts
+	LedgerBalance money.Amount `json:"ledger_balance"` // Остаток клиентских счетов по проводкам (доступные и заблокированные)
+	StoredHeld    money.Amount `json:"stored_held"`    // Заблокированная сумма в таблице accounts
+	LedgerHeld    money.Amount `json:"ledger_held"`    // Остаток счета блокировок по проводкам
+	Difference    money.Amount `json:"difference"`     // Расхождение баланса: stored_balance - ledger_balance
+}
+
+// UnbalancedEntry операция журнала, у которой дебет не равен кредиту
+type UnbalancedEntry struct {
+	JournalEntryID uint         `json:"journal_entry_id"`
+	Debit          money.Amount `json:"debit"`
+	Credit         money.Amount `json:"credit"`
+}
+
+// LedgerTotal обороты главной книги в одной валюте
+type LedgerTotal struct {
+	Currency money.Currency `json:"currency"`
+	Debit    money.Amount   `json:"debit"`
+	Credit   money.Amount   `json:"credit"`
+}
+
+// ReconciliationReport результат сверки балансов аккаунтов с главной книгой
+type ReconciliationReport struct {
+	CheckedAt         time.Time         `json:"checked_at"`
+	AccountsChecked   int               `json:"accounts_checked"`
+	Totals            []LedgerTotal     `json:"totals"` // Обороты по валютам: дебет каждой валюты равен кредиту
+	Drifts            []BalanceDrift    `json:"drifts"`
+	UnbalancedEntries []UnbalancedEntry `json:"unbalanced_entries"`
+	Consistent        bool              `json:"consistent"`
+}
@@ -2,10 +2,14 @@ package repo
 
 import (
 	"context"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/director74/dz8_shop/billing-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/database"
+	"github.com/director74/dz8_shop/pkg/money"
 )
 
 // BillingRepository представляет репозиторий для работы с биллингом
@@ -20,30 +24,51 @@ func NewBillingRepository(db *gorm.DB) *BillingRepository {
 }
 
 func (r *BillingRepository) CreateAccount(ctx context.Context, account entity.Account) (entity.Account, error) {
-	err := r.db.WithContext(ctx).Create(&account).Error
+	err := r.conn(ctx).Create(&account).Error
 	return account, err
 }
 
 func (r *BillingRepository) GetAccountByUserID(ctx context.Context, userID uint) (entity.Account, error) {
 	var account entity.Account
-	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&account).Error
+	err := r.conn(ctx).Where("user_id = ?", userID).First(&account).Error
 	return account, err
 }
 
+// LockAccountByUserID получает аккаунт пользователя с блокировкой строки до конца транзакции.
+// Используется для проверки доступных средств перед списанием или блокировкой.
+func (r *BillingRepository) LockAccountByUserID(ctx context.Context, userID uint) (entity.Account, error) {
+	var account entity.Account
+	err := r.conn(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&account).Error
+	return account, err
+}
+
+// ListAccounts возвращает все аккаунты
+func (r *BillingRepository) ListAccounts(ctx context.Context) ([]entity.Account, error) {
+	var accounts []entity.Account
+	err := r.conn(ctx).Order("id").Find(&accounts).Error
+	return accounts, err
+}
+
+// UpdateHeld изменяет сумму, заблокированную под заказы
+func (r *BillingRepository) UpdateHeld(ctx context.Context, accountID uint, amount money.Amount) error {
+	return r.conn(ctx).Model(&entity.Account{}).Where("id = ?", accountID).
+		Update("held", gorm.Expr("held + ?", amount)).Error
+}
+
 // UpdateBalance обновляет баланс аккаунта
-func (r *BillingRepository) UpdateBalance(ctx context.Context, accountID uint, amount float64) error {
-	return r.db.WithContext(ctx).Model(&entity.Account{}).Where("id = ?", accountID).
+func (r *BillingRepository) UpdateBalance(ctx context.Context, accountID uint, amount money.Amount) error {
+	return r.conn(ctx).Model(&entity.Account{}).Where("id = ?", accountID).
 		Update("balance", gorm.Expr("balance + ?", amount)).Error
 }
 
 func (r *BillingRepository) CreateTransaction(ctx context.Context, transaction entity.Transaction) (entity.Transaction, error) {
-	err := r.db.WithContext(ctx).Create(&transaction).Error
+	err := r.conn(ctx).Create(&transaction).Error
 	return transaction, err
 }
 
 func (r *BillingRepository) GetTransactionByID(ctx context.Context, id uint) (entity.Transaction, error) {
 	var transaction entity.Transaction
-	err := r.db.WithContext(ctx).Where("id = ?", id).First(&transaction).Error
+	err := r.conn(ctx).Where("id = ?", id).First(&transaction).Error
 	return transaction, err
 }
 
@@ -51,15 +76,69 @@ func (r *BillingRepository) ListTransactionsByAccountID(ctx context.Context, acc
 	var transactions []entity.Transaction
 	var total int64
 
-	r.db.WithContext(ctx).Model(&entity.Transaction{}).Where("account_id = ?", accountID).Count(&total)
-	err := r.db.WithContext(ctx).Where("account_id = ?", accountID).Limit(limit).Offset(offset).Order("created_at DESC").Find(&transactions).Error
+	r.conn(ctx).Model(&entity.Transaction{}).Where("account_id = ?", accountID).Count(&total)
+	err := r.conn(ctx).Where("account_id = ?", accountID).Limit(limit).Offset(offset).Order("created_at DESC").Find(&transactions).Error
 
 	return transactions, total, err
 }
 
-// WithTransaction выполняет функцию в транзакции базы данных
-func (r *BillingRepository) WithTransaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
-	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		return fn(tx)
-	})
+// ListTransactions возвращает транзакции аккаунта по фильтру и их общее количество
+func (r *BillingRepository) ListTransactions(ctx context.Context, filter entity.TransactionFilter) ([]entity.Transaction, int64, error) {
+	query := r.conn(ctx).Model(&entity.Transaction{}).Where("account_id = ?", filter.AccountID)
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	order := "created_at DESC, id DESC"
+	if filter.Ascending {
+		order = "created_at ASC, id ASC"
+	}
+	query = query.Order(order).Offset(filter.Offset)
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var transactions []entity.Transaction
+	if err := query.Find(&transactions).Error; err != nil {
+		return nil, 0, err
+	}
+	return transactions, total, nil
+}
+
+// SumBalanceChangesSince возвращает сумму изменений баланса аккаунта начиная с момента since.
+// Неуспешные транзакции баланс не меняют и не учитываются.
+func (r *BillingRepository) SumBalanceChangesSince(ctx context.Context, accountID uint, since time.Time) (money.Amount, error) {
+	var result struct {
+		Sum money.Amount
+	}
+	err := r.conn(ctx).Model(&entity.Transaction{}).
+		Select("COALESCE(SUM(amount), 0) AS sum").
+		Where("account_id = ? AND status <> ? AND created_at >= ?", accountID, entity.TransactionStatusFailed, since).
+		Scan(&result).Error
+	return result.Sum, err
+}
+
+// WithTransaction выполняет функцию в транзакции базы данных.
+// Методы репозитория, вызванные с контекстом fn, выполняются в этой транзакции.
+func (r *BillingRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return database.WithTransaction(ctx, r.db, fn)
+}
+
+// conn возвращает соединение с учетом транзакции, открытой через WithTransaction
+func (r *BillingRepository) conn(ctx context.Context) *gorm.DB {
+	return database.Conn(ctx, r.db)
 }
@@ -0,0 +1,121 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/director74/dz8_shop/billing-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/database"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// ExchangeRateRepository локальный источник курсов обмена: курсы хранятся в таблице exchange_rates
+// и могут быть загружены из файла при запуске сервиса
+type ExchangeRateRepository struct {
+	db *gorm.DB
+}
+
+func NewExchangeRateRepository(db *gorm.DB) *ExchangeRateRepository {
+	return &ExchangeRateRepository{
+		db: db,
+	}
+}
+
+// Rate возвращает курс обмена from -> to. Если задан только обратный курс, используется он.
+func (r *ExchangeRateRepository) Rate(ctx context.Context, from, to money.Currency) (money.ExchangeRate, error) {
+	if from == to {
+		return money.Identity(from), nil
+	}
+
+	var rates []entity.ExchangeRate
+	err := r.conn(ctx).
+		Where("(base_currency = ? AND quote_currency = ?) OR (base_currency = ? AND quote_currency = ?)", from, to, to, from).
+		Find(&rates).Error
+	if err != nil {
+		return money.ExchangeRate{}, err
+	}
+
+	var inverse *entity.ExchangeRate
+	for i := range rates {
+		if rates[i].BaseCurrency == from {
+			return money.NewExchangeRate(from, to, rates[i].Rate)
+		}
+		inverse = &rates[i]
+	}
+	if inverse != nil {
+		rate, err := money.NewExchangeRate(to, from, inverse.Rate)
+		if err != nil {
+			return money.ExchangeRate{}, err
+		}
+		return rate.Inverse()
+	}
+	return money.ExchangeRate{}, fmt.Errorf("%w: %s/%s", money.ErrRateNotFound, from, to)
+}
+
+// ListRates возвращает все заданные курсы обмена
+func (r *ExchangeRateRepository) ListRates(ctx context.Context) ([]entity.ExchangeRate, error) {
+	var rates []entity.ExchangeRate
+	err := r.conn(ctx).Order("base_currency, quote_currency").Find(&rates).Error
+	return rates, err
+}
+
+// SaveRate создает или обновляет курс обмена
+func (r *ExchangeRateRepository) SaveRate(ctx context.Context, rate money.ExchangeRate) error {
+	return r.conn(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "base_currency"}, {Name: "quote_currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_at"}),
+	}).Create(&entity.ExchangeRate{
+		BaseCurrency:  rate.From,
+		QuoteCurrency: rate.To,
+		Rate:          rate.Rate,
+		UpdatedAt:     time.Now(),
+	}).Error
+}
+
+// LoadFile загружает курсы обмена из JSON файла вида [{"from": "USD", "to": "RUB", "rate": "92.5"}]
+// и сохраняет их в таблицу курсов
+func (r *ExchangeRateRepository) LoadFile(ctx context.Context, path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка чтения файла курсов %s: %w", path, err)
+	}
+
+	var rates []money.ExchangeRate
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return 0, fmt.Errorf("ошибка разбора файла курсов %s: %w", path, err)
+	}
+
+	err = database.WithTransaction(ctx, r.db, func(ctx context.Context) error {
+		for _, rate := range rates {
+			from, fromErr := money.ParseCurrency(string(rate.From))
+			to, toErr := money.ParseCurrency(string(rate.To))
+			if err := errors.Join(fromErr, toErr); err != nil {
+				return err
+			}
+			validated, err := money.NewExchangeRate(from, to, rate.Rate)
+			if err != nil {
+				return err
+			}
+			if err := r.SaveRate(ctx, validated); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ошибка загрузки курсов из %s: %w", path, err)
+	}
+	return len(rates), nil
+}
+
+// conn возвращает соединение с учетом транзакции, открытой через BillingRepository.WithTransaction
+func (r *ExchangeRateRepository) conn(ctx context.Context) *gorm.DB {
+	return database.Conn(ctx, r.db)
+}
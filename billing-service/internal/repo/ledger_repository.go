@@ -0,0 +1,120 @@
+package repo
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/director74/dz8_shop/billing-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/database"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// LedgerRepository репозиторий главной книги: счета, операции и проводки
+type LedgerRepository struct {
+	db *gorm.DB
+}
+
+func NewLedgerRepository(db *gorm.DB) *LedgerRepository {
+	return &LedgerRepository{
+		db: db,
+	}
+}
+
+// GetOrCreateAccount возвращает счет главной книги для стороны проводки, создавая его при первом обращении
+func (r *LedgerRepository) GetOrCreateAccount(ctx context.Context, leg entity.LedgerLeg) (entity.LedgerAccount, error) {
+	account := entity.LedgerAccount{
+		Code:      leg.Code,
+		Kind:      leg.Kind,
+		AccountID: leg.AccountID,
+		Currency:  leg.Currency,
+	}
+	if err := r.conn(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error; err != nil {
+		return entity.LedgerAccount{}, err
+	}
+
+	var existing entity.LedgerAccount
+	err := r.conn(ctx).Where("code = ?", leg.Code).First(&existing).Error
+	return existing, err
+}
+
+// CreateEntry сохраняет операцию вместе с проводками
+func (r *LedgerRepository) CreateEntry(ctx context.Context, entry *entity.JournalEntry) error {
+	return r.conn(ctx).Create(entry).Error
+}
+
+// AccountBalanceByReference возвращает остаток счета (кредит минус дебет) по операциям с указанной ссылкой
+func (r *LedgerRepository) AccountBalanceByReference(ctx context.Context, ledgerAccountID uint, reference string) (money.Amount, error) {
+	var result struct {
+		Balance money.Amount
+	}
+	err := r.conn(ctx).Model(&entity.Posting{}).
+		Select("COALESCE(SUM(CASE WHEN postings.direction = ? THEN postings.amount ELSE -postings.amount END), 0) AS balance", entity.PostingCredit).
+		Joins("JOIN journal_entries ON journal_entries.id = postings.journal_entry_id").
+		Where("postings.ledger_account_id = ? AND journal_entries.reference = ?", ledgerAccountID, reference).
+		Scan(&result).Error
+	return result.Balance, err
+}
+
+// EntryExists сообщает, проведена ли уже операция entryType со ссылкой reference
+func (r *LedgerRepository) EntryExists(ctx context.Context, entryType entity.JournalEntryType, reference string) (bool, error) {
+	var count int64
+	err := r.conn(ctx).Model(&entity.JournalEntry{}).
+		Where("type = ? AND reference = ?", entryType, reference).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// CustomerBalances возвращает остатки клиентских счетов по проводкам для всех аккаунтов биллинга
+func (r *LedgerRepository) CustomerBalances(ctx context.Context) ([]entity.CustomerLedgerBalance, error) {
+	var balances []entity.CustomerLedgerBalance
+	err := r.conn(ctx).Model(&entity.LedgerAccount{}).
+		Select(`ledger_accounts.account_id AS account_id,
+			COALESCE(SUM(CASE WHEN ledger_accounts.kind = ? THEN
+				CASE WHEN postings.direction = ? THEN postings.amount ELSE -postings.amount END END), 0) AS available,
+			COALESCE(SUM(CASE WHEN ledger_accounts.kind = ? THEN
+				CASE WHEN postings.direction = ? THEN postings.amount ELSE -postings.amount END END), 0) AS held`,
+			entity.LedgerAccountCustomerAvailable, entity.PostingCredit,
+			entity.LedgerAccountCustomerHeld, entity.PostingCredit).
+		Joins("LEFT JOIN postings ON postings.ledger_account_id = ledger_accounts.id").
+		Where("ledger_accounts.account_id IS NOT NULL").
+		Group("ledger_accounts.account_id").
+		Scan(&balances).Error
+	return balances, err
+}
+
+// Totals возвращает суммы дебетовых и кредитовых проводок по каждой валюте
+func (r *LedgerRepository) Totals(ctx context.Context) ([]entity.LedgerTotal, error) {
+	var totals []entity.LedgerTotal
+	err := r.conn(ctx).Model(&entity.Posting{}).
+		Select(`ledger_accounts.currency AS currency,
+			COALESCE(SUM(CASE WHEN postings.direction = ? THEN postings.amount END), 0) AS debit,
+			COALESCE(SUM(CASE WHEN postings.direction = ? THEN postings.amount END), 0) AS credit`,
+			entity.PostingDebit, entity.PostingCredit).
+		Joins("JOIN ledger_accounts ON ledger_accounts.id = postings.ledger_account_id").
+		Group("ledger_accounts.currency").
+		Order("ledger_accounts.currency").
+		Scan(&totals).Error
+	return totals, err
+}
+
+// UnbalancedEntries возвращает операции, у которых сумма дебета не равна сумме кредита
+func (r *LedgerRepository) UnbalancedEntries(ctx context.Context) ([]entity.UnbalancedEntry, error) {
+	var entries []entity.UnbalancedEntry
+	err := r.conn(ctx).Model(&entity.Posting{}).
+		Select(`journal_entry_id,
+			COALESCE(SUM(CASE WHEN direction = ? THEN amount END), 0) AS debit,
+			COALESCE(SUM(CASE WHEN direction = ? THEN amount END), 0) AS credit`,
+			entity.PostingDebit, entity.PostingCredit).
+		Group("journal_entry_id").
+		Having("COALESCE(SUM(CASE WHEN direction = ? THEN amount END), 0) <> COALESCE(SUM(CASE WHEN direction = ? THEN amount END), 0)",
+			entity.PostingDebit, entity.PostingCredit).
+		Scan(&entries).Error
+	return entries, err
+}
+
+// conn возвращает соединение с учетом транзакции, открытой через BillingRepository.WithTransaction
+func (r *LedgerRepository) conn(ctx context.Context) *gorm.DB {
+	return database.Conn(ctx, r.db)
+}
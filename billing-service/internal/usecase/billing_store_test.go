@@ -0,0 +1,319 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/director74/dz8_shop/billing-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// billingStore заменяет BillingRepository и LedgerRepository в тестах usecase биллинга: аккаунты, транзакции
+// и проводки главной книги лежат в срезах, а транзакция базы данных сводится к копии срезов, которая
+// восстанавливается при ошибке. Блокировки аккаунтов не моделируются - тесты выполняются в одной горутине.
+type billingStore struct {
+	accounts       []entity.Account
+	transactions   []entity.Transaction
+	ledgerAccounts []entity.LedgerAccount
+	entries        []entity.JournalEntry
+}
+
+var (
+	_ BillingRepository = (*billingStore)(nil)
+	_ LedgerRepository  = (*billingStore)(nil)
+)
+
+// addAccount добавляет аккаунт с балансом balance
+func (s *billingStore) addAccount(userID uint, balance money.Amount) entity.Account {
+	account := entity.Account{
+		ID:       uint(len(s.accounts) + 1),
+		UserID:   userID,
+		Balance:  balance,
+		Currency: money.DefaultCurrency,
+	}
+	s.accounts = append(s.accounts, account)
+	return account
+}
+
+// account возвращает текущее состояние аккаунта пользователя
+func (s *billingStore) account(userID uint) entity.Account {
+	for _, account := range s.accounts {
+		if account.UserID == userID {
+			return account
+		}
+	}
+	return entity.Account{}
+}
+
+// addTransaction добавляет транзакцию аккаунта, созданную в момент createdAt
+func (s *billingStore) addTransaction(accountID uint, amount money.Amount, txType, status string, createdAt time.Time) {
+	s.transactions = append(s.transactions, entity.Transaction{
+		ID:        uint(len(s.transactions) + 1),
+		AccountID: accountID,
+		Amount:    amount,
+		Currency:  money.DefaultCurrency,
+		Type:      txType,
+		Status:    status,
+		CreatedAt: createdAt,
+	})
+}
+
+// entriesOf возвращает операции главной книги типа entryType
+func (s *billingStore) entriesOf(entryType entity.JournalEntryType) []entity.JournalEntry {
+	var entries []entity.JournalEntry
+	for _, entry := range s.entries {
+		if entry.Type == entryType {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (s *billingStore) CreateAccount(ctx context.Context, account entity.Account) (entity.Account, error) {
+	account.ID = uint(len(s.accounts) + 1)
+	s.accounts = append(s.accounts, account)
+	return account, nil
+}
+
+func (s *billingStore) GetAccountByUserID(ctx context.Context, userID uint) (entity.Account, error) {
+	for _, account := range s.accounts {
+		if account.UserID == userID {
+			return account, nil
+		}
+	}
+	return entity.Account{}, errors.New("record not found")
+}
+
+func (s *billingStore) LockAccountByUserID(ctx context.Context, userID uint) (entity.Account, error) {
+	return s.GetAccountByUserID(ctx, userID)
+}
+
+func (s *billingStore) ListAccounts(ctx context.Context) ([]entity.Account, error) {
+	return append([]entity.Account(nil), s.accounts...), nil
+}
+
+func (s *billingStore) UpdateBalance(ctx context.Context, accountID uint, amount money.Amount) error {
+	s.accounts[accountID-1].Balance += amount
+	return nil
+}
+
+func (s *billingStore) UpdateHeld(ctx context.Context, accountID uint, amount money.Amount) error {
+	s.accounts[accountID-1].Held += amount
+	return nil
+}
+
+func (s *billingStore) CreateTransaction(ctx context.Context, transaction entity.Transaction) (entity.Transaction, error) {
+	transaction.ID = uint(len(s.transactions) + 1)
+	s.transactions = append(s.transactions, transaction)
+	return transaction, nil
+}
+
+func (s *billingStore) GetTransactionByID(ctx context.Context, id uint) (entity.Transaction, error) {
+	if id == 0 || int(id) > len(s.transactions) {
+		return entity.Transaction{}, errors.New("record not found")
+	}
+	return s.transactions[id-1], nil
+}
+
+func (s *billingStore) ListTransactionsByAccountID(ctx context.Context, accountID uint, limit, offset int) ([]entity.Transaction, int64, error) {
+	return s.ListTransactions(ctx, entity.TransactionFilter{AccountID: accountID, Limit: limit, Offset: offset})
+}
+
+func (s *billingStore) ListTransactions(ctx context.Context, filter entity.TransactionFilter) ([]entity.Transaction, int64, error) {
+	var matched []entity.Transaction
+	for _, transaction := range s.transactions {
+		switch {
+		case transaction.AccountID != filter.AccountID,
+			filter.Type != "" && transaction.Type != filter.Type,
+			filter.Status != "" && transaction.Status != filter.Status,
+			!filter.From.IsZero() && transaction.CreatedAt.Before(filter.From),
+			!filter.To.IsZero() && !transaction.CreatedAt.Before(filter.To):
+			continue
+		}
+		matched = append(matched, transaction)
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		if filter.Ascending {
+			return matched[i].CreatedAt.Before(matched[j].CreatedAt)
+		}
+		return matched[i].CreatedAt.After(matched[j].CreatedAt)
+	})
+
+	total := int64(len(matched))
+	if filter.Offset < len(matched) {
+		matched = matched[filter.Offset:]
+	} else {
+		matched = nil
+	}
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[:filter.Limit]
+	}
+	return matched, total, nil
+}
+
+func (s *billingStore) SumBalanceChangesSince(ctx context.Context, accountID uint, since time.Time) (money.Amount, error) {
+	var sum money.Amount
+	for _, transaction := range s.transactions {
+		if transaction.AccountID == accountID && transaction.Status != entity.TransactionStatusFailed && !transaction.CreatedAt.Before(since) {
+			sum += transaction.Amount
+		}
+	}
+	return sum, nil
+}
+
+func (s *billingStore) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	snapshot := billingStore{
+		accounts:       append([]entity.Account(nil), s.accounts...),
+		transactions:   append([]entity.Transaction(nil), s.transactions...),
+		ledgerAccounts: append([]entity.LedgerAccount(nil), s.ledgerAccounts...),
+		entries:        append([]entity.JournalEntry(nil), s.entries...),
+	}
+	if err := fn(ctx); err != nil {
+		*s = snapshot
+		return err
+	}
+	return nil
+}
+
+func (s *billingStore) GetOrCreateAccount(ctx context.Context, leg entity.LedgerLeg) (entity.LedgerAccount, error) {
+	for _, account := range s.ledgerAccounts {
+		if account.Code == leg.Code {
+			return account, nil
+		}
+	}
+	account := entity.LedgerAccount{
+		ID:        uint(len(s.ledgerAccounts) + 1),
+		Code:      leg.Code,
+		Kind:      leg.Kind,
+		AccountID: leg.AccountID,
+		Currency:  leg.Currency,
+	}
+	s.ledgerAccounts = append(s.ledgerAccounts, account)
+	return account, nil
+}
+
+func (s *billingStore) CreateEntry(ctx context.Context, entry *entity.JournalEntry) error {
+	entry.ID = uint(len(s.entries) + 1)
+	for i := range entry.Postings {
+		entry.Postings[i].JournalEntryID = entry.ID
+	}
+	s.entries = append(s.entries, *entry)
+	return nil
+}
+
+func (s *billingStore) AccountBalanceByReference(ctx context.Context, ledgerAccountID uint, reference string) (money.Amount, error) {
+	var balance money.Amount
+	for _, entry := range s.entries {
+		if entry.Reference != reference {
+			continue
+		}
+		for _, posting := range entry.Postings {
+			if posting.LedgerAccountID == ledgerAccountID {
+				balance += signedAmount(posting)
+			}
+		}
+	}
+	return balance, nil
+}
+
+func (s *billingStore) EntryExists(ctx context.Context, entryType entity.JournalEntryType, reference string) (bool, error) {
+	for _, entry := range s.entries {
+		if entry.Type == entryType && entry.Reference == reference {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *billingStore) CustomerBalances(ctx context.Context) ([]entity.CustomerLedgerBalance, error) {
+	byAccount := make(map[uint]*entity.CustomerLedgerBalance)
+	var balances []*entity.CustomerLedgerBalance
+	for _, account := range s.ledgerAccounts {
+		if account.AccountID == nil {
+			continue
+		}
+		balance := byAccount[*account.AccountID]
+		if balance == nil {
+			balance = &entity.CustomerLedgerBalance{AccountID: *account.AccountID}
+			byAccount[*account.AccountID] = balance
+			balances = append(balances, balance)
+		}
+		for _, entry := range s.entries {
+			for _, posting := range entry.Postings {
+				if posting.LedgerAccountID != account.ID {
+					continue
+				}
+				if account.Kind == entity.LedgerAccountCustomerHeld {
+					balance.Held += signedAmount(posting)
+				} else {
+					balance.Available += signedAmount(posting)
+				}
+			}
+		}
+	}
+
+	result := make([]entity.CustomerLedgerBalance, 0, len(balances))
+	for _, balance := range balances {
+		result = append(result, *balance)
+	}
+	return result, nil
+}
+
+func (s *billingStore) Totals(ctx context.Context) ([]entity.LedgerTotal, error) {
+	var totals []entity.LedgerTotal
+	for _, entry := range s.entries {
+		for _, posting := range entry.Postings {
+			currency := s.ledgerAccounts[posting.LedgerAccountID-1].Currency
+			index := -1
+			for i := range totals {
+				if totals[i].Currency == currency {
+					index = i
+				}
+			}
+			if index < 0 {
+				totals = append(totals, entity.LedgerTotal{Currency: currency})
+				index = len(totals) - 1
+			}
+			if posting.Direction == entity.PostingDebit {
+				totals[index].Debit += posting.Amount
+			} else {
+				totals[index].Credit += posting.Amount
+			}
+		}
+	}
+	return totals, nil
+}
+
+func (s *billingStore) UnbalancedEntries(ctx context.Context) ([]entity.UnbalancedEntry, error) {
+	var unbalanced []entity.UnbalancedEntry
+	for _, entry := range s.entries {
+		var debit, credit money.Amount
+		for _, posting := range entry.Postings {
+			if posting.Direction == entity.PostingDebit {
+				debit += posting.Amount
+			} else {
+				credit += posting.Amount
+			}
+		}
+		if debit != credit {
+			unbalanced = append(unbalanced, entity.UnbalancedEntry{JournalEntryID: entry.ID, Debit: debit, Credit: credit})
+		}
+	}
+	return unbalanced, nil
+}
+
+// signedAmount сумма проводки со знаком: кредит увеличивает остаток счета, дебет уменьшает
+func signedAmount(posting entity.Posting) money.Amount {
+	if posting.Direction == entity.PostingCredit {
+		return posting.Amount
+	}
+	return -posting.Amount
+}
+
+// newTestBillingUseCase создает usecase биллинга поверх billingStore без публикации событий
+func newTestBillingUseCase(store *billingStore) *BillingUseCase {
+	return NewBillingUseCase(store, store, nil, nil, "billing_exchange")
+}
@@ -8,20 +8,43 @@ import (
 	"log"
 	"time"
 
-	"gorm.io/gorm"
-
 	"github.com/director74/dz8_shop/billing-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+	"github.com/director74/dz8_shop/pkg/outbox"
 )
 
 // BillingRepository интерфейс для работы с хранилищем биллинга
 type BillingRepository interface {
 	CreateAccount(ctx context.Context, account entity.Account) (entity.Account, error)
 	GetAccountByUserID(ctx context.Context, userID uint) (entity.Account, error)
-	UpdateBalance(ctx context.Context, accountID uint, amount float64) error
+	UpdateBalance(ctx context.Context, accountID uint, amount money.Amount) error
 	CreateTransaction(ctx context.Context, transaction entity.Transaction) (entity.Transaction, error)
 	GetTransactionByID(ctx context.Context, id uint) (entity.Transaction, error)
 	ListTransactionsByAccountID(ctx context.Context, accountID uint, limit, offset int) ([]entity.Transaction, int64, error)
-	WithTransaction(ctx context.Context, fn func(tx *gorm.DB) error) error
+	ListTransactions(ctx context.Context, filter entity.TransactionFilter) ([]entity.Transaction, int64, error)
+	SumBalanceChangesSince(ctx context.Context, accountID uint, since time.Time) (money.Amount, error)
+	LockAccountByUserID(ctx context.Context, userID uint) (entity.Account, error)
+	ListAccounts(ctx context.Context) ([]entity.Account, error)
+	UpdateHeld(ctx context.Context, accountID uint, amount money.Amount) error
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// LedgerRepository интерфейс главной книги двойной записи
+type LedgerRepository interface {
+	GetOrCreateAccount(ctx context.Context, leg entity.LedgerLeg) (entity.LedgerAccount, error)
+	CreateEntry(ctx context.Context, entry *entity.JournalEntry) error
+	AccountBalanceByReference(ctx context.Context, ledgerAccountID uint, reference string) (money.Amount, error)
+	EntryExists(ctx context.Context, entryType entity.JournalEntryType, reference string) (bool, error)
+	CustomerBalances(ctx context.Context) ([]entity.CustomerLedgerBalance, error)
+	Totals(ctx context.Context) ([]entity.LedgerTotal, error)
+	UnbalancedEntries(ctx context.Context) ([]entity.UnbalancedEntry, error)
+}
+
+// ExchangeRateProvider источник курсов обмена валют
+type ExchangeRateProvider interface {
+	Rate(ctx context.Context, from, to money.Currency) (money.ExchangeRate, error)
+	ListRates(ctx context.Context) ([]entity.ExchangeRate, error)
+	SaveRate(ctx context.Context, rate money.ExchangeRate) error
 }
 
 // RabbitMQClient интерфейс для работы с RabbitMQ
@@ -33,21 +56,39 @@ type RabbitMQClient interface {
 // BillingUseCase представляет usecase для работы с биллингом
 type BillingUseCase struct {
 	repo        BillingRepository
+	ledger      LedgerRepository
+	rates       ExchangeRateProvider
 	rabbitMQ    RabbitMQClient
 	billingExch string
 }
 
 // NewBillingUseCase создает новый usecase для работы с биллингом
-func NewBillingUseCase(repo BillingRepository, rabbitMQ RabbitMQClient, billingExch string) *BillingUseCase {
+func NewBillingUseCase(repo BillingRepository, ledger LedgerRepository, rates ExchangeRateProvider, rabbitMQ RabbitMQClient, billingExch string) *BillingUseCase {
 	return &BillingUseCase{
 		repo:        repo,
+		ledger:      ledger,
+		rates:       rates,
 		rabbitMQ:    rabbitMQ,
 		billingExch: billingExch,
 	}
 }
 
+// publisher возвращает клиент для публикации в рамках транзакции из ctx.
+// Outbox сохраняет сообщение в той же транзакции, что и изменения баланса.
+func (uc *BillingUseCase) publisher(ctx context.Context) RabbitMQClient {
+	if p, ok := uc.rabbitMQ.(*outbox.Publisher); ok {
+		return p.WithContext(ctx)
+	}
+	return uc.rabbitMQ
+}
+
 func (uc *BillingUseCase) CreateAccount(ctx context.Context, req entity.CreateAccountRequest) (entity.CreateAccountResponse, error) {
-	_, err := uc.repo.GetAccountByUserID(ctx, req.UserID)
+	currency, err := money.ParseCurrency(string(req.Currency))
+	if err != nil {
+		return entity.CreateAccountResponse{}, err
+	}
+
+	_, err = uc.repo.GetAccountByUserID(ctx, req.UserID)
 	if err == nil {
 		return entity.CreateAccountResponse{}, errors.New("аккаунт для данного пользователя уже существует")
 	}
@@ -55,6 +96,7 @@ func (uc *BillingUseCase) CreateAccount(ctx context.Context, req entity.CreateAc
 	account := entity.Account{
 		UserID:    req.UserID,
 		Balance:   0,
+		Currency:  currency,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -65,9 +107,10 @@ func (uc *BillingUseCase) CreateAccount(ctx context.Context, req entity.CreateAc
 	}
 
 	return entity.CreateAccountResponse{
-		ID:      newAccount.ID,
-		UserID:  newAccount.UserID,
-		Balance: newAccount.Balance,
+		ID:       newAccount.ID,
+		UserID:   newAccount.UserID,
+		Balance:  newAccount.Balance,
+		Currency: newAccount.Currency,
 	}, nil
 }
 
@@ -81,29 +124,51 @@ func (uc *BillingUseCase) GetAccount(ctx context.Context, userID uint) (entity.G
 		ID:        account.ID,
 		UserID:    account.UserID,
 		Balance:   account.Balance,
+		Held:      account.Held,
+		Available: account.Balance - account.Held,
+		Currency:  account.Currency,
 		CreatedAt: account.CreatedAt,
 	}, nil
 }
 
-// Deposit пополняет баланс аккаунта
-func (uc *BillingUseCase) Deposit(ctx context.Context, userID uint, amount float64, email string) (entity.DepositResponse, error) {
+// Deposit пополняет баланс аккаунта. Пополнение принимается только в валюте счета,
+// пустая валюта означает валюту счета.
+func (uc *BillingUseCase) Deposit(ctx context.Context, userID uint, amount money.Amount, currency money.Currency, email string) (entity.DepositResponse, error) {
 	account, err := uc.repo.GetAccountByUserID(ctx, userID)
 	if err != nil {
 		return entity.DepositResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
 	}
+	if err := checkAccountCurrency(account, currency); err != nil {
+		return entity.DepositResponse{}, err
+	}
 
 	transaction := entity.Transaction{
 		AccountID: account.ID,
 		Amount:    amount,
+		Currency:  account.Currency,
 		Type:      entity.TransactionTypeDeposit,
 		Status:    entity.TransactionStatusSuccess,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
+	// Определяем email для уведомления
+	if email == "" {
+		email = "user" + fmt.Sprintf("%d", account.UserID) + "@example.com"
+	}
+
 	var newTransaction entity.Transaction
 
-	err = uc.repo.WithTransaction(ctx, func(tx *gorm.DB) error {
+	err = uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		// Проводим пополнение в главной книге: внешние поступления -> доступные средства клиента
+		entryID, err := uc.post(ctx, entity.JournalEntryDeposit, "", "Пополнение баланса",
+			externalLeg(account.Currency, entity.PostingDebit, amount),
+			availableLeg(account, entity.PostingCredit, amount))
+		if err != nil {
+			return err
+		}
+		transaction.JournalEntryID = &entryID
+
 		// Обновляем баланс
 		if err := uc.repo.UpdateBalance(ctx, account.ID, amount); err != nil {
 			return fmt.Errorf("ошибка при обновлении баланса: %w", err)
@@ -115,153 +180,110 @@ func (uc *BillingUseCase) Deposit(ctx context.Context, userID uint, amount float
 			return fmt.Errorf("ошибка при создании транзакции: %w", txErr)
 		}
 
-		return nil
-	})
-
-	if err != nil {
-		return entity.DepositResponse{}, err
-	}
-
-	// Отправляем событие в RabbitMQ для нотификации с повторными попытками, если RabbitMQ инициализирован
-	if uc.rabbitMQ != nil {
-		// Определяем email для уведомления
-		if email == "" {
-			email = "user" + fmt.Sprintf("%d", account.UserID) + "@example.com"
+		// Отправляем событие для нотификации, если RabbitMQ инициализирован.
+		// При работе через outbox событие фиксируется вместе с пополнением.
+		if uc.rabbitMQ == nil {
+			return nil
 		}
 
 		messageWithType := struct {
-			Type          string  `json:"type"`
-			UserID        uint    `json:"user_id"`
-			TransactionID uint    `json:"transaction_id"`
-			Amount        float64 `json:"amount"`
-			OperationType string  `json:"operation_type"`
-			Status        string  `json:"status"`
-			Email         string  `json:"email"`
+			Type          string         `json:"type"`
+			UserID        uint           `json:"user_id"`
+			TransactionID uint           `json:"transaction_id"`
+			Amount        money.Amount   `json:"amount"`
+			Currency      money.Currency `json:"currency"`
+			OperationType string         `json:"operation_type"`
+			Status        string         `json:"status"`
+			Email         string         `json:"email"`
 		}{
 			Type:          "billing.deposit",
 			UserID:        account.UserID,
 			TransactionID: newTransaction.ID,
 			Amount:        amount,
+			Currency:      account.Currency,
 			OperationType: entity.TransactionTypeDeposit,
 			Status:        entity.TransactionStatusSuccess,
 			Email:         email,
 		}
 
-		// Используем метод с повторными попытками для надежной публикации
-		err = uc.rabbitMQ.PublishMessageWithRetry(uc.billingExch, "billing.deposit", messageWithType, 3)
-		if err != nil {
-			// Логируем ошибку, но не прерываем выполнение
-			log.Printf("Ошибка при отправке нотификации о пополнении баланса после %d попыток: %v\n", 3, err)
-		} else {
-			// Логируем успешную отправку
-			log.Printf("Успешно отправлено уведомление о пополнении баланса для пользователя %d на email %s\n",
-				account.UserID, email)
+		if err := uc.publisher(ctx).PublishMessageWithRetry(uc.billingExch, "billing.deposit", messageWithType, 3); err != nil {
+			return fmt.Errorf("ошибка при отправке нотификации о пополнении баланса: %w", err)
 		}
+		return nil
+	})
+
+	if err != nil {
+		return entity.DepositResponse{}, err
 	}
 
+	log.Printf("Пополнение баланса пользователя %d сохранено, уведомление будет отправлено на email %s\n",
+		account.UserID, email)
+
 	return entity.DepositResponse{
-		Transaction: entity.TransactionResponse{
-			ID:        newTransaction.ID,
-			AccountID: newTransaction.AccountID,
-			Amount:    newTransaction.Amount,
-			Type:      newTransaction.Type,
-			Status:    newTransaction.Status,
-			CreatedAt: newTransaction.CreatedAt,
-		},
-		Success: true,
+		Transaction: toTransactionResponse(newTransaction),
+		Success:     true,
 	}, nil
 }
 
-// Withdraw снимает деньги с аккаунта
-func (uc *BillingUseCase) Withdraw(ctx context.Context, userID uint, amount float64, email string) (entity.WithdrawResponse, error) {
-	account, err := uc.repo.GetAccountByUserID(ctx, userID)
-	if err != nil {
-		return entity.WithdrawResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
-	}
+// Withdraw снимает деньги с аккаунта. Списываются только доступные средства:
+// сумма, заблокированная под заказы, для списания недоступна.
+// Списание принимается только в валюте счета, пустая валюта означает валюту счета.
+func (uc *BillingUseCase) Withdraw(ctx context.Context, userID uint, amount money.Amount, currency money.Currency, email string) (entity.WithdrawResponse, error) {
+	return uc.withdraw(ctx, userID, amount, currency, email, false)
+}
 
-	if account.Balance < amount {
-		transaction := entity.Transaction{
-			AccountID: account.ID,
-			Amount:    amount,
-			Type:      entity.TransactionTypeWithdrawal,
-			Status:    entity.TransactionStatusFailed,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
+// ChargeOrder списывает со счета оплату заказа. Если валюта заказа отличается от валюты счета,
+// сумма конвертируется по текущему курсу, а исходная сумма и курс сохраняются в транзакции.
+func (uc *BillingUseCase) ChargeOrder(ctx context.Context, userID uint, amount money.Amount, currency money.Currency, email string) (entity.WithdrawResponse, error) {
+	return uc.withdraw(ctx, userID, amount, currency, email, true)
+}
+
+// withdraw выполняет списание. При convert сумма в чужой валюте конвертируется в валюту счета,
+// иначе такая сумма отклоняется с ErrCurrencyMismatch.
+func (uc *BillingUseCase) withdraw(ctx context.Context, userID uint, amount money.Amount, currency money.Currency, email string, convert bool) (entity.WithdrawResponse, error) {
+	var newTransaction entity.Transaction
+	success := false
 
-		newTransaction, err := uc.repo.CreateTransaction(ctx, transaction)
+	err := uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		// Блокируем аккаунт, чтобы параллельные списания не увели баланс в минус
+		account, err := uc.repo.LockAccountByUserID(ctx, userID)
 		if err != nil {
-			return entity.WithdrawResponse{}, fmt.Errorf("ошибка при создании транзакции: %w", err)
+			return fmt.Errorf("аккаунт не найден: %w", err)
 		}
 
-		// Отправляем событие в RabbitMQ при недостатке средств
-		if uc.rabbitMQ != nil {
-			notification := struct {
-				Type          string  `json:"type"`
-				UserID        uint    `json:"user_id"`
-				TransactionID uint    `json:"transaction_id"`
-				Amount        float64 `json:"amount"`
-				OperationType string  `json:"operation_type"`
-				Status        string  `json:"status"`
-				Balance       float64 `json:"balance"`
-				Reason        string  `json:"reason"`
-				Email         string  `json:"email"`
-			}{
-				Type:          "billing.insufficient_funds",
-				UserID:        account.UserID,
-				TransactionID: newTransaction.ID,
-				Amount:        amount,
-				OperationType: entity.TransactionTypeWithdrawal,
-				Status:        entity.TransactionStatusFailed,
-				Balance:       account.Balance,
-				Reason:        "insufficient_funds",
-				Email:         email,
-			}
-
-			// Используем метод с повторными попытками для надежной публикации
-			err = uc.rabbitMQ.PublishMessageWithRetry(uc.billingExch, "billing.insufficient_funds", notification, 3)
-			if err != nil {
-				// Логируем ошибку, но не прерываем выполнение
-				log.Printf("Ошибка при отправке нотификации о недостатке средств после %d попыток: %v\n", 3, err)
-			}
+		charge, err := uc.accountCharge(ctx, account, amount, currency, convert)
+		if err != nil {
+			return err
 		}
 
-		return entity.WithdrawResponse{
-			Transaction: entity.TransactionResponse{
-				ID:        newTransaction.ID,
-				AccountID: newTransaction.AccountID,
-				Amount:    newTransaction.Amount,
-				Type:      newTransaction.Type,
-				Status:    newTransaction.Status,
-				CreatedAt: newTransaction.CreatedAt,
-			},
-			Success: false,
-		}, nil
-	}
-
-	transaction := entity.Transaction{
-		AccountID: account.ID,
-		Amount:    -amount, // Отрицательная сумма для снятия
-		Type:      entity.TransactionTypeWithdrawal,
-		Status:    entity.TransactionStatusSuccess,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
+		available := account.Balance - account.Held
+		if available < charge.Amount {
+			return uc.recordInsufficientFunds(ctx, account, charge, available, email, &newTransaction)
+		}
 
-	var newTransaction entity.Transaction
+		// Проводим списание в главной книге: доступные средства клиента -> выручка
+		entryID, err := uc.post(ctx, entity.JournalEntryWithdrawal, "", "Списание средств",
+			availableLeg(account, entity.PostingDebit, charge.Amount),
+			salesLeg(account.Currency, entity.PostingCredit, charge.Amount))
+		if err != nil {
+			return err
+		}
 
-	err = uc.repo.WithTransaction(ctx, func(tx *gorm.DB) error {
 		// Обновляем баланс
-		if err := uc.repo.UpdateBalance(ctx, account.ID, -amount); err != nil {
+		if err := uc.repo.UpdateBalance(ctx, account.ID, -charge.Amount); err != nil {
 			return fmt.Errorf("ошибка при обновлении баланса: %w", err)
 		}
 
-		var txErr error
-		newTransaction, txErr = uc.repo.CreateTransaction(ctx, transaction)
-		if txErr != nil {
-			return fmt.Errorf("ошибка при создании транзакции: %w", txErr)
+		transaction := charge
+		transaction.Amount = -charge.Amount // Отрицательная сумма для снятия
+		transaction.Status = entity.TransactionStatusSuccess
+		transaction.JournalEntryID = &entryID
+		newTransaction, err = uc.repo.CreateTransaction(ctx, transaction)
+		if err != nil {
+			return fmt.Errorf("ошибка при создании транзакции: %w", err)
 		}
 
+		success = true
 		return nil
 	})
 
@@ -269,27 +291,112 @@ func (uc *BillingUseCase) Withdraw(ctx context.Context, userID uint, amount floa
 		return entity.WithdrawResponse{}, err
 	}
 
+	response := toTransactionResponse(newTransaction)
+	response.Amount = newTransaction.Amount.Abs() // Возвращаем положительную сумму для ясности
 	return entity.WithdrawResponse{
-		Transaction: entity.TransactionResponse{
-			ID:        newTransaction.ID,
-			AccountID: newTransaction.AccountID,
-			Amount:    amount, // Возвращаем положительную сумму для ясности
-			Type:      newTransaction.Type,
-			Status:    newTransaction.Status,
-			CreatedAt: newTransaction.CreatedAt,
-		},
-		Success: true,
+		Transaction: response,
+		Success:     success,
 	}, nil
 }
 
+// accountCharge готовит транзакцию списания в валюте счета. Для суммы в другой валюте
+// (только при convert) в транзакции сохраняются исходная сумма и примененный курс.
+func (uc *BillingUseCase) accountCharge(ctx context.Context, account entity.Account, amount money.Amount, currency money.Currency, convert bool) (entity.Transaction, error) {
+	transaction := entity.Transaction{
+		AccountID: account.ID,
+		Amount:    amount,
+		Currency:  account.Currency,
+		Type:      entity.TransactionTypeWithdrawal,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if currency == "" || currency == account.Currency {
+		return transaction, nil
+	}
+	if !convert {
+		return entity.Transaction{}, fmt.Errorf("%w: счет в %s, операция в %s", money.ErrCurrencyMismatch, account.Currency, currency)
+	}
+
+	rate, err := uc.rates.Rate(ctx, currency, account.Currency)
+	if err != nil {
+		return entity.Transaction{}, err
+	}
+	converted, err := rate.Convert(amount)
+	if err != nil {
+		return entity.Transaction{}, fmt.Errorf("ошибка конвертации %s %s в %s: %w", amount, currency, account.Currency, err)
+	}
+
+	transaction.Amount = converted
+	transaction.OriginalAmount = amount
+	transaction.OriginalCurrency = currency
+	transaction.ExchangeRate = rate.Rate
+	return transaction, nil
+}
+
+// checkAccountCurrency проверяет, что операция выполняется в валюте счета.
+// Пустая валюта означает валюту счета.
+func checkAccountCurrency(account entity.Account, currency money.Currency) error {
+	if currency != "" && currency != account.Currency {
+		return fmt.Errorf("%w: счет в %s, операция в %s", money.ErrCurrencyMismatch, account.Currency, currency)
+	}
+	return nil
+}
+
+// recordInsufficientFunds сохраняет неуспешную транзакцию списания и событие о недостатке средств.
+// Баланс и главная книга не меняются.
+func (uc *BillingUseCase) recordInsufficientFunds(ctx context.Context, account entity.Account, charge entity.Transaction, available money.Amount, email string, newTransaction *entity.Transaction) error {
+	var err error
+	charge.Status = entity.TransactionStatusFailed
+	*newTransaction, err = uc.repo.CreateTransaction(ctx, charge)
+	if err != nil {
+		return fmt.Errorf("ошибка при создании транзакции: %w", err)
+	}
+
+	// Отправляем событие при недостатке средств
+	if uc.rabbitMQ == nil {
+		return nil
+	}
+
+	notification := struct {
+		Type          string         `json:"type"`
+		UserID        uint           `json:"user_id"`
+		TransactionID uint           `json:"transaction_id"`
+		Amount        money.Amount   `json:"amount"`
+		Currency      money.Currency `json:"currency"`
+		OperationType string         `json:"operation_type"`
+		Status        string         `json:"status"`
+		Balance       money.Amount   `json:"balance"`
+		Reason        string         `json:"reason"`
+		Email         string         `json:"email"`
+	}{
+		Type:          "billing.insufficient_funds",
+		UserID:        account.UserID,
+		TransactionID: newTransaction.ID,
+		Amount:        charge.Amount,
+		Currency:      account.Currency,
+		OperationType: entity.TransactionTypeWithdrawal,
+		Status:        entity.TransactionStatusFailed,
+		Balance:       available,
+		Reason:        "insufficient_funds",
+		Email:         email,
+	}
+
+	if err := uc.publisher(ctx).PublishMessageWithRetry(uc.billingExch, "billing.insufficient_funds", notification, 3); err != nil {
+		return fmt.Errorf("ошибка при отправке нотификации о недостатке средств: %w", err)
+	}
+	return nil
+}
+
 // HandleOrderCreatedEvent обрабатывает событие создания заказа
 func (uc *BillingUseCase) HandleOrderCreatedEvent(data []byte) error {
 	// Структура для десериализации сообщения
 	var message struct {
-		OrderID   uint    `json:"order_id"`
-		UserID    uint    `json:"user_id"`
-		TotalCost float64 `json:"total_cost"`
-		Email     string  `json:"email"`
+		OrderID   uint           `json:"order_id"`
+		UserID    uint           `json:"user_id"`
+		TotalCost money.Amount   `json:"total_cost"`
+		Currency  money.Currency `json:"currency"`
+		Email     string         `json:"email"`
 	}
 
 	// Десериализуем сообщение
@@ -298,43 +405,51 @@ func (uc *BillingUseCase) HandleOrderCreatedEvent(data []byte) error {
 		return fmt.Errorf("ошибка при разборе сообщения о создании заказа: %w", err)
 	}
 
-	log.Printf("Получено событие создания заказа: OrderID=%d, UserID=%d, TotalCost=%.2f",
-		message.OrderID, message.UserID, message.TotalCost)
+	log.Printf("Получено событие создания заказа: OrderID=%d, UserID=%d, TotalCost=%s %s",
+		message.OrderID, message.UserID, message.TotalCost, message.Currency.OrDefault())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Выполняем списание средств
-	resp, err := uc.Withdraw(ctx, message.UserID, message.TotalCost, message.Email)
-	if err != nil {
-		log.Printf("Ошибка при списании средств для заказа %d: %v", message.OrderID, err)
-		return err
-	}
+	// Списание и событие о его результате фиксируются в одной транзакции
+	var transactionSuccess bool
+	err = uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		// Выполняем списание средств
+		resp, err := uc.ChargeOrder(ctx, message.UserID, message.TotalCost, message.Currency.OrDefault(), message.Email)
+		if err != nil {
+			return fmt.Errorf("ошибка при списании средств: %w", err)
+		}
 
-	// Результат операции (статус транзакции)
-	transactionSuccess := resp.Success
-
-	// Отправляем событие о результате обработки платежа
-	paymentEvent := struct {
-		OrderID       uint    `json:"order_id"`
-		UserID        uint    `json:"user_id"`
-		TransactionID uint    `json:"transaction_id"`
-		Amount        float64 `json:"amount"`
-		Status        string  `json:"status"`
-		Success       bool    `json:"success"`
-	}{
-		OrderID:       message.OrderID,
-		UserID:        message.UserID,
-		TransactionID: resp.Transaction.ID,
-		Amount:        message.TotalCost,
-		Status:        resp.Transaction.Status,
-		Success:       transactionSuccess,
-	}
+		// Результат операции (статус транзакции)
+		transactionSuccess = resp.Success
+
+		// Отправляем событие о результате обработки платежа
+		paymentEvent := struct {
+			OrderID       uint           `json:"order_id"`
+			UserID        uint           `json:"user_id"`
+			TransactionID uint           `json:"transaction_id"`
+			Amount        money.Amount   `json:"amount"`
+			Currency      money.Currency `json:"currency"`
+			Status        string         `json:"status"`
+			Success       bool           `json:"success"`
+		}{
+			OrderID:       message.OrderID,
+			UserID:        message.UserID,
+			TransactionID: resp.Transaction.ID,
+			Amount:        message.TotalCost,
+			Currency:      message.Currency.OrDefault(),
+			Status:        resp.Transaction.Status,
+			Success:       transactionSuccess,
+		}
 
-	// Публикуем событие результата обработки
-	err = uc.rabbitMQ.PublishMessageWithRetry(uc.billingExch, "billing.payment_processed", paymentEvent, 3)
+		// Публикуем событие результата обработки
+		if err := uc.publisher(ctx).PublishMessageWithRetry(uc.billingExch, "billing.payment_processed", paymentEvent, 3); err != nil {
+			return fmt.Errorf("ошибка при отправке события обработки платежа: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		log.Printf("Ошибка при отправке события обработки платежа: %v", err)
+		log.Printf("Ошибка при обработке платежа для заказа %d: %v", message.OrderID, err)
 		return err
 	}
 
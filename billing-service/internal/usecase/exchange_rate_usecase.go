@@ -0,0 +1,43 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/director74/dz8_shop/billing-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// ListExchangeRates возвращает заданные курсы обмена валют
+func (uc *BillingUseCase) ListExchangeRates(ctx context.Context) ([]entity.ExchangeRate, error) {
+	rates, err := uc.rates.ListRates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения курсов обмена: %w", err)
+	}
+	if rates == nil {
+		rates = []entity.ExchangeRate{}
+	}
+	return rates, nil
+}
+
+// SetExchangeRate задает курс обмена для пары валют. Курс применяется к заказам, оплаченным после его установки.
+func (uc *BillingUseCase) SetExchangeRate(ctx context.Context, req entity.SetExchangeRateRequest) (money.ExchangeRate, error) {
+	from, fromErr := money.ParseCurrency(string(req.From))
+	to, toErr := money.ParseCurrency(string(req.To))
+	if err := errors.Join(fromErr, toErr); err != nil {
+		return money.ExchangeRate{}, err
+	}
+	if from == to {
+		return money.ExchangeRate{}, fmt.Errorf("%w: курс валюты %s к самой себе всегда равен 1", money.ErrInvalidRate, from)
+	}
+
+	rate, err := money.NewExchangeRate(from, to, req.Rate)
+	if err != nil {
+		return money.ExchangeRate{}, err
+	}
+	if err := uc.rates.SaveRate(ctx, rate); err != nil {
+		return money.ExchangeRate{}, fmt.Errorf("ошибка сохранения курса обмена: %w", err)
+	}
+	return rate, nil
+}
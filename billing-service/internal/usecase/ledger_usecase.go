@@ -0,0 +1,465 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/director74/dz8_shop/billing-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+var (
+	// ErrHoldNotFound нет заблокированных средств по ссылке
+	ErrHoldNotFound = errors.New("блокировка средств не найдена")
+	// ErrRefundExceedsCharge сумма возврата больше суммы, списанной по ссылке
+	ErrRefundExceedsCharge = errors.New("сумма возврата превышает списанную сумму")
+	// ErrUnbalancedEntry сумма дебета операции не равна сумме кредита
+	ErrUnbalancedEntry = errors.New("операция главной книги не сбалансирована")
+)
+
+// OrderReference ссылка главной книги на заказ: по ней блокируется, списывается и возвращается оплата заказа
+func OrderReference(orderID uint) string {
+	return fmt.Sprintf("order:%d", orderID)
+}
+
+// Hold блокирует amount на счете пользователя под операцию reference (например, заказ).
+// Сумма в валюте, отличной от валюты счета, переводится по текущему курсу.
+// Заблокированные средства остаются в балансе, но недоступны для списания до Capture или Release.
+// При недостатке средств сохраняется неуспешная транзакция списания и событие о недостатке средств,
+// а в ответе Success = false. Повторная блокировка по той же ссылке возвращает уже заблокированную сумму.
+func (uc *BillingUseCase) Hold(ctx context.Context, userID uint, amount money.Amount, currency money.Currency, reference string) (entity.HoldResponse, error) {
+	if reference == "" {
+		return entity.HoldResponse{}, errors.New("не указана ссылка для блокировки средств")
+	}
+
+	response := entity.HoldResponse{Reference: reference}
+	err := uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		account, err := uc.repo.LockAccountByUserID(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("аккаунт не найден: %w", err)
+		}
+		response.Currency = account.Currency
+
+		held, err := uc.outstandingHold(ctx, account, reference)
+		if err != nil {
+			return err
+		}
+		if held > 0 {
+			response.Amount = held
+			response.Success = true
+			return nil
+		}
+
+		charge, err := uc.accountCharge(ctx, account, amount, currency, true)
+		if err != nil {
+			return err
+		}
+		response.Amount = charge.Amount
+		response.ExchangeRate = charge.ExchangeRate
+
+		available := account.Balance - account.Held
+		if available < charge.Amount {
+			var failed entity.Transaction
+			if err := uc.recordInsufficientFunds(ctx, account, charge, available, "", &failed); err != nil {
+				return err
+			}
+			response.TransactionID = failed.ID
+			return nil
+		}
+
+		if _, err := uc.post(ctx, entity.JournalEntryHold, reference, "Блокировка средств",
+			availableLeg(account, entity.PostingDebit, charge.Amount),
+			heldLeg(account, entity.PostingCredit, charge.Amount)); err != nil {
+			return err
+		}
+		if err := uc.repo.UpdateHeld(ctx, account.ID, charge.Amount); err != nil {
+			return fmt.Errorf("ошибка при обновлении заблокированной суммы: %w", err)
+		}
+		response.Success = true
+		return nil
+	})
+	if err != nil {
+		return entity.HoldResponse{}, err
+	}
+	return response, nil
+}
+
+// Capture списывает средства, заблокированные по ссылке reference, и возвращает транзакцию списания
+func (uc *BillingUseCase) Capture(ctx context.Context, userID uint, reference string) (entity.TransactionResponse, error) {
+	var newTransaction entity.Transaction
+
+	err := uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		account, err := uc.repo.LockAccountByUserID(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("аккаунт не найден: %w", err)
+		}
+
+		held, err := uc.outstandingHold(ctx, account, reference)
+		if err != nil {
+			return err
+		}
+		if held <= 0 {
+			return fmt.Errorf("%w: %s", ErrHoldNotFound, reference)
+		}
+
+		entryID, err := uc.post(ctx, entity.JournalEntryCapture, reference, "Списание заблокированных средств",
+			heldLeg(account, entity.PostingDebit, held),
+			salesLeg(account.Currency, entity.PostingCredit, held))
+		if err != nil {
+			return err
+		}
+		if err := uc.repo.UpdateHeld(ctx, account.ID, -held); err != nil {
+			return fmt.Errorf("ошибка при обновлении заблокированной суммы: %w", err)
+		}
+		if err := uc.repo.UpdateBalance(ctx, account.ID, -held); err != nil {
+			return fmt.Errorf("ошибка при обновлении баланса: %w", err)
+		}
+
+		newTransaction, err = uc.repo.CreateTransaction(ctx, entity.Transaction{
+			AccountID:      account.ID,
+			Amount:         -held,
+			Currency:       account.Currency,
+			Type:           entity.TransactionTypeWithdrawal,
+			Status:         entity.TransactionStatusSuccess,
+			JournalEntryID: &entryID,
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("ошибка при создании транзакции: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	return toTransactionResponse(newTransaction), nil
+}
+
+// Release снимает блокировку средств по ссылке reference. Если блокировки нет, ничего не делает.
+func (uc *BillingUseCase) Release(ctx context.Context, userID uint, reference string) error {
+	return uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		account, err := uc.repo.LockAccountByUserID(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("аккаунт не найден: %w", err)
+		}
+
+		held, err := uc.outstandingHold(ctx, account, reference)
+		if err != nil {
+			return err
+		}
+		if held <= 0 {
+			return nil
+		}
+
+		if _, err := uc.post(ctx, entity.JournalEntryRelease, reference, "Снятие блокировки средств",
+			heldLeg(account, entity.PostingDebit, held),
+			availableLeg(account, entity.PostingCredit, held)); err != nil {
+			return err
+		}
+		if err := uc.repo.UpdateHeld(ctx, account.ID, -held); err != nil {
+			return fmt.Errorf("ошибка при обновлении заблокированной суммы: %w", err)
+		}
+		return nil
+	})
+}
+
+// Refund возвращает на счет пользователя средства, списанные по ссылке reference (например, заказ).
+// В отличие от пополнения, возврат уменьшает выручку, а не приходит из внешних поступлений.
+// Сумма в валюте, отличной от валюты счета, переводится по текущему курсу; пустая валюта означает валюту счета.
+// Возвращается не больше списанного по ссылке. По одной ссылке выполняется один возврат:
+// повторный вызов, например при повторной компенсации, ничего не меняет и возвращает пустой ответ.
+func (uc *BillingUseCase) Refund(ctx context.Context, userID uint, amount money.Amount, currency money.Currency, reference string) (entity.TransactionResponse, error) {
+	if reference == "" {
+		return entity.TransactionResponse{}, errors.New("не указана ссылка на списание для возврата")
+	}
+
+	var newTransaction entity.Transaction
+
+	err := uc.repo.WithTransaction(ctx, func(ctx context.Context) error {
+		account, err := uc.repo.LockAccountByUserID(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("аккаунт не найден: %w", err)
+		}
+
+		exists, err := uc.ledger.EntryExists(ctx, entity.JournalEntryRefund, reference)
+		if err != nil {
+			return fmt.Errorf("ошибка проверки возврата %s: %w", reference, err)
+		}
+		if exists {
+			log.Printf("Возврат по ссылке %s уже выполнен, повторный возврат пропущен", reference)
+			return nil
+		}
+
+		credit, err := uc.accountCharge(ctx, account, amount, currency, true)
+		if err != nil {
+			return err
+		}
+
+		charged, err := uc.chargedByReference(ctx, account.Currency, reference)
+		if err != nil {
+			return err
+		}
+		if credit.Amount > charged {
+			return fmt.Errorf("%w: по ссылке %s списано %s, к возврату %s", ErrRefundExceedsCharge, reference, charged, credit.Amount)
+		}
+
+		entryID, err := uc.post(ctx, entity.JournalEntryRefund, reference, "Возврат списанных средств",
+			salesLeg(account.Currency, entity.PostingDebit, credit.Amount),
+			availableLeg(account, entity.PostingCredit, credit.Amount))
+		if err != nil {
+			return err
+		}
+		if err := uc.repo.UpdateBalance(ctx, account.ID, credit.Amount); err != nil {
+			return fmt.Errorf("ошибка при обновлении баланса: %w", err)
+		}
+
+		credit.Type = entity.TransactionTypeRefund
+		credit.Status = entity.TransactionStatusSuccess
+		credit.JournalEntryID = &entryID
+		newTransaction, err = uc.repo.CreateTransaction(ctx, credit)
+		if err != nil {
+			return fmt.Errorf("ошибка при создании транзакции: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	if newTransaction.ID == 0 {
+		return entity.TransactionResponse{}, nil
+	}
+
+	return toTransactionResponse(newTransaction), nil
+}
+
+// Reconcile сверяет балансы аккаунтов с главной книгой: баланс и заблокированная сумма каждого аккаунта
+// должны совпадать с остатками его счетов по проводкам, а каждая операция - быть сбалансированной
+func (uc *BillingUseCase) Reconcile(ctx context.Context) (entity.ReconciliationReport, error) {
+	report := entity.ReconciliationReport{
+		CheckedAt:         time.Now(),
+		Totals:            []entity.LedgerTotal{},
+		Drifts:            []entity.BalanceDrift{},
+		UnbalancedEntries: []entity.UnbalancedEntry{},
+	}
+
+	accounts, err := uc.repo.ListAccounts(ctx)
+	if err != nil {
+		return report, fmt.Errorf("ошибка получения аккаунтов: %w", err)
+	}
+	balances, err := uc.ledger.CustomerBalances(ctx)
+	if err != nil {
+		return report, fmt.Errorf("ошибка расчета остатков по проводкам: %w", err)
+	}
+	byAccount := make(map[uint]entity.CustomerLedgerBalance, len(balances))
+	for _, balance := range balances {
+		byAccount[balance.AccountID] = balance
+	}
+
+	report.AccountsChecked = len(accounts)
+	for _, account := range accounts {
+		ledgerBalance := byAccount[account.ID]
+		total := ledgerBalance.Available + ledgerBalance.Held
+		if account.Balance == total && account.Held == ledgerBalance.Held {
+			continue
+		}
+		report.Drifts = append(report.Drifts, entity.BalanceDrift{
+			AccountID:     account.ID,
+			UserID:        account.UserID,
+			StoredBalance: account.Balance,
+			LedgerBalance: total,
+			StoredHeld:    account.Held,
+			LedgerHeld:    ledgerBalance.Held,
+			Difference:    account.Balance - total,
+		})
+	}
+
+	totals, err := uc.ledger.Totals(ctx)
+	if err != nil {
+		return report, fmt.Errorf("ошибка расчета оборотов главной книги: %w", err)
+	}
+	report.Totals = append(report.Totals, totals...)
+	unbalanced, err := uc.ledger.UnbalancedEntries(ctx)
+	if err != nil {
+		return report, fmt.Errorf("ошибка проверки операций главной книги: %w", err)
+	}
+	report.UnbalancedEntries = append(report.UnbalancedEntries, unbalanced...)
+
+	report.Consistent = len(report.Drifts) == 0 && len(report.UnbalancedEntries) == 0
+	for _, total := range report.Totals {
+		if total.Debit != total.Credit {
+			report.Consistent = false
+		}
+	}
+	return report, nil
+}
+
+// RunReconciliation периодически сверяет балансы с главной книгой и пишет в лог найденные расхождения.
+// Останавливается при отмене ctx.
+func (uc *BillingUseCase) RunReconciliation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("[INFO] Сверка балансов с главной книгой запущена (интервал %s)", interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] Сверка балансов с главной книгой остановлена")
+			return
+		case <-ticker.C:
+			report, err := uc.Reconcile(ctx)
+			if err != nil {
+				log.Printf("[ERROR] Сверка балансов: %v", err)
+				continue
+			}
+			if report.Consistent {
+				continue
+			}
+			for _, drift := range report.Drifts {
+				log.Printf("[WARN] Сверка балансов: аккаунт %d (пользователь %d): баланс %s, по проводкам %s, заблокировано %s, по проводкам %s",
+					drift.AccountID, drift.UserID, drift.StoredBalance, drift.LedgerBalance, drift.StoredHeld, drift.LedgerHeld)
+			}
+			for _, entry := range report.UnbalancedEntries {
+				log.Printf("[WARN] Сверка балансов: операция %d не сбалансирована: дебет %s, кредит %s",
+					entry.JournalEntryID, entry.Debit, entry.Credit)
+			}
+			for _, total := range report.Totals {
+				if total.Debit != total.Credit {
+					log.Printf("[WARN] Сверка балансов: обороты главной книги в %s не совпадают: дебет %s, кредит %s",
+						total.Currency, total.Debit, total.Credit)
+				}
+			}
+		}
+	}
+}
+
+// post проводит операцию в главной книге и возвращает ее ID.
+// Все проводки операции должны быть в одной валюте, иначе баланс по валютам не сойдется.
+// Должна вызываться в транзакции вместе с изменением баланса аккаунта.
+func (uc *BillingUseCase) post(ctx context.Context, entryType entity.JournalEntryType, reference, description string, legs ...entity.LedgerLeg) (uint, error) {
+	var debit, credit money.Amount
+	for _, leg := range legs {
+		if leg.Currency != legs[0].Currency {
+			return 0, fmt.Errorf("%w: проводки в %s и %s", ErrUnbalancedEntry, legs[0].Currency, leg.Currency)
+		}
+		if leg.Amount <= 0 {
+			return 0, fmt.Errorf("%w: сумма проводки по счету %s должна быть положительной", ErrUnbalancedEntry, leg.Code)
+		}
+		if leg.Direction == entity.PostingDebit {
+			debit += leg.Amount
+		} else {
+			credit += leg.Amount
+		}
+	}
+	if len(legs) < 2 || debit != credit {
+		return 0, fmt.Errorf("%w: дебет %s, кредит %s", ErrUnbalancedEntry, debit, credit)
+	}
+
+	entry := &entity.JournalEntry{
+		Type:        entryType,
+		Reference:   reference,
+		Description: description,
+		CreatedAt:   time.Now(),
+	}
+	for _, leg := range legs {
+		account, err := uc.ledger.GetOrCreateAccount(ctx, leg)
+		if err != nil {
+			return 0, fmt.Errorf("ошибка получения счета главной книги %s: %w", leg.Code, err)
+		}
+		entry.Postings = append(entry.Postings, entity.Posting{
+			LedgerAccountID: account.ID,
+			Direction:       leg.Direction,
+			Amount:          leg.Amount,
+			CreatedAt:       entry.CreatedAt,
+		})
+	}
+
+	if err := uc.ledger.CreateEntry(ctx, entry); err != nil {
+		return 0, fmt.Errorf("ошибка проведения операции %s в главной книге: %w", entryType, err)
+	}
+	return entry.ID, nil
+}
+
+// outstandingHold возвращает сумму, заблокированную на счете аккаунта по ссылке reference
+func (uc *BillingUseCase) outstandingHold(ctx context.Context, account entity.Account, reference string) (money.Amount, error) {
+	leg := heldLeg(account, entity.PostingCredit, 0)
+	ledgerAccount, err := uc.ledger.GetOrCreateAccount(ctx, leg)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения счета главной книги %s: %w", leg.Code, err)
+	}
+	held, err := uc.ledger.AccountBalanceByReference(ctx, ledgerAccount.ID, reference)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка расчета заблокированной суммы: %w", err)
+	}
+	return held, nil
+}
+
+// chargedByReference возвращает сумму, списанную в выручку по ссылке reference и еще не возвращенную
+func (uc *BillingUseCase) chargedByReference(ctx context.Context, currency money.Currency, reference string) (money.Amount, error) {
+	leg := salesLeg(currency, entity.PostingCredit, 0)
+	ledgerAccount, err := uc.ledger.GetOrCreateAccount(ctx, leg)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения счета главной книги %s: %w", leg.Code, err)
+	}
+	charged, err := uc.ledger.AccountBalanceByReference(ctx, ledgerAccount.ID, reference)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка расчета списанной суммы: %w", err)
+	}
+	return charged, nil
+}
+
+// availableLeg проводка по счету доступных средств клиента
+func availableLeg(account entity.Account, direction entity.PostingDirection, amount money.Amount) entity.LedgerLeg {
+	return entity.LedgerLeg{
+		Code:      fmt.Sprintf("customer:%d:available", account.ID),
+		Kind:      entity.LedgerAccountCustomerAvailable,
+		AccountID: &account.ID,
+		Currency:  account.Currency,
+		Direction: direction,
+		Amount:    amount,
+	}
+}
+
+// heldLeg проводка по счету заблокированных средств клиента
+func heldLeg(account entity.Account, direction entity.PostingDirection, amount money.Amount) entity.LedgerLeg {
+	return entity.LedgerLeg{
+		Code:      fmt.Sprintf("customer:%d:held", account.ID),
+		Kind:      entity.LedgerAccountCustomerHeld,
+		AccountID: &account.ID,
+		Currency:  account.Currency,
+		Direction: direction,
+		Amount:    amount,
+	}
+}
+
+// externalLeg проводка по счету внешних поступлений в валюте currency
+func externalLeg(currency money.Currency, direction entity.PostingDirection, amount money.Amount) entity.LedgerLeg {
+	return entity.LedgerLeg{
+		Code:      systemLedgerCode(entity.LedgerCodeExternal, currency),
+		Kind:      entity.LedgerAccountExternal,
+		Currency:  currency,
+		Direction: direction,
+		Amount:    amount,
+	}
+}
+
+// salesLeg проводка по счету выручки в валюте currency
+func salesLeg(currency money.Currency, direction entity.PostingDirection, amount money.Amount) entity.LedgerLeg {
+	return entity.LedgerLeg{
+		Code:      systemLedgerCode(entity.LedgerCodeSales, currency),
+		Kind:      entity.LedgerAccountSales,
+		Currency:  currency,
+		Direction: direction,
+		Amount:    amount,
+	}
+}
+
+// systemLedgerCode код системного счета главной книги в валюте currency
+func systemLedgerCode(prefix string, currency money.Currency) string {
+	return prefix + ":" + string(currency)
+}
@@ -0,0 +1,251 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/director74/dz8_shop/billing-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// newFundedUseCase создает usecase с аккаунтом пользователя 1, пополненным на balance через главную книгу
+func newFundedUseCase(t *testing.T, balance money.Amount) (*BillingUseCase, *billingStore) {
+	store := &billingStore{}
+	store.addAccount(1, 0)
+	uc := newTestBillingUseCase(store)
+	if _, err := uc.Deposit(context.Background(), 1, balance, "", ""); err != nil {
+		t.Fatalf("не удалось пополнить счет: %v", err)
+	}
+	return uc, store
+}
+
+// assertConsistent проверяет, что балансы аккаунтов совпадают с главной книгой
+func assertConsistent(t *testing.T, uc *BillingUseCase) {
+	report, err := uc.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, report.Consistent, "сверка: %+v", report)
+}
+
+// TestHoldCaptureRefund тестирует блокировку, списание и возврат оплаты заказа
+func TestHoldCaptureRefund(t *testing.T) {
+	ctx := context.Background()
+	uc, store := newFundedUseCase(t, 100000)
+	reference := OrderReference(7)
+
+	hold, err := uc.Hold(ctx, 1, 30000, "", reference)
+	assert.NoError(t, err)
+	assert.True(t, hold.Success)
+	assert.Equal(t, money.Amount(30000), hold.Amount)
+	assert.Equal(t, money.Amount(100000), store.account(1).Balance)
+	assert.Equal(t, money.Amount(30000), store.account(1).Held)
+	assertConsistent(t, uc)
+
+	// Повторная блокировка по той же ссылке не блокирует сумму второй раз
+	hold, err = uc.Hold(ctx, 1, 30000, "", reference)
+	assert.NoError(t, err)
+	assert.True(t, hold.Success)
+	assert.Equal(t, money.Amount(30000), hold.Amount)
+	assert.Len(t, store.entriesOf(entity.JournalEntryHold), 1)
+	assert.Equal(t, money.Amount(30000), store.account(1).Held)
+
+	captured, err := uc.Capture(ctx, 1, reference)
+	assert.NoError(t, err)
+	assert.Equal(t, money.Amount(30000), captured.Amount)
+	assert.Equal(t, entity.TransactionTypeWithdrawal, captured.Type)
+	assert.Equal(t, money.Amount(70000), store.account(1).Balance)
+	assert.Zero(t, store.account(1).Held)
+	assertConsistent(t, uc)
+
+	refund, err := uc.Refund(ctx, 1, 30000, "", reference)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.TransactionTypeRefund, refund.Type)
+	assert.Equal(t, money.Amount(100000), store.account(1).Balance)
+
+	// Повторная компенсация не возвращает средства второй раз
+	refund, err = uc.Refund(ctx, 1, 30000, "", reference)
+	assert.NoError(t, err)
+	assert.Zero(t, refund.ID)
+	assert.Equal(t, money.Amount(100000), store.account(1).Balance)
+	assert.Len(t, store.entriesOf(entity.JournalEntryRefund), 1)
+	assertConsistent(t, uc)
+}
+
+// TestHold_InsufficientFunds тестирует неуспешную блокировку: средства не блокируются, сохраняется неуспешная транзакция
+func TestHold_InsufficientFunds(t *testing.T) {
+	ctx := context.Background()
+	uc, store := newFundedUseCase(t, 50000)
+	_, err := uc.Hold(ctx, 1, 40000, "", OrderReference(1))
+	assert.NoError(t, err)
+
+	// Доступно только 100.00: заблокированная под другой заказ сумма не учитывается
+	hold, err := uc.Hold(ctx, 1, 20000, "", OrderReference(2))
+
+	assert.NoError(t, err)
+	assert.False(t, hold.Success)
+	assert.NotZero(t, hold.TransactionID)
+	failed, err := store.GetTransactionByID(ctx, hold.TransactionID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.TransactionStatusFailed, failed.Status)
+	assert.Equal(t, money.Amount(40000), store.account(1).Held)
+	assert.Len(t, store.entriesOf(entity.JournalEntryHold), 1)
+	assertConsistent(t, uc)
+}
+
+// TestRelease тестирует снятие блокировки: баланс не меняется, списать снятую блокировку нельзя
+func TestRelease(t *testing.T) {
+	ctx := context.Background()
+	uc, store := newFundedUseCase(t, 100000)
+	reference := OrderReference(7)
+	_, err := uc.Hold(ctx, 1, 30000, "", reference)
+	assert.NoError(t, err)
+
+	assert.NoError(t, uc.Release(ctx, 1, reference))
+	assert.Equal(t, money.Amount(100000), store.account(1).Balance)
+	assert.Zero(t, store.account(1).Held)
+
+	// Повторное снятие ничего не меняет
+	assert.NoError(t, uc.Release(ctx, 1, reference))
+	assert.Len(t, store.entriesOf(entity.JournalEntryRelease), 1)
+
+	_, err = uc.Capture(ctx, 1, reference)
+	assert.ErrorIs(t, err, ErrHoldNotFound)
+	assertConsistent(t, uc)
+}
+
+// TestRelease_AfterCapture тестирует, что компенсация блокировки после списания не меняет баланс
+func TestRelease_AfterCapture(t *testing.T) {
+	ctx := context.Background()
+	uc, store := newFundedUseCase(t, 100000)
+	reference := OrderReference(7)
+	_, err := uc.Hold(ctx, 1, 30000, "", reference)
+	assert.NoError(t, err)
+	_, err = uc.Capture(ctx, 1, reference)
+	assert.NoError(t, err)
+
+	assert.NoError(t, uc.Release(ctx, 1, reference))
+
+	assert.Equal(t, money.Amount(70000), store.account(1).Balance)
+	assert.Empty(t, store.entriesOf(entity.JournalEntryRelease))
+}
+
+// TestRefund_ExceedsCharge тестирует отказ в возврате больше списанной по ссылке суммы
+func TestRefund_ExceedsCharge(t *testing.T) {
+	ctx := context.Background()
+	uc, store := newFundedUseCase(t, 100000)
+	reference := OrderReference(7)
+	_, err := uc.Hold(ctx, 1, 30000, "", reference)
+	assert.NoError(t, err)
+
+	// До списания возвращать нечего
+	_, err = uc.Refund(ctx, 1, 30000, "", reference)
+	assert.ErrorIs(t, err, ErrRefundExceedsCharge)
+
+	_, err = uc.Capture(ctx, 1, reference)
+	assert.NoError(t, err)
+
+	_, err = uc.Refund(ctx, 1, 30001, "", reference)
+	assert.ErrorIs(t, err, ErrRefundExceedsCharge)
+	// Списание по другому заказу не увеличивает сумму, доступную к возврату
+	_, err = uc.Refund(ctx, 1, 30000, "", OrderReference(8))
+	assert.ErrorIs(t, err, ErrRefundExceedsCharge)
+
+	_, err = uc.Refund(ctx, 1, 10000, "", "")
+	assert.Error(t, err)
+
+	assert.Equal(t, money.Amount(70000), store.account(1).Balance)
+	assert.Empty(t, store.entriesOf(entity.JournalEntryRefund))
+	assertConsistent(t, uc)
+}
+
+// TestPost тестирует проверку баланса операции главной книги
+func TestPost(t *testing.T) {
+	ctx := context.Background()
+	store := &billingStore{}
+	account := store.addAccount(1, 0)
+	uc := newTestBillingUseCase(store)
+
+	tests := []struct {
+		name string
+		legs []entity.LedgerLeg
+	}{
+		{
+			name: "дебет не равен кредиту",
+			legs: []entity.LedgerLeg{
+				externalLeg("RUB", entity.PostingDebit, 10000),
+				availableLeg(account, entity.PostingCredit, 9000),
+			},
+		},
+		{
+			name: "проводки в разных валютах",
+			legs: []entity.LedgerLeg{
+				externalLeg("USD", entity.PostingDebit, 10000),
+				availableLeg(account, entity.PostingCredit, 10000),
+			},
+		},
+		{
+			name: "нулевая сумма",
+			legs: []entity.LedgerLeg{
+				externalLeg("RUB", entity.PostingDebit, 0),
+				availableLeg(account, entity.PostingCredit, 0),
+			},
+		},
+		{
+			name: "одна проводка",
+			legs: []entity.LedgerLeg{externalLeg("RUB", entity.PostingDebit, 10000)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := uc.post(ctx, entity.JournalEntryDeposit, "", "тест", tt.legs...)
+			assert.ErrorIs(t, err, ErrUnbalancedEntry)
+		})
+	}
+	assert.Empty(t, store.entries)
+
+	entryID, err := uc.post(ctx, entity.JournalEntryDeposit, "ref", "тест",
+		externalLeg("RUB", entity.PostingDebit, 10000),
+		availableLeg(account, entity.PostingCredit, 10000))
+	assert.NoError(t, err)
+	if assert.Len(t, store.entries, 1) {
+		assert.Equal(t, entryID, store.entries[0].ID)
+		assert.Equal(t, "ref", store.entries[0].Reference)
+		assert.Len(t, store.entries[0].Postings, 2)
+	}
+}
+
+// TestReconcile тестирует обнаружение расхождений баланса с главной книгой и несбалансированных операций
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	uc, store := newFundedUseCase(t, 100000)
+	_, err := uc.Hold(ctx, 1, 30000, "", OrderReference(7))
+	assert.NoError(t, err)
+	store.addAccount(2, 0)
+	assertConsistent(t, uc)
+
+	// Баланс изменен в обход главной книги, а одна из операций потеряла проводку
+	store.accounts[0].Balance += 5000
+	store.accounts[0].Held -= 1000
+	store.entries[0].Postings = store.entries[0].Postings[:1]
+
+	report, err := uc.Reconcile(ctx)
+
+	assert.NoError(t, err)
+	assert.False(t, report.Consistent)
+	assert.Equal(t, 2, report.AccountsChecked)
+	if assert.Len(t, report.Drifts, 1) {
+		drift := report.Drifts[0]
+		assert.Equal(t, uint(1), drift.UserID)
+		assert.Equal(t, money.Amount(105000), drift.StoredBalance)
+		assert.Equal(t, money.Amount(29000), drift.StoredHeld)
+		assert.Equal(t, money.Amount(30000), drift.LedgerHeld)
+	}
+	if assert.Len(t, report.UnbalancedEntries, 1) {
+		assert.Equal(t, store.entries[0].ID, report.UnbalancedEntries[0].JournalEntryID)
+	}
+	if assert.Len(t, report.Totals, 1) {
+		assert.NotEqual(t, report.Totals[0].Debit, report.Totals[0].Credit)
+	}
+}
@@ -0,0 +1,150 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/director74/dz8_shop/billing-service/internal/entity"
+)
+
+const (
+	// defaultTransactionsLimit размер страницы истории транзакций по умолчанию
+	defaultTransactionsLimit = 20
+	// maxTransactionsLimit максимальный размер страницы истории транзакций
+	maxTransactionsLimit = 100
+	// statementPeriodLayout формат месяца выписки
+	statementPeriodLayout = "2006-01"
+)
+
+// ErrInvalidPeriod некорректный период истории транзакций или выписки
+var ErrInvalidPeriod = errors.New("некорректный период")
+
+// ListTransactions возвращает историю транзакций аккаунта пользователя, новые транзакции первыми
+func (uc *BillingUseCase) ListTransactions(ctx context.Context, userID uint, query entity.ListTransactionsQuery) (entity.ListTransactionsResponse, error) {
+	account, err := uc.repo.GetAccountByUserID(ctx, userID)
+	if err != nil {
+		return entity.ListTransactionsResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
+	}
+
+	filter := entity.TransactionFilter{
+		AccountID: account.ID,
+		Type:      query.Type,
+		Status:    query.Status,
+		From:      query.From,
+		Limit:     query.Limit,
+		Offset:    query.Offset,
+	}
+	if !query.To.IsZero() {
+		// Дата окончания включается в период целиком
+		filter.To = query.To.AddDate(0, 0, 1)
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return entity.ListTransactionsResponse{}, fmt.Errorf("%w: дата начала позже даты окончания", ErrInvalidPeriod)
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultTransactionsLimit
+	}
+	if filter.Limit > maxTransactionsLimit {
+		filter.Limit = maxTransactionsLimit
+	}
+
+	transactions, total, err := uc.repo.ListTransactions(ctx, filter)
+	if err != nil {
+		return entity.ListTransactionsResponse{}, fmt.Errorf("ошибка при получении истории транзакций: %w", err)
+	}
+
+	response := entity.ListTransactionsResponse{
+		Transactions: make([]entity.TransactionResponse, 0, len(transactions)),
+		Total:        total,
+		Limit:        filter.Limit,
+		Offset:       filter.Offset,
+	}
+	for _, transaction := range transactions {
+		response.Transactions = append(response.Transactions, toTransactionResponse(transaction))
+	}
+	return response, nil
+}
+
+// GetStatement возвращает выписку по аккаунту пользователя за месяц period (YYYY-MM).
+// Баланс на начало и конец периода восстанавливается от текущего баланса вычитанием последующих изменений,
+// поэтому выписка согласована с балансом аккаунта, даже если часть ранней истории отсутствует.
+func (uc *BillingUseCase) GetStatement(ctx context.Context, userID uint, period string) (entity.StatementResponse, error) {
+	from, err := time.ParseInLocation(statementPeriodLayout, period, time.Local)
+	if err != nil {
+		return entity.StatementResponse{}, fmt.Errorf("%w: ожидается месяц в формате YYYY-MM", ErrInvalidPeriod)
+	}
+	to := from.AddDate(0, 1, 0)
+
+	account, err := uc.repo.GetAccountByUserID(ctx, userID)
+	if err != nil {
+		return entity.StatementResponse{}, fmt.Errorf("аккаунт не найден: %w", err)
+	}
+
+	changedSinceFrom, err := uc.repo.SumBalanceChangesSince(ctx, account.ID, from)
+	if err != nil {
+		return entity.StatementResponse{}, fmt.Errorf("ошибка при расчете баланса на начало периода: %w", err)
+	}
+	changedSinceTo, err := uc.repo.SumBalanceChangesSince(ctx, account.ID, to)
+	if err != nil {
+		return entity.StatementResponse{}, fmt.Errorf("ошибка при расчете баланса на конец периода: %w", err)
+	}
+
+	transactions, _, err := uc.repo.ListTransactions(ctx, entity.TransactionFilter{
+		AccountID: account.ID,
+		From:      from,
+		To:        to,
+		Ascending: true,
+	})
+	if err != nil {
+		return entity.StatementResponse{}, fmt.Errorf("ошибка при получении транзакций за период: %w", err)
+	}
+
+	statement := entity.StatementResponse{
+		AccountID:      account.ID,
+		UserID:         account.UserID,
+		Period:         period,
+		Currency:       account.Currency,
+		From:           from,
+		To:             to,
+		OpeningBalance: account.Balance - changedSinceFrom,
+		ClosingBalance: account.Balance - changedSinceTo,
+		Transactions:   make([]entity.StatementLine, 0, len(transactions)),
+	}
+
+	balance := statement.OpeningBalance
+	for _, transaction := range transactions {
+		if transaction.Status != entity.TransactionStatusFailed {
+			balance += transaction.Amount
+			if transaction.Amount >= 0 {
+				statement.TotalDeposits += transaction.Amount
+			} else {
+				statement.TotalWithdrawals -= transaction.Amount
+			}
+		}
+		statement.Transactions = append(statement.Transactions, entity.StatementLine{
+			TransactionResponse: toTransactionResponse(transaction),
+			BalanceAfter:        balance,
+		})
+	}
+
+	return statement, nil
+}
+
+// toTransactionResponse преобразует транзакцию в ответ API.
+// Сумма всегда положительна, направление движения средств определяется типом транзакции.
+func toTransactionResponse(transaction entity.Transaction) entity.TransactionResponse {
+	return entity.TransactionResponse{
+		ID:               transaction.ID,
+		AccountID:        transaction.AccountID,
+		Amount:           transaction.Amount.Abs(),
+		Currency:         transaction.Currency.OrDefault(),
+		OriginalAmount:   transaction.OriginalAmount,
+		OriginalCurrency: transaction.OriginalCurrency,
+		ExchangeRate:     transaction.ExchangeRate,
+		Type:             transaction.Type,
+		Status:           transaction.Status,
+		CreatedAt:        transaction.CreatedAt,
+	}
+}
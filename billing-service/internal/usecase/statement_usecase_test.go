@@ -0,0 +1,86 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/director74/dz8_shop/billing-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// TestGetStatement тестирует балансы на начало и конец месяца и баланс после каждой транзакции
+func TestGetStatement(t *testing.T) {
+	store := &billingStore{}
+	// Текущий баланс 1000 - 200 + 500 - 150 + 300 = 1450
+	account := store.addAccount(1, 145000)
+	march := func(day int) time.Time { return time.Date(2026, time.March, day, 12, 0, 0, 0, time.Local) }
+
+	store.addTransaction(account.ID, 100000, entity.TransactionTypeDeposit, entity.TransactionStatusSuccess, march(1).AddDate(0, -1, 0))
+	store.addTransaction(account.ID, -20000, entity.TransactionTypeWithdrawal, entity.TransactionStatusSuccess, march(28).AddDate(0, -1, 0))
+	store.addTransaction(account.ID, 50000, entity.TransactionTypeDeposit, entity.TransactionStatusSuccess, march(3))
+	store.addTransaction(account.ID, -70000, entity.TransactionTypeWithdrawal, entity.TransactionStatusFailed, march(10))
+	store.addTransaction(account.ID, -15000, entity.TransactionTypeWithdrawal, entity.TransactionStatusSuccess, march(20))
+	store.addTransaction(account.ID, 30000, entity.TransactionTypeDeposit, entity.TransactionStatusSuccess, march(2).AddDate(0, 1, 0))
+
+	statement, err := newTestBillingUseCase(store).GetStatement(context.Background(), 1, "2026-03")
+
+	assert.NoError(t, err)
+	assert.Equal(t, account.ID, statement.AccountID)
+	assert.Equal(t, time.Date(2026, time.March, 1, 0, 0, 0, 0, time.Local), statement.From)
+	assert.Equal(t, time.Date(2026, time.April, 1, 0, 0, 0, 0, time.Local), statement.To)
+	assert.Equal(t, money.Amount(80000), statement.OpeningBalance)
+	assert.Equal(t, money.Amount(115000), statement.ClosingBalance)
+	assert.Equal(t, money.Amount(50000), statement.TotalDeposits)
+	assert.Equal(t, money.Amount(15000), statement.TotalWithdrawals)
+
+	if assert.Len(t, statement.Transactions, 3) {
+		assert.Equal(t, money.Amount(130000), statement.Transactions[0].BalanceAfter)
+		// Неуспешная транзакция попадает в выписку, но не меняет баланс
+		assert.Equal(t, entity.TransactionStatusFailed, statement.Transactions[1].Status)
+		assert.Equal(t, money.Amount(70000), statement.Transactions[1].Amount)
+		assert.Equal(t, money.Amount(130000), statement.Transactions[1].BalanceAfter)
+		assert.Equal(t, money.Amount(115000), statement.Transactions[2].BalanceAfter)
+	}
+}
+
+// TestGetStatement_EmptyPeriod тестирует выписку за месяц без транзакций
+func TestGetStatement_EmptyPeriod(t *testing.T) {
+	store := &billingStore{}
+	account := store.addAccount(1, 50000)
+	store.addTransaction(account.ID, 50000, entity.TransactionTypeDeposit, entity.TransactionStatusSuccess,
+		time.Date(2026, time.January, 15, 0, 0, 0, 0, time.Local))
+
+	statement, err := newTestBillingUseCase(store).GetStatement(context.Background(), 1, "2026-02")
+
+	assert.NoError(t, err)
+	assert.Equal(t, money.Amount(50000), statement.OpeningBalance)
+	assert.Equal(t, money.Amount(50000), statement.ClosingBalance)
+	assert.Empty(t, statement.Transactions)
+}
+
+// TestGetStatement_InvalidPeriod тестирует отказ для периода не в формате YYYY-MM
+func TestGetStatement_InvalidPeriod(t *testing.T) {
+	store := &billingStore{}
+	store.addAccount(1, 0)
+
+	for _, period := range []string{"", "2026-13", "2026-03-01", "март"} {
+		_, err := newTestBillingUseCase(store).GetStatement(context.Background(), 1, period)
+		assert.ErrorIs(t, err, ErrInvalidPeriod, period)
+	}
+}
+
+// TestListTransactions_InvalidPeriod тестирует отказ, если дата начала позже даты окончания
+func TestListTransactions_InvalidPeriod(t *testing.T) {
+	store := &billingStore{}
+	store.addAccount(1, 0)
+
+	_, err := newTestBillingUseCase(store).ListTransactions(context.Background(), 1, entity.ListTransactionsQuery{
+		From: time.Date(2026, time.March, 10, 0, 0, 0, 0, time.Local),
+		To:   time.Date(2026, time.March, 1, 0, 0, 0, 0, time.Local),
+	})
+
+	assert.ErrorIs(t, err, ErrInvalidPeriod)
+}
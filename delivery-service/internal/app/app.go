@@ -15,7 +15,9 @@ import (
 	"github.com/director74/dz8_shop/delivery-service/internal/controller/rabbitmq"
 	"github.com/director74/dz8_shop/delivery-service/internal/repo"
 	"github.com/director74/dz8_shop/delivery-service/internal/usecase"
+	"github.com/director74/dz8_shop/pkg/messaging"
 	pkgRabbitMQ "github.com/director74/dz8_shop/pkg/rabbitmq"
+	"github.com/director74/dz8_shop/pkg/sagahandler"
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -57,9 +59,10 @@ func NewApp(config *config.Config) (*App, error) {
 	router := gin.Default()
 	deliveryHandler := httpController.NewDeliveryHandler(deliveryUseCase)
 	deliveryHandler.RegisterRoutes(router)
+	messaging.RegisterDeadLetterAdmin(router, rabbitMQ)
 
 	// Инициализируем обработчик сообщений саги
-	sagaConsumer := rabbitmq.NewSagaConsumer(deliveryUseCase, rabbitMQ)
+	sagaConsumer := rabbitmq.NewSagaConsumer(deliveryUseCase, rabbitMQ, sagahandler.NewLedger(db))
 
 	return &App{
 		httpServer: &http.Server{
@@ -149,6 +152,10 @@ func initRabbitMQ(config *config.Config) (*pkgRabbitMQ.RabbitMQ, error) {
 		Host:     config.RabbitMQ.Host,
 		Port:     config.RabbitMQ.Port,
 		VHost:    config.RabbitMQ.VHost,
+
+		MandatoryExchanges:  config.RabbitMQ.MandatoryExchanges,
+		MaxRedeliveries:     config.RabbitMQ.MaxRedeliveries,
+		RedeliveryBaseDelay: config.RabbitMQ.RedeliveryBaseDelay,
 	}
 
 	rabbitMQ, err := pkgRabbitMQ.NewRabbitMQ(rabbitConfig)
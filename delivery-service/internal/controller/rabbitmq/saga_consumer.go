@@ -17,17 +17,28 @@ import (
 // SagaConsumer обработчик сообщений саги для доставки
 type SagaConsumer struct {
 	sagahandler.BaseSagaConsumer
+	confirm         *sagahandler.BaseSagaConsumer // Обработчик шага confirm_order, результат которого публикуется после доставки
 	deliveryUseCase *usecase.DeliveryUseCase
 }
 
 // NewSagaConsumer создает новый обработчик сообщений саги для доставки
-func NewSagaConsumer(deliveryUseCase *usecase.DeliveryUseCase, rabbitMQ *rabbitmq.RabbitMQ) *SagaConsumer {
+func NewSagaConsumer(deliveryUseCase *usecase.DeliveryUseCase, rabbitMQ *rabbitmq.RabbitMQ, ledger *sagahandler.Ledger) *SagaConsumer {
+	confirm := &sagahandler.BaseSagaConsumer{
+		RabbitMQ: rabbitMQ,
+		Logger:   log.New(log.Writer(), "[DeliveryService] [Saga] ", log.LstdFlags),
+		Step:     "confirm_order",
+		Ledger:   ledger,
+	}
+	deliveryUseCase.UseSagaResultPublisher(confirm)
+
 	return &SagaConsumer{
 		BaseSagaConsumer: sagahandler.BaseSagaConsumer{
 			RabbitMQ: rabbitMQ,
 			Logger:   log.New(log.Writer(), "[DeliveryService] [Saga] ", log.LstdFlags),
 			Step:     "reserve_delivery",
+			Ledger:   ledger,
 		},
+		confirm:         confirm,
 		deliveryUseCase: deliveryUseCase,
 	}
 }
@@ -220,7 +231,7 @@ func (c *SagaConsumer) SetupConfirmConsumer() error {
 	}
 
 	consumerTag := "delivery_confirm_consumer_" + queueName
-	err = c.RabbitMQ.ConsumeMessages(queueName, consumerTag, c.handleConfirmDelivery)
+	err = c.RabbitMQ.ConsumeMessages(queueName, consumerTag, c.confirm.Idempotent(sagahandler.OperationExecute, c.handleConfirmDelivery))
 	if err != nil {
 		return fmt.Errorf("ошибка при запуске consumer'а для очереди %s: %w", queueName, err)
 	}
@@ -242,7 +253,7 @@ func (c *SagaConsumer) handleConfirmDelivery(data []byte) error {
 	var sagaData sagahandler.SagaData
 	if err := json.Unmarshal(message.Data, &sagaData); err != nil {
 		c.Logger.Printf("SagaID=%s: [ERROR] Ошибка десериализации данных заказа при подтверждении: %v", message.SagaID, err)
-		_ = c.PublishFailureResult(message.SagaID, fmt.Sprintf("ошибка десериализации данных заказа: %v", err))
+		_ = c.confirm.PublishFailureResult(message.SagaID, fmt.Sprintf("ошибка десериализации данных заказа: %v", err))
 		return fmt.Errorf("ошибка десериализации данных заказа: %w", err)
 	}
 
@@ -262,13 +273,13 @@ func (c *SagaConsumer) handleConfirmDelivery(data []byte) error {
 	if err != nil {
 		c.Logger.Printf("SagaID=%s: [ERROR] Ошибка при вызове ConfirmForSaga для OrderID=%d: %v", message.SagaID, sagaData.OrderID, err)
 		// Публикуем неудачный результат обратно в order-service
-		_ = c.PublishFailureResultWithData(message.SagaID, fmt.Sprintf("ошибка подтверждения доставки: %v", err), message.Data)
+		_ = c.confirm.PublishFailureResultWithData(message.SagaID, fmt.Sprintf("ошибка подтверждения доставки: %v", err), message.Data)
 		return err // Возвращаем ошибку, чтобы RabbitMQ знал о проблеме
 	}
 
 	// Если ConfirmForSaga вернул nil, значит команда принята к исполнению.
 	// Мы не отправляем SuccessResult здесь, так как фактический результат шага
-	// (доставка завершена) придет позже от simulateDeliveryCompletion.
+	// (доставка завершена) придет позже от simulateDeliveryCompletion и будет сохранен в журнал команд.
 	c.Logger.Printf("SagaID=%s: Команда confirm_order для OrderID=%d принята к исполнению.", message.SagaID, sagaData.OrderID)
 	return nil // Возвращаем nil, чтобы подтвердить получение сообщения RabbitMQ
 }
@@ -12,11 +12,17 @@ import (
 	"github.com/director74/dz8_shop/pkg/sagahandler"
 )
 
+// SagaResultPublisher публикует результат шага саги, отправляемый после завершения обработчика команды
+type SagaResultPublisher interface {
+	PublishResult(message sagahandler.SagaMessage) error
+}
+
 // DeliveryUseCase бизнес-логика для работы с доставкой
 type DeliveryUseCase struct {
 	repo         *repo.DeliveryRepo
 	publisher    messaging.MessagePublisher
 	exchangeName string
+	sagaResults  SagaResultPublisher
 }
 
 // NewDeliveryUseCase создает новый use case для доставки
@@ -28,6 +34,12 @@ func NewDeliveryUseCase(repo *repo.DeliveryRepo, publisher messaging.MessagePubl
 	}
 }
 
+// UseSagaResultPublisher задает публикатор результатов шагов саги, завершающихся асинхронно.
+// Он сохраняет результат в журнал обработанных команд, чтобы повторная доставка команды получила его снова.
+func (u *DeliveryUseCase) UseSagaResultPublisher(publisher SagaResultPublisher) {
+	u.sagaResults = publisher
+}
+
 // GetDeliveryByID получает информацию о доставке по ID
 func (u *DeliveryUseCase) GetDeliveryByID(id uint) (*entity.GetDeliveryResponse, error) {
 	delivery, err := u.repo.GetDeliveryByID(id)
@@ -345,10 +357,15 @@ func (u *DeliveryUseCase) publishSagaResult(sagaID, stepName, status string, sag
 		Data:      dataBytes,
 		Error:     errorMsg,
 		Timestamp: time.Now().Unix(),
+		Version:   sagahandler.SchemaVersion,
 	}
 
-	// Используем тот же publisher, что и для других сообщений
-	err = messaging.PublishWithRetryAndLogging(u.publisher, u.exchangeName, routingKey, message, 3)
+	if u.sagaResults != nil {
+		err = u.sagaResults.PublishResult(message)
+	} else {
+		// Используем тот же publisher, что и для других сообщений
+		err = messaging.PublishWithRetryAndLogging(u.publisher, u.exchangeName, routingKey, message, 3)
+	}
 	if err != nil {
 		fmt.Printf("[Ошибка] (SagaID: %s) Не удалось опубликовать результат (%s) шага %s: %v\\n", sagaID, status, stepName, err)
 	}
@@ -0,0 +1,12 @@
+-- Журнал обработанных команд саги: защищает шаги от повторного выполнения при повторной доставке
+CREATE TABLE IF NOT EXISTS saga_processed_messages (
+    saga_id VARCHAR(255) NOT NULL,
+    step_name VARCHAR(255) NOT NULL,
+    operation VARCHAR(50) NOT NULL, -- execute, compensate
+    status VARCHAR(20) NOT NULL, -- processing, completed
+    routing_key VARCHAR(255),
+    result JSONB, -- опубликованный результат шага
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (saga_id, step_name, operation)
+);
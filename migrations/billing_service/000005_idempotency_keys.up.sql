@@ -0,0 +1,15 @@
+-- Ответы на запросы с заголовком Idempotency-Key: повтор запроса возвращает сохраненный ответ
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope VARCHAR(255) NOT NULL, -- пользователь и маршрут запроса
+    key VARCHAR(255) NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL, -- sha256 метода, пути и тела запроса
+    status VARCHAR(20) NOT NULL, -- processing, completed
+    response_code INTEGER NOT NULL DEFAULT 0,
+    content_type VARCHAR(255),
+    response_body BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_updated_at ON idempotency_keys(updated_at);
@@ -0,0 +1,5 @@
+ALTER TABLE IF EXISTS transactions DROP COLUMN IF EXISTS journal_entry_id;
+ALTER TABLE IF EXISTS accounts DROP COLUMN IF EXISTS held;
+DROP TABLE IF EXISTS postings;
+DROP TABLE IF EXISTS journal_entries;
+DROP TABLE IF EXISTS ledger_accounts;
@@ -0,0 +1,71 @@
+-- Главная книга двойной записи: каждая операция с деньгами - сбалансированный набор проводок
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(100) NOT NULL UNIQUE, -- customer:{account_id}:available, customer:{account_id}:held, system:*
+    kind VARCHAR(30) NOT NULL, -- customer_available, customer_held, external, sales, equity
+    account_id INTEGER REFERENCES accounts(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_accounts_account_id ON ledger_accounts(account_id);
+
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id SERIAL PRIMARY KEY,
+    type VARCHAR(30) NOT NULL, -- deposit, withdrawal, refund, hold, capture, release, opening_balance
+    reference VARCHAR(255), -- внешний идентификатор операции: заказ, исходная транзакция
+    description TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_journal_entries_reference ON journal_entries(reference);
+
+CREATE TABLE IF NOT EXISTS postings (
+    id SERIAL PRIMARY KEY,
+    journal_entry_id INTEGER NOT NULL REFERENCES journal_entries(id) ON DELETE CASCADE,
+    ledger_account_id INTEGER NOT NULL REFERENCES ledger_accounts(id),
+    direction VARCHAR(10) NOT NULL CHECK (direction IN ('debit', 'credit')),
+    amount DECIMAL(12, 2) NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_postings_journal_entry_id ON postings(journal_entry_id);
+CREATE INDEX IF NOT EXISTS idx_postings_ledger_account_id ON postings(ledger_account_id);
+
+-- Заблокированная под заказы часть баланса: доступно balance - held
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS held DECIMAL(12, 2) NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS journal_entry_id INTEGER REFERENCES journal_entries(id);
+
+-- Системные счета
+INSERT INTO ledger_accounts (code, kind) VALUES
+('system:external', 'external'),
+('system:sales', 'sales'),
+('system:opening_balance', 'equity')
+ON CONFLICT (code) DO NOTHING;
+
+-- Клиентские счета для существующих аккаунтов
+INSERT INTO ledger_accounts (code, kind, account_id)
+SELECT 'customer:' || id || ':available', 'customer_available', id FROM accounts
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO ledger_accounts (code, kind, account_id)
+SELECT 'customer:' || id || ':held', 'customer_held', id FROM accounts
+ON CONFLICT (code) DO NOTHING;
+
+-- Перенос накопленных балансов во входящие остатки книги, чтобы сверка сходилась с первого запуска
+DO $$
+DECLARE
+    acc RECORD;
+    entry_id INTEGER;
+BEGIN
+    FOR acc IN SELECT id, balance FROM accounts WHERE balance > 0 AND deleted_at IS NULL LOOP
+        INSERT INTO journal_entries (type, reference, description)
+        VALUES ('opening_balance', 'account:' || acc.id, 'Входящий остаток')
+        RETURNING id INTO entry_id;
+
+        INSERT INTO postings (journal_entry_id, ledger_account_id, direction, amount)
+        SELECT entry_id, id, 'debit', acc.balance FROM ledger_accounts WHERE code = 'system:opening_balance';
+
+        INSERT INTO postings (journal_entry_id, ledger_account_id, direction, amount)
+        SELECT entry_id, id, 'credit', acc.balance FROM ledger_accounts WHERE code = 'customer:' || acc.id || ':available';
+    END LOOP;
+END $$;
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS exchange_rates;
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'ledger_accounts') THEN
+        UPDATE ledger_accounts SET code = left(code, length(code) - 4)
+        WHERE code IN ('system:external:RUB', 'system:sales:RUB', 'system:opening_balance:RUB');
+    END IF;
+END $$;
+ALTER TABLE IF EXISTS ledger_accounts DROP COLUMN IF EXISTS currency;
+ALTER TABLE IF EXISTS transactions DROP COLUMN IF EXISTS exchange_rate;
+ALTER TABLE IF EXISTS transactions DROP COLUMN IF EXISTS original_currency;
+ALTER TABLE IF EXISTS transactions DROP COLUMN IF EXISTS original_amount;
+ALTER TABLE IF EXISTS transactions DROP COLUMN IF EXISTS currency;
+ALTER TABLE IF EXISTS accounts DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,27 @@
+-- Валюта счета: все существующие счета и операции ведутся в рублях
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'RUB';
+
+-- Сумма списания хранится в валюте счета; для заказов в другой валюте сохраняются исходная сумма и курс
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'RUB';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS original_amount DECIMAL(12, 2) NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS original_currency VARCHAR(3) NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS exchange_rate VARCHAR(32) NOT NULL DEFAULT '';
+
+-- Счета главной книги ведутся в одной валюте, системные счета - отдельно по каждой валюте
+ALTER TABLE ledger_accounts ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'RUB';
+UPDATE ledger_accounts SET code = code || ':RUB'
+WHERE code IN ('system:external', 'system:sales', 'system:opening_balance');
+
+-- Курсы обмена: единиц quote_currency за единицу base_currency
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    base_currency VARCHAR(3) NOT NULL,
+    quote_currency VARCHAR(3) NOT NULL,
+    rate VARCHAR(32) NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (base_currency, quote_currency)
+);
+
+INSERT INTO exchange_rates (base_currency, quote_currency, rate) VALUES
+('USD', 'RUB', '90'),
+('EUR', 'RUB', '100')
+ON CONFLICT (base_currency, quote_currency) DO NOTHING;
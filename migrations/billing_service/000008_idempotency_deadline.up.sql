@@ -0,0 +1,3 @@
+-- Крайний срок выполнения запроса, захватившего ключ идемпотентности: до него повтор получает 409,
+-- после него выполнение может быть перехвачено
+ALTER TABLE IF EXISTS idempotency_keys ADD COLUMN IF NOT EXISTS processing_deadline TIMESTAMP NOT NULL DEFAULT NOW();
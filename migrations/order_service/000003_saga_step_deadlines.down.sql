@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_saga_states_step_deadline;
+
+ALTER TABLE saga_states DROP COLUMN IF EXISTS step_payload;
+ALTER TABLE saga_states DROP COLUMN IF EXISTS step_attempts;
+ALTER TABLE saga_states DROP COLUMN IF EXISTS step_deadline;
+ALTER TABLE saga_states DROP COLUMN IF EXISTS current_step;
@@ -0,0 +1,7 @@
+-- Дедлайны шагов саги для обнаружения зависших саг
+ALTER TABLE saga_states ADD COLUMN IF NOT EXISTS current_step VARCHAR(100);
+ALTER TABLE saga_states ADD COLUMN IF NOT EXISTS step_deadline TIMESTAMP;
+ALTER TABLE saga_states ADD COLUMN IF NOT EXISTS step_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE saga_states ADD COLUMN IF NOT EXISTS step_payload JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_saga_states_step_deadline ON saga_states(step_deadline);
@@ -0,0 +1 @@
+ALTER TABLE saga_states DROP COLUMN IF EXISTS saga_type;
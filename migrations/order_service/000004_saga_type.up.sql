@@ -0,0 +1,2 @@
+-- Имя определения саги, по которому выполняется сага
+ALTER TABLE saga_states ADD COLUMN IF NOT EXISTS saga_type VARCHAR(100) NOT NULL DEFAULT 'order';
@@ -0,0 +1,31 @@
+-- Состояние отдельных шагов саги (независимые шаги выполняются параллельно)
+CREATE TABLE IF NOT EXISTS saga_steps (
+    id SERIAL PRIMARY KEY,
+    saga_id VARCHAR(255) NOT NULL,
+    step_name VARCHAR(100) NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'running', -- running, completed, failed, timed_out
+    deadline TIMESTAMP,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    payload JSONB,
+    result JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_saga_steps_saga FOREIGN KEY (saga_id) REFERENCES saga_states(saga_id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_saga_steps_saga_step ON saga_steps(saga_id, step_name);
+CREATE INDEX IF NOT EXISTS idx_saga_steps_deadline ON saga_steps(deadline);
+
+-- Переносим ожидаемые шаги выполняющихся саг. Предшествующие шаги считаются выполненными,
+-- так как шаг отправляется только после завершения своих зависимостей.
+INSERT INTO saga_steps (saga_id, step_name, status, deadline, attempts, payload)
+SELECT saga_id, current_step, 'running', step_deadline, step_attempts, step_payload
+FROM saga_states
+WHERE current_step IS NOT NULL AND current_step <> ''
+ON CONFLICT (saga_id, step_name) DO NOTHING;
+
+DROP INDEX IF EXISTS idx_saga_states_step_deadline;
+ALTER TABLE saga_states DROP COLUMN IF EXISTS step_payload;
+ALTER TABLE saga_states DROP COLUMN IF EXISTS step_attempts;
+ALTER TABLE saga_states DROP COLUMN IF EXISTS step_deadline;
+ALTER TABLE saga_states DROP COLUMN IF EXISTS current_step;
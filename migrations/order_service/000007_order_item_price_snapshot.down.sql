@@ -0,0 +1 @@
+ALTER TABLE IF EXISTS order_items DROP COLUMN IF EXISTS sku;
@@ -0,0 +1,2 @@
+-- Снимок товара из каталога склада в позиции заказа: цена и название берутся из каталога при создании заказа
+ALTER TABLE IF EXISTS order_items ADD COLUMN IF NOT EXISTS sku VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE IF EXISTS order_items ALTER COLUMN price TYPE NUMERIC;
@@ -0,0 +1,3 @@
+-- Цены позиций заказа хранятся с точностью до копеек, как и остальные денежные колонки (DECIMAL(12,2)).
+-- Колонка создавалась автомиграцией как NUMERIC без масштаба; существующие значения округляются до копеек.
+ALTER TABLE IF EXISTS order_items ALTER COLUMN price TYPE DECIMAL(12, 2) USING ROUND(price::numeric, 2);
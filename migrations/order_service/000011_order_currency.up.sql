@@ -0,0 +1,2 @@
+-- Валюта заказа: существующие заказы оформлены в рублях
+ALTER TABLE IF EXISTS orders ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'RUB';
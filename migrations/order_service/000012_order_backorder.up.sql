@@ -0,0 +1,2 @@
+-- Ожидаемая дата поступления товаров заказа, принятого под поступление (статус backordered)
+ALTER TABLE IF EXISTS orders ADD COLUMN IF NOT EXISTS expected_available_at TIMESTAMP;
@@ -0,0 +1 @@
+ALTER TABLE IF EXISTS payments DROP COLUMN IF EXISTS currency;
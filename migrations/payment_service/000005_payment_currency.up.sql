@@ -0,0 +1,2 @@
+-- Валюта платежа: существующие платежи выполнены в рублях
+ALTER TABLE IF EXISTS payments ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'RUB';
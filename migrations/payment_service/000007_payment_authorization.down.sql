@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_payments_authorization_expires_at;
+ALTER TABLE IF EXISTS payments DROP COLUMN IF EXISTS authorization_expires_at;
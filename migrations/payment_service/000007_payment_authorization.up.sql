@@ -0,0 +1,5 @@
+-- Авторизация платежа в саге: сумма блокируется на шаге process_payment и списывается при подтверждении заказа.
+-- Новые статусы платежа: authorized, voided, expired
+ALTER TABLE IF EXISTS payments ADD COLUMN IF NOT EXISTS authorization_expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_payments_authorization_expires_at ON payments(authorization_expires_at);
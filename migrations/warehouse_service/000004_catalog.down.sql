@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_warehouse_items_search;
+DROP INDEX IF EXISTS idx_warehouse_items_price;
+DROP INDEX IF EXISTS idx_warehouse_items_category_id;
+ALTER TABLE IF EXISTS warehouse_items DROP COLUMN IF EXISTS category_id;
+DROP TABLE IF EXISTS product_images;
+DROP TABLE IF EXISTS categories;
@@ -0,0 +1,55 @@
+-- Каталог товаров: категории, изображения и полнотекстовый поиск по карточкам товаров на складе
+CREATE TABLE IF NOT EXISTS categories (
+    id SERIAL PRIMARY KEY,
+    parent_id INTEGER REFERENCES categories(id) ON DELETE SET NULL,
+    name VARCHAR(255) NOT NULL,
+    slug VARCHAR(255) NOT NULL UNIQUE,
+    description TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_categories_parent_id ON categories(parent_id);
+
+CREATE TABLE IF NOT EXISTS product_images (
+    id SERIAL PRIMARY KEY,
+    warehouse_item_id INTEGER NOT NULL REFERENCES warehouse_items(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_images_warehouse_item_id ON product_images(warehouse_item_id);
+
+ALTER TABLE IF EXISTS warehouse_items ADD COLUMN IF NOT EXISTS description TEXT;
+ALTER TABLE IF EXISTS warehouse_items ADD COLUMN IF NOT EXISTS category_id INTEGER REFERENCES categories(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_warehouse_items_category_id ON warehouse_items(category_id);
+CREATE INDEX IF NOT EXISTS idx_warehouse_items_price ON warehouse_items(price, id);
+-- Выражение должно совпадать с выражением поиска в CatalogRepo
+CREATE INDEX IF NOT EXISTS idx_warehouse_items_search ON warehouse_items
+    USING GIN (to_tsvector('russian', coalesce(name, '') || ' ' || coalesce(description, '') || ' ' || coalesce(sku, '')));
+
+-- Тестовые категории
+INSERT INTO categories (name, slug, description) VALUES
+('Электроника', 'electronics', 'Смартфоны, компьютеры, аудио и аксессуары')
+ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO categories (parent_id, name, slug) VALUES
+((SELECT id FROM categories WHERE slug = 'electronics'), 'Смартфоны и планшеты', 'phones-tablets'),
+((SELECT id FROM categories WHERE slug = 'electronics'), 'Компьютеры', 'computers'),
+((SELECT id FROM categories WHERE slug = 'electronics'), 'Аудио', 'audio'),
+((SELECT id FROM categories WHERE slug = 'electronics'), 'Фото и игры', 'photo-games'),
+((SELECT id FROM categories WHERE slug = 'electronics'), 'Аксессуары', 'accessories')
+ON CONFLICT (slug) DO NOTHING;
+
+-- Описания товаров каталога берутся из справочника продуктов
+UPDATE warehouse_items wi SET description = p.description
+FROM products p
+WHERE p.id = wi.product_id AND (wi.description IS NULL OR wi.description = '');
+
+UPDATE warehouse_items SET category_id = (SELECT id FROM categories WHERE slug = 'phones-tablets') WHERE sku IN ('STOCK-PHONE-001', 'STOCK-TABLET-001');
+UPDATE warehouse_items SET category_id = (SELECT id FROM categories WHERE slug = 'computers') WHERE sku IN ('STOCK-LAPTOP-001', 'STOCK-NETWORK-001');
+UPDATE warehouse_items SET category_id = (SELECT id FROM categories WHERE slug = 'audio') WHERE sku IN ('STOCK-AUDIO-001', 'STOCK-SPEAKER-001');
+UPDATE warehouse_items SET category_id = (SELECT id FROM categories WHERE slug = 'photo-games') WHERE sku IN ('STOCK-CAMERA-001', 'STOCK-GAME-001');
+UPDATE warehouse_items SET category_id = (SELECT id FROM categories WHERE slug = 'accessories') WHERE sku IN ('STOCK-WATCH-001', 'STOCK-POWER-001');
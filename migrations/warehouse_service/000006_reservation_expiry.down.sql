@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_warehouse_reservations_reservation_expiry;
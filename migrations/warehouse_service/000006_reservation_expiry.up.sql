@@ -0,0 +1,6 @@
+-- Истечение срока резервирования: просроченные активные резервации переводятся в статус expired,
+-- товары возвращаются в доступный остаток, публикуется событие warehouse.reservation.expired
+ALTER TABLE IF EXISTS warehouse_reservations ADD COLUMN IF NOT EXISTS reserved_at TIMESTAMP;
+ALTER TABLE IF EXISTS warehouse_reservations ADD COLUMN IF NOT EXISTS reservation_expiry TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_warehouse_reservations_reservation_expiry ON warehouse_reservations(reservation_expiry);
@@ -0,0 +1,39 @@
+-- Журнал движения товаров: приемка, списание, инвентаризация, корректировки и изменения резерва под заказы.
+-- Для каждой записи сохраняются остаток и резерв товара до и после изменения
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id BIGSERIAL PRIMARY KEY,
+    warehouse_item_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    type VARCHAR(20) NOT NULL, -- receipt, write_off, count, adjustment, reserve, release, expire, sale
+    quantity_delta BIGINT NOT NULL DEFAULT 0,
+    quantity_before BIGINT NOT NULL,
+    quantity_after BIGINT NOT NULL,
+    reserved_delta BIGINT NOT NULL DEFAULT 0,
+    reserved_before BIGINT NOT NULL,
+    reserved_after BIGINT NOT NULL,
+    reason TEXT,
+    actor VARCHAR(255) NOT NULL,
+    reference VARCHAR(255), -- номер накладной, акта списания или инвентаризации
+    order_id INTEGER,
+    reservation_id INTEGER,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_movements_warehouse_item_id ON stock_movements(warehouse_item_id);
+CREATE INDEX IF NOT EXISTS idx_stock_movements_product_id ON stock_movements(product_id);
+CREATE INDEX IF NOT EXISTS idx_stock_movements_type ON stock_movements(type);
+CREATE INDEX IF NOT EXISTS idx_stock_movements_order_id ON stock_movements(order_id);
+CREATE INDEX IF NOT EXISTS idx_stock_movements_reservation_id ON stock_movements(reservation_id);
+CREATE INDEX IF NOT EXISTS idx_stock_movements_created_at ON stock_movements(created_at);
+
+-- Журнал только пополняется: изменение и удаление записей запрещены
+CREATE OR REPLACE FUNCTION stock_movements_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'журнал движения товаров не допускает изменения записей';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_stock_movements_append_only ON stock_movements;
+CREATE TRIGGER trg_stock_movements_append_only
+    BEFORE UPDATE OR DELETE ON stock_movements
+    FOR EACH ROW EXECUTE FUNCTION stock_movements_append_only();
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_stock_movements_warehouse_id;
+ALTER TABLE IF EXISTS stock_movements DROP COLUMN IF EXISTS warehouse_id;
+DROP INDEX IF EXISTS idx_warehouse_reservations_warehouse_id;
+ALTER TABLE IF EXISTS warehouse_reservations DROP COLUMN IF EXISTS warehouse_id;
+DROP TABLE IF EXISTS warehouse_stocks;
+DROP TABLE IF EXISTS warehouse_zones;
+DROP TABLE IF EXISTS warehouses;
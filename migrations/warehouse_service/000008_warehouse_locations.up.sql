@@ -0,0 +1,60 @@
+-- Склады (фулфилмент-центры) и остатки товаров по складам. Остаток и резерв в warehouse_items
+-- равны сумме остатков и резервов товара по всем складам. Резервирование выбирает склад,
+-- ближайший к зоне доставки заказа, и сохраняет его в warehouse_reservations.warehouse_id
+CREATE TABLE IF NOT EXISTS warehouses (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(50) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    address TEXT NOT NULL DEFAULT '',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_warehouses_code ON warehouses(code);
+-- Основной склад может быть только один
+CREATE UNIQUE INDEX IF NOT EXISTS idx_warehouses_default ON warehouses(is_default) WHERE is_default;
+
+-- Удаленность склада от зоны доставки: чем меньше distance, тем ближе склад
+CREATE TABLE IF NOT EXISTS warehouse_zones (
+    warehouse_id INTEGER NOT NULL REFERENCES warehouses(id) ON DELETE CASCADE,
+    zone_id INTEGER NOT NULL,
+    distance INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (warehouse_id, zone_id)
+);
+
+CREATE TABLE IF NOT EXISTS warehouse_stocks (
+    id SERIAL PRIMARY KEY,
+    warehouse_id INTEGER NOT NULL REFERENCES warehouses(id),
+    warehouse_item_id INTEGER NOT NULL REFERENCES warehouse_items(id) ON DELETE CASCADE,
+    product_id INTEGER NOT NULL,
+    bin VARCHAR(255) NOT NULL DEFAULT '', -- место хранения внутри склада
+    quantity BIGINT NOT NULL DEFAULT 0,
+    reserved_quantity BIGINT NOT NULL DEFAULT 0,
+    available BIGINT GENERATED ALWAYS AS (quantity - reserved_quantity) STORED,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_warehouse_stocks_location_item ON warehouse_stocks(warehouse_id, warehouse_item_id);
+CREATE INDEX IF NOT EXISTS idx_warehouse_stocks_product_id ON warehouse_stocks(product_id);
+
+-- Весь имеющийся товар находится на основном складе
+INSERT INTO warehouses (code, name, is_default) VALUES ('MAIN', 'Основной склад', TRUE)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO warehouse_stocks (warehouse_id, warehouse_item_id, product_id, bin, quantity, reserved_quantity)
+SELECT w.id, i.id, i.product_id, i.location, i.quantity, i.reserved_quantity
+FROM warehouse_items i
+CROSS JOIN warehouses w
+WHERE w.is_default
+ON CONFLICT (warehouse_id, warehouse_item_id) DO NOTHING;
+
+ALTER TABLE IF EXISTS warehouse_reservations ADD COLUMN IF NOT EXISTS warehouse_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_warehouse_reservations_warehouse_id ON warehouse_reservations(warehouse_id);
+UPDATE warehouse_reservations SET warehouse_id = (SELECT id FROM warehouses WHERE is_default)
+WHERE warehouse_id IS NULL;
+
+ALTER TABLE IF EXISTS stock_movements ADD COLUMN IF NOT EXISTS warehouse_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_stock_movements_warehouse_id ON stock_movements(warehouse_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_warehouse_items_reorder_point;
+ALTER TABLE IF EXISTS warehouse_items DROP COLUMN IF EXISTS stock_level_at;
+ALTER TABLE IF EXISTS warehouse_items DROP COLUMN IF EXISTS stock_level;
+ALTER TABLE IF EXISTS warehouse_items DROP COLUMN IF EXISTS safety_stock;
+ALTER TABLE IF EXISTS warehouse_items DROP COLUMN IF EXISTS reorder_point;
@@ -0,0 +1,8 @@
+-- Контроль низкого остатка: точка дозаказа и страховой запас товара. Фоновая проверка сравнивает
+-- с ними доступный остаток и при ухудшении уровня публикует событие warehouse.stock.low
+ALTER TABLE IF EXISTS warehouse_items ADD COLUMN IF NOT EXISTS reorder_point BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE IF EXISTS warehouse_items ADD COLUMN IF NOT EXISTS safety_stock BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE IF EXISTS warehouse_items ADD COLUMN IF NOT EXISTS stock_level VARCHAR(20) NOT NULL DEFAULT 'normal'; -- normal, low, critical
+ALTER TABLE IF EXISTS warehouse_items ADD COLUMN IF NOT EXISTS stock_level_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_warehouse_items_reorder_point ON warehouse_items(reorder_point) WHERE reorder_point > 0;
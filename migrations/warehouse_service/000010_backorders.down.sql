@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS warehouse_backorder_items;
+DROP TABLE IF EXISTS warehouse_backorders;
+ALTER TABLE IF EXISTS warehouse_items DROP COLUMN IF EXISTS expected_available_at;
+ALTER TABLE IF EXISTS warehouse_items DROP COLUMN IF EXISTS backorderable;
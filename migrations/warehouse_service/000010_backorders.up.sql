@@ -0,0 +1,33 @@
+-- Заказ под поступление: при нехватке остатка товара с разрешенным заказом под поступление заказ
+-- встает в очередь и резервируется в порядке очереди после приемки товара
+ALTER TABLE IF EXISTS warehouse_items ADD COLUMN IF NOT EXISTS backorderable BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE IF EXISTS warehouse_items ADD COLUMN IF NOT EXISTS expected_available_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS warehouse_backorders (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    saga_id VARCHAR(255) NOT NULL DEFAULT '',
+    zone_id INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, allocated, cancelled
+    expected_available_at TIMESTAMP,
+    saga_data JSONB, -- данные шага reserve_warehouse для публикации его результата
+    allocated_at TIMESTAMP,
+    cancelled_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_warehouse_backorders_order_id ON warehouse_backorders(order_id);
+CREATE INDEX IF NOT EXISTS idx_warehouse_backorders_status ON warehouse_backorders(status);
+
+CREATE TABLE IF NOT EXISTS warehouse_backorder_items (
+    id SERIAL PRIMARY KEY,
+    backorder_id INTEGER NOT NULL REFERENCES warehouse_backorders(id) ON DELETE CASCADE,
+    product_id INTEGER NOT NULL,
+    quantity INTEGER NOT NULL,
+    shortage BIGINT NOT NULL DEFAULT 0 -- недостающее количество на момент постановки в очередь
+);
+
+CREATE INDEX IF NOT EXISTS idx_warehouse_backorder_items_backorder_id ON warehouse_backorder_items(backorder_id);
+CREATE INDEX IF NOT EXISTS idx_warehouse_backorder_items_product_id ON warehouse_backorder_items(product_id);
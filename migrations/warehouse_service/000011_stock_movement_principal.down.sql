@@ -0,0 +1 @@
+ALTER TABLE IF EXISTS stock_movements DROP COLUMN IF EXISTS principal;
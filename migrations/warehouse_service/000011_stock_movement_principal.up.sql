@@ -0,0 +1,3 @@
+-- Вызывающий внутреннего API, прошедший проверку доступа (способ проверки и адрес клиента).
+-- Исполнитель операции actor указывается самим вызывающим и сохраняется рядом для сверки
+ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS principal VARCHAR(255) NOT NULL DEFAULT '';
@@ -10,6 +10,7 @@ type Config struct {
 	Postgres config.PostgresConfig
 	RabbitMQ config.RabbitMQConfig
 	Mail     MailConfig
+	Alerts   AlertsConfig
 }
 
 // MailConfig содержит настройки для отправки почты
@@ -21,6 +22,18 @@ type MailConfig struct {
 	FromEmail    string
 }
 
+// AlertsConfig содержит адреса служебных уведомлений
+type AlertsConfig struct {
+	PurchasingEmail string // Отдел закупок: уведомления о низком остатке товаров на складе
+}
+
+// LoadAlertsConfig загружает адреса служебных уведомлений
+func LoadAlertsConfig() AlertsConfig {
+	return AlertsConfig{
+		PurchasingEmail: config.GetEnv("PURCHASING_TEAM_EMAIL", "purchasing@example.com"),
+	}
+}
+
 // LoadMailConfig загружает конфигурацию для отправки почты
 func LoadMailConfig() MailConfig {
 	return MailConfig{
@@ -36,11 +49,13 @@ func NewConfig() (*Config, error) {
 	// Загружаем общую конфигурацию
 	commonConfig := config.LoadCommonConfig("notifications", "8082")
 	mailConfig := LoadMailConfig()
+	alertsConfig := LoadAlertsConfig()
 
 	return &Config{
 		HTTP:     commonConfig.HTTP,
 		Postgres: commonConfig.Postgres,
 		RabbitMQ: commonConfig.RabbitMQ,
 		Mail:     mailConfig,
+		Alerts:   alertsConfig,
 	}, nil
 }
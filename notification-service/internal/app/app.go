@@ -88,7 +88,7 @@ func (a *App) Run() error {
 	// --- Инициализация зависимостей ---
 	notificationRepo := repo.NewNotificationRepository(a.db)
 	emailSender := usecase.NewDummyEmailSender() // Используем заглушку для email
-	notificationUseCase := usecase.NewNotificationUseCase(notificationRepo, emailSender)
+	notificationUseCase := usecase.NewNotificationUseCase(notificationRepo, emailSender, a.config.Alerts.PurchasingEmail)
 
 	// --- Настройка RabbitMQ ---
 	// Инициализируем контроллер консьюмеров
@@ -98,9 +98,10 @@ func (a *App) Run() error {
 	orderExchangeName := "order_events"
 	billingExchangeName := "billing_events"
 	sagaExchangeName := "saga_exchange"
+	warehouseExchangeName := "warehouse_events"
 
 	// Настраиваем все очереди и привязки через контроллер
-	if err := notificationConsumer.Setup(orderExchangeName, billingExchangeName, sagaExchangeName); err != nil {
+	if err := notificationConsumer.Setup(orderExchangeName, billingExchangeName, sagaExchangeName, warehouseExchangeName); err != nil {
 		return errors.AppendPrefix(err, "ошибка при настройке notification consumer")
 	}
 
@@ -112,6 +113,7 @@ func (a *App) Run() error {
 	// --- Настройка HTTP ---
 	notificationHandler := httpController.NewNotificationHandler(notificationUseCase)
 	notificationHandler.RegisterRoutes(a.router)
+	messaging.RegisterDeadLetterAdmin(a.router, a.rabbitMQ)
 
 	// Запускаем HTTP сервер
 	go func() {
@@ -42,6 +42,7 @@ func (c *NotificationConsumer) publishSagaResult(sagaExch, sagaID, stepName, sta
 		Data:      sagaData,
 		Error:     errorMsg,
 		Timestamp: time.Now().Unix(),
+		Version:   sagahandler.SchemaVersion,
 	}
 
 	err := c.publisher.PublishMessage(sagaExch, routingKey, message)
@@ -118,7 +119,7 @@ func (c *NotificationConsumer) handleNotifyCustomer(data []byte) error {
 }
 
 // Setup настраивает все необходимые очереди и привязки для сервиса уведомлений
-func (c *NotificationConsumer) Setup(orderExch, billingExch, sagaExch string) error {
+func (c *NotificationConsumer) Setup(orderExch, billingExch, sagaExch, warehouseExch string) error {
 	// Объявляем exchanges
 	err := c.rabbitMQ.DeclareExchange(orderExch, "topic")
 	if err != nil {
@@ -132,6 +133,10 @@ func (c *NotificationConsumer) Setup(orderExch, billingExch, sagaExch string) er
 	if err != nil {
 		return fmt.Errorf("ошибка при объявлении exchange %s: %w", sagaExch, err)
 	}
+	err = c.rabbitMQ.DeclareExchange(warehouseExch, "topic")
+	if err != nil {
+		return fmt.Errorf("ошибка при объявлении exchange %s: %w", warehouseExch, err)
+	}
 
 	// --- Очередь для order.notification ---
 	orderQueueName := "order_notifications"
@@ -181,6 +186,17 @@ func (c *NotificationConsumer) Setup(orderExch, billingExch, sagaExch string) er
 		return fmt.Errorf("ошибка при привязке очереди %s к ключу order.failed в %s: %w", cancellationQueueName, orderExch, err)
 	}
 
+	// --- Очередь для warehouse.stock.low ---
+	lowStockQueueName := "low_stock_notifications"
+	err = c.rabbitMQ.DeclareQueue(lowStockQueueName)
+	if err != nil {
+		return fmt.Errorf("ошибка при объявлении очереди %s: %w", lowStockQueueName, err)
+	}
+	err = c.rabbitMQ.BindQueue(lowStockQueueName, warehouseExch, "warehouse.stock.low")
+	if err != nil {
+		return fmt.Errorf("ошибка при привязке очереди %s к exchange %s: %w", lowStockQueueName, warehouseExch, err)
+	}
+
 	// Настройка consumer'а для шага саги
 	if err := c.SetupSagaConsumer(sagaExch); err != nil {
 		return fmt.Errorf("ошибка настройки saga consumer: %w", err)
@@ -214,6 +230,11 @@ func (c *NotificationConsumer) StartConsuming() error {
 		return fmt.Errorf("ошибка при запуске consumer'а order_cancellation_notifications: %w", err)
 	}
 
+	err = c.rabbitMQ.ConsumeMessages("low_stock_notifications", "notification_service_low_stock", c.handleLowStockNotification)
+	if err != nil {
+		return fmt.Errorf("ошибка при запуске consumer'а low_stock_notifications: %w", err)
+	}
+
 	err = c.rabbitMQ.ConsumeMessages("notification_saga_queue", "notification_service_saga_step", c.handleNotifyCustomer)
 	if err != nil {
 		return fmt.Errorf("ошибка при запуске consumer'а notification_saga_queue: %w", err)
@@ -305,3 +326,23 @@ func (c *NotificationConsumer) handleOrderCancellation(body []byte) error {
 	c.logger.Printf("Уведомление %s для OrderID=%d успешно обработано", cancellationEvent.Type, cancellationEvent.OrderID)
 	return nil
 }
+
+// handleLowStockNotification обрабатывает событие о низком остатке товара на складе
+func (c *NotificationConsumer) handleLowStockNotification(body []byte) error {
+	var lowStockNotification entity.LowStockNotification
+
+	err := json.Unmarshal(body, &lowStockNotification)
+	if err != nil {
+		return fmt.Errorf("ошибка при десериализации сообщения о низком остатке: %w", err)
+	}
+
+	c.logger.Printf("Получено уведомление о низком остатке товара ProductID=%d (%s): уровень %s, доступно %d",
+		lowStockNotification.ProductID, lowStockNotification.SKU, lowStockNotification.Level, lowStockNotification.Available)
+
+	err = c.notificationUseCase.ProcessLowStockNotification(context.Background(), lowStockNotification)
+	if err != nil {
+		return fmt.Errorf("ошибка при обработке уведомления о низком остатке: %w", err)
+	}
+
+	return nil
+}
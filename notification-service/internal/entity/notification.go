@@ -2,6 +2,8 @@ package entity
 
 import (
 	"time"
+
+	"github.com/director74/dz8_shop/pkg/money"
 )
 
 // Notification содержит данные об отправленных пользователю уведомлениях
@@ -55,32 +57,50 @@ type ListNotificationsResponse struct {
 
 // OrderNotification событие для уведомления о заказе (транспортная модель)
 type OrderNotification struct {
-	UserID  uint    `json:"user_id"`
-	Email   string  `json:"email"`
-	OrderID uint    `json:"order_id"`
-	Amount  float64 `json:"amount"`
-	Success bool    `json:"success"`
+	UserID  uint         `json:"user_id"`
+	Email   string       `json:"email"`
+	OrderID uint         `json:"order_id"`
+	Amount  money.Amount `json:"amount"`
+	Success bool         `json:"success"`
 }
 
 // DepositNotification событие для уведомления о пополнении баланса (транспортная модель)
 type DepositNotification struct {
-	Type          string  `json:"type"`
-	UserID        uint    `json:"user_id"`
-	TransactionID uint    `json:"transaction_id"`
-	Amount        float64 `json:"amount"`
-	OperationType string  `json:"operation_type"`
-	Status        string  `json:"status"`
-	Email         string  `json:"email"`
+	Type          string         `json:"type"`
+	UserID        uint           `json:"user_id"`
+	TransactionID uint           `json:"transaction_id"`
+	Amount        money.Amount   `json:"amount"`
+	Currency      money.Currency `json:"currency"`
+	OperationType string         `json:"operation_type"`
+	Status        string         `json:"status"`
+	Email         string         `json:"email"`
 }
 
 // InsufficientFundsNotification событие для уведомления о недостатке средств (транспортная модель)
 type InsufficientFundsNotification struct {
-	UserID        uint    `json:"user_id"`
-	TransactionID uint    `json:"transaction_id"`
-	Amount        float64 `json:"amount"`
-	Type          string  `json:"type"`
-	Status        string  `json:"status"`
-	Balance       float64 `json:"balance"`
-	Reason        string  `json:"reason"`
-	Email         string  `json:"email"`
+	UserID        uint           `json:"user_id"`
+	TransactionID uint           `json:"transaction_id"`
+	Amount        money.Amount   `json:"amount"`
+	Currency      money.Currency `json:"currency"`
+	Type          string         `json:"type"`
+	Status        string         `json:"status"`
+	Balance       money.Amount   `json:"balance"`
+	Reason        string         `json:"reason"`
+	Email         string         `json:"email"`
+}
+
+// LowStockNotification событие warehouse.stock.low о низком остатке товара на складе (транспортная модель)
+type LowStockNotification struct {
+	ProductID        uint      `json:"product_id"`
+	SKU              string    `json:"sku"`
+	Name             string    `json:"name"`
+	Level            string    `json:"level"`
+	PreviousLevel    string    `json:"previous_level"`
+	Quantity         int64     `json:"quantity"`
+	ReservedQuantity int64     `json:"reserved_quantity"`
+	Available        int64     `json:"available"`
+	ReorderPoint     int64     `json:"reorder_point"`
+	SafetyStock      int64     `json:"safety_stock"`
+	Shortfall        int64     `json:"shortfall"`
+	DetectedAt       time.Time `json:"detected_at"`
 }
@@ -36,14 +36,18 @@ type EmailSender interface {
 
 // NotificationUseCase представляет usecase для работы с нотификациями
 type NotificationUseCase struct {
-	repo        NotificationRepository
-	emailSender EmailSender
+	repo            NotificationRepository
+	emailSender     EmailSender
+	purchasingEmail string
 }
 
-func NewNotificationUseCase(repo NotificationRepository, emailSender EmailSender) *NotificationUseCase {
+// NewNotificationUseCase создает usecase нотификаций. purchasingEmail - адрес отдела закупок
+// для уведомлений о низком остатке товаров
+func NewNotificationUseCase(repo NotificationRepository, emailSender EmailSender, purchasingEmail string) *NotificationUseCase {
 	return &NotificationUseCase{
-		repo:        repo,
-		emailSender: emailSender,
+		repo:            repo,
+		emailSender:     emailSender,
+		purchasingEmail: purchasingEmail,
 	}
 }
 
@@ -94,12 +98,12 @@ func (uc *NotificationUseCase) ProcessOrderNotification(ctx context.Context, ord
 
 	if orderNotification.Success {
 		subject = fmt.Sprintf("Заказ #%d успешно оформлен", orderNotification.OrderID)
-		message = fmt.Sprintf("Уважаемый клиент, ваш заказ #%d на сумму %.2f успешно оформлен. Спасибо за покупку!",
+		message = fmt.Sprintf("Уважаемый клиент, ваш заказ #%d на сумму %s успешно оформлен. Спасибо за покупку!",
 			orderNotification.OrderID, orderNotification.Amount)
 	} else {
 		// Этот блок кода может быть неактуален, т.к. ошибки обрабатываются через ProcessOrderCancellation
 		subject = fmt.Sprintf("Проблема с заказом #%d", orderNotification.OrderID)
-		message = fmt.Sprintf("Уважаемый клиент, при оформлении заказа #%d на сумму %.2f возникла проблема.",
+		message = fmt.Sprintf("Уважаемый клиент, при оформлении заказа #%d на сумму %s возникла проблема.",
 			orderNotification.OrderID, orderNotification.Amount)
 	}
 
@@ -122,8 +126,8 @@ func (uc *NotificationUseCase) ProcessDepositNotification(ctx context.Context, d
 	}
 
 	subject := "Пополнение баланса"
-	message := fmt.Sprintf("Уважаемый клиент, ваш счет был пополнен на сумму %.2f. Текущая операция: %s.",
-		depositNotification.Amount, depositNotification.OperationType)
+	message := fmt.Sprintf("Уважаемый клиент, ваш счет был пополнен на сумму %s %s. Текущая операция: %s.",
+		depositNotification.Amount, depositNotification.Currency.OrDefault(), depositNotification.OperationType)
 
 	req := entity.SendNotificationRequest{
 		UserID:  depositNotification.UserID,
@@ -144,9 +148,9 @@ func (uc *NotificationUseCase) ProcessInsufficientFundsNotification(ctx context.
 	}
 
 	subject := "Недостаточно средств на вашем счете"
-	message := fmt.Sprintf("Уважаемый клиент, на вашем счете недостаточно средств для совершения операции на сумму %.2f. "+
-		"Текущий баланс: %.2f. Пожалуйста, пополните баланс для совершения покупок.",
-		notification.Amount, notification.Balance)
+	message := fmt.Sprintf("Уважаемый клиент, на вашем счете недостаточно средств для совершения операции на сумму %s %s. "+
+		"Текущий баланс: %s %s. Пожалуйста, пополните баланс для совершения покупок.",
+		notification.Amount, notification.Currency.OrDefault(), notification.Balance, notification.Currency.OrDefault())
 
 	req := entity.SendNotificationRequest{
 		UserID:  notification.UserID,
@@ -159,6 +163,33 @@ func (uc *NotificationUseCase) ProcessInsufficientFundsNotification(ctx context.
 	return err
 }
 
+// ProcessLowStockNotification обрабатывает событие низкого остатка товара на складе: уведомление
+// отправляется отделу закупок. Служебные уведомления сохраняются без пользователя (UserID = 0)
+func (uc *NotificationUseCase) ProcessLowStockNotification(ctx context.Context, notification entity.LowStockNotification) error {
+	var subject string
+	if notification.Level == "critical" {
+		subject = fmt.Sprintf("Критический остаток товара %s", notification.SKU)
+	} else {
+		subject = fmt.Sprintf("Низкий остаток товара %s", notification.SKU)
+	}
+
+	message := fmt.Sprintf("Доступный остаток товара «%s» (SKU %s, ID продукта %d) снизился до %d шт.: "+
+		"на складе %d шт., из них зарезервировано %d. Точка дозаказа: %d, страховой запас: %d. "+
+		"До точки дозаказа не хватает %d шт. Пожалуйста, оформите пополнение.",
+		notification.Name, notification.SKU, notification.ProductID, notification.Available,
+		notification.Quantity, notification.ReservedQuantity, notification.ReorderPoint, notification.SafetyStock,
+		notification.Shortfall)
+
+	req := entity.SendNotificationRequest{
+		Email:   uc.purchasingEmail,
+		Subject: subject,
+		Message: message,
+	}
+
+	_, err := uc.SendNotification(ctx, req)
+	return err
+}
+
 func (uc *NotificationUseCase) GetNotification(ctx context.Context, id uint) (entity.GetNotificationResponse, error) {
 	notification, err := uc.repo.GetNotificationByID(ctx, id)
 	if err != nil {
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"time"
+
 	"github.com/director74/dz8_shop/pkg/config"
+	"github.com/director74/dz8_shop/pkg/money"
 )
 
 // Config содержит конфигурацию сервиса заказов
@@ -11,12 +14,25 @@ type Config struct {
 	RabbitMQ config.RabbitMQConfig
 	Services ServicesConfig
 	JWT      config.JWTConfig
+	Saga     SagaConfig
+	Outbox   config.OutboxConfig
+	Currency money.Currency // Валюта цен каталога и заказов
 }
 
 // ServicesConfig содержит настройки внешних сервисов
 type ServicesConfig struct {
 	BillingURL      string
 	NotificationURL string
+	WarehouseURL    string
+}
+
+// SagaConfig содержит настройки ожидания результатов шагов саги заказа
+type SagaConfig struct {
+	StepTimeout        time.Duration // Таймаут шага по умолчанию
+	ConfirmStepTimeout time.Duration // Таймаут шага confirm_order (ждет завершения доставки)
+	StepMaxRetries     int           // Количество повторных отправок шага перед компенсацией
+	WatchdogInterval   time.Duration // Интервал проверки зависших шагов
+	BackorderTimeout   time.Duration // Ожидание резерва склада сверх ожидаемой даты поступления товаров заказа под поступление
 }
 
 func NewConfig() (*Config, error) {
@@ -25,6 +41,11 @@ func NewConfig() (*Config, error) {
 	jwtConfig := config.LoadJWTConfig("microservices-auth")
 	servicesConfig := config.LoadServicesConfig()
 
+	currency, err := money.ParseCurrency(config.GetEnv("ORDER_CURRENCY", string(money.DefaultCurrency)))
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
 		HTTP:     commonConfig.HTTP,
 		Postgres: commonConfig.Postgres,
@@ -32,7 +53,22 @@ func NewConfig() (*Config, error) {
 		Services: ServicesConfig{
 			BillingURL:      servicesConfig.BillingURL,
 			NotificationURL: servicesConfig.NotificationURL,
+			WarehouseURL:    servicesConfig.WarehouseURL,
 		},
-		JWT: *jwtConfig,
+		JWT:      *jwtConfig,
+		Saga:     loadSagaConfig(),
+		Outbox:   *config.LoadOutboxConfig(),
+		Currency: currency,
 	}, nil
 }
+
+// loadSagaConfig загружает настройки таймаутов саги
+func loadSagaConfig() SagaConfig {
+	return SagaConfig{
+		StepTimeout:        config.GetEnvAsDuration("SAGA_STEP_TIMEOUT", 2*time.Minute),
+		ConfirmStepTimeout: config.GetEnvAsDuration("SAGA_CONFIRM_STEP_TIMEOUT", 30*time.Minute),
+		StepMaxRetries:     config.GetEnvAsInt("SAGA_STEP_MAX_RETRIES", 0),
+		WatchdogInterval:   config.GetEnvAsDuration("SAGA_WATCHDOG_INTERVAL", 30*time.Second),
+		BackorderTimeout:   config.GetEnvAsDuration("SAGA_BACKORDER_TIMEOUT", 7*24*time.Hour),
+	}
+}
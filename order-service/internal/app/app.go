@@ -23,6 +23,8 @@ import (
 	"github.com/director74/dz8_shop/pkg/database"
 	"github.com/director74/dz8_shop/pkg/errors"
 	"github.com/director74/dz8_shop/pkg/messaging"
+	pkgMiddleware "github.com/director74/dz8_shop/pkg/middleware"
+	"github.com/director74/dz8_shop/pkg/outbox"
 	"github.com/director74/dz8_shop/pkg/rabbitmq"
 )
 
@@ -33,6 +35,9 @@ type App struct {
 	jwtManager *auth.JWTManager
 	db         *gorm.DB
 	rabbitMQ   *rabbitmq.RabbitMQ
+
+	stopWatchdog context.CancelFunc
+	stopRelay    context.CancelFunc
 }
 
 func NewApp(config *config.Config) (*App, error) {
@@ -47,7 +52,7 @@ func NewApp(config *config.Config) (*App, error) {
 	}
 
 	// Автомиграция моделей, включая SagaState
-	if err := database.AutoMigrateWithCleanup(db, &entity.User{}, &entity.Order{}, &entity.OrderItem{}, &entity.SagaState{}); err != nil {
+	if err := database.AutoMigrateWithCleanup(db, &entity.User{}, &entity.Order{}, &entity.OrderItem{}, &entity.SagaState{}, &entity.SagaStepState{}, &entity.Cart{}, &entity.CartItem{}, &outbox.Message{}, &pkgMiddleware.IdempotencyRecord{}); err != nil {
 		return nil, errors.AppendPrefix(err, "не удалось выполнить миграцию")
 	}
 
@@ -84,16 +89,40 @@ func NewApp(config *config.Config) (*App, error) {
 	userRepo := repo.NewUserGormRepository(db)
 	orderRepo := repo.NewOrderRepository(db)
 	sagaStateRepo := repo.NewSagaStateRepository(db) // Создаем репозиторий состояний саг
+	cartRepo := repo.NewCartRepository(db)
 
-	// Создаем клиент для биллинга
+	// Создаем клиенты биллинга и каталога склада
 	billingClient := webapi.NewBillingClient(config.Services.BillingURL)
+	warehouseClient := webapi.NewWarehouseClient(config.Services.WarehouseURL)
 
-	// Создаем middleware для аутентификации
+	// Создаем middleware для аутентификации и повторов запросов с Idempotency-Key
 	authMiddleware := auth.NewAuthMiddleware(jwtManager)
+	idempotencyMiddleware := pkgMiddleware.NewIdempotencyMiddleware(db)
 
 	// Создаем use cases, передавая sagaStateRepo в OrderUseCase
 	authUseCase := usecase.NewAuthUseCase(userRepo, jwtManager, billingClient)
-	orderUseCase := usecase.NewOrderUseCase(orderRepo, userRepo, sagaStateRepo, billingClient, rmq, "order_events", "saga_exchange")
+	orderUseCase := usecase.NewOrderUseCase(orderRepo, userRepo, sagaStateRepo, billingClient, warehouseClient, rmq, "order_events", "saga_exchange")
+
+	cartUseCase := usecase.NewCartUseCase(cartRepo, warehouseClient, warehouseClient, orderUseCase)
+	// Цены каталога, заказы и корзины ведутся в валюте из конфигурации
+	orderUseCase.UseCurrency(config.Currency)
+	cartUseCase.UseCurrency(config.Currency)
+
+	// Сообщения саги и события заказов сохраняются в outbox и отправляются relay
+	orderUseCase.UseOutbox(outbox.NewPublisher(db))
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	go outbox.NewRelay(db, rmq, config.Outbox, nil).Run(relayCtx)
+
+	// Запускаем watchdog зависших шагов саги
+	watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+	orderUseCase.StartSagaWatchdog(watchdogCtx, usecase.StepTimeoutConfig{
+		DefaultTimeout: config.Saga.StepTimeout,
+		StepTimeouts: map[string]time.Duration{
+			"confirm_order": config.Saga.ConfirmStepTimeout,
+		},
+		MaxRetries:       config.Saga.StepMaxRetries,
+		BackorderTimeout: config.Saga.BackorderTimeout,
+	}, config.Saga.WatchdogInterval)
 
 	// Создаем и настраиваем DeliveryConsumer
 	deliveryConsumer := rabbitmqController.NewDeliveryConsumer(orderUseCase, orderRepo, rmq, nil)
@@ -102,9 +131,16 @@ func NewApp(config *config.Config) (*App, error) {
 		log.Printf("ВНИМАНИЕ: Ошибка при настройке DeliveryConsumer: %v", err)
 	}
 
+	// Истечение резервирования товаров на складе завершает сагу заказа ошибкой
+	warehouseConsumer := rabbitmqController.NewWarehouseConsumer(orderUseCase, rmq, nil)
+	if err := warehouseConsumer.Setup(); err != nil {
+		log.Printf("ВНИМАНИЕ: Ошибка при настройке WarehouseConsumer: %v", err)
+	}
+
 	// Создаем HTTP контроллеры
 	authHandler := httpController.NewAuthHandler(authUseCase)
-	orderHandler := httpController.NewOrderHandler(orderUseCase, authMiddleware)
+	orderHandler := httpController.NewOrderHandler(orderUseCase, authMiddleware, idempotencyMiddleware)
+	cartHandler := httpController.NewCartHandler(cartUseCase, authMiddleware, idempotencyMiddleware)
 
 	// Инициализируем Gin роутер
 	router := gin.Default()
@@ -120,6 +156,8 @@ func NewApp(config *config.Config) (*App, error) {
 	// Регистрируем эндпоинты
 	authHandler.RegisterRoutes(router)
 	orderHandler.RegisterRoutes(router)
+	cartHandler.RegisterRoutes(router)
+	messaging.RegisterDeadLetterAdmin(router, rmq)
 
 	// Настраиваем HTTP сервер
 	httpServer := &http.Server{
@@ -135,6 +173,9 @@ func NewApp(config *config.Config) (*App, error) {
 		jwtManager: jwtManager,
 		db:         db,
 		rabbitMQ:   rmq,
+
+		stopWatchdog: stopWatchdog,
+		stopRelay:    stopRelay,
 	}, nil
 }
 
@@ -170,6 +211,14 @@ func (a *App) Run() error {
 func (a *App) Shutdown() error {
 	errGroup := errors.NewErrorGroup()
 
+	// Останавливаем watchdog саги до закрытия соединений
+	if a.stopWatchdog != nil {
+		a.stopWatchdog()
+	}
+	if a.stopRelay != nil {
+		a.stopRelay()
+	}
+
 	// Закрываем HTTP сервер
 	if a.httpServer != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -0,0 +1,202 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"github.com/director74/dz8_shop/order-service/internal/usecase"
+	"github.com/director74/dz8_shop/pkg/auth"
+	pkgMiddleware "github.com/director74/dz8_shop/pkg/middleware"
+)
+
+// CartHandler обработчик HTTP запросов корзины пользователя
+type CartHandler struct {
+	cartUseCase    *usecase.CartUseCase
+	authMiddleware *auth.AuthMiddleware
+	idempotency    *pkgMiddleware.IdempotencyMiddleware
+}
+
+func NewCartHandler(cartUseCase *usecase.CartUseCase, authMiddleware *auth.AuthMiddleware, idempotency *pkgMiddleware.IdempotencyMiddleware) *CartHandler {
+	return &CartHandler{
+		cartUseCase:    cartUseCase,
+		authMiddleware: authMiddleware,
+		idempotency:    idempotency,
+	}
+}
+
+func (h *CartHandler) RegisterRoutes(router *gin.Engine) {
+	cart := router.Group("/api/v1/cart")
+	cart.Use(h.authMiddleware.AuthRequired())
+	{
+		cart.GET("", h.GetCart)
+		cart.DELETE("", h.ClearCart)
+		cart.POST("/items", h.AddItem)
+		cart.PUT("/items/:product_id", h.UpdateItem)
+		cart.DELETE("/items/:product_id", h.RemoveItem)
+		cart.POST("/checkout", h.idempotency.Handler(), h.Checkout)
+	}
+}
+
+// GetCart возвращает корзину текущего пользователя
+func (h *CartHandler) GetCart(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "пользователь не авторизован"})
+		return
+	}
+
+	resp, err := h.cartUseCase.GetCart(c.Request.Context(), userID)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// AddItem добавляет товар в корзину текущего пользователя
+func (h *CartHandler) AddItem(c *gin.Context) {
+	var req entity.AddCartItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "пользователь не авторизован"})
+		return
+	}
+
+	resp, err := h.cartUseCase.AddItem(c.Request.Context(), userID, req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// UpdateItem изменяет количество товара в корзине текущего пользователя
+func (h *CartHandler) UpdateItem(c *gin.Context) {
+	productID, err := strconv.ParseUint(c.Param("product_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID товара"})
+		return
+	}
+
+	var req entity.UpdateCartItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "пользователь не авторизован"})
+		return
+	}
+
+	resp, err := h.cartUseCase.UpdateItem(c.Request.Context(), userID, uint(productID), *req.Quantity)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// RemoveItem удаляет товар из корзины текущего пользователя
+func (h *CartHandler) RemoveItem(c *gin.Context) {
+	productID, err := strconv.ParseUint(c.Param("product_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID товара"})
+		return
+	}
+
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "пользователь не авторизован"})
+		return
+	}
+
+	resp, err := h.cartUseCase.RemoveItem(c.Request.Context(), userID, uint(productID))
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// ClearCart удаляет все товары из корзины текущего пользователя
+func (h *CartHandler) ClearCart(c *gin.Context) {
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "пользователь не авторизован"})
+		return
+	}
+
+	if err := h.cartUseCase.ClearCart(c.Request.Context(), userID); err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Checkout оформляет заказ из корзины текущего пользователя
+func (h *CartHandler) Checkout(c *gin.Context) {
+	var req entity.CheckoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "пользователь не авторизован"})
+		return
+	}
+
+	jwtToken, exists := c.Get("jwt_token")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "отсутствует токен авторизации"})
+		return
+	}
+	ctx := context.WithValue(c.Request.Context(), "jwt_token", jwtToken)
+
+	resp, err := h.cartUseCase.Checkout(ctx, userID, req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+// handleError преобразует ошибку use case корзины в HTTP ответ
+func (h *CartHandler) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrCartItemNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrCartEmpty), errors.Is(err, usecase.ErrCartUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrProductNotFound),
+		errors.Is(err, usecase.ErrProductUnavailable),
+		errors.Is(err, usecase.ErrAmountMismatch),
+		errors.Is(err, usecase.ErrCurrencyMismatch),
+		errors.Is(err, usecase.ErrInvalidCurrency),
+		errors.Is(err, usecase.ErrInvalidAmount):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -2,6 +2,7 @@ package http
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -10,17 +11,20 @@ import (
 	"github.com/director74/dz8_shop/order-service/internal/entity"
 	"github.com/director74/dz8_shop/order-service/internal/usecase"
 	"github.com/director74/dz8_shop/pkg/auth"
+	pkgMiddleware "github.com/director74/dz8_shop/pkg/middleware"
 )
 
 type OrderHandler struct {
 	orderUseCase   *usecase.OrderUseCase
 	authMiddleware *auth.AuthMiddleware
+	idempotency    *pkgMiddleware.IdempotencyMiddleware
 }
 
-func NewOrderHandler(orderUseCase *usecase.OrderUseCase, authMiddleware *auth.AuthMiddleware) *OrderHandler {
+func NewOrderHandler(orderUseCase *usecase.OrderUseCase, authMiddleware *auth.AuthMiddleware, idempotency *pkgMiddleware.IdempotencyMiddleware) *OrderHandler {
 	return &OrderHandler{
 		orderUseCase:   orderUseCase,
 		authMiddleware: authMiddleware,
+		idempotency:    idempotency,
 	}
 }
 
@@ -36,8 +40,9 @@ func (h *OrderHandler) RegisterRoutes(router *gin.Engine) {
 		authorized := api.Group("")
 		authorized.Use(h.authMiddleware.AuthRequired())
 		{
-			authorized.POST("/orders", h.CreateOrder)
+			authorized.POST("/orders", h.idempotency.Handler(), h.CreateOrder)
 			authorized.GET("/orders/:id", h.GetOrder)
+			authorized.POST("/orders/:id/cancel", h.CancelOrder)
 			authorized.GET("/users/:id/orders", h.ListUserOrders)
 		}
 	}
@@ -89,7 +94,17 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 
 	resp, err := h.orderUseCase.CreateOrder(ctx, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		switch {
+		case errors.Is(err, usecase.ErrProductNotFound),
+			errors.Is(err, usecase.ErrProductUnavailable),
+			errors.Is(err, usecase.ErrAmountMismatch),
+			errors.Is(err, usecase.ErrCurrencyMismatch),
+			errors.Is(err, usecase.ErrInvalidCurrency),
+			errors.Is(err, usecase.ErrInvalidAmount):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
@@ -116,6 +131,46 @@ func (h *OrderHandler) GetOrder(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// CancelOrder отменяет заказ текущего пользователя
+func (h *OrderHandler) CancelOrder(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID"})
+		return
+	}
+
+	var req entity.CancelOrderRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "пользователь не авторизован"})
+		return
+	}
+
+	resp, err := h.orderUseCase.CancelOrder(c.Request.Context(), uint(id), userID, req.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrOrderNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, usecase.ErrOrderAccessDenied):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, usecase.ErrOrderNotCancellable):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, resp)
+}
+
 func (h *OrderHandler) ListUserOrders(c *gin.Context) {
 	idStr := c.Param("id")
 	userID, err := strconv.ParseUint(idStr, 10, 32)
@@ -0,0 +1,143 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/director74/dz8_shop/order-service/internal/usecase"
+	"github.com/director74/dz8_shop/pkg/rabbitmq"
+)
+
+// WarehouseConsumer обработчик событий сервиса склада
+type WarehouseConsumer struct {
+	orderUseCase *usecase.OrderUseCase
+	rabbitMQ     *rabbitmq.RabbitMQ
+	logger       *log.Logger
+}
+
+// NewWarehouseConsumer создает новый обработчик
+func NewWarehouseConsumer(orderUseCase *usecase.OrderUseCase, rabbitMQ *rabbitmq.RabbitMQ, logger *log.Logger) *WarehouseConsumer {
+	if logger == nil {
+		logger = log.New(log.Writer(), "[WarehouseConsumer] ", log.LstdFlags)
+	}
+	return &WarehouseConsumer{
+		orderUseCase: orderUseCase,
+		rabbitMQ:     rabbitMQ,
+		logger:       logger,
+	}
+}
+
+// ReservationExpiredMessage структура события об истечении резервирования (копируем из warehouse-service)
+type ReservationExpiredMessage struct {
+	OrderID   uint      `json:"order_id"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+// HandleReservationExpired обрабатывает событие warehouse.reservation.expired.
+// Ошибка обработки возвращается, чтобы сообщение было доставлено повторно.
+func (c *WarehouseConsumer) HandleReservationExpired(data []byte) error {
+	var msg ReservationExpiredMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.logger.Printf("[ERROR] Не удалось десериализовать сообщение warehouse.reservation.expired: %v", err)
+		return fmt.Errorf("ошибка десериализации warehouse.reservation.expired: %w", err)
+	}
+
+	c.logger.Printf("[INFO] OrderID=%d: Получено событие warehouse.reservation.expired (истекло %s).", msg.OrderID, msg.ExpiredAt.Format(time.RFC3339))
+
+	if err := c.orderUseCase.HandleReservationExpired(context.Background(), msg.OrderID); err != nil {
+		c.logger.Printf("[ERROR] OrderID=%d: Ошибка обработки истечения резервирования: %v", msg.OrderID, err)
+		return err
+	}
+	return nil
+}
+
+// BackorderScheduledMessage структура события о заказе под поступление товара (копируем из warehouse-service)
+type BackorderScheduledMessage struct {
+	OrderID             uint       `json:"order_id"`
+	SagaID              string     `json:"saga_id"`
+	ExpectedAvailableAt *time.Time `json:"expected_available_at,omitempty"`
+}
+
+// HandleBackorderScheduled обрабатывает событие warehouse.backorder.scheduled: заказ поставлен складом
+// в очередь под поступление товара или у него изменилась ожидаемая дата поступления.
+// Ошибка обработки возвращается, чтобы сообщение было доставлено повторно.
+func (c *WarehouseConsumer) HandleBackorderScheduled(data []byte) error {
+	var msg BackorderScheduledMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.logger.Printf("[ERROR] Не удалось десериализовать сообщение warehouse.backorder.scheduled: %v", err)
+		return fmt.Errorf("ошибка десериализации warehouse.backorder.scheduled: %w", err)
+	}
+
+	c.logger.Printf("[INFO] OrderID=%d: Получено событие warehouse.backorder.scheduled (SagaID=%s).", msg.OrderID, msg.SagaID)
+
+	if err := c.orderUseCase.HandleBackorderScheduled(context.Background(), msg.OrderID, msg.ExpectedAvailableAt); err != nil {
+		c.logger.Printf("[ERROR] OrderID=%d: Ошибка обработки заказа под поступление: %v", msg.OrderID, err)
+		return err
+	}
+	return nil
+}
+
+// Setup настраивает консьюмера
+func (c *WarehouseConsumer) Setup() error {
+	exchangeName := "warehouse_events"
+	queueName := "warehouse_order_queue"
+	routingKey := "warehouse.reservation.expired"
+
+	// Объявляем exchange
+	err := c.rabbitMQ.DeclareExchange(exchangeName, "topic")
+	if err != nil {
+		c.logger.Printf("[ERROR] Ошибка при объявлении exchange %s: %v", exchangeName, err)
+		return fmt.Errorf("ошибка при объявлении exchange %s: %w", exchangeName, err)
+	}
+
+	// Объявляем очередь
+	err = c.rabbitMQ.DeclareQueue(queueName)
+	if err != nil {
+		c.logger.Printf("[ERROR] Ошибка при объявлении очереди %s: %v", queueName, err)
+		return fmt.Errorf("ошибка при объявлении очереди %s: %w", queueName, err)
+	}
+
+	// Привязываем очередь к exchange
+	err = c.rabbitMQ.BindQueue(queueName, exchangeName, routingKey)
+	if err != nil {
+		c.logger.Printf("[ERROR] Ошибка при привязке очереди %s к ключу %s: %v", queueName, routingKey, err)
+		return fmt.Errorf("ошибка при привязке очереди %s к ключу %s: %w", queueName, routingKey, err)
+	}
+
+	// Настраиваем обработчик сообщений
+	err = c.rabbitMQ.ConsumeMessages(queueName, "order-service-warehouse-handler", c.HandleReservationExpired)
+	if err != nil {
+		c.logger.Printf("[ERROR] Ошибка при настройке обработчика сообщений для %s: %v", queueName, err)
+		return fmt.Errorf("ошибка при настройке обработчика сообщений для %s: %w", queueName, err)
+	}
+
+	c.logger.Printf("[INFO] Настроена обработка сообщений из очереди %s", queueName)
+
+	// Заказы под поступление обрабатываются из отдельной очереди: у события свой обработчик
+	return c.setupQueue(exchangeName, "warehouse_backorder_queue", "warehouse.backorder.scheduled",
+		"order-service-warehouse-backorder-handler", c.HandleBackorderScheduled)
+}
+
+// setupQueue объявляет очередь queueName, привязывает ее к exchangeName по routingKey и запускает обработчик
+func (c *WarehouseConsumer) setupQueue(exchangeName, queueName, routingKey, consumerName string, handler func([]byte) error) error {
+	if err := c.rabbitMQ.DeclareQueue(queueName); err != nil {
+		c.logger.Printf("[ERROR] Ошибка при объявлении очереди %s: %v", queueName, err)
+		return fmt.Errorf("ошибка при объявлении очереди %s: %w", queueName, err)
+	}
+
+	if err := c.rabbitMQ.BindQueue(queueName, exchangeName, routingKey); err != nil {
+		c.logger.Printf("[ERROR] Ошибка при привязке очереди %s к ключу %s: %v", queueName, routingKey, err)
+		return fmt.Errorf("ошибка при привязке очереди %s к ключу %s: %w", queueName, routingKey, err)
+	}
+
+	if err := c.rabbitMQ.ConsumeMessages(queueName, consumerName, handler); err != nil {
+		c.logger.Printf("[ERROR] Ошибка при настройке обработчика сообщений для %s: %v", queueName, err)
+		return fmt.Errorf("ошибка при настройке обработчика сообщений для %s: %w", queueName, err)
+	}
+
+	c.logger.Printf("[INFO] Настроена обработка сообщений из очереди %s", queueName)
+	return nil
+}
@@ -0,0 +1,95 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// Cart корзина пользователя. У каждого пользователя одна корзина, она хранится в базе
+// и доступна с любого устройства до оформления заказа.
+type Cart struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;uniqueIndex"`
+	Items     []CartItem `json:"items" gorm:"foreignKey:CartID"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// CartItem позиция корзины. Цена в корзине не хранится: она берется из каталога склада при каждом просмотре.
+type CartItem struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CartID    uint      `json:"cart_id" gorm:"not null;uniqueIndex:idx_cart_items_cart_product"`
+	ProductID uint      `json:"product_id" gorm:"not null;uniqueIndex:idx_cart_items_cart_product"`
+	Quantity  int       `json:"quantity" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AddCartItemRequest запрос на добавление товара в корзину
+type AddCartItemRequest struct {
+	ProductID uint `json:"product_id" binding:"required"`
+	Quantity  int  `json:"quantity" binding:"required,gt=0"`
+}
+
+// UpdateCartItemRequest запрос на изменение количества товара в корзине. Количество 0 удаляет позицию.
+type UpdateCartItemRequest struct {
+	Quantity *int `json:"quantity" binding:"required,min=0"`
+}
+
+// CheckoutRequest запрос на оформление заказа из корзины
+type CheckoutRequest struct {
+	Amount   money.Amount     `json:"amount" binding:"omitempty,min=0"`   // Ожидаемая клиентом сумма, сверяется с ценами каталога
+	Currency money.Currency   `json:"currency" binding:"omitempty,len=3"` // Ожидаемая клиентом валюта, сверяется с валютой каталога
+	Delivery *DeliveryRequest `json:"delivery,omitempty"`
+}
+
+// CartItemResponse позиция корзины с актуальной ценой и наличием на складе
+type CartItemResponse struct {
+	ProductID         uint         `json:"product_id"`
+	SKU               string       `json:"sku"`
+	Name              string       `json:"name"`
+	Price             money.Amount `json:"price"`
+	Quantity          int          `json:"quantity"`
+	Subtotal          money.Amount `json:"subtotal"`
+	Status            string       `json:"status"`
+	Available         bool         `json:"available"`
+	AvailableQuantity *int64       `json:"available_quantity,omitempty"` // Остаток на складе, если его не хватает
+	// Backorder товара не хватает, но его можно заказать под поступление: заказ будет собран после поступления товара
+	Backorder           bool       `json:"backorder,omitempty"`
+	ExpectedAvailableAt *time.Time `json:"expected_available_at,omitempty"` // Ожидаемая дата поступления товара
+}
+
+// CartResponse корзина пользователя с актуальными ценами и наличием
+type CartResponse struct {
+	UserID    uint               `json:"user_id"`
+	Items     []CartItemResponse `json:"items"`
+	Total     money.Amount       `json:"total"`
+	Currency  money.Currency     `json:"currency"`
+	Available bool               `json:"available"` // Все позиции есть в наличии или доступны под поступление, корзину можно оформить
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+// StockRequestItem товар и количество для проверки наличия на складе
+type StockRequestItem struct {
+	ProductID uint `json:"product_id"`
+	Quantity  int  `json:"quantity"`
+}
+
+// StockShortage товар, которого на складе меньше, чем запрошено
+type StockShortage struct {
+	ProductID           uint       `json:"product_id"`
+	RequestedQuantity   int64      `json:"requested_quantity"`
+	AvailableQuantity   int64      `json:"available_quantity"`
+	Backorderable       bool       `json:"backorderable,omitempty"` // Товар можно заказать под поступление
+	ExpectedAvailableAt *time.Time `json:"expected_available_at,omitempty"`
+}
+
+// StockAvailability результат проверки наличия товаров на складе
+type StockAvailability struct {
+	Available        bool            `json:"available"`
+	UnavailableItems []StockShortage `json:"unavailable_items,omitempty"`
+	// Backorderable все недостающие товары можно заказать под поступление
+	Backorderable       bool       `json:"backorderable,omitempty"`
+	ExpectedAvailableAt *time.Time `json:"expected_available_at,omitempty"`
+}
@@ -2,53 +2,102 @@ package entity
 
 import (
 	"time"
+
+	"github.com/director74/dz8_shop/pkg/money"
+	"github.com/director74/dz8_shop/pkg/sagahandler"
 )
 
 // OrderStatus статус заказа
 type OrderStatus string
 
 const (
-	OrderStatusCreated   OrderStatus = "created"
-	OrderStatusPaid      OrderStatus = "paid"
-	OrderStatusShipped   OrderStatus = "shipped"
-	OrderStatusDelivered OrderStatus = "delivered"
-	OrderStatusCancelled OrderStatus = "cancelled"
-	OrderStatusPending   OrderStatus = "pending"
-	OrderStatusFailed    OrderStatus = "failed"
-	OrderStatusCompleted OrderStatus = "completed"
+	OrderStatusCreated     OrderStatus = "created"
+	OrderStatusPaid        OrderStatus = "paid"
+	OrderStatusShipped     OrderStatus = "shipped"
+	OrderStatusDelivered   OrderStatus = "delivered"
+	OrderStatusCancelled   OrderStatus = "cancelled"
+	OrderStatusCancelling  OrderStatus = "cancelling" // Запущена отмена, ожидаются компенсации шагов саги
+	OrderStatusPending     OrderStatus = "pending"
+	OrderStatusFailed      OrderStatus = "failed"
+	OrderStatusCompleted   OrderStatus = "completed"
+	OrderStatusBackordered OrderStatus = "backordered" // Товара нет в наличии, заказ ждет поступления на склад
 )
 
+// Cancellable сообщает, может ли клиент отменить заказ в этом статусе.
+// Отгруженные, доставленные и уже завершенные заказы не отменяются.
+func (s OrderStatus) Cancellable() bool {
+	switch s {
+	case OrderStatusCreated, OrderStatusPending, OrderStatusPaid, OrderStatusBackordered:
+		return true
+	default:
+		return false
+	}
+}
+
 // OrderItem элемент заказа
 type OrderItem struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	OrderID   uint      `json:"order_id" gorm:"index"`
-	ProductID uint      `json:"product_id"`
-	Name      string    `json:"name"`
-	Price     float64   `json:"price"`
-	Quantity  int       `json:"quantity"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        uint         `json:"id" gorm:"primaryKey"`
+	OrderID   uint         `json:"order_id" gorm:"index"`
+	ProductID uint         `json:"product_id"`
+	Name      string       `json:"name"`
+	SKU       string       `json:"sku" gorm:"type:varchar(255);not null;default:''"`
+	Price     money.Amount `json:"price"` // Цена из каталога склада на момент создания заказа
+	Quantity  int          `json:"quantity"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// CatalogProduct товар каталога склада, по которому рассчитывается стоимость позиции заказа
+type CatalogProduct struct {
+	ProductID uint         `json:"product_id"`
+	SKU       string       `json:"sku"`
+	Name      string       `json:"name"`
+	Price     money.Amount `json:"price"`
+	Status    string       `json:"status"`
+}
+
+// OrderItemsToSaga преобразует позиции заказа в позиции контракта саги.
+// OrderItem и sagahandler.OrderItem должны совпадать по набору полей: иначе преобразование не скомпилируется,
+// и поле не будет молча потеряно при передаче между сервисами.
+func OrderItemsToSaga(items []OrderItem) []sagahandler.OrderItem {
+	result := make([]sagahandler.OrderItem, len(items))
+	for i, item := range items {
+		result[i] = sagahandler.OrderItem(item)
+	}
+	return result
+}
+
+// OrderItemsFromSaga преобразует позиции контракта саги в позиции заказа
+func OrderItemsFromSaga(items []sagahandler.OrderItem) []OrderItem {
+	result := make([]OrderItem, len(items))
+	for i, item := range items {
+		result[i] = OrderItem(item)
+	}
+	return result
 }
 
 // Order хранит информацию о заказе клиента, его статусе и связанных товарах
 type Order struct {
-	ID               uint            `json:"id" gorm:"primaryKey"`
-	UserID           uint            `json:"user_id" gorm:"index"`
-	Items            []OrderItem     `json:"items" gorm:"foreignKey:OrderID"`
-	Amount           float64         `json:"amount"`
-	Status           OrderStatus     `json:"status"`
-	CreatedAt        time.Time       `json:"created_at"`
-	UpdatedAt        time.Time       `json:"updated_at"`
-	DeletedAt        *time.Time      `json:"-" gorm:"index"`
-	User             User            `json:"-" gorm:"foreignKey:UserID"`
-	CompensatedSteps map[string]bool `json:"-" gorm:"-"`
+	ID                  uint            `json:"id" gorm:"primaryKey"`
+	UserID              uint            `json:"user_id" gorm:"index"`
+	Items               []OrderItem     `json:"items" gorm:"foreignKey:OrderID"`
+	Amount              money.Amount    `json:"amount"`
+	Currency            money.Currency  `json:"currency" gorm:"type:varchar(3);not null;default:'RUB'"`
+	Status              OrderStatus     `json:"status"`
+	ExpectedAvailableAt *time.Time      `json:"expected_available_at,omitempty"` // Ожидаемая дата поступления товаров заказа под поступление
+	CreatedAt           time.Time       `json:"created_at"`
+	UpdatedAt           time.Time       `json:"updated_at"`
+	DeletedAt           *time.Time      `json:"-" gorm:"index"`
+	User                User            `json:"-" gorm:"foreignKey:UserID"`
+	CompensatedSteps    map[string]bool `json:"-" gorm:"-"`
 }
 
 // CreateOrderRequest запрос на создание заказа
 type CreateOrderRequest struct {
 	UserID   uint             `json:"user_id"`
 	Items    []OrderItem      `json:"items" binding:"required,min=1"`
-	Amount   float64          `json:"amount" binding:"omitempty,min=0"`
+	Amount   money.Amount     `json:"amount" binding:"omitempty,min=0"`
+	Currency money.Currency   `json:"currency" binding:"omitempty,len=3"` // Ожидаемая клиентом валюта, сверяется с валютой каталога
 	Delivery *DeliveryRequest `json:"delivery,omitempty"`
 }
 
@@ -61,21 +110,25 @@ type DeliveryRequest struct {
 
 // CreateOrderResponse ответ на запрос создания заказа
 type CreateOrderResponse struct {
-	ID        uint        `json:"id"`
-	UserID    uint        `json:"user_id"`
-	Items     []OrderItem `json:"items"`
-	Amount    float64     `json:"amount"`
-	Status    OrderStatus `json:"status"`
-	CreatedAt time.Time   `json:"created_at"`
+	ID        uint           `json:"id"`
+	UserID    uint           `json:"user_id"`
+	Items     []OrderItem    `json:"items"`
+	Amount    money.Amount   `json:"amount"`
+	Currency  money.Currency `json:"currency"`
+	Status    OrderStatus    `json:"status"`
+	CreatedAt time.Time      `json:"created_at"`
 }
 
 type GetOrderResponse struct {
-	ID        uint        `json:"id"`
-	UserID    uint        `json:"user_id"`
-	Amount    float64     `json:"amount"`
-	Status    OrderStatus `json:"status"`
-	CreatedAt time.Time   `json:"created_at"`
-	UpdatedAt time.Time   `json:"updated_at"`
+	ID       uint           `json:"id"`
+	UserID   uint           `json:"user_id"`
+	Amount   money.Amount   `json:"amount"`
+	Currency money.Currency `json:"currency"`
+	Status   OrderStatus    `json:"status"`
+	// ExpectedAvailableAt ожидаемая дата поступления товаров заказа в статусе backordered
+	ExpectedAvailableAt *time.Time `json:"expected_available_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
 }
 
 type ListOrdersResponse struct {
@@ -83,7 +136,18 @@ type ListOrdersResponse struct {
 	Total  int64              `json:"total"`
 }
 
+// CancelOrderRequest запрос на отмену заказа
+type CancelOrderRequest struct {
+	Reason string `json:"reason"`
+}
+
+// CancelOrderResponse ответ на запрос отмены заказа
+type CancelOrderResponse struct {
+	ID     uint        `json:"id"`
+	Status OrderStatus `json:"status"`
+}
+
 type BillingRequest struct {
-	UserID uint    `json:"user_id"`
-	Amount float64 `json:"amount"`
+	UserID uint         `json:"user_id"`
+	Amount money.Amount `json:"amount"`
 }
@@ -20,6 +20,7 @@ const (
 // SagaState представляет состояние саги, хранящееся в БД
 type SagaState struct {
 	SagaID            string            `gorm:"primaryKey;type:varchar(255)"`
+	SagaType          string            `gorm:"not null;type:varchar(100);default:order"` // Имя определения саги
 	OrderID           uint              `gorm:"not null;index"`
 	Status            SagaStatus        `gorm:"not null;type:varchar(50);default:running;index"`
 	CompensatedSteps  datatypes.JSONMap `gorm:"not null;default:'{}'"` // Используем datatypes.JSONMap для JSONB
@@ -37,3 +38,34 @@ type SagaState struct {
 func (SagaState) TableName() string {
 	return "saga_states"
 }
+
+// SagaStepStatus представляет статус отдельного шага саги
+type SagaStepStatus string
+
+const (
+	SagaStepStatusRunning   SagaStepStatus = "running"   // Шаг отправлен, ожидается результат
+	SagaStepStatusCompleted SagaStepStatus = "completed" // Шаг успешно выполнен
+	SagaStepStatusFailed    SagaStepStatus = "failed"    // Шаг завершился ошибкой
+	SagaStepStatusTimedOut  SagaStepStatus = "timed_out" // Результат шага не получен в срок
+)
+
+// SagaStepState представляет состояние отправленного шага саги.
+// Запись создается при публикации шага; независимые шаги выполняются параллельно,
+// поэтому у саги может быть несколько шагов в статусе running.
+type SagaStepState struct {
+	ID        uint           `gorm:"primaryKey"`
+	SagaID    string         `gorm:"not null;type:varchar(255);uniqueIndex:idx_saga_steps_saga_step"`
+	StepName  string         `gorm:"not null;type:varchar(100);uniqueIndex:idx_saga_steps_saga_step"`
+	Status    SagaStepStatus `gorm:"not null;type:varchar(50);default:running"`
+	Deadline  *time.Time     `gorm:"index"`              // Крайний срок получения результата
+	Attempts  int            `gorm:"not null;default:0"` // Количество отправок шага
+	Payload   datatypes.JSON `gorm:"type:jsonb"`         // Данные, отправленные шагу (для повтора и компенсации)
+	Result    datatypes.JSON `gorm:"type:jsonb"`         // Данные из результата шага (для объединения параллельных веток)
+	CreatedAt time.Time      `gorm:"not null;default:now()"`
+	UpdatedAt time.Time      `gorm:"not null;default:now()"`
+}
+
+// TableName задает имя таблицы для GORM
+func (SagaStepState) TableName() string {
+	return "saga_steps"
+}
@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/database"
+)
+
+// CartRepository интерфейс репозитория для работы с корзинами
+type CartRepository interface {
+	GetOrCreate(ctx context.Context, userID uint) (*entity.Cart, error)
+	AddItem(ctx context.Context, cartID, productID uint, quantity int) error
+	SetItemQuantity(ctx context.Context, cartID, productID uint, quantity int) error
+	RemoveItem(ctx context.Context, cartID, productID uint) error
+	RemoveItems(ctx context.Context, cartID uint, itemIDs []uint) error
+	Clear(ctx context.Context, cartID uint) error
+}
+
+// ErrCartItemNotFound ошибка, когда товара нет в корзине
+var ErrCartItemNotFound = errors.New("товар не найден в корзине")
+
+// CartRepositoryImpl реализация репозитория корзин на GORM
+type CartRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewCartRepository(db *gorm.DB) CartRepository {
+	return &CartRepositoryImpl{
+		db: db,
+	}
+}
+
+// GetOrCreate возвращает корзину пользователя с позициями, создавая пустую корзину при первом обращении
+func (r *CartRepositoryImpl) GetOrCreate(ctx context.Context, userID uint) (*entity.Cart, error) {
+	var cart entity.Cart
+	// ON CONFLICT DO NOTHING защищает от гонки при одновременном первом обращении с разных устройств
+	err := r.conn(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&entity.Cart{UserID: userID}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := r.conn(ctx).
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("user_id = ?", userID).
+		First(&cart)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &cart, nil
+}
+
+// AddItem добавляет товар в корзину. Если товар уже есть, количество увеличивается.
+func (r *CartRepositoryImpl) AddItem(ctx context.Context, cartID, productID uint, quantity int) error {
+	item := &entity.CartItem{
+		CartID:    cartID,
+		ProductID: productID,
+		Quantity:  quantity,
+	}
+	err := r.conn(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "cart_id"}, {Name: "product_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"quantity":   gorm.Expr("cart_items.quantity + excluded.quantity"),
+			"updated_at": time.Now(),
+		}),
+	}).Create(item).Error
+	if err != nil {
+		return err
+	}
+	return r.touch(ctx, cartID)
+}
+
+// SetItemQuantity устанавливает количество товара в корзине
+func (r *CartRepositoryImpl) SetItemQuantity(ctx context.Context, cartID, productID uint, quantity int) error {
+	result := r.conn(ctx).Model(&entity.CartItem{}).
+		Where("cart_id = ? AND product_id = ?", cartID, productID).
+		Update("quantity", quantity)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCartItemNotFound
+	}
+	return r.touch(ctx, cartID)
+}
+
+// RemoveItem удаляет товар из корзины
+func (r *CartRepositoryImpl) RemoveItem(ctx context.Context, cartID, productID uint) error {
+	result := r.conn(ctx).Where("cart_id = ? AND product_id = ?", cartID, productID).Delete(&entity.CartItem{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCartItemNotFound
+	}
+	return r.touch(ctx, cartID)
+}
+
+// RemoveItems удаляет из корзины позиции с указанными ID
+func (r *CartRepositoryImpl) RemoveItems(ctx context.Context, cartID uint, itemIDs []uint) error {
+	if len(itemIDs) == 0 {
+		return nil
+	}
+	if err := r.conn(ctx).Where("cart_id = ? AND id IN ?", cartID, itemIDs).Delete(&entity.CartItem{}).Error; err != nil {
+		return err
+	}
+	return r.touch(ctx, cartID)
+}
+
+// Clear удаляет все позиции корзины
+func (r *CartRepositoryImpl) Clear(ctx context.Context, cartID uint) error {
+	if err := r.conn(ctx).Where("cart_id = ?", cartID).Delete(&entity.CartItem{}).Error; err != nil {
+		return err
+	}
+	return r.touch(ctx, cartID)
+}
+
+// touch обновляет время изменения корзины
+func (r *CartRepositoryImpl) touch(ctx context.Context, cartID uint) error {
+	return r.conn(ctx).Model(&entity.Cart{}).Where("id = ?", cartID).Update("updated_at", time.Now()).Error
+}
+
+// conn возвращает соединение с учетом транзакции из контекста (см. database.WithTransaction)
+func (r *CartRepositoryImpl) conn(ctx context.Context) *gorm.DB {
+	return database.Conn(ctx, r.db)
+}
@@ -3,10 +3,12 @@ package repo
 import (
 	"context"
 	"errors"
+	"time"
 
 	"gorm.io/gorm"
 
 	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/database"
 )
 
 // OrderRepository интерфейс репозитория для работы с заказами
@@ -19,6 +21,7 @@ type OrderRepository interface {
 	Delete(ctx context.Context, id uint) error
 	ListOrdersByUserID(ctx context.Context, userID uint, limit, offset int) ([]entity.Order, int64, error)
 	UpdateOrderStatus(ctx context.Context, orderID uint, status entity.OrderStatus) error
+	UpdateBackorderStatus(ctx context.Context, orderID uint, expectedAt *time.Time) error
 }
 
 // ErrOrderNotFound ошибка, когда заказ не найден
@@ -36,12 +39,12 @@ func NewOrderRepository(db *gorm.DB) OrderRepository {
 }
 
 func (r *OrderRepositoryImpl) Create(ctx context.Context, order *entity.Order) error {
-	return r.db.WithContext(ctx).Create(order).Error
+	return r.conn(ctx).Create(order).Error
 }
 
 func (r *OrderRepositoryImpl) GetByID(ctx context.Context, id uint) (*entity.Order, error) {
 	var order entity.Order
-	result := r.db.WithContext(ctx).First(&order, id)
+	result := r.conn(ctx).First(&order, id)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, ErrOrderNotFound
@@ -53,7 +56,7 @@ func (r *OrderRepositoryImpl) GetByID(ctx context.Context, id uint) (*entity.Ord
 
 func (r *OrderRepositoryImpl) GetByUserID(ctx context.Context, userID uint, limit, offset int) ([]entity.Order, error) {
 	var orders []entity.Order
-	result := r.db.WithContext(ctx).
+	result := r.conn(ctx).
 		Where("user_id = ?", userID).
 		Limit(limit).
 		Offset(offset).
@@ -69,7 +72,7 @@ func (r *OrderRepositoryImpl) GetByUserID(ctx context.Context, userID uint, limi
 // CountByUserID подсчитывает количество заказов пользователя
 func (r *OrderRepositoryImpl) CountByUserID(ctx context.Context, userID uint) (int64, error) {
 	var count int64
-	result := r.db.WithContext(ctx).
+	result := r.conn(ctx).
 		Model(&entity.Order{}).
 		Where("user_id = ?", userID).
 		Count(&count)
@@ -82,12 +85,12 @@ func (r *OrderRepositoryImpl) CountByUserID(ctx context.Context, userID uint) (i
 
 // Update обновляет заказ
 func (r *OrderRepositoryImpl) Update(ctx context.Context, order *entity.Order) error {
-	return r.db.WithContext(ctx).Save(order).Error
+	return r.conn(ctx).Save(order).Error
 }
 
 // Delete удаляет заказ
 func (r *OrderRepositoryImpl) Delete(ctx context.Context, id uint) error {
-	return r.db.WithContext(ctx).Delete(&entity.Order{}, id).Error
+	return r.conn(ctx).Delete(&entity.Order{}, id).Error
 }
 
 func (r *OrderRepositoryImpl) ListOrdersByUserID(ctx context.Context, userID uint, limit, offset int) ([]entity.Order, int64, error) {
@@ -106,7 +109,7 @@ func (r *OrderRepositoryImpl) ListOrdersByUserID(ctx context.Context, userID uin
 
 // UpdateOrderStatus обновляет только статус заказа
 func (r *OrderRepositoryImpl) UpdateOrderStatus(ctx context.Context, orderID uint, status entity.OrderStatus) error {
-	result := r.db.WithContext(ctx).Model(&entity.Order{}).Where("id = ?", orderID).Update("status", status)
+	result := r.conn(ctx).Model(&entity.Order{}).Where("id = ?", orderID).Update("status", status)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -115,3 +118,23 @@ func (r *OrderRepositoryImpl) UpdateOrderStatus(ctx context.Context, orderID uin
 	}
 	return nil
 }
+
+// UpdateBackorderStatus переводит заказ в статус backordered и сохраняет ожидаемую дату поступления товаров
+func (r *OrderRepositoryImpl) UpdateBackorderStatus(ctx context.Context, orderID uint, expectedAt *time.Time) error {
+	result := r.conn(ctx).Model(&entity.Order{}).Where("id = ?", orderID).Updates(map[string]interface{}{
+		"status":                entity.OrderStatusBackordered,
+		"expected_available_at": expectedAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOrderNotFound
+	}
+	return nil
+}
+
+// conn возвращает соединение с учетом транзакции из контекста (см. database.WithTransaction)
+func (r *OrderRepositoryImpl) conn(ctx context.Context) *gorm.DB {
+	return database.Conn(ctx, r.db)
+}
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/database"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -31,7 +33,7 @@ func (r *sagaStateRepository) Create(ctx context.Context, state *entity.SagaStat
 		state.CompensatedSteps = make(map[string]interface{})
 	}
 
-	result := r.db.WithContext(ctx).Create(state)
+	result := r.conn(ctx).Create(state)
 	if result.Error != nil {
 		return fmt.Errorf("ошибка создания состояния саги %s: %w", state.SagaID, result.Error)
 	}
@@ -41,7 +43,7 @@ func (r *sagaStateRepository) Create(ctx context.Context, state *entity.SagaStat
 // GetByID получает состояние саги по ее ID
 func (r *sagaStateRepository) GetByID(ctx context.Context, sagaID string) (*entity.SagaState, error) {
 	var state entity.SagaState
-	result := r.db.WithContext(ctx).First(&state, "saga_id = ?", sagaID)
+	result := r.conn(ctx).First(&state, "saga_id = ?", sagaID)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			return nil, result.Error // Возвращаем ошибку "не найдено"
@@ -51,6 +53,34 @@ func (r *sagaStateRepository) GetByID(ctx context.Context, sagaID string) (*enti
 	return &state, nil
 }
 
+// LockByID получает состояние саги и блокирует его запись до конца транзакции.
+// Должна вызываться в транзакции (см. database.WithTransaction), иначе блокировка снимается сразу.
+func (r *sagaStateRepository) LockByID(ctx context.Context, sagaID string) (*entity.SagaState, error) {
+	var state entity.SagaState
+	result := r.conn(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).First(&state, "saga_id = ?", sagaID)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, result.Error
+		}
+		return nil, fmt.Errorf("ошибка блокировки состояния саги %s: %w", sagaID, result.Error)
+	}
+	return &state, nil
+}
+
+// GetByOrderID получает последнее незавершенное состояние саги заказа.
+// Завершенные саги удаляются, поэтому отсутствие записи означает, что активной саги у заказа нет.
+func (r *sagaStateRepository) GetByOrderID(ctx context.Context, orderID uint) (*entity.SagaState, error) {
+	var state entity.SagaState
+	result := r.conn(ctx).Where("order_id = ?", orderID).Order("created_at DESC").First(&state)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return nil, result.Error
+		}
+		return nil, fmt.Errorf("ошибка получения состояния саги заказа %d: %w", orderID, result.Error)
+	}
+	return &state, nil
+}
+
 // Update обновляет существующее состояние саги
 func (r *sagaStateRepository) Update(ctx context.Context, state *entity.SagaState) error {
 	// Обновляем время обновления
@@ -62,7 +92,7 @@ func (r *sagaStateRepository) Update(ctx context.Context, state *entity.SagaStat
 
 	// Используем Clauses(clause.Returning{}) чтобы GORM вернул обновленную запись (если нужно)
 	// Используем Omit(clause.Associations) чтобы не пытаться обновить связанные сущности (Order)
-	result := r.db.WithContext(ctx).Omit(clause.Associations).Save(state)
+	result := r.conn(ctx).Omit(clause.Associations).Save(state)
 	if result.Error != nil {
 		return fmt.Errorf("ошибка обновления состояния саги %s: %w", state.SagaID, result.Error)
 	}
@@ -74,16 +104,153 @@ func (r *sagaStateRepository) Update(ctx context.Context, state *entity.SagaStat
 	return nil
 }
 
-// Delete удаляет состояние саги по ее ID
+// Delete удаляет состояние саги и состояния ее шагов по ID саги
 func (r *sagaStateRepository) Delete(ctx context.Context, sagaID string) error {
-	// Создаем пустой экземпляр, чтобы указать GORM таблицу и ключ
-	result := r.db.WithContext(ctx).Delete(&entity.SagaState{SagaID: sagaID})
+	return r.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("saga_id = ?", sagaID).Delete(&entity.SagaStepState{}).Error; err != nil {
+			return fmt.Errorf("ошибка удаления шагов саги %s: %w", sagaID, err)
+		}
+		// Создаем пустой экземпляр, чтобы указать GORM таблицу и ключ
+		result := tx.Delete(&entity.SagaState{SagaID: sagaID})
+		if result.Error != nil {
+			return fmt.Errorf("ошибка удаления состояния саги %s: %w", sagaID, result.Error)
+		}
+		// GORM может не вернуть ошибку, если запись не найдена. Проверяем RowsAffected.
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound // Указываем, что запись для удаления не найдена
+		}
+		return nil
+	})
+}
+
+// CreateStep сохраняет отправленный шаг саги.
+// Возвращает false, если шаг уже был запущен ранее (например, при повторной доставке результата предыдущего шага).
+func (r *sagaStateRepository) CreateStep(ctx context.Context, step *entity.SagaStepState) (bool, error) {
+	now := time.Now()
+	step.CreatedAt = now
+	step.UpdatedAt = now
+
+	result := r.conn(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "saga_id"}, {Name: "step_name"}}, DoNothing: true}).
+		Create(step)
 	if result.Error != nil {
-		return fmt.Errorf("ошибка удаления состояния саги %s: %w", sagaID, result.Error)
+		return false, fmt.Errorf("ошибка сохранения шага %s саги %s: %w", step.StepName, step.SagaID, result.Error)
 	}
-	// GORM может не вернуть ошибку, если запись не найдена. Проверяем RowsAffected.
-	if result.RowsAffected == 0 {
-		return gorm.ErrRecordNotFound // Указываем, что запись для удаления не найдена
+	return result.RowsAffected > 0, nil
+}
+
+// GetSteps возвращает состояния всех запущенных шагов саги
+func (r *sagaStateRepository) GetSteps(ctx context.Context, sagaID string) ([]entity.SagaStepState, error) {
+	var steps []entity.SagaStepState
+	if err := r.conn(ctx).Where("saga_id = ?", sagaID).Order("id").Find(&steps).Error; err != nil {
+		return nil, fmt.Errorf("ошибка получения шагов саги %s: %w", sagaID, err)
 	}
-	return nil
+	return steps, nil
+}
+
+// CompleteStep фиксирует результат выполняющегося шага.
+// Возвращает false, если шаг уже не выполняется (результат обработан ранее или зафиксирован таймаут).
+func (r *sagaStateRepository) CompleteStep(ctx context.Context, sagaID, stepName string, status entity.SagaStepStatus, stepResult datatypes.JSON) (bool, error) {
+	result := r.conn(ctx).Model(&entity.SagaStepState{}).
+		Where("saga_id = ? AND step_name = ? AND status = ?", sagaID, stepName, entity.SagaStepStatusRunning).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"deadline":   nil,
+			"result":     stepResult,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("ошибка фиксации результата шага %s саги %s: %w", stepName, sagaID, result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// FindExpiredSteps возвращает выполняющиеся шаги активных саг, у которых истек срок ожидания результата
+func (r *sagaStateRepository) FindExpiredSteps(ctx context.Context, now time.Time, limit int) ([]entity.SagaStepState, error) {
+	var steps []entity.SagaStepState
+	result := r.conn(ctx).
+		Joins("JOIN saga_states ON saga_states.saga_id = saga_steps.saga_id").
+		Where("saga_steps.status = ? AND saga_steps.deadline IS NOT NULL AND saga_steps.deadline < ?", entity.SagaStepStatusRunning, now).
+		Where("saga_states.status IN ?", []entity.SagaStatus{entity.SagaStatusRunning, entity.SagaStatusCompensating}).
+		Order("saga_steps.deadline").
+		Limit(limit).
+		Find(&steps)
+	if result.Error != nil {
+		return nil, fmt.Errorf("ошибка поиска шагов саг с истекшим сроком: %w", result.Error)
+	}
+	return steps, nil
+}
+
+// RescheduleExpiredStep продлевает срок ожидания шага перед повторной отправкой.
+// Обновление выполняется только если шаг и его дедлайн не изменились с момента чтения,
+// поэтому возвращает false, если результат шага уже был обработан параллельно.
+func (r *sagaStateRepository) RescheduleExpiredStep(ctx context.Context, sagaID, stepName string, deadline, nextDeadline time.Time) (bool, error) {
+	result := r.conn(ctx).Model(&entity.SagaStepState{}).
+		Where("saga_id = ? AND step_name = ? AND status = ? AND deadline = ?", sagaID, stepName, entity.SagaStepStatusRunning, deadline).
+		Updates(map[string]interface{}{
+			"deadline":   nextDeadline,
+			"attempts":   gorm.Expr("attempts + 1"),
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("ошибка продления срока шага %s саги %s: %w", stepName, sagaID, result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ExtendStepDeadline переносит срок ожидания результата выполняющегося шага на deadline, не считая это
+// повторной отправкой. Возвращает false, если шаг уже не выполняется.
+func (r *sagaStateRepository) ExtendStepDeadline(ctx context.Context, sagaID, stepName string, deadline time.Time) (bool, error) {
+	result := r.conn(ctx).Model(&entity.SagaStepState{}).
+		Where("saga_id = ? AND step_name = ? AND status = ?", sagaID, stepName, entity.SagaStepStatusRunning).
+		Updates(map[string]interface{}{
+			"deadline":   deadline,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("ошибка переноса срока шага %s саги %s: %w", stepName, sagaID, result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// MarkStepTimedOut фиксирует таймаут шага и переводит выполняющуюся сагу в компенсацию.
+// Как и RescheduleExpiredStep, срабатывает только для неизменившегося шага.
+// Сага, которая уже компенсируется, остается в статусе Compensating.
+func (r *sagaStateRepository) MarkStepTimedOut(ctx context.Context, sagaID, stepName string, deadline time.Time, errorMessage string) (bool, error) {
+	claimed := false
+	err := r.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&entity.SagaStepState{}).
+			Where("saga_id = ? AND step_name = ? AND status = ? AND deadline = ?", sagaID, stepName, entity.SagaStepStatusRunning, deadline).
+			Updates(map[string]interface{}{
+				"status":     entity.SagaStepStatusTimedOut,
+				"deadline":   nil,
+				"updated_at": now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		claimed = true
+
+		return tx.Model(&entity.SagaState{}).
+			Where("saga_id = ? AND status = ?", sagaID, entity.SagaStatusRunning).
+			Updates(map[string]interface{}{
+				"status":        entity.SagaStatusCompensating,
+				"last_step":     stepName,
+				"error_message": errorMessage,
+				"updated_at":    now,
+			}).Error
+	})
+	if err != nil {
+		return false, fmt.Errorf("ошибка фиксации таймаута шага %s саги %s: %w", stepName, sagaID, err)
+	}
+	return claimed, nil
+}
+
+// conn возвращает соединение с учетом транзакции из контекста (см. database.WithTransaction)
+func (r *sagaStateRepository) conn(ctx context.Context) *gorm.DB {
+	return database.Conn(ctx, r.db)
 }
@@ -0,0 +1,260 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"github.com/director74/dz8_shop/order-service/internal/repo"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+var (
+	// ErrCartEmpty корзина пуста
+	ErrCartEmpty = errors.New("корзина пуста")
+	// ErrCartItemNotFound товара нет в корзине
+	ErrCartItemNotFound = repo.ErrCartItemNotFound
+	// ErrCartUnavailable часть товаров корзины отсутствует на складе в нужном количестве
+	ErrCartUnavailable = errors.New("недостаточно товаров на складе")
+)
+
+// OrderCreator создает заказ и запускает сагу его обработки
+type OrderCreator interface {
+	CreateOrder(ctx context.Context, req entity.CreateOrderRequest) (entity.CreateOrderResponse, error)
+}
+
+// CartUseCase представляет usecase для работы с корзиной пользователя
+type CartUseCase struct {
+	repo     repo.CartRepository
+	catalog  ProductCatalog
+	stock    StockChecker
+	orders   OrderCreator
+	currency money.Currency
+	logger   *log.Logger
+}
+
+func NewCartUseCase(cartRepo repo.CartRepository, catalog ProductCatalog, stock StockChecker, orders OrderCreator) *CartUseCase {
+	return &CartUseCase{
+		repo:    cartRepo,
+		catalog: catalog,
+		stock:   stock,
+		orders:  orders,
+		logger:  log.New(log.Writer(), "[CartUseCase] ", log.LstdFlags),
+	}
+}
+
+// UseCurrency задает валюту цен каталога. По умолчанию - money.DefaultCurrency.
+func (uc *CartUseCase) UseCurrency(currency money.Currency) {
+	uc.currency = currency
+}
+
+// GetCart возвращает корзину пользователя с актуальными ценами каталога и наличием на складе
+func (uc *CartUseCase) GetCart(ctx context.Context, userID uint) (entity.CartResponse, error) {
+	cart, err := uc.repo.GetOrCreate(ctx, userID)
+	if err != nil {
+		return entity.CartResponse{}, fmt.Errorf("ошибка получения корзины: %w", err)
+	}
+	return uc.describe(ctx, cart)
+}
+
+// AddItem добавляет товар в корзину. Повторное добавление увеличивает количество.
+func (uc *CartUseCase) AddItem(ctx context.Context, userID uint, req entity.AddCartItemRequest) (entity.CartResponse, error) {
+	product, err := uc.catalog.GetProduct(ctx, req.ProductID)
+	if err != nil {
+		return entity.CartResponse{}, fmt.Errorf("ошибка получения товара %d: %w", req.ProductID, err)
+	}
+	if product == nil {
+		return entity.CartResponse{}, fmt.Errorf("%w: %d", ErrProductNotFound, req.ProductID)
+	}
+	if product.Status == "unavailable" {
+		return entity.CartResponse{}, fmt.Errorf("%w: %d", ErrProductUnavailable, req.ProductID)
+	}
+
+	cart, err := uc.repo.GetOrCreate(ctx, userID)
+	if err != nil {
+		return entity.CartResponse{}, fmt.Errorf("ошибка получения корзины: %w", err)
+	}
+	if err := uc.repo.AddItem(ctx, cart.ID, req.ProductID, req.Quantity); err != nil {
+		return entity.CartResponse{}, fmt.Errorf("ошибка добавления товара в корзину: %w", err)
+	}
+	return uc.GetCart(ctx, userID)
+}
+
+// UpdateItem изменяет количество товара в корзине. Количество 0 удаляет товар из корзины.
+func (uc *CartUseCase) UpdateItem(ctx context.Context, userID, productID uint, quantity int) (entity.CartResponse, error) {
+	if quantity == 0 {
+		return uc.RemoveItem(ctx, userID, productID)
+	}
+
+	cart, err := uc.repo.GetOrCreate(ctx, userID)
+	if err != nil {
+		return entity.CartResponse{}, fmt.Errorf("ошибка получения корзины: %w", err)
+	}
+	if err := uc.repo.SetItemQuantity(ctx, cart.ID, productID, quantity); err != nil {
+		return entity.CartResponse{}, fmt.Errorf("ошибка изменения количества товара %d: %w", productID, err)
+	}
+	return uc.GetCart(ctx, userID)
+}
+
+// RemoveItem удаляет товар из корзины
+func (uc *CartUseCase) RemoveItem(ctx context.Context, userID, productID uint) (entity.CartResponse, error) {
+	cart, err := uc.repo.GetOrCreate(ctx, userID)
+	if err != nil {
+		return entity.CartResponse{}, fmt.Errorf("ошибка получения корзины: %w", err)
+	}
+	if err := uc.repo.RemoveItem(ctx, cart.ID, productID); err != nil {
+		return entity.CartResponse{}, fmt.Errorf("ошибка удаления товара %d из корзины: %w", productID, err)
+	}
+	return uc.GetCart(ctx, userID)
+}
+
+// ClearCart удаляет все товары из корзины
+func (uc *CartUseCase) ClearCart(ctx context.Context, userID uint) error {
+	cart, err := uc.repo.GetOrCreate(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка получения корзины: %w", err)
+	}
+	if err := uc.repo.Clear(ctx, cart.ID); err != nil {
+		return fmt.Errorf("ошибка очистки корзины: %w", err)
+	}
+	return nil
+}
+
+// Checkout оформляет заказ из корзины: проверяет наличие товаров на складе, создает заказ
+// с ценами каталога, запуская сагу заказа, и удаляет оформленные позиции из корзины.
+// Если недостающие товары можно заказать под поступление, заказ оформляется и ждет поступления товара на склад.
+func (uc *CartUseCase) Checkout(ctx context.Context, userID uint, req entity.CheckoutRequest) (entity.CreateOrderResponse, error) {
+	cart, err := uc.repo.GetOrCreate(ctx, userID)
+	if err != nil {
+		return entity.CreateOrderResponse{}, fmt.Errorf("ошибка получения корзины: %w", err)
+	}
+	if len(cart.Items) == 0 {
+		return entity.CreateOrderResponse{}, ErrCartEmpty
+	}
+
+	availability, err := uc.stock.CheckAvailability(ctx, toStockRequest(cart.Items))
+	if err != nil {
+		return entity.CreateOrderResponse{}, fmt.Errorf("ошибка проверки наличия товаров: %w", err)
+	}
+	if !availability.Available && !availability.Backorderable {
+		shortage := availability.UnavailableItems
+		if len(shortage) > 0 {
+			return entity.CreateOrderResponse{}, fmt.Errorf("%w: товар %d, запрошено %d, в наличии %d",
+				ErrCartUnavailable, shortage[0].ProductID, shortage[0].RequestedQuantity, shortage[0].AvailableQuantity)
+		}
+		return entity.CreateOrderResponse{}, ErrCartUnavailable
+	}
+
+	orderReq := entity.CreateOrderRequest{
+		UserID:   userID,
+		Items:    make([]entity.OrderItem, 0, len(cart.Items)),
+		Amount:   req.Amount,
+		Currency: req.Currency,
+		Delivery: req.Delivery,
+	}
+	itemIDs := make([]uint, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		orderReq.Items = append(orderReq.Items, entity.OrderItem{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+		})
+		itemIDs = append(itemIDs, item.ID)
+	}
+
+	resp, err := uc.orders.CreateOrder(ctx, orderReq)
+	if err != nil {
+		return entity.CreateOrderResponse{}, err
+	}
+
+	// Удаляем только оформленные позиции: товары, добавленные с другого устройства во время оформления, остаются в корзине
+	if err := uc.repo.RemoveItems(ctx, cart.ID, itemIDs); err != nil {
+		uc.logger.Printf("[Cart][ERROR] Заказ %d создан, но корзина пользователя %d не очищена: %v", resp.ID, userID, err)
+	}
+	uc.logger.Printf("[Cart] Корзина пользователя %d оформлена в заказ ID=%d", userID, resp.ID)
+
+	return resp, nil
+}
+
+// describe дополняет позиции корзины ценами каталога и наличием на складе
+func (uc *CartUseCase) describe(ctx context.Context, cart *entity.Cart) (entity.CartResponse, error) {
+	response := entity.CartResponse{
+		UserID:    cart.UserID,
+		Items:     make([]entity.CartItemResponse, 0, len(cart.Items)),
+		Currency:  uc.currency.OrDefault(),
+		UpdatedAt: cart.UpdatedAt,
+	}
+
+	var orderable []entity.CartItem
+	var total money.Amount
+	for _, item := range cart.Items {
+		line := entity.CartItemResponse{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+			Status:    "not_found",
+		}
+
+		product, err := uc.catalog.GetProduct(ctx, item.ProductID)
+		if err != nil {
+			return entity.CartResponse{}, fmt.Errorf("ошибка получения товара %d: %w", item.ProductID, err)
+		}
+		if product != nil {
+			line.SKU = product.SKU
+			line.Name = product.Name
+			line.Price = product.Price
+			line.Status = product.Status
+			line.Subtotal, err = product.Price.Mul(item.Quantity)
+			if err != nil {
+				return entity.CartResponse{}, fmt.Errorf("сумма позиции товара %d: %w", item.ProductID, err)
+			}
+			total += line.Subtotal
+			if product.Status != "unavailable" {
+				line.Available = true
+				orderable = append(orderable, item)
+			}
+		}
+		response.Items = append(response.Items, line)
+	}
+	response.Total = total
+
+	if len(orderable) > 0 {
+		availability, err := uc.stock.CheckAvailability(ctx, toStockRequest(orderable))
+		if err != nil {
+			return entity.CartResponse{}, fmt.Errorf("ошибка проверки наличия товаров: %w", err)
+		}
+		for _, shortage := range availability.UnavailableItems {
+			for i := range response.Items {
+				if response.Items[i].ProductID == shortage.ProductID {
+					available := shortage.AvailableQuantity
+					// Товар под поступление можно оформить: заказ дождется его на складе
+					response.Items[i].Available = shortage.Backorderable
+					response.Items[i].AvailableQuantity = &available
+					response.Items[i].Backorder = shortage.Backorderable
+					response.Items[i].ExpectedAvailableAt = shortage.ExpectedAvailableAt
+				}
+			}
+		}
+	}
+
+	response.Available = len(response.Items) > 0
+	for _, line := range response.Items {
+		if !line.Available {
+			response.Available = false
+			break
+		}
+	}
+	return response, nil
+}
+
+// toStockRequest преобразует позиции корзины в запрос проверки наличия на складе
+func toStockRequest(items []entity.CartItem) []entity.StockRequestItem {
+	result := make([]entity.StockRequestItem, len(items))
+	for i, item := range items {
+		result[i] = entity.StockRequestItem{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+		}
+	}
+	return result
+}
@@ -0,0 +1,197 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// Мок для CartRepository
+type MockCartRepository struct {
+	mock.Mock
+}
+
+func (m *MockCartRepository) GetOrCreate(ctx context.Context, userID uint) (*entity.Cart, error) {
+	args := m.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*entity.Cart), args.Error(1)
+}
+
+func (m *MockCartRepository) AddItem(ctx context.Context, cartID, productID uint, quantity int) error {
+	args := m.Called(ctx, cartID, productID, quantity)
+	return args.Error(0)
+}
+
+func (m *MockCartRepository) SetItemQuantity(ctx context.Context, cartID, productID uint, quantity int) error {
+	args := m.Called(ctx, cartID, productID, quantity)
+	return args.Error(0)
+}
+
+func (m *MockCartRepository) RemoveItem(ctx context.Context, cartID, productID uint) error {
+	args := m.Called(ctx, cartID, productID)
+	return args.Error(0)
+}
+
+func (m *MockCartRepository) RemoveItems(ctx context.Context, cartID uint, itemIDs []uint) error {
+	args := m.Called(ctx, cartID, itemIDs)
+	return args.Error(0)
+}
+
+func (m *MockCartRepository) Clear(ctx context.Context, cartID uint) error {
+	args := m.Called(ctx, cartID)
+	return args.Error(0)
+}
+
+// Мок для StockChecker
+type MockStockChecker struct {
+	mock.Mock
+}
+
+func (m *MockStockChecker) CheckAvailability(ctx context.Context, items []entity.StockRequestItem) (*entity.StockAvailability, error) {
+	args := m.Called(ctx, items)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*entity.StockAvailability), args.Error(1)
+}
+
+// Мок для OrderCreator
+type MockOrderCreator struct {
+	mock.Mock
+}
+
+func (m *MockOrderCreator) CreateOrder(ctx context.Context, req entity.CreateOrderRequest) (entity.CreateOrderResponse, error) {
+	args := m.Called(ctx, req)
+	return args.Get(0).(entity.CreateOrderResponse), args.Error(1)
+}
+
+func createTestCart() *entity.Cart {
+	return &entity.Cart{
+		ID:     3,
+		UserID: 5,
+		Items: []entity.CartItem{
+			{ID: 11, CartID: 3, ProductID: 1, Quantity: 2},
+			{ID: 12, CartID: 3, ProductID: 2, Quantity: 5},
+		},
+	}
+}
+
+// TestGetCart_LivePricesAndAvailability тестирует заполнение корзины ценами каталога и остатками склада
+func TestGetCart_LivePricesAndAvailability(t *testing.T) {
+	cartRepo := new(MockCartRepository)
+	catalog := new(MockProductCatalog)
+	stock := new(MockStockChecker)
+	uc := NewCartUseCase(cartRepo, catalog, stock, new(MockOrderCreator))
+
+	cartRepo.On("GetOrCreate", mock.Anything, uint(5)).Return(createTestCart(), nil)
+	catalog.On("GetProduct", mock.Anything, uint(1)).Return(&entity.CatalogProduct{ProductID: 1, SKU: "PHONE-001", Name: "Смартфон", Price: money.FromMinor(10010), Status: "available"}, nil)
+	catalog.On("GetProduct", mock.Anything, uint(2)).Return(&entity.CatalogProduct{ProductID: 2, SKU: "POWER-001", Name: "Аккумулятор", Price: money.FromMinor(10), Status: "available"}, nil)
+	stock.On("CheckAvailability", mock.Anything, []entity.StockRequestItem{{ProductID: 1, Quantity: 2}, {ProductID: 2, Quantity: 5}}).
+		Return(&entity.StockAvailability{
+			Available:        false,
+			UnavailableItems: []entity.StockShortage{{ProductID: 2, RequestedQuantity: 5, AvailableQuantity: 3}},
+		}, nil)
+
+	cart, err := uc.GetCart(context.Background(), 5)
+
+	assert.NoError(t, err)
+	assert.Equal(t, money.FromMinor(20070), cart.Total)
+	assert.False(t, cart.Available)
+	if assert.Len(t, cart.Items, 2) {
+		assert.Equal(t, "Смартфон", cart.Items[0].Name)
+		assert.Equal(t, money.FromMinor(20020), cart.Items[0].Subtotal)
+		assert.True(t, cart.Items[0].Available)
+		assert.False(t, cart.Items[1].Available)
+		if assert.NotNil(t, cart.Items[1].AvailableQuantity) {
+			assert.Equal(t, int64(3), *cart.Items[1].AvailableQuantity)
+		}
+	}
+}
+
+// TestCheckout_CreatesOrderAndRemovesItems тестирует оформление заказа из корзины
+func TestCheckout_CreatesOrderAndRemovesItems(t *testing.T) {
+	cartRepo := new(MockCartRepository)
+	stock := new(MockStockChecker)
+	orders := new(MockOrderCreator)
+	uc := NewCartUseCase(cartRepo, new(MockProductCatalog), stock, orders)
+
+	cartRepo.On("GetOrCreate", mock.Anything, uint(5)).Return(createTestCart(), nil)
+	stock.On("CheckAvailability", mock.Anything, mock.Anything).Return(&entity.StockAvailability{Available: true}, nil)
+	orders.On("CreateOrder", mock.Anything, mock.MatchedBy(func(req entity.CreateOrderRequest) bool {
+		return req.UserID == 5 && len(req.Items) == 2 &&
+			req.Items[0].ProductID == 1 && req.Items[0].Quantity == 2 && req.Items[0].Price == 0 &&
+			req.Items[1].ProductID == 2 && req.Items[1].Quantity == 5
+	})).Return(entity.CreateOrderResponse{ID: 10, UserID: 5, Status: entity.OrderStatusPending}, nil)
+	cartRepo.On("RemoveItems", mock.Anything, uint(3), []uint{11, 12}).Return(nil)
+
+	resp, err := uc.Checkout(context.Background(), 5, entity.CheckoutRequest{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint(10), resp.ID)
+	orders.AssertExpectations(t)
+	cartRepo.AssertExpectations(t)
+}
+
+// TestCheckout_Backorder тестирует оформление заказа, недостающие товары которого можно заказать под поступление
+func TestCheckout_Backorder(t *testing.T) {
+	cartRepo := new(MockCartRepository)
+	stock := new(MockStockChecker)
+	orders := new(MockOrderCreator)
+	uc := NewCartUseCase(cartRepo, new(MockProductCatalog), stock, orders)
+
+	cartRepo.On("GetOrCreate", mock.Anything, uint(5)).Return(createTestCart(), nil)
+	stock.On("CheckAvailability", mock.Anything, mock.Anything).Return(&entity.StockAvailability{
+		Available:        false,
+		UnavailableItems: []entity.StockShortage{{ProductID: 2, RequestedQuantity: 5, AvailableQuantity: 0, Backorderable: true}},
+		Backorderable:    true,
+	}, nil)
+	orders.On("CreateOrder", mock.Anything, mock.Anything).Return(entity.CreateOrderResponse{ID: 10, UserID: 5, Status: entity.OrderStatusPending}, nil)
+	cartRepo.On("RemoveItems", mock.Anything, uint(3), []uint{11, 12}).Return(nil)
+
+	resp, err := uc.Checkout(context.Background(), 5, entity.CheckoutRequest{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint(10), resp.ID)
+	orders.AssertExpectations(t)
+	cartRepo.AssertExpectations(t)
+}
+
+// TestCheckout_Rejected тестирует отказ в оформлении пустой корзины и корзины с недостающими товарами
+func TestCheckout_Rejected(t *testing.T) {
+	t.Run("empty cart", func(t *testing.T) {
+		cartRepo := new(MockCartRepository)
+		orders := new(MockOrderCreator)
+		uc := NewCartUseCase(cartRepo, new(MockProductCatalog), new(MockStockChecker), orders)
+		cartRepo.On("GetOrCreate", mock.Anything, uint(5)).Return(&entity.Cart{ID: 3, UserID: 5}, nil)
+
+		_, err := uc.Checkout(context.Background(), 5, entity.CheckoutRequest{})
+
+		assert.ErrorIs(t, err, ErrCartEmpty)
+		orders.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+	})
+
+	t.Run("out of stock", func(t *testing.T) {
+		cartRepo := new(MockCartRepository)
+		stock := new(MockStockChecker)
+		orders := new(MockOrderCreator)
+		uc := NewCartUseCase(cartRepo, new(MockProductCatalog), stock, orders)
+		cartRepo.On("GetOrCreate", mock.Anything, uint(5)).Return(createTestCart(), nil)
+		stock.On("CheckAvailability", mock.Anything, mock.Anything).Return(&entity.StockAvailability{
+			Available:        false,
+			UnavailableItems: []entity.StockShortage{{ProductID: 2, RequestedQuantity: 5, AvailableQuantity: 0}},
+		}, nil)
+
+		_, err := uc.Checkout(context.Background(), 5, entity.CheckoutRequest{})
+
+		assert.ErrorIs(t, err, ErrCartUnavailable)
+		orders.AssertNotCalled(t, "CreateOrder", mock.Anything, mock.Anything)
+		cartRepo.AssertNotCalled(t, "RemoveItems", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
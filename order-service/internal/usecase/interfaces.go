@@ -2,12 +2,26 @@ package usecase
 
 import (
 	"context"
+
+	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
 )
 
 // BillingService интерфейс для работы с сервисом биллинга
 type BillingService interface {
 	CreateAccount(ctx context.Context, userID uint) error
-	WithdrawMoney(ctx context.Context, userID uint, amount float64, email string, token string) (bool, error)
+	WithdrawMoney(ctx context.Context, userID uint, amount money.Amount, email string, token string) (bool, error)
+}
+
+// ProductCatalog интерфейс для получения цен и названий товаров из каталога склада
+type ProductCatalog interface {
+	// GetProduct возвращает товар по ID продукта или nil, если товар не найден
+	GetProduct(ctx context.Context, productID uint) (*entity.CatalogProduct, error)
+}
+
+// StockChecker интерфейс для проверки наличия товаров на складе
+type StockChecker interface {
+	CheckAvailability(ctx context.Context, items []entity.StockRequestItem) (*entity.StockAvailability, error)
 }
 
 // RabbitMQClient интерфейс для работы с RabbitMQ
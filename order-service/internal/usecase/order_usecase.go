@@ -10,14 +10,37 @@ import (
 
 	"github.com/director74/dz8_shop/order-service/internal/entity"
 	"github.com/director74/dz8_shop/order-service/internal/repo"
+	"github.com/director74/dz8_shop/pkg/money"
+	"github.com/director74/dz8_shop/pkg/outbox"
 	"github.com/director74/dz8_shop/pkg/sagahandler"
 )
 
+var (
+	// ErrProductNotFound товар из заказа отсутствует в каталоге склада
+	ErrProductNotFound = errors.New("товар не найден в каталоге")
+	// ErrProductUnavailable товар из заказа снят с продажи
+	ErrProductUnavailable = errors.New("товар недоступен для заказа")
+	// ErrAmountMismatch сумма заказа, переданная клиентом, не совпадает с рассчитанной по каталогу
+	ErrAmountMismatch = errors.New("сумма заказа не совпадает с ценами каталога")
+	// ErrOrderNotFound заказ не найден
+	ErrOrderNotFound = repo.ErrOrderNotFound
+	// ErrOrderAccessDenied заказ принадлежит другому пользователю
+	ErrOrderAccessDenied = errors.New("доступ к заказу запрещен")
+	// ErrCurrencyMismatch валюта, переданная клиентом, не совпадает с валютой каталога
+	ErrCurrencyMismatch = money.ErrCurrencyMismatch
+	// ErrInvalidCurrency код валюты, переданный клиентом, некорректен
+	ErrInvalidCurrency = money.ErrInvalidCurrency
+	// ErrInvalidAmount сумма позиции или заказа не помещается в допустимый диапазон сумм
+	ErrInvalidAmount = money.ErrInvalidAmount
+)
+
 // OrderUseCase представляет usecase для работы с заказами
 type OrderUseCase struct {
 	repo      repo.OrderRepository
 	userRepo  repo.UserRepository
 	billing   BillingService
+	catalog   ProductCatalog
+	currency  money.Currency
 	rabbitMQ  RabbitMQClient
 	orderExch string
 	sagaExch  string
@@ -28,11 +51,11 @@ type OrderUseCase struct {
 // OrderNotificationPayload структура для отправки уведомления о заказе
 // (локальная копия, чтобы избежать прямой зависимости от notification-service)
 type OrderNotificationPayload struct {
-	UserID  uint    `json:"user_id"`
-	Email   string  `json:"email"`
-	OrderID uint    `json:"order_id"`
-	Amount  float64 `json:"amount"`
-	Success bool    `json:"success"` // Добавляем поле Success
+	UserID  uint         `json:"user_id"`
+	Email   string       `json:"email"`
+	OrderID uint         `json:"order_id"`
+	Amount  money.Amount `json:"amount"`
+	Success bool         `json:"success"` // Добавляем поле Success
 }
 
 func NewOrderUseCase(
@@ -40,6 +63,7 @@ func NewOrderUseCase(
 	userRepo repo.UserRepository,
 	sagaStateRepo SagaStateRepository,
 	billing BillingService,
+	catalog ProductCatalog,
 	rabbitMQ RabbitMQClient,
 	orderExch string,
 	sagaExch string,
@@ -50,6 +74,7 @@ func NewOrderUseCase(
 		repo:      orderRepo,
 		userRepo:  userRepo,
 		billing:   billing,
+		catalog:   catalog,
 		rabbitMQ:  rabbitMQ,
 		orderExch: orderExch,
 		sagaExch:  sagaExch,
@@ -69,6 +94,35 @@ func NewOrderUseCase(
 	return uc
 }
 
+// UseOutbox переключает публикацию событий заказов и сообщений саги на transactional outbox.
+// Получение результатов саги по-прежнему выполняется через RabbitMQ-клиент, переданный в NewOrderUseCase.
+func (uc *OrderUseCase) UseOutbox(publisher *outbox.Publisher) {
+	uc.rabbitMQ = publisher
+	uc.sagaOrch.UseOutbox(publisher)
+}
+
+// UseCurrency задает валюту цен каталога, в которой оформляются заказы. По умолчанию - money.DefaultCurrency.
+func (uc *OrderUseCase) UseCurrency(currency money.Currency) {
+	uc.currency = currency
+}
+
+// StartSagaWatchdog настраивает таймауты шагов саги и запускает фоновую проверку зависших шагов.
+// Watchdog останавливается при отмене ctx.
+func (uc *OrderUseCase) StartSagaWatchdog(ctx context.Context, cfg StepTimeoutConfig, interval time.Duration) {
+	uc.sagaOrch.ConfigureStepTimeouts(cfg)
+	go uc.sagaOrch.RunStepWatchdog(ctx, interval)
+}
+
+// HandleReservationExpired завершает ошибкой сагу заказа, резервирование товаров которого истекло на складе
+func (uc *OrderUseCase) HandleReservationExpired(ctx context.Context, orderID uint) error {
+	return uc.sagaOrch.HandleReservationExpired(ctx, orderID)
+}
+
+// HandleBackorderScheduled переводит в статус backordered заказ, поставленный складом в очередь под поступление товара
+func (uc *OrderUseCase) HandleBackorderScheduled(ctx context.Context, orderID uint, expectedAt *time.Time) error {
+	return uc.sagaOrch.HandleBackorderScheduled(ctx, orderID, expectedAt)
+}
+
 func (uc *OrderUseCase) CreateUser(ctx context.Context, req entity.CreateUserRequest) (entity.CreateUserResponse, error) {
 	_, err := uc.userRepo.GetByEmail(ctx, req.Email)
 	if err == nil {
@@ -114,30 +168,37 @@ func (uc *OrderUseCase) CreateOrder(ctx context.Context, req entity.CreateOrderR
 		return entity.CreateOrderResponse{}, fmt.Errorf("пользователь не найден: %w", err)
 	}
 
-	// Если сумма заказа не указана, вычисляем её автоматически
-	if req.Amount == 0 {
-		var totalAmount float64
-		for _, item := range req.Items {
-			totalAmount += item.Price * float64(item.Quantity)
-		}
-		req.Amount = totalAmount
+	// Цены и названия товаров берутся из каталога склада, сумма заказа рассчитывается на сервере
+	totalAmount, err := uc.priceItems(ctx, req.Items)
+	if err != nil {
+		return entity.CreateOrderResponse{}, err
+	}
+	if req.Amount != 0 && req.Amount != totalAmount {
+		return entity.CreateOrderResponse{}, fmt.Errorf("%w: передано %s, по каталогу %s", ErrAmountMismatch, req.Amount, totalAmount)
+	}
+	req.Amount = totalAmount
+
+	currency, err := checkCurrency(req.Currency, uc.currency.OrDefault())
+	if err != nil {
+		return entity.CreateOrderResponse{}, err
 	}
 
 	// Подготавливаем данные для саги
-	sagaData := SagaData{
+	sagaData := sagahandler.SagaData{
 		UserID:    req.UserID,
-		Items:     req.Items,
+		Items:     entity.OrderItemsToSaga(req.Items),
 		Amount:    req.Amount,
-		Status:    entity.OrderStatusPending,
+		Currency:  currency,
+		Status:    string(entity.OrderStatusPending),
 		CreatedAt: time.Now(),
 	}
 
 	// Кратко логируем создание заказа
-	uc.logger.Printf("[Order] Создание заказа: UserID=%d, Amount=%.2f, Items=%d", req.UserID, req.Amount, len(req.Items))
+	uc.logger.Printf("[Order] Создание заказа: UserID=%d, Amount=%s %s, Items=%d", req.UserID, req.Amount, currency, len(req.Items))
 
 	// Если в запросе есть информация о доставке, добавляем ее
 	if req.Delivery != nil {
-		sagaData.DeliveryInfo = &DeliveryInfo{
+		sagaData.DeliveryInfo = &sagahandler.DeliveryInfo{
 			Address:      req.Delivery.Address,
 			TimeSlotID:   parseUintOrZero(req.Delivery.TimeSlotID),
 			ZoneID:       parseUintOrZero(req.Delivery.ZoneID),
@@ -147,46 +208,15 @@ func (uc *OrderUseCase) CreateOrder(ctx context.Context, req entity.CreateOrderR
 		}
 	}
 
-	// Конвертируем в формат для SagaOrchestrator
-	sagaPkgData := sagahandler.SagaData{
-		UserID:    sagaData.UserID,
-		Items:     make([]sagahandler.OrderItem, len(sagaData.Items)),
-		Amount:    sagaData.Amount,
-		Status:    string(sagaData.Status),
-		CreatedAt: sagaData.CreatedAt,
-	}
-	for i, item := range sagaData.Items {
-		sagaPkgData.Items[i] = sagahandler.OrderItem{
-			ID:        item.ID,
-			OrderID:   item.OrderID,
-			ProductID: item.ProductID,
-			Name:      item.Name,
-			Price:     item.Price,
-			Quantity:  item.Quantity,
-			CreatedAt: item.CreatedAt,
-			UpdatedAt: item.UpdatedAt,
-		}
-	}
-	if sagaData.DeliveryInfo != nil {
-		sagaPkgData.DeliveryInfo = &sagahandler.DeliveryInfo{
-			Address:      sagaData.DeliveryInfo.Address,
-			TimeSlotID:   sagaData.DeliveryInfo.TimeSlotID,
-			ZoneID:       sagaData.DeliveryInfo.ZoneID,
-			Cost:         sagaData.DeliveryInfo.Cost,
-			Status:       sagaData.DeliveryInfo.Status,
-			DeliveryDate: sagaData.DeliveryInfo.DeliveryDate,
-		}
-	}
-
 	// Запускаем сагу
-	err = uc.sagaOrch.StartOrderSaga(ctx, &sagaPkgData)
+	err = uc.sagaOrch.StartOrderSaga(ctx, &sagaData)
 	if err != nil {
 		uc.logger.Printf("[Order][ERROR] Ошибка запуска саги: %v", err)
 		return entity.CreateOrderResponse{}, fmt.Errorf("ошибка при запуске процесса обработки заказа: %w", err)
 	}
 
 	// Получаем ID заказа из саги после создания
-	orderID := sagaPkgData.OrderID
+	orderID := sagaData.OrderID
 	uc.logger.Printf("[Order] Создан заказ ID=%d", orderID)
 
 	// Обновляем ID для всех позиций заказа
@@ -212,11 +242,61 @@ func (uc *OrderUseCase) CreateOrder(ctx context.Context, req entity.CreateOrderR
 		UserID:    req.UserID,
 		Items:     req.Items,
 		Amount:    req.Amount,
+		Currency:  currency,
 		Status:    entity.OrderStatusPending,
 		CreatedAt: sagaData.CreatedAt,
 	}, nil
 }
 
+// checkCurrency сверяет валюту, переданную клиентом, с валютой каталога. Пустая валюта означает валюту каталога.
+func checkCurrency(requested, catalog money.Currency) (money.Currency, error) {
+	if requested == "" {
+		return catalog, nil
+	}
+	currency, err := money.ParseCurrency(string(requested))
+	if err != nil {
+		return "", err
+	}
+	if currency != catalog {
+		return "", fmt.Errorf("%w: передано %s, цены каталога в %s", ErrCurrencyMismatch, currency, catalog)
+	}
+	return currency, nil
+}
+
+// priceItems заполняет цену, название и SKU позиций заказа по каталогу склада и возвращает сумму заказа
+func (uc *OrderUseCase) priceItems(ctx context.Context, items []entity.OrderItem) (money.Amount, error) {
+	var total money.Amount
+	for i := range items {
+		if items[i].Quantity <= 0 {
+			return 0, fmt.Errorf("некорректное количество товара %d: %d", items[i].ProductID, items[i].Quantity)
+		}
+
+		product, err := uc.catalog.GetProduct(ctx, items[i].ProductID)
+		if err != nil {
+			return 0, fmt.Errorf("ошибка получения цены товара %d: %w", items[i].ProductID, err)
+		}
+		if product == nil {
+			return 0, fmt.Errorf("%w: %d", ErrProductNotFound, items[i].ProductID)
+		}
+		if product.Status == "unavailable" {
+			return 0, fmt.Errorf("%w: %d", ErrProductUnavailable, items[i].ProductID)
+		}
+
+		items[i].Price = product.Price
+		items[i].Name = product.Name
+		items[i].SKU = product.SKU
+		subtotal, err := product.Price.Mul(items[i].Quantity)
+		if err != nil {
+			return 0, fmt.Errorf("сумма позиции товара %d: %w", items[i].ProductID, err)
+		}
+		total += subtotal
+	}
+	if total > money.MaxAmount {
+		return 0, fmt.Errorf("%w: сумма заказа %s", ErrInvalidAmount, total)
+	}
+	return total, nil
+}
+
 func (uc *OrderUseCase) GetOrder(ctx context.Context, id uint) (entity.GetOrderResponse, error) {
 	order, err := uc.repo.GetByID(ctx, id)
 	if err != nil {
@@ -224,12 +304,41 @@ func (uc *OrderUseCase) GetOrder(ctx context.Context, id uint) (entity.GetOrderR
 	}
 
 	return entity.GetOrderResponse{
-		ID:        order.ID,
-		UserID:    order.UserID,
-		Amount:    order.Amount,
-		Status:    order.Status,
-		CreatedAt: order.CreatedAt,
-		UpdatedAt: order.UpdatedAt,
+		ID:                  order.ID,
+		UserID:              order.UserID,
+		Amount:              order.Amount,
+		Currency:            order.Currency.OrDefault(),
+		Status:              order.Status,
+		CreatedAt:           order.CreatedAt,
+		UpdatedAt:           order.UpdatedAt,
+		ExpectedAvailableAt: order.ExpectedAvailableAt,
+	}, nil
+}
+
+// CancelOrder отменяет заказ пользователя userID через сагу отмены.
+// Отмена выполняется асинхронно: заказ переходит в статус cancelling, а после компенсации всех шагов - в cancelled.
+func (uc *OrderUseCase) CancelOrder(ctx context.Context, orderID, userID uint, reason string) (entity.CancelOrderResponse, error) {
+	order, err := uc.repo.GetByID(ctx, orderID)
+	if err != nil {
+		return entity.CancelOrderResponse{}, fmt.Errorf("ошибка получения заказа: %w", err)
+	}
+	if order.UserID != userID {
+		return entity.CancelOrderResponse{}, ErrOrderAccessDenied
+	}
+
+	if err := uc.sagaOrch.CancelOrder(ctx, order, reason); err != nil {
+		return entity.CancelOrderResponse{}, err
+	}
+
+	updated, err := uc.repo.GetByID(ctx, orderID)
+	if err != nil {
+		return entity.CancelOrderResponse{}, fmt.Errorf("ошибка получения заказа: %w", err)
+	}
+	uc.logger.Printf("[Order] Запрошена отмена заказа ID=%d, статус %s", orderID, updated.Status)
+
+	return entity.CancelOrderResponse{
+		ID:     updated.ID,
+		Status: updated.Status,
 	}, nil
 }
 
@@ -253,12 +362,14 @@ func (uc *OrderUseCase) ListUserOrders(ctx context.Context, userID uint, limit,
 
 	for i, order := range orders {
 		response.Orders[i] = entity.GetOrderResponse{
-			ID:        order.ID,
-			UserID:    order.UserID,
-			Amount:    order.Amount,
-			Status:    order.Status,
-			CreatedAt: order.CreatedAt,
-			UpdatedAt: order.UpdatedAt,
+			ID:                  order.ID,
+			UserID:              order.UserID,
+			Amount:              order.Amount,
+			Currency:            order.Currency.OrDefault(),
+			Status:              order.Status,
+			CreatedAt:           order.CreatedAt,
+			UpdatedAt:           order.UpdatedAt,
+			ExpectedAvailableAt: order.ExpectedAvailableAt,
 		}
 	}
 
@@ -0,0 +1,85 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// Мок для ProductCatalog
+type MockProductCatalog struct {
+	mock.Mock
+}
+
+func (m *MockProductCatalog) GetProduct(ctx context.Context, productID uint) (*entity.CatalogProduct, error) {
+	args := m.Called(ctx, productID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*entity.CatalogProduct), args.Error(1)
+}
+
+// TestPriceItems_UsesCatalogPrices тестирует расчет суммы заказа по ценам каталога вместо цен клиента
+func TestPriceItems_UsesCatalogPrices(t *testing.T) {
+	catalog := new(MockProductCatalog)
+	catalog.On("GetProduct", mock.Anything, uint(1)).Return(&entity.CatalogProduct{ProductID: 1, SKU: "PHONE-001", Name: "Смартфон", Price: money.FromMinor(4999999), Status: "available"}, nil)
+	catalog.On("GetProduct", mock.Anything, uint(2)).Return(&entity.CatalogProduct{ProductID: 2, SKU: "POWER-001", Name: "Аккумулятор", Price: money.FromMinor(10), Status: "available"}, nil)
+	uc := &OrderUseCase{catalog: catalog}
+
+	items := []entity.OrderItem{
+		{ProductID: 1, Quantity: 1, Price: money.FromMinor(1)},
+		{ProductID: 2, Quantity: 3},
+	}
+	total, err := uc.priceItems(context.Background(), items)
+
+	assert.NoError(t, err)
+	assert.Equal(t, money.FromMinor(5000029), total)
+	assert.Equal(t, money.FromMinor(4999999), items[0].Price)
+	assert.Equal(t, "Смартфон", items[0].Name)
+	assert.Equal(t, "PHONE-001", items[0].SKU)
+	assert.Equal(t, money.FromMinor(10), items[1].Price)
+}
+
+// TestPriceItems_Errors тестирует отклонение позиций, которые нельзя оценить по каталогу
+func TestPriceItems_Errors(t *testing.T) {
+	catalog := new(MockProductCatalog)
+	catalog.On("GetProduct", mock.Anything, uint(1)).Return(nil, nil)
+	catalog.On("GetProduct", mock.Anything, uint(2)).Return(&entity.CatalogProduct{ProductID: 2, Price: money.FromMinor(1000), Status: "unavailable"}, nil)
+	catalog.On("GetProduct", mock.Anything, uint(3)).Return(nil, errors.New("timeout"))
+	uc := &OrderUseCase{catalog: catalog}
+
+	_, err := uc.priceItems(context.Background(), []entity.OrderItem{{ProductID: 1, Quantity: 1}})
+	assert.ErrorIs(t, err, ErrProductNotFound)
+
+	_, err = uc.priceItems(context.Background(), []entity.OrderItem{{ProductID: 2, Quantity: 1}})
+	assert.ErrorIs(t, err, ErrProductUnavailable)
+
+	_, err = uc.priceItems(context.Background(), []entity.OrderItem{{ProductID: 3, Quantity: 1}})
+	assert.Error(t, err)
+
+	_, err = uc.priceItems(context.Background(), []entity.OrderItem{{ProductID: 1, Quantity: 0}})
+	assert.Error(t, err)
+}
+
+// TestCheckCurrency тестирует сверку валюты заказа с валютой каталога
+func TestCheckCurrency(t *testing.T) {
+	currency, err := checkCurrency("", "RUB")
+	assert.NoError(t, err)
+	assert.Equal(t, money.Currency("RUB"), currency)
+
+	currency, err = checkCurrency("rub", "RUB")
+	assert.NoError(t, err)
+	assert.Equal(t, money.Currency("RUB"), currency)
+
+	_, err = checkCurrency("USD", "RUB")
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+
+	_, err = checkCurrency("R1B", "RUB")
+	assert.ErrorIs(t, err, ErrInvalidCurrency)
+}
@@ -0,0 +1,120 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"gorm.io/gorm"
+)
+
+const (
+	// backorderStep шаг саги, результат которого склад откладывает до поступления товара
+	backorderStep = "reserve_warehouse"
+	// backorderHoldMargin запас сверх срока ожидания резерва склада, за который сага успевает
+	// зарезервировать доставку и списать заблокированные средства
+	backorderHoldMargin = 24 * time.Hour
+)
+
+// OrderBackorderedPayload событие order.backordered: заказ ждет поступления товара, и средства,
+// заблокированные на шагах process_billing и process_payment, должны удерживаться до HoldUntil
+type OrderBackorderedPayload struct {
+	Type                string     `json:"type"` // "order.backordered"
+	OrderID             uint       `json:"order_id"`
+	ExpectedAvailableAt *time.Time `json:"expected_available_at,omitempty"`
+	HoldUntil           time.Time  `json:"hold_until"`
+}
+
+// HandleBackorderScheduled обрабатывает постановку заказа в очередь под поступление товара на складе
+// или перенос ожидаемой даты поступления. Склад ответит на шаг reserve_warehouse только после поступления
+// товара, поэтому срок ожидания шага переносится на ожидаемую дату поступления плюс backorderTimeout
+// (от текущего момента, если дата неизвестна или уже прошла), а заказ переходит в статус backordered.
+// Авторизация платежа выдается на короткий срок, поэтому в той же транзакции публикуется событие
+// order.backordered: платежный сервис продлевает блокировку суммы на время ожидания товара.
+// Если шаг уже завершен или сага не выполняется, событие игнорируется.
+func (s *SagaOrchestrator) HandleBackorderScheduled(ctx context.Context, orderID uint, expectedAt *time.Time) error {
+	state, err := s.sagaStateRepo.GetByOrderID(ctx, orderID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.logger.Printf("[WARN] OrderID=%d: Заказ поставлен в очередь под поступление, но сага заказа не найдена. Игнорируется.", orderID)
+			return nil
+		}
+		return err
+	}
+	if state.Status != entity.SagaStatusRunning {
+		s.logger.Printf("SagaID=%s: Заказ %d поставлен в очередь под поступление, сага в статусе %s. Игнорируется.", state.SagaID, orderID, state.Status)
+		return nil
+	}
+
+	now := time.Now()
+	deadline := now.Add(s.backorderTimeout)
+	if expectedAt != nil && expectedAt.After(now) {
+		deadline = expectedAt.Add(s.backorderTimeout)
+	}
+
+	return s.inTransaction(ctx, func(ctx context.Context) error {
+		extended, err := s.sagaStateRepo.ExtendStepDeadline(ctx, state.SagaID, backorderStep, deadline)
+		if err != nil {
+			return err
+		}
+		if !extended {
+			s.logger.Printf("SagaID=%s: Шаг %s уже не ожидает результата, статус заказа %d не меняется.", state.SagaID, backorderStep, orderID)
+			return nil
+		}
+
+		if err := s.orderRepo.UpdateBackorderStatus(ctx, orderID, expectedAt); err != nil {
+			return fmt.Errorf("ошибка обновления статуса заказа %d: %w", orderID, err)
+		}
+
+		payload := OrderBackorderedPayload{
+			Type:                "order.backordered",
+			OrderID:             orderID,
+			ExpectedAvailableAt: expectedAt,
+			HoldUntil:           deadline.Add(backorderHoldMargin),
+		}
+		if err := s.publisher(ctx).PublishMessage(s.orderExchange, payload.Type, payload); err != nil {
+			return fmt.Errorf("ошибка публикации события order.backordered для заказа %d: %w", orderID, err)
+		}
+
+		s.logger.Printf("SagaID=%s: Заказ %d ожидает поступления товара (ожидаемая дата: %s), результат шага %s ожидается до %s.",
+			state.SagaID, orderID, formatExpectedDate(expectedAt), backorderStep, deadline.Format(time.RFC3339))
+		return nil
+	})
+}
+
+// awaitsBackorder сообщает, ждет ли заказ поступления товара: заказ в статусе backordered остается в нем,
+// пока склад не зарезервирует товар, даже если параллельные шаги саги уже завершились
+func awaitsBackorder(order *entity.Order, steps []entity.SagaStepState) bool {
+	if order.Status != entity.OrderStatusBackordered {
+		return false
+	}
+	for _, step := range steps {
+		if step.StepName == backorderStep {
+			return step.Status == entity.SagaStepStatusRunning
+		}
+	}
+	return false
+}
+
+// stopBackorderStep прекращает ожидание резерва склада для отменяемого заказа под поступление: шаг
+// reserve_warehouse помечается как не ответивший, чтобы компенсация не ждала поступления товара, а склад
+// убрал заказ из очереди. Возвращает false, если шаг уже не ожидает результата.
+func (s *SagaOrchestrator) stopBackorderStep(ctx context.Context, sagaID string, steps []entity.SagaStepState, reason string) (bool, error) {
+	for _, step := range steps {
+		if step.StepName != backorderStep || step.Status != entity.SagaStepStatusRunning || step.Deadline == nil {
+			continue
+		}
+		return s.sagaStateRepo.MarkStepTimedOut(ctx, sagaID, backorderStep, *step.Deadline, reason)
+	}
+	return false, nil
+}
+
+// formatExpectedDate описывает ожидаемую дату поступления для лога
+func formatExpectedDate(expectedAt *time.Time) string {
+	if expectedAt == nil {
+		return "неизвестна"
+	}
+	return expectedAt.Format(time.DateOnly)
+}
@@ -0,0 +1,142 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/sagahandler"
+	"gorm.io/gorm"
+)
+
+// ErrOrderNotCancellable заказ нельзя отменить в текущем состоянии
+var ErrOrderNotCancellable = errors.New("заказ нельзя отменить")
+
+// CancelOrder запускает сагу отмены заказа по запросу клиента.
+// Выполняющаяся сага заказа переводится в компенсацию: завершенные шаги (списание, платеж, резервы склада и доставки)
+// компенсируются сразу, а шаги, ожидающие результата, - по мере его получения. Списанные средства возвращаются
+// на счет биллинга компенсацией capture_billing, заблокированные - компенсацией process_billing; списанный платеж
+// возвращается компенсацией capture_payment, авторизация снимается компенсацией process_payment. После получения всех компенсаций заказ переходит в статус cancelled
+// и публикуется событие order.cancelled. Заказ, ожидающий поступления товара, не ждет ответа склада:
+// резерв склада компенсируется сразу, и склад убирает заказ из очереди. Статус саги и ее шаги перепроверяются
+// под блокировкой записи саги, так как параллельно может обрабатываться результат шага.
+func (s *SagaOrchestrator) CancelOrder(ctx context.Context, order *entity.Order, reason string) error {
+	if !order.Status.Cancellable() {
+		return fmt.Errorf("%w: заказ %d в статусе %s", ErrOrderNotCancellable, order.ID, order.Status)
+	}
+
+	state, err := s.sagaStateRepo.GetByOrderID(ctx, order.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Саги нет: компенсировать нечего, отменяем заказ локально
+			return s.cancelWithoutSaga(ctx, order, reason)
+		}
+		return err
+	}
+	if state.Status != entity.SagaStatusRunning {
+		return fmt.Errorf("%w: сага заказа %d в статусе %s", ErrOrderNotCancellable, order.ID, state.Status)
+	}
+
+	errorMessage := "Заказ отменен клиентом"
+	if reason != "" {
+		errorMessage = fmt.Sprintf("%s: %s", errorMessage, reason)
+	}
+
+	return s.inTransaction(ctx, func(ctx context.Context) error {
+		sagaID := state.SagaID
+		state, err := s.sagaStateRepo.LockByID(ctx, sagaID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: сага заказа %d уже завершена", ErrOrderNotCancellable, order.ID)
+			}
+			return err
+		}
+		if state.Status != entity.SagaStatusRunning {
+			return fmt.Errorf("%w: сага заказа %d в статусе %s", ErrOrderNotCancellable, order.ID, state.Status)
+		}
+
+		def, err := s.definitionFor(state)
+		if err != nil {
+			return err
+		}
+		steps, err := s.sagaStateRepo.GetSteps(ctx, sagaID)
+		if err != nil {
+			return err
+		}
+		_, started, _ := stepProgress(def, steps)
+		if blocker := def.CancellationBlockedBy(started); blocker != "" {
+			return fmt.Errorf("%w: заказ %d уже передан в доставку (шаг %s)", ErrOrderNotCancellable, order.ID, blocker)
+		}
+
+		sagaData := s.cancellationData(sagaID, steps)
+		if sagaData.OrderID == 0 {
+			sagaData.OrderID = order.ID
+			sagaData.UserID = order.UserID
+			sagaData.Amount = order.Amount
+			sagaData.Currency = order.Currency
+		}
+		s.logger.Printf("SagaID=%s: %s. Запуск саги отмены заказа %d.", sagaID, errorMessage, order.ID)
+
+		if err := s.orderRepo.UpdateOrderStatus(ctx, order.ID, entity.OrderStatusCancelling); err != nil {
+			return fmt.Errorf("ошибка обновления статуса заказа %d: %w", order.ID, err)
+		}
+
+		state.Status = entity.SagaStatusCompensating
+		state.ErrorMessage = errorMessage
+		if err := s.sagaStateRepo.Update(ctx, state); err != nil {
+			return fmt.Errorf("не удалось обновить состояние саги %s: %w", sagaID, err)
+		}
+
+		// Заказ под поступление не ждет товара: склад убирает его из очереди компенсацией reserve_warehouse
+		if order.Status == entity.OrderStatusBackordered {
+			stopped, err := s.stopBackorderStep(ctx, sagaID, steps, errorMessage)
+			if err != nil {
+				return fmt.Errorf("ошибка остановки ожидания резерва склада заказа %d: %w", order.ID, err)
+			}
+			if stopped {
+				return s.startCompensationProcess(ctx, sagaID, backorderStep, sagaData, convertJSONMapToBoolMap(state.CompensatedSteps), true)
+			}
+		}
+
+		return s.startCompensationProcess(ctx, sagaID, "", sagaData, convertJSONMapToBoolMap(state.CompensatedSteps), false)
+	})
+}
+
+// cancelWithoutSaga отменяет заказ, для которого не выполняется сага
+func (s *SagaOrchestrator) cancelWithoutSaga(ctx context.Context, order *entity.Order, reason string) error {
+	if reason == "" {
+		reason = "Заказ отменен клиентом"
+	}
+	return s.inTransaction(ctx, func(ctx context.Context) error {
+		if err := s.orderRepo.UpdateOrderStatus(ctx, order.ID, entity.OrderStatusCancelled); err != nil {
+			return fmt.Errorf("ошибка обновления статуса заказа %d: %w", order.ID, err)
+		}
+		return s.publishCancellationEvent(ctx, order.ID, order.UserID, "order.cancelled", reason)
+	})
+}
+
+// cancellationData собирает данные саги для компенсации: исходные данные заказа из первого шага
+// и результаты завершенных шагов (идентификаторы транзакции биллинга, платежа, резервов)
+func (s *SagaOrchestrator) cancellationData(sagaID string, steps []entity.SagaStepState) sagahandler.SagaData {
+	var sagaData sagahandler.SagaData
+	if len(steps) > 0 && len(steps[0].Payload) > 0 {
+		if err := json.Unmarshal(steps[0].Payload, &sagaData); err != nil {
+			s.logger.Printf("[WARN] SagaID=%s: Не удалось десериализовать данные шага %s: %v", sagaID, steps[0].StepName, err)
+		}
+	}
+
+	for _, step := range steps {
+		if step.Status != entity.SagaStepStatusCompleted || len(step.Result) == 0 {
+			continue
+		}
+		var stepData sagahandler.SagaData
+		if err := json.Unmarshal(step.Result, &stepData); err != nil {
+			s.logger.Printf("[WARN] SagaID=%s: Не удалось десериализовать результат шага %s: %v", sagaID, step.StepName, err)
+			continue
+		}
+		mergeSagaData(&sagaData, stepData)
+	}
+	return sagaData
+}
@@ -0,0 +1,234 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OrderSagaName имя стандартной саги оформления заказа
+const OrderSagaName = "order"
+
+// Step описывает шаг саги.
+// Сообщения шага публикуются с ключами saga.<Name>.execute и saga.<Name>.compensate,
+// результат ожидается с ключом saga.<Name>.result.
+type Step struct {
+	Name              string
+	Local             bool          // Шаг выполняется самим оркестратором и не публикуется (например, create_order)
+	CompensateOnError bool          // Шаг компенсируется при сбое последующих шагов
+	Dependencies      []string      // Шаги, которые должны завершиться до запуска этого шага (nil - предыдущий шаг в списке)
+	Timeout           time.Duration // Время ожидания результата шага (0 - таймаут по умолчанию)
+	Retry             *RetryPolicy  // Политика повторов при таймауте (nil - политика оркестратора)
+	NonCancellable    bool          // После запуска шага сагу нельзя отменить по запросу клиента
+}
+
+// RetryPolicy политика повторной отправки шага, не ответившего в срок
+type RetryPolicy struct {
+	MaxRetries int // Количество повторных отправок перед запуском компенсации
+}
+
+// SagaDefinition декларативное описание саги: упорядоченный набор шагов и их зависимостей.
+// Шаги образуют ациклический граф: шаг запускается, как только завершены все его зависимости,
+// поэтому независимые шаги выполняются параллельно.
+type SagaDefinition struct {
+	Name  string
+	Steps []Step
+}
+
+// OrderSagaDefinition возвращает определение стандартной саги оформления заказа
+func OrderSagaDefinition() SagaDefinition {
+	return SagaDefinition{
+		Name: OrderSagaName,
+		Steps: []Step{
+			{Name: "create_order", Local: true},
+			{Name: "process_billing", CompensateOnError: true},
+			{Name: "process_payment", CompensateOnError: true},
+			// Резервирование склада и доставки не зависят друг от друга и выполняются параллельно
+			{Name: "reserve_warehouse", CompensateOnError: true, Dependencies: []string{"process_payment"}},
+			{Name: "reserve_delivery", CompensateOnError: true, Dependencies: []string{"process_payment"}},
+			// Средства, заблокированные на process_billing и process_payment, списываются параллельно
+			// и только после резервирования товаров и доставки
+			{Name: "capture_billing", CompensateOnError: true, Dependencies: []string{"reserve_warehouse", "reserve_delivery"}},
+			{Name: "capture_payment", CompensateOnError: true, Dependencies: []string{"reserve_warehouse", "reserve_delivery"}},
+			// Подтверждение запускает доставку, после этого заказ отменить нельзя
+			{Name: "confirm_order", Timeout: defaultConfirmStepTimeout, NonCancellable: true, Dependencies: []string{"capture_billing", "capture_payment"}},
+			{Name: "notify_customer"},
+		},
+	}
+}
+
+// Validate проверяет определение саги и заполняет неявные зависимости.
+// Зависимость может ссылаться только на шаг, объявленный раньше, поэтому порядок шагов
+// в определении всегда является допустимым порядком выполнения.
+func (d *SagaDefinition) Validate() error {
+	if d.Name == "" {
+		return errors.New("не задано имя саги")
+	}
+	if len(d.Steps) == 0 {
+		return fmt.Errorf("сага %s не содержит шагов", d.Name)
+	}
+
+	declared := make(map[string]bool, len(d.Steps))
+	hasRemote := false
+	for i := range d.Steps {
+		step := &d.Steps[i]
+		if step.Name == "" {
+			return fmt.Errorf("сага %s: шаг #%d без имени", d.Name, i)
+		}
+		if declared[step.Name] {
+			return fmt.Errorf("сага %s: шаг %s объявлен повторно", d.Name, step.Name)
+		}
+		if step.Dependencies == nil && i > 0 {
+			step.Dependencies = []string{d.Steps[i-1].Name}
+		}
+		for _, dep := range step.Dependencies {
+			if !declared[dep] {
+				return fmt.Errorf("сага %s: шаг %s зависит от %s, который не объявлен до него", d.Name, step.Name, dep)
+			}
+		}
+		if step.Local && hasRemote {
+			return fmt.Errorf("сага %s: локальный шаг %s должен предшествовать публикуемым шагам", d.Name, step.Name)
+		}
+		if step.Retry != nil && step.Retry.MaxRetries < 0 {
+			return fmt.Errorf("сага %s: отрицательное количество повторов шага %s", d.Name, step.Name)
+		}
+		if !step.Local {
+			hasRemote = true
+		}
+		declared[step.Name] = true
+	}
+	if !hasRemote {
+		return fmt.Errorf("сага %s не содержит публикуемых шагов", d.Name)
+	}
+	return nil
+}
+
+// Step возвращает шаг по имени
+func (d *SagaDefinition) Step(name string) *Step {
+	if idx := d.indexOf(name); idx >= 0 {
+		return &d.Steps[idx]
+	}
+	return nil
+}
+
+// ReadySteps возвращает публикуемые шаги, которые еще не запускались и все зависимости которых завершены.
+// Локальные шаги считаются завершенными.
+func (d *SagaDefinition) ReadySteps(completed, started map[string]bool) []Step {
+	ready := make([]Step, 0)
+	for _, step := range d.Steps {
+		if step.Local || started[step.Name] || completed[step.Name] {
+			continue
+		}
+		if d.dependenciesCompleted(step, completed) {
+			ready = append(ready, step)
+		}
+	}
+	return ready
+}
+
+// IsCompleted проверяет, завершены ли все публикуемые шаги саги
+func (d *SagaDefinition) IsCompleted(completed map[string]bool) bool {
+	for i := range d.Steps {
+		if !d.Steps[i].Local && !completed[d.Steps[i].Name] {
+			return false
+		}
+	}
+	return true
+}
+
+// Ancestors возвращает все шаги, от которых прямо или транзитивно зависит шаг name
+func (d *SagaDefinition) Ancestors(name string) map[string]bool {
+	ancestors := make(map[string]bool)
+	queue := []string{name}
+	for len(queue) > 0 {
+		step := d.Step(queue[0])
+		queue = queue[1:]
+		if step == nil {
+			continue
+		}
+		for _, dep := range step.Dependencies {
+			if !ancestors[dep] {
+				ancestors[dep] = true
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return ancestors
+}
+
+// CancellationBlockedBy возвращает запущенный шаг, после которого сагу нельзя отменить, или пустую строку
+func (d *SagaDefinition) CancellationBlockedBy(started map[string]bool) string {
+	for i := range d.Steps {
+		if d.Steps[i].NonCancellable && started[d.Steps[i].Name] {
+			return d.Steps[i].Name
+		}
+	}
+	return ""
+}
+
+// StepsToCompensate возвращает завершенные компенсируемые шаги в порядке, обратном объявлению.
+// Шаги, которые не запускались или завершились ошибкой, не компенсируются.
+func (d *SagaDefinition) StepsToCompensate(completed map[string]bool) []Step {
+	steps := make([]Step, 0)
+	for i := len(d.Steps) - 1; i >= 0; i-- {
+		if d.Steps[i].CompensateOnError && completed[d.Steps[i].Name] {
+			steps = append(steps, d.Steps[i])
+		}
+	}
+	return steps
+}
+
+func (d *SagaDefinition) dependenciesCompleted(step Step, completed map[string]bool) bool {
+	for _, dep := range step.Dependencies {
+		if depStep := d.Step(dep); depStep != nil && depStep.Local {
+			continue
+		}
+		if !completed[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *SagaDefinition) indexOf(name string) int {
+	for i := range d.Steps {
+		if d.Steps[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// SagaRegistry реестр определений саг, доступных оркестратору
+type SagaRegistry struct {
+	mu          sync.RWMutex
+	definitions map[string]*SagaDefinition
+}
+
+// NewSagaRegistry создает пустой реестр саг
+func NewSagaRegistry() *SagaRegistry {
+	return &SagaRegistry{definitions: make(map[string]*SagaDefinition)}
+}
+
+// Register проверяет и добавляет определение саги. Повторная регистрация заменяет определение.
+func (r *SagaRegistry) Register(def SagaDefinition) error {
+	// Копируем шаги, чтобы изменения исходного определения не влияли на реестр
+	def.Steps = append([]Step(nil), def.Steps...)
+	if err := def.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.definitions[def.Name] = &def
+	return nil
+}
+
+// Get возвращает определение саги по имени
+func (r *SagaRegistry) Get(name string) (*SagaDefinition, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	def, ok := r.definitions[name]
+	return def, ok
+}
@@ -0,0 +1,160 @@
+package usecase
+
+import (
+	"context"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/sagahandler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// TestSagaDefinition_Validate тестирует проверку определения саги
+func TestSagaDefinition_Validate(t *testing.T) {
+	def := OrderSagaDefinition()
+	assert.NoError(t, def.Validate())
+	// Неявная зависимость от предыдущего шага
+	assert.Equal(t, []string{"process_billing"}, def.Step("process_payment").Dependencies)
+	assert.Equal(t, []string{"reserve_warehouse", "reserve_delivery"}, def.Step("capture_billing").Dependencies)
+	assert.Equal(t, []string{"reserve_warehouse", "reserve_delivery"}, def.Step("capture_payment").Dependencies)
+	assert.Equal(t, []string{"capture_billing", "capture_payment"}, def.Step("confirm_order").Dependencies)
+	assert.Equal(t, []string{"process_billing"}, stepNames(def.ReadySteps(nil, nil)))
+
+	unknownDep := SagaDefinition{Name: "broken", Steps: []Step{
+		{Name: "process_billing"},
+		{Name: "reserve_warehouse", Dependencies: []string{"process_payment"}},
+	}}
+	assert.Error(t, unknownDep.Validate())
+
+	duplicate := SagaDefinition{Name: "broken", Steps: []Step{{Name: "process_billing"}, {Name: "process_billing"}}}
+	assert.Error(t, duplicate.Validate())
+
+	onlyLocal := SagaDefinition{Name: "broken", Steps: []Step{{Name: "create_order", Local: true}}}
+	assert.Error(t, onlyLocal.Validate())
+}
+
+// TestSagaDefinition_ReadySteps тестирует параллельный запуск независимых шагов и ожидание их завершения
+func TestSagaDefinition_ReadySteps(t *testing.T) {
+	def := OrderSagaDefinition()
+	assert.NoError(t, def.Validate())
+
+	// После платежа склад и доставка запускаются одновременно
+	completed := map[string]bool{"process_billing": true, "process_payment": true}
+	started := map[string]bool{"process_billing": true, "process_payment": true}
+	assert.Equal(t, []string{"reserve_warehouse", "reserve_delivery"}, stepNames(def.ReadySteps(completed, started)))
+
+	// Пока доставка не завершена, подтверждение не запускается
+	completed["reserve_warehouse"] = true
+	started["reserve_warehouse"] = true
+	started["reserve_delivery"] = true
+	assert.Empty(t, def.ReadySteps(completed, started))
+
+	// Средства списываются только после обоих резервов, подтверждение ждет списания
+	completed["reserve_delivery"] = true
+	assert.Equal(t, []string{"capture_billing", "capture_payment"}, stepNames(def.ReadySteps(completed, started)))
+	started["capture_billing"] = true
+	started["capture_payment"] = true
+	assert.Empty(t, def.ReadySteps(completed, started))
+
+	// Подтверждение ждет обоих списаний
+	completed["capture_billing"] = true
+	assert.Empty(t, def.ReadySteps(completed, started))
+	completed["capture_payment"] = true
+	assert.Equal(t, []string{"confirm_order"}, stepNames(def.ReadySteps(completed, started)))
+	assert.False(t, def.IsCompleted(completed))
+
+	completed["confirm_order"] = true
+	completed["notify_customer"] = true
+	assert.True(t, def.IsCompleted(completed))
+
+	assert.Equal(t, map[string]bool{"create_order": true, "process_billing": true, "process_payment": true, "reserve_warehouse": true, "reserve_delivery": true, "capture_billing": true, "capture_payment": true},
+		def.Ancestors("confirm_order"))
+}
+
+// TestSagaDefinition_StepsToCompensate тестирует выбор шагов для компенсации
+func TestSagaDefinition_StepsToCompensate(t *testing.T) {
+	def := OrderSagaDefinition()
+	assert.NoError(t, def.Validate())
+
+	// Склад завершился ошибкой, доставка еще не ответила: компенсируются только завершенные шаги
+	steps := def.StepsToCompensate(map[string]bool{"process_billing": true, "process_payment": true})
+	assert.Equal(t, []string{"process_payment", "process_billing"}, stepNames(steps))
+
+	// Доставка успела завершиться до сбоя склада
+	steps = def.StepsToCompensate(map[string]bool{"process_billing": true, "process_payment": true, "reserve_delivery": true})
+	assert.Equal(t, []string{"reserve_delivery", "process_payment", "process_billing"}, stepNames(steps))
+
+	// Сбой подтверждения после списания: сначала возвращаются списанные средства, затем снимаются резервы и блокировка
+	steps = def.StepsToCompensate(map[string]bool{"process_billing": true, "process_payment": true, "reserve_warehouse": true, "reserve_delivery": true, "capture_billing": true, "capture_payment": true})
+	assert.Equal(t, []string{"capture_payment", "capture_billing", "reserve_delivery", "reserve_warehouse", "process_payment", "process_billing"}, stepNames(steps))
+
+	// Списание платежа не прошло (например, авторизация истекла): списание биллинга возвращается
+	steps = def.StepsToCompensate(map[string]bool{"process_billing": true, "process_payment": true, "reserve_warehouse": true, "reserve_delivery": true, "capture_billing": true})
+	assert.Equal(t, []string{"capture_billing", "reserve_delivery", "reserve_warehouse", "process_payment", "process_billing"}, stepNames(steps))
+}
+
+// TestStartSaga_CustomDefinition тестирует запуск саги по зарегистрированному определению
+func TestStartSaga_CustomDefinition(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	// Цифровые товары: без резервирования склада и доставки
+	err := orchestrator.RegisterSaga(SagaDefinition{
+		Name: "digital_goods",
+		Steps: []Step{
+			{Name: "create_order", Local: true},
+			{Name: "process_billing", CompensateOnError: true},
+			{Name: "process_payment", CompensateOnError: true},
+			{Name: "notify_customer"},
+		},
+	})
+	assert.NoError(t, err)
+
+	mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*entity.Order")).Return(nil)
+	mockStateRepo.On("Create", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
+		return state.SagaType == "digital_goods"
+	})).Return(nil)
+	mockStateRepo.On("Update", mock.Anything, mock.AnythingOfType("*entity.SagaState")).Return(nil)
+	mockStateRepo.On("CreateStep", mock.Anything, mock.AnythingOfType("*entity.SagaStepState")).Return(true, nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_billing.execute", mock.Anything).Return(nil)
+
+	err = orchestrator.StartSaga(context.Background(), "digital_goods", createTestSagaData())
+	assert.NoError(t, err)
+
+	// После платежа сага переходит сразу к уведомлению
+	state := &entity.SagaState{SagaID: "saga-digital", SagaType: "digital_goods", OrderID: 10, Status: entity.SagaStatusRunning}
+	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
+	mockStateRepo.On("LockByID", mock.Anything, "saga-digital").Return(state, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, "saga-digital").Return([]entity.SagaStepState{
+		{SagaID: "saga-digital", StepName: "process_billing", Status: entity.SagaStepStatusCompleted},
+		{SagaID: "saga-digital", StepName: "process_payment", Status: entity.SagaStepStatusRunning},
+	}, nil)
+	mockStateRepo.On("CompleteStep", mock.Anything, "saga-digital", "process_payment", entity.SagaStepStatusCompleted, mock.Anything).Return(true, nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.notify_customer.execute", mock.Anything).Return(nil)
+
+	message, err := createSagaMessage("saga-digital", "process_payment", sagahandler.OperationExecute, sagahandler.StatusCompleted, createTestSagaData())
+	assert.NoError(t, err)
+	assert.NoError(t, orchestrator.HandleSagaResult(message))
+
+	mockRabbitMQ.AssertExpectations(t)
+	mockStateRepo.AssertCalled(t, "CreateStep", mock.Anything, mock.MatchedBy(func(step *entity.SagaStepState) bool {
+		return step.StepName == "notify_customer"
+	}))
+
+	// Неизвестная сага не запускается
+	assert.Error(t, orchestrator.StartSaga(context.Background(), "unknown", createTestSagaData()))
+}
+
+func stepNames(steps []Step) []string {
+	names := make([]string, len(steps))
+	for i, step := range steps {
+		names[i] = step.Name
+	}
+	return names
+}
@@ -10,31 +10,31 @@ import (
 
 	"github.com/director74/dz8_shop/order-service/internal/entity"
 	"github.com/director74/dz8_shop/order-service/internal/repo"
+	"github.com/director74/dz8_shop/pkg/outbox"
 	"github.com/director74/dz8_shop/pkg/sagahandler"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
-// Step описывает шаг саги
-type Step struct {
-	Name              string
-	CompensateOnError bool
-}
+const (
+	// defaultStepTimeout время ожидания результата шага по умолчанию
+	defaultStepTimeout = 2 * time.Minute
+	// defaultConfirmStepTimeout время ожидания confirm_order, результат которого приходит после завершения доставки
+	defaultConfirmStepTimeout = 30 * time.Minute
+	// defaultBackorderTimeout ожидание резерва склада сверх ожидаемой даты поступления товаров (или с момента
+	// постановки в очередь, если дата неизвестна) для заказа под поступление
+	defaultBackorderTimeout = 7 * 24 * time.Hour
+	// watchdogBatchSize максимальное количество саг, обрабатываемых watchdog за одну проверку
+	watchdogBatchSize = 100
+)
 
-// SagaData представляет данные для передачи между шагами саги
-type SagaData struct {
-	OrderID          uint               `json:"order_id"`
-	UserID           uint               `json:"user_id"`
-	Items            []entity.OrderItem `json:"items"`
-	Amount           float64            `json:"amount"`
-	Status           entity.OrderStatus `json:"status"`
-	DeliveryInfo     *DeliveryInfo      `json:"delivery_info,omitempty"`
-	PaymentInfo      *PaymentInfo       `json:"payment_info,omitempty"`
-	WarehouseInfo    *WarehouseInfo     `json:"warehouse_info,omitempty"`
-	BillingInfo      *BillingInfo       `json:"billing_info,omitempty"`
-	Error            string             `json:"error,omitempty"`
-	CreatedAt        time.Time          `json:"created_at"`
-	CompensatedSteps map[string]bool    `json:"compensated_steps,omitempty"`
+// StepTimeoutConfig настройки ожидания результатов шагов саги
+type StepTimeoutConfig struct {
+	DefaultTimeout time.Duration            // Таймаут шага по умолчанию
+	StepTimeouts   map[string]time.Duration // Таймауты отдельных шагов
+	MaxRetries     int                      // Количество повторных отправок шага перед запуском компенсации
+	// BackorderTimeout ожидание резерва склада для заказа под поступление сверх ожидаемой даты поступления товаров
+	BackorderTimeout time.Duration
 }
 
 // OrderCancellationPayload структура для события отмены/ошибки заказа
@@ -47,38 +47,6 @@ type OrderCancellationPayload struct {
 	Reason  string `json:"reason"`
 }
 
-// DeliveryInfo информация о доставке
-type DeliveryInfo struct {
-	DeliveryID   string  `json:"delivery_id,omitempty"`
-	Address      string  `json:"address"`
-	DeliveryDate string  `json:"delivery_date"`
-	Cost         float64 `json:"cost"`
-	Status       string  `json:"status"`
-	TimeSlotID   uint    `json:"time_slot_id,omitempty"`
-	ZoneID       uint    `json:"zone_id,omitempty"`
-}
-
-// PaymentInfo информация о платеже
-type PaymentInfo struct {
-	PaymentID     string  `json:"payment_id,omitempty"`
-	Amount        float64 `json:"amount"`
-	Status        string  `json:"status"`
-	TransactionID string  `json:"transaction_id,omitempty"`
-}
-
-// WarehouseInfo информация о резервации товаров на складе
-type WarehouseInfo struct {
-	ReservationID string `json:"reservation_id,omitempty"`
-	Status        string `json:"status"`
-}
-
-// BillingInfo информация о биллинге
-type BillingInfo struct {
-	TransactionID string  `json:"transaction_id,omitempty"`
-	Amount        float64 `json:"amount"`
-	Status        string  `json:"status"`
-}
-
 // ServiceType тип сервиса для шагов саги
 type ServiceType string
 
@@ -90,17 +58,6 @@ const (
 	ServiceWarehouse ServiceType = "warehouse"
 )
 
-// SagaMessage представляет сообщение для оркестрации саги
-type SagaMessage struct {
-	SagaID    string          `json:"saga_id"`
-	StepName  string          `json:"step_name"`
-	Operation string          `json:"operation"`
-	Status    string          `json:"status"`
-	Data      json.RawMessage `json:"data"`
-	Error     string          `json:"error,omitempty"`
-	Timestamp int64           `json:"timestamp"`
-}
-
 // SagaRabbitMQClient интерфейс для работы с RabbitMQ в контексте саги
 type SagaRabbitMQClient interface {
 	PublishMessage(exchange, routingKey string, message interface{}) error
@@ -110,8 +67,17 @@ type SagaRabbitMQClient interface {
 type SagaStateRepository interface {
 	Create(ctx context.Context, state *entity.SagaState) error
 	GetByID(ctx context.Context, sagaID string) (*entity.SagaState, error)
+	LockByID(ctx context.Context, sagaID string) (*entity.SagaState, error)
+	GetByOrderID(ctx context.Context, orderID uint) (*entity.SagaState, error)
 	Update(ctx context.Context, state *entity.SagaState) error
 	Delete(ctx context.Context, sagaID string) error
+	CreateStep(ctx context.Context, step *entity.SagaStepState) (bool, error)
+	GetSteps(ctx context.Context, sagaID string) ([]entity.SagaStepState, error)
+	CompleteStep(ctx context.Context, sagaID, stepName string, status entity.SagaStepStatus, result datatypes.JSON) (bool, error)
+	FindExpiredSteps(ctx context.Context, now time.Time, limit int) ([]entity.SagaStepState, error)
+	RescheduleExpiredStep(ctx context.Context, sagaID, stepName string, deadline, nextDeadline time.Time) (bool, error)
+	MarkStepTimedOut(ctx context.Context, sagaID, stepName string, deadline time.Time, errorMessage string) (bool, error)
+	ExtendStepDeadline(ctx context.Context, sagaID, stepName string, deadline time.Time) (bool, error)
 }
 
 // SagaOrchestrator оркестратор саги для обработки заказа
@@ -123,7 +89,13 @@ type SagaOrchestrator struct {
 	sagaExchange  string
 	orderExchange string
 	logger        *log.Logger
-	sagaSteps     []Step
+	registry      *SagaRegistry
+	outbox        *outbox.Publisher // Если задан, сообщения саги сохраняются в outbox
+
+	defaultStepTimeout time.Duration
+	stepTimeouts       map[string]time.Duration
+	maxStepRetries     int
+	backorderTimeout   time.Duration
 }
 
 // OrderRepository интерфейс для работы с репозиторием заказов
@@ -132,11 +104,7 @@ type OrderRepository interface {
 	GetByID(ctx context.Context, id uint) (*entity.Order, error)
 	Update(ctx context.Context, order *entity.Order) error
 	UpdateOrderStatus(ctx context.Context, orderID uint, status entity.OrderStatus) error
-}
-
-type SagaStep struct {
-	Name         string
-	Dependencies []string
+	UpdateBackorderStatus(ctx context.Context, orderID uint, expectedAt *time.Time) error
 }
 
 // NewSagaOrchestrator создает новый оркестратор саги
@@ -153,14 +121,10 @@ func NewSagaOrchestrator(
 		logger = log.New(log.Writer(), "[SagaOrchestrator] [Saga] ", log.LstdFlags)
 	}
 
-	steps := []Step{
-		{Name: "create_order", CompensateOnError: false},
-		{Name: "process_billing", CompensateOnError: true},
-		{Name: "process_payment", CompensateOnError: true},
-		{Name: "reserve_warehouse", CompensateOnError: true},
-		{Name: "reserve_delivery", CompensateOnError: true},
-		{Name: "confirm_order", CompensateOnError: false},
-		{Name: "notify_customer", CompensateOnError: false},
+	registry := NewSagaRegistry()
+	if err := registry.Register(OrderSagaDefinition()); err != nil {
+		// Стандартное определение статично, ошибка здесь означает ошибку в коде
+		panic(fmt.Sprintf("некорректное определение саги %s: %v", OrderSagaName, err))
 	}
 
 	return &SagaOrchestrator{
@@ -171,166 +135,311 @@ func NewSagaOrchestrator(
 		sagaExchange:  sagaExchange,
 		orderExchange: orderExchange,
 		logger:        logger,
-		sagaSteps:     steps,
+		registry:      registry,
+
+		defaultStepTimeout: defaultStepTimeout,
+		stepTimeouts:       make(map[string]time.Duration),
+		backorderTimeout:   defaultBackorderTimeout,
 	}
 }
 
-// convertOrderItems преобразует entity.OrderItem в sagahandler.OrderItem
-func convertOrderItems(items []entity.OrderItem) []sagahandler.OrderItem {
-	result := make([]sagahandler.OrderItem, len(items))
-	for i, item := range items {
-		result[i] = sagahandler.OrderItem{
-			ID:        item.ID,
-			OrderID:   item.OrderID,
-			ProductID: item.ProductID,
-			Name:      item.Name,
-			Price:     item.Price,
-			Quantity:  item.Quantity,
-			CreatedAt: item.CreatedAt,
-			UpdatedAt: item.UpdatedAt,
+// ConfigureStepTimeouts задает таймауты шагов и количество повторов.
+// Должен вызываться до запуска саг и watchdog.
+func (s *SagaOrchestrator) ConfigureStepTimeouts(cfg StepTimeoutConfig) {
+	if cfg.DefaultTimeout > 0 {
+		s.defaultStepTimeout = cfg.DefaultTimeout
+	}
+	for stepName, timeout := range cfg.StepTimeouts {
+		if timeout > 0 {
+			s.stepTimeouts[stepName] = timeout
 		}
 	}
-	return result
+	if cfg.MaxRetries >= 0 {
+		s.maxStepRetries = cfg.MaxRetries
+	}
+	if cfg.BackorderTimeout > 0 {
+		s.backorderTimeout = cfg.BackorderTimeout
+	}
 }
 
-// convertToEntityItems преобразует sagahandler.OrderItem в entity.OrderItem
-func convertToEntityItems(items []sagahandler.OrderItem) []entity.OrderItem {
-	result := make([]entity.OrderItem, len(items))
-	for i, item := range items {
-		result[i] = entity.OrderItem{
-			ID:        item.ID,
-			OrderID:   item.OrderID,
-			ProductID: item.ProductID,
-			Name:      item.Name,
-			Price:     item.Price,
-			Quantity:  item.Quantity,
-			CreatedAt: item.CreatedAt,
-			UpdatedAt: item.UpdatedAt,
-		}
+// UseOutbox переключает публикацию сообщений саги на transactional outbox.
+// Запуск саги, обработка результата шага и таймаута выполняются в одной транзакции с сообщениями
+// следующих шагов и компенсаций, поэтому сбой сервиса между фиксацией состояния и публикацией не останавливает сагу.
+// Должен вызываться до запуска саг.
+func (s *SagaOrchestrator) UseOutbox(publisher *outbox.Publisher) {
+	s.outbox = publisher
+}
+
+// publisher возвращает клиент для публикации сообщений в рамках транзакции из ctx
+func (s *SagaOrchestrator) publisher(ctx context.Context) SagaRabbitMQClient {
+	if s.outbox != nil {
+		return s.outbox.WithContext(ctx)
 	}
-	return result
+	return s.rabbitMQ
 }
 
-// StartOrderSaga начинает сагу для обработки заказа
-func (s *SagaOrchestrator) StartOrderSaga(ctx context.Context, orderData *sagahandler.SagaData) error {
-	s.logger.Printf("Начата обработка заказа: UserID=%d, Amount=%.2f, Items=%d", orderData.UserID, orderData.Amount, len(orderData.Items))
+// inTransaction выполняет fn в транзакции outbox; без outbox fn выполняется без транзакции
+func (s *SagaOrchestrator) inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.outbox != nil {
+		return s.outbox.Transaction(ctx, fn)
+	}
+	return fn(ctx)
+}
 
-	order := &entity.Order{
-		UserID:    orderData.UserID,
-		Amount:    orderData.Amount,
-		Items:     convertToEntityItems(orderData.Items),
-		Status:    entity.OrderStatusPending,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+// RegisterSaga добавляет определение саги, которое можно запускать через StartSaga
+func (s *SagaOrchestrator) RegisterSaga(def SagaDefinition) error {
+	if err := s.registry.Register(def); err != nil {
+		return fmt.Errorf("ошибка регистрации саги: %w", err)
 	}
+	s.logger.Printf("[INFO] Зарегистрировано определение саги %s (%d шагов)", def.Name, len(def.Steps))
+	return nil
+}
 
-	if err := s.orderRepo.Create(ctx, order); err != nil {
-		return fmt.Errorf("ошибка при создании заказа: %w", err)
+// definitionFor возвращает определение, по которому выполняется сага
+func (s *SagaOrchestrator) definitionFor(state *entity.SagaState) (*SagaDefinition, error) {
+	sagaType := state.SagaType
+	if sagaType == "" {
+		// Саги, созданные до появления реестра, выполняются по стандартному определению
+		sagaType = OrderSagaName
 	}
+	def, ok := s.registry.Get(sagaType)
+	if !ok {
+		return nil, fmt.Errorf("определение саги %s не зарегистрировано", sagaType)
+	}
+	return def, nil
+}
 
-	orderData.OrderID = order.ID
-	orderData.CreatedAt = order.CreatedAt
-	s.logger.Printf("Заказ создан: ID=%d", order.ID)
+// stepTimeout возвращает время ожидания результата шага.
+// Настройка из конфигурации имеет приоритет над таймаутом из определения саги.
+func (s *SagaOrchestrator) stepTimeout(def *SagaDefinition, stepName string) time.Duration {
+	if timeout, ok := s.stepTimeouts[stepName]; ok {
+		return timeout
+	}
+	if step := def.Step(stepName); step != nil && step.Timeout > 0 {
+		return step.Timeout
+	}
+	return s.defaultStepTimeout
+}
 
-	for i := range order.Items {
-		order.Items[i].OrderID = order.ID
+// stepMaxRetries возвращает количество повторных отправок шага при таймауте
+func (s *SagaOrchestrator) stepMaxRetries(def *SagaDefinition, stepName string) int {
+	if step := def.Step(stepName); step != nil && step.Retry != nil {
+		return step.Retry.MaxRetries
 	}
-	orderData.Items = convertOrderItems(order.Items)
+	return s.maxStepRetries
+}
 
-	sagaID := fmt.Sprintf("saga-order-%d-%d", order.ID, time.Now().UnixNano())
+// stepProgress возвращает завершенные и запущенные шаги саги, а также количество шагов, ожидающих результата.
+// Зависимости запущенного шага считаются завершенными: шаг публикуется только после их завершения,
+// а у саг, начатых до появления таблицы шагов, сохранен только ожидаемый шаг.
+func stepProgress(def *SagaDefinition, steps []entity.SagaStepState) (completed, started map[string]bool, running int) {
+	completed = make(map[string]bool)
+	started = make(map[string]bool)
+	for _, step := range steps {
+		started[step.StepName] = true
+		switch step.Status {
+		case entity.SagaStepStatusCompleted:
+			completed[step.StepName] = true
+		case entity.SagaStepStatusRunning:
+			running++
+		}
+		for ancestor := range def.Ancestors(step.StepName) {
+			completed[ancestor] = true
+		}
+	}
+	return completed, started, running
+}
+
+// mergeSagaData дополняет dst данными параллельной ветки саги
+func mergeSagaData(dst *sagahandler.SagaData, src sagahandler.SagaData) {
+	if dst.PaymentInfo == nil {
+		dst.PaymentInfo = src.PaymentInfo
+	}
+	if dst.DeliveryInfo == nil {
+		dst.DeliveryInfo = src.DeliveryInfo
+	}
+	if dst.WarehouseInfo == nil {
+		dst.WarehouseInfo = src.WarehouseInfo
+	}
+	if dst.BillingInfo == nil {
+		dst.BillingInfo = src.BillingInfo
+	}
+	for stepName, compensated := range src.CompensatedSteps {
+		if dst.CompensatedSteps == nil {
+			dst.CompensatedSteps = make(map[string]bool)
+		}
+		dst.CompensatedSteps[stepName] = dst.CompensatedSteps[stepName] || compensated
+	}
+}
 
-	initialSagaState := &entity.SagaState{
-		SagaID:            sagaID,
-		OrderID:           order.ID,
-		Status:            entity.SagaStatusRunning,
-		CompensatedSteps:  make(datatypes.JSONMap),
-		TotalToCompensate: 0,
-		LastStep:          "",
+// publishStep сохраняет шаг со сроком ожидания результата и публикует сообщение для его выполнения.
+// Повторный запуск уже отправленного шага пропускается.
+func (s *SagaOrchestrator) publishStep(ctx context.Context, def *SagaDefinition, state *entity.SagaState, step Step, sagaData sagahandler.SagaData) error {
+	sagaID := state.SagaID
+	if sagaData.CompensatedSteps == nil {
+		sagaData.CompensatedSteps = make(map[string]bool)
 	}
-	if err := s.sagaStateRepo.Create(ctx, initialSagaState); err != nil {
-		s.logger.Printf("[ERROR] SagaID=%s: Не удалось создать состояние саги: %v", sagaID, err)
-		return fmt.Errorf("ошибка создания состояния саги: %w", err)
+	message, err := sagahandler.NewSagaMessage(sagaID, step.Name, sagahandler.OperationExecute, sagahandler.StatusPending, sagaData)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации сообщения для шага %s: %w", step.Name, err)
 	}
-	s.logger.Printf("SagaID=%s: Сага запущена для заказа %d, состояние сохранено в БД", sagaID, orderData.OrderID)
 
-	var actualFirstStep *Step
-	if len(s.sagaSteps) > 1 {
-		actualFirstStep = &s.sagaSteps[1]
+	deadline := time.Now().Add(s.stepTimeout(def, step.Name))
+	created, err := s.sagaStateRepo.CreateStep(ctx, &entity.SagaStepState{
+		SagaID:   sagaID,
+		StepName: step.Name,
+		Status:   entity.SagaStepStatusRunning,
+		Deadline: &deadline,
+		Attempts: 1,
+		Payload:  datatypes.JSON(message.Data),
+	})
+	if err != nil {
+		return err
+	}
+	if !created {
+		s.logger.Printf("SagaID=%s: Шаг %s уже был запущен ранее, повторная отправка не требуется.", sagaID, step.Name)
+		return nil
 	}
 
-	if actualFirstStep != nil {
-		initialSagaState.LastStep = actualFirstStep.Name
-		if err := s.sagaStateRepo.Update(ctx, initialSagaState); err != nil {
-			s.logger.Printf("[WARN] SagaID=%s: Не удалось обновить LastStep при старте: %v", sagaID, err)
+	routingKey := "saga." + step.Name + ".execute"
+	if err := s.publisher(ctx).PublishMessage(s.sagaExchange, routingKey, message); err != nil {
+		if _, cErr := s.sagaStateRepo.CompleteStep(ctx, sagaID, step.Name, entity.SagaStepStatusFailed, nil); cErr != nil {
+			s.logger.Printf("[ERROR] SagaID=%s: Не удалось отметить неотправленный шаг %s: %v", sagaID, step.Name, cErr)
 		}
+		return fmt.Errorf("ошибка публикации сообщения для шага %s: %w", step.Name, err)
+	}
+	s.logger.Printf("SagaID=%s: Сообщение для шага %s отправлено.", sagaID, step.Name)
+	return nil
+}
 
-		message, err := sagahandler.NewSagaMessage(sagaID, actualFirstStep.Name, sagahandler.OperationExecute, sagahandler.StatusPending, orderData)
-		if err != nil {
-			return fmt.Errorf("ошибка при создании сообщения саги для шага %s: %w", actualFirstStep.Name, err)
+// StartOrderSaga начинает стандартную сагу для обработки заказа
+func (s *SagaOrchestrator) StartOrderSaga(ctx context.Context, orderData *sagahandler.SagaData) error {
+	return s.StartSaga(ctx, OrderSagaName, orderData)
+}
+
+// StartSaga создает заказ и запускает сагу по зарегистрированному определению sagaName
+func (s *SagaOrchestrator) StartSaga(ctx context.Context, sagaName string, orderData *sagahandler.SagaData) error {
+	def, ok := s.registry.Get(sagaName)
+	if !ok {
+		return fmt.Errorf("определение саги %s не зарегистрировано", sagaName)
+	}
+	s.logger.Printf("Начата обработка заказа (сага %s): UserID=%d, Amount=%s, Items=%d", def.Name, orderData.UserID, orderData.Amount, len(orderData.Items))
+
+	// Заказ, состояние саги и сообщения первых шагов сохраняются в одной транзакции (при работе через outbox)
+	var sagaID string
+	err := s.inTransaction(ctx, func(ctx context.Context) error {
+		order := &entity.Order{
+			UserID:    orderData.UserID,
+			Amount:    orderData.Amount,
+			Currency:  orderData.Currency.OrDefault(),
+			Items:     entity.OrderItemsFromSaga(orderData.Items),
+			Status:    entity.OrderStatusPending,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
 		}
-		routingKey := "saga." + actualFirstStep.Name + ".execute"
-		err = s.rabbitMQ.PublishMessage(s.sagaExchange, routingKey, message)
-		if err != nil {
-			s.logger.Printf("[ERROR] SagaID=%s: Ошибка публикации для первого шага %s: %v", sagaID, actualFirstStep.Name, err)
+
+		if err := s.orderRepo.Create(ctx, order); err != nil {
+			return fmt.Errorf("ошибка при создании заказа: %w", err)
+		}
+
+		orderData.OrderID = order.ID
+		orderData.CreatedAt = order.CreatedAt
+		s.logger.Printf("Заказ создан: ID=%d", order.ID)
+
+		for i := range order.Items {
+			order.Items[i].OrderID = order.ID
+		}
+		orderData.Items = entity.OrderItemsToSaga(order.Items)
+
+		sagaID = fmt.Sprintf("saga-order-%d-%d", order.ID, time.Now().UnixNano())
+
+		initialSagaState := &entity.SagaState{
+			SagaID:            sagaID,
+			SagaType:          def.Name,
+			OrderID:           order.ID,
+			Status:            entity.SagaStatusRunning,
+			CompensatedSteps:  make(datatypes.JSONMap),
+			TotalToCompensate: 0,
+			LastStep:          "",
+		}
+		if err := s.sagaStateRepo.Create(ctx, initialSagaState); err != nil {
+			s.logger.Printf("[ERROR] SagaID=%s: Не удалось создать состояние саги: %v", sagaID, err)
+			return fmt.Errorf("ошибка создания состояния саги: %w", err)
+		}
+		s.logger.Printf("SagaID=%s: Сага запущена для заказа %d, состояние сохранено в БД", sagaID, orderData.OrderID)
+
+		// Запускаем все шаги без внешних зависимостей
+		firstSteps := def.ReadySteps(nil, nil)
+		if len(firstSteps) == 0 {
+			s.logger.Printf("[WARN] SagaID=%s: Не найден первый реальный шаг для запуска саги", sagaID)
 			initialSagaState.Status = entity.SagaStatusFailed
-			initialSagaState.ErrorMessage = fmt.Sprintf("Ошибка публикации первого шага %s: %v", actualFirstStep.Name, err)
+			initialSagaState.ErrorMessage = "Не найден первый реальный шаг для запуска саги"
 			if uErr := s.sagaStateRepo.Update(ctx, initialSagaState); uErr != nil {
-				s.logger.Printf("[ERROR] SagaID=%s: Не удалось обновить статус саги на Failed после ошибки публикации: %v", sagaID, uErr)
+				s.logger.Printf("[ERROR] SagaID=%s: Не удалось обновить статус саги на Failed (нет шагов): %v", sagaID, uErr)
 			}
-			return err
 		}
-		s.logger.Printf("SagaID=%s: Стартует первый реальный шаг: %s", sagaID, actualFirstStep.Name)
-	} else {
-		s.logger.Printf("[WARN] SagaID=%s: Не найден первый реальный шаг для запуска саги", sagaID)
-		initialSagaState.Status = entity.SagaStatusFailed
-		initialSagaState.ErrorMessage = "Не найден первый реальный шаг для запуска саги"
-		if uErr := s.sagaStateRepo.Update(ctx, initialSagaState); uErr != nil {
-			s.logger.Printf("[ERROR] SagaID=%s: Не удалось обновить статус саги на Failed (нет шагов): %v", sagaID, uErr)
+		for _, step := range firstSteps {
+			if err := s.publishStep(ctx, def, initialSagaState, step, *orderData); err != nil {
+				s.logger.Printf("[ERROR] SagaID=%s: Ошибка публикации для первого шага %s: %v", sagaID, step.Name, err)
+				initialSagaState.Status = entity.SagaStatusFailed
+				initialSagaState.ErrorMessage = fmt.Sprintf("Ошибка публикации первого шага %s: %v", step.Name, err)
+				if uErr := s.sagaStateRepo.Update(ctx, initialSagaState); uErr != nil {
+					s.logger.Printf("[ERROR] SagaID=%s: Не удалось обновить статус саги на Failed после ошибки публикации: %v", sagaID, uErr)
+				}
+				return err
+			}
+			initialSagaState.LastStep = step.Name
+			s.logger.Printf("SagaID=%s: Стартует первый реальный шаг: %s", sagaID, step.Name)
+		}
+		if len(firstSteps) > 0 {
+			if err := s.sagaStateRepo.Update(ctx, initialSagaState); err != nil {
+				s.logger.Printf("[WARN] SagaID=%s: Не удалось обновить LastStep при старте: %v", sagaID, err)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	s.logger.Printf("SagaID=%s: Сага для заказа %d начата.", sagaID, order.ID)
+	s.logger.Printf("SagaID=%s: Сага для заказа %d начата.", sagaID, orderData.OrderID)
 
 	return nil
 }
 
-// getNextStep возвращает следующий шаг после указанного
-func (s *SagaOrchestrator) getNextStep(currentStep string) *Step {
-	currentIdx := -1
-
-	for i, step := range s.sagaSteps {
-		if step.Name == currentStep {
-			currentIdx = i
-			break
+// publishReadySteps публикует шаги, все зависимости которых завершены.
+// Данные завершенных зависимостей объединяются, чтобы шаг после параллельных веток получил результаты каждой из них.
+// Возвращает количество отправленных шагов.
+func (s *SagaOrchestrator) publishReadySteps(ctx context.Context, def *SagaDefinition, state *entity.SagaState, steps []entity.SagaStepState, sagaData sagahandler.SagaData) (int, error) {
+	completed, started, _ := stepProgress(def, steps)
+	results := make(map[string]datatypes.JSON, len(steps))
+	for _, step := range steps {
+		if step.Status == entity.SagaStepStatusCompleted && len(step.Result) > 0 {
+			results[step.StepName] = step.Result
 		}
 	}
 
-	if currentIdx == -1 || currentIdx >= len(s.sagaSteps)-1 {
-		return nil
-	}
-
-	return &s.sagaSteps[currentIdx+1]
-}
-
-// publishNextStep публикует сообщение для следующего шага саги
-func (s *SagaOrchestrator) publishNextStep(sagaID string, currentStep string, sagaData sagahandler.SagaData) error {
-	nextStep := s.getNextStep(currentStep)
-	if nextStep == nil {
-		return nil
-	}
-	if sagaData.CompensatedSteps == nil {
-		sagaData.CompensatedSteps = make(map[string]bool)
-	}
-	message, err := sagahandler.NewSagaMessage(sagaID, nextStep.Name, sagahandler.OperationExecute, sagahandler.StatusPending, sagaData)
-	if err != nil {
-		return fmt.Errorf("ошибка сериализации сообщения для шага %s: %w", nextStep.Name, err)
-	}
-	routingKey := "saga." + nextStep.Name + ".execute"
-	if err := s.rabbitMQ.PublishMessage(s.sagaExchange, routingKey, message); err != nil {
-		return fmt.Errorf("ошибка публикации сообщения для шага %s: %w", nextStep.Name, err)
+	published := 0
+	for _, step := range def.ReadySteps(completed, started) {
+		stepData := sagaData
+		for _, dep := range step.Dependencies {
+			result, ok := results[dep]
+			if !ok {
+				continue
+			}
+			var depData sagahandler.SagaData
+			if err := json.Unmarshal(result, &depData); err != nil {
+				s.logger.Printf("[WARN] SagaID=%s: Не удалось десериализовать результат шага %s: %v", state.SagaID, dep, err)
+				continue
+			}
+			mergeSagaData(&stepData, depData)
+		}
+		if err := s.publishStep(ctx, def, state, step, stepData); err != nil {
+			return published, err
+		}
+		published++
 	}
-	s.logger.Printf("SagaID=%s: Сообщение для следующего шага %s отправлено.", sagaID, nextStep.Name)
-	return nil
+	return published, nil
 }
 
 // removeUnusedSagaStates удаляет состояния саг, которые завершились (успешно или с компенсацией)
@@ -347,39 +456,13 @@ func (s *SagaOrchestrator) cleanupSagaState(ctx context.Context, sagaID string)
 	}
 }
 
-// startCompensationProcess запускает процесс компенсации для шагов, предшествующих failedStep
-func (s *SagaOrchestrator) startCompensationProcess(ctx context.Context, sagaID string, failedStep string, sagaData sagahandler.SagaData, compensatedStepsFromCaller map[string]bool) error {
+// startCompensationProcess запускает процесс компенсации для успешно завершенных шагов саги.
+// Параллельные ветки, которые еще выполняются, компенсируются по мере получения их результатов.
+// Если includeFailedStep равен true, компенсируется и сам failedStep (его результат неизвестен, например при таймауте).
+// Пустой failedStep означает компенсацию без сбойного шага (отмена заказа клиентом).
+func (s *SagaOrchestrator) startCompensationProcess(ctx context.Context, sagaID string, failedStep string, sagaData sagahandler.SagaData, compensatedStepsFromCaller map[string]bool, includeFailedStep bool) error {
 	s.logger.Printf("SagaID=%s: Запуск компенсации для шагов перед %s.", sagaID, failedStep)
 
-	// Находим индекс шага, вызвавшего сбой
-	failedStepIndex := -1
-	for i, step := range s.sagaSteps {
-		if step.Name == failedStep {
-			failedStepIndex = i
-			break
-		}
-	}
-
-	if failedStepIndex == -1 {
-		s.logger.Printf("[ERROR] SagaID=%s: Шаг %s не найден в конфигурации саги.", sagaID, failedStep)
-		return fmt.Errorf("шаг %s не найден в конфигурации саги", failedStep)
-	}
-
-	// Определяем шаги для компенсации (только предыдущие и компенсируемые)
-	stepsToCompensate := make([]Step, 0)
-	for i := failedStepIndex - 1; i >= 0; i-- {
-		step := s.sagaSteps[i]
-		// Шаг нужно компенсировать, только если он имеет флаг CompensateOnError
-		// и он еще не был компенсирован (согласно compensatedStepsFromCaller)
-		if step.CompensateOnError {
-			stepsToCompensate = append(stepsToCompensate, step)
-		}
-	}
-
-	// Рассчитываем общее количество шагов, которые *теоретически* требуют компенсации
-	totalPotentialCompensatable := len(stepsToCompensate)
-	s.logger.Printf("SagaID=%s: Найдено %d предыдущих шагов с флагом CompensateOnError перед %s.", sagaID, totalPotentialCompensatable, failedStep)
-
 	// Получаем текущее состояние саги из репозитория
 	state, err := s.sagaStateRepo.GetByID(ctx, sagaID)
 	if err != nil {
@@ -393,6 +476,35 @@ func (s *SagaOrchestrator) startCompensationProcess(ctx context.Context, sagaID
 		return fmt.Errorf("ошибка получения состояния саги %s: %w", sagaID, err)
 	}
 
+	def, err := s.definitionFor(state)
+	if err != nil {
+		s.logger.Printf("[ERROR] SagaID=%s: %v", sagaID, err)
+		return err
+	}
+
+	if failedStep != "" && def.Step(failedStep) == nil {
+		err := fmt.Errorf("шаг %s не найден в конфигурации саги %s", failedStep, def.Name)
+		s.logger.Printf("[ERROR] SagaID=%s: %v", sagaID, err)
+		return err
+	}
+
+	steps, err := s.sagaStateRepo.GetSteps(ctx, sagaID)
+	if err != nil {
+		s.logger.Printf("[ERROR] SagaID=%s: Ошибка получения шагов саги при запуске компенсации: %v", sagaID, err)
+		return err
+	}
+	completed, _, running := stepProgress(def, steps)
+	if failedStep != "" {
+		completed[failedStep] = includeFailedStep
+	}
+
+	// Определяем шаги для компенсации (завершенные шаги с флагом CompensateOnError)
+	stepsToCompensate := def.StepsToCompensate(completed)
+
+	// Рассчитываем общее количество шагов, которые *теоретически* требуют компенсации
+	totalPotentialCompensatable := len(stepsToCompensate)
+	s.logger.Printf("SagaID=%s: Найдено %d завершенных шагов с флагом CompensateOnError (сбой шага %s, ожидают результата: %d).", sagaID, totalPotentialCompensatable, failedStep, running)
+
 	// Если сага уже в конечном статусе (Compensated или Failed), компенсацию запускать не нужно
 	if state.Status == entity.SagaStatusCompensated || state.Status == entity.SagaStatusFailed {
 		s.logger.Printf("SagaID=%s: Сага уже в конечном статусе (%s), запуск компенсации не требуется.", sagaID, state.Status)
@@ -401,7 +513,18 @@ func (s *SagaOrchestrator) startCompensationProcess(ctx context.Context, sagaID
 
 	// Если нет шагов, которые *теоретически* требуют компенсации (totalPotentialCompensatable == 0),
 	// то сагу можно считать компенсированной (так как нечего компенсировать).
-	if totalPotentialCompensatable == 0 {
+	if totalPotentialCompensatable == 0 && state.TotalToCompensate == 0 {
+		if running > 0 {
+			// Компенсировать пока нечего, но параллельные шаги еще могут завершиться успешно
+			s.logger.Printf("SagaID=%s: Нет завершенных шагов для компенсации, ожидаем результаты %d параллельных шагов.", sagaID, running)
+			if state.Status != entity.SagaStatusCompensating {
+				state.Status = entity.SagaStatusCompensating
+				if uErr := s.sagaStateRepo.Update(ctx, state); uErr != nil {
+					return fmt.Errorf("не удалось обновить состояние саги %s: %w", sagaID, uErr)
+				}
+			}
+			return nil
+		}
 		s.logger.Printf("SagaID=%s: Нет предыдущих шагов, требующих компенсации перед %s. Завершаем сагу как Compensated.", sagaID, failedStep)
 		state.Status = entity.SagaStatusCompensated
 		if uErr := s.sagaStateRepo.Update(ctx, state); uErr != nil {
@@ -457,29 +580,11 @@ func (s *SagaOrchestrator) startCompensationProcess(ctx context.Context, sagaID
 	for _, step := range stepsToCompensate {
 		if _, alreadyCompensated := compensatedStepsFromCaller[step.Name]; !alreadyCompensated {
 			// Готовим и отправляем сообщение компенсации для этого шага
-			dataCopy := sagaData
-			jsonData, err := json.Marshal(dataCopy)
-			if err != nil {
-				s.logger.Printf("[ERROR] SagaID=%s: Ошибка маршалинга данных для компенсации шага %s: %v", sagaID, step.Name, err)
-				continue // Пропускаем этот шаг, но пытаемся компенсировать остальные
+			// Ошибка откатывает транзакцию вместе с состоянием саги, запуск компенсации будет повторен
+			if err := s.publishCompensation(ctx, sagaID, step.Name, sagaData); err != nil {
+				s.logger.Printf("[ERROR] SagaID=%s: %v", sagaID, err)
+				return err
 			}
-
-			message := sagahandler.SagaMessage{
-				SagaID:    sagaID,
-				StepName:  step.Name,
-				Operation: sagahandler.OperationCompensate,
-				Status:    sagahandler.StatusPending,
-				Data:      jsonData,
-				Timestamp: sagahandler.GetTimestamp(),
-			}
-			routingKey := fmt.Sprintf("saga.%s.compensate", step.Name)
-
-			if err := s.rabbitMQ.PublishMessage(s.sagaExchange, routingKey, message); err != nil {
-				s.logger.Printf("[ERROR] SagaID=%s: Ошибка публикации сообщения компенсации для шага %s (key: %s): %v", sagaID, step.Name, routingKey, err)
-				// TODO: Рассмотреть механизм повторных попыток или DLQ. Пока пропускаем.
-				continue
-			}
-			s.logger.Printf("SagaID=%s: Запрос на компенсацию шага %s отправлен (key: %s).", sagaID, step.Name, routingKey)
 			stepsForWhichCompensationSent++
 		} else {
 			s.logger.Printf("SagaID=%s: Шаг %s уже помечен как компенсированный (в данных от вызывающего), пропускаем отправку сообщения компенсации.", sagaID, step.Name)
@@ -504,7 +609,7 @@ func (s *SagaOrchestrator) startCompensationProcess(ctx context.Context, sagaID
 	// Компенсация завершена, если количество фактически компенсированных шагов (currentCompensatedCount)
 	// достигло общего числа шагов, требующих компенсации (state.TotalToCompensate),
 	// и при этом есть хотя бы один шаг для компенсации (state.TotalToCompensate > 0).
-	if currentCompensatedCount >= state.TotalToCompensate && state.TotalToCompensate > 0 {
+	if currentCompensatedCount >= state.TotalToCompensate && state.TotalToCompensate > 0 && running == 0 {
 		s.logger.Printf("SagaID=%s: Все %d необходимых шагов компенсированы (последний инициирующий шаг: %s). Завершение саги как Compensated.", sagaID, state.TotalToCompensate, failedStep)
 		state.Status = entity.SagaStatusCompensated
 		if uErr := s.sagaStateRepo.Update(ctx, state); uErr != nil {
@@ -519,15 +624,95 @@ func (s *SagaOrchestrator) startCompensationProcess(ctx context.Context, sagaID
 	return nil
 }
 
-// HandleSagaResult обрабатывает результат выполнения шага саги
+// publishCompensation отправляет запрос на компенсацию шага
+func (s *SagaOrchestrator) publishCompensation(ctx context.Context, sagaID, stepName string, sagaData sagahandler.SagaData) error {
+	jsonData, err := json.Marshal(sagaData)
+	if err != nil {
+		return fmt.Errorf("ошибка маршалинга данных для компенсации шага %s: %w", stepName, err)
+	}
+
+	message := sagahandler.SagaMessage{
+		SagaID:    sagaID,
+		StepName:  stepName,
+		Operation: sagahandler.OperationCompensate,
+		Status:    sagahandler.StatusPending,
+		Data:      jsonData,
+		Timestamp: sagahandler.GetTimestamp(),
+		Version:   sagahandler.SchemaVersion,
+	}
+	routingKey := fmt.Sprintf("saga.%s.compensate", stepName)
+
+	if err := s.publisher(ctx).PublishMessage(s.sagaExchange, routingKey, message); err != nil {
+		return fmt.Errorf("ошибка публикации сообщения компенсации для шага %s (key: %s): %w", stepName, routingKey, err)
+	}
+	s.logger.Printf("SagaID=%s: Запрос на компенсацию шага %s отправлен (key: %s).", sagaID, stepName, routingKey)
+	return nil
+}
+
+// handleLateStep обрабатывает шаг параллельной ветки, завершившийся после начала компенсации.
+// Если compensate равен true (шаг выполнен или его результат неизвестен), шаг компенсируется.
+// Когда не остается шагов, ожидающих результата, и все компенсации получены, сага завершается.
+func (s *SagaOrchestrator) handleLateStep(ctx context.Context, def *SagaDefinition, state *entity.SagaState, stepName string, compensate bool, sagaData sagahandler.SagaData) error {
+	sagaID := state.SagaID
+	if compensate {
+		if step := def.Step(stepName); step != nil && step.CompensateOnError {
+			if err := s.publishCompensation(ctx, sagaID, stepName, sagaData); err != nil {
+				return err
+			}
+			state.TotalToCompensate++
+			if err := s.sagaStateRepo.Update(ctx, state); err != nil {
+				return fmt.Errorf("не удалось обновить состояние саги %s: %w", sagaID, err)
+			}
+			s.logger.Printf("SagaID=%s: Шаг %s завершился во время компенсации и будет компенсирован (всего к компенсации: %d).", sagaID, stepName, state.TotalToCompensate)
+			return nil
+		}
+	}
+
+	steps, err := s.sagaStateRepo.GetSteps(ctx, sagaID)
+	if err != nil {
+		return err
+	}
+	_, _, running := stepProgress(def, steps)
+	if running > 0 || len(state.CompensatedSteps) < state.TotalToCompensate {
+		s.logger.Printf("SagaID=%s: Компенсация продолжается (ожидают результата: %d, компенсировано %d из %d).", sagaID, running, len(state.CompensatedSteps), state.TotalToCompensate)
+		return nil
+	}
+
+	s.logger.Printf("SagaID=%s: Параллельные шаги завершены, все компенсации получены. Завершение саги как Compensated.", sagaID)
+	state.Status = entity.SagaStatusCompensated
+	if err := s.sagaStateRepo.Update(ctx, state); err != nil {
+		s.logger.Printf("[ERROR] SagaID=%s: Не удалось обновить статус саги на Compensated: %v", sagaID, err)
+	}
+	userID := sagaData.UserID
+	if order, err := s.orderRepo.GetByID(ctx, state.OrderID); err != nil {
+		s.logger.Printf("[ERROR] SagaID=%s: Ошибка получения заказа %d: %v", sagaID, state.OrderID, err)
+	} else {
+		userID = order.UserID
+		if order.Status != entity.OrderStatusCancelled {
+			if err := s.orderRepo.UpdateOrderStatus(ctx, order.ID, entity.OrderStatusCancelled); err != nil {
+				s.logger.Printf("[ERROR] SagaID=%s: Ошибка обновления статуса заказа %d на Canceled: %v", sagaID, order.ID, err)
+			}
+		}
+	}
+	if err := s.publishCancellationEvent(ctx, state.OrderID, userID, "order.cancelled", "Компенсация саги успешно завершена"); err != nil {
+		return err
+	}
+	s.cleanupSagaState(ctx, sagaID)
+	return nil
+}
+
+// HandleSagaResult обрабатывает результат выполнения шага саги.
+// Результаты параллельных веток и отмена заказа могут обрабатываться одновременно, а состояние саги
+// сохраняется целиком, поэтому результат обрабатывается в транзакции под блокировкой записи саги.
 func (s *SagaOrchestrator) HandleSagaResult(result []byte) error {
 	ctx := context.Background()
 
-	var message sagahandler.SagaMessage
-	if err := json.Unmarshal(result, &message); err != nil {
-		s.logger.Printf("[ERROR] Не удалось десериализовать сообщение саги: %v", err)
-		return fmt.Errorf("ошибка при десериализации сообщения саги: %w", err)
+	parsed, err := sagahandler.ParseSagaMessage(result)
+	if err != nil {
+		s.logger.Printf("[ERROR] Не удалось разобрать сообщение саги: %v", err)
+		return err
 	}
+	message := *parsed
 	s.logger.Printf("SagaID=%s: Получен результат: Step=%s, Op=%s, Status=%s", message.SagaID, message.StepName, message.Operation, message.Status)
 
 	sagaData, err := sagahandler.ParseSagaData(message)
@@ -536,7 +721,14 @@ func (s *SagaOrchestrator) HandleSagaResult(result []byte) error {
 		sagaData = sagahandler.SagaData{}
 	}
 
-	state, err := s.sagaStateRepo.GetByID(ctx, message.SagaID)
+	return s.inTransaction(ctx, func(ctx context.Context) error {
+		return s.handleSagaResult(ctx, message, sagaData)
+	})
+}
+
+// handleSagaResult обрабатывает результат шага саги в транзакции из ctx
+func (s *SagaOrchestrator) handleSagaResult(ctx context.Context, message sagahandler.SagaMessage, sagaData sagahandler.SagaData) error {
+	state, err := s.sagaStateRepo.LockByID(ctx, message.SagaID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			s.logger.Printf("[WARN] SagaID=%s: Получено сообщение для неизвестной или уже очищенной саги [%s/%s/%s]. Игнорируется.",
@@ -550,6 +742,32 @@ func (s *SagaOrchestrator) HandleSagaResult(result []byte) error {
 	if state.CompensatedSteps == nil {
 		state.CompensatedSteps = make(datatypes.JSONMap)
 	}
+
+	def, err := s.definitionFor(state)
+	if err != nil {
+		s.logger.Printf("[ERROR] SagaID=%s: %v", message.SagaID, err)
+		return err
+	}
+
+	// Результат шага, пришедший после начала компенсации, не должен продвигать сагу.
+	// Успешно завершившаяся параллельная ветка компенсируется, шаг после таймаута уже включен в компенсацию.
+	if message.Operation == sagahandler.OperationExecute && state.Status == entity.SagaStatusCompensating {
+		succeeded := message.Status == sagahandler.StatusCompleted
+		stepStatus := entity.SagaStepStatusFailed
+		if succeeded {
+			stepStatus = entity.SagaStepStatusCompleted
+		}
+		claimed, cErr := s.sagaStateRepo.CompleteStep(ctx, message.SagaID, message.StepName, stepStatus, datatypes.JSON(message.Data))
+		if cErr != nil {
+			return cErr
+		}
+		if !claimed {
+			s.logger.Printf("[WARN] SagaID=%s: Получен результат %s шага %s во время компенсации. Шаг уже обработан, игнорируется.", message.SagaID, message.Status, message.StepName)
+			return nil
+		}
+		s.logger.Printf("SagaID=%s: Получен результат %s параллельного шага %s во время компенсации.", message.SagaID, message.Status, message.StepName)
+		return s.handleLateStep(ctx, def, state, message.StepName, succeeded, sagaData)
+	}
 	deliveryInfoBackup := sagaData.DeliveryInfo
 
 	stateUpdated := false
@@ -568,12 +786,20 @@ func (s *SagaOrchestrator) HandleSagaResult(result []byte) error {
 		stateUpdated = true
 		s.logger.Printf("SagaID=%s: Шаг %s помечен как компенсированный.", message.SagaID, message.StepName)
 
-		if state.TotalToCompensate > 0 && len(state.CompensatedSteps) >= state.TotalToCompensate {
+		// Пока параллельные шаги ожидают результата, компенсация не может быть завершена
+		steps, sErr := s.sagaStateRepo.GetSteps(ctx, message.SagaID)
+		if sErr != nil {
+			s.logger.Printf("[ERROR] SagaID=%s: Ошибка получения шагов саги: %v", message.SagaID, sErr)
+			return sErr
+		}
+		_, _, running := stepProgress(def, steps)
+
+		if state.TotalToCompensate > 0 && len(state.CompensatedSteps) >= state.TotalToCompensate && running == 0 {
 			s.logger.Printf("SagaID=%s: Все %d ожидаемых шагов компенсированы. Завершаем компенсацию саги. Компенсированные шаги: %v", message.SagaID, state.TotalToCompensate, state.CompensatedSteps)
 			state.Status = entity.SagaStatusCompensated
 			compensationCompleted = true
 		} else {
-			s.logger.Printf("SagaID=%s: Компенсация продолжается. Шагов компенсировано: %d из %d. Компенсированные шаги: %v", message.SagaID, len(state.CompensatedSteps), state.TotalToCompensate, state.CompensatedSteps)
+			s.logger.Printf("SagaID=%s: Компенсация продолжается. Шагов компенсировано: %d из %d, ожидают результата: %d. Компенсированные шаги: %v", message.SagaID, len(state.CompensatedSteps), state.TotalToCompensate, running, state.CompensatedSteps)
 			state.Status = entity.SagaStatusCompensating
 		}
 
@@ -596,12 +822,15 @@ func (s *SagaOrchestrator) HandleSagaResult(result []byte) error {
 		if compensationCompleted {
 			s.logger.Printf("SagaID=%s: Компенсация завершена. Запуск очистки состояния.", message.SagaID)
 			// Отправляем уведомление об отмене перед очисткой
+			var userID uint
 			if order != nil {
-				s.publishCancellationEvent(ctx, state.OrderID, order.UserID, "order.cancelled", "Компенсация саги успешно завершена")
+				userID = order.UserID
 			} else {
 				// Крайне маловероятно, что order будет nil здесь, но на всякий случай
 				s.logger.Printf("[WARN] SagaID=%s: order is nil при отправке уведомления order.cancelled. Используем UserID=0.", message.SagaID)
-				s.publishCancellationEvent(ctx, state.OrderID, 0, "order.cancelled", "Компенсация саги успешно завершена")
+			}
+			if err := s.publishCancellationEvent(ctx, state.OrderID, userID, "order.cancelled", "Компенсация саги успешно завершена"); err != nil {
+				return err
 			}
 			s.cleanupSagaState(ctx, message.SagaID)
 		}
@@ -627,6 +856,20 @@ func (s *SagaOrchestrator) HandleSagaResult(result []byte) error {
 		stateUpdated = true
 	}
 
+	// Фиксируем результат шага, таймаут больше не отслеживается
+	var steps []entity.SagaStepState
+	if message.Operation == sagahandler.OperationExecute && def.Step(message.StepName) != nil {
+		var recorded bool
+		steps, recorded, err = s.recordStepResult(ctx, message)
+		if err != nil {
+			return err
+		}
+		if !recorded {
+			s.logger.Printf("[WARN] SagaID=%s: Результат шага %s уже был обработан. Игнорируется.", message.SagaID, message.StepName)
+			return nil
+		}
+	}
+
 	switch {
 	case message.Operation == sagahandler.OperationExecute && message.Status == sagahandler.StatusCompleted:
 		// Обработка успешного завершения шага
@@ -638,9 +881,19 @@ func (s *SagaOrchestrator) HandleSagaResult(result []byte) error {
 			return fmt.Errorf("критическая ошибка: не удалось получить заказ %d при обработке шага %s саги %s: %w", state.OrderID, message.StepName, message.SagaID, err)
 		}
 
-		if message.StepName == "notify_customer" {
-			// Это был предпоследний шаг, теперь завершаем заказ
-			s.logger.Printf("SagaID=%s: Получен успешный результат от notify_customer. Завершение заказа ID=%d.", message.SagaID, order.ID)
+		if message.StepName == "complete_order" {
+			// Этот шаг больше не должен вызываться через сообщение, но оставим лог на всякий случай
+			s.logger.Printf("[WARN] SagaID=%s: Получено сообщение для устаревшего шага 'complete_order'. Игнорируется.", message.SagaID)
+			// Можно просто проигнорировать или проверить статус заказа/саги и очистить если нужно
+			return nil
+
+		} else if def.Step(message.StepName) == nil {
+			s.logger.Printf("[WARN] SagaID=%s: Шаг %s не входит в определение саги %s. Игнорируется.", message.SagaID, message.StepName, def.Name)
+			return nil
+
+		} else if completed, _, _ := stepProgress(def, steps); def.IsCompleted(completed) {
+			// Все шаги саги выполнены, теперь завершаем заказ
+			s.logger.Printf("SagaID=%s: Получен успешный результат последнего шага %s. Завершение заказа ID=%d.", message.SagaID, message.StepName, order.ID)
 
 			// Обновляем статус заказа на Completed
 			if order.Status != entity.OrderStatusCompleted { // Проверяем, чтобы не обновлять повторно
@@ -669,23 +922,18 @@ func (s *SagaOrchestrator) HandleSagaResult(result []byte) error {
 			s.cleanupSagaState(ctx, message.SagaID)
 			return nil // Завершаем обработку успешно
 
-		} else if message.StepName == "complete_order" {
-			// Этот шаг больше не должен вызываться через сообщение, но оставим лог на всякий случай
-			s.logger.Printf("[WARN] SagaID=%s: Получено сообщение для устаревшего шага 'complete_order'. Игнорируется.", message.SagaID)
-			// Можно просто проигнорировать или проверить статус заказа/саги и очистить если нужно
-			return nil
-
 		} else {
-			// Обработка успешного завершения промежуточного шага (не notify_customer)
-			s.logger.Printf("SagaID=%s: Успешно завершен промежуточный шаг: %s. Запуск следующего.", message.SagaID, message.StepName)
+			// Обработка успешного завершения промежуточного шага
+			s.logger.Printf("SagaID=%s: Успешно завершен промежуточный шаг: %s. Запуск готовых шагов.", message.SagaID, message.StepName)
 
 			// Восстановление DeliveryInfo, если оно пропало (может быть актуально)
 			if sagaData.DeliveryInfo == nil && deliveryInfoBackup != nil {
 				sagaData.DeliveryInfo = deliveryInfoBackup
 			}
 
-			// Публикация сообщения для следующего шага
-			if err := s.publishNextStep(message.SagaID, message.StepName, sagaData); err != nil {
+			// Публикация сообщений для шагов, все зависимости которых завершены
+			published, err := s.publishReadySteps(ctx, def, state, steps, sagaData)
+			if err != nil {
 				// Ошибка публикации -> Переводим заказ и сагу в Failed
 				order.Status = entity.OrderStatusFailed
 				if uErr := s.orderRepo.UpdateOrderStatus(ctx, order.ID, entity.OrderStatusFailed); uErr != nil {
@@ -699,9 +947,13 @@ func (s *SagaOrchestrator) HandleSagaResult(result []byte) error {
 				return err // Возвращаем ошибку публикации
 			}
 
+			if published == 0 {
+				s.logger.Printf("SagaID=%s: Ожидаем завершения параллельных шагов перед продолжением саги.", message.SagaID)
+			}
+
 			// Если публикация успешна:
-			// Статус заказа остается Pending
-			if order.Status != entity.OrderStatusPending {
+			// Статус заказа остается Pending (или Backordered, пока склад ждет поступления товара)
+			if order.Status != entity.OrderStatusPending && !awaitsBackorder(order, steps) {
 				if err := s.orderRepo.UpdateOrderStatus(ctx, order.ID, entity.OrderStatusPending); err != nil {
 					// Ошибка обновления статуса заказа на Pending -> возвращаем ошибку
 					return fmt.Errorf("ошибка при обновлении заказа %d на Pending: %w", order.ID, err)
@@ -745,17 +997,20 @@ func (s *SagaOrchestrator) HandleSagaResult(result []byte) error {
 		if order != nil {
 			userID = order.UserID
 		}
-		s.publishCancellationEvent(ctx, state.OrderID, userID, "order.failed", state.ErrorMessage)
+		if err := s.publishCancellationEvent(ctx, state.OrderID, userID, "order.failed", state.ErrorMessage); err != nil {
+			return err
+		}
 
-		// Запускаем процесс компенсации (если нужно)
+		// Запускаем процесс компенсации (если нужно).
+		// Ошибка откатывает транзакцию, и результат шага будет обработан повторно.
 		if state.Status == entity.SagaStatusCompensating {
 			stepsToPass := convertJSONMapToBoolMap(state.CompensatedSteps)
-			if err := s.startCompensationProcess(ctx, message.SagaID, message.StepName, sagaData, stepsToPass); err != nil {
+			if err := s.startCompensationProcess(ctx, message.SagaID, message.StepName, sagaData, stepsToPass, false); err != nil {
 				s.logger.Printf("[ERROR] SagaID=%s: Ошибка запуска компенсации после сбоя шага %s: %v", message.SagaID, message.StepName, err)
-				// Не возвращаем ошибку, компенсация будет продолжена или зависнет
+				return err
 			}
 		}
-		return nil // Return nil because the error/compensation is being handled asynchronously
+		return nil
 
 	default:
 		s.logger.Printf("[WARN] SagaID=%s: Неизвестная или необработанная комбинация операции/статуса: %s/%s для шага %s",
@@ -769,7 +1024,9 @@ func (s *SagaOrchestrator) HandleSagaResult(result []byte) error {
 		if order != nil {
 			userID = order.UserID
 		}
-		s.publishCancellationEvent(ctx, state.OrderID, userID, "order.failed", state.ErrorMessage)
+		if err := s.publishCancellationEvent(ctx, state.OrderID, userID, "order.failed", state.ErrorMessage); err != nil {
+			return err
+		}
 	}
 
 	if stateUpdated {
@@ -782,6 +1039,56 @@ func (s *SagaOrchestrator) HandleSagaResult(result []byte) error {
 	return nil
 }
 
+// recordStepResult фиксирует результат выполняющегося шага и возвращает состояния шагов саги,
+// прочитанные после фиксации. Возвращает false, если результат шага уже был обработан ранее.
+func (s *SagaOrchestrator) recordStepResult(ctx context.Context, message sagahandler.SagaMessage) ([]entity.SagaStepState, bool, error) {
+	steps, err := s.sagaStateRepo.GetSteps(ctx, message.SagaID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	stepStatus := entity.SagaStepStatusFailed
+	if message.Status == sagahandler.StatusCompleted {
+		stepStatus = entity.SagaStepStatusCompleted
+	}
+
+	for i := range steps {
+		if steps[i].StepName != message.StepName {
+			continue
+		}
+		if steps[i].Status != entity.SagaStepStatusRunning {
+			return steps, false, nil
+		}
+		claimed, err := s.sagaStateRepo.CompleteStep(ctx, message.SagaID, message.StepName, stepStatus, datatypes.JSON(message.Data))
+		if err != nil || !claimed {
+			return steps, false, err
+		}
+		steps[i].Status = stepStatus
+		steps[i].Deadline = nil
+		steps[i].Result = datatypes.JSON(message.Data)
+
+		// Шаги перечитываются после фиксации результата: результат параллельной ветки мог быть зафиксирован
+		// другим экземпляром сервиса после первого чтения. Иначе каждая ветка увидит другую выполняющейся,
+		// и шаг после их объединения не будет запущен. Если обе ветки увидят друг друга завершенными,
+		// повторный запуск шага исключает CreateStep.
+		current, err := s.sagaStateRepo.GetSteps(ctx, message.SagaID)
+		if err != nil {
+			s.logger.Printf("[WARN] SagaID=%s: Не удалось перечитать шаги саги после фиксации шага %s: %v. Используется прочитанное ранее состояние.", message.SagaID, message.StepName, err)
+			return steps, true, nil
+		}
+		return current, true, nil
+	}
+
+	// Шаг саги, начатой до появления таблицы шагов, учитываем только в памяти
+	steps = append(steps, entity.SagaStepState{
+		SagaID:   message.SagaID,
+		StepName: message.StepName,
+		Status:   stepStatus,
+		Result:   datatypes.JSON(message.Data),
+	})
+	return steps, true, nil
+}
+
 // copyMap создает неглубокую копию map[string]bool (достаточно для этого случая)
 func convertJSONMapToBoolMap(original datatypes.JSONMap) map[string]bool {
 	if original == nil {
@@ -845,28 +1152,9 @@ func (l *sagaLogger) Error(msg string, args ...interface{}) {
 	l.logger.Printf("[ERROR] "+msg, args...)
 }
 
-// isFirstCompensatableStep проверяет, является ли данный шаг первым компенсируемым шагом в саге
-func (s *SagaOrchestrator) isFirstCompensatableStep(stepName string) bool {
-	for _, step := range s.sagaSteps {
-		if step.CompensateOnError {
-			return step.Name == stepName
-		}
-	}
-	return false
-}
-
-// getFirstCompensatableStepName возвращает имя первого компенсируемого шага (для логов)
-func (s *SagaOrchestrator) getFirstCompensatableStepName() string {
-	for _, step := range s.sagaSteps {
-		if step.CompensateOnError {
-			return step.Name
-		}
-	}
-	return "<не найдено>"
-}
-
-// publishCancellationEvent отправляет событие отмены/ошибки заказа
-func (s *SagaOrchestrator) publishCancellationEvent(ctx context.Context, orderID uint, userID uint, eventType string, reason string) {
+// publishCancellationEvent отправляет событие отмены/ошибки заказа.
+// В режиме outbox ошибка означает сбой записи в транзакции, поэтому она возвращается вызывающему.
+func (s *SagaOrchestrator) publishCancellationEvent(ctx context.Context, orderID uint, userID uint, eventType string, reason string) error {
 	// Получаем email пользователя
 	user, err := s.userRepo.GetByID(ctx, userID)
 	userEmail := ""
@@ -887,7 +1175,9 @@ func (s *SagaOrchestrator) publishCancellationEvent(ctx context.Context, orderID
 	}
 
 	// Публикуем в exchange заказов (например, order_events), а не в saga_events
-	if err := s.rabbitMQ.PublishMessage(s.orderExchange, eventType, payload); err != nil {
+	if err := s.publisher(ctx).PublishMessage(s.orderExchange, eventType, payload); err != nil {
 		s.logger.Printf("[ERROR] SagaID=saga-order-%d: Ошибка отправки уведомления %s: %v", orderID, eventType, err)
+		return fmt.Errorf("ошибка отправки уведомления %s по заказу %d: %w", eventType, orderID, err)
 	}
+	return nil
 }
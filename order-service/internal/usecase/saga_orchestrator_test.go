@@ -6,14 +6,22 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"regexp"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+	"github.com/director74/dz8_shop/pkg/outbox"
 	"github.com/director74/dz8_shop/pkg/sagahandler"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gorm.io/datatypes"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 // Мок для OrderRepository
@@ -48,6 +56,11 @@ func (m *MockOrderRepository) UpdateOrderStatus(ctx context.Context, orderID uin
 	return args.Error(0)
 }
 
+func (m *MockOrderRepository) UpdateBackorderStatus(ctx context.Context, orderID uint, expectedAt *time.Time) error {
+	args := m.Called(ctx, orderID, expectedAt)
+	return args.Error(0)
+}
+
 // Мок для SagaStateRepository
 type MockSagaStateRepository struct {
 	mock.Mock
@@ -63,6 +76,28 @@ func (m *MockSagaStateRepository) GetByID(ctx context.Context, sagaID string) (*
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
+	if load, ok := args.Get(0).(func() *entity.SagaState); ok {
+		return load(), args.Error(1)
+	}
+	return args.Get(0).(*entity.SagaState), args.Error(1)
+}
+
+func (m *MockSagaStateRepository) LockByID(ctx context.Context, sagaID string) (*entity.SagaState, error) {
+	args := m.Called(ctx, sagaID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	if load, ok := args.Get(0).(func() *entity.SagaState); ok {
+		return load(), args.Error(1)
+	}
+	return args.Get(0).(*entity.SagaState), args.Error(1)
+}
+
+func (m *MockSagaStateRepository) GetByOrderID(ctx context.Context, orderID uint) (*entity.SagaState, error) {
+	args := m.Called(ctx, orderID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
 	return args.Get(0).(*entity.SagaState), args.Error(1)
 }
 
@@ -76,6 +111,76 @@ func (m *MockSagaStateRepository) Delete(ctx context.Context, sagaID string) err
 	return args.Error(0)
 }
 
+func (m *MockSagaStateRepository) CreateStep(ctx context.Context, step *entity.SagaStepState) (bool, error) {
+	args := m.Called(ctx, step)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockSagaStateRepository) GetSteps(ctx context.Context, sagaID string) ([]entity.SagaStepState, error) {
+	args := m.Called(ctx, sagaID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]entity.SagaStepState), args.Error(1)
+}
+
+func (m *MockSagaStateRepository) CompleteStep(ctx context.Context, sagaID, stepName string, status entity.SagaStepStatus, result datatypes.JSON) (bool, error) {
+	args := m.Called(ctx, sagaID, stepName, status, result)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockSagaStateRepository) FindExpiredSteps(ctx context.Context, now time.Time, limit int) ([]entity.SagaStepState, error) {
+	args := m.Called(ctx, now, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]entity.SagaStepState), args.Error(1)
+}
+
+func (m *MockSagaStateRepository) RescheduleExpiredStep(ctx context.Context, sagaID, stepName string, deadline, nextDeadline time.Time) (bool, error) {
+	args := m.Called(ctx, sagaID, stepName, deadline, nextDeadline)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockSagaStateRepository) MarkStepTimedOut(ctx context.Context, sagaID, stepName string, deadline time.Time, errorMessage string) (bool, error) {
+	args := m.Called(ctx, sagaID, stepName, deadline, errorMessage)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockSagaStateRepository) ExtendStepDeadline(ctx context.Context, sagaID, stepName string, deadline time.Time) (bool, error) {
+	args := m.Called(ctx, sagaID, stepName, deadline)
+	return args.Bool(0), args.Error(1)
+}
+
+// Мок для UserRepository
+type MockUserRepository struct {
+	mock.Mock
+}
+
+func (m *MockUserRepository) Create(ctx context.Context, user *entity.User) error {
+	return nil
+}
+
+func (m *MockUserRepository) GetByID(ctx context.Context, id uint) (*entity.User, error) {
+	return &entity.User{ID: id, Email: "test@example.com"}, nil
+}
+
+func (m *MockUserRepository) GetByEmail(ctx context.Context, email string) (*entity.User, error) {
+	return nil, nil
+}
+
+func (m *MockUserRepository) GetByUsername(ctx context.Context, username string) (*entity.User, error) {
+	return nil, nil
+}
+
+func (m *MockUserRepository) Update(ctx context.Context, user *entity.User) error {
+	return nil
+}
+
+func (m *MockUserRepository) Delete(ctx context.Context, id uint) error {
+	return nil
+}
+
 // Мок для SagaRabbitMQClient
 type MockRabbitMQ struct {
 	mock.Mock
@@ -151,10 +256,10 @@ func createTestSagaData() *sagahandler.SagaData {
 			{
 				ProductID: 1,
 				Quantity:  2,
-				Price:     100,
+				Price:     money.FromMinor(10000),
 			},
 		},
-		Amount: 200,
+		Amount: money.FromMinor(20000),
 		Status: "pending",
 	}
 }
@@ -168,16 +273,22 @@ func createTestOrder() *entity.Order {
 			{
 				ProductID: 1,
 				Quantity:  2,
-				Price:     100,
+				Price:     money.FromMinor(10000),
 			},
 		},
-		Amount:    200,
+		Amount:    money.FromMinor(20000),
 		Status:    entity.OrderStatusPending,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 }
 
+// Вспомогательная функция для создания шага, ожидающего результата.
+// Зависимости шага оркестратор считает завершенными.
+func runningStep(sagaID, stepName string) []entity.SagaStepState {
+	return []entity.SagaStepState{{SagaID: sagaID, StepName: stepName, Status: entity.SagaStepStatusRunning}}
+}
+
 // Основные тесты для оркестратора
 
 // TestStartOrderSaga тестирует запуск саги для обработки заказа
@@ -189,7 +300,7 @@ func TestStartOrderSaga(t *testing.T) {
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
 	// Создаем оркестратор с моками
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	// Тестовые данные
 	orderData := createTestSagaData()
@@ -198,7 +309,13 @@ func TestStartOrderSaga(t *testing.T) {
 	mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*entity.Order")).Return(nil)
 	// Настраиваем ожидаемое поведение репозитория состояний
 	mockStateRepo.On("Create", mock.Anything, mock.AnythingOfType("*entity.SagaState")).Return(nil)
-	mockStateRepo.On("Update", mock.Anything, mock.AnythingOfType("*entity.SagaState")).Return(nil)
+	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
+		return state.LastStep == "process_billing"
+	})).Return(nil)
+	// Для первого шага фиксируется срок ожидания результата
+	mockStateRepo.On("CreateStep", mock.Anything, mock.MatchedBy(func(step *entity.SagaStepState) bool {
+		return step.StepName == "process_billing" && step.Status == entity.SagaStepStatusRunning && step.Deadline != nil && step.Attempts == 1
+	})).Return(true, nil)
 
 	// Настраиваем ожидаемое поведение RabbitMQ
 	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_billing.execute", mock.Anything).Return(nil)
@@ -227,7 +344,7 @@ func TestHandleSagaResult_SuccessExecution(t *testing.T) {
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
 	// Создаем оркестратор с моками
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	// Тестовый заказ и данные саги
 	// testOrder := createTestOrder() // Не используется
@@ -241,15 +358,16 @@ func TestHandleSagaResult_SuccessExecution(t *testing.T) {
 
 	// Настраиваем ожидаемое поведение репозитория
 	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
-	// Добавляем ожидание вызова Update с правильным статусом Pending
-	mockRepo.On("Update", mock.Anything, mock.MatchedBy(func(order *entity.Order) bool {
-		return order.ID == 10 && order.Status == entity.OrderStatusPending
-	})).Return(nil)
 	// Настраиваем ожидаемое поведение репозитория состояний
-	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
 	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
 		return state.SagaID == sagaID && state.LastStep == "process_billing" && state.Status == entity.SagaStatusRunning
 	})).Return(nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return(runningStep(sagaID, "process_billing"), nil)
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "process_billing", entity.SagaStepStatusCompleted, mock.Anything).Return(true, nil)
+	mockStateRepo.On("CreateStep", mock.Anything, mock.MatchedBy(func(step *entity.SagaStepState) bool {
+		return step.StepName == "process_payment"
+	})).Return(true, nil)
 
 	// Настраиваем ожидаемое поведение RabbitMQ для следующего шага
 	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_payment.execute", mock.Anything).Return(nil)
@@ -277,7 +395,7 @@ func TestHandleSagaResult_FailedExecution(t *testing.T) {
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
 	// Создаем оркестратор с моками
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	// Тестовый заказ и данные саги
 	// testOrder := createTestOrder() // Не используется
@@ -294,7 +412,13 @@ func TestHandleSagaResult_FailedExecution(t *testing.T) {
 	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
 	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusFailed).Return(nil)
 	// Настраиваем ожидаемое поведение репозитория состояний
-	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil).Once()
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil).Once()
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return(runningStep(sagaID, "process_payment"), nil).Once()
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "process_payment", entity.SagaStepStatusFailed, mock.Anything).Return(true, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{
+		{SagaID: sagaID, StepName: "process_billing", Status: entity.SagaStepStatusCompleted},
+		{SagaID: sagaID, StepName: "process_payment", Status: entity.SagaStepStatusFailed},
+	}, nil).Twice()
 	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
 		return state.SagaID == sagaID && state.Status == entity.SagaStatusCompensating && state.LastStep == "process_payment"
 	})).Return(nil).Once()
@@ -305,6 +429,8 @@ func TestHandleSagaResult_FailedExecution(t *testing.T) {
 
 	// Настраиваем ожидаемое поведение RabbitMQ для компенсации предыдущего шага
 	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_billing.compensate", mock.Anything).Return(nil)
+	// Уведомление об ошибке заказа публикуется в exchange заказов
+	mockRabbitMQ.On("PublishMessage", "order_events", "order.failed", mock.Anything).Return(nil)
 
 	// Вызываем тестируемый метод
 	err = orchestrator.HandleSagaResult(testMessage)
@@ -328,7 +454,7 @@ func TestHandleSagaResult_CompensationResult(t *testing.T) {
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
 	// Создаем оркестратор с моками
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	// Тестовый заказ и данные саги
 	// testOrder := createTestOrder() // Не используется
@@ -347,7 +473,7 @@ func TestHandleSagaResult_CompensationResult(t *testing.T) {
 	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil).Maybe()                     // Может вызываться или нет в compensate
 	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusCancelled).Return(nil).Maybe() // Может вызываться или нет
 	// Настраиваем ожидаемое поведение репозитория состояний
-	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
 
 	// Ожидаем Update после каждого шага компенсации
 	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
@@ -381,6 +507,9 @@ func TestHandleSagaResult_CompensationResult(t *testing.T) {
 	})).Return(nil).Once() // .Once() для четвертого вызова
 
 	mockStateRepo.On("Delete", mock.Anything, sagaID).Return(nil).Once() // Ожидаем Delete после последнего Update
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{}, nil)
+	// После завершения компенсации публикуется событие отмены заказа
+	mockRabbitMQ.On("PublishMessage", "order_events", "order.cancelled", mock.Anything).Return(nil).Once()
 
 	// 1. Компенсация reserve_delivery
 	testMessage, err := createSagaMessage(sagaID, "reserve_delivery", sagahandler.OperationCompensate, sagahandler.StatusCompensated, sagaData)
@@ -409,7 +538,7 @@ func TestHandleSagaResult_CompensationResult(t *testing.T) {
 	// Проверяем ожидания моков
 	mockRepo.AssertExpectations(t)
 	mockStateRepo.AssertExpectations(t)
-	// Проверяем, что PublishMessage не вызывался в этом тесте
+	// Проверяем, что в saga_exchange ничего не публиковалось, кроме события отмены заказа
 	mockRabbitMQ.AssertExpectations(t)
 }
 
@@ -422,7 +551,7 @@ func TestHandleSagaResult_CompleteOrder(t *testing.T) {
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
 	// Создаем оркестратор с моками
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	// Тестовый заказ и данные саги
 	// testOrder := createTestOrder() // Не используется
@@ -431,18 +560,20 @@ func TestHandleSagaResult_CompleteOrder(t *testing.T) {
 	testSagaState := &entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusCompleted}
 
 	// Создаем тестовое сообщение (успешное завершение последнего шага)
-	testMessage, err := createSagaMessage(sagaID, "complete_order", sagahandler.OperationExecute, sagahandler.StatusCompleted, sagaData)
+	testMessage, err := createSagaMessage(sagaID, "notify_customer", sagahandler.OperationExecute, sagahandler.StatusCompleted, sagaData)
 	assert.NoError(t, err)
 
 	// Настраиваем ожидаемое поведение репозитория
 	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
 	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusCompleted).Return(nil)
 	// Настраиваем ожидаемое поведение репозитория состояний
-	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
 	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
 		return state.SagaID == sagaID && state.Status == entity.SagaStatusCompleted
 	})).Return(nil)
 	mockStateRepo.On("Delete", mock.Anything, sagaID).Return(nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return(runningStep(sagaID, "notify_customer"), nil)
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "notify_customer", entity.SagaStepStatusCompleted, mock.Anything).Return(true, nil)
 
 	// Вызываем тестируемый метод
 	err = orchestrator.HandleSagaResult(testMessage)
@@ -466,7 +597,7 @@ func TestHandleSagaResult_ExecuteCompensated(t *testing.T) {
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
 	// Создаем оркестратор с моками
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	// Тестовый заказ и данные саги
 	// testOrder := createTestOrder() // Не используется
@@ -482,17 +613,26 @@ func TestHandleSagaResult_ExecuteCompensated(t *testing.T) {
 	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
 	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusFailed).Return(nil)
 	// Настраиваем ожидаемое поведение репозитория состояний
-	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil).Once()
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil).Once()
 	// Первый Update: сохраняем статус Compensating и ошибку
 	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
 		return state.SagaID == sagaID && state.Status == entity.SagaStatusCompensating
 	})).Return(nil).Once()
+	// Склад, выполнявшийся параллельно, успел завершиться
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{
+		{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusCompleted},
+		{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusRunning},
+	}, nil).Once()
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "reserve_delivery", entity.SagaStepStatusFailed, mock.Anything).Return(true, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{
+		{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusCompleted},
+		{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusFailed},
+	}, nil).Twice()
 	// Второй GetByID: вызывается внутри startCompensationProcess
 	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil).Once()
 	// Второй Update: устанавливаем TotalToCompensate
 	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
-		// Рассчитываем ожидаемое количество шагов для компенсации перед reserve_delivery
-		// process_billing, process_payment, reserve_warehouse - всего 3
+		// Компенсируются успешно завершенные шаги: process_billing, process_payment, reserve_warehouse - всего 3
 		return state.SagaID == sagaID && state.Status == entity.SagaStatusCompensating && state.TotalToCompensate == 3
 	})).Return(nil).Once()
 
@@ -501,6 +641,7 @@ func TestHandleSagaResult_ExecuteCompensated(t *testing.T) {
 	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.reserve_warehouse.compensate", mock.Anything).Return(nil)
 	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_payment.compensate", mock.Anything).Return(nil)
 	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_billing.compensate", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "order_events", "order.failed", mock.Anything).Return(nil)
 
 	// Вызываем тестируемый метод
 	err = orchestrator.HandleSagaResult(testMessage)
@@ -512,11 +653,13 @@ func TestHandleSagaResult_ExecuteCompensated(t *testing.T) {
 	mockRabbitMQ.AssertExpectations(t)
 
 	// Проверяем, что сообщения для компенсации предыдущих шагов были отправлены
-	assert.Equal(t, 3, len(mockRabbitMQ.PublishHistory)) // Ожидаем 3 сообщения
 	actualKeys := map[string]bool{}
 	for _, pub := range mockRabbitMQ.PublishHistory {
-		actualKeys[pub.RoutingKey] = true
+		if pub.Exchange == "saga_exchange" {
+			actualKeys[pub.RoutingKey] = true
+		}
 	}
+	assert.Equal(t, 3, len(actualKeys)) // Ожидаем 3 сообщения компенсации
 	expectedKeys := map[string]bool{
 		"saga.reserve_warehouse.compensate": true,
 		"saga.process_payment.compensate":   true,
@@ -536,7 +679,7 @@ func TestSetupOrderSagaConsumer(t *testing.T) {
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
 	// Создаем оркестратор с моками
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	// Настраиваем ожидаемое поведение RabbitMQ
 	mockRabbitMQ.On("DeclareExchange", "saga_exchange", "topic").Return(nil)
@@ -563,7 +706,7 @@ func TestStartOrderSaga_CreateError(t *testing.T) {
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
 	// Создаем оркестратор с моками
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	// Тестовые данные
 	orderData := createTestSagaData()
@@ -592,7 +735,7 @@ func TestHandleSagaResult_GetOrderError(t *testing.T) {
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
 	// Создаем оркестратор с моками
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	// Тестовые данные саги
 	sagaData := createTestSagaData()
@@ -603,7 +746,7 @@ func TestHandleSagaResult_GetOrderError(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Настраиваем ожидаемое поведение репозитория состояний с ошибкой
-	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(nil, fmt.Errorf("Ошибка получения состояния саги из БД: saga state not found"))
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(nil, fmt.Errorf("Ошибка получения состояния саги из БД: saga state not found"))
 
 	// Настраиваем ожидаемое поведение репозитория с ошибкой
 	// mockRepo.On("GetByID", mock.Anything, uint(10)).Return(nil, fmt.Errorf("order not found")) // Этот мок не нужен здесь, так как GetByID для Order не вызывается, если GetByID для SagaState вернул ошибку
@@ -629,7 +772,7 @@ func TestHandleSagaResult_UpdateOrderError(t *testing.T) {
 	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	// testOrder := createTestOrder() // Не используется
 	sagaData := createTestSagaData()
@@ -645,12 +788,13 @@ func TestHandleSagaResult_UpdateOrderError(t *testing.T) {
 	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
 	// Настраиваем мок UpdateOrderStatus на возврат ошибки
 	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusCancelled).Return(fmt.Errorf("database update error")) // Ожидаем Canceled, т.к. это compensate/compensated
-	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
 	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
 		// Этот Update должен быть вызван до UpdateOrderStatus, чтобы пометить шаг компенсированным
 		_, compensated := state.CompensatedSteps["process_payment"].(bool)
 		return state.SagaID == sagaID && compensated && state.LastStep == "process_payment"
 	})).Return(nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{}, nil)
 	// PublishMessage не должен вызываться при ошибке UpdateOrderStatus
 	// mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_billing.compensate", mock.Anything).Return(nil)
 	// Delete не должен вызываться при ошибке
@@ -672,7 +816,7 @@ func TestHandleSagaResult_IgnoreDuplicateCompensated(t *testing.T) {
 	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	sagaData := createTestSagaData()
 	sagaID := "saga-order-10-123456789"
@@ -693,7 +837,7 @@ func TestHandleSagaResult_IgnoreDuplicateCompensated(t *testing.T) {
 		CompensatedSteps:  map[string]interface{}{"process_payment": true}, // Шаг уже помечен как компенсированный
 		TotalToCompensate: 1,                                               // Не важно для этого теста, но должно быть > 0
 	}
-	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(initialState, nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(initialState, nil)
 	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
 
 	err = orchestrator.HandleSagaResult(testMessage)
@@ -709,7 +853,7 @@ func TestHandleSagaResult_CompensateNotYetCompensated(t *testing.T) {
 	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	sagaID := "saga-order-10-123456789"
 	// Тестовое состояние, где шаг process_billing еще не компенсирован
@@ -724,7 +868,7 @@ func TestHandleSagaResult_CompensateNotYetCompensated(t *testing.T) {
 
 	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
 	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusCancelled).Return(nil)
-	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
 	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
 		_, billingComp := state.CompensatedSteps["process_billing"].(bool)
 		return state.SagaID == sagaID &&
@@ -733,6 +877,8 @@ func TestHandleSagaResult_CompensateNotYetCompensated(t *testing.T) {
 			len(state.CompensatedSteps) == 2 // Оба шага компенсированы
 	})).Return(nil)
 	mockStateRepo.On("Delete", mock.Anything, sagaID).Return(nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{}, nil)
+	mockRabbitMQ.On("PublishMessage", "order_events", "order.cancelled", mock.Anything).Return(nil)
 
 	// Моделируем приход сообщения compensate/compensated для process_billing
 	testMessage, err := createSagaMessage(sagaID, "process_billing", sagahandler.OperationCompensate, sagahandler.StatusCompensated, sagaData)
@@ -752,7 +898,7 @@ func TestHandleSagaResult_CompensateUnknownStep(t *testing.T) {
 	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	// testOrder := createTestOrder() // Не используется
 	sagaData := createTestSagaData()
@@ -764,12 +910,13 @@ func TestHandleSagaResult_CompensateUnknownStep(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Настраиваем ожидаемое поведение репозитория состояний
-	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
 	// Update ДОЛЖЕН вызываться, так как HandleSagaResult помечает шаг компенсированным
 	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
 		_, compensated := state.CompensatedSteps["unknown_step"].(bool)
 		return state.SagaID == sagaID && compensated && state.LastStep == "unknown_step"
 	})).Return(nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{}, nil)
 	// GetByID для Order ДОЛЖЕН вызываться в блоке compensate/compensated
 	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
 	// UpdateOrderStatus ДОЛЖЕН вызываться, так как статус заказа != Canceled
@@ -794,7 +941,7 @@ func TestHandleSagaResult_ConcurrentCompensate(t *testing.T) {
 	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
 
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	sagaID := "saga-order-10-123456789"
 	sagaData := createTestSagaData()
@@ -817,15 +964,17 @@ func TestHandleSagaResult_ConcurrentCompensate(t *testing.T) {
 
 	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
 	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusCancelled).Return(nil)
-	// Первый вызов GetByID вернет initial state
-	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(initialState, nil).Once()
+	// Первая блокировка состояния вернет initial state
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(initialState, nil).Once()
 	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
 		_, compensated := state.CompensatedSteps["process_billing"].(bool)
 		return state.SagaID == sagaID && state.Status == entity.SagaStatusCompensated && compensated
 	})).Return(nil).Once() // Первый Update
 	mockStateRepo.On("Delete", mock.Anything, sagaID).Return(nil).Once() // Ожидаем удаление после первого успешного Update
-	// Второй вызов GetByID вернет уже обновленное состояние (или то же самое, если гонка)
-	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(firstUpdateState, nil).Maybe() // Может быть вызван или нет, если гонка
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{}, nil)
+	// Следующие обработчики получают блокировку после первого и видят уже обновленное состояние
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(firstUpdateState, nil).Maybe()
+	mockRabbitMQ.On("PublishMessage", "order_events", "order.cancelled", mock.Anything).Return(nil)
 
 	// Создаем сообщение
 	testMessage, err := createSagaMessage(sagaID, "process_billing", sagahandler.OperationCompensate, sagahandler.StatusCompensated, sagaData)
@@ -860,7 +1009,7 @@ func TestHandleSagaResult_CompensateFailedFromService(t *testing.T) {
 	mockStateRepo := new(MockSagaStateRepository)
 	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
 	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
-	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, "saga_exchange", logger)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
 
 	sagaData := createTestSagaData()
 	sagaID := "saga-order-10-123456789"
@@ -881,7 +1030,7 @@ func TestHandleSagaResult_CompensateFailedFromService(t *testing.T) {
 	// GetByID вызывается в блоке compensate/failed перед UpdateOrderStatus
 	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
 	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusFailed).Return(nil)
-	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil).Once() // Первый GetByID
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil).Once() // Блокировка состояния при получении результата
 	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
 		// Ожидаем обновление статуса на Compensating и запись ошибки
 		return state.SagaID == sagaID &&
@@ -890,11 +1039,16 @@ func TestHandleSagaResult_CompensateFailedFromService(t *testing.T) {
 			state.LastStep == failedStep
 	})).Return(nil).Once()
 	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil).Once() // GetByID в startCompensationProcess
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{
+		{SagaID: sagaID, StepName: "process_billing", Status: entity.SagaStepStatusCompleted},
+		{SagaID: sagaID, StepName: "process_payment", Status: entity.SagaStepStatusCompleted},
+	}, nil)
 	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
 		// Ожидаем обновление TotalToCompensate
 		return state.SagaID == sagaID && state.TotalToCompensate == 1
 	})).Return(nil).Once()
 	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_billing.compensate", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "order_events", "order.failed", mock.Anything).Return(nil)
 
 	// Вызываем тестируемый метод
 	err = orchestrator.HandleSagaResult(testMessageBytes)
@@ -905,3 +1059,835 @@ func TestHandleSagaResult_CompensateFailedFromService(t *testing.T) {
 	mockStateRepo.AssertExpectations(t)
 	mockRabbitMQ.AssertExpectations(t)
 }
+
+// TestCheckExpiredSteps_StartsCompensation тестирует запуск компенсации для шага, не ответившего в срок
+func TestCheckExpiredSteps_StartsCompensation(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	payload, err := json.Marshal(createTestSagaData())
+	assert.NoError(t, err)
+	deadline := time.Now().Add(-time.Minute)
+	runningState := &entity.SagaState{
+		SagaID:           sagaID,
+		OrderID:          10,
+		Status:           entity.SagaStatusRunning,
+		CompensatedSteps: make(map[string]interface{}),
+		LastStep:         "reserve_delivery",
+	}
+	expiredStep := entity.SagaStepState{
+		SagaID:   sagaID,
+		StepName: "reserve_warehouse",
+		Status:   entity.SagaStepStatusRunning,
+		Deadline: &deadline,
+		Attempts: 1,
+		Payload:  payload,
+	}
+	// Состояние после MarkStepTimedOut, которое читает startCompensationProcess
+	timedOutState := &entity.SagaState{
+		SagaID:           sagaID,
+		OrderID:          10,
+		Status:           entity.SagaStatusCompensating,
+		CompensatedSteps: make(map[string]interface{}),
+		LastStep:         "reserve_warehouse",
+	}
+
+	mockStateRepo.On("FindExpiredSteps", mock.Anything, mock.Anything, mock.Anything).Return([]entity.SagaStepState{expiredStep}, nil)
+	mockStateRepo.On("MarkStepTimedOut", mock.Anything, sagaID, "reserve_warehouse", deadline, mock.Anything).Return(true, nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(runningState, nil).Once()
+	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(timedOutState, nil).Once()
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{
+		{SagaID: sagaID, StepName: "process_billing", Status: entity.SagaStepStatusCompleted},
+		{SagaID: sagaID, StepName: "process_payment", Status: entity.SagaStepStatusCompleted},
+		{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusTimedOut},
+		{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusCompleted},
+	}, nil)
+	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
+		// Зависший шаг компенсируется вместе с параллельной доставкой, process_billing и process_payment
+		return state.SagaID == sagaID && state.Status == entity.SagaStatusCompensating && state.TotalToCompensate == 4
+	})).Return(nil).Once()
+	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
+	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusFailed).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "order_events", "order.failed", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.reserve_warehouse.compensate", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.reserve_delivery.compensate", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_payment.compensate", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_billing.compensate", mock.Anything).Return(nil)
+
+	err = orchestrator.CheckExpiredSteps(context.Background())
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockStateRepo.AssertExpectations(t)
+	mockRabbitMQ.AssertExpectations(t)
+}
+
+// TestCheckExpiredSteps_RetriesStep тестирует повторную отправку шага, пока не исчерпаны повторы
+func TestCheckExpiredSteps_RetriesStep(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+	orchestrator.ConfigureStepTimeouts(StepTimeoutConfig{DefaultTimeout: time.Minute, MaxRetries: 1})
+
+	sagaID := "saga-order-10-123456789"
+	deadline := time.Now().Add(-time.Second)
+	expiredStep := entity.SagaStepState{
+		SagaID:   sagaID,
+		StepName: "reserve_delivery",
+		Status:   entity.SagaStepStatusRunning,
+		Deadline: &deadline,
+		Attempts: 1,
+		Payload:  []byte(`{"order_id":10}`),
+	}
+
+	mockStateRepo.On("FindExpiredSteps", mock.Anything, mock.Anything, mock.Anything).Return([]entity.SagaStepState{expiredStep}, nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(&entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning}, nil)
+	mockStateRepo.On("RescheduleExpiredStep", mock.Anything, sagaID, "reserve_delivery", deadline, mock.MatchedBy(func(next time.Time) bool {
+		return next.After(time.Now().Add(50 * time.Second))
+	})).Return(true, nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.reserve_delivery.execute", mock.Anything).Return(nil)
+
+	err := orchestrator.CheckExpiredSteps(context.Background())
+
+	assert.NoError(t, err)
+	mockStateRepo.AssertExpectations(t)
+	mockRabbitMQ.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "UpdateOrderStatus", mock.Anything, mock.Anything, mock.Anything)
+
+	msg, ok := mockRabbitMQ.PublishHistory[0].Message.(sagahandler.SagaMessage)
+	assert.True(t, ok)
+	assert.Equal(t, sagahandler.OperationExecute, msg.Operation)
+	assert.JSONEq(t, `{"order_id":10}`, string(msg.Data))
+}
+
+// TestHandleSagaResult_LateResultDuringCompensation тестирует, что результат шага после таймаута не продвигает сагу
+func TestHandleSagaResult_LateResultDuringCompensation(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	testSagaState := &entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusCompensating, CompensatedSteps: make(map[string]interface{})}
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	// Шаг уже помечен как timed_out и включен в компенсацию
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "reserve_warehouse", entity.SagaStepStatusCompleted, mock.Anything).Return(false, nil)
+
+	testMessage, err := createSagaMessage(sagaID, "reserve_warehouse", sagahandler.OperationExecute, sagahandler.StatusCompleted, createTestSagaData())
+	assert.NoError(t, err)
+
+	err = orchestrator.HandleSagaResult(testMessage)
+
+	assert.NoError(t, err)
+	mockStateRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+	assert.Equal(t, 0, len(mockRabbitMQ.PublishHistory))
+}
+
+// TestHandleSagaResult_ParallelFanOut тестирует одновременный запуск независимых шагов после платежа
+func TestHandleSagaResult_ParallelFanOut(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	testSagaState := &entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning}
+
+	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return(runningStep(sagaID, "process_payment"), nil)
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "process_payment", entity.SagaStepStatusCompleted, mock.Anything).Return(true, nil)
+	mockStateRepo.On("CreateStep", mock.Anything, mock.AnythingOfType("*entity.SagaStepState")).Return(true, nil)
+	mockStateRepo.On("Update", mock.Anything, mock.AnythingOfType("*entity.SagaState")).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.reserve_warehouse.execute", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.reserve_delivery.execute", mock.Anything).Return(nil)
+
+	testMessage, err := createSagaMessage(sagaID, "process_payment", sagahandler.OperationExecute, sagahandler.StatusCompleted, createTestSagaData())
+	assert.NoError(t, err)
+	assert.NoError(t, orchestrator.HandleSagaResult(testMessage))
+
+	mockRabbitMQ.AssertExpectations(t)
+	mockStateRepo.AssertNumberOfCalls(t, "CreateStep", 2)
+	assert.Equal(t, 2, len(mockRabbitMQ.PublishHistory))
+}
+
+// newOutboxOrchestrator создает оркестратор, публикующий сообщения через outbox поверх sqlmock
+func newOutboxOrchestrator(t *testing.T, orderRepo *MockOrderRepository, stateRepo *MockSagaStateRepository) (*SagaOrchestrator, sqlmock.Sqlmock) {
+	sqlDB, dbMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("не удалось создать sqlmock: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("не удалось открыть gorm: %v", err)
+	}
+
+	orchestrator := NewSagaOrchestrator(orderRepo, stateRepo, &MockRabbitMQ{}, new(MockUserRepository), "saga_exchange", "order_events", log.New(os.Stdout, "[TEST] ", log.LstdFlags))
+	orchestrator.UseOutbox(outbox.NewPublisher(db))
+	return orchestrator, dbMock
+}
+
+var insertOutboxSQL = regexp.QuoteMeta(`INSERT INTO "outbox_messages"`)
+
+// TestHandleSagaResult_OutboxSingleTransaction тестирует публикацию следующих шагов в транзакции обработки результата
+func TestHandleSagaResult_OutboxSingleTransaction(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	orchestrator, dbMock := newOutboxOrchestrator(t, mockRepo, mockStateRepo)
+
+	sagaID := "saga-order-10-123456789"
+	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(&entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning}, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return(runningStep(sagaID, "process_payment"), nil)
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "process_payment", entity.SagaStepStatusCompleted, mock.Anything).Return(true, nil)
+	mockStateRepo.On("CreateStep", mock.Anything, mock.AnythingOfType("*entity.SagaStepState")).Return(true, nil)
+	mockStateRepo.On("Update", mock.Anything, mock.AnythingOfType("*entity.SagaState")).Return(nil)
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(insertOutboxSQL).WithArgs("saga_exchange", "saga.reserve_warehouse.execute", sqlmock.AnyArg(), outbox.StatusPending, 0, "", nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	dbMock.ExpectQuery(insertOutboxSQL).WithArgs("saga_exchange", "saga.reserve_delivery.execute", sqlmock.AnyArg(), outbox.StatusPending, 0, "", nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	dbMock.ExpectCommit()
+
+	testMessage, err := createSagaMessage(sagaID, "process_payment", sagahandler.OperationExecute, sagahandler.StatusCompleted, createTestSagaData())
+	assert.NoError(t, err)
+	assert.NoError(t, orchestrator.HandleSagaResult(testMessage))
+
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+	mockStateRepo.AssertNumberOfCalls(t, "CreateStep", 2)
+}
+
+// TestHandleSagaResult_OutboxErrorRollsBack тестирует откат фиксации результата шага при ошибке публикации следующего шага:
+// сообщение с результатом будет доставлено повторно, и сага не останется без следующего шага
+func TestHandleSagaResult_OutboxErrorRollsBack(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	orchestrator, dbMock := newOutboxOrchestrator(t, mockRepo, mockStateRepo)
+
+	sagaID := "saga-order-10-123456789"
+	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
+	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusFailed).Return(nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(&entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning}, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return(runningStep(sagaID, "process_payment"), nil)
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "process_payment", entity.SagaStepStatusCompleted, mock.Anything).Return(true, nil)
+	mockStateRepo.On("CreateStep", mock.Anything, mock.AnythingOfType("*entity.SagaStepState")).Return(true, nil)
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "reserve_warehouse", entity.SagaStepStatusFailed, mock.Anything).Return(true, nil)
+	mockStateRepo.On("Update", mock.Anything, mock.AnythingOfType("*entity.SagaState")).Return(nil)
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(insertOutboxSQL).WillReturnError(fmt.Errorf("connection reset"))
+	dbMock.ExpectRollback()
+
+	testMessage, err := createSagaMessage(sagaID, "process_payment", sagahandler.OperationExecute, sagahandler.StatusCompleted, createTestSagaData())
+	assert.NoError(t, err)
+	assert.Error(t, orchestrator.HandleSagaResult(testMessage))
+
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+// TestHandleSagaResult_ParallelJoin тестирует ожидание всех параллельных веток и объединение их данных
+func TestHandleSagaResult_ParallelJoin(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	testSagaState := &entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning}
+
+	warehouseData := createTestSagaData()
+	warehouseData.WarehouseInfo = &sagahandler.WarehouseInfo{ReservationID: "res-1", Status: "reserved"}
+	warehouseResult, err := json.Marshal(warehouseData)
+	assert.NoError(t, err)
+
+	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("Update", mock.Anything, mock.AnythingOfType("*entity.SagaState")).Return(nil)
+
+	// 1. Склад завершился, доставка еще выполняется: следующий шаг не отправляется
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{
+		{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusRunning},
+		{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusRunning},
+	}, nil).Once()
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "reserve_warehouse", entity.SagaStepStatusCompleted, mock.Anything).Return(true, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{
+		{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusCompleted, Result: warehouseResult},
+		{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusRunning},
+	}, nil).Once()
+
+	testMessage, err := createSagaMessage(sagaID, "reserve_warehouse", sagahandler.OperationExecute, sagahandler.StatusCompleted, warehouseData)
+	assert.NoError(t, err)
+	assert.NoError(t, orchestrator.HandleSagaResult(testMessage))
+	assert.Equal(t, 0, len(mockRabbitMQ.PublishHistory))
+
+	// 2. Доставка завершилась: capture_billing и capture_payment получают данные обеих веток
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{
+		{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusCompleted, Result: warehouseResult},
+		{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusRunning},
+	}, nil).Once()
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "reserve_delivery", entity.SagaStepStatusCompleted, mock.Anything).Return(true, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{
+		{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusCompleted, Result: warehouseResult},
+		{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusCompleted},
+	}, nil).Once()
+	mockStateRepo.On("CreateStep", mock.Anything, mock.MatchedBy(func(step *entity.SagaStepState) bool {
+		return step.StepName == "capture_billing" || step.StepName == "capture_payment"
+	})).Return(true, nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.capture_billing.execute", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.capture_payment.execute", mock.Anything).Return(nil)
+
+	deliveryData := createTestSagaData()
+	deliveryData.DeliveryInfo = &sagahandler.DeliveryInfo{DeliveryID: "del-1", Status: "scheduled"}
+	testMessage, err = createSagaMessage(sagaID, "reserve_delivery", sagahandler.OperationExecute, sagahandler.StatusCompleted, deliveryData)
+	assert.NoError(t, err)
+	assert.NoError(t, orchestrator.HandleSagaResult(testMessage))
+
+	mockRabbitMQ.AssertExpectations(t)
+	assert.Equal(t, 2, len(mockRabbitMQ.PublishHistory))
+	for _, published := range mockRabbitMQ.PublishHistory {
+		msg, ok := published.Message.(sagahandler.SagaMessage)
+		assert.True(t, ok)
+		joinedData, err := sagahandler.ParseSagaData(msg)
+		assert.NoError(t, err)
+		if assert.NotNil(t, joinedData.WarehouseInfo) && assert.NotNil(t, joinedData.DeliveryInfo) {
+			assert.Equal(t, "res-1", joinedData.WarehouseInfo.ReservationID)
+			assert.Equal(t, "del-1", joinedData.DeliveryInfo.DeliveryID)
+		}
+	}
+}
+
+// TestHandleSagaResult_ParallelJoinConcurrentBranches тестирует объединение веток, результаты которых обработаны
+// одновременно (например, разными экземплярами сервиса): каждая ветка прочитала другую выполняющейся, но после
+// фиксации своего результата видит обе завершенными, поэтому следующий шаг не теряется
+func TestHandleSagaResult_ParallelJoinConcurrentBranches(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	testSagaState := &entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning}
+
+	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("Update", mock.Anything, mock.AnythingOfType("*entity.SagaState")).Return(nil)
+
+	// Результат доставки зафиксирован параллельно между чтением шагов и фиксацией результата склада
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{
+		{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusRunning},
+		{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusRunning},
+	}, nil).Once()
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "reserve_warehouse", entity.SagaStepStatusCompleted, mock.Anything).Return(true, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return([]entity.SagaStepState{
+		{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusCompleted},
+		{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusCompleted},
+	}, nil).Once()
+	mockStateRepo.On("CreateStep", mock.Anything, mock.MatchedBy(func(step *entity.SagaStepState) bool {
+		return step.StepName == "capture_billing"
+	})).Return(true, nil).Once()
+	mockStateRepo.On("CreateStep", mock.Anything, mock.MatchedBy(func(step *entity.SagaStepState) bool {
+		return step.StepName == "capture_payment"
+	})).Return(true, nil).Once()
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.capture_billing.execute", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.capture_payment.execute", mock.Anything).Return(nil)
+
+	testMessage, err := createSagaMessage(sagaID, "reserve_warehouse", sagahandler.OperationExecute, sagahandler.StatusCompleted, createTestSagaData())
+	assert.NoError(t, err)
+	assert.NoError(t, orchestrator.HandleSagaResult(testMessage))
+
+	mockStateRepo.AssertExpectations(t)
+	mockRabbitMQ.AssertExpectations(t)
+	assert.Equal(t, 2, len(mockRabbitMQ.PublishHistory))
+}
+
+// sagaStateStore хранит состояние саги как запись в базе данных: чтение возвращает копию последнего
+// сохраненного состояния, поэтому изменения, не сохраненные обработчиком, не видны другим обработчикам
+type sagaStateStore struct {
+	mu    sync.Mutex
+	state entity.SagaState
+}
+
+func (s *sagaStateStore) load() *entity.SagaState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.state
+	state.CompensatedSteps = make(datatypes.JSONMap, len(s.state.CompensatedSteps))
+	for step, compensated := range s.state.CompensatedSteps {
+		state.CompensatedSteps[step] = compensated
+	}
+	return &state
+}
+
+func (s *sagaStateStore) save(args mock.Arguments) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = *args.Get(1).(*entity.SagaState)
+}
+
+// TestHandleSagaResult_BranchSuccessAfterSiblingFailure тестирует результат параллельной ветки, обработанный
+// после сбоя соседней ветки: обработчик читает состояние саги под блокировкой, поэтому видит начатую компенсацию
+// и компенсирует свою ветку, не затирая статус, ошибку и количество шагов к компенсации
+func TestHandleSagaResult_BranchSuccessAfterSiblingFailure(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	store := &sagaStateStore{state: entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning}}
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(store.load, nil)
+	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(store.load, nil)
+	mockStateRepo.On("Update", mock.Anything, mock.AnythingOfType("*entity.SagaState")).Run(store.save).Return(nil)
+	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
+	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusFailed).Return(nil)
+	mockRabbitMQ.On("PublishMessage", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	// 1. Склад завершился ошибкой, пока доставка выполняется: компенсируются платеж и биллинг
+	completedSteps := []entity.SagaStepState{
+		{SagaID: sagaID, StepName: "process_billing", Status: entity.SagaStepStatusCompleted},
+		{SagaID: sagaID, StepName: "process_payment", Status: entity.SagaStepStatusCompleted},
+	}
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return(append(completedSteps,
+		entity.SagaStepState{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusRunning},
+		entity.SagaStepState{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusRunning},
+	), nil).Once()
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "reserve_warehouse", entity.SagaStepStatusFailed, mock.Anything).Return(true, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return(append(completedSteps,
+		entity.SagaStepState{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusFailed},
+		entity.SagaStepState{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusRunning},
+	), nil).Twice()
+
+	failure := sagahandler.SagaMessage{SagaID: sagaID, StepName: "reserve_warehouse", Operation: sagahandler.OperationExecute,
+		Status: sagahandler.StatusFailed, Error: "нет товара на складе", Data: json.RawMessage(`{"order_id":10}`)}
+	failureMessage, err := json.Marshal(failure)
+	assert.NoError(t, err)
+	assert.NoError(t, orchestrator.HandleSagaResult(failureMessage))
+
+	// 2. Доставка завершилась успешно после сбоя склада
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "reserve_delivery", entity.SagaStepStatusCompleted, mock.Anything).Return(true, nil)
+	successMessage, err := createSagaMessage(sagaID, "reserve_delivery", sagahandler.OperationExecute, sagahandler.StatusCompleted, createTestSagaData())
+	assert.NoError(t, err)
+	assert.NoError(t, orchestrator.HandleSagaResult(successMessage))
+
+	state := store.load()
+	assert.Equal(t, entity.SagaStatusCompensating, state.Status)
+	assert.Equal(t, "нет товара на складе", state.ErrorMessage)
+	assert.Equal(t, 3, state.TotalToCompensate)
+	mockStateRepo.AssertNotCalled(t, "Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
+		return state.Status == entity.SagaStatusRunning
+	}))
+
+	var routingKeys []string
+	for _, published := range mockRabbitMQ.PublishHistory {
+		routingKeys = append(routingKeys, published.RoutingKey)
+	}
+	assert.ElementsMatch(t, []string{"order.failed", "saga.process_payment.compensate", "saga.process_billing.compensate", "saga.reserve_delivery.compensate"}, routingKeys)
+}
+
+// TestHandleSagaResult_LateBranchCompensated тестирует компенсацию параллельной ветки, завершившейся после сбоя соседней
+func TestHandleSagaResult_LateBranchCompensated(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	// Склад завершился ошибкой, компенсация billing и payment уже запущена
+	testSagaState := &entity.SagaState{
+		SagaID:            sagaID,
+		OrderID:           10,
+		Status:            entity.SagaStatusCompensating,
+		CompensatedSteps:  make(map[string]interface{}),
+		TotalToCompensate: 2,
+	}
+
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("CompleteStep", mock.Anything, sagaID, "reserve_delivery", entity.SagaStepStatusCompleted, mock.Anything).Return(true, nil)
+	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
+		return state.Status == entity.SagaStatusCompensating && state.TotalToCompensate == 3
+	})).Return(nil).Once()
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.reserve_delivery.compensate", mock.Anything).Return(nil)
+
+	testMessage, err := createSagaMessage(sagaID, "reserve_delivery", sagahandler.OperationExecute, sagahandler.StatusCompleted, createTestSagaData())
+	assert.NoError(t, err)
+	assert.NoError(t, orchestrator.HandleSagaResult(testMessage))
+
+	mockStateRepo.AssertExpectations(t)
+	mockRabbitMQ.AssertExpectations(t)
+	assert.Equal(t, 1, len(mockRabbitMQ.PublishHistory))
+}
+
+// TestHandleSagaResult_IncompatibleSchemaVersion тестирует отклонение результата несовместимой версии контракта
+func TestHandleSagaResult_IncompatibleSchemaVersion(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	testMessage, err := createSagaMessage("saga-order-10-123456789", "process_billing", sagahandler.OperationExecute, sagahandler.StatusCompleted, createTestSagaData())
+	assert.NoError(t, err)
+	var message sagahandler.SagaMessage
+	assert.NoError(t, json.Unmarshal(testMessage, &message))
+	message.Version = sagahandler.SchemaVersion + 1
+	testMessage, _ = json.Marshal(message)
+
+	err = orchestrator.HandleSagaResult(testMessage)
+
+	assert.ErrorIs(t, err, sagahandler.ErrIncompatibleSchema)
+	mockStateRepo.AssertNotCalled(t, "GetByID", mock.Anything, mock.Anything)
+}
+
+// TestParseSagaData_LegacyBillingTransactionID тестирует чтение числового transaction_id из сообщений без версии
+func TestParseSagaData_LegacyBillingTransactionID(t *testing.T) {
+	message := sagahandler.SagaMessage{
+		SagaID: "saga-order-10-123456789",
+		Data:   json.RawMessage(`{"order_id":10,"billing_info":{"transaction_id":42,"amount":200,"status":"completed"}}`),
+	}
+
+	sagaData, err := sagahandler.ParseSagaData(message)
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint(10), sagaData.OrderID)
+	assert.Equal(t, "42", sagaData.BillingInfo.TransactionID)
+	assert.Equal(t, money.FromMinor(20000), sagaData.BillingInfo.Amount)
+}
+
+// TestCancelOrder_CompensatesCompletedSteps тестирует отмену заказа клиентом во время резервирования:
+// завершенные списание и платеж компенсируются с данными их результатов, заказ переходит в cancelling
+func TestCancelOrder_CompensatesCompletedSteps(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	testSagaState := &entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning, CompensatedSteps: make(map[string]interface{})}
+
+	payload, err := json.Marshal(createTestSagaData())
+	assert.NoError(t, err)
+	billingData := createTestSagaData()
+	billingData.BillingInfo = &sagahandler.BillingInfo{TransactionID: "42", Amount: money.FromMinor(20000), Status: "completed"}
+	billingResult, err := json.Marshal(billingData)
+	assert.NoError(t, err)
+	steps := []entity.SagaStepState{
+		{SagaID: sagaID, StepName: "process_billing", Status: entity.SagaStepStatusCompleted, Payload: payload, Result: billingResult},
+		{SagaID: sagaID, StepName: "process_payment", Status: entity.SagaStepStatusCompleted},
+		{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusRunning},
+		{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusCompleted},
+	}
+
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(10)).Return(testSagaState, nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return(steps, nil)
+	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusCancelling).Return(nil).Once()
+	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
+		return state.Status == entity.SagaStatusCompensating
+	})).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.reserve_delivery.compensate", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_payment.compensate", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_billing.compensate", mock.Anything).Return(nil)
+
+	err = orchestrator.CancelOrder(context.Background(), createTestOrder(), "передумал")
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRabbitMQ.AssertExpectations(t)
+	assert.Equal(t, 3, len(mockRabbitMQ.PublishHistory))
+	assert.Equal(t, 3, testSagaState.TotalToCompensate)
+	assert.Contains(t, testSagaState.ErrorMessage, "передумал")
+
+	// Компенсация биллинга получает идентификатор транзакции списания для возврата средств
+	for _, published := range mockRabbitMQ.PublishHistory {
+		if published.RoutingKey != "saga.process_billing.compensate" {
+			continue
+		}
+		msg, ok := published.Message.(sagahandler.SagaMessage)
+		assert.True(t, ok)
+		data, err := sagahandler.ParseSagaData(msg)
+		assert.NoError(t, err)
+		if assert.NotNil(t, data.BillingInfo) {
+			assert.Equal(t, "42", data.BillingInfo.TransactionID)
+		}
+	}
+}
+
+// TestCancelOrder_DeliveryStarted тестирует запрет отмены после передачи заказа в доставку
+func TestCancelOrder_DeliveryStarted(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	testSagaState := &entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning}
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(10)).Return(testSagaState, nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return(runningStep(sagaID, "confirm_order"), nil)
+
+	err := orchestrator.CancelOrder(context.Background(), createTestOrder(), "")
+
+	assert.ErrorIs(t, err, ErrOrderNotCancellable)
+	mockStateRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "UpdateOrderStatus", mock.Anything, mock.Anything, mock.Anything)
+	assert.Equal(t, 0, len(mockRabbitMQ.PublishHistory))
+}
+
+// TestCancelOrder_RechecksUnderLock тестирует повторную проверку саги под блокировкой: результат шага,
+// обработанный между чтением саги и ее блокировкой, не затирается отменой
+func TestCancelOrder_RechecksUnderLock(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	// Склад завершился ошибкой после чтения саги: сага уже компенсируется
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(10)).Return(&entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning}, nil).Once()
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(&entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusCompensating, ErrorMessage: "нет товара на складе"}, nil).Once()
+	// Сага завершилась и была удалена после чтения
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(11)).Return(&entity.SagaState{SagaID: "saga-order-11", OrderID: 11, Status: entity.SagaStatusRunning}, nil).Once()
+	mockStateRepo.On("LockByID", mock.Anything, "saga-order-11").Return(nil, gorm.ErrRecordNotFound).Once()
+
+	err := orchestrator.CancelOrder(context.Background(), createTestOrder(), "")
+	assert.ErrorIs(t, err, ErrOrderNotCancellable)
+
+	order := createTestOrder()
+	order.ID = 11
+	err = orchestrator.CancelOrder(context.Background(), order, "")
+	assert.ErrorIs(t, err, ErrOrderNotCancellable)
+
+	mockStateRepo.AssertExpectations(t)
+	mockStateRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "UpdateOrderStatus", mock.Anything, mock.Anything, mock.Anything)
+	assert.Equal(t, 0, len(mockRabbitMQ.PublishHistory))
+}
+
+// TestCancelOrder_NotCancellableStatus тестирует отказ в отмене завершенного заказа
+func TestCancelOrder_NotCancellableStatus(t *testing.T) {
+	mockStateRepo := new(MockSagaStateRepository)
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(new(MockOrderRepository), mockStateRepo, &MockRabbitMQ{}, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	order := createTestOrder()
+	order.Status = entity.OrderStatusCompleted
+
+	err := orchestrator.CancelOrder(context.Background(), order, "")
+
+	assert.ErrorIs(t, err, ErrOrderNotCancellable)
+	mockStateRepo.AssertNotCalled(t, "GetByOrderID", mock.Anything, mock.Anything)
+}
+
+// TestHandleReservationExpired_CompensatesSaga тестирует завершение саги ошибкой при истечении резервирования склада
+func TestHandleReservationExpired_CompensatesSaga(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	testSagaState := &entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning, CompensatedSteps: make(map[string]interface{})}
+	payload, err := json.Marshal(createTestSagaData())
+	assert.NoError(t, err)
+	steps := []entity.SagaStepState{
+		{SagaID: sagaID, StepName: "process_billing", Status: entity.SagaStepStatusCompleted, Payload: payload},
+		{SagaID: sagaID, StepName: "process_payment", Status: entity.SagaStepStatusCompleted},
+		{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusCompleted},
+		{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusCompleted},
+	}
+
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(10)).Return(testSagaState, nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil).Once()
+	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return(steps, nil)
+	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
+		return state.Status == entity.SagaStatusCompensating
+	})).Return(nil)
+	mockRepo.On("GetByID", mock.Anything, uint(10)).Return(createTestOrder(), nil)
+	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusFailed).Return(nil).Once()
+	mockRabbitMQ.On("PublishMessage", "order_events", "order.failed", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.reserve_delivery.compensate", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_payment.compensate", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_billing.compensate", mock.Anything).Return(nil)
+
+	err = orchestrator.HandleReservationExpired(context.Background(), 10)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRabbitMQ.AssertExpectations(t)
+	// Резерв склада уже снят складом и не компенсируется
+	assert.Equal(t, 3, testSagaState.TotalToCompensate)
+	for _, published := range mockRabbitMQ.PublishHistory {
+		assert.NotEqual(t, "saga.reserve_warehouse.compensate", published.RoutingKey)
+	}
+}
+
+// TestHandleReservationExpired_IgnoresFinishedSaga тестирует, что истечение резервирования не меняет неактивную сагу
+func TestHandleReservationExpired_IgnoresFinishedSaga(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(10)).Return(&entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusCompensating}, nil).Once()
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(11)).Return(nil, gorm.ErrRecordNotFound).Once()
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(12)).Return(&entity.SagaState{SagaID: sagaID, OrderID: 12, Status: entity.SagaStatusRunning}, nil).Once()
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(&entity.SagaState{SagaID: sagaID, OrderID: 12, Status: entity.SagaStatusRunning}, nil).Once()
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return(runningStep(sagaID, "confirm_order"), nil)
+
+	assert.NoError(t, orchestrator.HandleReservationExpired(context.Background(), 10))
+	assert.NoError(t, orchestrator.HandleReservationExpired(context.Background(), 11))
+	assert.NoError(t, orchestrator.HandleReservationExpired(context.Background(), 12))
+
+	mockStateRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "UpdateOrderStatus", mock.Anything, mock.Anything, mock.Anything)
+	assert.Equal(t, 0, len(mockRabbitMQ.PublishHistory))
+}
+
+// TestHandleReservationExpired_RechecksUnderLock тестирует, что сага, перешедшая в компенсацию между чтением
+// и блокировкой ее записи (например, после сбоя параллельного шага), не компенсируется повторно
+func TestHandleReservationExpired_RechecksUnderLock(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(10)).Return(&entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning}, nil).Once()
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(&entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusCompensating}, nil).Once()
+	// Сага завершилась и удалена до блокировки
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(11)).Return(&entity.SagaState{SagaID: "saga-order-11", OrderID: 11, Status: entity.SagaStatusRunning}, nil).Once()
+	mockStateRepo.On("LockByID", mock.Anything, "saga-order-11").Return(nil, gorm.ErrRecordNotFound).Once()
+
+	assert.NoError(t, orchestrator.HandleReservationExpired(context.Background(), 10))
+	assert.NoError(t, orchestrator.HandleReservationExpired(context.Background(), 11))
+
+	mockStateRepo.AssertExpectations(t)
+	mockStateRepo.AssertNotCalled(t, "GetSteps", mock.Anything, mock.Anything)
+	mockStateRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "UpdateOrderStatus", mock.Anything, mock.Anything, mock.Anything)
+	assert.Equal(t, 0, len(mockRabbitMQ.PublishHistory))
+}
+
+// TestHandleBackorderScheduled_ExtendsStepDeadline тестирует ожидание поступления товара: срок шага
+// reserve_warehouse переносится на ожидаемую дату поступления плюс backorderTimeout, заказ переходит в backordered,
+// а блокировка средств продлевается на время ожидания
+func TestHandleBackorderScheduled_ExtendsStepDeadline(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+	orchestrator.ConfigureStepTimeouts(StepTimeoutConfig{BackorderTimeout: 48 * time.Hour})
+
+	sagaID := "saga-order-10-123456789"
+	expectedAt := time.Now().Add(72 * time.Hour)
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(10)).Return(&entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning}, nil)
+	mockStateRepo.On("ExtendStepDeadline", mock.Anything, sagaID, "reserve_warehouse", expectedAt.Add(48*time.Hour)).Return(true, nil).Once()
+	mockRepo.On("UpdateBackorderStatus", mock.Anything, uint(10), &expectedAt).Return(nil).Once()
+	mockRabbitMQ.On("PublishMessage", "order_events", "order.backordered", mock.Anything).Return(nil).Once()
+
+	err := orchestrator.HandleBackorderScheduled(context.Background(), 10, &expectedAt)
+
+	assert.NoError(t, err)
+	mockStateRepo.AssertExpectations(t)
+	mockRepo.AssertExpectations(t)
+	// Платежный сервис продлевает блокировку средств до конца ожидания резерва склада с запасом на остальные шаги
+	if assert.Len(t, mockRabbitMQ.PublishHistory, 1) {
+		payload, ok := mockRabbitMQ.PublishHistory[0].Message.(OrderBackorderedPayload)
+		if assert.True(t, ok) {
+			assert.Equal(t, uint(10), payload.OrderID)
+			assert.Equal(t, &expectedAt, payload.ExpectedAvailableAt)
+			assert.Equal(t, expectedAt.Add(48*time.Hour+backorderHoldMargin), payload.HoldUntil)
+		}
+	}
+}
+
+// TestHandleBackorderScheduled_StepFinished тестирует, что заказ не переходит в backordered,
+// если склад уже ответил на шаг reserve_warehouse
+func TestHandleBackorderScheduled_StepFinished(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, &MockRabbitMQ{}, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(10)).Return(&entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning}, nil).Once()
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(11)).Return(&entity.SagaState{SagaID: sagaID, OrderID: 11, Status: entity.SagaStatusCompensating}, nil).Once()
+	mockStateRepo.On("ExtendStepDeadline", mock.Anything, sagaID, "reserve_warehouse", mock.Anything).Return(false, nil).Once()
+
+	assert.NoError(t, orchestrator.HandleBackorderScheduled(context.Background(), 10, nil))
+	assert.NoError(t, orchestrator.HandleBackorderScheduled(context.Background(), 11, nil))
+
+	mockStateRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "UpdateBackorderStatus", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestCancelOrder_BackorderedCompensatesWarehouse тестирует отмену заказа под поступление: ожидание резерва
+// склада прекращается, и reserve_warehouse компенсируется вместе с завершенными шагами, чтобы склад убрал заказ из очереди
+func TestCancelOrder_BackorderedCompensatesWarehouse(t *testing.T) {
+	mockRepo := new(MockOrderRepository)
+	mockStateRepo := new(MockSagaStateRepository)
+	mockRabbitMQ := &MockRabbitMQ{PublishHistory: []PublishData{}}
+	logger := log.New(os.Stdout, "[TEST] ", log.LstdFlags)
+	orchestrator := NewSagaOrchestrator(mockRepo, mockStateRepo, mockRabbitMQ, new(MockUserRepository), "saga_exchange", "order_events", logger)
+
+	sagaID := "saga-order-10-123456789"
+	testSagaState := &entity.SagaState{SagaID: sagaID, OrderID: 10, Status: entity.SagaStatusRunning, CompensatedSteps: make(map[string]interface{})}
+	payload, err := json.Marshal(createTestSagaData())
+	assert.NoError(t, err)
+	deadline := time.Now().Add(7 * 24 * time.Hour)
+	steps := []entity.SagaStepState{
+		{SagaID: sagaID, StepName: "process_billing", Status: entity.SagaStepStatusCompleted, Payload: payload},
+		{SagaID: sagaID, StepName: "process_payment", Status: entity.SagaStepStatusCompleted},
+		{SagaID: sagaID, StepName: "reserve_warehouse", Status: entity.SagaStepStatusRunning, Deadline: &deadline},
+		{SagaID: sagaID, StepName: "reserve_delivery", Status: entity.SagaStepStatusCompleted},
+	}
+
+	mockStateRepo.On("GetByOrderID", mock.Anything, uint(10)).Return(testSagaState, nil)
+	mockStateRepo.On("LockByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("GetByID", mock.Anything, sagaID).Return(testSagaState, nil)
+	mockStateRepo.On("GetSteps", mock.Anything, sagaID).Return(steps, nil)
+	mockRepo.On("UpdateOrderStatus", mock.Anything, uint(10), entity.OrderStatusCancelling).Return(nil).Once()
+	mockStateRepo.On("Update", mock.Anything, mock.MatchedBy(func(state *entity.SagaState) bool {
+		return state.Status == entity.SagaStatusCompensating
+	})).Return(nil)
+	mockStateRepo.On("MarkStepTimedOut", mock.Anything, sagaID, "reserve_warehouse", deadline, mock.Anything).Return(true, nil).Once()
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.reserve_warehouse.compensate", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.reserve_delivery.compensate", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_payment.compensate", mock.Anything).Return(nil)
+	mockRabbitMQ.On("PublishMessage", "saga_exchange", "saga.process_billing.compensate", mock.Anything).Return(nil)
+
+	order := createTestOrder()
+	order.Status = entity.OrderStatusBackordered
+	err = orchestrator.CancelOrder(context.Background(), order, "")
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockStateRepo.AssertExpectations(t)
+	mockRabbitMQ.AssertExpectations(t)
+	assert.Equal(t, 4, testSagaState.TotalToCompensate)
+}
@@ -0,0 +1,87 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"gorm.io/gorm"
+)
+
+// HandleReservationExpired обрабатывает истечение резервирования товаров заказа на складе.
+// Склад уже вернул товары в остаток, поэтому выполняющаяся сага заказа завершается ошибкой:
+// заказ переходит в статус failed, а завершенные шаги (списание, платеж, резерв доставки) компенсируются.
+// Резерв склада не компенсируется - его больше нет. Саги, которые уже компенсируются, завершены или
+// дошли до подтверждения заказа, не меняются. Статус саги и ее шаги перепроверяются под блокировкой
+// записи саги, так как параллельно может обрабатываться результат шага или отмена заказа.
+func (s *SagaOrchestrator) HandleReservationExpired(ctx context.Context, orderID uint) error {
+	state, err := s.sagaStateRepo.GetByOrderID(ctx, orderID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.logger.Printf("[WARN] OrderID=%d: Резервирование склада истекло, но сага заказа не найдена. Игнорируется.", orderID)
+			return nil
+		}
+		return err
+	}
+	if state.Status != entity.SagaStatusRunning {
+		s.logger.Printf("SagaID=%s: Резервирование склада заказа %d истекло, сага в статусе %s. Игнорируется.", state.SagaID, orderID, state.Status)
+		return nil
+	}
+
+	return s.inTransaction(ctx, func(ctx context.Context) error {
+		sagaID := state.SagaID
+		state, err := s.sagaStateRepo.LockByID(ctx, sagaID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				s.logger.Printf("SagaID=%s: Резервирование склада заказа %d истекло, сага уже завершена. Игнорируется.", sagaID, orderID)
+				return nil
+			}
+			return err
+		}
+		if state.Status != entity.SagaStatusRunning {
+			s.logger.Printf("SagaID=%s: Резервирование склада заказа %d истекло, сага в статусе %s. Игнорируется.", sagaID, orderID, state.Status)
+			return nil
+		}
+
+		def, err := s.definitionFor(state)
+		if err != nil {
+			return err
+		}
+		steps, err := s.sagaStateRepo.GetSteps(ctx, sagaID)
+		if err != nil {
+			return err
+		}
+		_, started, _ := stepProgress(def, steps)
+		if blocker := def.CancellationBlockedBy(started); blocker != "" {
+			s.logger.Printf("[ERROR] SagaID=%s: Резервирование склада заказа %d истекло после запуска шага %s. Требуется ручная проверка.", sagaID, orderID, blocker)
+			return nil
+		}
+
+		sagaData := s.cancellationData(sagaID, steps)
+		userID := sagaData.UserID
+		if order, oErr := s.orderRepo.GetByID(ctx, orderID); oErr != nil {
+			s.logger.Printf("[ERROR] SagaID=%s: Ошибка получения заказа %d при истечении резервирования: %v", sagaID, orderID, oErr)
+		} else {
+			userID = order.UserID
+		}
+
+		errorMessage := "Истек срок резервирования товаров на складе"
+		s.logger.Printf("[WARN] SagaID=%s: %s. Запуск компенсации заказа %d.", sagaID, errorMessage, orderID)
+
+		if err := s.orderRepo.UpdateOrderStatus(ctx, orderID, entity.OrderStatusFailed); err != nil {
+			return fmt.Errorf("ошибка обновления статуса заказа %d: %w", orderID, err)
+		}
+
+		state.Status = entity.SagaStatusCompensating
+		state.ErrorMessage = errorMessage
+		if err := s.sagaStateRepo.Update(ctx, state); err != nil {
+			return fmt.Errorf("не удалось обновить состояние саги %s: %w", sagaID, err)
+		}
+		if err := s.publishCancellationEvent(ctx, orderID, userID, "order.failed", errorMessage); err != nil {
+			return err
+		}
+
+		return s.startCompensationProcess(ctx, sagaID, "reserve_warehouse", sagaData, convertJSONMapToBoolMap(state.CompensatedSteps), false)
+	})
+}
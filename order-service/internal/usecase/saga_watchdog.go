@@ -0,0 +1,155 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/director74/dz8_shop/order-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/sagahandler"
+	"gorm.io/datatypes"
+)
+
+// RunStepWatchdog периодически ищет саги, шаг которых не ответил в срок.
+// Блокирует вызывающую горутину до отмены ctx.
+func (s *SagaOrchestrator) RunStepWatchdog(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	s.logger.Printf("[INFO] Watchdog шагов саги запущен (интервал %s, повторов шага по умолчанию %d)", interval, s.maxStepRetries)
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Printf("[INFO] Watchdog шагов саги остановлен")
+			return
+		case <-ticker.C:
+			if err := s.CheckExpiredSteps(ctx); err != nil {
+				s.logger.Printf("[ERROR] Watchdog: %v", err)
+			}
+		}
+	}
+}
+
+// CheckExpiredSteps обрабатывает шаги саг с истекшим сроком ожидания результата:
+// повторно отправляет шаг, пока не исчерпаны повторы, после чего запускает компенсацию.
+// Параллельный шаг саги, которая уже компенсируется, не повторяется, а сразу компенсируется.
+// Каждый шаг обрабатывается в отдельной транзакции под блокировкой записи саги.
+func (s *SagaOrchestrator) CheckExpiredSteps(ctx context.Context) error {
+	now := time.Now()
+	steps, err := s.sagaStateRepo.FindExpiredSteps(ctx, now, watchdogBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for i := range steps {
+		step := &steps[i]
+		if step.Deadline == nil {
+			continue
+		}
+		err = s.inTransaction(ctx, func(ctx context.Context) error {
+			return s.handleExpiredStep(ctx, step, now)
+		})
+		if err != nil {
+			s.logger.Printf("[ERROR] SagaID=%s: Ошибка обработки таймаута шага %s: %v", step.SagaID, step.StepName, err)
+		}
+	}
+	return nil
+}
+
+// handleExpiredStep повторяет или компенсирует шаг с истекшим сроком в транзакции из ctx
+func (s *SagaOrchestrator) handleExpiredStep(ctx context.Context, step *entity.SagaStepState, now time.Time) error {
+	state, err := s.sagaStateRepo.LockByID(ctx, step.SagaID)
+	if err != nil {
+		return fmt.Errorf("ошибка получения состояния саги: %w", err)
+	}
+	def, err := s.definitionFor(state)
+	if err != nil {
+		return err
+	}
+	if state.Status == entity.SagaStatusRunning && step.Attempts <= s.stepMaxRetries(def, step.StepName) {
+		return s.retryExpiredStep(ctx, def, step, now)
+	}
+	return s.compensateExpiredStep(ctx, def, state, step)
+}
+
+// retryExpiredStep повторно отправляет шаг с теми же данными и продлевает срок ожидания
+func (s *SagaOrchestrator) retryExpiredStep(ctx context.Context, def *SagaDefinition, step *entity.SagaStepState, now time.Time) error {
+	nextDeadline := now.Add(s.stepTimeout(def, step.StepName))
+	claimed, err := s.sagaStateRepo.RescheduleExpiredStep(ctx, step.SagaID, step.StepName, *step.Deadline, nextDeadline)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		s.logger.Printf("SagaID=%s: Шаг %s изменился во время проверки таймаута, повтор не требуется.", step.SagaID, step.StepName)
+		return nil
+	}
+
+	message := sagahandler.SagaMessage{
+		SagaID:    step.SagaID,
+		StepName:  step.StepName,
+		Operation: sagahandler.OperationExecute,
+		Status:    sagahandler.StatusPending,
+		Data:      json.RawMessage(step.Payload),
+		Timestamp: sagahandler.GetTimestamp(),
+		Version:   sagahandler.SchemaVersion,
+	}
+	routingKey := "saga." + step.StepName + ".execute"
+	if err := s.publisher(ctx).PublishMessage(s.sagaExchange, routingKey, message); err != nil {
+		// Продление срока откатывается вместе с транзакцией, при следующей проверке шаг будет обработан снова
+		return fmt.Errorf("ошибка повторной публикации шага %s: %w", step.StepName, err)
+	}
+
+	s.logger.Printf("[WARN] SagaID=%s: Шаг %s не ответил в срок, отправлен повторно (попытка %d из %d).",
+		step.SagaID, step.StepName, step.Attempts+1, s.stepMaxRetries(def, step.StepName)+1)
+	return nil
+}
+
+// compensateExpiredStep фиксирует таймаут шага и запускает компенсацию.
+// Результат зависшего шага неизвестен, поэтому он компенсируется вместе с завершенными шагами.
+func (s *SagaOrchestrator) compensateExpiredStep(ctx context.Context, def *SagaDefinition, state *entity.SagaState, step *entity.SagaStepState) error {
+	stepName := step.StepName
+	errorMessage := fmt.Sprintf("Превышено время ожидания результата шага %s (попыток: %d)", stepName, step.Attempts)
+
+	claimed, err := s.sagaStateRepo.MarkStepTimedOut(ctx, state.SagaID, stepName, *step.Deadline, errorMessage)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		s.logger.Printf("SagaID=%s: Шаг %s изменился во время проверки таймаута, компенсация не требуется.", state.SagaID, stepName)
+		return nil
+	}
+	s.logger.Printf("[WARN] SagaID=%s: %s. Запуск компенсации.", state.SagaID, errorMessage)
+
+	var sagaData sagahandler.SagaData
+	if len(step.Payload) > 0 {
+		if err := json.Unmarshal(step.Payload, &sagaData); err != nil {
+			s.logger.Printf("[WARN] SagaID=%s: Не удалось десериализовать данные шага %s: %v. Компенсация будет запущена без них.", state.SagaID, stepName, err)
+		}
+	}
+
+	if state.Status == entity.SagaStatusCompensating {
+		// Сага уже компенсируется из-за сбоя параллельной ветки
+		if state.CompensatedSteps == nil {
+			state.CompensatedSteps = make(datatypes.JSONMap)
+		}
+		return s.handleLateStep(ctx, def, state, stepName, true, sagaData)
+	}
+
+	userID := sagaData.UserID
+	order, err := s.orderRepo.GetByID(ctx, state.OrderID)
+	if err != nil {
+		s.logger.Printf("[ERROR] SagaID=%s: Ошибка получения заказа %d при таймауте шага %s: %v", state.SagaID, state.OrderID, stepName, err)
+	} else {
+		userID = order.UserID
+		if err := s.orderRepo.UpdateOrderStatus(ctx, order.ID, entity.OrderStatusFailed); err != nil {
+			s.logger.Printf("[ERROR] SagaID=%s: Ошибка при обновлении статуса заказа %d на Failed: %v", state.SagaID, order.ID, err)
+		}
+	}
+	if err := s.publishCancellationEvent(ctx, state.OrderID, userID, "order.failed", errorMessage); err != nil {
+		return err
+	}
+
+	return s.startCompensationProcess(ctx, state.SagaID, stepName, sagaData, convertJSONMapToBoolMap(state.CompensatedSteps), true)
+}
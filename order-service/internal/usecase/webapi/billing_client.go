@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/director74/dz8_shop/pkg/money"
 )
 
 // BillingClient представляет HTTP клиент для работы с сервисом биллинга
@@ -57,7 +59,7 @@ func (c *BillingClient) CreateAccount(ctx context.Context, userID uint) error {
 }
 
 // WithdrawMoney снимает деньги с аккаунта в сервисе биллинга
-func (c *BillingClient) WithdrawMoney(ctx context.Context, userID uint, amount float64, email string, token string) (bool, error) {
+func (c *BillingClient) WithdrawMoney(ctx context.Context, userID uint, amount money.Amount, email string, token string) (bool, error) {
 	url := fmt.Sprintf("%s/api/v1/billing/withdraw", c.baseURL)
 
 	reqBody := map[string]interface{}{
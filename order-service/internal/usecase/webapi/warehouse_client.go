@@ -0,0 +1,104 @@
+package webapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/director74/dz8_shop/order-service/internal/entity"
+	pkgMiddleware "github.com/director74/dz8_shop/pkg/middleware"
+)
+
+// WarehouseClient представляет HTTP клиент для работы с внутренним API сервиса склада
+type WarehouseClient struct {
+	baseURL    string
+	apiKey     string
+	headerName string
+	httpClient *http.Client
+}
+
+// NewWarehouseClient создает клиент склада. Запросы подписываются ключом внутреннего API
+// из переменной окружения, заданной в конфигурации внутреннего API по умолчанию.
+func NewWarehouseClient(baseURL string) *WarehouseClient {
+	internalCfg := pkgMiddleware.NewInternalAPIConfig()
+	apiKey := os.Getenv(internalCfg.APIKeyEnvName)
+	if apiKey == "" {
+		apiKey = internalCfg.DefaultAPIKey
+	}
+
+	return &WarehouseClient{
+		baseURL:    baseURL,
+		apiKey:     apiKey,
+		headerName: internalCfg.HeaderName,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+// GetProduct возвращает товар каталога склада по ID продукта или nil, если товар не найден
+func (c *WarehouseClient) GetProduct(ctx context.Context, productID uint) (*entity.CatalogProduct, error) {
+	url := fmt.Sprintf("%s/internal/warehouse/product/%d", c.baseURL, productID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при создании запроса: %w", err)
+	}
+	req.Header.Set(c.headerName, c.apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при выполнении запроса: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("неуспешный ответ от сервиса склада: %s", resp.Status)
+	}
+
+	var product entity.CatalogProduct
+	if err := json.NewDecoder(resp.Body).Decode(&product); err != nil {
+		return nil, fmt.Errorf("ошибка при декодировании ответа: %w", err)
+	}
+	return &product, nil
+}
+
+// CheckAvailability проверяет наличие товаров на складе в запрошенном количестве
+func (c *WarehouseClient) CheckAvailability(ctx context.Context, items []entity.StockRequestItem) (*entity.StockAvailability, error) {
+	url := fmt.Sprintf("%s/internal/warehouse/check", c.baseURL)
+
+	body, err := json.Marshal(map[string]interface{}{"items": items})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при сериализации запроса: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при создании запроса: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(c.headerName, c.apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при выполнении запроса: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("неуспешный ответ от сервиса склада: %s", resp.Status)
+	}
+
+	var availability entity.StockAvailability
+	if err := json.NewDecoder(resp.Body).Decode(&availability); err != nil {
+		return nil, fmt.Errorf("ошибка при декодировании ответа: %w", err)
+	}
+	return &availability, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/director74/dz8_shop/payment-service/internal/usecase/gateway"
+	"github.com/director74/dz8_shop/pkg/config"
+)
+
+// Заглушка платежного шлюза для локальной разработки: PAYMENT_GATEWAY=http и
+// PAYMENT_GATEWAY_URL=http://localhost:8090 направляют платежный сервис на нее
+func main() {
+	port := config.GetEnv("GATEWAY_STUB_PORT", "8090")
+
+	// Сценарии отказов и таймаутов задаются так же, как для встроенного фейкового шлюза
+	rules, err := gateway.ParseFakeRules(config.GetEnv("PAYMENT_GATEWAY_FAKE_RULES", ""))
+	if err != nil {
+		log.Fatalf("Ошибка разбора правил заглушки: %v", err)
+	}
+	fake := gateway.NewFakeGateway(append(rules, gateway.DefaultFakeRules()...)...)
+
+	log.Printf("Заглушка платежного шлюза запущена на порту %s", port)
+	if err := http.ListenAndServe(fmt.Sprintf(":%s", port), gateway.NewStubHandler(fake)); err != nil {
+		log.Fatalf("Ошибка запуска заглушки платежного шлюза: %v", err)
+	}
+}
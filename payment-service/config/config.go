@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/director74/dz8_shop/pkg/config"
 )
 
@@ -11,6 +13,19 @@ type Config struct {
 	RabbitMQ config.RabbitMQConfig
 	JWT      config.JWTConfig
 	Internal InternalAPIConfig
+	Outbox   config.OutboxConfig
+	Gateway  GatewayConfig
+}
+
+// GatewayConfig настройки платежного шлюза
+type GatewayConfig struct {
+	Provider  string        // fake - детерминированный шлюз в памяти процесса, http - внешний шлюз или заглушка по URL
+	URL       string        // адрес шлюза для провайдера http
+	Timeout   time.Duration // таймаут запросов к шлюзу
+	FakeRules string        // сценарии отказов и таймаутов фейкового шлюза, формат см. gateway.ParseFakeRules
+
+	AuthorizationTTL            time.Duration // срок списания суммы, авторизованной в саге заказа
+	AuthorizationExpiryInterval time.Duration // интервал проверки просроченных авторизаций
 }
 
 // InternalAPIConfig конфигурация для внутреннего API
@@ -38,6 +53,16 @@ func NewConfig() (*Config, error) {
 		RabbitMQ: commonConfig.RabbitMQ,
 		JWT:      *jwtConfig,
 		Internal: internalConfig,
+		Outbox:   *config.LoadOutboxConfig(),
+		Gateway: GatewayConfig{
+			Provider:  config.GetEnv("PAYMENT_GATEWAY", "fake"),
+			URL:       config.GetEnv("PAYMENT_GATEWAY_URL", "http://localhost:8090"),
+			Timeout:   config.GetEnvAsDuration("PAYMENT_GATEWAY_TIMEOUT", 10*time.Second),
+			FakeRules: config.GetEnv("PAYMENT_GATEWAY_FAKE_RULES", ""),
+
+			AuthorizationTTL:            config.GetEnvAsDuration("PAYMENT_AUTHORIZATION_TTL", 24*time.Hour),
+			AuthorizationExpiryInterval: config.GetEnvAsDuration("PAYMENT_AUTHORIZATION_EXPIRY_INTERVAL", 5*time.Minute),
+		},
 	}, nil
 }
 
@@ -16,13 +16,17 @@ import (
 	"github.com/director74/dz8_shop/payment-service/internal/entity"
 	"github.com/director74/dz8_shop/payment-service/internal/repo"
 	"github.com/director74/dz8_shop/payment-service/internal/usecase"
+	"github.com/director74/dz8_shop/payment-service/internal/usecase/gateway"
 	"github.com/director74/dz8_shop/pkg/auth"
 	"github.com/director74/dz8_shop/pkg/database"
 	"github.com/director74/dz8_shop/pkg/errors"
 	"github.com/director74/dz8_shop/pkg/messaging"
+	pkgMiddleware "github.com/director74/dz8_shop/pkg/middleware"
+	"github.com/director74/dz8_shop/pkg/outbox"
 
 	// nolint:typecheck
 	"github.com/director74/dz8_shop/pkg/rabbitmq"
+	"github.com/director74/dz8_shop/pkg/sagahandler"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -35,6 +39,9 @@ type App struct {
 	rabbitMQ messaging.MessageBroker
 	router   *gin.Engine
 	server   *http.Server
+
+	stopRelay  context.CancelFunc
+	stopExpiry context.CancelFunc
 }
 
 // NewApp создает новое приложение с указанной конфигурацией
@@ -50,7 +57,7 @@ func NewApp(cfg *config.Config) (*App, error) {
 	}
 
 	// Автомиграция моделей
-	if err := database.AutoMigrateWithCleanup(db, &entity.Payment{}, &entity.PaymentMethod{}); err != nil {
+	if err := database.AutoMigrateWithCleanup(db, &entity.Payment{}, &entity.PaymentMethod{}, &entity.Refund{}, &outbox.Message{}, &sagahandler.ProcessedMessage{}, &pkgMiddleware.IdempotencyRecord{}); err != nil {
 		return nil, errors.AppendPrefix(err, "не удалось выполнить миграцию")
 	}
 
@@ -96,12 +103,6 @@ func NewApp(cfg *config.Config) (*App, error) {
 	// Создание репозитория платежей
 	paymentRepo := repo.NewPaymentRepository(db)
 
-	// Создание use case платежей
-	paymentUseCase := usecase.NewPaymentUseCase(paymentRepo, rmq, "payment_events")
-
-	// Создание обработчика HTTP запросов
-	paymentHandler := httpController.NewPaymentHandler(paymentUseCase, cfg)
-
 	// Проверяем, что RabbitMQ имеет правильный тип
 	rawRMQ, ok := rmq.(*rabbitmq.RabbitMQ)
 	if !ok {
@@ -110,17 +111,39 @@ func NewApp(cfg *config.Config) (*App, error) {
 		return nil, fmt.Errorf("неожиданный тип для RabbitMQ: %T", rmq)
 	}
 
+	// Создание платежного шлюза
+	paymentGateway, err := newPaymentGateway(cfg.Gateway)
+	if err != nil {
+		database.CloseDB(db)
+		rmq.Close()
+		return nil, errors.AppendPrefix(err, "ошибка настройки платежного шлюза")
+	}
+
+	// Создание use case платежей: события платежей сохраняются в outbox и отправляются relay
+	paymentUseCase := usecase.NewPaymentUseCase(paymentRepo, paymentGateway, outbox.NewPublisher(db), "payment_events")
+	paymentUseCase.UseAuthorizationTTL(cfg.Gateway.AuthorizationTTL)
+
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	go outbox.NewRelay(db, rawRMQ, cfg.Outbox, nil).Run(relayCtx)
+
+	// Создание обработчика HTTP запросов
+	paymentHandler := httpController.NewPaymentHandler(paymentUseCase, cfg, pkgMiddleware.NewIdempotencyMiddleware(db))
+
 	// Создание обработчика сообщений RabbitMQ
 	paymentConsumer := rmqController.NewPaymentConsumer(paymentUseCase, rawRMQ)
 
-	// Создание обработчика сообщений саги
-	sagaConsumer := rmqController.NewSagaConsumer(paymentUseCase, rawRMQ)
+	// Создание обработчиков сообщений саги: авторизация платежа и его списание - разные шаги саги
+	sagaLedger := sagahandler.NewLedger(db)
+	sagaConsumer := rmqController.NewSagaConsumer(paymentUseCase, rawRMQ, sagaLedger)
+	captureConsumer := rmqController.NewCaptureConsumer(paymentUseCase, rawRMQ, sagaLedger)
 
 	// Регистрация маршрутов
 	paymentHandler.RegisterRoutes(router, authMiddleware.AuthRequired())
+	messaging.RegisterDeadLetterAdmin(router, rawRMQ)
 
 	// Настройка обработки сообщений RabbitMQ
 	if err := paymentConsumer.Setup(); err != nil {
+		stopRelay()
 		database.CloseDB(db)
 		rmq.Close()
 		return nil, errors.AppendPrefix(err, "ошибка настройки обработчика сообщений")
@@ -128,11 +151,24 @@ func NewApp(cfg *config.Config) (*App, error) {
 
 	// Настройка обработки сообщений саги
 	if err := sagaConsumer.Setup(); err != nil {
+		stopRelay()
 		database.CloseDB(db)
 		rmq.Close()
 		return nil, errors.AppendPrefix(err, "ошибка настройки обработчика сообщений саги")
 	}
 
+	// Списание авторизованного платежа на шаге capture_payment
+	if err := captureConsumer.Setup(); err != nil {
+		stopRelay()
+		database.CloseDB(db)
+		rmq.Close()
+		return nil, errors.AppendPrefix(err, "ошибка настройки обработчика списания платежей")
+	}
+
+	// Снятие блокировок с авторизаций, которые не были списаны в срок
+	expiryCtx, stopExpiry := context.WithCancel(context.Background())
+	go paymentUseCase.RunAuthorizationExpiry(expiryCtx, cfg.Gateway.AuthorizationExpiryInterval)
+
 	// Настройка HTTP сервера
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%s", cfg.HTTP.Port),
@@ -145,6 +181,9 @@ func NewApp(cfg *config.Config) (*App, error) {
 		rabbitMQ: rmq,
 		router:   router,
 		server:   server,
+
+		stopRelay:  stopRelay,
+		stopExpiry: stopExpiry,
 	}, nil
 }
 
@@ -174,6 +213,16 @@ func (a *App) Run() error {
 		log.Printf("ошибка остановки HTTP сервера: %v", err)
 	}
 
+	// Остановка проверки просроченных авторизаций
+	if a.stopExpiry != nil {
+		a.stopExpiry()
+	}
+
+	// Остановка relay outbox до закрытия соединения с RabbitMQ
+	if a.stopRelay != nil {
+		a.stopRelay()
+	}
+
 	// Закрытие соединения с RabbitMQ
 	if err := a.rabbitMQ.Close(); err != nil {
 		log.Printf("ошибка закрытия соединения с RabbitMQ: %v", err)
@@ -197,3 +246,21 @@ func (a *App) Healthcheck() error {
 
 	return nil
 }
+
+// newPaymentGateway создает платежный шлюз по настройкам: фейковый шлюз в памяти процесса или HTTP адаптер
+func newPaymentGateway(cfg config.GatewayConfig) (usecase.PaymentGateway, error) {
+	switch cfg.Provider {
+	case "fake":
+		rules, err := gateway.ParseFakeRules(cfg.FakeRules)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Используется фейковый платежный шлюз, дополнительных правил: %d", len(rules))
+		return gateway.NewFakeGateway(append(rules, gateway.DefaultFakeRules()...)...), nil
+	case "http":
+		log.Printf("Используется платежный шлюз %s", cfg.URL)
+		return gateway.NewHTTPGateway(cfg.URL, cfg.Timeout), nil
+	default:
+		return nil, fmt.Errorf("неизвестный провайдер платежного шлюза: %q", cfg.Provider)
+	}
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -16,13 +17,15 @@ import (
 type PaymentHandler struct {
 	paymentUseCase *usecase.PaymentUseCase
 	config         *config.Config
+	idempotency    *pkgMiddleware.IdempotencyMiddleware
 }
 
 // NewPaymentHandler создает новый обработчик платежей
-func NewPaymentHandler(paymentUseCase *usecase.PaymentUseCase, cfg *config.Config) *PaymentHandler {
+func NewPaymentHandler(paymentUseCase *usecase.PaymentUseCase, cfg *config.Config, idempotency *pkgMiddleware.IdempotencyMiddleware) *PaymentHandler {
 	return &PaymentHandler{
 		paymentUseCase: paymentUseCase,
 		config:         cfg,
+		idempotency:    idempotency,
 	}
 }
 
@@ -49,7 +52,7 @@ func (h *PaymentHandler) ProcessPayment(c *gin.Context) {
 
 	confirmation, err := h.paymentUseCase.ProcessPayment(&req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(processErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -94,15 +97,18 @@ func (h *PaymentHandler) GetPayment(c *gin.Context) {
 	}
 
 	response := entity.GetPaymentResponse{
-		ID:            payment.ID,
-		OrderID:       payment.OrderID,
-		UserID:        payment.UserID,
-		Amount:        payment.Amount,
-		PaymentMethod: payment.PaymentMethod,
-		Status:        payment.Status,
-		TransactionID: payment.TransactionID,
-		CreatedAt:     payment.CreatedAt,
-		UpdatedAt:     payment.UpdatedAt,
+		ID:                     payment.ID,
+		OrderID:                payment.OrderID,
+		UserID:                 payment.UserID,
+		Amount:                 payment.Amount,
+		Currency:               payment.Currency.OrDefault(),
+		RefundedAmount:         payment.RefundedAmount,
+		PaymentMethod:          payment.PaymentMethod,
+		Status:                 payment.Status,
+		TransactionID:          payment.TransactionID,
+		AuthorizationExpiresAt: payment.AuthorizationExpiresAt,
+		CreatedAt:              payment.CreatedAt,
+		UpdatedAt:              payment.UpdatedAt,
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -129,15 +135,18 @@ func (h *PaymentHandler) GetPaymentByOrderID(c *gin.Context) {
 	}
 
 	response := entity.GetPaymentResponse{
-		ID:            payment.ID,
-		OrderID:       payment.OrderID,
-		UserID:        payment.UserID,
-		Amount:        payment.Amount,
-		PaymentMethod: payment.PaymentMethod,
-		Status:        payment.Status,
-		TransactionID: payment.TransactionID,
-		CreatedAt:     payment.CreatedAt,
-		UpdatedAt:     payment.UpdatedAt,
+		ID:                     payment.ID,
+		OrderID:                payment.OrderID,
+		UserID:                 payment.UserID,
+		Amount:                 payment.Amount,
+		Currency:               payment.Currency.OrDefault(),
+		RefundedAmount:         payment.RefundedAmount,
+		PaymentMethod:          payment.PaymentMethod,
+		Status:                 payment.Status,
+		TransactionID:          payment.TransactionID,
+		AuthorizationExpiresAt: payment.AuthorizationExpiresAt,
+		CreatedAt:              payment.CreatedAt,
+		UpdatedAt:              payment.UpdatedAt,
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -169,15 +178,18 @@ func (h *PaymentHandler) GetUserPayments(c *gin.Context) {
 	var paymentResponses []entity.GetPaymentResponse
 	for _, payment := range payments {
 		paymentResponses = append(paymentResponses, entity.GetPaymentResponse{
-			ID:            payment.ID,
-			OrderID:       payment.OrderID,
-			UserID:        payment.UserID,
-			Amount:        payment.Amount,
-			PaymentMethod: payment.PaymentMethod,
-			Status:        payment.Status,
-			TransactionID: payment.TransactionID,
-			CreatedAt:     payment.CreatedAt,
-			UpdatedAt:     payment.UpdatedAt,
+			ID:                     payment.ID,
+			OrderID:                payment.OrderID,
+			UserID:                 payment.UserID,
+			Amount:                 payment.Amount,
+			Currency:               payment.Currency.OrDefault(),
+			RefundedAmount:         payment.RefundedAmount,
+			PaymentMethod:          payment.PaymentMethod,
+			Status:                 payment.Status,
+			TransactionID:          payment.TransactionID,
+			AuthorizationExpiresAt: payment.AuthorizationExpiresAt,
+			CreatedAt:              payment.CreatedAt,
+			UpdatedAt:              payment.UpdatedAt,
 		})
 	}
 
@@ -200,7 +212,7 @@ func (h *PaymentHandler) InternalProcessPayment(c *gin.Context) {
 
 	confirmation, err := h.paymentUseCase.ProcessPayment(&req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(processErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -227,15 +239,18 @@ func (h *PaymentHandler) InternalGetPaymentByOrderID(c *gin.Context) {
 	}
 
 	response := entity.GetPaymentResponse{
-		ID:            payment.ID,
-		OrderID:       payment.OrderID,
-		UserID:        payment.UserID,
-		Amount:        payment.Amount,
-		PaymentMethod: payment.PaymentMethod,
-		Status:        payment.Status,
-		TransactionID: payment.TransactionID,
-		CreatedAt:     payment.CreatedAt,
-		UpdatedAt:     payment.UpdatedAt,
+		ID:                     payment.ID,
+		OrderID:                payment.OrderID,
+		UserID:                 payment.UserID,
+		Amount:                 payment.Amount,
+		Currency:               payment.Currency.OrDefault(),
+		RefundedAmount:         payment.RefundedAmount,
+		PaymentMethod:          payment.PaymentMethod,
+		Status:                 payment.Status,
+		TransactionID:          payment.TransactionID,
+		AuthorizationExpiresAt: payment.AuthorizationExpiresAt,
+		CreatedAt:              payment.CreatedAt,
+		UpdatedAt:              payment.UpdatedAt,
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -258,6 +273,70 @@ func (h *PaymentHandler) InternalCancelPayment(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "платеж успешно отменен"})
 }
 
+// InternalCreateRefund выполняет возврат по платежу (для внутренних сервисов и службы поддержки).
+// Клиентам возврат не доступен: его оформляет служба поддержки после возврата товара.
+func (h *PaymentHandler) InternalCreateRefund(c *gin.Context) {
+	paymentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный ID платежа"})
+		return
+	}
+
+	var req entity.CreateRefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	refund, err := h.paymentUseCase.CreateRefund(c.Request.Context(), uint(paymentID), req)
+	if err != nil {
+		c.JSON(refundErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, refund)
+}
+
+// ListRefunds возвращает возвраты по платежу текущего пользователя
+func (h *PaymentHandler) ListRefunds(c *gin.Context) {
+	paymentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный ID платежа"})
+		return
+	}
+
+	userID := auth.GetUserID(c)
+	if userID == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "доступ запрещен"})
+		return
+	}
+
+	refunds, err := h.paymentUseCase.ListRefunds(uint(paymentID), userID)
+	if err != nil {
+		c.JSON(refundErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, refunds)
+}
+
+// InternalGetGatewayStatus возвращает состояние транзакции платежа в платежном шлюзе (для внутренних сервисов и службы поддержки)
+func (h *PaymentHandler) InternalGetGatewayStatus(c *gin.Context) {
+	paymentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный ID платежа"})
+		return
+	}
+
+	status, err := h.paymentUseCase.GatewayStatus(c.Request.Context(), uint(paymentID))
+	if err != nil {
+		c.JSON(refundErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
 // RegisterRoutes регистрирует маршруты для платежей
 func (h *PaymentHandler) RegisterRoutes(router *gin.Engine, authMiddleware gin.HandlerFunc) {
 	// Добавляем эндпоинт для проверки работоспособности сервиса
@@ -267,10 +346,13 @@ func (h *PaymentHandler) RegisterRoutes(router *gin.Engine, authMiddleware gin.H
 	payments := router.Group("/api/v1/payments")
 	{
 		payments.GET("/:id", authMiddleware, h.GetPayment)
-		payments.POST("/process", authMiddleware, h.ProcessPayment)
+		payments.POST("/process", authMiddleware, h.idempotency.Handler(), h.ProcessPayment)
 		payments.POST("/:id/cancel", authMiddleware, h.CancelPayment)
 		payments.GET("/by-order/:order_id", authMiddleware, h.GetPaymentByOrderID)
 		payments.GET("/by-customer/:user_id", authMiddleware, h.GetUserPayments)
+
+		// Возвраты по платежу клиент только просматривает, проводит их служба поддержки через внутренний API
+		payments.GET("/:id/refunds", authMiddleware, h.ListRefunds)
 	}
 
 	// Внутренние API маршруты (с проверкой доступа для внутренних сервисов)
@@ -290,6 +372,40 @@ func (h *PaymentHandler) RegisterRoutes(router *gin.Engine, authMiddleware gin.H
 			internalPayments.POST("/process", h.InternalProcessPayment)
 			internalPayments.POST("/:id/cancel", h.InternalCancelPayment)
 			internalPayments.GET("/by-order/:order_id", h.InternalGetPaymentByOrderID)
+			// Возвраты: частичные, пока их сумма не достигнет суммы платежа, или полный возврат остатка
+			internalPayments.POST("/:id/refunds", h.idempotency.Handler(), h.InternalCreateRefund)
+			internalPayments.GET("/:id/gateway", h.InternalGetGatewayStatus)
 		}
 	}
 }
+
+// processErrorStatus возвращает HTTP статус для ошибки обработки платежа:
+// некорректная или не совпадающая с заказом валюта - 422, остальные ошибки - 500
+func processErrorStatus(err error) int {
+	if errors.Is(err, usecase.ErrCurrencyMismatch) || errors.Is(err, usecase.ErrInvalidCurrency) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// refundErrorStatus возвращает HTTP статус для ошибки возврата по платежу или запроса к платежному шлюзу
+func refundErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, usecase.ErrPaymentNotFound), errors.Is(err, entity.ErrGatewayTransactionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, usecase.ErrPaymentAccessDenied):
+		return http.StatusForbidden
+	case errors.Is(err, usecase.ErrPaymentNotRefundable):
+		return http.StatusConflict
+	case errors.Is(err, usecase.ErrRefundExceedsPayment):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, entity.ErrGatewayDeclined):
+		return http.StatusPaymentRequired
+	case errors.Is(err, entity.ErrGatewayTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, entity.ErrGatewayInvalidState):
+		return http.StatusBadGateway
+	default:
+		return processErrorStatus(err)
+	}
+}
@@ -0,0 +1,198 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/director74/dz8_shop/payment-service/internal/entity"
+	"github.com/director74/dz8_shop/payment-service/internal/usecase"
+	"github.com/director74/dz8_shop/pkg/rabbitmq"
+	"github.com/director74/dz8_shop/pkg/sagahandler"
+)
+
+// CaptureConsumer обработчик шага capture_payment: списывает сумму, авторизованную на шаге process_payment,
+// после резервирования товаров и доставки, а при компенсации возвращает списанный платеж
+type CaptureConsumer struct {
+	sagahandler.BaseSagaConsumer
+	paymentUseCase usecase.PaymentUseCaseInterface
+}
+
+// NewCaptureConsumer создает обработчик шага списания авторизованного платежа
+func NewCaptureConsumer(paymentUseCase usecase.PaymentUseCaseInterface, rabbitMQ *rabbitmq.RabbitMQ, ledger *sagahandler.Ledger) *CaptureConsumer {
+	return &CaptureConsumer{
+		BaseSagaConsumer: sagahandler.BaseSagaConsumer{
+			RabbitMQ: rabbitMQ,
+			Logger:   log.New(log.Writer(), "[PaymentService] [Saga] ", log.LstdFlags),
+			Step:     "capture_payment",
+			Ledger:   ledger,
+		},
+		paymentUseCase: paymentUseCase,
+	}
+}
+
+// Setup настраивает обработчик событий саги
+func (c *CaptureConsumer) Setup() error {
+	return c.SetupQueues(
+		"saga_exchange",                    // exchangeName
+		"payment_capture_execute_queue",    // executeQueueName
+		"payment_capture_compensate_queue", // compensateQueueName
+		c.handleCapturePayment,             // handleExecute
+		c.handleCompensateCapture,          // handleCompensate
+	)
+}
+
+// handleCapturePayment списывает авторизованный платеж заказа.
+// Если платеж нельзя списать (авторизация отменена, истекла или отклонена шлюзом), шаг завершается ошибкой
+// и сага компенсируется. Таймаут шлюза и прочие ошибки возвращаются, чтобы сообщение было доставлено повторно.
+func (c *CaptureConsumer) handleCapturePayment(data []byte) error {
+	message, err := sagahandler.ParseSagaMessage(data)
+	if err != nil {
+		c.Logger.Printf("[ERROR] Ошибка парсинга сообщения execute: %v", err)
+		return err
+	}
+
+	c.Logger.Printf("SagaID=%s: Получено сообщение execute для шага %s", message.SagaID, message.StepName)
+
+	var sagaData sagahandler.SagaData
+	if err := json.Unmarshal(message.Data, &sagaData); err != nil {
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка десериализации данных: %v", message.SagaID, err)
+		return c.PublishFailureResult(message.SagaID,
+			fmt.Sprintf("ошибка десериализации данных заказа: %v", err))
+	}
+
+	paymentID, err := c.paymentID(sagaData)
+	if err != nil {
+		c.Logger.Printf("[ERROR] SagaID=%s: %v", message.SagaID, err)
+		return err
+	}
+	if paymentID == 0 {
+		c.Logger.Printf("[ERROR] SagaID=%s: Не найден платеж для списания по заказу %d", message.SagaID, sagaData.OrderID)
+		return c.PublishFailureResultWithData(message.SagaID,
+			fmt.Sprintf("не найден платеж для списания по заказу %d", sagaData.OrderID), message.Data)
+	}
+
+	payment, err := c.paymentUseCase.CapturePayment(context.Background(), paymentID)
+	if err != nil {
+		if isCaptureRejected(err) {
+			c.Logger.Printf("[ERROR] SagaID=%s: Платеж PaymentID=%d не может быть списан: %v", message.SagaID, paymentID, err)
+			return c.PublishFailureResultWithData(message.SagaID,
+				fmt.Sprintf("ошибка списания платежа: %v", err), message.Data)
+		}
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка списания платежа PaymentID=%d: %v", message.SagaID, paymentID, err)
+		return err
+	}
+
+	c.Logger.Printf("SagaID=%s: Платеж PaymentID=%d для OrderID=%d списан, статус %s",
+		message.SagaID, payment.ID, payment.OrderID, payment.Status)
+
+	if sagaData.PaymentInfo == nil {
+		sagaData.PaymentInfo = &sagahandler.PaymentInfo{}
+	}
+	sagaData.PaymentInfo.PaymentID = fmt.Sprintf("%d", payment.ID)
+	sagaData.PaymentInfo.Amount = payment.Amount
+	sagaData.PaymentInfo.Currency = payment.Currency
+	sagaData.PaymentInfo.Status = string(payment.Status)
+	sagaData.PaymentInfo.TransactionID = payment.TransactionID
+	sagaData.Status = "payment_captured"
+
+	updatedData, err := json.Marshal(sagaData)
+	if err != nil {
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка сериализации данных после списания платежа: %v", message.SagaID, err)
+		return c.PublishFailureResult(message.SagaID,
+			fmt.Sprintf("ошибка сериализации обновленных данных: %v", err))
+	}
+
+	c.Logger.Printf("SagaID=%s: Отправка успешного результата шага %s", message.SagaID, c.Step)
+	return c.PublishSuccessResult(message.SagaID, updatedData)
+}
+
+// handleCompensateCapture возвращает платеж, списанный на шаге capture_payment.
+// Повторная компенсация уже возвращенного платежа ничего не меняет.
+func (c *CaptureConsumer) handleCompensateCapture(data []byte) error {
+	message, err := sagahandler.ParseSagaMessage(data)
+	if err != nil {
+		c.Logger.Printf("[ERROR] Ошибка парсинга сообщения compensate: %v", err)
+		return err
+	}
+
+	c.Logger.Printf("SagaID=%s: Получено сообщение compensate для шага %s", message.SagaID, message.StepName)
+
+	var sagaData sagahandler.SagaData
+	if err := json.Unmarshal(message.Data, &sagaData); err != nil {
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка десериализации данных компенсации: %v", message.SagaID, err)
+		return err
+	}
+
+	paymentID, err := c.paymentID(sagaData)
+	if err != nil {
+		c.Logger.Printf("[ERROR] SagaID=%s: %v", message.SagaID, err)
+		return err
+	}
+
+	if paymentID == 0 {
+		c.Logger.Printf("[WARN] SagaID=%s: Нет данных о списанном платеже для компенсации (OrderID=%d). Считаем компенсацию выполненной.",
+			message.SagaID, sagaData.OrderID)
+	} else {
+		status, err := c.paymentUseCase.RefundPayment(context.Background(), &entity.RefundPaymentRequest{
+			PaymentID: paymentID,
+			Amount:    sagaData.Amount,
+			Currency:  sagaData.Currency,
+		})
+		if err != nil {
+			c.Logger.Printf("[ERROR] SagaID=%s: Ошибка возврата платежа PaymentID=%d: %v", message.SagaID, paymentID, err)
+			return err
+		}
+		c.Logger.Printf("SagaID=%s: Платеж PaymentID=%d возвращен (компенсация списания), статус %s", message.SagaID, paymentID, status)
+
+		if sagaData.PaymentInfo == nil {
+			sagaData.PaymentInfo = &sagahandler.PaymentInfo{}
+		}
+		sagaData.PaymentInfo.Status = strings.ToUpper(string(status))
+	}
+
+	sagaData.Status = "payment_capture_compensated"
+
+	updatedData, err := json.Marshal(sagaData)
+	if err != nil {
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка сериализации данных после компенсации: %v", message.SagaID, err)
+		return err
+	}
+
+	c.Logger.Printf("SagaID=%s: Отправка результата compensate/compensated шага %s", message.SagaID, c.Step)
+	return c.PublishCompensationResult(message.SagaID, updatedData)
+}
+
+// paymentID возвращает ID платежа заказа из данных саги, а если его там нет - ищет платеж по заказу.
+// Возвращает 0, если платеж не найден.
+func (c *CaptureConsumer) paymentID(sagaData sagahandler.SagaData) (uint, error) {
+	if sagaData.PaymentInfo != nil && sagaData.PaymentInfo.PaymentID != "" {
+		if id := sagahandler.ParseUint(sagaData.PaymentInfo.PaymentID); id > 0 {
+			return id, nil
+		}
+	}
+	if sagaData.OrderID == 0 {
+		return 0, nil
+	}
+
+	payment, err := c.paymentUseCase.GetPaymentForOrder(sagaData.OrderID)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка поиска платежа заказа %d: %w", sagaData.OrderID, err)
+	}
+	if payment == nil {
+		return 0, nil
+	}
+	return payment.ID, nil
+}
+
+// isCaptureRejected проверяет, что платеж нельзя списать и повторная доставка команды не поможет
+func isCaptureRejected(err error) bool {
+	return errors.Is(err, usecase.ErrPaymentNotCapturable) ||
+		errors.Is(err, usecase.ErrPaymentNotFound) ||
+		errors.Is(err, entity.ErrGatewayDeclined) ||
+		errors.Is(err, entity.ErrGatewayInvalidState) ||
+		errors.Is(err, entity.ErrGatewayTransactionNotFound)
+}
@@ -53,6 +53,11 @@ func (c *PaymentConsumer) Setup() error {
 		return fmt.Errorf("ошибка при привязке очереди к ключу order.cancelled: %w", err)
 	}
 
+	err = c.rabbitMQ.BindQueue("order_payment_queue", "order_events", "order.backordered")
+	if err != nil {
+		return fmt.Errorf("ошибка при привязке очереди к ключу order.backordered: %w", err)
+	}
+
 	// Настраиваем обработчик сообщений из очереди
 	err = c.rabbitMQ.ConsumeMessages("order_payment_queue", "payment-service", func(data []byte) error {
 		return c.paymentUseCase.HandleOrderEvent(data)
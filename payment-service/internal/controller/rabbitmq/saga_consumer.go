@@ -21,12 +21,13 @@ type SagaConsumer struct {
 }
 
 // NewSagaConsumer создает новый обработчик сообщений саги для платежей
-func NewSagaConsumer(paymentUseCase usecase.PaymentUseCaseInterface, rabbitMQ *rabbitmq.RabbitMQ) *SagaConsumer {
+func NewSagaConsumer(paymentUseCase usecase.PaymentUseCaseInterface, rabbitMQ *rabbitmq.RabbitMQ, ledger *sagahandler.Ledger) *SagaConsumer {
 	return &SagaConsumer{
 		BaseSagaConsumer: sagahandler.BaseSagaConsumer{
 			RabbitMQ: rabbitMQ,
 			Logger:   log.New(log.Writer(), "[PaymentService] [Saga] ", log.LstdFlags),
 			Step:     "process_payment",
+			Ledger:   ledger,
 		},
 		paymentUseCase: paymentUseCase,
 	}
@@ -87,24 +88,26 @@ func (c *SagaConsumer) handlePayment(data []byte) error {
 	}
 
 	if sagaDataRabbitmq.Amount <= 0 {
-		c.Logger.Printf("SagaID=%s: [ERROR] Некорректная сумма платежа: %.2f", message.SagaID, sagaDataRabbitmq.Amount)
-		return c.PublishFailureResult(message.SagaID, fmt.Sprintf("некорректная сумма платежа: %.2f", sagaDataRabbitmq.Amount))
+		c.Logger.Printf("SagaID=%s: [ERROR] Некорректная сумма платежа: %s", message.SagaID, sagaDataRabbitmq.Amount)
+		return c.PublishFailureResult(message.SagaID, fmt.Sprintf("некорректная сумма платежа: %s", sagaDataRabbitmq.Amount))
 	}
 
 	payment := &entity.CreatePaymentRequest{
 		OrderID:     sagaDataRabbitmq.OrderID,
 		UserID:      sagaDataRabbitmq.UserID,
 		Amount:      sagaDataRabbitmq.Amount,
+		Currency:    sagaDataRabbitmq.Currency.OrDefault(),
 		PaymentType: "CREDIT_CARD",
 	}
 
-	paymentInfo, err := c.paymentUseCase.CreatePayment(context.Background(), payment)
+	// Сумма только блокируется; списание выполняется на шаге capture_payment
+	paymentInfo, err := c.paymentUseCase.AuthorizePayment(context.Background(), payment)
 	if err != nil {
-		c.Logger.Printf("SagaID=%s: [ERROR] Ошибка создания платежа: %v", message.SagaID, err)
+		c.Logger.Printf("SagaID=%s: [ERROR] Ошибка авторизации платежа: %v", message.SagaID, err)
 		return c.PublishFailureResultWithData(message.SagaID,
 			fmt.Sprintf("ошибка обработки платежа: %v", err), message.Data)
 	}
-	c.Logger.Printf("SagaID=%s: Платеж создан успешно, PaymentID=%d", message.SagaID, paymentInfo.ID)
+	c.Logger.Printf("SagaID=%s: Платеж авторизован, PaymentID=%d", message.SagaID, paymentInfo.ID)
 
 	if sagaDataRabbitmq.PaymentInfo == nil {
 		sagaDataRabbitmq.PaymentInfo = &sagahandler.PaymentInfo{}
@@ -112,7 +115,9 @@ func (c *SagaConsumer) handlePayment(data []byte) error {
 
 	sagaDataRabbitmq.PaymentInfo.PaymentID = fmt.Sprintf("%d", paymentInfo.ID)
 	sagaDataRabbitmq.PaymentInfo.Status = string(paymentInfo.Status)
-	sagaDataRabbitmq.Status = "payment_processed"
+	sagaDataRabbitmq.PaymentInfo.Currency = paymentInfo.Currency
+	sagaDataRabbitmq.PaymentInfo.TransactionID = paymentInfo.TransactionID
+	sagaDataRabbitmq.Status = "payment_authorized"
 
 	if sagaDataRabbitmq.PaymentInfo == nil || sagaDataRabbitmq.PaymentInfo.PaymentID == "" {
 		c.Logger.Printf("SagaID=%s: [ERROR] КРИТИЧЕСКАЯ ОШИБКА: PaymentID не установлен перед публикацией результата", message.SagaID)
@@ -251,9 +256,10 @@ func (c *SagaConsumer) handleCompensatePayment(data []byte) error {
 	refundRequest := &entity.RefundPaymentRequest{
 		PaymentID: paymentID,
 		Amount:    sagaData.Amount,
+		Currency:  sagaData.Currency,
 	}
 
-	err = c.paymentUseCase.RefundPayment(context.Background(), refundRequest)
+	status, err := c.paymentUseCase.RefundPayment(context.Background(), refundRequest)
 	if err != nil {
 		c.Logger.Printf("SagaID=%s: [ERROR] Ошибка возврата платежа PaymentID=%d: %v", message.SagaID, paymentID, err)
 		sagaData.CompensatedSteps["process_payment"] = true
@@ -289,7 +295,7 @@ func (c *SagaConsumer) handleCompensatePayment(data []byte) error {
 	if sagaData.PaymentInfo == nil {
 		sagaData.PaymentInfo = &sagahandler.PaymentInfo{}
 	}
-	sagaData.PaymentInfo.Status = "REFUNDED"
+	sagaData.PaymentInfo.Status = strings.ToUpper(string(status))
 	sagaData.Status = "payment_compensated"
 
 	if sagaData.OrderID == 0 || sagaData.UserID == 0 {
@@ -0,0 +1,60 @@
+package entity
+
+import (
+	"errors"
+
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+var (
+	// ErrGatewayDeclined платежный шлюз отклонил операцию (недостаточно средств, карта заблокирована и т.п.)
+	ErrGatewayDeclined = errors.New("операция отклонена платежным шлюзом")
+	// ErrGatewayTimeout платежный шлюз не ответил вовремя: результат операции неизвестен
+	ErrGatewayTimeout = errors.New("таймаут платежного шлюза")
+	// ErrGatewayTransactionNotFound транзакция не найдена в платежном шлюзе
+	ErrGatewayTransactionNotFound = errors.New("транзакция не найдена в платежном шлюзе")
+	// ErrGatewayInvalidState операция недопустима в текущем статусе транзакции шлюза
+	ErrGatewayInvalidState = errors.New("операция недопустима в текущем статусе транзакции шлюза")
+)
+
+// GatewayStatus статус транзакции в платежном шлюзе
+type GatewayStatus string
+
+// Константы для статусов транзакции в платежном шлюзе
+const (
+	GatewayStatusAuthorized GatewayStatus = "authorized"
+	GatewayStatusCaptured   GatewayStatus = "captured"
+	GatewayStatusVoided     GatewayStatus = "voided"
+	GatewayStatusRefunded   GatewayStatus = "refunded"
+	GatewayStatusDeclined   GatewayStatus = "declined"
+)
+
+// GatewayAuthorizeRequest запрос авторизации (блокировки) суммы в платежном шлюзе
+type GatewayAuthorizeRequest struct {
+	// Reference идентификатор платежа на нашей стороне; шлюз использует его как ключ идемпотентности
+	Reference string         `json:"reference"`
+	OrderID   uint           `json:"order_id"`
+	Amount    money.Amount   `json:"amount"`
+	Currency  money.Currency `json:"currency"`
+	CardToken string         `json:"card_token,omitempty"`
+}
+
+// GatewayResult состояние транзакции в платежном шлюзе после операции
+type GatewayResult struct {
+	// TransactionID идентификатор транзакции платежа в шлюзе
+	TransactionID string         `json:"transaction_id"`
+	Status        GatewayStatus  `json:"status"`
+	Amount        money.Amount   `json:"amount"`
+	Currency      money.Currency `json:"currency"`
+	// CapturedAmount и RefundedAmount суммы списания и всех возвратов по транзакции
+	CapturedAmount money.Amount `json:"captured_amount"`
+	RefundedAmount money.Amount `json:"refunded_amount"`
+	// RefundID идентификатор возврата в шлюзе; заполняется только в ответ на возврат
+	RefundID      string `json:"refund_id,omitempty"`
+	DeclineReason string `json:"decline_reason,omitempty"`
+}
+
+// GatewayAmountRequest тело запроса списания или возврата суммы по транзакции шлюза
+type GatewayAmountRequest struct {
+	Amount money.Amount `json:"amount"`
+}
@@ -2,6 +2,8 @@ package entity
 
 import (
 	"time"
+
+	"github.com/director74/dz8_shop/pkg/money"
 )
 
 // PaymentStatus статус платежа
@@ -9,11 +11,19 @@ type PaymentStatus string
 
 // Константы для статусов платежа
 const (
-	PaymentStatusPending   PaymentStatus = "pending"
-	PaymentStatusCompleted PaymentStatus = "completed"
-	PaymentStatusFailed    PaymentStatus = "failed"
-	PaymentStatusRefunded  PaymentStatus = "refunded"
-	PaymentStatusCancelled PaymentStatus = "cancelled"
+	PaymentStatusPending PaymentStatus = "pending"
+	// PaymentStatusAuthorized сумма заблокирована в платежном шлюзе и будет списана при подтверждении заказа
+	PaymentStatusAuthorized PaymentStatus = "authorized"
+	PaymentStatusCompleted  PaymentStatus = "completed"
+	PaymentStatusFailed     PaymentStatus = "failed"
+	PaymentStatusRefunded   PaymentStatus = "refunded"
+	// PaymentStatusPartiallyRefunded часть суммы платежа возвращена, остаток можно вернуть следующими возвратами
+	PaymentStatusPartiallyRefunded PaymentStatus = "partially_refunded"
+	PaymentStatusCancelled         PaymentStatus = "cancelled"
+	// PaymentStatusVoided блокировка суммы снята при компенсации саги, деньги не списывались
+	PaymentStatusVoided PaymentStatus = "voided"
+	// PaymentStatusExpired блокировка суммы снята, так как заказ не подтвердили до истечения срока авторизации
+	PaymentStatusExpired PaymentStatus = "expired"
 )
 
 // PaymentMethodType тип метода платежа
@@ -29,16 +39,21 @@ const (
 
 // Payment представляет платеж
 type Payment struct {
-	ID            uint          `json:"id" gorm:"primaryKey"`
-	OrderID       uint          `json:"order_id" gorm:"not null"`
-	UserID        uint          `json:"user_id" gorm:"not null;index"`
-	Amount        float64       `json:"amount" gorm:"type:decimal(12,2);not null"`
-	PaymentMethod string        `json:"payment_method" gorm:"not null"`
-	Status        PaymentStatus `json:"status" gorm:"not null;default:pending"`
-	TransactionID string        `json:"transaction_id"`
-	CreatedAt     time.Time     `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
-	UpdatedAt     time.Time     `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
-	DeletedAt     *time.Time    `json:"deleted_at" gorm:"index"`
+	ID       uint           `json:"id" gorm:"primaryKey"`
+	OrderID  uint           `json:"order_id" gorm:"not null"`
+	UserID   uint           `json:"user_id" gorm:"not null;index"`
+	Amount   money.Amount   `json:"amount" gorm:"type:decimal(12,2);not null"`
+	Currency money.Currency `json:"currency" gorm:"type:varchar(3);not null;default:'RUB'"`
+	// RefundedAmount сумма всех выполненных возвратов по платежу, не больше Amount
+	RefundedAmount money.Amount  `json:"refunded_amount" gorm:"type:decimal(12,2);not null;default:0"`
+	PaymentMethod  string        `json:"payment_method" gorm:"not null"`
+	Status         PaymentStatus `json:"status" gorm:"not null;default:pending"`
+	TransactionID  string        `json:"transaction_id"`
+	// AuthorizationExpiresAt срок, до которого авторизованный платеж должен быть списан, иначе блокировка снимается
+	AuthorizationExpiresAt *time.Time `json:"authorization_expires_at,omitempty" gorm:"index"`
+	CreatedAt              time.Time  `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt              time.Time  `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	DeletedAt              *time.Time `json:"deleted_at" gorm:"index"`
 }
 
 // PaymentMethod представляет метод платежа пользователя
@@ -55,47 +70,57 @@ type PaymentMethod struct {
 
 // PaymentRequest модель запроса для создания платежа
 type PaymentRequest struct {
-	OrderID       uint    `json:"order_id" binding:"required"`
-	UserID        uint    `json:"user_id" binding:"required"`
-	Amount        float64 `json:"amount" binding:"required,gt=0"`
-	PaymentMethod string  `json:"payment_method" binding:"required"`
+	OrderID       uint           `json:"order_id" binding:"required"`
+	UserID        uint           `json:"user_id" binding:"required"`
+	Amount        money.Amount   `json:"amount" binding:"required,gt=0"`
+	Currency      money.Currency `json:"currency" binding:"omitempty,len=3"` // Валюта заказа, по умолчанию RUB
+	PaymentMethod string         `json:"payment_method" binding:"required"`
+	CardToken     string         `json:"card_token"` // Токен карты в платежном шлюзе
 }
 
 // CreatePaymentRequest модель запроса для создания платежа через сагу
 type CreatePaymentRequest struct {
-	OrderID     uint    `json:"order_id"`
-	UserID      uint    `json:"user_id"`
-	Amount      float64 `json:"amount"`
-	PaymentType string  `json:"payment_type"`
+	OrderID     uint           `json:"order_id"`
+	UserID      uint           `json:"user_id"`
+	Amount      money.Amount   `json:"amount"`
+	Currency    money.Currency `json:"currency"`
+	PaymentType string         `json:"payment_type"`
+	CardToken   string         `json:"card_token,omitempty"`
 }
 
 // RefundPaymentRequest модель запроса для возврата платежа
 type RefundPaymentRequest struct {
-	PaymentID uint    `json:"payment_id"`
-	Amount    float64 `json:"amount"`
+	PaymentID uint           `json:"payment_id"`
+	Amount    money.Amount   `json:"amount"`
+	Currency  money.Currency `json:"currency"` // Должна совпадать с валютой платежа; пустая - валюта платежа
 }
 
 // PaymentConfirmation модель ответа при подтверждении платежа
 type PaymentConfirmation struct {
-	PaymentID     uint          `json:"payment_id"`
-	OrderID       uint          `json:"order_id"`
-	Amount        float64       `json:"amount"`
-	Status        PaymentStatus `json:"status"`
-	TransactionID string        `json:"transaction_id,omitempty"`
-	Message       string        `json:"message,omitempty"`
+	PaymentID     uint           `json:"payment_id"`
+	OrderID       uint           `json:"order_id"`
+	Amount        money.Amount   `json:"amount"`
+	Currency      money.Currency `json:"currency"`
+	Status        PaymentStatus  `json:"status"`
+	TransactionID string         `json:"transaction_id,omitempty"`
+	Message       string         `json:"message,omitempty"`
 }
 
 // GetPaymentResponse модель ответа при запросе платежа
 type GetPaymentResponse struct {
-	ID            uint          `json:"id"`
-	OrderID       uint          `json:"order_id"`
-	UserID        uint          `json:"user_id"`
-	Amount        float64       `json:"amount"`
-	PaymentMethod string        `json:"payment_method"`
-	Status        PaymentStatus `json:"status"`
-	TransactionID string        `json:"transaction_id,omitempty"`
-	CreatedAt     time.Time     `json:"created_at"`
-	UpdatedAt     time.Time     `json:"updated_at"`
+	ID             uint           `json:"id"`
+	OrderID        uint           `json:"order_id"`
+	UserID         uint           `json:"user_id"`
+	Amount         money.Amount   `json:"amount"`
+	Currency       money.Currency `json:"currency"`
+	RefundedAmount money.Amount   `json:"refunded_amount"`
+	PaymentMethod  string         `json:"payment_method"`
+	Status         PaymentStatus  `json:"status"`
+	TransactionID  string         `json:"transaction_id,omitempty"`
+	// AuthorizationExpiresAt срок списания авторизованного платежа
+	AuthorizationExpiresAt *time.Time `json:"authorization_expires_at,omitempty"`
+	CreatedAt              time.Time  `json:"created_at"`
+	UpdatedAt              time.Time  `json:"updated_at"`
 }
 
 // ListPaymentsResponse модель ответа при запросе списка платежей
@@ -0,0 +1,50 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// RefundStatus статус возврата
+type RefundStatus string
+
+const (
+	RefundStatusCompleted RefundStatus = "completed"
+)
+
+// Refund возврат части или всей суммы платежа. Сумма всех возвратов платежа не превышает сумму платежа.
+type Refund struct {
+	ID            uint           `json:"id" gorm:"primaryKey"`
+	PaymentID     uint           `json:"payment_id" gorm:"not null;index"`
+	OrderID       uint           `json:"order_id" gorm:"not null"`
+	UserID        uint           `json:"user_id" gorm:"not null;index"`
+	Amount        money.Amount   `json:"amount" gorm:"type:decimal(12,2);not null"`
+	Currency      money.Currency `json:"currency" gorm:"type:varchar(3);not null;default:'RUB'"`
+	Reason        string         `json:"reason" gorm:"type:text"`
+	Status        RefundStatus   `json:"status" gorm:"type:varchar(20);not null"`
+	TransactionID string         `json:"transaction_id"`
+	CreatedAt     time.Time      `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+}
+
+// CreateRefundRequest запрос на возврат по платежу. Без суммы возвращается весь невозвращенный остаток.
+type CreateRefundRequest struct {
+	Amount   money.Amount   `json:"amount" binding:"omitempty,gt=0"`
+	Currency money.Currency `json:"currency" binding:"omitempty,len=3"` // Должна совпадать с валютой платежа
+	Reason   string         `json:"reason" binding:"max=500"`
+}
+
+// RefundResponse возврат и состояние платежа после него
+type RefundResponse struct {
+	Refund         Refund        `json:"refund"`
+	PaymentStatus  PaymentStatus `json:"payment_status"`
+	RefundedAmount money.Amount  `json:"refunded_amount"` // Сумма всех возвратов по платежу
+	Refundable     money.Amount  `json:"refundable"`      // Остаток, который еще можно вернуть
+}
+
+// ListRefundsResponse возвраты по платежу
+type ListRefundsResponse struct {
+	Refunds        []Refund     `json:"refunds"`
+	RefundedAmount money.Amount `json:"refunded_amount"`
+	Refundable     money.Amount `json:"refundable"`
+}
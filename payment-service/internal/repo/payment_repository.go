@@ -2,9 +2,12 @@ package repo
 
 import (
 	"errors"
+	"time"
 
 	"github.com/director74/dz8_shop/payment-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // PaymentRepository интерфейс для работы с платежами
@@ -14,11 +17,21 @@ type PaymentRepository interface {
 	GetPaymentByOrderID(orderID uint) (*entity.Payment, error)
 	UpdatePaymentStatus(id uint, status entity.PaymentStatus, transactionID string) error
 	GetPaymentsByUserID(userID uint) ([]entity.Payment, error)
+	LockPaymentByID(id uint) (*entity.Payment, error)
+	UpdateRefundedAmount(id uint, refunded money.Amount, status entity.PaymentStatus) error
+	MarkAuthorized(id uint, transactionID string, expiresAt time.Time) error
+	GetExpiredAuthorizations(now time.Time, limit int) ([]entity.Payment, error)
+
+	CreateRefund(refund *entity.Refund) error
+	GetRefundsByPaymentID(paymentID uint) ([]entity.Refund, error)
 
 	CreatePaymentMethod(method *entity.PaymentMethod) error
 	GetPaymentMethodsByUserID(userID uint) ([]entity.PaymentMethod, error)
 	GetDefaultPaymentMethod(userID uint) (*entity.PaymentMethod, error)
 	SetDefaultPaymentMethod(id uint, userID uint) error
+
+	// WithTransaction выполняет fn в транзакции; txRepo работает в этой же транзакции
+	WithTransaction(fn func(txRepo PaymentRepository, tx *gorm.DB) error) error
 }
 
 // PaymentRepo реализация репозитория платежей
@@ -79,6 +92,59 @@ func (r *PaymentRepo) GetPaymentsByUserID(userID uint) ([]entity.Payment, error)
 	return payments, err
 }
 
+// LockPaymentByID возвращает платеж по ID, блокируя строку до конца транзакции.
+// Используется для возвратов, чтобы параллельные возвраты не превысили сумму платежа.
+func (r *PaymentRepo) LockPaymentByID(id uint) (*entity.Payment, error) {
+	var payment entity.Payment
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// UpdateRefundedAmount обновляет сумму возвратов и статус платежа
+func (r *PaymentRepo) UpdateRefundedAmount(id uint, refunded money.Amount, status entity.PaymentStatus) error {
+	return r.db.Model(&entity.Payment{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"refunded_amount": refunded,
+			"status":          status,
+		}).Error
+}
+
+// MarkAuthorized переводит платеж в статус authorized со сроком списания expiresAt
+func (r *PaymentRepo) MarkAuthorized(id uint, transactionID string, expiresAt time.Time) error {
+	return r.db.Model(&entity.Payment{}).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":                   entity.PaymentStatusAuthorized,
+			"transaction_id":           transactionID,
+			"authorization_expires_at": expiresAt,
+		}).Error
+}
+
+// GetExpiredAuthorizations возвращает авторизованные платежи, срок списания которых истек к моменту now
+func (r *PaymentRepo) GetExpiredAuthorizations(now time.Time, limit int) ([]entity.Payment, error) {
+	var payments []entity.Payment
+	err := r.db.Where("status = ? AND authorization_expires_at < ?", entity.PaymentStatusAuthorized, now).
+		Order("authorization_expires_at").Limit(limit).Find(&payments).Error
+	return payments, err
+}
+
+// CreateRefund сохраняет возврат по платежу
+func (r *PaymentRepo) CreateRefund(refund *entity.Refund) error {
+	return r.db.Create(refund).Error
+}
+
+// GetRefundsByPaymentID возвращает возвраты по платежу в порядке создания
+func (r *PaymentRepo) GetRefundsByPaymentID(paymentID uint) ([]entity.Refund, error) {
+	var refunds []entity.Refund
+	err := r.db.Where("payment_id = ?", paymentID).Order("id").Find(&refunds).Error
+	return refunds, err
+}
+
 // CreatePaymentMethod создает новый метод платежа
 func (r *PaymentRepo) CreatePaymentMethod(method *entity.PaymentMethod) error {
 	return r.db.Create(method).Error
@@ -119,3 +185,10 @@ func (r *PaymentRepo) SetDefaultPaymentMethod(id uint, userID uint) error {
 		Where("id = ? AND user_id = ?", id, userID).
 		Update("is_default", true).Error
 }
+
+// WithTransaction выполняет функцию в транзакции базы данных
+func (r *PaymentRepo) WithTransaction(fn func(txRepo PaymentRepository, tx *gorm.DB) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&PaymentRepo{db: tx}, tx)
+	})
+}
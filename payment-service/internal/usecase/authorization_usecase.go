@@ -0,0 +1,257 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/director74/dz8_shop/payment-service/internal/entity"
+	"github.com/director74/dz8_shop/payment-service/internal/repo"
+	"gorm.io/gorm"
+)
+
+// ErrPaymentNotCapturable платеж в текущем статусе нельзя списать: авторизация отменена, истекла или не проходила
+var ErrPaymentNotCapturable = errors.New("списание по платежу невозможно")
+
+const (
+	// defaultAuthorizationTTL срок списания авторизованного платежа по умолчанию
+	defaultAuthorizationTTL = 24 * time.Hour
+	// authorizationExpiryBatchSize количество просроченных авторизаций, обрабатываемых за одну проверку
+	authorizationExpiryBatchSize = 100
+)
+
+// AuthorizePayment создает платеж в рамках саги и блокирует его сумму в платежном шлюзе.
+// Деньги списываются позже, при подтверждении заказа (CapturePayment). Если шлюз отклонил
+// авторизацию, платеж сохраняется в статусе failed и возвращается вместе с ошибкой.
+func (uc *PaymentUseCase) AuthorizePayment(ctx context.Context, req *entity.CreatePaymentRequest) (*entity.Payment, error) {
+	currency, err := uc.orderCurrency(req.OrderID, req.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	payment := &entity.Payment{
+		OrderID:       req.OrderID,
+		UserID:        req.UserID,
+		Amount:        req.Amount,
+		Currency:      currency,
+		PaymentMethod: req.PaymentType,
+		Status:        entity.PaymentStatusPending,
+	}
+	if err := uc.paymentRepo.CreatePayment(payment); err != nil {
+		return nil, fmt.Errorf("ошибка создания платежа: %w", err)
+	}
+
+	transactionID, authErr := uc.authorize(ctx, payment, req.CardToken)
+	payment.TransactionID = transactionID
+	if authErr != nil {
+		log.Printf("Авторизация платежа для OrderID=%d, Amount=%s не прошла: %v", payment.OrderID, payment.Amount, authErr)
+		if err := uc.paymentRepo.UpdatePaymentStatus(payment.ID, entity.PaymentStatusFailed, transactionID); err != nil {
+			return nil, fmt.Errorf("ошибка обновления статуса платежа на %s: %w", entity.PaymentStatusFailed, err)
+		}
+		payment.Status = entity.PaymentStatusFailed
+		return payment, authErr
+	}
+
+	expiresAt := time.Now().Add(uc.authorizationTTL)
+	if err := uc.paymentRepo.MarkAuthorized(payment.ID, transactionID, expiresAt); err != nil {
+		// Платеж остается в статусе pending: блокировку в шлюзе снимаем, чтобы она не висела на карте клиента
+		if _, voidErr := uc.gateway.Void(ctx, transactionID); voidErr != nil {
+			log.Printf("Ошибка отмены авторизации %s платежа %d: %v", transactionID, payment.ID, voidErr)
+		}
+		return nil, fmt.Errorf("ошибка обновления статуса платежа на %s: %w", entity.PaymentStatusAuthorized, err)
+	}
+	payment.Status = entity.PaymentStatusAuthorized
+	payment.AuthorizationExpiresAt = &expiresAt
+
+	return payment, nil
+}
+
+// CapturePayment списывает заблокированную сумму авторизованного платежа при подтверждении заказа.
+// Повторное списание уже списанного платежа ничего не меняет.
+func (uc *PaymentUseCase) CapturePayment(ctx context.Context, paymentID uint) (*entity.Payment, error) {
+	var captured *entity.Payment
+
+	err := uc.paymentRepo.WithTransaction(func(txRepo repo.PaymentRepository, tx *gorm.DB) error {
+		// Блокируем платеж, чтобы списание не пересеклось с отменой или истечением авторизации
+		payment, err := txRepo.LockPaymentByID(paymentID)
+		if err != nil {
+			return fmt.Errorf("ошибка получения платежа: %w", err)
+		}
+		if payment == nil {
+			return ErrPaymentNotFound
+		}
+
+		switch payment.Status {
+		case entity.PaymentStatusCompleted, entity.PaymentStatusPartiallyRefunded, entity.PaymentStatusRefunded:
+			captured = payment
+			return nil
+		case entity.PaymentStatusAuthorized:
+		default:
+			return fmt.Errorf("%w: платеж %d в статусе %s", ErrPaymentNotCapturable, payment.ID, payment.Status)
+		}
+
+		if _, err := uc.gateway.Capture(ctx, payment.TransactionID, payment.Amount); err != nil {
+			return fmt.Errorf("ошибка списания в платежном шлюзе: %w", err)
+		}
+		if err := txRepo.UpdatePaymentStatus(payment.ID, entity.PaymentStatusCompleted, payment.TransactionID); err != nil {
+			return fmt.Errorf("ошибка обновления статуса платежа: %w", err)
+		}
+		payment.Status = entity.PaymentStatusCompleted
+		captured = payment
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Платеж %d по заказу %d списан, статус %s", captured.ID, captured.OrderID, captured.Status)
+	return captured, nil
+}
+
+// ExpireAuthorizations снимает блокировку сумм авторизованных платежей, которые не были списаны
+// до истечения срока авторизации. Возвращает количество платежей, переведенных в статус expired.
+func (uc *PaymentUseCase) ExpireAuthorizations(ctx context.Context) (int, error) {
+	payments, err := uc.paymentRepo.GetExpiredAuthorizations(time.Now(), authorizationExpiryBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка поиска просроченных авторизаций: %w", err)
+	}
+
+	expired := 0
+	for _, payment := range payments {
+		released, err := uc.releaseAuthorization(ctx, payment.ID, entity.PaymentStatusExpired)
+		if err != nil {
+			log.Printf("Ошибка снятия просроченной авторизации платежа %d: %v", payment.ID, err)
+			continue
+		}
+		if released.Status == entity.PaymentStatusExpired {
+			log.Printf("Авторизация платежа %d по заказу %d истекла, блокировка суммы снята", payment.ID, payment.OrderID)
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// ExtendAuthorization продлевает блокировку суммы авторизованного платежа заказа orderID до holdUntil,
+// пока заказ ждет поступления товара на склад (событие order.backordered). Срок авторизации только
+// увеличивается; списанный, отмененный или истекший платеж не меняется. Возвращает true, если срок продлен.
+func (uc *PaymentUseCase) ExtendAuthorization(ctx context.Context, orderID uint, holdUntil time.Time) (bool, error) {
+	extended := false
+
+	err := uc.paymentRepo.WithTransaction(func(txRepo repo.PaymentRepository, tx *gorm.DB) error {
+		found, err := txRepo.GetPaymentByOrderID(orderID)
+		if err != nil {
+			return fmt.Errorf("ошибка получения платежа заказа %d: %w", orderID, err)
+		}
+		if found == nil {
+			return nil
+		}
+
+		// Блокируем платеж, чтобы продление не пересеклось со списанием или истечением авторизации
+		payment, err := txRepo.LockPaymentByID(found.ID)
+		if err != nil {
+			return fmt.Errorf("ошибка получения платежа: %w", err)
+		}
+		if payment == nil || payment.Status != entity.PaymentStatusAuthorized {
+			return nil
+		}
+		if payment.AuthorizationExpiresAt != nil && !payment.AuthorizationExpiresAt.Before(holdUntil) {
+			return nil
+		}
+
+		if err := txRepo.MarkAuthorized(payment.ID, payment.TransactionID, holdUntil); err != nil {
+			return fmt.Errorf("ошибка продления авторизации платежа %d: %w", payment.ID, err)
+		}
+		extended = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if extended {
+		log.Printf("Авторизация платежа по заказу %d продлена до %s на время ожидания товара", orderID, holdUntil.Format(time.RFC3339))
+	}
+	return extended, nil
+}
+
+// RunAuthorizationExpiry периодически снимает просроченные авторизации.
+// Блокирует вызывающую горутину до отмены ctx.
+func (uc *PaymentUseCase) RunAuthorizationExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Проверка просроченных авторизаций запущена (интервал %s, срок авторизации %s)", interval, uc.authorizationTTL)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Проверка просроченных авторизаций остановлена")
+			return
+		case <-ticker.C:
+			if _, err := uc.ExpireAuthorizations(ctx); err != nil {
+				log.Printf("Ошибка проверки просроченных авторизаций: %v", err)
+			}
+		}
+	}
+}
+
+// authorize блокирует сумму платежа в платежном шлюзе.
+// Возвращает идентификатор транзакции шлюза, если шлюз успел ее создать.
+func (uc *PaymentUseCase) authorize(ctx context.Context, payment *entity.Payment, cardToken string) (string, error) {
+	authorization, err := uc.gateway.Authorize(ctx, entity.GatewayAuthorizeRequest{
+		Reference: fmt.Sprintf("payment-%d", payment.ID),
+		OrderID:   payment.OrderID,
+		Amount:    payment.Amount,
+		Currency:  payment.Currency.OrDefault(),
+		CardToken: cardToken,
+	})
+
+	var transactionID string
+	if authorization != nil {
+		transactionID = authorization.TransactionID
+	}
+	if err != nil {
+		return transactionID, fmt.Errorf("ошибка авторизации платежа: %w", err)
+	}
+	return transactionID, nil
+}
+
+// releaseAuthorization снимает блокировку суммы авторизованного платежа и переводит его в статус status.
+// Платеж, который уже не авторизован (списан или блокировка уже снята), возвращается без изменений.
+func (uc *PaymentUseCase) releaseAuthorization(ctx context.Context, paymentID uint, status entity.PaymentStatus) (*entity.Payment, error) {
+	var released *entity.Payment
+
+	err := uc.paymentRepo.WithTransaction(func(txRepo repo.PaymentRepository, tx *gorm.DB) error {
+		payment, err := txRepo.LockPaymentByID(paymentID)
+		if err != nil {
+			return fmt.Errorf("ошибка получения платежа: %w", err)
+		}
+		if payment == nil {
+			return ErrPaymentNotFound
+		}
+		released = payment
+		if payment.Status != entity.PaymentStatusAuthorized {
+			return nil
+		}
+
+		if _, err := uc.gateway.Void(ctx, payment.TransactionID); err != nil {
+			// Неизвестную шлюзу транзакцию (например, истекшую на его стороне) отменять не нужно
+			if !errors.Is(err, entity.ErrGatewayTransactionNotFound) {
+				return fmt.Errorf("ошибка отмены авторизации в платежном шлюзе: %w", err)
+			}
+			log.Printf("Транзакция %s платежа %d не найдена в платежном шлюзе, блокировка не действует", payment.TransactionID, payment.ID)
+		}
+		if err := txRepo.UpdatePaymentStatus(payment.ID, status, payment.TransactionID); err != nil {
+			return fmt.Errorf("ошибка обновления статуса платежа: %w", err)
+		}
+		payment.Status = status
+
+		return uc.publishPaymentCancellation(uc.txPublisher(tx), payment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return released, nil
+}
@@ -0,0 +1,206 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/director74/dz8_shop/payment-service/internal/entity"
+	"github.com/director74/dz8_shop/payment-service/internal/usecase/gateway"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// authorizeRequest запрос на авторизацию платежа пользователя 1 по заказу orderID
+func authorizeRequest(orderID uint, amount money.Amount, cardToken string) *entity.CreatePaymentRequest {
+	return &entity.CreatePaymentRequest{
+		OrderID:     orderID,
+		UserID:      1,
+		Amount:      amount,
+		PaymentType: "CREDIT_CARD",
+		CardToken:   cardToken,
+	}
+}
+
+// TestAuthorizePayment тестирует блокировку суммы платежа со сроком списания
+func TestAuthorizePayment(t *testing.T) {
+	ctx := context.Background()
+	uc, store, gw, _ := newTestPaymentUseCase()
+	uc.UseAuthorizationTTL(time.Hour)
+	before := time.Now()
+
+	payment, err := uc.AuthorizePayment(ctx, authorizeRequest(7, 10000, ""))
+
+	assert.NoError(t, err)
+	assert.Equal(t, entity.PaymentStatusAuthorized, payment.Status)
+	assert.Equal(t, money.DefaultCurrency, payment.Currency)
+	stored := store.payment(payment.ID)
+	assert.Equal(t, entity.PaymentStatusAuthorized, stored.Status)
+	assert.Equal(t, payment.TransactionID, stored.TransactionID)
+	if assert.NotNil(t, stored.AuthorizationExpiresAt) {
+		assert.WithinDuration(t, before.Add(time.Hour), *stored.AuthorizationExpiresAt, time.Minute)
+	}
+
+	status, err := gw.Status(ctx, payment.TransactionID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.GatewayStatusAuthorized, status.Status)
+	assert.Zero(t, status.CapturedAmount)
+}
+
+// TestAuthorizePayment_Declined тестирует сохранение неуспешного платежа при отказе шлюза
+func TestAuthorizePayment_Declined(t *testing.T) {
+	uc, store, _, _ := newTestPaymentUseCase(gateway.DefaultFakeRules()...)
+
+	payment, err := uc.AuthorizePayment(context.Background(), authorizeRequest(7, 10000, gateway.CardTokenDeclined))
+
+	assert.ErrorIs(t, err, entity.ErrGatewayDeclined)
+	if assert.NotNil(t, payment) {
+		assert.Equal(t, entity.PaymentStatusFailed, payment.Status)
+		assert.Equal(t, entity.PaymentStatusFailed, store.payment(payment.ID).Status)
+		assert.Nil(t, store.payment(payment.ID).AuthorizationExpiresAt)
+	}
+}
+
+// TestAuthorizePayment_CurrencyMismatch тестирует отказ в платеже по заказу в другой валюте
+func TestAuthorizePayment_CurrencyMismatch(t *testing.T) {
+	uc, store, _, _ := newTestPaymentUseCase()
+	store.addPayment(entity.Payment{OrderID: 7, UserID: 1, Amount: 10000, Currency: "USD", Status: entity.PaymentStatusFailed})
+
+	req := authorizeRequest(7, 10000, "")
+	req.Currency = "RUB"
+	_, err := uc.AuthorizePayment(context.Background(), req)
+
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+	assert.Len(t, store.payments, 1)
+}
+
+// TestCapturePayment тестирует списание авторизованного платежа без повторного списания
+func TestCapturePayment(t *testing.T) {
+	ctx := context.Background()
+	uc, store, gw, _ := newTestPaymentUseCase()
+	authorized, err := uc.AuthorizePayment(ctx, authorizeRequest(7, 10000, ""))
+	assert.NoError(t, err)
+
+	captured, err := uc.CapturePayment(ctx, authorized.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.PaymentStatusCompleted, captured.Status)
+	assert.Equal(t, entity.PaymentStatusCompleted, store.payment(authorized.ID).Status)
+
+	// Повторная команда списания ничего не меняет
+	captured, err = uc.CapturePayment(ctx, authorized.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.PaymentStatusCompleted, captured.Status)
+
+	status, err := gw.Status(ctx, authorized.TransactionID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.GatewayStatusCaptured, status.Status)
+	assert.Equal(t, money.Amount(10000), status.CapturedAmount)
+}
+
+// TestCapturePayment_NotCapturable тестирует отказ в списании платежа, который нельзя списать
+func TestCapturePayment_NotCapturable(t *testing.T) {
+	ctx := context.Background()
+	uc, store, gw, _ := newTestPaymentUseCase(gateway.FakeRule{Operation: gateway.OperationCapture, Amount: 6660, Outcome: gateway.OutcomeDecline})
+
+	voided, err := uc.AuthorizePayment(ctx, authorizeRequest(1, 10000, ""))
+	assert.NoError(t, err)
+	status, err := uc.RefundPayment(ctx, &entity.RefundPaymentRequest{PaymentID: voided.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, entity.PaymentStatusVoided, status)
+
+	_, err = uc.CapturePayment(ctx, voided.ID)
+	assert.ErrorIs(t, err, ErrPaymentNotCapturable)
+
+	_, err = uc.CapturePayment(ctx, 99)
+	assert.ErrorIs(t, err, ErrPaymentNotFound)
+
+	// Шлюз отклонил списание: платеж остается авторизованным
+	declined, err := uc.AuthorizePayment(ctx, authorizeRequest(2, 6660, ""))
+	assert.NoError(t, err)
+	_, err = uc.CapturePayment(ctx, declined.ID)
+	assert.ErrorIs(t, err, entity.ErrGatewayDeclined)
+	assert.Equal(t, entity.PaymentStatusAuthorized, store.payment(declined.ID).Status)
+
+	gwStatus, err := gw.Status(ctx, voided.TransactionID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.GatewayStatusVoided, gwStatus.Status)
+}
+
+// TestExpireAuthorizations тестирует снятие блокировки с авторизаций, не списанных в срок
+func TestExpireAuthorizations(t *testing.T) {
+	ctx := context.Background()
+	uc, store, gw, publisher := newTestPaymentUseCase()
+
+	stale, err := uc.AuthorizePayment(ctx, authorizeRequest(1, 10000, ""))
+	assert.NoError(t, err)
+	fresh, err := uc.AuthorizePayment(ctx, authorizeRequest(2, 20000, ""))
+	assert.NoError(t, err)
+	capturedInTime, err := uc.AuthorizePayment(ctx, authorizeRequest(3, 30000, ""))
+	assert.NoError(t, err)
+	_, err = uc.CapturePayment(ctx, capturedInTime.ID)
+	assert.NoError(t, err)
+
+	past := time.Now().Add(-time.Minute)
+	store.find(stale.ID).AuthorizationExpiresAt = &past
+	store.find(capturedInTime.ID).AuthorizationExpiresAt = &past
+
+	expired, err := uc.ExpireAuthorizations(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	assert.Equal(t, entity.PaymentStatusExpired, store.payment(stale.ID).Status)
+	assert.Equal(t, entity.PaymentStatusAuthorized, store.payment(fresh.ID).Status)
+	assert.Equal(t, entity.PaymentStatusCompleted, store.payment(capturedInTime.ID).Status)
+	assert.Equal(t, []string{"payment.cancelled"}, publisher.routingKeys())
+
+	status, err := gw.Status(ctx, stale.TransactionID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.GatewayStatusVoided, status.Status)
+
+	// Истекшую авторизацию нельзя списать, а повторная проверка ничего не меняет
+	_, err = uc.CapturePayment(ctx, stale.ID)
+	assert.ErrorIs(t, err, ErrPaymentNotCapturable)
+	expired, err = uc.ExpireAuthorizations(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, expired)
+}
+
+// TestExtendAuthorization тестирует продление блокировки суммы платежа заказа, ожидающего поступления товара
+func TestExtendAuthorization(t *testing.T) {
+	ctx := context.Background()
+	uc, store, _, _ := newTestPaymentUseCase()
+	uc.UseAuthorizationTTL(time.Hour)
+
+	payment, err := uc.AuthorizePayment(ctx, authorizeRequest(7, 10000, ""))
+	assert.NoError(t, err)
+
+	holdUntil := time.Now().Add(10 * 24 * time.Hour).Truncate(time.Second)
+	event := fmt.Sprintf(`{"type":"order.backordered","order_id":7,"hold_until":%q}`, holdUntil.Format(time.RFC3339))
+	assert.NoError(t, uc.HandleOrderEvent([]byte(event)))
+	if expiresAt := store.payment(payment.ID).AuthorizationExpiresAt; assert.NotNil(t, expiresAt) {
+		assert.True(t, holdUntil.Equal(*expiresAt))
+	}
+
+	// Продленная авторизация не истекает по обычному сроку, а более ранний срок ее не сокращает
+	expired, err := uc.ExpireAuthorizations(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, expired)
+	extended, err := uc.ExtendAuthorization(ctx, 7, time.Now().Add(48*time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, extended)
+	assert.True(t, holdUntil.Equal(*store.payment(payment.ID).AuthorizationExpiresAt))
+
+	// Списанный платеж и заказ без платежа не меняются
+	_, err = uc.CapturePayment(ctx, payment.ID)
+	assert.NoError(t, err)
+	extended, err = uc.ExtendAuthorization(ctx, 7, holdUntil.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, extended)
+	assert.Equal(t, entity.PaymentStatusCompleted, store.payment(payment.ID).Status)
+
+	extended, err = uc.ExtendAuthorization(ctx, 99, holdUntil)
+	assert.NoError(t, err)
+	assert.False(t, extended)
+}
@@ -0,0 +1,347 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/director74/dz8_shop/payment-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// Operation операция платежного шлюза
+type Operation string
+
+// Константы для операций платежного шлюза
+const (
+	OperationAuthorize Operation = "authorize"
+	OperationCapture   Operation = "capture"
+	OperationVoid      Operation = "void"
+	OperationRefund    Operation = "refund"
+	OperationStatus    Operation = "status"
+)
+
+// Outcome исход операции фейкового шлюза по правилу
+type Outcome string
+
+// Константы для исходов операций фейкового шлюза
+const (
+	// OutcomeDecline шлюз отклоняет операцию
+	OutcomeDecline Outcome = "decline"
+	// OutcomeTimeout шлюз не отвечает; операция при этом не выполняется
+	OutcomeTimeout Outcome = "timeout"
+)
+
+// Тестовые токены карт, которые фейковый шлюз обрабатывает без дополнительных правил
+const (
+	CardTokenDeclined = "tok_declined"
+	CardTokenTimeout  = "tok_timeout"
+)
+
+// FakeRule правило фейкового шлюза: операция Operation по платежу с суммой Amount и токеном карты CardToken
+// завершается исходом Outcome. Пустое поле подходит к любому значению. Amount сравнивается с суммой
+// самой операции (авторизации, списания, возврата), для отмены и запроса статуса - с суммой авторизации.
+type FakeRule struct {
+	Operation Operation
+	Amount    money.Amount
+	CardToken string
+	Outcome   Outcome
+}
+
+// matches проверяет, применяется ли правило к операции
+func (r FakeRule) matches(op Operation, amount money.Amount, cardToken string) bool {
+	return (r.Operation == "" || r.Operation == op) &&
+		(r.Amount == 0 || r.Amount == amount) &&
+		(r.CardToken == "" || r.CardToken == cardToken)
+}
+
+// DefaultFakeRules правила для тестовых токенов карт
+func DefaultFakeRules() []FakeRule {
+	return []FakeRule{
+		{CardToken: CardTokenDeclined, Outcome: OutcomeDecline},
+		{CardToken: CardTokenTimeout, Outcome: OutcomeTimeout},
+	}
+}
+
+// ParseFakeRules разбирает правила фейкового шлюза из строки вида
+// "outcome=decline,amount=666.00;outcome=timeout,op=refund,token=tok_slow".
+// Правила разделяются ";", поля правила - ",". Поле outcome обязательно.
+func ParseFakeRules(spec string) ([]FakeRule, error) {
+	var rules []FakeRule
+	for _, ruleSpec := range strings.Split(spec, ";") {
+		ruleSpec = strings.TrimSpace(ruleSpec)
+		if ruleSpec == "" {
+			continue
+		}
+
+		var rule FakeRule
+		for _, field := range strings.Split(ruleSpec, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+			if !ok {
+				return nil, fmt.Errorf("некорректное поле %q в правиле %q", field, ruleSpec)
+			}
+			value = strings.TrimSpace(value)
+
+			switch strings.TrimSpace(key) {
+			case "outcome":
+				rule.Outcome = Outcome(value)
+			case "op":
+				rule.Operation = Operation(value)
+			case "amount":
+				amount, err := money.Parse(value)
+				if err != nil {
+					return nil, fmt.Errorf("некорректная сумма в правиле %q: %w", ruleSpec, err)
+				}
+				rule.Amount = amount
+			case "token":
+				rule.CardToken = value
+			default:
+				return nil, fmt.Errorf("неизвестное поле %q в правиле %q", key, ruleSpec)
+			}
+		}
+
+		switch rule.Outcome {
+		case OutcomeDecline, OutcomeTimeout:
+		default:
+			return nil, fmt.Errorf("некорректный исход %q в правиле %q", rule.Outcome, ruleSpec)
+		}
+		switch rule.Operation {
+		case "", OperationAuthorize, OperationCapture, OperationVoid, OperationRefund, OperationStatus:
+		default:
+			return nil, fmt.Errorf("некорректная операция %q в правиле %q", rule.Operation, ruleSpec)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// fakeTransaction транзакция фейкового шлюза
+type fakeTransaction struct {
+	result    entity.GatewayResult
+	cardToken string
+}
+
+// FakeGateway детерминированный платежный шлюз в памяти процесса для разработки и тестов.
+// Все операции успешны, кроме подходящих под правила; первое подходящее правило определяет исход.
+type FakeGateway struct {
+	mu           sync.Mutex
+	rules        []FakeRule
+	transactions map[string]*fakeTransaction
+	references   map[string]string
+	seq          int
+	refundSeq    int
+}
+
+// NewFakeGateway создает фейковый шлюз с правилами rules
+func NewFakeGateway(rules ...FakeRule) *FakeGateway {
+	return &FakeGateway{
+		rules:        rules,
+		transactions: make(map[string]*fakeTransaction),
+		references:   make(map[string]string),
+	}
+}
+
+// AddRule добавляет правило в конец списка правил
+func (g *FakeGateway) AddRule(rule FakeRule) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rules = append(g.rules, rule)
+}
+
+// Authorize блокирует сумму платежа. Повторная авторизация с тем же Reference возвращает существующую транзакцию.
+func (g *FakeGateway) Authorize(ctx context.Context, req entity.GatewayAuthorizeRequest) (*entity.GatewayResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if req.Reference != "" {
+		if id, ok := g.references[req.Reference]; ok {
+			return g.reply(g.transactions[id])
+		}
+	}
+	if !req.Amount.IsPositive() {
+		return nil, fmt.Errorf("%w: сумма авторизации должна быть положительной", entity.ErrGatewayInvalidState)
+	}
+
+	outcome := g.outcome(OperationAuthorize, req.Amount, req.CardToken)
+	if outcome == OutcomeTimeout {
+		return nil, fmt.Errorf("%w: авторизация %s", entity.ErrGatewayTimeout, req.Reference)
+	}
+
+	g.seq++
+	tx := &fakeTransaction{
+		result: entity.GatewayResult{
+			TransactionID: fmt.Sprintf("FAKE-TRX-%06d", g.seq),
+			Status:        entity.GatewayStatusAuthorized,
+			Amount:        req.Amount,
+			Currency:      req.Currency.OrDefault(),
+		},
+		cardToken: req.CardToken,
+	}
+	if outcome == OutcomeDecline {
+		tx.result.Status = entity.GatewayStatusDeclined
+		tx.result.DeclineReason = "платеж отклонен по сценарию шлюза"
+	}
+	g.transactions[tx.result.TransactionID] = tx
+	if req.Reference != "" {
+		g.references[req.Reference] = tx.result.TransactionID
+	}
+
+	return g.reply(tx)
+}
+
+// Capture списывает заблокированную сумму
+func (g *FakeGateway) Capture(ctx context.Context, transactionID string, amount money.Amount) (*entity.GatewayResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	tx, ok := g.transactions[transactionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", entity.ErrGatewayTransactionNotFound, transactionID)
+	}
+	if tx.result.Status == entity.GatewayStatusCaptured && (amount == 0 || amount == tx.result.CapturedAmount) {
+		return g.copyResult(tx), nil
+	}
+	if tx.result.Status != entity.GatewayStatusAuthorized {
+		return nil, fmt.Errorf("%w: списание транзакции %s в статусе %s", entity.ErrGatewayInvalidState, transactionID, tx.result.Status)
+	}
+	if amount == 0 {
+		amount = tx.result.Amount
+	}
+	if amount < 0 || amount > tx.result.Amount {
+		return nil, fmt.Errorf("%w: списание %s больше авторизованной суммы %s", entity.ErrGatewayInvalidState, amount, tx.result.Amount)
+	}
+
+	switch g.outcome(OperationCapture, amount, tx.cardToken) {
+	case OutcomeTimeout:
+		return nil, fmt.Errorf("%w: списание %s", entity.ErrGatewayTimeout, transactionID)
+	case OutcomeDecline:
+		return g.declined(tx, "списание отклонено по сценарию шлюза")
+	}
+
+	tx.result.Status = entity.GatewayStatusCaptured
+	tx.result.CapturedAmount = amount
+	return g.copyResult(tx), nil
+}
+
+// Void снимает блокировку суммы. Повторная отмена ничего не меняет.
+func (g *FakeGateway) Void(ctx context.Context, transactionID string) (*entity.GatewayResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	tx, ok := g.transactions[transactionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", entity.ErrGatewayTransactionNotFound, transactionID)
+	}
+	if tx.result.Status == entity.GatewayStatusVoided {
+		return g.copyResult(tx), nil
+	}
+	if tx.result.Status != entity.GatewayStatusAuthorized {
+		return nil, fmt.Errorf("%w: отмена транзакции %s в статусе %s", entity.ErrGatewayInvalidState, transactionID, tx.result.Status)
+	}
+
+	switch g.outcome(OperationVoid, tx.result.Amount, tx.cardToken) {
+	case OutcomeTimeout:
+		return nil, fmt.Errorf("%w: отмена %s", entity.ErrGatewayTimeout, transactionID)
+	case OutcomeDecline:
+		return g.declined(tx, "отмена отклонена по сценарию шлюза")
+	}
+
+	tx.result.Status = entity.GatewayStatusVoided
+	return g.copyResult(tx), nil
+}
+
+// Refund возвращает часть списанной суммы. Транзакции, неизвестные шлюзу (например, созданные
+// до перезапуска процесса), считаются списанными: невозвращенный остаток проверяет платежный сервис.
+func (g *FakeGateway) Refund(ctx context.Context, transactionID string, amount money.Amount) (*entity.GatewayResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	tx, ok := g.transactions[transactionID]
+	if !ok {
+		if !amount.IsPositive() {
+			return nil, fmt.Errorf("%w: %s", entity.ErrGatewayTransactionNotFound, transactionID)
+		}
+		tx = &fakeTransaction{result: entity.GatewayResult{
+			TransactionID:  transactionID,
+			Status:         entity.GatewayStatusCaptured,
+			Amount:         amount,
+			CapturedAmount: amount,
+		}}
+		g.transactions[transactionID] = tx
+	}
+	if tx.result.Status != entity.GatewayStatusCaptured {
+		return nil, fmt.Errorf("%w: возврат по транзакции %s в статусе %s", entity.ErrGatewayInvalidState, transactionID, tx.result.Status)
+	}
+
+	remaining := tx.result.CapturedAmount - tx.result.RefundedAmount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		return nil, fmt.Errorf("%w: возврат %s больше невозвращенного остатка %s", entity.ErrGatewayInvalidState, amount, remaining)
+	}
+
+	switch g.outcome(OperationRefund, amount, tx.cardToken) {
+	case OutcomeTimeout:
+		return nil, fmt.Errorf("%w: возврат по %s", entity.ErrGatewayTimeout, transactionID)
+	case OutcomeDecline:
+		return g.declined(tx, "возврат отклонен по сценарию шлюза")
+	}
+
+	tx.result.RefundedAmount += amount
+	if tx.result.RefundedAmount == tx.result.CapturedAmount {
+		tx.result.Status = entity.GatewayStatusRefunded
+	}
+	g.refundSeq++
+	result := g.copyResult(tx)
+	result.RefundID = fmt.Sprintf("FAKE-RFD-%06d", g.refundSeq)
+	return result, nil
+}
+
+// Status возвращает текущее состояние транзакции
+func (g *FakeGateway) Status(ctx context.Context, transactionID string) (*entity.GatewayResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	tx, ok := g.transactions[transactionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", entity.ErrGatewayTransactionNotFound, transactionID)
+	}
+	if g.outcome(OperationStatus, tx.result.Amount, tx.cardToken) == OutcomeTimeout {
+		return nil, fmt.Errorf("%w: статус %s", entity.ErrGatewayTimeout, transactionID)
+	}
+	return g.copyResult(tx), nil
+}
+
+// outcome возвращает исход операции по первому подходящему правилу; пустой исход - успех
+func (g *FakeGateway) outcome(op Operation, amount money.Amount, cardToken string) Outcome {
+	for _, rule := range g.rules {
+		if rule.matches(op, amount, cardToken) {
+			return rule.Outcome
+		}
+	}
+	return ""
+}
+
+// reply возвращает результат транзакции и ошибку отказа, если транзакция отклонена
+func (g *FakeGateway) reply(tx *fakeTransaction) (*entity.GatewayResult, error) {
+	result := g.copyResult(tx)
+	if result.Status == entity.GatewayStatusDeclined {
+		return result, fmt.Errorf("%w: %s", entity.ErrGatewayDeclined, result.DeclineReason)
+	}
+	return result, nil
+}
+
+// declined возвращает отказ в операции над транзакцией, не меняя ее состояние
+func (g *FakeGateway) declined(tx *fakeTransaction, reason string) (*entity.GatewayResult, error) {
+	result := g.copyResult(tx)
+	result.DeclineReason = reason
+	return result, fmt.Errorf("%w: %s", entity.ErrGatewayDeclined, reason)
+}
+
+// copyResult возвращает копию состояния транзакции, чтобы вызывающий код не менял состояние шлюза
+func (g *FakeGateway) copyResult(tx *fakeTransaction) *entity.GatewayResult {
+	result := tx.result
+	return &result
+}
@@ -0,0 +1,167 @@
+package gateway
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/director74/dz8_shop/payment-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+func authorizeRequest(reference string, amount money.Amount, cardToken string) entity.GatewayAuthorizeRequest {
+	return entity.GatewayAuthorizeRequest{
+		Reference: reference,
+		OrderID:   1,
+		Amount:    amount,
+		Currency:  money.DefaultCurrency,
+		CardToken: cardToken,
+	}
+}
+
+func TestParseFakeRules(t *testing.T) {
+	rules, err := ParseFakeRules("outcome=decline,amount=666.00; outcome=timeout,op=refund,token=tok_slow;")
+	assert.NoError(t, err)
+	assert.Equal(t, []FakeRule{
+		{Amount: money.FromMinor(66600), Outcome: OutcomeDecline},
+		{Operation: OperationRefund, CardToken: "tok_slow", Outcome: OutcomeTimeout},
+	}, rules)
+
+	rules, err = ParseFakeRules("")
+	assert.NoError(t, err)
+	assert.Empty(t, rules)
+
+	for _, spec := range []string{"amount=1.00", "outcome=explode", "outcome=decline,op=charge", "outcome=decline,amount=1.001", "outcome"} {
+		_, err := ParseFakeRules(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestFakeGateway_AuthorizeCaptureRefund(t *testing.T) {
+	ctx := context.Background()
+	g := NewFakeGateway()
+
+	auth, err := g.Authorize(ctx, authorizeRequest("payment-1", money.FromMinor(10000), ""))
+	assert.NoError(t, err)
+	assert.Equal(t, "FAKE-TRX-000001", auth.TransactionID)
+	assert.Equal(t, entity.GatewayStatusAuthorized, auth.Status)
+
+	// Повторная авторизация с тем же Reference возвращает ту же транзакцию
+	again, err := g.Authorize(ctx, authorizeRequest("payment-1", money.FromMinor(10000), ""))
+	assert.NoError(t, err)
+	assert.Equal(t, auth.TransactionID, again.TransactionID)
+
+	captured, err := g.Capture(ctx, auth.TransactionID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.GatewayStatusCaptured, captured.Status)
+	assert.Equal(t, money.FromMinor(10000), captured.CapturedAmount)
+
+	_, err = g.Void(ctx, auth.TransactionID)
+	assert.ErrorIs(t, err, entity.ErrGatewayInvalidState)
+
+	refund, err := g.Refund(ctx, auth.TransactionID, money.FromMinor(3000))
+	assert.NoError(t, err)
+	assert.Equal(t, "FAKE-RFD-000001", refund.RefundID)
+	assert.Equal(t, entity.GatewayStatusCaptured, refund.Status)
+
+	_, err = g.Refund(ctx, auth.TransactionID, money.FromMinor(8000))
+	assert.ErrorIs(t, err, entity.ErrGatewayInvalidState)
+
+	refund, err = g.Refund(ctx, auth.TransactionID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.GatewayStatusRefunded, refund.Status)
+	assert.Equal(t, money.FromMinor(10000), refund.RefundedAmount)
+
+	status, err := g.Status(ctx, auth.TransactionID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.GatewayStatusRefunded, status.Status)
+}
+
+func TestFakeGateway_Rules(t *testing.T) {
+	ctx := context.Background()
+	g := NewFakeGateway(append([]FakeRule{
+		{Amount: money.FromMinor(66600), Outcome: OutcomeDecline},
+		{Operation: OperationCapture, Amount: money.FromMinor(77700), Outcome: OutcomeTimeout},
+		{Operation: OperationRefund, Amount: money.FromMinor(100), Outcome: OutcomeDecline},
+	}, DefaultFakeRules()...)...)
+
+	// Отказ по сумме: транзакция сохраняется со статусом declined
+	declined, err := g.Authorize(ctx, authorizeRequest("payment-1", money.FromMinor(66600), ""))
+	assert.ErrorIs(t, err, entity.ErrGatewayDeclined)
+	assert.NotNil(t, declined)
+	assert.Equal(t, entity.GatewayStatusDeclined, declined.Status)
+
+	// Отказ и таймаут по тестовым токенам карт
+	_, err = g.Authorize(ctx, authorizeRequest("payment-2", money.FromMinor(100), CardTokenDeclined))
+	assert.ErrorIs(t, err, entity.ErrGatewayDeclined)
+	timedOut, err := g.Authorize(ctx, authorizeRequest("payment-3", money.FromMinor(100), CardTokenTimeout))
+	assert.ErrorIs(t, err, entity.ErrGatewayTimeout)
+	assert.Nil(t, timedOut)
+
+	// Таймаут списания оставляет авторизацию, которую можно отменить
+	auth, err := g.Authorize(ctx, authorizeRequest("payment-4", money.FromMinor(77700), ""))
+	assert.NoError(t, err)
+	_, err = g.Capture(ctx, auth.TransactionID, 0)
+	assert.ErrorIs(t, err, entity.ErrGatewayTimeout)
+	voided, err := g.Void(ctx, auth.TransactionID)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.GatewayStatusVoided, voided.Status)
+
+	// Отказ в возврате не меняет сумму возвратов
+	auth, err = g.Authorize(ctx, authorizeRequest("payment-5", money.FromMinor(500), ""))
+	assert.NoError(t, err)
+	_, err = g.Capture(ctx, auth.TransactionID, 0)
+	assert.NoError(t, err)
+	_, err = g.Refund(ctx, auth.TransactionID, money.FromMinor(100))
+	assert.ErrorIs(t, err, entity.ErrGatewayDeclined)
+	status, err := g.Status(ctx, auth.TransactionID)
+	assert.NoError(t, err)
+	assert.True(t, status.RefundedAmount.IsZero())
+
+	_, err = g.Status(ctx, "UNKNOWN")
+	assert.ErrorIs(t, err, entity.ErrGatewayTransactionNotFound)
+}
+
+func TestHTTPGateway_Stub(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeGateway(DefaultFakeRules()...)
+	server := httptest.NewServer(NewStubHandler(fake))
+	defer server.Close()
+
+	g := NewHTTPGateway(server.URL, 5*time.Second)
+
+	auth, err := g.Authorize(ctx, authorizeRequest("payment-1", money.FromMinor(12345), ""))
+	assert.NoError(t, err)
+	assert.Equal(t, entity.GatewayStatusAuthorized, auth.Status)
+	assert.Equal(t, money.FromMinor(12345), auth.Amount)
+
+	captured, err := g.Capture(ctx, auth.TransactionID, money.FromMinor(12345))
+	assert.NoError(t, err)
+	assert.Equal(t, entity.GatewayStatusCaptured, captured.Status)
+
+	refund, err := g.Refund(ctx, auth.TransactionID, money.FromMinor(45))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, refund.RefundID)
+	assert.Equal(t, money.FromMinor(45), refund.RefundedAmount)
+
+	status, err := g.Status(ctx, auth.TransactionID)
+	assert.NoError(t, err)
+	assert.Equal(t, money.FromMinor(45), status.RefundedAmount)
+
+	declined, err := g.Authorize(ctx, authorizeRequest("payment-2", money.FromMinor(100), CardTokenDeclined))
+	assert.ErrorIs(t, err, entity.ErrGatewayDeclined)
+	assert.NotNil(t, declined)
+	assert.Equal(t, entity.GatewayStatusDeclined, declined.Status)
+
+	_, err = g.Authorize(ctx, authorizeRequest("payment-3", money.FromMinor(100), CardTokenTimeout))
+	assert.ErrorIs(t, err, entity.ErrGatewayTimeout)
+
+	_, err = g.Void(ctx, auth.TransactionID)
+	assert.ErrorIs(t, err, entity.ErrGatewayInvalidState)
+
+	_, err = g.Status(ctx, "UNKNOWN")
+	assert.ErrorIs(t, err, entity.ErrGatewayTransactionNotFound)
+}
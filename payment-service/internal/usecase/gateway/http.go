@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/director74/dz8_shop/payment-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// HTTPGateway адаптер платежного шлюза с REST API:
+//
+//	POST /v1/authorizations                  - авторизация
+//	POST /v1/transactions/{id}/capture       - списание
+//	POST /v1/transactions/{id}/void          - отмена авторизации
+//	POST /v1/transactions/{id}/refunds       - возврат
+//	GET  /v1/transactions/{id}               - статус транзакции
+//
+// Шлюз отвечает состоянием транзакции (entity.GatewayResult). Отказ передается статусом 402,
+// неизвестная транзакция - 404, недопустимая операция - 409, таймаут на стороне шлюза - 504.
+// Тот же API реализует NewStubHandler, поэтому адаптер можно направить на локальную заглушку.
+type HTTPGateway struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewHTTPGateway создает адаптер шлюза по адресу baseURL с таймаутом запросов timeout
+func NewHTTPGateway(baseURL string, timeout time.Duration) *HTTPGateway {
+	return &HTTPGateway{
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// Authorize блокирует сумму платежа
+func (g *HTTPGateway) Authorize(ctx context.Context, req entity.GatewayAuthorizeRequest) (*entity.GatewayResult, error) {
+	return g.do(ctx, http.MethodPost, "/v1/authorizations", req)
+}
+
+// Capture списывает заблокированную сумму
+func (g *HTTPGateway) Capture(ctx context.Context, transactionID string, amount money.Amount) (*entity.GatewayResult, error) {
+	return g.do(ctx, http.MethodPost, transactionPath(transactionID, "/capture"), entity.GatewayAmountRequest{Amount: amount})
+}
+
+// Void снимает блокировку суммы
+func (g *HTTPGateway) Void(ctx context.Context, transactionID string) (*entity.GatewayResult, error) {
+	return g.do(ctx, http.MethodPost, transactionPath(transactionID, "/void"), nil)
+}
+
+// Refund возвращает часть списанной суммы
+func (g *HTTPGateway) Refund(ctx context.Context, transactionID string, amount money.Amount) (*entity.GatewayResult, error) {
+	return g.do(ctx, http.MethodPost, transactionPath(transactionID, "/refunds"), entity.GatewayAmountRequest{Amount: amount})
+}
+
+// Status возвращает текущее состояние транзакции
+func (g *HTTPGateway) Status(ctx context.Context, transactionID string) (*entity.GatewayResult, error) {
+	return g.do(ctx, http.MethodGet, transactionPath(transactionID, ""), nil)
+}
+
+// do выполняет запрос к шлюзу и переводит ответ в результат или ошибку шлюза
+func (g *HTTPGateway) do(ctx context.Context, method, path string, body interface{}) (*entity.GatewayResult, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBodyJSON, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при маршалинге запроса: %w", err)
+		}
+		reqBody = bytes.NewBuffer(reqBodyJSON)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, g.baseURL+path, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при создании запроса: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, fmt.Errorf("%w: %s %s: %v", entity.ErrGatewayTimeout, method, path, err)
+		}
+		return nil, fmt.Errorf("ошибка при выполнении запроса к платежному шлюзу: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusPaymentRequired:
+		var result entity.GatewayResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return nil, fmt.Errorf("ошибка при декодировании ответа платежного шлюза: %w", err)
+		}
+		if resp.StatusCode == http.StatusPaymentRequired {
+			return &result, fmt.Errorf("%w: %s", entity.ErrGatewayDeclined, result.DeclineReason)
+		}
+		return &result, nil
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", entity.ErrGatewayTransactionNotFound, path)
+	case http.StatusConflict:
+		return nil, fmt.Errorf("%w: %s", entity.ErrGatewayInvalidState, responseError(resp))
+	case http.StatusGatewayTimeout:
+		return nil, fmt.Errorf("%w: %s %s", entity.ErrGatewayTimeout, method, path)
+	default:
+		return nil, fmt.Errorf("неуспешный ответ от платежного шлюза: %s: %s", resp.Status, responseError(resp))
+	}
+}
+
+// transactionPath возвращает путь ресурса транзакции
+func transactionPath(transactionID, suffix string) string {
+	return "/v1/transactions/" + url.PathEscape(transactionID) + suffix
+}
+
+// isTimeout проверяет, что запрос не уложился в таймаут клиента или контекста
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// responseError возвращает текст ошибки из ответа шлюза
+func responseError(resp *http.Response) string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+		return resp.Status
+	}
+	return body.Error
+}
@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/director74/dz8_shop/payment-service/internal/entity"
+)
+
+// NewStubHandler создает HTTP заглушку платежного шлюза с API, которое ожидает HTTPGateway.
+// Операции выполняет фейковый шлюз fake, поэтому сценарии отказов и таймаутов задаются его правилами.
+func NewStubHandler(fake *FakeGateway) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /v1/authorizations", func(w http.ResponseWriter, r *http.Request) {
+		var req entity.GatewayAuthorizeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeStubJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		result, err := fake.Authorize(r.Context(), req)
+		writeStubResult(w, http.StatusCreated, result, err)
+	})
+
+	mux.HandleFunc("POST /v1/transactions/{id}/capture", func(w http.ResponseWriter, r *http.Request) {
+		var req entity.GatewayAmountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeStubJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		result, err := fake.Capture(r.Context(), r.PathValue("id"), req.Amount)
+		writeStubResult(w, http.StatusOK, result, err)
+	})
+
+	mux.HandleFunc("POST /v1/transactions/{id}/void", func(w http.ResponseWriter, r *http.Request) {
+		result, err := fake.Void(r.Context(), r.PathValue("id"))
+		writeStubResult(w, http.StatusOK, result, err)
+	})
+
+	mux.HandleFunc("POST /v1/transactions/{id}/refunds", func(w http.ResponseWriter, r *http.Request) {
+		var req entity.GatewayAmountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeStubJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		result, err := fake.Refund(r.Context(), r.PathValue("id"), req.Amount)
+		writeStubResult(w, http.StatusCreated, result, err)
+	})
+
+	mux.HandleFunc("GET /v1/transactions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		result, err := fake.Status(r.Context(), r.PathValue("id"))
+		writeStubResult(w, http.StatusOK, result, err)
+	})
+
+	return mux
+}
+
+// writeStubResult пишет ответ заглушки: результат операции или ошибку шлюза с соответствующим статусом
+func writeStubResult(w http.ResponseWriter, status int, result *entity.GatewayResult, err error) {
+	switch {
+	case err == nil:
+		writeStubJSON(w, status, result)
+	case errors.Is(err, entity.ErrGatewayDeclined) && result != nil:
+		writeStubJSON(w, http.StatusPaymentRequired, result)
+	case errors.Is(err, entity.ErrGatewayTransactionNotFound):
+		writeStubJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, entity.ErrGatewayInvalidState):
+		writeStubJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, entity.ErrGatewayTimeout):
+		writeStubJSON(w, http.StatusGatewayTimeout, map[string]string{"error": err.Error()})
+	default:
+		writeStubJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
+// writeStubJSON пишет JSON ответ заглушки
+func writeStubJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,23 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/director74/dz8_shop/payment-service/internal/entity"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// PaymentGateway платежный шлюз (PSP). Отказ шлюза возвращается ошибкой entity.ErrGatewayDeclined
+// вместе с результатом операции, отсутствие ответа - ошибкой entity.ErrGatewayTimeout.
+type PaymentGateway interface {
+	// Authorize блокирует сумму платежа на карте клиента
+	Authorize(ctx context.Context, req entity.GatewayAuthorizeRequest) (*entity.GatewayResult, error)
+	// Capture списывает заблокированную сумму; amount 0 - всю сумму авторизации
+	Capture(ctx context.Context, transactionID string, amount money.Amount) (*entity.GatewayResult, error)
+	// Void снимает блокировку суммы, которая еще не списана
+	Void(ctx context.Context, transactionID string) (*entity.GatewayResult, error)
+	// Refund возвращает часть списанной суммы; amount 0 - весь невозвращенный остаток
+	Refund(ctx context.Context, transactionID string, amount money.Amount) (*entity.GatewayResult, error)
+	// Status возвращает текущее состояние транзакции в шлюзе
+	Status(ctx context.Context, transactionID string) (*entity.GatewayResult, error)
+}
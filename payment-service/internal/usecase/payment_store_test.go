@@ -0,0 +1,244 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/director74/dz8_shop/payment-service/internal/entity"
+	"github.com/director74/dz8_shop/payment-service/internal/repo"
+	"github.com/director74/dz8_shop/payment-service/internal/usecase/gateway"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// paymentStore подменяет PaymentRepository в тестах usecase платежей. WithTransaction передает в fn само
+// хранилище с tx=nil, поэтому публикация идет через обычный publisher, а не outbox; при ошибке fn
+// восстанавливаются платежи и возвраты, сохраненные до ее вызова. Способы оплаты в откат не входят.
+type paymentStore struct {
+	payments []entity.Payment
+	refunds  []entity.Refund
+	methods  []entity.PaymentMethod
+}
+
+var _ repo.PaymentRepository = (*paymentStore)(nil)
+
+// addPayment добавляет платеж и возвращает его ID
+func (s *paymentStore) addPayment(payment entity.Payment) uint {
+	payment.ID = uint(len(s.payments) + 1)
+	s.payments = append(s.payments, payment)
+	return payment.ID
+}
+
+// payment возвращает текущее состояние платежа
+func (s *paymentStore) payment(id uint) entity.Payment {
+	for _, payment := range s.payments {
+		if payment.ID == id {
+			return payment
+		}
+	}
+	return entity.Payment{}
+}
+
+// find возвращает указатель на платеж для изменения
+func (s *paymentStore) find(id uint) *entity.Payment {
+	for i := range s.payments {
+		if s.payments[i].ID == id {
+			return &s.payments[i]
+		}
+	}
+	return nil
+}
+
+func (s *paymentStore) CreatePayment(payment *entity.Payment) error {
+	payment.ID = uint(len(s.payments) + 1)
+	s.payments = append(s.payments, *payment)
+	return nil
+}
+
+func (s *paymentStore) GetPaymentByID(id uint) (*entity.Payment, error) {
+	payment := s.find(id)
+	if payment == nil {
+		return nil, nil
+	}
+	found := *payment
+	return &found, nil
+}
+
+func (s *paymentStore) GetPaymentByOrderID(orderID uint) (*entity.Payment, error) {
+	for _, payment := range s.payments {
+		if payment.OrderID == orderID {
+			return &payment, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *paymentStore) UpdatePaymentStatus(id uint, status entity.PaymentStatus, transactionID string) error {
+	if payment := s.find(id); payment != nil {
+		payment.Status = status
+		payment.TransactionID = transactionID
+	}
+	return nil
+}
+
+func (s *paymentStore) GetPaymentsByUserID(userID uint) ([]entity.Payment, error) {
+	var payments []entity.Payment
+	for _, payment := range s.payments {
+		if payment.UserID == userID {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+func (s *paymentStore) LockPaymentByID(id uint) (*entity.Payment, error) {
+	return s.GetPaymentByID(id)
+}
+
+func (s *paymentStore) UpdateRefundedAmount(id uint, refunded money.Amount, status entity.PaymentStatus) error {
+	if payment := s.find(id); payment != nil {
+		payment.RefundedAmount = refunded
+		payment.Status = status
+	}
+	return nil
+}
+
+func (s *paymentStore) MarkAuthorized(id uint, transactionID string, expiresAt time.Time) error {
+	if payment := s.find(id); payment != nil {
+		payment.Status = entity.PaymentStatusAuthorized
+		payment.TransactionID = transactionID
+		payment.AuthorizationExpiresAt = &expiresAt
+	}
+	return nil
+}
+
+func (s *paymentStore) GetExpiredAuthorizations(now time.Time, limit int) ([]entity.Payment, error) {
+	var payments []entity.Payment
+	for _, payment := range s.payments {
+		if payment.Status == entity.PaymentStatusAuthorized && payment.AuthorizationExpiresAt != nil &&
+			payment.AuthorizationExpiresAt.Before(now) && len(payments) < limit {
+			payments = append(payments, payment)
+		}
+	}
+	return payments, nil
+}
+
+func (s *paymentStore) CreateRefund(refund *entity.Refund) error {
+	refund.ID = uint(len(s.refunds) + 1)
+	s.refunds = append(s.refunds, *refund)
+	return nil
+}
+
+func (s *paymentStore) GetRefundsByPaymentID(paymentID uint) ([]entity.Refund, error) {
+	var refunds []entity.Refund
+	for _, refund := range s.refunds {
+		if refund.PaymentID == paymentID {
+			refunds = append(refunds, refund)
+		}
+	}
+	return refunds, nil
+}
+
+func (s *paymentStore) CreatePaymentMethod(method *entity.PaymentMethod) error {
+	method.ID = uint(len(s.methods) + 1)
+	s.methods = append(s.methods, *method)
+	return nil
+}
+
+func (s *paymentStore) GetPaymentMethodsByUserID(userID uint) ([]entity.PaymentMethod, error) {
+	var methods []entity.PaymentMethod
+	for _, method := range s.methods {
+		if method.UserID == userID {
+			methods = append(methods, method)
+		}
+	}
+	return methods, nil
+}
+
+func (s *paymentStore) GetDefaultPaymentMethod(userID uint) (*entity.PaymentMethod, error) {
+	for _, method := range s.methods {
+		if method.UserID == userID && method.IsDefault {
+			return &method, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *paymentStore) SetDefaultPaymentMethod(id uint, userID uint) error {
+	for i := range s.methods {
+		if s.methods[i].UserID == userID {
+			s.methods[i].IsDefault = s.methods[i].ID == id
+		}
+	}
+	return nil
+}
+
+func (s *paymentStore) WithTransaction(fn func(txRepo repo.PaymentRepository, tx *gorm.DB) error) error {
+	payments := append([]entity.Payment(nil), s.payments...)
+	refunds := append([]entity.Refund(nil), s.refunds...)
+	if err := fn(s, nil); err != nil {
+		s.payments, s.refunds = payments, refunds
+		return err
+	}
+	return nil
+}
+
+// publishedMessage сообщение, отправленное через recordingPublisher
+type publishedMessage struct {
+	routingKey string
+	message    interface{}
+}
+
+// recordingPublisher запоминает опубликованные сообщения
+type recordingPublisher struct {
+	messages []publishedMessage
+}
+
+func (p *recordingPublisher) PublishMessage(exchange, routingKey string, message interface{}) error {
+	p.messages = append(p.messages, publishedMessage{routingKey: routingKey, message: message})
+	return nil
+}
+
+func (p *recordingPublisher) PublishMessageWithRetry(exchange, routingKey string, message interface{}, retries int) error {
+	return p.PublishMessage(exchange, routingKey, message)
+}
+
+// routingKeys возвращает ключи маршрутизации опубликованных сообщений по порядку
+func (p *recordingPublisher) routingKeys() []string {
+	keys := make([]string, 0, len(p.messages))
+	for _, m := range p.messages {
+		keys = append(keys, m.routingKey)
+	}
+	return keys
+}
+
+// newTestPaymentUseCase создает usecase с paymentStore, фейковым шлюзом и публикатором, запоминающим сообщения
+func newTestPaymentUseCase(rules ...gateway.FakeRule) (*PaymentUseCase, *paymentStore, *gateway.FakeGateway, *recordingPublisher) {
+	store := &paymentStore{}
+	gw := gateway.NewFakeGateway(rules...)
+	publisher := &recordingPublisher{}
+	return NewPaymentUseCase(store, gw, publisher, "payment_events"), store, gw, publisher
+}
+
+// addCapturedPayment добавляет платеж пользователя 1 на сумму amount, списанный в шлюзе gw
+func addCapturedPayment(t *testing.T, store *paymentStore, gw *gateway.FakeGateway, amount money.Amount) uint {
+	ctx := context.Background()
+	auth, err := gw.Authorize(ctx, entity.GatewayAuthorizeRequest{Reference: "captured", OrderID: 7, Amount: amount, Currency: money.DefaultCurrency})
+	if err != nil {
+		t.Fatalf("не удалось авторизовать платеж в шлюзе: %v", err)
+	}
+	if _, err := gw.Capture(ctx, auth.TransactionID, amount); err != nil {
+		t.Fatalf("не удалось списать платеж в шлюзе: %v", err)
+	}
+	return store.addPayment(entity.Payment{
+		OrderID:       7,
+		UserID:        1,
+		Amount:        amount,
+		Currency:      money.DefaultCurrency,
+		PaymentMethod: "credit_card",
+		Status:        entity.PaymentStatusCompleted,
+		TransactionID: auth.TransactionID,
+	})
+}
@@ -6,117 +6,143 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"math/rand"
 	"time"
 
 	"github.com/director74/dz8_shop/payment-service/internal/entity"
 	"github.com/director74/dz8_shop/payment-service/internal/repo"
 	"github.com/director74/dz8_shop/pkg/messaging"
+	"github.com/director74/dz8_shop/pkg/money"
+	"github.com/director74/dz8_shop/pkg/outbox"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrCurrencyMismatch валюта платежа или возврата не совпадает с валютой заказа
+	ErrCurrencyMismatch = money.ErrCurrencyMismatch
+	// ErrInvalidCurrency некорректный код валюты
+	ErrInvalidCurrency = money.ErrInvalidCurrency
 )
 
 // PaymentUseCaseInterface определяет интерфейс для работы с платежами в саге
 type PaymentUseCaseInterface interface {
-	CreatePayment(ctx context.Context, req *entity.CreatePaymentRequest) (*entity.Payment, error)
-	RefundPayment(ctx context.Context, req *entity.RefundPaymentRequest) error
+	AuthorizePayment(ctx context.Context, req *entity.CreatePaymentRequest) (*entity.Payment, error)
+	CapturePayment(ctx context.Context, paymentID uint) (*entity.Payment, error)
+	RefundPayment(ctx context.Context, req *entity.RefundPaymentRequest) (entity.PaymentStatus, error)
 	GetPaymentForOrder(orderID uint) (*entity.Payment, error)
 }
 
 // PaymentUseCase реализует бизнес-логику для платежей
 type PaymentUseCase struct {
 	paymentRepo  repo.PaymentRepository
+	gateway      PaymentGateway
 	publisher    messaging.MessagePublisher
 	exchangeName string
+
+	authorizationTTL time.Duration
 }
 
 // NewPaymentUseCase создает новый use case для платежей
-func NewPaymentUseCase(paymentRepo repo.PaymentRepository, publisher messaging.MessagePublisher, exchangeName string) *PaymentUseCase {
+func NewPaymentUseCase(paymentRepo repo.PaymentRepository, gateway PaymentGateway, publisher messaging.MessagePublisher, exchangeName string) *PaymentUseCase {
 	return &PaymentUseCase{
 		paymentRepo:  paymentRepo,
+		gateway:      gateway,
 		publisher:    publisher,
 		exchangeName: exchangeName,
-	}
-}
-
-// CreatePayment создает новый платеж в рамках саги
-func (uc *PaymentUseCase) CreatePayment(ctx context.Context, req *entity.CreatePaymentRequest) (*entity.Payment, error) {
-	// Создаем новый платеж
-	payment := &entity.Payment{
-		OrderID:       req.OrderID,
-		UserID:        req.UserID,
-		Amount:        req.Amount,
-		PaymentMethod: req.PaymentType,
-		Status:        entity.PaymentStatusPending,
-	}
 
-	// Сохраняем платеж
-	if err := uc.paymentRepo.CreatePayment(payment); err != nil {
-		return nil, fmt.Errorf("ошибка создания платежа: %w", err)
+		authorizationTTL: defaultAuthorizationTTL,
 	}
+}
 
-	// Эмулируем процесс платежа (в реальной системе здесь был бы вызов внешнего платежного шлюза)
-	success, transactionID := uc.simulatePaymentGateway(payment.Amount)
-
-	var status entity.PaymentStatus
-	var paymentErr error // Переменная для хранения ошибки
-
-	if !success {
-		status = entity.PaymentStatusFailed
-		// Генерируем ошибку, если симуляция не удалась
-		paymentErr = errors.New("сбой обработки платежа (симуляция)")
-		log.Printf("Сработал неудачный исход при симуляции платежа для OrderID=%d, Amount=%.2f", payment.OrderID, payment.Amount)
-	} else {
-		status = entity.PaymentStatusCompleted
-		paymentErr = nil // Нет ошибки, если успешно
+// UseAuthorizationTTL задает срок, в течение которого авторизованный в саге платеж должен быть списан
+func (uc *PaymentUseCase) UseAuthorizationTTL(ttl time.Duration) {
+	if ttl > 0 {
+		uc.authorizationTTL = ttl
 	}
+}
 
-	// Обновляем статус платежа в любом случае (успех или неудача)
-	if err := uc.paymentRepo.UpdatePaymentStatus(payment.ID, status, transactionID); err != nil {
-		// Ошибка при обновлении статуса - это более серьезная проблема, возвращаем ее
-		return nil, fmt.Errorf("ошибка обновления статуса платежа на %s: %w", status, err)
+// txPublisher возвращает публикатор для транзакции tx.
+// Outbox сохраняет сообщение в той же транзакции, что и изменение статуса платежа.
+func (uc *PaymentUseCase) txPublisher(tx *gorm.DB) messaging.MessagePublisher {
+	if p, ok := uc.publisher.(*outbox.Publisher); ok {
+		return p.WithTx(tx)
 	}
-
-	// Обновляем локальный объект payment с новыми значениями
-	payment.Status = status
-	payment.TransactionID = transactionID
-
-	// Возвращаем объект платежа и ошибку, если она была
-	return payment, paymentErr
+	return uc.publisher
 }
 
-// RefundPayment выполняет возврат платежа в рамках саги
-func (uc *PaymentUseCase) RefundPayment(ctx context.Context, req *entity.RefundPaymentRequest) error {
+// RefundPayment компенсирует платеж саги: с авторизованного платежа снимается блокировка суммы,
+// по списанному возвращается весь еще не возвращенный остаток. Возвращает итоговый статус платежа.
+// Повторная компенсация уже возвращенного или отмененного платежа ничего не меняет.
+func (uc *PaymentUseCase) RefundPayment(ctx context.Context, req *entity.RefundPaymentRequest) (entity.PaymentStatus, error) {
 	payment, err := uc.paymentRepo.GetPaymentByID(req.PaymentID)
 	if err != nil {
-		return fmt.Errorf("ошибка получения платежа: %w", err)
+		return "", fmt.Errorf("ошибка получения платежа: %w", err)
 	}
 
 	if payment == nil {
-		return errors.New("платеж не найден")
+		return "", ErrPaymentNotFound
 	}
 
-	// Проверяем, можно ли выполнить возврат
-	if payment.Status != entity.PaymentStatusCompleted && payment.Status != entity.PaymentStatusPending {
-		return fmt.Errorf("невозможно выполнить возврат для платежа в статусе %s", payment.Status)
+	// Возврат выполняется только в валюте платежа
+	if req.Currency != "" && req.Currency != payment.Currency.OrDefault() {
+		return "", fmt.Errorf("%w: платеж %d в %s, возврат в %s", ErrCurrencyMismatch, payment.ID, payment.Currency.OrDefault(), req.Currency)
 	}
 
-	// Обновляем статус платежа на возвращенный
-	if err := uc.paymentRepo.UpdatePaymentStatus(payment.ID, entity.PaymentStatusRefunded, payment.TransactionID); err != nil {
-		return fmt.Errorf("ошибка обновления статуса платежа: %w", err)
+	switch payment.Status {
+	case entity.PaymentStatusRefunded, entity.PaymentStatusVoided, entity.PaymentStatusExpired, entity.PaymentStatusFailed:
+		return payment.Status, nil
+	case entity.PaymentStatusAuthorized:
+		// Деньги еще не списаны: снимаем блокировку суммы
+		released, err := uc.releaseAuthorization(ctx, payment.ID, entity.PaymentStatusVoided)
+		if err != nil {
+			return "", err
+		}
+		if released.Status != entity.PaymentStatusCompleted && released.Status != entity.PaymentStatusPartiallyRefunded {
+			return released.Status, nil
+		}
+		// Платеж успели списать до компенсации - возвращаем остаток
+		return uc.refundRemainder(ctx, payment.ID)
+	case entity.PaymentStatusCompleted, entity.PaymentStatusPartiallyRefunded:
+		return uc.refundRemainder(ctx, payment.ID)
+	case entity.PaymentStatusPending:
+		// Деньги по платежу еще не получены: платеж только помечается возвращенным
+		err := uc.paymentRepo.WithTransaction(func(txRepo repo.PaymentRepository, tx *gorm.DB) error {
+			if err := txRepo.UpdatePaymentStatus(payment.ID, entity.PaymentStatusRefunded, payment.TransactionID); err != nil {
+				return fmt.Errorf("ошибка обновления статуса платежа: %w", err)
+			}
+			return uc.publishPaymentRefund(uc.txPublisher(tx), payment)
+		})
+		if err != nil {
+			return "", err
+		}
+		return entity.PaymentStatusRefunded, nil
+	default:
+		return "", fmt.Errorf("невозможно выполнить возврат для платежа в статусе %s", payment.Status)
 	}
+}
 
-	// Отправляем событие о возврате платежа (опционально)
-	uc.publishPaymentRefund(payment)
-
-	return nil
+// refundRemainder возвращает весь еще не возвращенный остаток списанного платежа при компенсации саги.
+// О компенсации сообщает результат шага саги, поэтому событие payment.refund.created не публикуется.
+func (uc *PaymentUseCase) refundRemainder(ctx context.Context, paymentID uint) (entity.PaymentStatus, error) {
+	refund, err := uc.refund(ctx, paymentID, 0, "", "Компенсация саги заказа", false)
+	if err != nil {
+		return "", err
+	}
+	return refund.PaymentStatus, nil
 }
 
 // ProcessPayment обрабатывает платеж
 func (uc *PaymentUseCase) ProcessPayment(paymentReq *entity.PaymentRequest) (*entity.PaymentConfirmation, error) {
+	currency, err := uc.orderCurrency(paymentReq.OrderID, paymentReq.Currency)
+	if err != nil {
+		return nil, err
+	}
+
 	// Создаем новый платеж
 	payment := &entity.Payment{
 		OrderID:       paymentReq.OrderID,
 		UserID:        paymentReq.UserID,
 		Amount:        paymentReq.Amount,
+		Currency:      currency,
 		PaymentMethod: paymentReq.PaymentMethod,
 		Status:        entity.PaymentStatusPending,
 	}
@@ -126,33 +152,42 @@ func (uc *PaymentUseCase) ProcessPayment(paymentReq *entity.PaymentRequest) (*en
 		return nil, fmt.Errorf("ошибка создания платежа: %w", err)
 	}
 
-	// Эмулируем процесс платежа (в реальной системе здесь был бы вызов внешнего платежного шлюза)
-	success, transactionID := uc.simulatePaymentGateway(payment.Amount)
+	// Проводим платеж через платежный шлюз
+	transactionID, chargeErr := uc.charge(context.Background(), payment, paymentReq.CardToken)
 
 	status := entity.PaymentStatusCompleted
 	message := "Платеж успешно обработан"
 
-	if !success {
+	if chargeErr != nil {
 		status = entity.PaymentStatusFailed
 		message = "Платеж не прошел"
+		if errors.Is(chargeErr, entity.ErrGatewayTimeout) {
+			message = "Платеж не прошел: платежный шлюз не ответил"
+		}
+		log.Printf("Платеж для OrderID=%d, Amount=%s не прошел: %v", payment.OrderID, payment.Amount, chargeErr)
 	}
 
-	// Обновляем статус платежа
-	if err := uc.paymentRepo.UpdatePaymentStatus(payment.ID, status, transactionID); err != nil {
-		return nil, fmt.Errorf("ошибка обновления статуса платежа: %w", err)
-	}
+	// Обновляем статус платежа и сохраняем событие с результатом в одной транзакции
+	err = uc.paymentRepo.WithTransaction(func(txRepo repo.PaymentRepository, tx *gorm.DB) error {
+		if err := txRepo.UpdatePaymentStatus(payment.ID, status, transactionID); err != nil {
+			return fmt.Errorf("ошибка обновления статуса платежа: %w", err)
+		}
 
-	// Обновляем локальный объект payment с новыми значениями
-	payment.Status = status
-	payment.TransactionID = transactionID
+		// Обновляем локальный объект payment с новыми значениями
+		payment.Status = status
+		payment.TransactionID = transactionID
 
-	// Отправляем событие с результатом платежа
-	uc.publishPaymentResult(payment)
+		return uc.publishPaymentResult(uc.txPublisher(tx), payment)
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	return &entity.PaymentConfirmation{
 		PaymentID:     payment.ID,
 		OrderID:       payment.OrderID,
 		Amount:        payment.Amount,
+		Currency:      payment.Currency,
 		Status:        status,
 		TransactionID: transactionID,
 		Message:       message,
@@ -170,20 +205,33 @@ func (uc *PaymentUseCase) CancelPayment(paymentID uint) error {
 		return errors.New("платеж не найден")
 	}
 
+	// С авторизованного платежа снимаем блокировку суммы в платежном шлюзе
+	if payment.Status == entity.PaymentStatusAuthorized {
+		if _, err := uc.releaseAuthorization(context.Background(), paymentID, entity.PaymentStatusCancelled); err != nil {
+			// Даже если произошла ошибка, компенсацию нужно продолжить
+			log.Printf("Ошибка при отмене авторизации платежа %d: %v", paymentID, err)
+		}
+		return nil
+	}
+
 	// Проверяем, можно ли отменить платеж
 	if payment.Status != entity.PaymentStatusPending && payment.Status != entity.PaymentStatusCompleted {
 		return fmt.Errorf("невозможно отменить платеж в статусе %s", payment.Status)
 	}
 
-	// Обновляем статус платежа
-	if err := uc.paymentRepo.UpdatePaymentStatus(paymentID, entity.PaymentStatusCancelled, payment.TransactionID); err != nil {
-		// Даже если произошла ошибка обновления статуса, компенсацию нужно продолжить
-		log.Printf("Ошибка при обновлении статуса платежа %d на cancelled: %v", paymentID, err)
-	}
-	payment.Status = entity.PaymentStatusCancelled
+	// Обновляем статус платежа и сохраняем событие об отмене в одной транзакции
+	err = uc.paymentRepo.WithTransaction(func(txRepo repo.PaymentRepository, tx *gorm.DB) error {
+		if err := txRepo.UpdatePaymentStatus(paymentID, entity.PaymentStatusCancelled, payment.TransactionID); err != nil {
+			return fmt.Errorf("ошибка обновления статуса платежа: %w", err)
+		}
+		payment.Status = entity.PaymentStatusCancelled
 
-	// Отправляем событие об отмене платежа
-	uc.publishPaymentCancellation(payment)
+		return uc.publishPaymentCancellation(uc.txPublisher(tx), payment)
+	})
+	if err != nil {
+		// Даже если произошла ошибка, компенсацию нужно продолжить
+		log.Printf("Ошибка при отмене платежа %d: %v", paymentID, err)
+	}
 
 	return nil
 }
@@ -215,17 +263,57 @@ func (uc *PaymentUseCase) GetPaymentsByUserID(userID uint) ([]entity.Payment, er
 	return payments, nil
 }
 
-// simulatePaymentGateway имитирует обработку платежа через платежный шлюз
-func (uc *PaymentUseCase) simulatePaymentGateway(amount float64) (bool, string) {
-	// Имитация задержки обработки платежа
-	time.Sleep(time.Millisecond * 200)
+// orderCurrency разбирает валюту платежа по заказу orderID. Пустая валюта означает валюту по умолчанию.
+// Все платежи по одному заказу выполняются в одной валюте: платеж в другой валюте отклоняется.
+func (uc *PaymentUseCase) orderCurrency(orderID uint, requested money.Currency) (money.Currency, error) {
+	currency, err := money.ParseCurrency(string(requested))
+	if err != nil {
+		return "", err
+	}
+
+	existing, err := uc.paymentRepo.GetPaymentByOrderID(orderID)
+	if err != nil {
+		return "", fmt.Errorf("ошибка проверки существующего платежа: %w", err)
+	}
+	if existing != nil && existing.Currency.OrDefault() != currency {
+		return "", fmt.Errorf("%w: заказ %d оплачивается в %s, платеж в %s", ErrCurrencyMismatch, orderID, existing.Currency.OrDefault(), currency)
+	}
+	return currency, nil
+}
+
+// charge авторизует и сразу списывает сумму платежа в платежном шлюзе. Если списание не прошло,
+// авторизация отменяется, чтобы сумма не оставалась заблокированной на карте клиента.
+// Возвращает идентификатор транзакции шлюза, если шлюз успел ее создать.
+func (uc *PaymentUseCase) charge(ctx context.Context, payment *entity.Payment, cardToken string) (string, error) {
+	transactionID, err := uc.authorize(ctx, payment, cardToken)
+	if err != nil {
+		return transactionID, err
+	}
 
-	// Генерация случайного ID транзакции
-	transactionID := fmt.Sprintf("TRX-%d", rand.Intn(1000000))
+	if _, err := uc.gateway.Capture(ctx, transactionID, payment.Amount); err != nil {
+		if _, voidErr := uc.gateway.Void(ctx, transactionID); voidErr != nil {
+			log.Printf("Ошибка отмены авторизации %s платежа %d: %v", transactionID, payment.ID, voidErr)
+		}
+		return transactionID, fmt.Errorf("ошибка списания платежа: %w", err)
+	}
 
-	// 98% платежей успешны (для тестирования), 2% - неуспешны
-	success := rand.Float64() < 0.98
-	return success, transactionID
+	return transactionID, nil
+}
+
+// GatewayStatus возвращает состояние транзакции платежа paymentID в платежном шлюзе
+func (uc *PaymentUseCase) GatewayStatus(ctx context.Context, paymentID uint) (*entity.GatewayResult, error) {
+	payment, err := uc.paymentRepo.GetPaymentByID(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения платежа: %w", err)
+	}
+	if payment == nil {
+		return nil, ErrPaymentNotFound
+	}
+	if payment.TransactionID == "" {
+		return nil, fmt.Errorf("%w: у платежа %d нет транзакции", entity.ErrGatewayTransactionNotFound, paymentID)
+	}
+
+	return uc.gateway.Status(ctx, payment.TransactionID)
 }
 
 // PaymentResultMessage Структура сообщения о результате платежа
@@ -233,7 +321,8 @@ type PaymentResultMessage struct {
 	PaymentID     uint                 `json:"payment_id"`
 	OrderID       uint                 `json:"order_id"`
 	UserID        uint                 `json:"user_id"`
-	Amount        float64              `json:"amount"`
+	Amount        money.Amount         `json:"amount"`
+	Currency      money.Currency       `json:"currency"`
 	Status        entity.PaymentStatus `json:"status"`
 	TransactionID string               `json:"transaction_id"`
 	Timestamp     int64                `json:"timestamp"`
@@ -244,19 +333,22 @@ type OrderEventMessage struct {
 	Type      string          `json:"type"`
 	OrderID   uint            `json:"order_id"`
 	UserID    uint            `json:"user_id"`
-	Amount    float64         `json:"amount"`
+	Amount    money.Amount    `json:"amount"`
+	Currency  money.Currency  `json:"currency"`
 	Status    string          `json:"status"`
 	Timestamp int64           `json:"timestamp"`
 	Data      json.RawMessage `json:"data,omitempty"`
+	HoldUntil *time.Time      `json:"hold_until,omitempty"` // Срок удержания средств заказа под поступление (order.backordered)
 }
 
-// publishPaymentResult публикует сообщение о результате платежа
-func (uc *PaymentUseCase) publishPaymentResult(payment *entity.Payment) {
+// publishPaymentResult публикует сообщение о результате платежа через publisher
+func (uc *PaymentUseCase) publishPaymentResult(publisher messaging.MessagePublisher, payment *entity.Payment) error {
 	message := PaymentResultMessage{
 		PaymentID:     payment.ID,
 		OrderID:       payment.OrderID,
 		UserID:        payment.UserID,
 		Amount:        payment.Amount,
+		Currency:      payment.Currency,
 		Status:        payment.Status,
 		TransactionID: payment.TransactionID,
 		Timestamp:     time.Now().Unix(),
@@ -269,46 +361,51 @@ func (uc *PaymentUseCase) publishPaymentResult(payment *entity.Payment) {
 	}
 
 	// Публикуем сообщение
-	err := messaging.PublishWithRetryAndLogging(uc.publisher, uc.exchangeName, routingKey, message, 3)
+	err := messaging.PublishWithRetryAndLogging(publisher, uc.exchangeName, routingKey, message, 3)
 	if err != nil {
-		log.Printf("Ошибка публикации сообщения о результате платежа: %v", err)
+		return fmt.Errorf("ошибка публикации сообщения о результате платежа: %w", err)
 	}
+	return nil
 }
 
-// publishPaymentCancellation публикует сообщение об отмене платежа
-func (uc *PaymentUseCase) publishPaymentCancellation(payment *entity.Payment) {
+// publishPaymentCancellation публикует сообщение об отмене платежа через publisher
+func (uc *PaymentUseCase) publishPaymentCancellation(publisher messaging.MessagePublisher, payment *entity.Payment) error {
 	message := PaymentResultMessage{
 		PaymentID:     payment.ID,
 		OrderID:       payment.OrderID,
 		UserID:        payment.UserID,
 		Amount:        payment.Amount,
+		Currency:      payment.Currency,
 		Status:        payment.Status,
 		TransactionID: payment.TransactionID,
 		Timestamp:     time.Now().Unix(),
 	}
 
-	err := messaging.PublishWithRetryAndLogging(uc.publisher, uc.exchangeName, "payment.cancelled", message, 3)
+	err := messaging.PublishWithRetryAndLogging(publisher, uc.exchangeName, "payment.cancelled", message, 3)
 	if err != nil {
-		log.Printf("Ошибка публикации сообщения об отмене платежа: %v", err)
+		return fmt.Errorf("ошибка публикации сообщения об отмене платежа: %w", err)
 	}
+	return nil
 }
 
-// publishPaymentRefund публикует сообщение о возврате платежа
-func (uc *PaymentUseCase) publishPaymentRefund(payment *entity.Payment) {
+// publishPaymentRefund публикует сообщение о возврате платежа через publisher
+func (uc *PaymentUseCase) publishPaymentRefund(publisher messaging.MessagePublisher, payment *entity.Payment) error {
 	message := PaymentResultMessage{
 		PaymentID:     payment.ID,
 		OrderID:       payment.OrderID,
 		UserID:        payment.UserID,
 		Amount:        payment.Amount,
+		Currency:      payment.Currency,
 		Status:        entity.PaymentStatusRefunded,
 		TransactionID: payment.TransactionID,
 		Timestamp:     time.Now().Unix(),
 	}
 
-	err := messaging.PublishWithRetryAndLogging(uc.publisher, uc.exchangeName, "payment.refunded", message, 3)
+	err := messaging.PublishWithRetryAndLogging(publisher, uc.exchangeName, "payment.refunded", message, 3)
 	if err != nil {
-		log.Printf("Ошибка публикации сообщения о возврате платежа: %v", err)
+		return fmt.Errorf("ошибка публикации сообщения о возврате платежа: %w", err)
 	}
+	return nil
 }
 
 // HandleOrderEvent обрабатывает события связанные с заказами
@@ -321,7 +418,7 @@ func (uc *PaymentUseCase) HandleOrderEvent(data []byte) error {
 
 	// Обработка события создания заказа
 	if event.Type == "order_created" {
-		log.Printf("Получено событие создания заказа: OrderID=%d, UserID=%d, Amount=%.2f",
+		log.Printf("Получено событие создания заказа: OrderID=%d, UserID=%d, Amount=%s",
 			event.OrderID, event.UserID, event.Amount)
 
 		// Проверяем, существует ли уже платеж для этого заказа
@@ -341,6 +438,7 @@ func (uc *PaymentUseCase) HandleOrderEvent(data []byte) error {
 			OrderID:       event.OrderID,
 			UserID:        event.UserID,
 			Amount:        event.Amount,
+			Currency:      event.Currency,
 			PaymentMethod: "credit_card", // Значение по умолчанию
 		}
 
@@ -377,6 +475,19 @@ func (uc *PaymentUseCase) HandleOrderEvent(data []byte) error {
 		return nil
 	}
 
+	// Заказ ждет поступления товара: блокировка суммы платежа продлевается на время ожидания
+	if event.Type == "order.backordered" {
+		if event.HoldUntil == nil {
+			log.Printf("Событие order.backordered для заказа %d без срока удержания средств, пропускаем", event.OrderID)
+			return nil
+		}
+		if _, err := uc.ExtendAuthorization(context.Background(), event.OrderID, *event.HoldUntil); err != nil {
+			log.Printf("Ошибка продления авторизации платежа заказа %d: %v", event.OrderID, err)
+			return err
+		}
+		return nil
+	}
+
 	log.Printf("Пропускаем необрабатываемое событие типа: %s", event.Type)
 	return nil
 }
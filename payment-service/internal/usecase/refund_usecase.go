@@ -0,0 +1,205 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/director74/dz8_shop/payment-service/internal/entity"
+	"github.com/director74/dz8_shop/payment-service/internal/repo"
+	"github.com/director74/dz8_shop/pkg/messaging"
+	"github.com/director74/dz8_shop/pkg/money"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrPaymentNotFound платеж не найден
+	ErrPaymentNotFound = errors.New("платеж не найден")
+	// ErrPaymentAccessDenied платеж принадлежит другому пользователю
+	ErrPaymentAccessDenied = errors.New("доступ к платежу запрещен")
+	// ErrPaymentNotRefundable платеж в текущем статусе нельзя вернуть
+	ErrPaymentNotRefundable = errors.New("возврат по платежу невозможен")
+	// ErrRefundExceedsPayment сумма возврата больше невозвращенного остатка платежа
+	ErrRefundExceedsPayment = errors.New("сумма возврата превышает невозвращенный остаток платежа")
+)
+
+// RefundMessage событие о возврате по платежу. Деньги к этому моменту уже возвращены на карту через
+// платежный шлюз, поэтому событие только информирует о возврате и не зачисляется на счет в биллинге.
+type RefundMessage struct {
+	RefundID       uint                 `json:"refund_id"`
+	PaymentID      uint                 `json:"payment_id"`
+	OrderID        uint                 `json:"order_id"`
+	UserID         uint                 `json:"user_id"`
+	Amount         money.Amount         `json:"amount"`
+	Currency       money.Currency       `json:"currency"`
+	RefundedAmount money.Amount         `json:"refunded_amount"`
+	PaymentStatus  entity.PaymentStatus `json:"payment_status"`
+	Reason         string               `json:"reason"`
+	Timestamp      int64                `json:"timestamp"`
+}
+
+// CreateRefund возвращает часть или всю сумму платежа paymentID. Возвратов может быть несколько,
+// пока их сумма не достигнет суммы платежа. Без суммы в запросе возвращается весь остаток.
+// Возврат проводят внутренние сервисы и служба поддержки (оформление возврата товара), клиент не может
+// вернуть платеж сам. Сумма возвращается на карту через платежный шлюз.
+func (uc *PaymentUseCase) CreateRefund(ctx context.Context, paymentID uint, req entity.CreateRefundRequest) (*entity.RefundResponse, error) {
+	var currency money.Currency
+	if req.Currency != "" {
+		parsed, err := money.ParseCurrency(string(req.Currency))
+		if err != nil {
+			return nil, err
+		}
+		currency = parsed
+	}
+
+	return uc.refund(ctx, paymentID, req.Amount, currency, req.Reason, true)
+}
+
+// ListRefunds возвращает возвраты по платежу paymentID. userID - владелец платежа; 0 - без проверки владельца.
+func (uc *PaymentUseCase) ListRefunds(paymentID, userID uint) (*entity.ListRefundsResponse, error) {
+	payment, err := uc.paymentRepo.GetPaymentByID(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения платежа: %w", err)
+	}
+	if payment == nil {
+		return nil, ErrPaymentNotFound
+	}
+	if userID != 0 && payment.UserID != userID {
+		return nil, ErrPaymentAccessDenied
+	}
+
+	refunds, err := uc.paymentRepo.GetRefundsByPaymentID(paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения возвратов платежа: %w", err)
+	}
+	if refunds == nil {
+		refunds = []entity.Refund{}
+	}
+
+	return &entity.ListRefundsResponse{
+		Refunds:        refunds,
+		RefundedAmount: payment.RefundedAmount,
+		Refundable:     refundable(payment),
+	}, nil
+}
+
+// refund проводит возврат amount (0 - весь остаток) по платежу через платежный шлюз и сохраняет его
+// в одной транзакции с обновлением суммы возвратов платежа. Если шлюз отклонил возврат, ничего не меняется.
+// При announce публикуется событие payment.refund.created; компенсацию саги о результате сообщает сама сага.
+func (uc *PaymentUseCase) refund(ctx context.Context, paymentID uint, amount money.Amount, currency money.Currency, reason string, announce bool) (*entity.RefundResponse, error) {
+	var response *entity.RefundResponse
+
+	err := uc.paymentRepo.WithTransaction(func(txRepo repo.PaymentRepository, tx *gorm.DB) error {
+		// Блокируем платеж, чтобы параллельные возвраты не превысили его сумму
+		payment, err := txRepo.LockPaymentByID(paymentID)
+		if err != nil {
+			return fmt.Errorf("ошибка получения платежа: %w", err)
+		}
+		if payment == nil {
+			return ErrPaymentNotFound
+		}
+		if payment.Status != entity.PaymentStatusCompleted && payment.Status != entity.PaymentStatusPartiallyRefunded {
+			return fmt.Errorf("%w: платеж %d в статусе %s", ErrPaymentNotRefundable, payment.ID, payment.Status)
+		}
+		if currency != "" && currency != payment.Currency.OrDefault() {
+			return fmt.Errorf("%w: платеж %d в %s, возврат в %s", ErrCurrencyMismatch, payment.ID, payment.Currency.OrDefault(), currency)
+		}
+
+		remaining := refundable(payment)
+		if amount == 0 {
+			amount = remaining
+		}
+		if amount <= 0 || amount > remaining {
+			return fmt.Errorf("%w: запрошено %s, доступно %s", ErrRefundExceedsPayment, amount, remaining)
+		}
+
+		// Платеж заблокирован до конца транзакции, поэтому возвраты по нему уходят в шлюз по одному
+		result, err := uc.gateway.Refund(ctx, payment.TransactionID, amount)
+		if err != nil {
+			return fmt.Errorf("ошибка возврата в платежном шлюзе: %w", err)
+		}
+
+		refund := &entity.Refund{
+			PaymentID:     payment.ID,
+			OrderID:       payment.OrderID,
+			UserID:        payment.UserID,
+			Amount:        amount,
+			Currency:      payment.Currency.OrDefault(),
+			Reason:        reason,
+			Status:        entity.RefundStatusCompleted,
+			TransactionID: result.RefundID,
+			CreatedAt:     time.Now(),
+		}
+		if err := txRepo.CreateRefund(refund); err != nil {
+			return fmt.Errorf("ошибка сохранения возврата: %w", err)
+		}
+
+		payment.RefundedAmount += amount
+		payment.Status = entity.PaymentStatusPartiallyRefunded
+		if payment.RefundedAmount == payment.Amount {
+			payment.Status = entity.PaymentStatusRefunded
+		}
+		if err := txRepo.UpdateRefundedAmount(payment.ID, payment.RefundedAmount, payment.Status); err != nil {
+			return fmt.Errorf("ошибка обновления суммы возвратов платежа: %w", err)
+		}
+
+		publisher := uc.txPublisher(tx)
+		if announce {
+			if err := uc.publishRefund(publisher, payment, refund); err != nil {
+				return err
+			}
+		}
+		if payment.Status == entity.PaymentStatusRefunded {
+			if err := uc.publishPaymentRefund(publisher, payment); err != nil {
+				return err
+			}
+		}
+
+		response = &entity.RefundResponse{
+			Refund:         *refund,
+			PaymentStatus:  payment.Status,
+			RefundedAmount: payment.RefundedAmount,
+			Refundable:     refundable(payment),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Возврат %d по платежу %d на сумму %s %s выполнен, статус платежа %s",
+		response.Refund.ID, paymentID, response.Refund.Amount, response.Refund.Currency, response.PaymentStatus)
+	return response, nil
+}
+
+// publishRefund публикует событие о возврате по платежу через publisher
+func (uc *PaymentUseCase) publishRefund(publisher messaging.MessagePublisher, payment *entity.Payment, refund *entity.Refund) error {
+	message := RefundMessage{
+		RefundID:       refund.ID,
+		PaymentID:      payment.ID,
+		OrderID:        payment.OrderID,
+		UserID:         payment.UserID,
+		Amount:         refund.Amount,
+		Currency:       refund.Currency,
+		RefundedAmount: payment.RefundedAmount,
+		PaymentStatus:  payment.Status,
+		Reason:         refund.Reason,
+		Timestamp:      time.Now().Unix(),
+	}
+
+	err := messaging.PublishWithRetryAndLogging(publisher, uc.exchangeName, "payment.refund.created", message, 3)
+	if err != nil {
+		return fmt.Errorf("ошибка публикации сообщения о возврате по платежу: %w", err)
+	}
+	return nil
+}
+
+// refundable возвращает сумму платежа, которую еще можно вернуть
+func refundable(payment *entity.Payment) money.Amount {
+	if payment.Status != entity.PaymentStatusCompleted && payment.Status != entity.PaymentStatusPartiallyRefunded {
+		return 0
+	}
+	return payment.Amount - payment.RefundedAmount
+}
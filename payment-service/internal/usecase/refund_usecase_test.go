@@ -0,0 +1,167 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/director74/dz8_shop/payment-service/internal/entity"
+	"github.com/director74/dz8_shop/payment-service/internal/usecase/gateway"
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// TestCreateRefund_PartialThenRemainder тестирует несколько частичных возвратов до полной суммы платежа
+func TestCreateRefund_PartialThenRemainder(t *testing.T) {
+	ctx := context.Background()
+	uc, store, gw, publisher := newTestPaymentUseCase()
+	paymentID := addCapturedPayment(t, store, gw, 10000)
+
+	refund, err := uc.CreateRefund(ctx, paymentID, entity.CreateRefundRequest{Amount: 3000, Reason: "возврат товара"})
+	assert.NoError(t, err)
+	assert.Equal(t, money.Amount(3000), refund.Refund.Amount)
+	assert.Equal(t, entity.PaymentStatusPartiallyRefunded, refund.PaymentStatus)
+	assert.Equal(t, money.Amount(3000), refund.RefundedAmount)
+	assert.Equal(t, money.Amount(7000), refund.Refundable)
+	assert.NotEmpty(t, refund.Refund.TransactionID)
+
+	// Без суммы возвращается весь остаток
+	refund, err = uc.CreateRefund(ctx, paymentID, entity.CreateRefundRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, money.Amount(7000), refund.Refund.Amount)
+	assert.Equal(t, entity.PaymentStatusRefunded, refund.PaymentStatus)
+	assert.Zero(t, refund.Refundable)
+
+	payment := store.payment(paymentID)
+	assert.Equal(t, money.Amount(10000), payment.RefundedAmount)
+	assert.Equal(t, entity.PaymentStatusRefunded, payment.Status)
+	assert.Equal(t, []string{"payment.refund.created", "payment.refund.created", "payment.refunded"}, publisher.routingKeys())
+
+	_, err = uc.CreateRefund(ctx, paymentID, entity.CreateRefundRequest{Amount: 100})
+	assert.ErrorIs(t, err, ErrPaymentNotRefundable)
+
+	status, err := gw.Status(ctx, payment.TransactionID)
+	assert.NoError(t, err)
+	assert.Equal(t, money.Amount(10000), status.RefundedAmount)
+}
+
+// TestCreateRefund_PaidOutOnce тестирует, что возврат выплачивается один раз: сумма уходит на карту
+// через шлюз, а событие о возврате только сообщает о нем
+func TestCreateRefund_PaidOutOnce(t *testing.T) {
+	ctx := context.Background()
+	uc, store, gw, publisher := newTestPaymentUseCase()
+	paymentID := addCapturedPayment(t, store, gw, 10000)
+
+	refund, err := uc.CreateRefund(ctx, paymentID, entity.CreateRefundRequest{Amount: 2500, Reason: "возврат товара"})
+	assert.NoError(t, err)
+
+	status, err := gw.Status(ctx, store.payment(paymentID).TransactionID)
+	assert.NoError(t, err)
+	// Биллинг не зачисляет возвраты на счет, поэтому карта получает всю сумму возврата и ничего сверх нее
+	assert.Equal(t, refund.Refund.Amount, status.RefundedAmount)
+	assert.Equal(t, money.Amount(2500), store.payment(paymentID).RefundedAmount)
+
+	if assert.Len(t, publisher.messages, 1) {
+		message, ok := publisher.messages[0].message.(RefundMessage)
+		if assert.True(t, ok) {
+			assert.Equal(t, money.Amount(2500), message.Amount)
+		}
+	}
+}
+
+// TestCreateRefund_ExceedsRemaining тестирует отказ в возврате больше невозвращенного остатка
+func TestCreateRefund_ExceedsRemaining(t *testing.T) {
+	ctx := context.Background()
+	uc, store, gw, publisher := newTestPaymentUseCase()
+	paymentID := addCapturedPayment(t, store, gw, 10000)
+	_, err := uc.CreateRefund(ctx, paymentID, entity.CreateRefundRequest{Amount: 3000})
+	assert.NoError(t, err)
+
+	_, err = uc.CreateRefund(ctx, paymentID, entity.CreateRefundRequest{Amount: 7001})
+
+	assert.ErrorIs(t, err, ErrRefundExceedsPayment)
+	payment := store.payment(paymentID)
+	assert.Equal(t, money.Amount(3000), payment.RefundedAmount)
+	assert.Equal(t, entity.PaymentStatusPartiallyRefunded, payment.Status)
+	assert.Len(t, store.refunds, 1)
+	assert.Len(t, publisher.messages, 1)
+
+	// В шлюз уходит только выполненный возврат
+	status, err := gw.Status(ctx, payment.TransactionID)
+	assert.NoError(t, err)
+	assert.Equal(t, money.Amount(3000), status.RefundedAmount)
+}
+
+// TestCreateRefund_Rejected тестирует возвраты, которые не проводятся
+func TestCreateRefund_Rejected(t *testing.T) {
+	ctx := context.Background()
+	uc, store, gw, _ := newTestPaymentUseCase()
+	paymentID := addCapturedPayment(t, store, gw, 10000)
+	authorizedID := store.addPayment(entity.Payment{OrderID: 8, UserID: 1, Amount: 5000, Status: entity.PaymentStatusAuthorized})
+
+	tests := []struct {
+		name      string
+		paymentID uint
+		req       entity.CreateRefundRequest
+		wantErr   error
+	}{
+		{name: "платеж не найден", paymentID: 99, req: entity.CreateRefundRequest{Amount: 1000}, wantErr: ErrPaymentNotFound},
+		{name: "другая валюта", paymentID: paymentID, req: entity.CreateRefundRequest{Amount: 1000, Currency: "USD"}, wantErr: ErrCurrencyMismatch},
+		{name: "платеж не списан", paymentID: authorizedID, req: entity.CreateRefundRequest{Amount: 1000}, wantErr: ErrPaymentNotRefundable},
+		{name: "больше суммы платежа", paymentID: paymentID, req: entity.CreateRefundRequest{Amount: 10001}, wantErr: ErrRefundExceedsPayment},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := uc.CreateRefund(ctx, tt.paymentID, tt.req)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+	assert.Empty(t, store.refunds)
+	assert.Zero(t, store.payment(paymentID).RefundedAmount)
+}
+
+// TestCreateRefund_GatewayDeclined тестирует, что отклоненный шлюзом возврат ничего не меняет
+func TestCreateRefund_GatewayDeclined(t *testing.T) {
+	ctx := context.Background()
+	uc, store, gw, publisher := newTestPaymentUseCase(gateway.FakeRule{Operation: gateway.OperationRefund, Amount: 2500, Outcome: gateway.OutcomeDecline})
+	paymentID := addCapturedPayment(t, store, gw, 10000)
+
+	_, err := uc.CreateRefund(ctx, paymentID, entity.CreateRefundRequest{Amount: 2500})
+
+	assert.ErrorIs(t, err, entity.ErrGatewayDeclined)
+	assert.Empty(t, store.refunds)
+	assert.Equal(t, entity.PaymentStatusCompleted, store.payment(paymentID).Status)
+	assert.Empty(t, publisher.messages)
+}
+
+// TestRefundPayment_RefundsRemainder тестирует компенсацию саги после частичного возврата:
+// возвращается только остаток, событие о возврате не публикуется
+func TestRefundPayment_RefundsRemainder(t *testing.T) {
+	ctx := context.Background()
+	uc, store, gw, publisher := newTestPaymentUseCase()
+	paymentID := addCapturedPayment(t, store, gw, 10000)
+	_, err := uc.CreateRefund(ctx, paymentID, entity.CreateRefundRequest{Amount: 4000})
+	assert.NoError(t, err)
+
+	status, err := uc.RefundPayment(ctx, &entity.RefundPaymentRequest{PaymentID: paymentID})
+	assert.NoError(t, err)
+	assert.Equal(t, entity.PaymentStatusRefunded, status)
+
+	// Повторная компенсация ничего не меняет
+	status, err = uc.RefundPayment(ctx, &entity.RefundPaymentRequest{PaymentID: paymentID})
+	assert.NoError(t, err)
+	assert.Equal(t, entity.PaymentStatusRefunded, status)
+
+	if assert.Len(t, store.refunds, 2) {
+		assert.Equal(t, money.Amount(6000), store.refunds[1].Amount)
+	}
+	assert.Equal(t, money.Amount(10000), store.payment(paymentID).RefundedAmount)
+	assert.Equal(t, []string{"payment.refund.created", "payment.refunded"}, publisher.routingKeys())
+
+	list, err := uc.ListRefunds(paymentID, 1)
+	assert.NoError(t, err)
+	assert.Len(t, list.Refunds, 2)
+	assert.Equal(t, money.Amount(10000), list.RefundedAmount)
+	assert.Zero(t, list.Refundable)
+}
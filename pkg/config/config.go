@@ -38,11 +38,14 @@ type PostgresConfig struct {
 
 // RabbitMQConfig содержит настройки RabbitMQ
 type RabbitMQConfig struct {
-	Host     string
-	Port     string
-	User     string
-	Password string
-	VHost    string
+	Host                string
+	Port                string
+	User                string
+	Password            string
+	VHost               string
+	MandatoryExchanges  []string      // Exchanges, сообщения в которые не должны теряться без очереди-получателя
+	MaxRedeliveries     int           // Количество повторных доставок перед переносом сообщения в parking-очередь
+	RedeliveryBaseDelay time.Duration // Задержка перед первой повторной доставкой, далее удваивается
 }
 
 // JWTConfig содержит настройки для JWT
@@ -57,6 +60,15 @@ type JWTConfig struct {
 type ServicesConfig struct {
 	BillingURL      string
 	NotificationURL string
+	WarehouseURL    string
+}
+
+// OutboxConfig содержит настройки отправки сообщений из transactional outbox
+type OutboxConfig struct {
+	PollInterval  time.Duration // Интервал опроса таблицы outbox
+	BatchSize     int           // Количество сообщений, отправляемых за один проход
+	SentRetention time.Duration // Время хранения отправленных сообщений
+	MaxAttempts   int           // Количество попыток отправки, после которого сообщение откладывается (статус failed)
 }
 
 // LoadCommonConfig загружает общую конфигурацию из переменных окружения
@@ -79,11 +91,14 @@ func LoadCommonConfig(serviceName string, port string) *CommonConfig {
 			SSLMode:  GetEnv("POSTGRES_SSLMODE", "disable"),
 		},
 		RabbitMQ: RabbitMQConfig{
-			Host:     GetEnv("RABBITMQ_HOST", "localhost"),
-			Port:     GetEnv("RABBITMQ_PORT", "5672"),
-			User:     GetEnv("RABBITMQ_USER", "guest"),
-			Password: GetEnv("RABBITMQ_PASSWORD", "guest"),
-			VHost:    GetEnv("RABBITMQ_VHOST", "/"),
+			Host:                GetEnv("RABBITMQ_HOST", "localhost"),
+			Port:                GetEnv("RABBITMQ_PORT", "5672"),
+			User:                GetEnv("RABBITMQ_USER", "guest"),
+			Password:            GetEnv("RABBITMQ_PASSWORD", "guest"),
+			VHost:               GetEnv("RABBITMQ_VHOST", "/"),
+			MandatoryExchanges:  strings.Split(GetEnv("RABBITMQ_MANDATORY_EXCHANGES", "saga_exchange"), ","),
+			MaxRedeliveries:     GetEnvAsInt("RABBITMQ_MAX_REDELIVERIES", 5),
+			RedeliveryBaseDelay: GetEnvAsDuration("RABBITMQ_REDELIVERY_BASE_DELAY", time.Second),
 		},
 	}
 }
@@ -110,6 +125,17 @@ func LoadServicesConfig() *ServicesConfig {
 	return &ServicesConfig{
 		BillingURL:      GetEnv("BILLING_SERVICE_URL", "http://localhost:8081"),
 		NotificationURL: GetEnv("NOTIFICATION_SERVICE_URL", "http://localhost:8082"),
+		WarehouseURL:    GetEnv("WAREHOUSE_SERVICE_URL", "http://localhost:8084"),
+	}
+}
+
+// LoadOutboxConfig загружает конфигурацию outbox из переменных окружения
+func LoadOutboxConfig() *OutboxConfig {
+	return &OutboxConfig{
+		PollInterval:  GetEnvAsDuration("OUTBOX_POLL_INTERVAL", time.Second),
+		BatchSize:     GetEnvAsInt("OUTBOX_BATCH_SIZE", 100),
+		SentRetention: GetEnvAsDuration("OUTBOX_SENT_RETENTION", 24*time.Hour),
+		MaxAttempts:   GetEnvAsInt("OUTBOX_MAX_ATTEMPTS", 10),
 	}
 }
 
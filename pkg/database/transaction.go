@@ -0,0 +1,37 @@
+package database
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// txKey ключ контекста для хранения текущей транзакции
+type txKey struct{}
+
+// WithTransaction выполняет fn в транзакции базы данных.
+// Транзакция передается через контекст: репозитории, получающие соединение через Conn,
+// и outbox выполняют свои запросы в ней. Вложенный вызов использует уже открытую транзакцию.
+func WithTransaction(ctx context.Context, db *gorm.DB, fn func(ctx context.Context) error) error {
+	if _, ok := TxFromContext(ctx); ok {
+		return fn(ctx)
+	}
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// TxFromContext возвращает транзакцию, открытую через WithTransaction
+func TxFromContext(ctx context.Context) (*gorm.DB, bool) {
+	tx, ok := ctx.Value(txKey{}).(*gorm.DB)
+	return tx, ok
+}
+
+// Conn возвращает соединение для запроса: транзакцию из контекста или db, если транзакция не открыта
+func Conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := TxFromContext(ctx); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
@@ -0,0 +1,126 @@
+package messaging
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/director74/dz8_shop/pkg/middleware"
+	"github.com/director74/dz8_shop/pkg/rabbitmq"
+)
+
+const (
+	// defaultParkedLimit количество сообщений, возвращаемых по умолчанию при просмотре parking-очереди
+	defaultParkedLimit = 50
+	// maxParkedLimit максимальное количество сообщений за один запрос
+	maxParkedLimit = 500
+)
+
+// DeadLetterBroker операции с parking-очередями, необходимые административному API
+type DeadLetterBroker interface {
+	ParkedQueues() []string
+	ListParked(queueName string, limit int) ([]rabbitmq.ParkedMessage, int, error)
+	ReplayParked(queueName string, messageIDs []string) (int, error)
+}
+
+// DeadLetterHandler обработчик административного API для просмотра и повторной отправки
+// сообщений, перенесенных в parking-очереди после исчерпания повторных доставок
+type DeadLetterHandler struct {
+	broker DeadLetterBroker
+}
+
+// NewDeadLetterHandler создает обработчик административного API parking-очередей
+func NewDeadLetterHandler(broker DeadLetterBroker) *DeadLetterHandler {
+	return &DeadLetterHandler{broker: broker}
+}
+
+// replayRequest запрос на повторную отправку сообщений. Пустой список означает все сообщения очереди.
+type replayRequest struct {
+	MessageIDs []string `json:"message_ids"`
+}
+
+// RegisterRoutes регистрирует маршруты административного API в router.
+// Маршруты должны быть защищены middleware внутреннего API.
+func (h *DeadLetterHandler) RegisterRoutes(router gin.IRouter) {
+	router.GET("/dead-letters", h.ListQueues)
+	router.GET("/dead-letters/:queue", h.ListMessages)
+	router.POST("/dead-letters/:queue/replay", h.Replay)
+}
+
+// RegisterDeadLetterAdmin регистрирует административное API parking-очередей в группе /internal/admin,
+// защищенной middleware внутреннего API
+func RegisterDeadLetterAdmin(router gin.IRouter, broker DeadLetterBroker) {
+	admin := router.Group("/internal/admin", middleware.NewInternalAuthMiddleware(nil).Required())
+	NewDeadLetterHandler(broker).RegisterRoutes(admin)
+}
+
+// ListQueues возвращает очереди сервиса, для которых настроены parking-очереди
+func (h *DeadLetterHandler) ListQueues(c *gin.Context) {
+	queues := h.broker.ParkedQueues()
+	result := make([]gin.H, 0, len(queues))
+	for _, name := range queues {
+		result = append(result, gin.H{
+			"queue":         name,
+			"parking_queue": rabbitmq.ParkingQueueName(name),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"queues": result})
+}
+
+// ListMessages возвращает сообщения parking-очереди без их удаления
+func (h *DeadLetterHandler) ListMessages(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultParkedLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный параметр limit"})
+		return
+	}
+	if limit > maxParkedLimit {
+		limit = maxParkedLimit
+	}
+
+	queueName := c.Param("queue")
+	messages, total, err := h.broker.ListParked(queueName, limit)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"queue":    queueName,
+		"total":    total,
+		"messages": messages,
+	})
+}
+
+// Replay возвращает сообщения из parking-очереди в исходную очередь
+func (h *DeadLetterHandler) Replay(c *gin.Context) {
+	var req replayRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	queueName := c.Param("queue")
+	replayed, err := h.broker.ReplayParked(queueName, req.MessageIDs)
+	if err != nil {
+		h.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"queue":    queueName,
+		"replayed": replayed,
+	})
+}
+
+func (h *DeadLetterHandler) respondError(c *gin.Context, err error) {
+	if errors.Is(err, rabbitmq.ErrUnknownQueue) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -36,6 +36,10 @@ func InitRabbitMQ(cfg config.RabbitMQConfig) (*rabbitmq.RabbitMQ, error) {
 		User:     cfg.User,
 		Password: cfg.Password,
 		VHost:    cfg.VHost,
+
+		MandatoryExchanges:  cfg.MandatoryExchanges,
+		MaxRedeliveries:     cfg.MaxRedeliveries,
+		RedeliveryBaseDelay: cfg.RedeliveryBaseDelay,
 	}
 
 	rmq, err := rabbitmq.NewRabbitMQ(rmqCfg)
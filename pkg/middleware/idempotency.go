@@ -0,0 +1,292 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// IdempotencyKeyHeader заголовок, в котором клиент передает ключ идемпотентности запроса
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader заголовок ответа, отмечающий повтор сохраненного ответа
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// maxIdempotencyKeyLength максимальная длина ключа идемпотентности
+	maxIdempotencyKeyLength = 255
+	// defaultIdempotencyTTL время хранения ответа, после которого ключ можно использовать заново
+	defaultIdempotencyTTL = 24 * time.Hour
+	// defaultIdempotencyProcessingTimeout время, отведенное на выполнение запроса. Контекст запроса отменяется
+	// по истечении этого времени, и только после этого выполнение может быть перехвачено повтором с тем же ключом
+	defaultIdempotencyProcessingTimeout = time.Minute
+)
+
+// IdempotencyStatus статус запроса с ключом идемпотентности
+type IdempotencyStatus string
+
+const (
+	IdempotencyStatusProcessing IdempotencyStatus = "processing" // Запрос выполняется, ответ еще не сохранен
+	IdempotencyStatusCompleted  IdempotencyStatus = "completed"  // Ответ сохранен и возвращается при повторах
+)
+
+// IdempotencyRecord сохраненный результат запроса с ключом идемпотентности.
+// Ключ действует в пределах Scope (пользователь и маршрут), Fingerprint защищает от повтора ключа с другим телом запроса.
+type IdempotencyRecord struct {
+	Scope        string            `gorm:"primaryKey;type:varchar(255)"`
+	Key          string            `gorm:"primaryKey;type:varchar(255)"`
+	Fingerprint  string            `gorm:"not null;type:varchar(64)"`
+	Status       IdempotencyStatus `gorm:"not null;type:varchar(20)"`
+	ResponseCode int               `gorm:"not null;default:0"`
+	ContentType  string            `gorm:"type:varchar(255)"`
+	ResponseBody []byte            `gorm:"type:bytea"`
+	// ProcessingDeadline крайний срок выполнения запроса, захватившего ключ. До него повтор получает 409,
+	// после него выполнение считается прерванным и может быть перехвачено
+	ProcessingDeadline time.Time `gorm:"not null;default:now()"`
+	CreatedAt          time.Time `gorm:"not null;default:now()"`
+	UpdatedAt          time.Time `gorm:"not null;default:now();index"`
+}
+
+// TableName задает имя таблицы для GORM
+func (IdempotencyRecord) TableName() string {
+	return "idempotency_keys"
+}
+
+// IdempotencyMiddleware middleware, выполняющий запрос с заголовком Idempotency-Key не более одного раза.
+// Повтор запроса с тем же ключом и телом возвращает сохраненный ответ, с другим телом - 422.
+// Запросы без заголовка выполняются как обычно.
+type IdempotencyMiddleware struct {
+	db                *gorm.DB
+	ttl               time.Duration
+	processingTimeout time.Duration
+}
+
+// NewIdempotencyMiddleware создает middleware идемпотентности, хранящий ответы в базе данных сервиса
+func NewIdempotencyMiddleware(db *gorm.DB) *IdempotencyMiddleware {
+	return &IdempotencyMiddleware{
+		db:                db,
+		ttl:               defaultIdempotencyTTL,
+		processingTimeout: defaultIdempotencyProcessingTimeout,
+	}
+}
+
+// Handler возвращает gin middleware. Должен подключаться после middleware аутентификации:
+// ключи разных пользователей не пересекаются.
+func (m *IdempotencyMiddleware) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("ключ идемпотентности длиннее %d символов", maxIdempotencyKeyLength),
+			})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "не удалось прочитать тело запроса"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := idempotencyScope(c)
+		fingerprint := requestFingerprint(c.Request, body)
+
+		record, acquired, err := m.acquire(scope, key, fingerprint)
+		if err != nil {
+			log.Printf("[Idempotency][ERROR] %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "ошибка проверки ключа идемпотентности"})
+			return
+		}
+		if !acquired {
+			m.replay(c, record, fingerprint)
+			return
+		}
+
+		// Запрос не может выполняться дольше срока захвата ключа: после него выполнение перехватывается повтором
+		ctx, cancel := context.WithDeadline(c.Request.Context(), record.ProcessingDeadline)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		writer := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		// Ответ с ошибкой сервера не сохраняется: клиент может повторить запрос с тем же ключом
+		if writer.Status() >= http.StatusInternalServerError {
+			if err := m.release(scope, key, record.ProcessingDeadline); err != nil {
+				log.Printf("[Idempotency][ERROR] %v", err)
+			}
+			return
+		}
+		if err := m.complete(scope, key, record.ProcessingDeadline, writer.Status(), writer.Header().Get("Content-Type"), writer.body.Bytes()); err != nil {
+			log.Printf("[Idempotency][ERROR] %v", err)
+		}
+	}
+}
+
+// replay отвечает на повтор запроса по сохраненной записи
+func (m *IdempotencyMiddleware) replay(c *gin.Context, record *IdempotencyRecord, fingerprint string) {
+	if record.Fingerprint != fingerprint {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "ключ идемпотентности уже использован для запроса с другими параметрами",
+		})
+		return
+	}
+	if record.Status != IdempotencyStatusCompleted {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "запрос с этим ключом идемпотентности еще выполняется"})
+		return
+	}
+
+	c.Header(IdempotentReplayedHeader, "true")
+	if len(record.ResponseBody) == 0 {
+		c.AbortWithStatus(record.ResponseCode)
+		return
+	}
+	c.Data(record.ResponseCode, record.ContentType, record.ResponseBody)
+	c.Abort()
+}
+
+// acquire захватывает выполнение запроса с ключом key до крайнего срока record.ProcessingDeadline.
+// Если запрос с этим ключом уже выполнялся или выполняется, возвращает его запись и acquired=false.
+func (m *IdempotencyMiddleware) acquire(scope, key, fingerprint string) (record *IdempotencyRecord, acquired bool, err error) {
+	now := time.Now()
+	// Срок служит признаком владения ключом в complete и release, поэтому округляется до точности колонки
+	deadline := now.Add(m.processingTimeout).Truncate(time.Microsecond)
+
+	// Ключ с истекшим сроком хранения освобождается для нового запроса
+	if err := m.db.Where("scope = ? AND key = ? AND created_at < ?", scope, key, now.Add(-m.ttl)).
+		Delete(&IdempotencyRecord{}).Error; err != nil {
+		return nil, false, fmt.Errorf("ошибка удаления устаревшего ключа идемпотентности: %w", err)
+	}
+
+	claim := IdempotencyRecord{
+		Scope:              scope,
+		Key:                key,
+		Fingerprint:        fingerprint,
+		Status:             IdempotencyStatusProcessing,
+		ProcessingDeadline: deadline,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+	result := m.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&claim)
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("ошибка сохранения ключа идемпотентности: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		// RETURNING перезаписывает срок прочитанным значением колонки без часового пояса,
+		// а владение ключом в complete и release проверяется по записанному значению
+		claim.ProcessingDeadline = deadline
+		return &claim, true, nil
+	}
+
+	var existing IdempotencyRecord
+	if err := m.db.Where("scope = ? AND key = ?", scope, key).First(&existing).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, fmt.Errorf("ключ идемпотентности %q удален во время проверки", key)
+		}
+		return nil, false, fmt.Errorf("ошибка чтения ключа идемпотентности: %w", err)
+	}
+	if existing.Status == IdempotencyStatusCompleted || existing.Fingerprint != fingerprint {
+		return &existing, false, nil
+	}
+
+	// Выполнение перехватывается только после его крайнего срока: к этому времени контекст
+	// прерванного запроса отменен, и его ответ уже не будет сохранен (см. complete)
+	takeover := m.db.Model(&IdempotencyRecord{}).
+		Where("scope = ? AND key = ? AND status = ? AND processing_deadline < ?",
+			scope, key, IdempotencyStatusProcessing, now).
+		Updates(map[string]interface{}{
+			"processing_deadline": deadline,
+			"updated_at":          now,
+		})
+	if takeover.Error != nil {
+		return nil, false, fmt.Errorf("ошибка обновления ключа идемпотентности: %w", takeover.Error)
+	}
+	if takeover.RowsAffected == 1 {
+		existing.ProcessingDeadline = deadline
+		existing.UpdatedAt = now
+		return &existing, true, nil
+	}
+
+	return &existing, false, nil
+}
+
+// complete сохраняет ответ на запрос с ключом key, захваченным до deadline.
+// Если после истечения срока выполнение перехвачено другим запросом, ответ не сохраняется.
+func (m *IdempotencyMiddleware) complete(scope, key string, deadline time.Time, code int, contentType string, body []byte) error {
+	result := m.db.Model(&IdempotencyRecord{}).
+		Where("scope = ? AND key = ? AND status = ? AND processing_deadline = ?",
+			scope, key, IdempotencyStatusProcessing, deadline).
+		Updates(map[string]interface{}{
+			"status":        IdempotencyStatusCompleted,
+			"response_code": code,
+			"content_type":  contentType,
+			"response_body": body,
+			"updated_at":    time.Now(),
+		})
+	if result.Error != nil {
+		return fmt.Errorf("ошибка сохранения ответа для ключа идемпотентности %q: %w", key, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("[Idempotency][WARN] Ответ для ключа %q не сохранен: выполнение перехвачено после истечения срока", key)
+	}
+	return nil
+}
+
+// release снимает захват ключа до deadline, если ответ не сохраняется
+func (m *IdempotencyMiddleware) release(scope, key string, deadline time.Time) error {
+	if err := m.db.Where("scope = ? AND key = ? AND status = ? AND processing_deadline = ?",
+		scope, key, IdempotencyStatusProcessing, deadline).
+		Delete(&IdempotencyRecord{}).Error; err != nil {
+		return fmt.Errorf("ошибка удаления ключа идемпотентности %q: %w", key, err)
+	}
+	return nil
+}
+
+// idempotencyScope область действия ключа: пользователь из JWT и маршрут запроса
+func idempotencyScope(c *gin.Context) string {
+	var userID uint
+	if value, exists := c.Get("user_id"); exists {
+		userID, _ = value.(uint)
+	}
+	return fmt.Sprintf("%d:%s %s", userID, c.Request.Method, c.FullPath())
+}
+
+// requestFingerprint хеш метода, пути и тела запроса
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder сохраняет копию тела ответа для повторной отправки
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
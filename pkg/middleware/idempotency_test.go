@@ -0,0 +1,249 @@
+package middleware
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testScope = "0:POST /orders"
+	testKey   = "key-1"
+	testBody  = `{"product_id":1}`
+)
+
+var (
+	deleteExpiredKeySQL = regexp.QuoteMeta(`DELETE FROM "idempotency_keys" WHERE scope = $1 AND key = $2 AND created_at < $3`)
+	insertKeySQL        = regexp.QuoteMeta(`INSERT INTO "idempotency_keys"`)
+	selectKeySQL        = regexp.QuoteMeta(`SELECT * FROM "idempotency_keys" WHERE scope = $1 AND key = $2`)
+	takeoverKeySQL      = regexp.QuoteMeta(`UPDATE "idempotency_keys" SET "processing_deadline"=$1,"updated_at"=$2 WHERE scope = $3 AND key = $4 AND status = $5 AND processing_deadline < $6`)
+	completeKeySQL      = regexp.QuoteMeta(`UPDATE "idempotency_keys" SET "content_type"=$1,"response_body"=$2,"response_code"=$3,"status"=$4,"updated_at"=$5 WHERE scope = $6 AND key = $7 AND status = $8 AND processing_deadline = $9`)
+	releaseKeySQL       = regexp.QuoteMeta(`DELETE FROM "idempotency_keys" WHERE scope = $1 AND key = $2 AND status = $3 AND processing_deadline = $4`)
+)
+
+// newMockDB создает GORM поверх sqlmock с диалектом PostgreSQL
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("не удалось создать sqlmock: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("не удалось открыть gorm: %v", err)
+	}
+	return db, mock
+}
+
+// deadlineArg запоминает срок захвата ключа при записи и проверяет, что complete и release используют тот же срок
+type deadlineArg struct {
+	value *time.Time
+}
+
+func (a deadlineArg) Match(v driver.Value) bool {
+	deadline, ok := v.(time.Time)
+	if !ok {
+		return false
+	}
+	if a.value.IsZero() {
+		*a.value = deadline
+		return true
+	}
+	return deadline.Equal(*a.value)
+}
+
+// testFingerprint отпечаток тестового запроса
+func testFingerprint() string {
+	req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	return requestFingerprint(req, []byte(testBody))
+}
+
+// serveIdempotent выполняет запрос с ключом идемпотентности через middleware и handler
+func serveIdempotent(db *gorm.DB, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/orders", NewIdempotencyMiddleware(db).Handler(), handler)
+
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(testBody))
+	req.Header.Set(IdempotencyKeyHeader, testKey)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// expectAcquireAttempt ожидает удаление устаревшего ключа и попытку захвата; claimed определяет, захвачен ли ключ
+func expectAcquireAttempt(mock sqlmock.Sqlmock, deadline deadlineArg, claimed bool) {
+	mock.ExpectBegin()
+	mock.ExpectExec(deleteExpiredKeySQL).WithArgs(testScope, testKey, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	rows := sqlmock.NewRows([]string{"processing_deadline", "created_at", "updated_at"})
+	if claimed {
+		rows.AddRow(time.Now(), time.Now(), time.Now())
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(insertKeySQL).
+		WithArgs(testScope, testKey, testFingerprint(), IdempotencyStatusProcessing, 0, "", sqlmock.AnyArg(), deadline, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(rows)
+	mock.ExpectCommit()
+}
+
+// expectExistingKey ожидает чтение существующей записи ключа
+func expectExistingKey(mock sqlmock.Sqlmock, status IdempotencyStatus, fingerprint string, code int, body string) {
+	mock.ExpectQuery(selectKeySQL).
+		WithArgs(testScope, testKey, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"scope", "key", "fingerprint", "status", "response_code", "content_type", "response_body"}).
+			AddRow(testScope, testKey, fingerprint, status, code, "application/json; charset=utf-8", []byte(body)))
+}
+
+// expectComplete ожидает сохранение ответа с тем же сроком захвата
+func expectComplete(mock sqlmock.Sqlmock, deadline deadlineArg, code int, body string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(completeKeySQL).
+		WithArgs("application/json; charset=utf-8", []byte(body), code, IdempotencyStatusCompleted, sqlmock.AnyArg(),
+			testScope, testKey, IdempotencyStatusProcessing, deadline).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// TestIdempotency_FirstRequestStoresResponse тестирует выполнение запроса и сохранение ответа
+func TestIdempotency_FirstRequestStoresResponse(t *testing.T) {
+	db, mock := newMockDB(t)
+	deadline := deadlineArg{value: new(time.Time)}
+	expectAcquireAttempt(mock, deadline, true)
+	expectComplete(mock, deadline, http.StatusCreated, `{"id":1}`)
+
+	calls := 0
+	var requestDeadline time.Time
+	w := serveIdempotent(db, func(c *gin.Context) {
+		calls++
+		requestDeadline, _ = c.Request.Context().Deadline()
+		c.JSON(http.StatusCreated, gin.H{"id": 1})
+	})
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"id":1}`, w.Body.String())
+	assert.Equal(t, 1, calls)
+	assert.True(t, requestDeadline.Equal(*deadline.value), "контекст запроса должен истекать вместе со сроком захвата ключа")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestIdempotency_ReplaysCompletedResponse тестирует повтор сохраненного ответа без выполнения запроса
+func TestIdempotency_ReplaysCompletedResponse(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectAcquireAttempt(mock, deadlineArg{value: new(time.Time)}, false)
+	expectExistingKey(mock, IdempotencyStatusCompleted, testFingerprint(), http.StatusCreated, `{"id":1}`)
+
+	calls := 0
+	w := serveIdempotent(db, func(c *gin.Context) { calls++ })
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"id":1}`, w.Body.String())
+	assert.Equal(t, "true", w.Header().Get(IdempotentReplayedHeader))
+	assert.Zero(t, calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestIdempotency_FingerprintMismatch тестирует отказ 422 при повторе ключа с другим телом запроса
+func TestIdempotency_FingerprintMismatch(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectAcquireAttempt(mock, deadlineArg{value: new(time.Time)}, false)
+	expectExistingKey(mock, IdempotencyStatusCompleted, "other-fingerprint", http.StatusCreated, `{"id":1}`)
+
+	calls := 0
+	w := serveIdempotent(db, func(c *gin.Context) { calls++ })
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Zero(t, calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestIdempotency_InProgressBeforeDeadline тестирует ответ 409, пока не истек срок выполнения первого запроса
+func TestIdempotency_InProgressBeforeDeadline(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectAcquireAttempt(mock, deadlineArg{value: new(time.Time)}, false)
+	expectExistingKey(mock, IdempotencyStatusProcessing, testFingerprint(), 0, "")
+	mock.ExpectBegin()
+	mock.ExpectExec(takeoverKeySQL).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), testScope, testKey, IdempotencyStatusProcessing, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	calls := 0
+	w := serveIdempotent(db, func(c *gin.Context) { calls++ })
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Zero(t, calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestIdempotency_TakeoverAfterDeadline тестирует повторное выполнение запроса, срок которого истек
+func TestIdempotency_TakeoverAfterDeadline(t *testing.T) {
+	db, mock := newMockDB(t)
+	deadline := deadlineArg{value: new(time.Time)}
+	expectAcquireAttempt(mock, deadline, false)
+	expectExistingKey(mock, IdempotencyStatusProcessing, testFingerprint(), 0, "")
+	mock.ExpectBegin()
+	mock.ExpectExec(takeoverKeySQL).
+		WithArgs(deadline, sqlmock.AnyArg(), testScope, testKey, IdempotencyStatusProcessing, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectComplete(mock, deadline, http.StatusCreated, `{"id":1}`)
+
+	calls := 0
+	w := serveIdempotent(db, func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"id": 1})
+	})
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 1, calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestIdempotency_ServerErrorReleasesKey тестирует освобождение ключа после ответа с ошибкой сервера
+func TestIdempotency_ServerErrorReleasesKey(t *testing.T) {
+	db, mock := newMockDB(t)
+	deadline := deadlineArg{value: new(time.Time)}
+	expectAcquireAttempt(mock, deadline, true)
+	mock.ExpectBegin()
+	mock.ExpectExec(releaseKeySQL).
+		WithArgs(testScope, testKey, IdempotencyStatusProcessing, deadline).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := serveIdempotent(db, func(c *gin.Context) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка"})
+	})
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestIdempotency_WithoutKey тестирует выполнение запроса без заголовка Idempotency-Key
+func TestIdempotency_WithoutKey(t *testing.T) {
+	db, mock := newMockDB(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/orders", NewIdempotencyMiddleware(db).Handler(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(testBody)))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -34,6 +34,9 @@ func NewInternalAPIConfig() *InternalAPIConfig {
 	}
 }
 
+// InternalPrincipalKey ключ контекста запроса, под которым Required сохраняет вызывающего внутреннего API
+const InternalPrincipalKey = "internal_principal"
+
 // InternalAuthMiddleware middleware для защиты доступа к внутренним API
 type InternalAuthMiddleware struct {
 	config *InternalAPIConfig
@@ -64,16 +67,16 @@ func (m *InternalAuthMiddleware) Required() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Проверка API ключа в заголовке
 		headerKey := c.GetHeader(m.config.HeaderName)
+		clientIP := c.ClientIP()
 		if headerKey == m.apiKey {
+			c.Set(InternalPrincipalKey, "api-key@"+clientIP)
 			c.Next()
 			return
 		}
 
-		// Если ключ не верный, проверяем IP-адрес
-		clientIP := c.ClientIP()
-
-		// Проверяем, что IP адрес входит в список доверенных сетей
+		// Если ключ не верный, проверяем, что IP адрес входит в список доверенных сетей
 		if isIPTrusted(clientIP, m.config.TrustedNetworks) {
+			c.Set(InternalPrincipalKey, "trusted-network@"+clientIP)
 			c.Next()
 			return
 		}
@@ -85,6 +88,12 @@ func (m *InternalAuthMiddleware) Required() gin.HandlerFunc {
 	}
 }
 
+// InternalPrincipal возвращает вызывающего внутреннего API, прошедшего проверку Required: способ
+// проверки доступа и адрес клиента, например "api-key@10.0.0.5". Пустая строка - запрос не проверялся.
+func InternalPrincipal(c *gin.Context) string {
+	return c.GetString(InternalPrincipalKey)
+}
+
 // isIPTrusted проверяет, входит ли IP-адрес в список доверенных сетей
 func isIPTrusted(ipStr string, trustedNetworks []string) bool {
 	// Обработка IPv4 и IPv6 адресов
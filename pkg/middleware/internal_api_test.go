@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestInternalAuthMiddleware_Principal тестирует сохранение вызывающего, прошедшего проверку доступа
+func TestInternalAuthMiddleware_Principal(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config := NewInternalAPIConfig()
+	config.APIKeyEnvName = "TEST_INTERNAL_API_KEY"
+	config.DefaultAPIKey = "secret"
+	config.TrustedNetworks = []string{"10.0.0.0/8"}
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		apiKey        string
+		wantCode      int
+		wantPrincipal string
+	}{
+		{name: "ключ API", remoteAddr: "203.0.113.5:4000", apiKey: "secret", wantCode: http.StatusOK, wantPrincipal: "api-key@203.0.113.5"},
+		{name: "доверенная сеть", remoteAddr: "10.1.2.3:4000", wantCode: http.StatusOK, wantPrincipal: "trusted-network@10.1.2.3"},
+		{name: "неверный ключ из внешней сети", remoteAddr: "203.0.113.5:4000", apiKey: "wrong", wantCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal string
+			router := gin.New()
+			router.GET("/internal", NewInternalAuthMiddleware(config).Required(), func(c *gin.Context) {
+				principal = InternalPrincipal(c)
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/internal", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.apiKey != "" {
+				req.Header.Set(config.HeaderName, tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantPrincipal, principal)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	// ErrInvalidCurrency код валюты не соответствует ISO 4217
+	ErrInvalidCurrency = errors.New("некорректный код валюты")
+	// ErrCurrencyMismatch валюта операции не совпадает с валютой заказа, счета или платежа
+	ErrCurrencyMismatch = errors.New("валюта не совпадает")
+	// ErrInvalidRate некорректный курс обмена
+	ErrInvalidRate = errors.New("некорректный курс обмена")
+	// ErrRateNotFound курс обмена для пары валют не задан
+	ErrRateNotFound = errors.New("курс обмена не найден")
+)
+
+// Currency трехбуквенный код валюты ISO 4217.
+// Поддерживаются только валюты с двумя знаками после запятой, как и Amount: валюты без дробной части (JPY)
+// или с тремя знаками (BHD) ParseCurrency отклоняет.
+type Currency string
+
+// otherMinorUnits валюты ISO 4217, у которых число знаков после запятой отличается от двух
+var otherMinorUnits = map[Currency]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// DefaultCurrency валюта заказов, счетов и платежей, созданных без явного указания валюты
+const DefaultCurrency Currency = "RUB"
+
+// ParseCurrency разбирает код валюты без учета регистра. Пустая строка означает валюту по умолчанию.
+// Валюты, число знаков после запятой которых отличается от двух, отклоняются с ErrInvalidCurrency.
+func ParseCurrency(code string) (Currency, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return DefaultCurrency, nil
+	}
+	if len(code) != 3 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidCurrency, code)
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return "", fmt.Errorf("%w: %q", ErrInvalidCurrency, code)
+		}
+	}
+	if digits, ok := otherMinorUnits[Currency(code)]; ok {
+		return "", fmt.Errorf("%w: %s: знаков после запятой %d, поддерживаются только валюты с двумя", ErrInvalidCurrency, code, digits)
+	}
+	return Currency(code), nil
+}
+
+// OrDefault возвращает валюту по умолчанию вместо пустой: записи и сообщения,
+// созданные до появления валют, считаются записями в валюте по умолчанию
+func (c Currency) OrDefault() Currency {
+	if c == "" {
+		return DefaultCurrency
+	}
+	return c
+}
+
+// ExchangeRate курс обмена: сколько единиц валюты To дается за единицу валюты From.
+// Курс хранится десятичной строкой, чтобы сохранить в записях точное значение, по которому выполнена конвертация.
+type ExchangeRate struct {
+	From Currency `json:"from"`
+	To   Currency `json:"to"`
+	Rate string   `json:"rate"`
+}
+
+// NewExchangeRate создает курс обмена, проверяя, что он положительный
+func NewExchangeRate(from, to Currency, rate string) (ExchangeRate, error) {
+	value, ok := new(big.Rat).SetString(strings.TrimSpace(rate))
+	if !ok || value.Sign() <= 0 || strings.Contains(rate, "/") {
+		return ExchangeRate{}, fmt.Errorf("%w: %s/%s = %q", ErrInvalidRate, from, to, rate)
+	}
+	return ExchangeRate{From: from, To: to, Rate: strings.TrimSpace(rate)}, nil
+}
+
+// Identity курс обмена валюты на саму себя
+func Identity(currency Currency) ExchangeRate {
+	return ExchangeRate{From: currency, To: currency, Rate: "1"}
+}
+
+// Inverse возвращает обратный курс с точностью до десяти знаков после запятой
+func (r ExchangeRate) Inverse() (ExchangeRate, error) {
+	value, ok := new(big.Rat).SetString(r.Rate)
+	if !ok || value.Sign() <= 0 {
+		return ExchangeRate{}, fmt.Errorf("%w: %s/%s = %q", ErrInvalidRate, r.From, r.To, r.Rate)
+	}
+	inverse := strings.TrimRight(strings.TrimRight(new(big.Rat).Inv(value).FloatString(10), "0"), ".")
+	return NewExchangeRate(r.To, r.From, inverse)
+}
+
+// Convert переводит сумму в валюте From в валюту To, округляя до копеек половиной от нуля
+func (r ExchangeRate) Convert(amount Amount) (Amount, error) {
+	value, ok := new(big.Rat).SetString(r.Rate)
+	if !ok || value.Sign() <= 0 {
+		return 0, fmt.Errorf("%w: %s/%s = %q", ErrInvalidRate, r.From, r.To, r.Rate)
+	}
+	return roundMinor(value.Mul(value, new(big.Rat).SetInt64(int64(amount))))
+}
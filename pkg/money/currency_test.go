@@ -0,0 +1,38 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseCurrency тестирует разбор кода валюты и отказ для валют без двух знаков после запятой
+func TestParseCurrency(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Currency
+		wantErr bool
+	}{
+		{input: "", want: DefaultCurrency},
+		{input: "usd", want: "USD"},
+		{input: " EUR ", want: "EUR"},
+		{input: "JPY", wantErr: true},
+		{input: "krw", wantErr: true},
+		{input: "BHD", wantErr: true},
+		{input: "CLF", wantErr: true},
+		{input: "RUBL", wantErr: true},
+		{input: "R1B", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseCurrency(tt.input)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidCurrency)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidAmount строка не является корректной денежной суммой
+var ErrInvalidAmount = errors.New("некорректная денежная сумма")
+
+// minorUnits количество минимальных единиц (копеек) в единице валюты
+const minorUnits = 100
+
+// MaxAmount наибольшая по модулю сумма, которая помещается в колонку DECIMAL(12,2)
+const MaxAmount Amount = 999_999_999_999
+
+// plainDecimal десятичная запись суммы без экспоненты, дробей, разделителей разрядов и префиксов систем счисления
+var plainDecimal = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// Amount точная денежная сумма в минимальных единицах валюты (копейках).
+// В JSON сумма передается десятичным числом с двумя знаками после запятой (123.45),
+// в базе хранится в колонке DECIMAL(12,2), поэтому формат API, сообщений и таблиц не меняется.
+type Amount int64
+
+// FromMinor создает сумму из минимальных единиц валюты
+func FromMinor(minor int64) Amount {
+	return Amount(minor)
+}
+
+// Parse разбирает десятичную запись суммы ("123.45", "-7", "0.5").
+// Больше двух знаков после запятой не допускается: сумма должна быть точной.
+// Экспонента, шестнадцатеричная запись и разделители разрядов ("1e3", "0x10", "1_000") отклоняются,
+// как и сумма, не помещающаяся в DECIMAL(12,2).
+func Parse(s string) (Amount, error) {
+	return parse(s, false)
+}
+
+// Minor возвращает сумму в минимальных единицах валюты
+func (a Amount) Minor() int64 {
+	return int64(a)
+}
+
+// Mul умножает сумму на количество. Если произведение не помещается в DECIMAL(12,2), возвращается ErrInvalidAmount.
+func (a Amount) Mul(quantity int) (Amount, error) {
+	product := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(int64(quantity)))
+	if product.CmpAbs(big.NewInt(int64(MaxAmount))) > 0 {
+		return 0, fmt.Errorf("%w: %s x %d: слишком большая сумма", ErrInvalidAmount, a, quantity)
+	}
+	return Amount(product.Int64()), nil
+}
+
+// Abs возвращает абсолютное значение суммы
+func (a Amount) Abs() Amount {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// IsZero сообщает, равна ли сумма нулю
+func (a Amount) IsZero() bool {
+	return a == 0
+}
+
+// IsPositive сообщает, больше ли сумма нуля
+func (a Amount) IsPositive() bool {
+	return a > 0
+}
+
+// String возвращает десятичную запись суммы с двумя знаками после запятой
+func (a Amount) String() string {
+	sign := ""
+	minor := int64(a)
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, minor/minorUnits, minor%minorUnits)
+}
+
+// MarshalJSON кодирует сумму JSON числом
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON разбирает сумму из JSON числа или строки без потери точности
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	s := string(data)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// UnmarshalText разбирает сумму из текста
+func (a *Amount) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// UnmarshalParam разбирает сумму из параметра запроса при привязке формы в gin
+func (a *Amount) UnmarshalParam(param string) error {
+	return a.UnmarshalText([]byte(param))
+}
+
+// Value сохраняет сумму в базе десятичной строкой
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan читает сумму из колонки DECIMAL. Значения с большей точностью
+// (колонки, созданные до перехода на Amount) округляются до копеек.
+func (a *Amount) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+	case []byte:
+		*a, err = parse(string(v), true)
+	case string:
+		*a, err = parse(v, true)
+	case int64:
+		*a = Amount(v * minorUnits)
+	case float64:
+		*a, err = parse(strconv.FormatFloat(v, 'f', -1, 64), true)
+	default:
+		err = fmt.Errorf("%w: неподдерживаемый тип %T", ErrInvalidAmount, src)
+	}
+	return err
+}
+
+// GormDataType тип колонки для GORM
+func (Amount) GormDataType() string {
+	return "decimal(12,2)"
+}
+
+// parse разбирает десятичную запись суммы. При round лишние знаки после запятой
+// округляются половиной от нуля, иначе такая запись считается ошибкой.
+func parse(s string, round bool) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if !plainDecimal.MatchString(s) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	value, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	value.Mul(value, big.NewRat(minorUnits, 1))
+
+	if !value.IsInt() && !round {
+		return 0, fmt.Errorf("%w: %q: больше двух знаков после запятой", ErrInvalidAmount, s)
+	}
+	return roundMinor(value)
+}
+
+// roundMinor округляет значение в минимальных единицах до целого половиной от нуля
+// и проверяет, что результат помещается в DECIMAL(12,2)
+func roundMinor(value *big.Rat) (Amount, error) {
+	if !value.IsInt() {
+		half := big.NewRat(1, 2)
+		if value.Sign() < 0 {
+			half.Neg(half)
+		}
+		value = new(big.Rat).Add(value, half)
+	}
+
+	minor := new(big.Int).Quo(value.Num(), value.Denom())
+	if minor.CmpAbs(big.NewInt(int64(MaxAmount))) > 0 {
+		return 0, fmt.Errorf("%w: %s: слишком большая сумма", ErrInvalidAmount, value.FloatString(2))
+	}
+	return Amount(minor.Int64()), nil
+}
@@ -0,0 +1,187 @@
+package money
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParse тестирует разбор десятичной записи суммы
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Amount
+		wantErr bool
+	}{
+		{input: "123.45", want: 12345},
+		{input: "-7", want: -700},
+		{input: "0.5", want: 50},
+		{input: " 10.00 ", want: 1000},
+		{input: "0", want: 0},
+		{input: "9999999999.99", want: MaxAmount},
+		{input: "-9999999999.99", want: -MaxAmount},
+		{input: "10000000000.00", wantErr: true},
+		{input: "1.001", wantErr: true},
+		{input: "", wantErr: true},
+		{input: "abc", wantErr: true},
+		{input: "0x10", wantErr: true},
+		{input: "0b101", wantErr: true},
+		{input: "1_000", wantErr: true},
+		{input: "1e3", wantErr: true},
+		{input: "1/2", wantErr: true},
+		{input: "+5", wantErr: true},
+		{input: ".5", wantErr: true},
+		{input: "5.", wantErr: true},
+		{input: "1,5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidAmount)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestAmount_String тестирует десятичную запись суммы с двумя знаками после запятой
+func TestAmount_String(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		want   string
+	}{
+		{amount: 0, want: "0.00"},
+		{amount: 5, want: "0.05"},
+		{amount: 12345, want: "123.45"},
+		{amount: -50, want: "-0.50"},
+		{amount: MaxAmount, want: "9999999999.99"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.amount.String())
+	}
+}
+
+// TestAmount_JSON тестирует кодирование суммы JSON числом и разбор из числа или строки
+func TestAmount_JSON(t *testing.T) {
+	type payload struct {
+		Amount Amount `json:"amount"`
+	}
+
+	data, err := json.Marshal(payload{Amount: 12345})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"amount":123.45}`, string(data))
+
+	tests := []struct {
+		name    string
+		input   string
+		want    Amount
+		wantErr bool
+	}{
+		{name: "число", input: `{"amount":123.45}`, want: 12345},
+		{name: "целое число", input: `{"amount":100}`, want: 10000},
+		{name: "строка", input: `{"amount":"0.10"}`, want: 10},
+		{name: "null", input: `{"amount":null}`, want: 0},
+		{name: "точность 0.1 + 0.2", input: `{"amount":0.30}`, want: 30},
+		{name: "больше двух знаков", input: `{"amount":0.001}`, wantErr: true},
+		{name: "экспонента", input: `{"amount":1e2}`, wantErr: true},
+		{name: "шестнадцатеричная строка", input: `{"amount":"0x10"}`, wantErr: true},
+		{name: "вне DECIMAL(12,2)", input: `{"amount":12345678901.00}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got payload
+			err := json.Unmarshal([]byte(tt.input), &got)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidAmount)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got.Amount)
+		})
+	}
+}
+
+// TestAmount_Scan тестирует чтение суммы из базы с округлением до копеек половиной от нуля
+func TestAmount_Scan(t *testing.T) {
+	tests := []struct {
+		name string
+		src  interface{}
+		want Amount
+	}{
+		{name: "DECIMAL(12,2)", src: []byte("123.45"), want: 12345},
+		{name: "строка", src: "0.10", want: 10},
+		{name: "округление вверх", src: "1.005", want: 101},
+		{name: "округление вниз", src: "1.0049", want: 100},
+		{name: "отрицательная половина от нуля", src: "-1.005", want: -101},
+		{name: "целое", src: int64(7), want: 700},
+		{name: "float", src: 2.675, want: 268},
+		{name: "NULL", src: nil, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Amount(1)
+			assert.NoError(t, got.Scan(tt.src))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	var a Amount
+	assert.ErrorIs(t, a.Scan(true), ErrInvalidAmount)
+}
+
+// TestAmount_Mul тестирует умножение суммы на количество и отказ при переполнении
+func TestAmount_Mul(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   Amount
+		quantity int
+		want     Amount
+		wantErr  bool
+	}{
+		{name: "обычное", amount: 12345, quantity: 3, want: 37035},
+		{name: "ноль", amount: 12345, quantity: 0, want: 0},
+		{name: "отрицательная сумма", amount: -100, quantity: 2, want: -200},
+		{name: "граница DECIMAL(12,2)", amount: MaxAmount, quantity: 1, want: MaxAmount},
+		{name: "больше DECIMAL(12,2)", amount: MaxAmount, quantity: 2, wantErr: true},
+		{name: "переполнение int64", amount: 100, quantity: math.MaxInt64, wantErr: true},
+		{name: "переполнение в минус", amount: -100, quantity: math.MaxInt64, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.amount.Mul(tt.quantity)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidAmount)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestExchangeRate_Convert тестирует конвертацию с округлением до копеек и проверкой диапазона
+func TestExchangeRate_Convert(t *testing.T) {
+	rate, err := NewExchangeRate("USD", "RUB", "92.3456")
+	assert.NoError(t, err)
+
+	converted, err := rate.Convert(1050)
+	assert.NoError(t, err)
+	// 10.50 * 92.3456 = 969.6288
+	assert.Equal(t, Amount(96963), converted)
+
+	_, err = rate.Convert(MaxAmount)
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+
+	_, err = NewExchangeRate("USD", "RUB", "0")
+	assert.ErrorIs(t, err, ErrInvalidRate)
+}
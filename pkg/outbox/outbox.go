@@ -0,0 +1,105 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+
+	"github.com/director74/dz8_shop/pkg/database"
+	"github.com/director74/dz8_shop/pkg/messaging"
+)
+
+// Status статус сообщения в outbox
+type Status string
+
+const (
+	StatusPending Status = "pending" // Сообщение ожидает отправки
+	StatusSent    Status = "sent"    // Сообщение подтверждено брокером
+	StatusFailed  Status = "failed"  // Сообщение не удалось отправить за максимальное количество попыток, отправка отложена
+)
+
+// Message сообщение, сохраненное для отправки в RabbitMQ.
+// Запись создается в той же транзакции, что и изменения данных сервиса,
+// и отправляется Relay после фиксации транзакции.
+type Message struct {
+	ID         uint64         `gorm:"primaryKey"`
+	Exchange   string         `gorm:"not null;type:varchar(255)"`
+	RoutingKey string         `gorm:"not null;type:varchar(255)"`
+	Payload    datatypes.JSON `gorm:"not null;type:jsonb"`
+	Status     Status         `gorm:"not null;type:varchar(20);default:pending;index"`
+	Attempts   int            `gorm:"not null;default:0"` // Количество неудачных попыток отправки
+	LastError  string         `gorm:"type:text"`
+	CreatedAt  time.Time      `gorm:"not null;default:now()"`
+	SentAt     *time.Time
+}
+
+// TableName задает имя таблицы для GORM
+func (Message) TableName() string {
+	return "outbox_messages"
+}
+
+// Enqueue сериализует message и сохраняет его в outbox через db.
+// Чтобы сообщение было отправлено только при фиксации изменений, db должен быть транзакцией.
+func Enqueue(db *gorm.DB, exchange, routingKey string, message interface{}) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	record := Message{
+		Exchange:   exchange,
+		RoutingKey: routingKey,
+		Payload:    datatypes.JSON(payload),
+		Status:     StatusPending,
+		CreatedAt:  time.Now(),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return fmt.Errorf("ошибка сохранения сообщения в outbox (%s, %s): %w", exchange, routingKey, err)
+	}
+	return nil
+}
+
+// Publisher реализует messaging.MessagePublisher, сохраняя сообщения в outbox вместо прямой публикации.
+// Сервисы переходят на него заменой RabbitMQ-клиента в usecase без изменения кода публикации.
+type Publisher struct {
+	db *gorm.DB
+}
+
+var _ messaging.MessagePublisher = (*Publisher)(nil)
+
+// NewPublisher создает публикатор, сохраняющий сообщения в outbox
+func NewPublisher(db *gorm.DB) *Publisher {
+	return &Publisher{db: db}
+}
+
+// WithTx возвращает публикатор, сохраняющий сообщения в транзакции tx
+func (p *Publisher) WithTx(tx *gorm.DB) *Publisher {
+	return &Publisher{db: tx}
+}
+
+// WithContext возвращает публикатор, привязанный к транзакции из контекста (см. database.WithTransaction).
+// Если транзакция не открыта, сообщение сохраняется отдельной записью.
+func (p *Publisher) WithContext(ctx context.Context) *Publisher {
+	return &Publisher{db: database.Conn(ctx, p.db)}
+}
+
+// Transaction выполняет fn в транзакции базы данных outbox.
+// Сообщения, опубликованные через WithContext(ctx), фиксируются вместе с остальными изменениями.
+func (p *Publisher) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return database.WithTransaction(ctx, p.db, fn)
+}
+
+// PublishMessage сохраняет сообщение в outbox
+func (p *Publisher) PublishMessage(exchange, routingKey string, message interface{}) error {
+	return Enqueue(p.db, exchange, routingKey, message)
+}
+
+// PublishMessageWithRetry сохраняет сообщение в outbox.
+// Повторные попытки отправки выполняет Relay, поэтому retries не используется.
+func (p *Publisher) PublishMessageWithRetry(exchange, routingKey string, message interface{}, retries int) error {
+	return p.PublishMessage(exchange, routingKey, message)
+}
@@ -0,0 +1,175 @@
+package outbox
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/director74/dz8_shop/pkg/config"
+)
+
+// newMockDB создает GORM поверх sqlmock с диалектом PostgreSQL
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("не удалось создать sqlmock: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("не удалось открыть gorm: %v", err)
+	}
+	return db, mock
+}
+
+// fakeConfirmPublisher публикатор, возвращающий заданные ошибки по routing key
+type fakeConfirmPublisher struct {
+	errors    map[string]error
+	published []string
+}
+
+func (p *fakeConfirmPublisher) PublishConfirmed(ctx context.Context, exchange, routingKey string, body []byte) error {
+	if err := p.errors[routingKey]; err != nil {
+		return err
+	}
+	p.published = append(p.published, routingKey)
+	return nil
+}
+
+func newTestRelay(db *gorm.DB, publisher ConfirmPublisher, maxAttempts int) *Relay {
+	return NewRelay(db, publisher, config.OutboxConfig{BatchSize: 10, MaxAttempts: maxAttempts}, log.New(io.Discard, "", 0))
+}
+
+var (
+	selectPendingSQL = regexp.QuoteMeta(`SELECT * FROM "outbox_messages" WHERE status = $1 ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED`)
+	updateMessageSQL = regexp.QuoteMeta(`UPDATE "outbox_messages" SET`)
+)
+
+func pendingRows(rows ...[]driver.Value) *sqlmock.Rows {
+	result := sqlmock.NewRows([]string{"id", "exchange", "routing_key", "payload", "status", "attempts"})
+	for _, row := range rows {
+		result.AddRow(row...)
+	}
+	return result
+}
+
+// TestPublisher_EnqueuesInTransaction тестирует сохранение сообщения в транзакции вызывающего кода
+func TestPublisher_EnqueuesInTransaction(t *testing.T) {
+	db, mock := newMockDB(t)
+	publisher := NewPublisher(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "outbox_messages"`)).
+		WithArgs("order_events", "order.created", sqlmock.AnyArg(), StatusPending, 0, "", nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	err := publisher.Transaction(context.Background(), func(ctx context.Context) error {
+		return publisher.WithContext(ctx).PublishMessage("order_events", "order.created", map[string]uint{"order_id": 10})
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestPublisher_RollbackDiscardsMessage тестирует, что сообщение не сохраняется при откате транзакции
+func TestPublisher_RollbackDiscardsMessage(t *testing.T) {
+	db, mock := newMockDB(t)
+	publisher := NewPublisher(db)
+	failure := errors.New("ошибка изменения данных")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "outbox_messages"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectRollback()
+
+	err := publisher.Transaction(context.Background(), func(ctx context.Context) error {
+		if err := publisher.WithContext(ctx).PublishMessage("order_events", "order.created", map[string]uint{"order_id": 10}); err != nil {
+			return err
+		}
+		return failure
+	})
+
+	assert.ErrorIs(t, err, failure)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestRelay_ProcessBatchSendsInOrder тестирует отправку пакета и отметку сообщений отправленными
+func TestRelay_ProcessBatchSendsInOrder(t *testing.T) {
+	db, mock := newMockDB(t)
+	publisher := &fakeConfirmPublisher{}
+	relay := newTestRelay(db, publisher, 3)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectPendingSQL).WithArgs(StatusPending, 10).WillReturnRows(pendingRows(
+		[]driver.Value{1, "order_events", "order.created", []byte(`{}`), StatusPending, 0},
+		[]driver.Value{2, "order_events", "order.paid", []byte(`{}`), StatusPending, 0},
+	))
+	mock.ExpectExec(updateMessageSQL).WithArgs(sqlmock.AnyArg(), StatusSent, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(updateMessageSQL).WithArgs(sqlmock.AnyArg(), StatusSent, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	sent, err := relay.ProcessBatch(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, []string{"order.created", "order.paid"}, publisher.published)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestRelay_ProcessBatchStopsOnError тестирует, что после ошибки отправки пакет прерывается, чтобы не нарушить порядок
+func TestRelay_ProcessBatchStopsOnError(t *testing.T) {
+	db, mock := newMockDB(t)
+	publisher := &fakeConfirmPublisher{errors: map[string]error{"order.created": errors.New("нет подтверждения")}}
+	relay := newTestRelay(db, publisher, 3)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectPendingSQL).WithArgs(StatusPending, 10).WillReturnRows(pendingRows(
+		[]driver.Value{1, "order_events", "order.created", []byte(`{}`), StatusPending, 0},
+		[]driver.Value{2, "order_events", "order.paid", []byte(`{}`), StatusPending, 0},
+	))
+	mock.ExpectExec(updateMessageSQL).WithArgs(1, "нет подтверждения", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	sent, err := relay.ProcessBatch(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	assert.Empty(t, publisher.published)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestRelay_ProcessBatchParksPoisonMessage тестирует, что сообщение, исчерпавшее попытки отправки,
+// откладывается в статус failed и не блокирует следующие сообщения
+func TestRelay_ProcessBatchParksPoisonMessage(t *testing.T) {
+	db, mock := newMockDB(t)
+	publisher := &fakeConfirmPublisher{errors: map[string]error{"saga.unknown.execute": errors.New("сообщение не маршрутизировано")}}
+	relay := newTestRelay(db, publisher, 3)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectPendingSQL).WithArgs(StatusPending, 10).WillReturnRows(pendingRows(
+		[]driver.Value{1, "saga_exchange", "saga.unknown.execute", []byte(`{}`), StatusPending, 2},
+		[]driver.Value{2, "order_events", "order.paid", []byte(`{}`), StatusPending, 0},
+	))
+	mock.ExpectExec(updateMessageSQL).WithArgs(3, "сообщение не маршрутизировано", StatusFailed, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(updateMessageSQL).WithArgs(sqlmock.AnyArg(), StatusSent, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	sent, err := relay.ProcessBatch(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"order.paid"}, publisher.published)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,186 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/director74/dz8_shop/pkg/config"
+)
+
+const (
+	// defaultPollInterval интервал опроса outbox по умолчанию
+	defaultPollInterval = time.Second
+	// defaultBatchSize количество сообщений, отправляемых за один проход по умолчанию
+	defaultBatchSize = 100
+	// defaultMaxAttempts количество попыток отправки сообщения по умолчанию
+	defaultMaxAttempts = 10
+	// publishTimeout время ожидания подтверждения публикации одного сообщения
+	publishTimeout = 5 * time.Second
+	// cleanupInterval интервал удаления отправленных сообщений
+	cleanupInterval = time.Hour
+)
+
+// ConfirmPublisher публикует сообщение и возвращает успех только после подтверждения брокером
+type ConfirmPublisher interface {
+	PublishConfirmed(ctx context.Context, exchange, routingKey string, body []byte) error
+}
+
+// Relay отправляет сообщения из outbox в RabbitMQ и отмечает их отправленными.
+// Сообщения выбираются с блокировкой FOR UPDATE SKIP LOCKED, поэтому несколько экземпляров
+// сервиса могут работать одновременно без повторной отправки одних и тех же записей.
+type Relay struct {
+	db            *gorm.DB
+	publisher     ConfirmPublisher
+	pollInterval  time.Duration
+	batchSize     int
+	sentRetention time.Duration
+	maxAttempts   int
+	logger        *log.Logger
+}
+
+// NewRelay создает relay для таблицы outbox в db
+func NewRelay(db *gorm.DB, publisher ConfirmPublisher, cfg config.OutboxConfig, logger *log.Logger) *Relay {
+	if logger == nil {
+		logger = log.New(log.Writer(), "[OutboxRelay] ", log.LstdFlags)
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+
+	return &Relay{
+		db:            db,
+		publisher:     publisher,
+		pollInterval:  cfg.PollInterval,
+		batchSize:     cfg.BatchSize,
+		sentRetention: cfg.SentRetention,
+		maxAttempts:   cfg.MaxAttempts,
+		logger:        logger,
+	}
+}
+
+// Run периодически отправляет ожидающие сообщения до отмены ctx
+func (r *Relay) Run(ctx context.Context) {
+	r.logger.Printf("[INFO] Relay outbox запущен (интервал %v, пакет %d)", r.pollInterval, r.batchSize)
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	lastCleanup := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Printf("[INFO] Relay outbox остановлен")
+			return
+		case <-ticker.C:
+			// Пока пакеты заполнены полностью, в outbox остаются сообщения — отправляем их без ожидания
+			for {
+				sent, err := r.ProcessBatch(ctx)
+				if err != nil {
+					r.logger.Printf("[ERROR] Ошибка отправки сообщений outbox: %v", err)
+					break
+				}
+				if sent < r.batchSize || ctx.Err() != nil {
+					break
+				}
+			}
+
+			if r.sentRetention > 0 && time.Since(lastCleanup) >= cleanupInterval {
+				if err := r.DeleteSent(ctx, time.Now().Add(-r.sentRetention)); err != nil {
+					r.logger.Printf("[ERROR] Ошибка очистки outbox: %v", err)
+				}
+				lastCleanup = time.Now()
+			}
+		}
+	}
+}
+
+// ProcessBatch отправляет очередной пакет ожидающих сообщений в порядке их создания.
+// При ошибке публикации отправка пакета прекращается, чтобы не нарушить порядок сообщений;
+// неотправленное сообщение будет повторено на следующем проходе. Сообщение, которое не удалось отправить
+// за maxAttempts попыток (например, неустранимо не маршрутизируемое), откладывается в статус failed,
+// и отправка пакета продолжается, чтобы оно не блокировало следующие сообщения.
+// Возвращает количество отправленных сообщений.
+func (r *Relay) ProcessBatch(ctx context.Context) (int, error) {
+	sent := 0
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var messages []Message
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ?", StatusPending).
+			Order("id").
+			Limit(r.batchSize).
+			Find(&messages).Error; err != nil {
+			return fmt.Errorf("ошибка выборки сообщений outbox: %w", err)
+		}
+
+		for _, msg := range messages {
+			pubCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+			pubErr := r.publisher.PublishConfirmed(pubCtx, msg.Exchange, msg.RoutingKey, msg.Payload)
+			cancel()
+
+			if pubErr != nil {
+				attempts := msg.Attempts + 1
+				if attempts < r.maxAttempts {
+					r.logger.Printf("[WARN] Не удалось отправить сообщение outbox %d (%s, %s), попытка %d из %d: %v",
+						msg.ID, msg.Exchange, msg.RoutingKey, attempts, r.maxAttempts, pubErr)
+					return tx.Model(&Message{}).Where("id = ?", msg.ID).
+						Updates(map[string]interface{}{
+							"attempts":   attempts,
+							"last_error": pubErr.Error(),
+						}).Error
+				}
+
+				r.logger.Printf("[ERROR] Сообщение outbox %d (%s, %s) не отправлено за %d попыток и отложено: %v",
+					msg.ID, msg.Exchange, msg.RoutingKey, attempts, pubErr)
+				if err := tx.Model(&Message{}).Where("id = ?", msg.ID).
+					Updates(map[string]interface{}{
+						"status":     StatusFailed,
+						"attempts":   attempts,
+						"last_error": pubErr.Error(),
+					}).Error; err != nil {
+					return fmt.Errorf("ошибка отметки сообщения outbox %d неотправленным: %w", msg.ID, err)
+				}
+				continue
+			}
+
+			now := time.Now()
+			if err := tx.Model(&Message{}).Where("id = ?", msg.ID).
+				Updates(map[string]interface{}{
+					"status":  StatusSent,
+					"sent_at": now,
+				}).Error; err != nil {
+				return fmt.Errorf("ошибка отметки сообщения outbox %d отправленным: %w", msg.ID, err)
+			}
+			sent++
+		}
+		return nil
+	})
+	if err != nil {
+		// Транзакция откатилась: сообщения останутся в статусе pending и могут быть отправлены повторно
+		return 0, err
+	}
+	return sent, nil
+}
+
+// DeleteSent удаляет отправленные сообщения, подтвержденные брокером раньше before
+func (r *Relay) DeleteSent(ctx context.Context, before time.Time) error {
+	result := r.db.WithContext(ctx).
+		Where("status = ? AND sent_at < ?", StatusSent, before).
+		Delete(&Message{})
+	if result.Error != nil {
+		return fmt.Errorf("ошибка удаления отправленных сообщений outbox: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		r.logger.Printf("[INFO] Удалено %d отправленных сообщений outbox", result.RowsAffected)
+	}
+	return nil
+}
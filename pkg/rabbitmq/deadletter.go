@@ -0,0 +1,316 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// DeadLetterExchange exchange, через который отклоненные брокером сообщения попадают в parking-очереди
+	DeadLetterExchange = "dead_letter"
+
+	// HeaderRetryCount количество выполненных повторных доставок сообщения
+	HeaderRetryCount = "x-retry-count"
+	// HeaderLastError текст последней ошибки обработки
+	HeaderLastError = "x-last-error"
+	// HeaderOriginalQueue очередь, из которой сообщение было перенесено в parking-очередь
+	HeaderOriginalQueue = "x-original-queue"
+	// HeaderParkedAt время переноса сообщения в parking-очередь
+	HeaderParkedAt = "x-parked-at"
+
+	// defaultMaxRedeliveries количество повторных доставок по умолчанию
+	defaultMaxRedeliveries = 5
+	// defaultRedeliveryBaseDelay задержка перед первой повторной доставкой по умолчанию
+	defaultRedeliveryBaseDelay = time.Second
+	// maxLastErrorLength ограничение длины текста ошибки в заголовке сообщения
+	maxLastErrorLength = 1024
+)
+
+// ErrUnknownQueue очередь не объявлялась этим клиентом, parking-очередь для нее неизвестна
+var ErrUnknownQueue = errors.New("очередь не найдена")
+
+// ParkedMessage сообщение, перенесенное в parking-очередь после исчерпания повторных доставок
+type ParkedMessage struct {
+	MessageID  string          `json:"message_id"`
+	Queue      string          `json:"queue"`
+	RetryCount int             `json:"retry_count"`
+	LastError  string          `json:"last_error,omitempty"`
+	ParkedAt   *time.Time      `json:"parked_at,omitempty"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// ParkingQueueName возвращает имя parking-очереди для очереди queueName
+func ParkingQueueName(queueName string) string {
+	return queueName + ".parking"
+}
+
+// retryQueueName возвращает имя очереди задержки для повторной доставки с задержкой delay.
+// Задержка входит в имя, чтобы изменение настроек не конфликтовало с уже объявленными очередями.
+func retryQueueName(queueName string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", queueName, delay)
+}
+
+// redeliveryDelay возвращает задержку перед повторной доставкой с номером attempt (начиная с 1)
+func (r *RabbitMQ) redeliveryDelay(attempt int) time.Duration {
+	return r.config.RedeliveryBaseDelay * time.Duration(1<<uint(attempt-1))
+}
+
+// declareQueueTopology объявляет очередь name и связанные с ней очереди:
+//   - name.retry.<задержка> — очереди задержки без потребителей; по истечении TTL сообщение возвращается в name;
+//   - name.parking — очередь для сообщений, исчерпавших повторные доставки, привязанная к DeadLetterExchange.
+//
+// Сама очередь получает DeadLetterExchange, поэтому отклоненные без повторной постановки сообщения также попадают в parking-очередь.
+func (r *RabbitMQ) declareQueueTopology(name string) (amqp.Queue, error) {
+	if err := r.channel.ExchangeDeclare(DeadLetterExchange, "direct", true, false, false, false, nil); err != nil {
+		return amqp.Queue{}, fmt.Errorf("ошибка объявления exchange %s: %w", DeadLetterExchange, err)
+	}
+
+	parking := ParkingQueueName(name)
+	if _, err := r.channel.QueueDeclare(parking, true, false, false, false, nil); err != nil {
+		return amqp.Queue{}, fmt.Errorf("ошибка объявления очереди %s: %w", parking, err)
+	}
+	if err := r.channel.QueueBind(parking, name, DeadLetterExchange, false, nil); err != nil {
+		return amqp.Queue{}, fmt.Errorf("ошибка привязки очереди %s: %w", parking, err)
+	}
+
+	for attempt := 1; attempt <= r.config.MaxRedeliveries; attempt++ {
+		delay := r.redeliveryDelay(attempt)
+		_, err := r.channel.QueueDeclare(retryQueueName(name, delay), true, false, false, false, amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": name,
+		})
+		if err != nil {
+			return amqp.Queue{}, fmt.Errorf("ошибка объявления очереди задержки для %s: %w", name, err)
+		}
+	}
+
+	queue, err := r.channel.QueueDeclare(name, true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange":    DeadLetterExchange,
+		"x-dead-letter-routing-key": name,
+	})
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		// Очередь создана ранее без dead-letter аргументов. Повторные доставки и parking-очередь
+		// работают и без них, поэтому используем существующую очередь (брокер закрыл канал — открываем заново).
+		log.Printf("Очередь %s уже существует с другими аргументами, dead-letter exchange для нее не настроен: %v", name, err)
+		if r.channel, err = r.connection.Channel(); err != nil {
+			return amqp.Queue{}, fmt.Errorf("failed to reopen channel: %w", err)
+		}
+		queue, err = r.channel.QueueDeclarePassive(name, true, false, false, false, nil)
+	}
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	r.queuesMu.Lock()
+	r.queues[name] = true
+	r.queuesMu.Unlock()
+
+	return queue, nil
+}
+
+// scheduleRedelivery откладывает сообщение, обработка которого завершилась ошибкой handlerErr:
+// публикует его в очередь задержки со следующим номером попытки, а после MaxRedeliveries попыток — в parking-очередь.
+func (r *RabbitMQ) scheduleRedelivery(queueName string, msg amqp.Delivery, handlerErr error) error {
+	attempt := headerInt(msg.Headers, HeaderRetryCount) + 1
+
+	headers := amqp.Table{}
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	lastError := handlerErr.Error()
+	if len(lastError) > maxLastErrorLength {
+		lastError = lastError[:maxLastErrorLength]
+	}
+	headers[HeaderLastError] = lastError
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	if attempt > r.config.MaxRedeliveries {
+		headers[HeaderRetryCount] = int32(attempt - 1)
+		headers[HeaderOriginalQueue] = queueName
+		headers[HeaderParkedAt] = time.Now()
+		log.Printf("Сообщение %s из %s не обработано после %d повторных доставок, перенесено в %s",
+			msg.MessageId, queueName, attempt-1, ParkingQueueName(queueName))
+		return r.publish(ctx, DeadLetterExchange, queueName, true, deliveryToPublishing(msg, headers))
+	}
+
+	headers[HeaderRetryCount] = int32(attempt)
+	delay := r.redeliveryDelay(attempt)
+	log.Printf("Повторная доставка сообщения %s в %s через %v (попытка %d из %d)",
+		msg.MessageId, queueName, delay, attempt, r.config.MaxRedeliveries)
+	return r.publish(ctx, "", retryQueueName(queueName, delay), true, deliveryToPublishing(msg, headers))
+}
+
+// ParkedQueues возвращает очереди, для которых этим клиентом объявлены parking-очереди
+func (r *RabbitMQ) ParkedQueues() []string {
+	r.queuesMu.Lock()
+	defer r.queuesMu.Unlock()
+
+	queues := make([]string, 0, len(r.queues))
+	for name := range r.queues {
+		queues = append(queues, name)
+	}
+	sort.Strings(queues)
+	return queues
+}
+
+// ListParked возвращает до limit сообщений из parking-очереди queueName и общее количество сообщений в ней.
+// Сообщения читаются без подтверждения и возвращаются в очередь при закрытии временного канала.
+func (r *RabbitMQ) ListParked(queueName string, limit int) ([]ParkedMessage, int, error) {
+	ch, err := r.adminChannel(queueName)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer ch.Close()
+
+	queue, err := ch.QueueDeclarePassive(ParkingQueueName(queueName), true, false, false, false, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка получения parking-очереди %s: %w", queueName, err)
+	}
+
+	messages := make([]ParkedMessage, 0, limit)
+	for len(messages) < limit {
+		msg, ok, err := ch.Get(queue.Name, false)
+		if err != nil {
+			return nil, 0, fmt.Errorf("ошибка чтения parking-очереди %s: %w", queueName, err)
+		}
+		if !ok {
+			break
+		}
+		messages = append(messages, toParkedMessage(queueName, msg))
+	}
+	return messages, queue.Messages, nil
+}
+
+// ReplayParked возвращает сообщения из parking-очереди queueName в исходную очередь со сброшенным счетчиком доставок.
+// Если messageIDs пуст, возвращаются все сообщения. Возвращает количество перенесенных сообщений.
+func (r *RabbitMQ) ReplayParked(queueName string, messageIDs []string) (int, error) {
+	ch, err := r.adminChannel(queueName)
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	selected := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		selected[id] = true
+	}
+
+	replayed := 0
+	for {
+		msg, ok, err := ch.Get(ParkingQueueName(queueName), false)
+		if err != nil {
+			return replayed, fmt.Errorf("ошибка чтения parking-очереди %s: %w", queueName, err)
+		}
+		if !ok {
+			break
+		}
+		// Невыбранные сообщения остаются неподтвержденными и вернутся в parking-очередь при закрытии канала
+		if len(selected) > 0 && !selected[msg.MessageId] {
+			continue
+		}
+
+		headers := amqp.Table{}
+		for key, value := range msg.Headers {
+			switch key {
+			case HeaderRetryCount, HeaderLastError, HeaderOriginalQueue, HeaderParkedAt, "x-death":
+			default:
+				headers[key] = value
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		err = r.publish(ctx, "", queueName, true, deliveryToPublishing(msg, headers))
+		cancel()
+		if err != nil {
+			return replayed, fmt.Errorf("ошибка возврата сообщения %s в очередь %s: %w", msg.MessageId, queueName, err)
+		}
+		if err := msg.Ack(false); err != nil {
+			return replayed, fmt.Errorf("ошибка удаления сообщения %s из parking-очереди: %w", msg.MessageId, err)
+		}
+		replayed++
+	}
+
+	if replayed > 0 {
+		log.Printf("Из parking-очереди %s возвращено сообщений: %d", ParkingQueueName(queueName), replayed)
+	}
+	return replayed, nil
+}
+
+// adminChannel открывает временный канал для операций с parking-очередью известной очереди queueName
+func (r *RabbitMQ) adminChannel(queueName string) (*amqp.Channel, error) {
+	r.queuesMu.Lock()
+	known := r.queues[queueName]
+	r.queuesMu.Unlock()
+	if !known {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownQueue, queueName)
+	}
+
+	if err := r.reconnect(); err != nil {
+		return nil, fmt.Errorf("ошибка переподключения к RabbitMQ: %w", err)
+	}
+	ch, err := r.connection.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	return ch, nil
+}
+
+// deliveryToPublishing копирует полученное сообщение для повторной публикации с заголовками headers
+func deliveryToPublishing(msg amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:       headers,
+		ContentType:   msg.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: msg.CorrelationId,
+		MessageId:     msg.MessageId,
+		Timestamp:     msg.Timestamp,
+		Type:          msg.Type,
+		Body:          msg.Body,
+	}
+}
+
+// toParkedMessage преобразует сообщение parking-очереди в ParkedMessage
+func toParkedMessage(queueName string, msg amqp.Delivery) ParkedMessage {
+	parked := ParkedMessage{
+		MessageID:  msg.MessageId,
+		Queue:      queueName,
+		RetryCount: headerInt(msg.Headers, HeaderRetryCount),
+	}
+	if lastError, ok := msg.Headers[HeaderLastError].(string); ok {
+		parked.LastError = lastError
+	}
+	if parkedAt, ok := msg.Headers[HeaderParkedAt].(time.Time); ok {
+		parked.ParkedAt = &parkedAt
+	}
+	if json.Valid(msg.Body) {
+		parked.Body = json.RawMessage(msg.Body)
+	} else {
+		parked.Body, _ = json.Marshal(string(msg.Body))
+	}
+	return parked
+}
+
+// headerInt возвращает целочисленное значение заголовка key или 0
+func headerInt(headers amqp.Table, key string) int {
+	switch value := headers[key].(type) {
+	case int32:
+		return int(value)
+	case int64:
+		return int(value)
+	case int:
+		return value
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,217 @@
+package rabbitmq
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAcknowledger запоминает подтверждения и отказы по сообщениям
+type fakeAcknowledger struct {
+	acked   []uint64
+	nacked  []uint64
+	requeue []bool
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = append(a.acked, tag)
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.nacked = append(a.nacked, tag)
+	a.requeue = append(a.requeue, requeue)
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func newDelivery(ack amqp.Acknowledger, tag uint64, headers amqp.Table) amqp.Delivery {
+	return amqp.Delivery{
+		Acknowledger: ack,
+		DeliveryTag:  tag,
+		Headers:      headers,
+		MessageId:    "msg-1",
+		ContentType:  "application/json",
+		Body:         []byte(`{"order_id":1}`),
+	}
+}
+
+// TestRedeliveryDelay тестирует удвоение задержки с каждой попыткой
+func TestRedeliveryDelay(t *testing.T) {
+	r := newTestRabbitMQ(&fakeChannel{})
+
+	assert.Equal(t, time.Second, r.redeliveryDelay(1))
+	assert.Equal(t, 2*time.Second, r.redeliveryDelay(2))
+	assert.Equal(t, 4*time.Second, r.redeliveryDelay(3))
+}
+
+// TestScheduleRedelivery_FirstAttempt тестирует публикацию в очередь задержки первой попытки
+func TestScheduleRedelivery_FirstAttempt(t *testing.T) {
+	ch := &fakeChannel{confirmation: fakeConfirmation{acked: true}}
+	r := newTestRabbitMQ(ch)
+
+	err := r.scheduleRedelivery("billing_queue", newDelivery(&fakeAcknowledger{}, 1, amqp.Table{"trace": "abc"}), errors.New("временная ошибка"))
+
+	assert.NoError(t, err)
+	if assert.Len(t, ch.published, 1) {
+		published := ch.published[0]
+		assert.Equal(t, "", published.exchange)
+		assert.Equal(t, "billing_queue.retry.1s", published.routingKey)
+		assert.True(t, published.mandatory)
+		assert.Equal(t, int32(1), published.msg.Headers[HeaderRetryCount])
+		assert.Equal(t, "временная ошибка", published.msg.Headers[HeaderLastError])
+		assert.Equal(t, "abc", published.msg.Headers["trace"])
+		assert.Equal(t, "msg-1", published.msg.MessageId)
+		assert.Equal(t, []byte(`{"order_id":1}`), published.msg.Body)
+	}
+}
+
+// TestScheduleRedelivery_NextAttempt тестирует выбор очереди задержки по счетчику повторных доставок
+func TestScheduleRedelivery_NextAttempt(t *testing.T) {
+	ch := &fakeChannel{confirmation: fakeConfirmation{acked: true}}
+	r := newTestRabbitMQ(ch)
+
+	err := r.scheduleRedelivery("billing_queue", newDelivery(&fakeAcknowledger{}, 1, amqp.Table{HeaderRetryCount: int32(2)}), errors.New("ошибка"))
+
+	assert.NoError(t, err)
+	if assert.Len(t, ch.published, 1) {
+		assert.Equal(t, "billing_queue.retry.4s", ch.published[0].routingKey)
+		assert.Equal(t, int32(3), ch.published[0].msg.Headers[HeaderRetryCount])
+	}
+}
+
+// TestScheduleRedelivery_ParksAfterMaxRedeliveries тестирует перенос сообщения в parking-очередь после исчерпания попыток
+func TestScheduleRedelivery_ParksAfterMaxRedeliveries(t *testing.T) {
+	ch := &fakeChannel{confirmation: fakeConfirmation{acked: true}}
+	r := newTestRabbitMQ(ch)
+
+	err := r.scheduleRedelivery("billing_queue", newDelivery(&fakeAcknowledger{}, 1, amqp.Table{HeaderRetryCount: int32(3)}), errors.New("постоянная ошибка"))
+
+	assert.NoError(t, err)
+	if assert.Len(t, ch.published, 1) {
+		published := ch.published[0]
+		assert.Equal(t, DeadLetterExchange, published.exchange)
+		assert.Equal(t, "billing_queue", published.routingKey)
+		assert.Equal(t, int32(3), published.msg.Headers[HeaderRetryCount])
+		assert.Equal(t, "billing_queue", published.msg.Headers[HeaderOriginalQueue])
+		assert.Equal(t, "постоянная ошибка", published.msg.Headers[HeaderLastError])
+		assert.IsType(t, time.Time{}, published.msg.Headers[HeaderParkedAt])
+	}
+}
+
+// TestScheduleRedelivery_TruncatesLastError тестирует ограничение длины текста ошибки в заголовке
+func TestScheduleRedelivery_TruncatesLastError(t *testing.T) {
+	ch := &fakeChannel{confirmation: fakeConfirmation{acked: true}}
+	r := newTestRabbitMQ(ch)
+
+	err := r.scheduleRedelivery("billing_queue", newDelivery(&fakeAcknowledger{}, 1, nil), errors.New(strings.Repeat("x", 2*maxLastErrorLength)))
+
+	assert.NoError(t, err)
+	if assert.Len(t, ch.published, 1) {
+		assert.Len(t, ch.published[0].msg.Headers[HeaderLastError], maxLastErrorLength)
+	}
+}
+
+// TestHandleMessages тестирует подтверждение, отложенную повторную доставку и возврат сообщения в очередь
+func TestHandleMessages(t *testing.T) {
+	tests := []struct {
+		name         string
+		handlerErr   error
+		confirmation fakeConfirmation
+		wantAcked    []uint64
+		wantNacked   []uint64
+		wantRequeue  []bool
+		wantRetries  int
+	}{
+		{
+			name:         "успешная обработка",
+			confirmation: fakeConfirmation{acked: true},
+			wantAcked:    []uint64{1},
+		},
+		{
+			name:         "ошибка обработки откладывает сообщение",
+			handlerErr:   errors.New("ошибка"),
+			confirmation: fakeConfirmation{acked: true},
+			wantAcked:    []uint64{1},
+			wantRetries:  1,
+		},
+		{
+			name:         "сообщение не удалось отложить",
+			handlerErr:   errors.New("ошибка"),
+			confirmation: fakeConfirmation{acked: false},
+			wantNacked:   []uint64{1},
+			wantRequeue:  []bool{true},
+			wantRetries:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &fakeChannel{confirmation: tt.confirmation}
+			r := newTestRabbitMQ(ch)
+			ack := &fakeAcknowledger{}
+
+			msgs := make(chan amqp.Delivery, 1)
+			msgs <- newDelivery(ack, 1, nil)
+			close(msgs)
+
+			r.HandleMessages("billing_queue", msgs, func([]byte) error { return tt.handlerErr })
+
+			assert.Equal(t, tt.wantAcked, ack.acked)
+			assert.Equal(t, tt.wantNacked, ack.nacked)
+			assert.Equal(t, tt.wantRequeue, ack.requeue)
+			assert.Len(t, ch.published, tt.wantRetries)
+		})
+	}
+}
+
+// TestToParkedMessage тестирует преобразование сообщения parking-очереди
+func TestToParkedMessage(t *testing.T) {
+	parkedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	msg := amqp.Delivery{
+		MessageId: "msg-1",
+		Headers: amqp.Table{
+			HeaderRetryCount: int32(5),
+			HeaderLastError:  "ошибка",
+			HeaderParkedAt:   parkedAt,
+		},
+		Body: []byte(`{"order_id":1}`),
+	}
+
+	parked := toParkedMessage("billing_queue", msg)
+
+	assert.Equal(t, "msg-1", parked.MessageID)
+	assert.Equal(t, "billing_queue", parked.Queue)
+	assert.Equal(t, 5, parked.RetryCount)
+	assert.Equal(t, "ошибка", parked.LastError)
+	assert.Equal(t, &parkedAt, parked.ParkedAt)
+	assert.JSONEq(t, `{"order_id":1}`, string(parked.Body))
+
+	msg.Body = []byte("not json")
+	assert.JSONEq(t, `"not json"`, string(toParkedMessage("billing_queue", msg).Body))
+}
+
+// TestHeaderInt тестирует чтение целочисленных заголовков разных типов
+func TestHeaderInt(t *testing.T) {
+	assert.Equal(t, 2, headerInt(amqp.Table{"n": int32(2)}, "n"))
+	assert.Equal(t, 3, headerInt(amqp.Table{"n": int64(3)}, "n"))
+	assert.Equal(t, 4, headerInt(amqp.Table{"n": 4}, "n"))
+	assert.Equal(t, 0, headerInt(amqp.Table{"n": "5"}, "n"))
+	assert.Equal(t, 0, headerInt(nil, "n"))
+}
+
+// TestReplayParked_UnknownQueue тестирует отказ для очереди, не объявленной клиентом
+func TestReplayParked_UnknownQueue(t *testing.T) {
+	r := newTestRabbitMQ(&fakeChannel{})
+
+	_, err := r.ReplayParked("unknown_queue", nil)
+
+	assert.ErrorIs(t, err, ErrUnknownQueue)
+}
@@ -3,13 +3,31 @@ package rabbitmq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+const (
+	// publishTimeout время ожидания подтверждения публикации брокером
+	publishTimeout = 5 * time.Second
+	// returnsBuffer размер буфера для сообщений, возвращенных брокером
+	returnsBuffer = 16
+)
+
+var (
+	// ErrNacked брокер отказался принять сообщение (basic.nack)
+	ErrNacked = errors.New("брокер не подтвердил сообщение")
+	// ErrUnroutable сообщение с флагом mandatory не попало ни в одну очередь и возвращено брокером
+	ErrUnroutable = errors.New("сообщение не маршрутизировано ни в одну очередь")
+	// ErrNotConfirmed подтверждение публикации не получено вовремя
+	ErrNotConfirmed = errors.New("не получено подтверждение публикации")
+)
+
 // Config содержит настройки подключения к RabbitMQ
 type Config struct {
 	Host     string
@@ -17,6 +35,15 @@ type Config struct {
 	User     string
 	Password string
 	VHost    string
+
+	// MandatoryExchanges exchanges, сообщения в которые публикуются с флагом mandatory:
+	// сообщение, не попавшее ни в одну очередь, возвращается брокером, и публикация завершается ошибкой ErrUnroutable
+	MandatoryExchanges []string
+
+	// MaxRedeliveries количество повторных доставок сообщения, обработка которого завершилась ошибкой
+	MaxRedeliveries int
+	// RedeliveryBaseDelay задержка перед первой повторной доставкой, каждая следующая вдвое дольше
+	RedeliveryBaseDelay time.Duration
 }
 
 // RabbitMQ представляет клиент для работы с RabbitMQ
@@ -24,11 +51,58 @@ type RabbitMQ struct {
 	config     Config
 	connection *amqp.Connection
 	channel    *amqp.Channel
+
+	mandatory map[string]bool
+
+	publishMu      sync.Mutex       // Сериализует публикации: подтверждение ожидается для каждого сообщения
+	publishChannel confirmChannel   // Канал в режиме publisher confirms
+	returns        chan amqp.Return // Сообщения, возвращенные брокером для publishChannel
+	publishSeq     uint64           // Счетчик для идентификаторов опубликованных сообщений
+
+	queuesMu sync.Mutex
+	queues   map[string]bool // Очереди, объявленные с parking-очередью
+}
+
+// confirmChannel канал публикации в режиме publisher confirms
+type confirmChannel interface {
+	PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (confirmation, error)
+	IsClosed() bool
+	Close() error
+}
+
+// confirmation ожидание подтверждения брокером опубликованного сообщения
+type confirmation interface {
+	WaitContext(ctx context.Context) (bool, error)
+}
+
+// amqpConfirmChannel адаптер amqp.Channel к confirmChannel
+type amqpConfirmChannel struct {
+	*amqp.Channel
+}
+
+// PublishWithDeferredConfirmWithContext публикует сообщение и возвращает ожидание его подтверждения
+func (c amqpConfirmChannel) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (confirmation, error) {
+	deferred, err := c.Channel.PublishWithDeferredConfirmWithContext(ctx, exchange, key, mandatory, immediate, msg)
+	if err != nil {
+		return nil, err
+	}
+	return deferred, nil
 }
 
 func NewRabbitMQ(cfg Config) (*RabbitMQ, error) {
 	rmq := &RabbitMQ{
-		config: cfg,
+		config:    cfg,
+		mandatory: make(map[string]bool, len(cfg.MandatoryExchanges)),
+		queues:    make(map[string]bool),
+	}
+	if rmq.config.MaxRedeliveries <= 0 {
+		rmq.config.MaxRedeliveries = defaultMaxRedeliveries
+	}
+	if rmq.config.RedeliveryBaseDelay <= 0 {
+		rmq.config.RedeliveryBaseDelay = defaultRedeliveryBaseDelay
+	}
+	for _, exchange := range cfg.MandatoryExchanges {
+		rmq.mandatory[exchange] = true
 	}
 
 	err := rmq.connect()
@@ -73,6 +147,11 @@ func (r *RabbitMQ) reconnect() error {
 // Close закрывает соединение с RabbitMQ
 func (r *RabbitMQ) Close() error {
 	var err error
+	if r.publishChannel != nil && !r.publishChannel.IsClosed() {
+		if err = r.publishChannel.Close(); err != nil {
+			return fmt.Errorf("ошибка при закрытии канала публикации: %w", err)
+		}
+	}
 	if r.channel != nil {
 		if err = r.channel.Close(); err != nil {
 			return fmt.Errorf("ошибка при закрытии канала: %w", err)
@@ -103,20 +182,9 @@ func (r *RabbitMQ) DeclareExchange(name string, kind string) error {
 	)
 }
 
-// DeclareQueue объявляет очередь
+// DeclareQueue объявляет очередь вместе с очередями повторной доставки и parking-очередью (см. declareQueueTopology)
 func (r *RabbitMQ) DeclareQueue(name string) error {
-	if err := r.reconnect(); err != nil {
-		return fmt.Errorf("ошибка переподключения перед объявлением очереди: %w", err)
-	}
-
-	_, err := r.channel.QueueDeclare(
-		name,  // name
-		true,  // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // no-wait
-		nil,   // arguments
-	)
+	_, err := r.DeclareQueueWithReturn(name)
 	return err
 }
 
@@ -126,14 +194,7 @@ func (r *RabbitMQ) DeclareQueueWithReturn(name string) (amqp.Queue, error) {
 		return amqp.Queue{}, fmt.Errorf("ошибка переподключения перед объявлением очереди: %w", err)
 	}
 
-	return r.channel.QueueDeclare(
-		name,  // name
-		true,  // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // no-wait
-		nil,   // arguments
-	)
+	return r.declareQueueTopology(name)
 }
 
 // BindQueue привязывает очередь к exchange
@@ -151,35 +212,123 @@ func (r *RabbitMQ) BindQueue(queueName, exchangeName, routingKey string) error {
 	)
 }
 
-// PublishMessage публикует сообщение в RabbitMQ
+// PublishMessage публикует сообщение в RabbitMQ.
+// Возвращает nil только после подтверждения сообщения брокером.
 func (r *RabbitMQ) PublishMessage(exchange, routingKey string, message interface{}) error {
-	if err := r.reconnect(); err != nil {
-		return fmt.Errorf("ошибка переподключения перед публикацией сообщения: %w", err)
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
 	defer cancel()
 
-	body, err := json.Marshal(message)
-	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+	return r.PublishConfirmed(ctx, exchange, routingKey, body)
+}
+
+// PublishConfirmed публикует готовое JSON-тело сообщения и ожидает подтверждения от брокера.
+// Возвращает ErrNacked, если брокер отклонил сообщение, ErrUnroutable, если сообщение в mandatory exchange
+// не попало ни в одну очередь, и ErrNotConfirmed, если подтверждение не пришло до отмены ctx.
+func (r *RabbitMQ) PublishConfirmed(ctx context.Context, exchange, routingKey string, body []byte) error {
+	return r.publish(ctx, exchange, routingKey, r.mandatory[exchange], amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Body:         body,
+	})
+}
+
+// publish публикует msg через канал подтверждений и ожидает подтверждения брокера
+func (r *RabbitMQ) publish(ctx context.Context, exchange, routingKey string, mandatory bool, msg amqp.Publishing) error {
+	r.publishMu.Lock()
+	defer r.publishMu.Unlock()
+
+	if err := r.openPublishChannel(); err != nil {
+		return err
+	}
+
+	r.publishSeq++
+	if msg.MessageId == "" {
+		msg.MessageId = fmt.Sprintf("%d-%d", time.Now().UnixNano(), r.publishSeq)
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
 	}
 
-	return r.channel.PublishWithContext(
+	confirmation, err := r.publishChannel.PublishWithDeferredConfirmWithContext(
 		ctx,
 		exchange,   // exchange
 		routingKey, // routing key
-		false,      // mandatory
+		mandatory,  // mandatory
 		false,      // immediate
-		amqp.Publishing{
-			ContentType:  "application/json",
-			DeliveryMode: amqp.Persistent,
-			Body:         body,
-		},
+		msg,
 	)
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("%w (%s, %s): %v", ErrNotConfirmed, exchange, routingKey, err)
+	}
+	if !acked {
+		return fmt.Errorf("%w (%s, %s)", ErrNacked, exchange, routingKey)
+	}
+
+	// Брокер отправляет basic.return до basic.ack, поэтому к этому моменту возврат уже получен
+	if mandatory && r.takeReturn(msg.MessageId) {
+		return fmt.Errorf("%w (%s, %s)", ErrUnroutable, exchange, routingKey)
+	}
+	return nil
+}
+
+// openPublishChannel открывает канал публикации в режиме confirms, если он еще не открыт или был закрыт брокером,
+// при необходимости переподключаясь к RabbitMQ. Вызывается под publishMu.
+func (r *RabbitMQ) openPublishChannel() error {
+	if r.publishChannel != nil && !r.publishChannel.IsClosed() {
+		return nil
+	}
+
+	if err := r.reconnect(); err != nil {
+		return fmt.Errorf("ошибка переподключения перед публикацией сообщения: %w", err)
+	}
+	ch, err := r.connection.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open publish channel: %w", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	r.returns = ch.NotifyReturn(make(chan amqp.Return, returnsBuffer))
+	r.publishChannel = amqpConfirmChannel{Channel: ch}
+	return nil
+}
+
+// takeReturn забирает все полученные возвраты и сообщает, был ли среди них возврат сообщения messageID.
+// Возвраты сообщений, для которых подтверждение не дождались, только логируются.
+// Вызывается под publishMu.
+func (r *RabbitMQ) takeReturn(messageID string) bool {
+	returned := false
+	for {
+		select {
+		case ret, ok := <-r.returns:
+			if !ok {
+				return returned
+			}
+			if ret.MessageId == messageID {
+				returned = true
+				continue
+			}
+			log.Printf("Брокер вернул сообщение %s (%s, %s): %s", ret.MessageId, ret.Exchange, ret.RoutingKey, ret.ReplyText)
+		default:
+			return returned
+		}
+	}
 }
 
-// PublishMessageWithRetry публикует сообщение с повторными попытками
+// PublishMessageWithRetry публикует сообщение с повторными попытками.
+// Повторяется любая неудачная публикация, включая отказ брокера, возврат и отсутствие подтверждения.
 func (r *RabbitMQ) PublishMessageWithRetry(exchange, routingKey string, message interface{}, retries int) error {
 	var err error
 	for i := 0; i <= retries; i++ {
@@ -224,7 +373,7 @@ func (r *RabbitMQ) ConsumeMessages(queueName, consumerName string, handler func(
 		return fmt.Errorf("ошибка при начале обработки сообщений: %w", err)
 	}
 
-	go r.HandleMessages(msgs, handler)
+	go r.HandleMessages(queueName, msgs, handler)
 
 	return nil
 }
@@ -247,14 +396,24 @@ func containsTimestamp(s string) bool {
 	return false
 }
 
-func (r *RabbitMQ) HandleMessages(msgs <-chan amqp.Delivery, handler func([]byte) error) {
+// HandleMessages обрабатывает сообщения очереди queueName.
+// Сообщение, обработка которого завершилась ошибкой, откладывается для повторной доставки
+// с экспоненциальной задержкой, а после исчерпания попыток переносится в parking-очередь.
+func (r *RabbitMQ) HandleMessages(queueName string, msgs <-chan amqp.Delivery, handler func([]byte) error) {
 	for msg := range msgs {
 		err := handler(msg.Body)
-		if err != nil {
-			log.Printf("Error handling message: %v", err)
-			msg.Nack(false, true) // Сообщение не обработано и возвращается в очередь
-		} else {
+		if err == nil {
 			msg.Ack(false) // Подтверждаем обработку сообщения
+			continue
+		}
+
+		log.Printf("Error handling message from %s: %v", queueName, err)
+		if rErr := r.scheduleRedelivery(queueName, msg, err); rErr != nil {
+			// Не удалось отложить сообщение - возвращаем его в очередь, чтобы не потерять
+			log.Printf("Не удалось отложить повторную обработку сообщения из %s: %v", queueName, rErr)
+			msg.Nack(false, true)
+			continue
 		}
+		msg.Ack(false)
 	}
 }
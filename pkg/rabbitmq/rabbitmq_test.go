@@ -0,0 +1,170 @@
+package rabbitmq
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// publishedMessage сообщение, опубликованное через fakeChannel
+type publishedMessage struct {
+	exchange   string
+	routingKey string
+	mandatory  bool
+	msg        amqp.Publishing
+}
+
+// fakeConfirmation подтверждение с заранее заданным результатом
+type fakeConfirmation struct {
+	acked bool
+	err   error
+}
+
+func (c fakeConfirmation) WaitContext(ctx context.Context) (bool, error) {
+	return c.acked, c.err
+}
+
+// fakeChannel канал публикации, подтверждающий сообщения без брокера.
+// Если unroutable установлен, перед подтверждением сообщение возвращается в returns, как это делает брокер.
+type fakeChannel struct {
+	confirmation fakeConfirmation
+	publishErr   error
+	unroutable   bool
+	returns      chan amqp.Return
+	published    []publishedMessage
+}
+
+func (c *fakeChannel) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (confirmation, error) {
+	if c.publishErr != nil {
+		return nil, c.publishErr
+	}
+	c.published = append(c.published, publishedMessage{exchange: exchange, routingKey: key, mandatory: mandatory, msg: msg})
+	if c.unroutable && mandatory {
+		c.returns <- amqp.Return{MessageId: msg.MessageId, Exchange: exchange, RoutingKey: key, ReplyText: "NO_ROUTE"}
+	}
+	return c.confirmation, nil
+}
+
+func (c *fakeChannel) IsClosed() bool { return false }
+
+func (c *fakeChannel) Close() error { return nil }
+
+// newTestRabbitMQ создает клиент с подмененным каналом публикации
+func newTestRabbitMQ(ch *fakeChannel, mandatoryExchanges ...string) *RabbitMQ {
+	ch.returns = make(chan amqp.Return, returnsBuffer)
+	r := &RabbitMQ{
+		config:         Config{MaxRedeliveries: 3, RedeliveryBaseDelay: defaultRedeliveryBaseDelay},
+		mandatory:      make(map[string]bool),
+		publishChannel: ch,
+		returns:        ch.returns,
+		queues:         make(map[string]bool),
+	}
+	for _, exchange := range mandatoryExchanges {
+		r.mandatory[exchange] = true
+	}
+	return r
+}
+
+// TestPublishConfirmed_Acked тестирует успешную публикацию после подтверждения брокером
+func TestPublishConfirmed_Acked(t *testing.T) {
+	ch := &fakeChannel{confirmation: fakeConfirmation{acked: true}}
+	r := newTestRabbitMQ(ch, "saga_exchange")
+
+	err := r.PublishConfirmed(context.Background(), "saga_exchange", "saga.process_billing.execute", []byte(`{}`))
+
+	assert.NoError(t, err)
+	if assert.Len(t, ch.published, 1) {
+		assert.True(t, ch.published[0].mandatory)
+		assert.NotEmpty(t, ch.published[0].msg.MessageId)
+		assert.Equal(t, amqp.Persistent, ch.published[0].msg.DeliveryMode)
+	}
+}
+
+// TestPublishConfirmed_Nacked тестирует ошибку при отказе брокера принять сообщение
+func TestPublishConfirmed_Nacked(t *testing.T) {
+	ch := &fakeChannel{confirmation: fakeConfirmation{acked: false}}
+	r := newTestRabbitMQ(ch)
+
+	err := r.PublishConfirmed(context.Background(), "order_events", "order.created", []byte(`{}`))
+
+	assert.ErrorIs(t, err, ErrNacked)
+}
+
+// TestPublishConfirmed_NotConfirmed тестирует ошибку, если подтверждение не получено до отмены контекста
+func TestPublishConfirmed_NotConfirmed(t *testing.T) {
+	ch := &fakeChannel{confirmation: fakeConfirmation{err: context.DeadlineExceeded}}
+	r := newTestRabbitMQ(ch)
+
+	err := r.PublishConfirmed(context.Background(), "order_events", "order.created", []byte(`{}`))
+
+	assert.ErrorIs(t, err, ErrNotConfirmed)
+}
+
+// TestPublishConfirmed_PublishError тестирует ошибку отправки сообщения в канал
+func TestPublishConfirmed_PublishError(t *testing.T) {
+	ch := &fakeChannel{publishErr: amqp.ErrClosed}
+	r := newTestRabbitMQ(ch)
+
+	err := r.PublishConfirmed(context.Background(), "order_events", "order.created", []byte(`{}`))
+
+	assert.ErrorIs(t, err, amqp.ErrClosed)
+}
+
+// TestPublishConfirmed_Unroutable тестирует ошибку, если сообщение в mandatory exchange возвращено брокером
+func TestPublishConfirmed_Unroutable(t *testing.T) {
+	ch := &fakeChannel{confirmation: fakeConfirmation{acked: true}, unroutable: true}
+	r := newTestRabbitMQ(ch, "saga_exchange")
+
+	err := r.PublishConfirmed(context.Background(), "saga_exchange", "saga.unknown.execute", []byte(`{}`))
+
+	assert.ErrorIs(t, err, ErrUnroutable)
+	assert.Empty(t, ch.returns, "возврат должен быть забран из канала")
+}
+
+// TestPublishConfirmed_NotMandatoryIgnoresRoute тестирует, что для exchange без mandatory возврат не запрашивается
+func TestPublishConfirmed_NotMandatoryIgnoresRoute(t *testing.T) {
+	ch := &fakeChannel{confirmation: fakeConfirmation{acked: true}, unroutable: true}
+	r := newTestRabbitMQ(ch, "saga_exchange")
+
+	err := r.PublishConfirmed(context.Background(), "order_events", "order.unknown", []byte(`{}`))
+
+	assert.NoError(t, err)
+	if assert.Len(t, ch.published, 1) {
+		assert.False(t, ch.published[0].mandatory)
+	}
+}
+
+// TestTakeReturn тестирует, что возврат другого сообщения не приводит к ошибке публикации, но забирается из канала
+func TestTakeReturn(t *testing.T) {
+	r := newTestRabbitMQ(&fakeChannel{})
+	r.returns <- amqp.Return{MessageId: "stale"}
+	r.returns <- amqp.Return{MessageId: "current"}
+
+	assert.True(t, r.takeReturn("current"))
+	assert.Empty(t, r.returns)
+
+	r.returns <- amqp.Return{MessageId: "stale"}
+	assert.False(t, r.takeReturn("current"))
+	assert.Empty(t, r.returns)
+}
+
+// TestTakeReturn_ClosedChannel тестирует обработку закрытого канала возвратов
+func TestTakeReturn_ClosedChannel(t *testing.T) {
+	r := newTestRabbitMQ(&fakeChannel{})
+	close(r.returns)
+
+	assert.False(t, r.takeReturn("current"))
+}
+
+// TestPublishMessageWithRetry_ReturnsLastError тестирует, что после исчерпания попыток возвращается последняя ошибка
+func TestPublishMessageWithRetry_ReturnsLastError(t *testing.T) {
+	ch := &fakeChannel{confirmation: fakeConfirmation{acked: false}}
+	r := newTestRabbitMQ(ch)
+
+	err := r.PublishMessageWithRetry("order_events", "order.created", map[string]uint{"order_id": 1}, 0)
+
+	assert.ErrorIs(t, err, ErrNacked)
+	assert.Len(t, ch.published, 1)
+}
@@ -0,0 +1,64 @@
+package sagahandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// SchemaVersion текущая версия контракта сообщений саги.
+	// Увеличивается при несовместимом изменении SagaMessage или SagaData;
+	// добавление необязательных полей версию не меняет.
+	SchemaVersion = 1
+	// MinSchemaVersion минимальная версия контракта, которую понимают обработчики
+	MinSchemaVersion = 1
+)
+
+// ErrIncompatibleSchema сообщение саги создано по несовместимой версии контракта
+var ErrIncompatibleSchema = errors.New("несовместимая версия контракта сообщения саги")
+
+// CheckCompatibility проверяет, что сообщение может быть обработано текущей версией контракта.
+// Сообщения без версии созданы до введения версионирования и соответствуют версии 1.
+func (m *SagaMessage) CheckCompatibility() error {
+	version := m.Version
+	if version == 0 {
+		version = 1
+	}
+	if version < MinSchemaVersion || version > SchemaVersion {
+		return fmt.Errorf("%w: версия %d, поддерживаются %d-%d (SagaID=%s, шаг %s)",
+			ErrIncompatibleSchema, version, MinSchemaVersion, SchemaVersion, m.SagaID, m.StepName)
+	}
+	return nil
+}
+
+// UnmarshalJSON читает BillingInfo, принимая transaction_id как строкой, так и числом:
+// ранние версии сервисов передавали идентификатор транзакции числом
+func (b *BillingInfo) UnmarshalJSON(data []byte) error {
+	type billingInfo BillingInfo
+	var raw struct {
+		billingInfo
+		TransactionID json.RawMessage `json:"transaction_id,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*b = BillingInfo(raw.billingInfo)
+	if len(raw.TransactionID) == 0 || string(raw.TransactionID) == "null" {
+		return nil
+	}
+
+	var id string
+	if err := json.Unmarshal(raw.TransactionID, &id); err == nil {
+		b.TransactionID = id
+		return nil
+	}
+	var numericID uint64
+	if err := json.Unmarshal(raw.TransactionID, &numericID); err != nil {
+		return fmt.Errorf("некорректный billing_info.transaction_id: %s", raw.TransactionID)
+	}
+	b.TransactionID = strconv.FormatUint(numericID, 10)
+	return nil
+}
@@ -0,0 +1,180 @@
+package sagahandler
+
+import (
+	"fmt"
+)
+
+// resultExchange exchange, в который публикуются результаты шагов саги
+const resultExchange = "saga_exchange"
+
+// Idempotent оборачивает обработчик команды operation журналом обработанных сообщений.
+// Перед вызовом handler команда захватывается в журнале; если она уже была обработана,
+// handler не вызывается, а сохраненный результат публикуется повторно.
+// Если handler завершился без результата, команда отмечается принятой: результат, опубликованный позже
+// через этот же обработчик, сохраняется в журнал. Если результат так и не опубликован (например, сервис
+// остановился раньше), повторная доставка команды после истечения срока обработки выполнит handler заново.
+// Без журнала (Ledger == nil) handler вызывается как есть.
+func (b *BaseSagaConsumer) Idempotent(operation SagaOperation, handler func([]byte) error) func([]byte) error {
+	return func(data []byte) error {
+		if b.Ledger == nil {
+			return handler(data)
+		}
+
+		message, err := ParseSagaMessage(data)
+		if err != nil || message.SagaID == "" {
+			// Сообщение без идентификатора саги нельзя сопоставить с журналом, обработчик разберет его сам
+			return handler(data)
+		}
+
+		record, acquired, err := b.Ledger.Acquire(message.SagaID, b.Step, operation)
+		if err != nil {
+			// Повторная доставка будет выполнена после задержки
+			b.Logger.Printf("[WARN] SagaID=%s: Команда %s шага %s не захвачена в журнале: %v",
+				message.SagaID, operation, b.Step, err)
+			return err
+		}
+		if !acquired {
+			return b.replayResult(record)
+		}
+
+		command := b.trackCommand(message.SagaID, operation)
+		defer b.untrackCommand(message.SagaID, operation)
+
+		if err := handler(data); err != nil {
+			if !b.resultStored(command) {
+				if releaseErr := b.Ledger.Release(message.SagaID, b.Step, operation); releaseErr != nil {
+					b.Logger.Printf("[ERROR] SagaID=%s: %v", message.SagaID, releaseErr)
+				}
+			}
+			return err
+		}
+
+		if !b.resultStored(command) {
+			// Обработчик не публиковал результат: результат будет отправлен позже асинхронно
+			if err := b.Ledger.Accept(message.SagaID, b.Step, operation); err != nil {
+				b.Logger.Printf("[ERROR] SagaID=%s: %v", message.SagaID, err)
+			}
+		}
+		return nil
+	}
+}
+
+// replayResult повторно публикует результат ранее обработанной команды
+func (b *BaseSagaConsumer) replayResult(record *ProcessedMessage) error {
+	if record.Status == LedgerStatusAccepted {
+		b.Logger.Printf("SagaID=%s: Повторная доставка команды %s шага %s, команда принята и результат еще не опубликован - пропускаем",
+			record.SagaID, record.Operation, b.Step)
+		return nil
+	}
+	if record.RoutingKey == "" || len(record.Result) == 0 {
+		b.Logger.Printf("SagaID=%s: Повторная доставка команды %s шага %s, результат не публиковался - пропускаем",
+			record.SagaID, record.Operation, b.Step)
+		return nil
+	}
+
+	result, err := record.StoredResult()
+	if err != nil {
+		return err
+	}
+
+	b.Logger.Printf("SagaID=%s: Повторная доставка команды %s шага %s, публикуем сохраненный результат (status=%s)",
+		record.SagaID, record.Operation, b.Step, result.Status)
+	if err := b.RabbitMQ.PublishMessage(resultExchange, record.RoutingKey, result); err != nil {
+		return fmt.Errorf("ошибка повторной публикации результата шага %s: %w", b.Step, err)
+	}
+	return nil
+}
+
+// PublishResult публикует результат шага, отправляемый асинхронно после завершения обработчика команды.
+// Результат сохраняется в журнал для принятой команды, чтобы при повторной доставке его можно было отправить снова.
+func (b *BaseSagaConsumer) PublishResult(message SagaMessage) error {
+	return b.publishResult(message)
+}
+
+// publishResult публикует результат шага на ключ saga.<шаг>.result.
+// Если результат отвечает на команду, обрабатываемую через Idempotent или принятую ранее,
+// он сначала сохраняется в журнал, чтобы при повторной доставке команды его можно было отправить снова.
+func (b *BaseSagaConsumer) publishResult(message SagaMessage) error {
+	routingKey := fmt.Sprintf("saga.%s.result", b.Step)
+
+	// Результат все равно публикуем при ошибке журнала: оркестратор не должен ждать из-за нее
+	if operation, command := b.commandFor(message); command != nil {
+		if err := b.Ledger.Complete(message.SagaID, b.Step, operation, routingKey, &message); err != nil {
+			b.Logger.Printf("[ERROR] SagaID=%s: %v", message.SagaID, err)
+		} else {
+			b.markResultStored(command)
+		}
+	} else if b.Ledger != nil {
+		if _, err := b.Ledger.CompleteAccepted(message.SagaID, b.Step, operation, routingKey, &message); err != nil {
+			b.Logger.Printf("[ERROR] SagaID=%s: %v", message.SagaID, err)
+		}
+	}
+
+	return b.RabbitMQ.PublishMessage(resultExchange, routingKey, message)
+}
+
+// markResultStored отмечает, что результат команды сохранен в журнал.
+// Результат может публиковаться из другой горутины, поэтому отметка выполняется под activeMu.
+func (b *BaseSagaConsumer) markResultStored(command *activeCommand) {
+	b.activeMu.Lock()
+	defer b.activeMu.Unlock()
+	command.resultStored = true
+}
+
+// resultStored сообщает, сохранен ли результат команды в журнал
+func (b *BaseSagaConsumer) resultStored(command *activeCommand) bool {
+	b.activeMu.Lock()
+	defer b.activeMu.Unlock()
+	return command.resultStored
+}
+
+// trackCommand отмечает команду саги как обрабатываемую
+func (b *BaseSagaConsumer) trackCommand(sagaID string, operation SagaOperation) *activeCommand {
+	b.activeMu.Lock()
+	defer b.activeMu.Unlock()
+
+	if b.active == nil {
+		b.active = make(map[string]map[SagaOperation]*activeCommand)
+	}
+	if b.active[sagaID] == nil {
+		b.active[sagaID] = make(map[SagaOperation]*activeCommand)
+	}
+	command := &activeCommand{}
+	b.active[sagaID][operation] = command
+	return command
+}
+
+// untrackCommand снимает отметку об обработке команды саги
+func (b *BaseSagaConsumer) untrackCommand(sagaID string, operation SagaOperation) {
+	b.activeMu.Lock()
+	defer b.activeMu.Unlock()
+
+	delete(b.active[sagaID], operation)
+	if len(b.active[sagaID]) == 0 {
+		delete(b.active, sagaID)
+	}
+}
+
+// commandFor возвращает обрабатываемую команду, на которую отвечает результат message.
+// Результаты compensated отвечают на компенсацию, остальные (completed и failed) - на выполнение шага.
+func (b *BaseSagaConsumer) commandFor(message SagaMessage) (SagaOperation, *activeCommand) {
+	if b.Ledger == nil {
+		return "", nil
+	}
+
+	b.activeMu.Lock()
+	defer b.activeMu.Unlock()
+
+	commands := b.active[message.SagaID]
+	if len(commands) == 1 {
+		for operation, command := range commands {
+			return operation, command
+		}
+	}
+
+	operation := OperationExecute
+	if message.Status == StatusCompensated {
+		operation = OperationCompensate
+	}
+	return operation, commands[operation]
+}
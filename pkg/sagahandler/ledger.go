@@ -0,0 +1,197 @@
+package sagahandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LedgerStatus статус обработки сообщения саги в журнале
+type LedgerStatus string
+
+const (
+	LedgerStatusProcessing LedgerStatus = "processing" // Сообщение обрабатывается, результат еще не опубликован
+	LedgerStatusAccepted   LedgerStatus = "accepted"   // Команда принята, результат будет опубликован асинхронно
+	LedgerStatusCompleted  LedgerStatus = "completed"  // Результат обработки сохранен и опубликован
+)
+
+// defaultProcessingTimeout время, после которого незавершенная обработка или принятая команда без результата
+// считается прерванной (например, из-за падения сервиса) и сообщение может быть обработано заново
+const defaultProcessingTimeout = 5 * time.Minute
+
+// ErrMessageInProgress сообщение с тем же ключом уже обрабатывается другим обработчиком
+var ErrMessageInProgress = errors.New("сообщение саги уже обрабатывается")
+
+// ProcessedMessage запись журнала обработанных сообщений саги.
+// Ключ (saga_id, step_name, operation) идентифицирует команду оркестратора,
+// Result хранит опубликованный в ответ результат для повторной отправки при повторной доставке.
+type ProcessedMessage struct {
+	SagaID     string         `gorm:"primaryKey;type:varchar(255)"`
+	StepName   string         `gorm:"primaryKey;type:varchar(255)"`
+	Operation  SagaOperation  `gorm:"primaryKey;type:varchar(50)"`
+	Status     LedgerStatus   `gorm:"not null;type:varchar(20)"`
+	RoutingKey string         `gorm:"type:varchar(255)"`
+	Result     datatypes.JSON `gorm:"type:jsonb"`
+	CreatedAt  time.Time      `gorm:"not null;default:now()"`
+	UpdatedAt  time.Time      `gorm:"not null;default:now()"`
+}
+
+// TableName задает имя таблицы для GORM
+func (ProcessedMessage) TableName() string {
+	return "saga_processed_messages"
+}
+
+// Ledger журнал обработанных сообщений саги (inbox), защищающий шаги от повторного выполнения
+// побочных эффектов при повторной доставке команды RabbitMQ
+type Ledger struct {
+	db                *gorm.DB
+	processingTimeout time.Duration
+}
+
+// NewLedger создает журнал обработанных сообщений в базе данных сервиса
+func NewLedger(db *gorm.DB) *Ledger {
+	return &Ledger{
+		db:                db,
+		processingTimeout: defaultProcessingTimeout,
+	}
+}
+
+// Acquire захватывает обработку команды (sagaID, stepName, operation).
+// Если команда уже обработана или принята и ожидает асинхронного результата, возвращает запись и acquired=false.
+// Если команда обрабатывается в данный момент, возвращает ErrMessageInProgress.
+// Обработка или принятая команда, запись которой не обновлялась дольше processingTimeout, захватывается заново.
+func (l *Ledger) Acquire(sagaID, stepName string, operation SagaOperation) (record *ProcessedMessage, acquired bool, err error) {
+	now := time.Now()
+	claim := ProcessedMessage{
+		SagaID:    sagaID,
+		StepName:  stepName,
+		Operation: operation,
+		Status:    LedgerStatusProcessing,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	result := l.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&claim)
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("ошибка записи в журнал обработанных сообщений: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return &claim, true, nil
+	}
+
+	var existing ProcessedMessage
+	if err := l.db.Where("saga_id = ? AND step_name = ? AND operation = ?", sagaID, stepName, operation).
+		First(&existing).Error; err != nil {
+		return nil, false, fmt.Errorf("ошибка чтения журнала обработанных сообщений: %w", err)
+	}
+
+	if existing.Status == LedgerStatusCompleted {
+		return &existing, false, nil
+	}
+
+	// Обработка была прервана или результат принятой команды так и не был опубликован:
+	// перехватываем ее, если запись не обновлялась дольше processingTimeout
+	takeover := l.db.Model(&ProcessedMessage{}).
+		Where("saga_id = ? AND step_name = ? AND operation = ? AND status IN ? AND updated_at < ?",
+			sagaID, stepName, operation, []LedgerStatus{LedgerStatusProcessing, LedgerStatusAccepted}, now.Add(-l.processingTimeout)).
+		Updates(map[string]interface{}{"status": LedgerStatusProcessing, "updated_at": now})
+	if takeover.Error != nil {
+		return nil, false, fmt.Errorf("ошибка обновления журнала обработанных сообщений: %w", takeover.Error)
+	}
+	if takeover.RowsAffected == 1 {
+		existing.Status = LedgerStatusProcessing
+		existing.UpdatedAt = now
+		return &existing, true, nil
+	}
+	if existing.Status == LedgerStatusAccepted {
+		return &existing, false, nil
+	}
+
+	return nil, false, ErrMessageInProgress
+}
+
+// Complete отмечает команду обработанной и сохраняет результат, который будет опубликован в routingKey.
+// Если обработчик не публиковал результат, result равен nil.
+func (l *Ledger) Complete(sagaID, stepName string, operation SagaOperation, routingKey string, result *SagaMessage) error {
+	record := ProcessedMessage{
+		SagaID:     sagaID,
+		StepName:   stepName,
+		Operation:  operation,
+		Status:     LedgerStatusCompleted,
+		RoutingKey: routingKey,
+		UpdatedAt:  time.Now(),
+	}
+	if result != nil {
+		payload, err := json.Marshal(result)
+		if err != nil {
+			return fmt.Errorf("ошибка сериализации результата шага: %w", err)
+		}
+		record.Result = datatypes.JSON(payload)
+	}
+	if err := l.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "saga_id"}, {Name: "step_name"}, {Name: "operation"}},
+		DoUpdates: clause.AssignmentColumns([]string{"status", "routing_key", "result", "updated_at"}),
+	}).Create(&record).Error; err != nil {
+		return fmt.Errorf("ошибка сохранения результата в журнал обработанных сообщений: %w", err)
+	}
+	return nil
+}
+
+// Accept отмечает команду принятой: обработчик завершился без результата, результат будет опубликован позже
+func (l *Ledger) Accept(sagaID, stepName string, operation SagaOperation) error {
+	if err := l.db.Model(&ProcessedMessage{}).
+		Where("saga_id = ? AND step_name = ? AND operation = ? AND status = ?",
+			sagaID, stepName, operation, LedgerStatusProcessing).
+		Updates(map[string]interface{}{"status": LedgerStatusAccepted, "updated_at": time.Now()}).Error; err != nil {
+		return fmt.Errorf("ошибка обновления журнала обработанных сообщений: %w", err)
+	}
+	return nil
+}
+
+// CompleteAccepted сохраняет асинхронный результат принятой команды.
+// Возвращает false, если команда не была принята (результат уже сохранен или команда не проходила через журнал).
+func (l *Ledger) CompleteAccepted(sagaID, stepName string, operation SagaOperation, routingKey string, result *SagaMessage) (bool, error) {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return false, fmt.Errorf("ошибка сериализации результата шага: %w", err)
+	}
+	update := l.db.Model(&ProcessedMessage{}).
+		Where("saga_id = ? AND step_name = ? AND operation = ? AND status = ?",
+			sagaID, stepName, operation, LedgerStatusAccepted).
+		Updates(map[string]interface{}{
+			"status":      LedgerStatusCompleted,
+			"routing_key": routingKey,
+			"result":      datatypes.JSON(payload),
+			"updated_at":  time.Now(),
+		})
+	if update.Error != nil {
+		return false, fmt.Errorf("ошибка сохранения результата в журнал обработанных сообщений: %w", update.Error)
+	}
+	return update.RowsAffected == 1, nil
+}
+
+// Release снимает захват обработки, если результат не был сохранен,
+// чтобы повторная доставка команды выполнила шаг заново
+func (l *Ledger) Release(sagaID, stepName string, operation SagaOperation) error {
+	if err := l.db.
+		Where("saga_id = ? AND step_name = ? AND operation = ? AND status = ?",
+			sagaID, stepName, operation, LedgerStatusProcessing).
+		Delete(&ProcessedMessage{}).Error; err != nil {
+		return fmt.Errorf("ошибка удаления записи журнала обработанных сообщений: %w", err)
+	}
+	return nil
+}
+
+// StoredResult возвращает сохраненный результат обработки
+func (m *ProcessedMessage) StoredResult() (SagaMessage, error) {
+	var result SagaMessage
+	if err := json.Unmarshal(m.Result, &result); err != nil {
+		return result, fmt.Errorf("ошибка десериализации сохраненного результата шага: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,349 @@
+package sagahandler
+
+import (
+	"errors"
+	"io"
+	"log"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newMockDB создает GORM поверх sqlmock с диалектом PostgreSQL
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("не удалось создать sqlmock: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("не удалось открыть gorm: %v", err)
+	}
+	return db, mock
+}
+
+var (
+	insertLedgerSQL   = regexp.QuoteMeta(`INSERT INTO "saga_processed_messages"`)
+	selectLedgerSQL   = regexp.QuoteMeta(`SELECT * FROM "saga_processed_messages" WHERE saga_id = $1 AND step_name = $2 AND operation = $3`)
+	takeoverLedgerSQL = regexp.QuoteMeta(`UPDATE "saga_processed_messages" SET "status"=$1,"updated_at"=$2 WHERE saga_id = $3 AND step_name = $4 AND operation = $5 AND status IN ($6,$7) AND updated_at < $8`)
+	acceptLedgerSQL   = regexp.QuoteMeta(`UPDATE "saga_processed_messages" SET "status"=$1,"updated_at"=$2 WHERE saga_id = $3 AND step_name = $4 AND operation = $5 AND status = $6`)
+	completeLedgerSQL = regexp.QuoteMeta(`UPDATE "saga_processed_messages" SET "result"=$1,"routing_key"=$2,"status"=$3,"updated_at"=$4 WHERE saga_id = $5 AND step_name = $6 AND operation = $7 AND status = $8`)
+	deleteLedgerSQL   = regexp.QuoteMeta(`DELETE FROM "saga_processed_messages" WHERE saga_id = $1 AND step_name = $2 AND operation = $3 AND status = $4`)
+)
+
+// expectClaim ожидает попытку захвата команды; claimed определяет, была ли вставлена новая запись
+func expectClaim(mock sqlmock.Sqlmock, claimed bool) {
+	rows := sqlmock.NewRows([]string{"created_at", "updated_at"})
+	if claimed {
+		rows.AddRow(time.Now(), time.Now())
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(insertLedgerSQL).
+		WithArgs("saga-1", "process_billing", OperationExecute, LedgerStatusProcessing, "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(rows)
+	mock.ExpectCommit()
+}
+
+// expectAccept ожидает отметку команды принятой
+func expectAccept(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(acceptLedgerSQL).
+		WithArgs(LedgerStatusAccepted, sqlmock.AnyArg(), "saga-1", "process_billing", OperationExecute, LedgerStatusProcessing).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// expectExisting ожидает чтение существующей записи журнала
+func expectExisting(mock sqlmock.Sqlmock, status LedgerStatus, routingKey string, result []byte) {
+	mock.ExpectQuery(selectLedgerSQL).
+		WithArgs("saga-1", "process_billing", OperationExecute, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"saga_id", "step_name", "operation", "status", "routing_key", "result"}).
+			AddRow("saga-1", "process_billing", OperationExecute, status, routingKey, result))
+}
+
+// TestLedgerAcquire_NewCommand тестирует захват новой команды
+func TestLedgerAcquire_NewCommand(t *testing.T) {
+	db, mock := newMockDB(t)
+	ledger := NewLedger(db)
+	expectClaim(mock, true)
+
+	record, acquired, err := ledger.Acquire("saga-1", "process_billing", OperationExecute)
+
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, LedgerStatusProcessing, record.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestLedgerAcquire_CompletedCommand тестирует, что обработанная команда возвращает сохраненный результат без захвата
+func TestLedgerAcquire_CompletedCommand(t *testing.T) {
+	db, mock := newMockDB(t)
+	ledger := NewLedger(db)
+	expectClaim(mock, false)
+	expectExisting(mock, LedgerStatusCompleted, "saga.process_billing.result", []byte(`{"saga_id":"saga-1","status":"completed"}`))
+
+	record, acquired, err := ledger.Acquire("saga-1", "process_billing", OperationExecute)
+
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+	assert.Equal(t, "saga.process_billing.result", record.RoutingKey)
+	result, err := record.StoredResult()
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCompleted, result.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestLedgerAcquire_InProgress тестирует отказ в захвате команды, которая обрабатывается в данный момент
+func TestLedgerAcquire_InProgress(t *testing.T) {
+	db, mock := newMockDB(t)
+	ledger := NewLedger(db)
+	expectClaim(mock, false)
+	expectExisting(mock, LedgerStatusProcessing, "", nil)
+	mock.ExpectBegin()
+	mock.ExpectExec(takeoverLedgerSQL).
+		WithArgs(LedgerStatusProcessing, sqlmock.AnyArg(), "saga-1", "process_billing", OperationExecute, LedgerStatusProcessing, LedgerStatusAccepted, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	_, acquired, err := ledger.Acquire("saga-1", "process_billing", OperationExecute)
+
+	assert.ErrorIs(t, err, ErrMessageInProgress)
+	assert.False(t, acquired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestLedgerAcquire_TakesOverStaleProcessing тестирует перехват обработки, прерванной дольше processingTimeout назад
+func TestLedgerAcquire_TakesOverStaleProcessing(t *testing.T) {
+	db, mock := newMockDB(t)
+	ledger := NewLedger(db)
+	expectClaim(mock, false)
+	expectExisting(mock, LedgerStatusProcessing, "", nil)
+	mock.ExpectBegin()
+	mock.ExpectExec(takeoverLedgerSQL).
+		WithArgs(LedgerStatusProcessing, sqlmock.AnyArg(), "saga-1", "process_billing", OperationExecute, LedgerStatusProcessing, LedgerStatusAccepted, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, acquired, err := ledger.Acquire("saga-1", "process_billing", OperationExecute)
+
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestLedgerRelease тестирует снятие захвата только для незавершенной обработки
+func TestLedgerRelease(t *testing.T) {
+	db, mock := newMockDB(t)
+	ledger := NewLedger(db)
+	mock.ExpectBegin()
+	mock.ExpectExec(deleteLedgerSQL).
+		WithArgs("saga-1", "process_billing", OperationExecute, LedgerStatusProcessing).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, ledger.Release("saga-1", "process_billing", OperationExecute))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectTakeover ожидает попытку перехвата прерванной обработки; taken определяет результат
+func expectTakeover(mock sqlmock.Sqlmock, taken bool) {
+	var affected int64
+	if taken {
+		affected = 1
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(takeoverLedgerSQL).
+		WithArgs(LedgerStatusProcessing, sqlmock.AnyArg(), "saga-1", "process_billing", OperationExecute, LedgerStatusProcessing, LedgerStatusAccepted, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, affected))
+	mock.ExpectCommit()
+}
+
+// TestLedgerAcquire_AcceptedCommand тестирует, что принятая команда без результата не захватывается повторно,
+// пока не истек срок ожидания результата
+func TestLedgerAcquire_AcceptedCommand(t *testing.T) {
+	db, mock := newMockDB(t)
+	ledger := NewLedger(db)
+	expectClaim(mock, false)
+	expectExisting(mock, LedgerStatusAccepted, "", nil)
+	expectTakeover(mock, false)
+
+	record, acquired, err := ledger.Acquire("saga-1", "process_billing", OperationExecute)
+
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+	assert.Equal(t, LedgerStatusAccepted, record.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestLedgerCompleteAccepted тестирует сохранение асинхронного результата только для принятой команды
+func TestLedgerCompleteAccepted(t *testing.T) {
+	db, mock := newMockDB(t)
+	ledger := NewLedger(db)
+	result := &SagaMessage{SagaID: "saga-1", StepName: "process_billing", Operation: OperationExecute, Status: StatusCompleted}
+	for _, affected := range []int64{1, 0} {
+		mock.ExpectBegin()
+		mock.ExpectExec(completeLedgerSQL).
+			WithArgs(sqlmock.AnyArg(), "saga.process_billing.result", LedgerStatusCompleted, sqlmock.AnyArg(), "saga-1", "process_billing", OperationExecute, LedgerStatusAccepted).
+			WillReturnResult(sqlmock.NewResult(0, affected))
+		mock.ExpectCommit()
+	}
+
+	completed, err := ledger.CompleteAccepted("saga-1", "process_billing", OperationExecute, "saga.process_billing.result", result)
+	assert.NoError(t, err)
+	assert.True(t, completed)
+
+	// Результат уже сохранен или команда не проходила через журнал
+	completed, err = ledger.CompleteAccepted("saga-1", "process_billing", OperationExecute, "saga.process_billing.result", result)
+	assert.NoError(t, err)
+	assert.False(t, completed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func newTestConsumer(ledger *Ledger) *BaseSagaConsumer {
+	return &BaseSagaConsumer{
+		Logger: log.New(io.Discard, "", 0),
+		Step:   "process_billing",
+		Ledger: ledger,
+	}
+}
+
+var executeCommand = []byte(`{"version":1,"saga_id":"saga-1","step_name":"process_billing","operation":"execute","status":"pending"}`)
+
+// TestIdempotent_FirstDeliveryAccepted тестирует, что команда, обработчик которой не опубликовал результат,
+// отмечается принятой, а не завершенной: асинхронный результат будет сохранен в журнал позже
+func TestIdempotent_FirstDeliveryAccepted(t *testing.T) {
+	db, mock := newMockDB(t)
+	consumer := newTestConsumer(NewLedger(db))
+	expectClaim(mock, true)
+	expectAccept(mock)
+
+	calls := 0
+	err := consumer.Idempotent(OperationExecute, func([]byte) error {
+		calls++
+		return nil
+	})(executeCommand)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestIdempotent_HandlerErrorReleasesClaim тестирует снятие захвата после ошибки обработчика для повторной доставки
+func TestIdempotent_HandlerErrorReleasesClaim(t *testing.T) {
+	db, mock := newMockDB(t)
+	consumer := newTestConsumer(NewLedger(db))
+	handlerErr := errors.New("ошибка обработки")
+	expectClaim(mock, true)
+	mock.ExpectBegin()
+	mock.ExpectExec(deleteLedgerSQL).
+		WithArgs("saga-1", "process_billing", OperationExecute, LedgerStatusProcessing).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := consumer.Idempotent(OperationExecute, func([]byte) error { return handlerErr })(executeCommand)
+
+	assert.ErrorIs(t, err, handlerErr)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestIdempotent_DuplicateWithoutResultSkipsHandler тестирует пропуск повторно доставленной обработанной команды
+func TestIdempotent_DuplicateWithoutResultSkipsHandler(t *testing.T) {
+	db, mock := newMockDB(t)
+	consumer := newTestConsumer(NewLedger(db))
+	expectClaim(mock, false)
+	expectExisting(mock, LedgerStatusCompleted, "", nil)
+
+	calls := 0
+	err := consumer.Idempotent(OperationExecute, func([]byte) error {
+		calls++
+		return nil
+	})(executeCommand)
+
+	assert.NoError(t, err)
+	assert.Zero(t, calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestIdempotent_AcceptedWithoutResultSkipsHandler тестирует пропуск повторной доставки принятой команды,
+// пока ее результат может быть опубликован асинхронно
+func TestIdempotent_AcceptedWithoutResultSkipsHandler(t *testing.T) {
+	db, mock := newMockDB(t)
+	consumer := newTestConsumer(NewLedger(db))
+	expectClaim(mock, false)
+	expectExisting(mock, LedgerStatusAccepted, "", nil)
+	expectTakeover(mock, false)
+
+	calls := 0
+	err := consumer.Idempotent(OperationExecute, func([]byte) error {
+		calls++
+		return nil
+	})(executeCommand)
+
+	assert.NoError(t, err)
+	assert.Zero(t, calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestIdempotent_StaleAcceptedRerunsHandler тестирует повторное выполнение принятой команды, результат которой
+// не был опубликован в срок (например, сервис остановился до асинхронной публикации)
+func TestIdempotent_StaleAcceptedRerunsHandler(t *testing.T) {
+	db, mock := newMockDB(t)
+	consumer := newTestConsumer(NewLedger(db))
+	expectClaim(mock, false)
+	expectExisting(mock, LedgerStatusAccepted, "", nil)
+	expectTakeover(mock, true)
+	expectAccept(mock)
+
+	calls := 0
+	err := consumer.Idempotent(OperationExecute, func([]byte) error {
+		calls++
+		return nil
+	})(executeCommand)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestIdempotent_InProgressReturnsError тестирует, что команда, обрабатываемая параллельно, откладывается для повторной доставки
+func TestIdempotent_InProgressReturnsError(t *testing.T) {
+	db, mock := newMockDB(t)
+	consumer := newTestConsumer(NewLedger(db))
+	expectClaim(mock, false)
+	expectExisting(mock, LedgerStatusProcessing, "", nil)
+	mock.ExpectBegin()
+	mock.ExpectExec(takeoverLedgerSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	calls := 0
+	err := consumer.Idempotent(OperationExecute, func([]byte) error {
+		calls++
+		return nil
+	})(executeCommand)
+
+	assert.ErrorIs(t, err, ErrMessageInProgress)
+	assert.Zero(t, calls)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestIdempotent_WithoutLedger тестирует вызов обработчика без журнала
+func TestIdempotent_WithoutLedger(t *testing.T) {
+	consumer := newTestConsumer(nil)
+
+	calls := 0
+	err := consumer.Idempotent(OperationExecute, func([]byte) error {
+		calls++
+		return nil
+	})(executeCommand)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+}
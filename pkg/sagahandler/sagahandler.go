@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/director74/dz8_shop/pkg/money"
 	"github.com/director74/dz8_shop/pkg/rabbitmq"
 )
 
@@ -27,8 +29,10 @@ const (
 	StatusRunning SagaStatus = "running"
 )
 
-// SagaMessage представляет сообщение для оркестрации саги
+// SagaMessage представляет сообщение для оркестрации саги.
+// Version - версия контракта сообщения (см. SchemaVersion), проверяется при разборе сообщения.
 type SagaMessage struct {
+	Version   int             `json:"version"`
 	SagaID    string          `json:"saga_id"`
 	StepName  string          `json:"step_name"`
 	Operation SagaOperation   `json:"operation"` // Используем тип SagaOperation
@@ -40,33 +44,35 @@ type SagaMessage struct {
 
 // OrderItem представляет элемент заказа в саге
 type OrderItem struct {
-	ID        uint      `json:"id,omitempty"`
-	OrderID   uint      `json:"order_id,omitempty"`
-	ProductID uint      `json:"product_id"`
-	Name      string    `json:"name,omitempty"`
-	Price     float64   `json:"price"`
-	Quantity  int       `json:"quantity"`
-	CreatedAt time.Time `json:"created_at,omitempty"`
-	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	ID        uint         `json:"id,omitempty"`
+	OrderID   uint         `json:"order_id,omitempty"`
+	ProductID uint         `json:"product_id"`
+	Name      string       `json:"name,omitempty"`
+	SKU       string       `json:"sku,omitempty"`
+	Price     money.Amount `json:"price"`
+	Quantity  int          `json:"quantity"`
+	CreatedAt time.Time    `json:"created_at,omitempty"`
+	UpdatedAt time.Time    `json:"updated_at,omitempty"`
 }
 
 // PaymentInfo информация о платеже
 type PaymentInfo struct {
-	PaymentID     string  `json:"payment_id,omitempty"`
-	Amount        float64 `json:"amount"`
-	Status        string  `json:"status"`
-	TransactionID string  `json:"transaction_id,omitempty"`
+	PaymentID     string         `json:"payment_id,omitempty"`
+	Amount        money.Amount   `json:"amount"`
+	Currency      money.Currency `json:"currency,omitempty"`
+	Status        string         `json:"status"`
+	TransactionID string         `json:"transaction_id,omitempty"`
 }
 
 // DeliveryInfo информация о доставке
 type DeliveryInfo struct {
-	DeliveryID   string  `json:"delivery_id,omitempty"`
-	Address      string  `json:"address"`
-	DeliveryDate string  `json:"delivery_date"`
-	Cost         float64 `json:"cost"`
-	Status       string  `json:"status"`
-	TimeSlotID   uint    `json:"time_slot_id,omitempty"`
-	ZoneID       uint    `json:"zone_id,omitempty"`
+	DeliveryID   string       `json:"delivery_id,omitempty"`
+	Address      string       `json:"address"`
+	DeliveryDate string       `json:"delivery_date"`
+	Cost         money.Amount `json:"cost"`
+	Status       string       `json:"status"`
+	TimeSlotID   uint         `json:"time_slot_id,omitempty"`
+	ZoneID       uint         `json:"zone_id,omitempty"`
 }
 
 // WarehouseInfo информация о резервации товаров на складе
@@ -77,9 +83,11 @@ type WarehouseInfo struct {
 
 // BillingInfo информация о биллинге
 type BillingInfo struct {
-	TransactionID string  `json:"transaction_id,omitempty"`
-	Amount        float64 `json:"amount"`
-	Status        string  `json:"status"`
+	TransactionID string         `json:"transaction_id,omitempty"`
+	Amount        money.Amount   `json:"amount"`                  // Списанная сумма в валюте счета
+	Currency      money.Currency `json:"currency,omitempty"`      // Валюта счета
+	ExchangeRate  string         `json:"exchange_rate,omitempty"` // Курс валюты заказа к валюте счета, если они различаются
+	Status        string         `json:"status"`
 }
 
 // SagaData представляет данные для передачи между шагами саги
@@ -87,8 +95,9 @@ type SagaData struct {
 	OrderID          uint            `json:"order_id"`
 	UserID           uint            `json:"user_id"`
 	Items            []OrderItem     `json:"items"`
-	Amount           float64         `json:"amount"`
-	Status           string          `json:"status"` // Оставляем string для совместимости с entity.OrderStatus? Или нужно привести к SagaStatus? Пока оставим string.
+	Amount           money.Amount    `json:"amount"`
+	Currency         money.Currency  `json:"currency,omitempty"` // Валюта заказа, пустая - валюта по умолчанию
+	Status           string          `json:"status"`             // Оставляем string для совместимости с entity.OrderStatus? Или нужно привести к SagaStatus? Пока оставим string.
 	PaymentInfo      *PaymentInfo    `json:"payment_info,omitempty"`
 	DeliveryInfo     *DeliveryInfo   `json:"delivery_info,omitempty"`
 	WarehouseInfo    *WarehouseInfo  `json:"warehouse_info,omitempty"`
@@ -103,6 +112,17 @@ type BaseSagaConsumer struct {
 	RabbitMQ *rabbitmq.RabbitMQ
 	Logger   *log.Logger
 	Step     string // шаг, за который отвечает этот обработчик
+	// Ledger журнал обработанных сообщений. Если задан, повторно доставленная команда не выполняется,
+	// а в ответ публикуется сохраненный результат первой обработки.
+	Ledger *Ledger
+
+	activeMu sync.Mutex
+	active   map[string]map[SagaOperation]*activeCommand // Обрабатываемые команды по SagaID
+}
+
+// activeCommand команда саги, обрабатываемая в данный момент
+type activeCommand struct {
+	resultStored bool // Результат сохранен в журнал
 }
 
 // SetupQueues настраивает очереди и обмены для обработки саги
@@ -146,14 +166,14 @@ func (b *BaseSagaConsumer) SetupQueues(
 
 	// Настраиваем обработчик сообщений для выполнения шага
 	consumerExecuteName := fmt.Sprintf("%s-execute-%d", b.Step, time.Now().UnixNano())
-	err = b.RabbitMQ.ConsumeMessages(reserveQueueName, consumerExecuteName, handleExecute)
+	err = b.RabbitMQ.ConsumeMessages(reserveQueueName, consumerExecuteName, b.Idempotent(OperationExecute, handleExecute))
 	if err != nil {
 		return fmt.Errorf("ошибка при настройке обработчика сообщений для выполнения: %w", err)
 	}
 
 	// Настраиваем обработчик сообщений для компенсации
 	consumerCompensateName := fmt.Sprintf("%s-compensate-%d", b.Step, time.Now().UnixNano())
-	err = b.RabbitMQ.ConsumeMessages(compensateQueueName, consumerCompensateName, handleCompensate)
+	err = b.RabbitMQ.ConsumeMessages(compensateQueueName, consumerCompensateName, b.Idempotent(OperationCompensate, handleCompensate))
 	if err != nil {
 		return fmt.Errorf("ошибка при настройке обработчика сообщений для компенсации: %w", err)
 	}
@@ -189,10 +209,10 @@ func (b *BaseSagaConsumer) PublishSuccessResult(sagaID string, data []byte) erro
 		Status:    StatusCompleted,  // Используем константу
 		Data:      data,
 		Timestamp: GetTimestamp(),
+		Version:   SchemaVersion,
 	}
 
-	resultRoutingKey := fmt.Sprintf("saga.%s.result", b.Step)
-	if err := b.RabbitMQ.PublishMessage("saga_exchange", resultRoutingKey, resultMessage); err != nil {
+	if err := b.publishResult(resultMessage); err != nil {
 		b.Logger.Printf("Ошибка при публикации результата выполнения шага %s: %v", b.Step, err)
 		return err
 	}
@@ -212,11 +232,11 @@ func (b *BaseSagaConsumer) PublishFailureResult(sagaID string, errorMsg string)
 		Status:    StatusFailed,        // Используем константу
 		Error:     errorMsg,
 		Timestamp: GetTimestamp(),
+		Version:   SchemaVersion,
 	}
 
 	// Отправляем результат на общий ключ *.result, чтобы оркестратор получил уведомление
-	resultRoutingKey := fmt.Sprintf("saga.%s.result", b.Step)
-	if err := b.RabbitMQ.PublishMessage("saga_exchange", resultRoutingKey, failureMessage); err != nil {
+	if err := b.publishResult(failureMessage); err != nil {
 		b.Logger.Printf("Ошибка при публикации сообщения о неудаче шага %s: %v", b.Step, err)
 		return err
 	}
@@ -254,11 +274,11 @@ func (b *BaseSagaConsumer) PublishFailureResultWithData(sagaID string, errorMsg
 		Error:     errorMsg,
 		Data:      data, // Сохраняем данные для шага компенсации
 		Timestamp: GetTimestamp(),
+		Version:   SchemaVersion,
 	}
 
 	// Отправляем результат на общий ключ *.result, чтобы оркестратор получил уведомление
-	resultRoutingKey := fmt.Sprintf("saga.%s.result", b.Step)
-	if err := b.RabbitMQ.PublishMessage("saga_exchange", resultRoutingKey, failureMessage); err != nil {
+	if err := b.publishResult(failureMessage); err != nil {
 		b.Logger.Printf("Ошибка при публикации сообщения о неудаче с данными для шага %s: %v", b.Step, err)
 		return err
 	}
@@ -276,11 +296,11 @@ func (b *BaseSagaConsumer) PublishCompensationResult(sagaID string, data []byte)
 		Status:    StatusCompensated,   // Используем константу
 		Data:      data,
 		Timestamp: GetTimestamp(),
+		Version:   SchemaVersion,
 	}
 
 	// Отправляем результат на общий ключ *.result, чтобы оркестратор получил уведомление
-	resultRoutingKey := fmt.Sprintf("saga.%s.result", b.Step)
-	if err := b.RabbitMQ.PublishMessage("saga_exchange", resultRoutingKey, compensationMessage); err != nil {
+	if err := b.publishResult(compensationMessage); err != nil {
 		b.Logger.Printf("Ошибка при публикации результата компенсации шага %s: %v", b.Step, err)
 		return err
 	}
@@ -289,12 +309,15 @@ func (b *BaseSagaConsumer) PublishCompensationResult(sagaID string, data []byte)
 	return nil
 }
 
-// ParseSagaMessage десериализует SagaMessage из байтов
+// ParseSagaMessage десериализует SagaMessage из байтов и проверяет совместимость версии контракта
 func ParseSagaMessage(data []byte) (*SagaMessage, error) {
 	var message SagaMessage
 	if err := json.Unmarshal(data, &message); err != nil {
 		return nil, fmt.Errorf("ошибка десериализации сообщения саги: %w", err)
 	}
+	if err := message.CheckCompatibility(); err != nil {
+		return nil, err
+	}
 	return &message, nil
 }
 
@@ -334,6 +357,7 @@ func NewSagaMessage(sagaID, stepName string, operation SagaOperation, status Sag
 		Status:    status,
 		Data:      jsonData,
 		Timestamp: GetTimestamp(),
+		Version:   SchemaVersion,
 	}, nil
 }
 
@@ -345,6 +369,7 @@ func NewSagaErrorMessage(sagaID, stepName string, operation SagaOperation, statu
 		Operation: operation,
 		Status:    status,
 		Timestamp: GetTimestamp(),
+		Version:   SchemaVersion,
 	}
 	if err != nil {
 		msg.Error = err.Error()
@@ -355,6 +380,9 @@ func NewSagaErrorMessage(sagaID, stepName string, operation SagaOperation, statu
 // ParseSagaData извлекает данные из сообщения саги
 func ParseSagaData(message SagaMessage) (SagaData, error) {
 	var sagaData SagaData
+	if err := message.CheckCompatibility(); err != nil {
+		return sagaData, err
+	}
 	if err := json.Unmarshal(message.Data, &sagaData); err != nil {
 		return sagaData, fmt.Errorf("ошибка при десериализации данных саги: %w", err)
 	}
@@ -375,18 +403,18 @@ func UpdateSagaData(message SagaMessage, sagaData SagaData) (SagaMessage, error)
 // DebugSagaData печатает содержимое sagaData для отладки
 func DebugSagaData(sagaData SagaData) string {
 	// Формируем отладочную информацию
-	debug := fmt.Sprintf("SagaData: OrderID=%d, UserID=%d, Amount=%.2f, Status=%s\n",
+	debug := fmt.Sprintf("SagaData: OrderID=%d, UserID=%d, Amount=%s, Status=%s\n",
 		sagaData.OrderID, sagaData.UserID, sagaData.Amount, sagaData.Status)
 
 	debug += fmt.Sprintf("Items count: %d\n", len(sagaData.Items))
 	for i, item := range sagaData.Items {
-		debug += fmt.Sprintf("Item %d: ProductID=%d, Quantity=%d, Price=%.2f\n",
+		debug += fmt.Sprintf("Item %d: ProductID=%d, Quantity=%d, Price=%s\n",
 			i+1, item.ProductID, item.Quantity, item.Price)
 	}
 
 	// Добавляем информацию о доставке, если она есть
 	if sagaData.DeliveryInfo != nil {
-		debug += fmt.Sprintf("DeliveryInfo: Address=%s, Date=%s, Cost=%.2f, TimeSlotID=%d, ZoneID=%d\n",
+		debug += fmt.Sprintf("DeliveryInfo: Address=%s, Date=%s, Cost=%s, TimeSlotID=%d, ZoneID=%d\n",
 			sagaData.DeliveryInfo.Address, sagaData.DeliveryInfo.DeliveryDate,
 			sagaData.DeliveryInfo.Cost, sagaData.DeliveryInfo.TimeSlotID, sagaData.DeliveryInfo.ZoneID)
 	} else {
@@ -11,6 +11,7 @@ type Config struct {
 	HTTP      config.HTTPConfig
 	Postgres  config.PostgresConfig
 	RabbitMQ  config.RabbitMQConfig
+	Outbox    config.OutboxConfig
 	JWT       config.JWTConfig
 	Warehouse WarehouseConfig
 	Internal  InternalAPIConfig
@@ -18,7 +19,10 @@ type Config struct {
 
 // WarehouseConfig содержит специфичные настройки для сервиса склада
 type WarehouseConfig struct {
-	ReservationTTL time.Duration
+	ReservationTTL            time.Duration // Срок резервирования товаров для саги заказа
+	ReservationExpiryInterval time.Duration // Интервал проверки просроченных резервирований
+	LowStockCheckInterval     time.Duration // Интервал проверки остатков товаров относительно точки дозаказа
+	BackorderInterval         time.Duration // Интервал распределения свободного остатка по заказам под поступление
 }
 
 // InternalAPIConfig конфигурация для внутреннего API
@@ -47,6 +51,7 @@ func NewConfig() (*Config, error) {
 		HTTP:      commonConfig.HTTP,
 		Postgres:  commonConfig.Postgres,
 		RabbitMQ:  commonConfig.RabbitMQ,
+		Outbox:    *config.LoadOutboxConfig(),
 		JWT:       *jwtConfig,
 		Warehouse: warehouseConfig,
 		Internal:  internalConfig,
@@ -56,7 +61,10 @@ func NewConfig() (*Config, error) {
 // loadWarehouseConfig загружает специфичные настройки склада
 func loadWarehouseConfig() WarehouseConfig {
 	return WarehouseConfig{
-		ReservationTTL: config.GetEnvAsDuration("WAREHOUSE_RESERVATION_TTL", 30*time.Minute),
+		ReservationTTL:            config.GetEnvAsDuration("WAREHOUSE_RESERVATION_TTL", 30*time.Minute),
+		ReservationExpiryInterval: config.GetEnvAsDuration("WAREHOUSE_RESERVATION_EXPIRY_INTERVAL", time.Minute),
+		LowStockCheckInterval:     config.GetEnvAsDuration("WAREHOUSE_LOW_STOCK_CHECK_INTERVAL", 5*time.Minute),
+		BackorderInterval:         config.GetEnvAsDuration("WAREHOUSE_BACKORDER_ALLOCATION_INTERVAL", time.Minute),
 	}
 }
 
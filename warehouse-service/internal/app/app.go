@@ -14,7 +14,9 @@ import (
 	"github.com/director74/dz8_shop/pkg/database"
 	"github.com/director74/dz8_shop/pkg/errors"
 	"github.com/director74/dz8_shop/pkg/messaging"
+	"github.com/director74/dz8_shop/pkg/outbox"
 	"github.com/director74/dz8_shop/pkg/rabbitmq"
+	"github.com/director74/dz8_shop/pkg/sagahandler"
 	"github.com/director74/dz8_shop/warehouse-service/config"
 	httpController "github.com/director74/dz8_shop/warehouse-service/internal/controller/http"
 	rmqController "github.com/director74/dz8_shop/warehouse-service/internal/controller/rabbitmq"
@@ -33,6 +35,11 @@ type App struct {
 	rabbitMQ messaging.MessageBroker
 	router   *gin.Engine
 	server   *http.Server
+
+	stopRelay      context.CancelFunc
+	stopExpiry     context.CancelFunc
+	stopLowStock   context.CancelFunc
+	stopBackorders context.CancelFunc
 }
 
 // NewApp создает новое приложение с указанной конфигурацией
@@ -48,7 +55,7 @@ func NewApp(cfg *config.Config) (*App, error) {
 	}
 
 	// Автомиграция моделей
-	if err := database.AutoMigrateWithCleanup(db, &entity.WarehouseItem{}, &entity.WarehouseReservation{}); err != nil {
+	if err := database.AutoMigrateWithCleanup(db, &entity.WarehouseItem{}, &entity.WarehouseReservation{}, &entity.Category{}, &entity.ProductImage{}, &entity.StockMovement{}, &entity.Warehouse{}, &entity.WarehouseZone{}, &entity.LocationStock{}, &entity.Backorder{}, &entity.BackorderItem{}, &outbox.Message{}, &sagahandler.ProcessedMessage{}); err != nil {
 		return nil, errors.AppendPrefix(err, "не удалось выполнить миграцию")
 	}
 
@@ -91,14 +98,27 @@ func NewApp(cfg *config.Config) (*App, error) {
 	// Создание роутера
 	router := gin.Default()
 
-	// Создание репозитория склада
+	// Создание репозиториев склада и каталога
 	warehouseRepo := repo.NewWarehouseRepo(db)
+	catalogRepo := repo.NewCatalogRepo(db)
+
+	// Создание use case склада, каталога, учета остатков и управления складами: события склада сохраняются в outbox и отправляются relay
+	eventPublisher := outbox.NewPublisher(db)
+	warehouseUseCase := usecase.NewWarehouseUseCase(warehouseRepo, eventPublisher, "warehouse_events")
+	warehouseUseCase.UseReservationTTL(cfg.Warehouse.ReservationTTL)
+	catalogUseCase := usecase.NewCatalogUseCase(catalogRepo, warehouseRepo)
+	stockUseCase := usecase.NewStockUseCase(warehouseRepo, eventPublisher, "warehouse_events")
+	locationUseCase := usecase.NewLocationUseCase(warehouseRepo)
 
-	// Создание use case склада
-	warehouseUseCase := usecase.NewWarehouseUseCase(warehouseRepo)
+	// Поступивший товар в первую очередь резервируется под заказы из очереди под поступление
+	stockUseCase.UseBackorderAllocator(warehouseUseCase)
 
-	// Создание обработчика HTTP запросов
+	// Создание обработчиков HTTP запросов
 	warehouseHandler := httpController.NewWarehouseHandler(warehouseUseCase, cfg)
+	catalogHandler := httpController.NewCatalogHandler(catalogUseCase, cfg)
+	stockHandler := httpController.NewStockHandler(stockUseCase, cfg)
+	locationHandler := httpController.NewLocationHandler(locationUseCase, cfg)
+	backorderHandler := httpController.NewBackorderHandler(warehouseUseCase, cfg)
 
 	// Проверяем, что RabbitMQ имеет правильный тип
 	rawRMQ, ok := rmq.(*rabbitmq.RabbitMQ)
@@ -109,10 +129,15 @@ func NewApp(cfg *config.Config) (*App, error) {
 	}
 
 	// Создание обработчика сообщений RabbitMQ
-	sagaConsumer := rmqController.NewSagaConsumer(warehouseUseCase, rawRMQ)
+	sagaConsumer := rmqController.NewSagaConsumer(warehouseUseCase, rawRMQ, sagahandler.NewLedger(db))
 
 	// Регистрация маршрутов
 	warehouseHandler.RegisterRoutes(router, authMiddleware.AuthRequired())
+	catalogHandler.RegisterRoutes(router)
+	stockHandler.RegisterRoutes(router)
+	locationHandler.RegisterRoutes(router)
+	backorderHandler.RegisterRoutes(router)
+	messaging.RegisterDeadLetterAdmin(router, rawRMQ)
 
 	// Настройка обработки сообщений RabbitMQ
 	if err := sagaConsumer.Setup(); err != nil {
@@ -121,6 +146,28 @@ func NewApp(cfg *config.Config) (*App, error) {
 		return nil, errors.AppendPrefix(err, "ошибка настройки обработчика сообщений")
 	}
 
+	// Подтверждение резервирования товаров при подтверждении заказа
+	if err := sagaConsumer.SetupConfirmConsumer(); err != nil {
+		database.CloseDB(db)
+		rmq.Close()
+		return nil, errors.AppendPrefix(err, "ошибка настройки обработчика подтверждения резервирования")
+	}
+
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	go outbox.NewRelay(db, rawRMQ, cfg.Outbox, nil).Run(relayCtx)
+
+	// Возврат в остаток товаров, резервирование которых не подтверждено и не отменено в срок
+	expiryCtx, stopExpiry := context.WithCancel(context.Background())
+	go warehouseUseCase.RunReservationExpiry(expiryCtx, cfg.Warehouse.ReservationExpiryInterval)
+
+	// Контроль остатков товаров относительно точки дозаказа: события warehouse.stock.low для отдела закупок
+	lowStockCtx, stopLowStock := context.WithCancel(context.Background())
+	go stockUseCase.RunLowStockEvaluation(lowStockCtx, cfg.Warehouse.LowStockCheckInterval)
+
+	// Резервирование свободного остатка под заказы из очереди под поступление (например, после возврата резерва)
+	backordersCtx, stopBackorders := context.WithCancel(context.Background())
+	go warehouseUseCase.RunBackorderAllocation(backordersCtx, cfg.Warehouse.BackorderInterval)
+
 	// Настройка HTTP сервера
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%s", cfg.HTTP.Port),
@@ -135,6 +182,11 @@ func NewApp(cfg *config.Config) (*App, error) {
 		rabbitMQ: rmq,
 		router:   router,
 		server:   server,
+
+		stopRelay:      stopRelay,
+		stopExpiry:     stopExpiry,
+		stopLowStock:   stopLowStock,
+		stopBackorders: stopBackorders,
 	}, nil
 }
 
@@ -164,6 +216,26 @@ func (a *App) Run() error {
 		log.Printf("Ошибка остановки HTTP сервера: %v", err)
 	}
 
+	// Остановка проверки просроченных резервирований
+	if a.stopExpiry != nil {
+		a.stopExpiry()
+	}
+
+	// Остановка проверки низкого остатка товаров
+	if a.stopLowStock != nil {
+		a.stopLowStock()
+	}
+
+	// Остановка распределения товара по заказам под поступление
+	if a.stopBackorders != nil {
+		a.stopBackorders()
+	}
+
+	// Остановка relay outbox до закрытия соединения с RabbitMQ
+	if a.stopRelay != nil {
+		a.stopRelay()
+	}
+
 	// Закрытие соединения с RabbitMQ
 	if err := a.rabbitMQ.Close(); err != nil {
 		log.Printf("Ошибка закрытия соединения с RabbitMQ: %v", err)
@@ -0,0 +1,106 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/director74/dz8_shop/warehouse-service/config"
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"github.com/director74/dz8_shop/warehouse-service/internal/usecase"
+	"github.com/gin-gonic/gin"
+)
+
+// BackorderHandler обработчик HTTP запросов управления заказами под поступление товара
+type BackorderHandler struct {
+	warehouseUseCase *usecase.WarehouseUseCase
+	config           *config.Config
+}
+
+// NewBackorderHandler создает новый обработчик управления заказами под поступление
+func NewBackorderHandler(warehouseUseCase *usecase.WarehouseUseCase, cfg *config.Config) *BackorderHandler {
+	return &BackorderHandler{
+		warehouseUseCase: warehouseUseCase,
+		config:           cfg,
+	}
+}
+
+// ListBackorders возвращает очередь заказов под поступление
+func (h *BackorderHandler) ListBackorders(c *gin.Context) {
+	var query entity.BackorderQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.warehouseUseCase.ListBackorders(c.Request.Context(), &query)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// AllocateBackorders резервирует свободный остаток под заказы из очереди, не дожидаясь периодической проверки
+func (h *BackorderHandler) AllocateBackorders(c *gin.Context) {
+	allocated, pending, err := h.warehouseUseCase.AllocateBackorders(c.Request.Context())
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, entity.AllocateBackordersResponse{
+		Allocated: allocated,
+		Pending:   pending,
+	})
+}
+
+// SetBackorderPolicy разрешает или запрещает заказ товара под поступление
+func (h *BackorderHandler) SetBackorderPolicy(c *gin.Context) {
+	productID, err := strconv.ParseUint(c.Param("product_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный ID продукта"})
+		return
+	}
+
+	var req entity.SetBackorderPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.warehouseUseCase.SetBackorderPolicy(c.Request.Context(), uint(productID), &req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// handleError преобразует ошибку use case заказов под поступление в HTTP ответ
+func (h *BackorderHandler) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrInvalidStockOperation):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrProductNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// RegisterRoutes регистрирует маршруты управления заказами под поступление
+func (h *BackorderHandler) RegisterRoutes(router *gin.Engine) {
+	internalBackorders := router.Group("/internal/backorders", newInternalAuthMiddleware(h.config).Required())
+	{
+		internalBackorders.GET("", h.ListBackorders)
+		internalBackorders.POST("/allocate", h.AllocateBackorders)
+	}
+
+	internalStock := router.Group("/internal/stock", newInternalAuthMiddleware(h.config).Required())
+	{
+		internalStock.PUT("/products/:product_id/backorder-policy", h.SetBackorderPolicy)
+	}
+}
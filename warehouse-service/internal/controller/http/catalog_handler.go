@@ -0,0 +1,148 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/director74/dz8_shop/warehouse-service/config"
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"github.com/director74/dz8_shop/warehouse-service/internal/usecase"
+	"github.com/gin-gonic/gin"
+)
+
+// CatalogHandler обработчик HTTP запросов публичного каталога товаров
+type CatalogHandler struct {
+	catalogUseCase *usecase.CatalogUseCase
+	config         *config.Config
+}
+
+// NewCatalogHandler создает новый обработчик каталога
+func NewCatalogHandler(catalogUseCase *usecase.CatalogUseCase, cfg *config.Config) *CatalogHandler {
+	return &CatalogHandler{
+		catalogUseCase: catalogUseCase,
+		config:         cfg,
+	}
+}
+
+// ListProducts возвращает страницу каталога с поиском и фильтрами
+func (h *CatalogHandler) ListProducts(c *gin.Context) {
+	var query entity.CatalogQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.catalogUseCase.ListProducts(c.Request.Context(), &query)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// GetProduct возвращает карточку товара по ID продукта
+func (h *CatalogHandler) GetProduct(c *gin.Context) {
+	productID, err := strconv.ParseUint(c.Param("product_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный ID продукта"})
+		return
+	}
+
+	product, err := h.catalogUseCase.GetProduct(c.Request.Context(), uint(productID))
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	if product == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "товар не найден"})
+		return
+	}
+
+	c.JSON(http.StatusOK, product)
+}
+
+// ListCategories возвращает все категории каталога
+func (h *CatalogHandler) ListCategories(c *gin.Context) {
+	categories, err := h.catalogUseCase.ListCategories(c.Request.Context())
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"categories": categories})
+}
+
+// CreateCategory создает категорию каталога
+func (h *CatalogHandler) CreateCategory(c *gin.Context) {
+	var req entity.CreateCategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	category, err := h.catalogUseCase.CreateCategory(c.Request.Context(), &req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, category)
+}
+
+// UpdateProduct изменяет карточку товара в каталоге
+func (h *CatalogHandler) UpdateProduct(c *gin.Context) {
+	productID, err := strconv.ParseUint(c.Param("product_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный ID продукта"})
+		return
+	}
+
+	var req entity.UpdateCatalogProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	product, err := h.catalogUseCase.UpdateProduct(c.Request.Context(), uint(productID), &req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, product)
+}
+
+// handleError преобразует ошибку use case каталога в HTTP ответ
+func (h *CatalogHandler) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrInvalidCatalogQuery):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrCategoryNotFound), errors.Is(err, usecase.ErrProductNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrCategoryExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// RegisterRoutes регистрирует маршруты каталога
+func (h *CatalogHandler) RegisterRoutes(router *gin.Engine) {
+	// Публичные API маршруты (без авторизации)
+	catalog := router.Group("/api/v1/catalog")
+	{
+		catalog.GET("/products", h.ListProducts)
+		catalog.GET("/products/:product_id", h.GetProduct)
+		catalog.GET("/categories", h.ListCategories)
+	}
+
+	// Внутренние API маршруты для управления каталогом
+	internalCatalog := router.Group("/internal/catalog", newInternalAuthMiddleware(h.config).Required())
+	{
+		internalCatalog.POST("/categories", h.CreateCategory)
+		internalCatalog.PUT("/products/:product_id", h.UpdateProduct)
+	}
+}
@@ -0,0 +1,148 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/director74/dz8_shop/warehouse-service/config"
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"github.com/director74/dz8_shop/warehouse-service/internal/usecase"
+	"github.com/gin-gonic/gin"
+)
+
+// LocationHandler обработчик HTTP запросов управления складами и остатками по складам
+type LocationHandler struct {
+	locationUseCase *usecase.LocationUseCase
+	config          *config.Config
+}
+
+// NewLocationHandler создает новый обработчик управления складами
+func NewLocationHandler(locationUseCase *usecase.LocationUseCase, cfg *config.Config) *LocationHandler {
+	return &LocationHandler{
+		locationUseCase: locationUseCase,
+		config:          cfg,
+	}
+}
+
+// ListWarehouses возвращает все склады
+func (h *LocationHandler) ListWarehouses(c *gin.Context) {
+	response, err := h.locationUseCase.ListWarehouses(c.Request.Context())
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// CreateWarehouse создает склад
+func (h *LocationHandler) CreateWarehouse(c *gin.Context) {
+	var req entity.CreateWarehouseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	warehouse, err := h.locationUseCase.CreateWarehouse(c.Request.Context(), &req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, warehouse)
+}
+
+// UpdateWarehouse изменяет склад
+func (h *LocationHandler) UpdateWarehouse(c *gin.Context) {
+	warehouseID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный ID склада"})
+		return
+	}
+
+	var req entity.UpdateWarehouseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	warehouse, err := h.locationUseCase.UpdateWarehouse(c.Request.Context(), uint(warehouseID), &req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, warehouse)
+}
+
+// SetWarehouseZones заменяет список зон доставки, которые обслуживает склад
+func (h *LocationHandler) SetWarehouseZones(c *gin.Context) {
+	warehouseID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный ID склада"})
+		return
+	}
+
+	var req entity.SetWarehouseZonesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	warehouse, err := h.locationUseCase.SetWarehouseZones(c.Request.Context(), uint(warehouseID), &req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, warehouse)
+}
+
+// GetProductStock возвращает остатки товара по складам
+func (h *LocationHandler) GetProductStock(c *gin.Context) {
+	productID, err := strconv.ParseUint(c.Param("product_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный ID продукта"})
+		return
+	}
+
+	response, err := h.locationUseCase.GetProductStock(c.Request.Context(), uint(productID))
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// handleError преобразует ошибку use case управления складами в HTTP ответ
+func (h *LocationHandler) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrInvalidWarehouse):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrWarehouseNotFound), errors.Is(err, usecase.ErrProductNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrWarehouseExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// RegisterRoutes регистрирует маршруты управления складами
+func (h *LocationHandler) RegisterRoutes(router *gin.Engine) {
+	// Внутренние API маршруты для управления складами (фулфилмент-центрами)
+	internalWarehouses := router.Group("/internal/warehouses", newInternalAuthMiddleware(h.config).Required())
+	{
+		internalWarehouses.GET("", h.ListWarehouses)
+		internalWarehouses.POST("", h.CreateWarehouse)
+		internalWarehouses.PUT("/:id", h.UpdateWarehouse)
+		internalWarehouses.PUT("/:id/zones", h.SetWarehouseZones)
+	}
+
+	internalStock := router.Group("/internal/stock", newInternalAuthMiddleware(h.config).Required())
+	{
+		internalStock.GET("/products/:product_id", h.GetProductStock)
+	}
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	pkgMiddleware "github.com/director74/dz8_shop/pkg/middleware"
+	"github.com/director74/dz8_shop/warehouse-service/config"
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"github.com/director74/dz8_shop/warehouse-service/internal/usecase"
+	"github.com/gin-gonic/gin"
+)
+
+// StockHandler обработчик HTTP запросов учета остатков склада
+type StockHandler struct {
+	stockUseCase *usecase.StockUseCase
+	config       *config.Config
+}
+
+// NewStockHandler создает новый обработчик учета остатков
+func NewStockHandler(stockUseCase *usecase.StockUseCase, cfg *config.Config) *StockHandler {
+	return &StockHandler{
+		stockUseCase: stockUseCase,
+		config:       cfg,
+	}
+}
+
+// ReceiveStock оприходует поступивший товар
+func (h *StockHandler) ReceiveStock(c *gin.Context) {
+	var req entity.ReceiveStockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Principal = pkgMiddleware.InternalPrincipal(c)
+
+	response, err := h.stockUseCase.ReceiveStock(c.Request.Context(), &req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// WriteOffStock списывает товар
+func (h *StockHandler) WriteOffStock(c *gin.Context) {
+	var req entity.WriteOffStockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Principal = pkgMiddleware.InternalPrincipal(c)
+
+	response, err := h.stockUseCase.WriteOffStock(c.Request.Context(), &req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// CountStock фиксирует результат инвентаризации товара
+func (h *StockHandler) CountStock(c *gin.Context) {
+	var req entity.InventoryCountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Principal = pkgMiddleware.InternalPrincipal(c)
+
+	response, err := h.stockUseCase.CountStock(c.Request.Context(), &req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// AdjustStock корректирует остаток товара вручную
+func (h *StockHandler) AdjustStock(c *gin.Context) {
+	var req entity.AdjustStockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Principal = pkgMiddleware.InternalPrincipal(c)
+
+	response, err := h.stockUseCase.AdjustStock(c.Request.Context(), &req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// ListMovements возвращает журнал движения товаров
+func (h *StockHandler) ListMovements(c *gin.Context) {
+	var query entity.StockMovementQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.stockUseCase.ListMovements(c.Request.Context(), &query)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SetReorderPolicy устанавливает точку дозаказа и страховой запас товара
+func (h *StockHandler) SetReorderPolicy(c *gin.Context) {
+	productID, err := strconv.ParseUint(c.Param("product_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "неверный ID продукта"})
+		return
+	}
+
+	var req entity.SetReorderPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.stockUseCase.SetReorderPolicy(c.Request.Context(), uint(productID), &req)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// LowStockReport возвращает товары, доступный остаток которых достиг точки дозаказа
+func (h *StockHandler) LowStockReport(c *gin.Context) {
+	var query entity.LowStockReportQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.stockUseCase.LowStockReport(c.Request.Context(), &query)
+	if err != nil {
+		h.handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// handleError преобразует ошибку use case учета остатков в HTTP ответ
+func (h *StockHandler) handleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecase.ErrInvalidStockOperation):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrProductNotFound), errors.Is(err, usecase.ErrWarehouseNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, usecase.ErrInsufficientStock):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// RegisterRoutes регистрирует маршруты учета остатков
+func (h *StockHandler) RegisterRoutes(router *gin.Engine) {
+	// Внутренние API маршруты для операций склада: исполнитель операции передается в поле actor,
+	// а вызывающий, прошедший проверку доступа, записывается в журнал рядом с ним
+	internalStock := router.Group("/internal/stock", newInternalAuthMiddleware(h.config).Required())
+	{
+		internalStock.POST("/receipts", h.ReceiveStock)
+		internalStock.POST("/write-offs", h.WriteOffStock)
+		internalStock.POST("/counts", h.CountStock)
+		internalStock.POST("/adjustments", h.AdjustStock)
+		internalStock.GET("/movements", h.ListMovements)
+		internalStock.PUT("/products/:product_id/reorder-policy", h.SetReorderPolicy)
+		internalStock.GET("/low-stock", h.LowStockReport)
+	}
+}
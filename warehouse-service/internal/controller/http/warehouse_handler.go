@@ -299,15 +299,7 @@ func (h *WarehouseHandler) RegisterRoutes(router *gin.Engine, authMiddleware gin
 	}
 
 	// Внутренние API маршруты (с проверкой доступа для внутренних сервисов)
-	internalAPIConfig := &pkgMiddleware.InternalAPIConfig{
-		TrustedNetworks: h.config.Internal.TrustedNetworks,
-		APIKeyEnvName:   h.config.Internal.APIKeyEnvName,
-		DefaultAPIKey:   h.config.Internal.DefaultAPIKey,
-		HeaderName:      h.config.Internal.HeaderName,
-	}
-
-	internalAuthMiddleware := pkgMiddleware.NewInternalAuthMiddleware(internalAPIConfig)
-	internal := router.Group("/internal", internalAuthMiddleware.Required())
+	internal := router.Group("/internal", newInternalAuthMiddleware(h.config).Required())
 	{
 		internalWarehouse := internal.Group("/warehouse")
 		{
@@ -321,3 +313,13 @@ func (h *WarehouseHandler) RegisterRoutes(router *gin.Engine, authMiddleware gin
 		}
 	}
 }
+
+// newInternalAuthMiddleware создает middleware проверки доступа к внутреннему API по конфигурации сервиса
+func newInternalAuthMiddleware(cfg *config.Config) *pkgMiddleware.InternalAuthMiddleware {
+	return pkgMiddleware.NewInternalAuthMiddleware(&pkgMiddleware.InternalAPIConfig{
+		TrustedNetworks: cfg.Internal.TrustedNetworks,
+		APIKeyEnvName:   cfg.Internal.APIKeyEnvName,
+		DefaultAPIKey:   cfg.Internal.DefaultAPIKey,
+		HeaderName:      cfg.Internal.HeaderName,
+	})
+}
@@ -3,6 +3,7 @@ package rabbitmq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 
@@ -19,12 +20,13 @@ type SagaConsumer struct {
 }
 
 // NewSagaConsumer создает новый обработчик сообщений саги для склада
-func NewSagaConsumer(warehouseUseCase *usecase.WarehouseUseCase, rabbitMQ *rabbitmq.RabbitMQ) *SagaConsumer {
+func NewSagaConsumer(warehouseUseCase *usecase.WarehouseUseCase, rabbitMQ *rabbitmq.RabbitMQ, ledger *sagahandler.Ledger) *SagaConsumer {
 	return &SagaConsumer{
 		BaseSagaConsumer: sagahandler.BaseSagaConsumer{
 			RabbitMQ: rabbitMQ,
 			Logger:   log.New(log.Writer(), "[WarehouseService] [Saga] ", log.LstdFlags),
 			Step:     "reserve_warehouse",
+			Ledger:   ledger,
 		},
 		warehouseUseCase: warehouseUseCase,
 	}
@@ -41,6 +43,70 @@ func (c *SagaConsumer) Setup() error {
 	)
 }
 
+// SetupConfirmConsumer настраивает consumer для шага подтверждения заказа: при подтверждении
+// резервирование товаров заказа переходит в продажу и больше не истекает. Результат шага confirm_order
+// публикует сервис доставки, поэтому здесь результат не отправляется.
+func (c *SagaConsumer) SetupConfirmConsumer() error {
+	queueName := "warehouse_confirm_queue"
+	exchangeName := "saga_exchange"
+	routingKey := "saga.confirm_order.execute"
+
+	err := c.RabbitMQ.DeclareQueue(queueName)
+	if err != nil {
+		return fmt.Errorf("ошибка при создании очереди %s: %w", queueName, err)
+	}
+
+	err = c.RabbitMQ.BindQueue(queueName, exchangeName, routingKey)
+	if err != nil {
+		return fmt.Errorf("ошибка при привязке очереди %s к обмену %s с ключом %s: %w", queueName, exchangeName, routingKey, err)
+	}
+
+	consumerTag := "warehouse_confirm_consumer_" + queueName
+	err = c.RabbitMQ.ConsumeMessages(queueName, consumerTag, c.handleConfirmWarehouse)
+	if err != nil {
+		return fmt.Errorf("ошибка при запуске consumer'а для очереди %s: %w", queueName, err)
+	}
+
+	c.Logger.Printf("Настроен обработчик для шага confirm_order (очередь %s)", queueName)
+	return nil
+}
+
+// handleConfirmWarehouse подтверждает резервирование товаров заказа при его подтверждении.
+// Ошибка подтверждения возвращается, чтобы сообщение было доставлено повторно. Отмененное или
+// истекшее резервирование повторно не подтвердить, поэтому такое сообщение только логируется.
+func (c *SagaConsumer) handleConfirmWarehouse(data []byte) error {
+	message, err := sagahandler.ParseSagaMessage(data)
+	if err != nil {
+		return err
+	}
+
+	var sagaData sagahandler.SagaData
+	if err := json.Unmarshal(message.Data, &sagaData); err != nil {
+		return fmt.Errorf("SagaID=%s: ошибка десериализации данных заказа при подтверждении резервирования: %w", message.SagaID, err)
+	}
+
+	orderID := sagaData.OrderID
+	if sagaData.WarehouseInfo != nil && sagaData.WarehouseInfo.ReservationID != "" {
+		orderID = sagahandler.ParseUint(sagaData.WarehouseInfo.ReservationID)
+	}
+	if orderID == 0 {
+		c.Logger.Printf("SagaID=%s: [ERROR] Не найден заказ для подтверждения резервирования", message.SagaID)
+		return nil
+	}
+
+	if err := c.warehouseUseCase.ConfirmOrderReservation(context.Background(), orderID); err != nil {
+		if errors.Is(err, usecase.ErrReservationNotConfirmable) {
+			c.Logger.Printf("SagaID=%s: [ERROR] Резервирование заказа OrderID=%d не подтверждено: %v", message.SagaID, orderID, err)
+			return nil
+		}
+		c.Logger.Printf("SagaID=%s: [ERROR] Ошибка подтверждения резервирования OrderID=%d: %v", message.SagaID, orderID, err)
+		return err
+	}
+
+	c.Logger.Printf("SagaID=%s: Резервирование товаров заказа OrderID=%d подтверждено", message.SagaID, orderID)
+	return nil
+}
+
 // handleReserveWarehouse обрабатывает сообщение для резервирования на складе
 func (c *SagaConsumer) handleReserveWarehouse(data []byte) error {
 	message, err := sagahandler.ParseSagaMessage(data)
@@ -86,10 +152,17 @@ func (c *SagaConsumer) handleReserveWarehouse(data []byte) error {
 		})
 	}
 
+	// Резервирование саги ограничено сроком: если сага не подтвердит и не отменит его, товары вернутся в остаток
+	reservationTTL := c.warehouseUseCase.ReservationTTL()
 	reserveRequest := &entity.ReserveWarehouseRequest{
-		OrderID: sagaData.OrderID,
-		UserID:  sagaData.UserID,
-		Items:   make([]entity.ReserveItem, 0, len(sagaData.Items)),
+		OrderID:   sagaData.OrderID,
+		UserID:    sagaData.UserID,
+		Items:     make([]entity.ReserveItem, 0, len(sagaData.Items)),
+		ExpiresIn: &reservationTTL,
+	}
+	// Товар резервируется на складе, ближайшем к зоне доставки заказа
+	if sagaData.DeliveryInfo != nil {
+		reserveRequest.ZoneID = sagaData.DeliveryInfo.ZoneID
 	}
 	for i := 0; i < len(sagaData.Items); i++ {
 		reserveRequest.Items = append(reserveRequest.Items, entity.ReserveItem{
@@ -98,7 +171,9 @@ func (c *SagaConsumer) handleReserveWarehouse(data []byte) error {
 		})
 	}
 
-	result, err := c.warehouseUseCase.ReserveWarehouseItems(context.Background(), reserveRequest)
+	// Если товара не хватает, но его можно заказать под поступление, заказ встает в очередь:
+	// результат шага будет опубликован, когда поступивший товар зарезервируют под заказ
+	result, err := c.warehouseUseCase.ReserveOrBackorder(context.Background(), reserveRequest, message.SagaID, message.Data)
 	if err != nil {
 		// Логируем ошибку резервирования
 		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка резервирования для OrderID=%d: %v", message.SagaID, sagaData.OrderID, err)
@@ -106,6 +181,12 @@ func (c *SagaConsumer) handleReserveWarehouse(data []byte) error {
 			fmt.Sprintf("ошибка резервирования на складе: %v", err), message.Data)
 	}
 
+	if result.Backordered {
+		c.Logger.Printf("SagaID=%s: Заказ OrderID=%d ожидает поступления товара, результат шага %s будет отправлен после резервирования",
+			message.SagaID, sagaData.OrderID, c.Step)
+		return nil
+	}
+
 	// Логируем успешное резервирование
 	c.Logger.Printf("SagaID=%s: Резервирование для OrderID=%d выполнено успешно. ReservationID: %d", message.SagaID, sagaData.OrderID, result.OrderID)
 
@@ -172,6 +253,12 @@ func (c *SagaConsumer) handleCompensateWarehouse(data []byte) error {
 			// Логируем успешную отмену
 			c.Logger.Printf("SagaID=%s: Резервирование %s (OrderID=%d) успешно отменено.", message.SagaID, reservationIDstr, sagaData.OrderID)
 		}
+	} else if cancelled, err := c.warehouseUseCase.CancelBackorder(context.Background(), sagaData.OrderID); err != nil {
+		// Повторная доставка сообщения повторит отмену
+		c.Logger.Printf("[ERROR] SagaID=%s: Ошибка отмены заказа под поступление OrderID=%d: %v", message.SagaID, sagaData.OrderID, err)
+		return err
+	} else if cancelled {
+		c.Logger.Printf("SagaID=%s: Заказ OrderID=%d убран из очереди под поступление", message.SagaID, sagaData.OrderID)
 	} else {
 		// Логируем отсутствие ID
 		c.Logger.Printf("SagaID=%s: Нет ID резервирования для компенсации. OrderID=%d", message.SagaID, sagaData.OrderID)
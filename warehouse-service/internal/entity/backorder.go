@@ -0,0 +1,98 @@
+package entity
+
+import "time"
+
+// BackorderStatus статус заказа, ожидающего поступления товара
+type BackorderStatus string
+
+// Константы для статусов заказа под поступление
+const (
+	BackorderStatusPending   BackorderStatus = "pending"   // Ожидает поступления товара
+	BackorderStatusAllocated BackorderStatus = "allocated" // Товар поступил и зарезервирован под заказ
+	BackorderStatusCancelled BackorderStatus = "cancelled" // Заказ отменен до поступления товара
+)
+
+// Backorder заказ, принятый при нехватке остатка товаров с разрешенным заказом под поступление.
+// Заказы ждут поступления в порядке очереди (по ID); когда товара хватает на весь заказ, он резервируется
+// и сага заказа получает результат шага reserve_warehouse.
+type Backorder struct {
+	ID                  uint            `json:"id" gorm:"primaryKey"`
+	OrderID             uint            `json:"order_id" gorm:"not null;uniqueIndex"`
+	UserID              uint            `json:"user_id" gorm:"not null"`
+	SagaID              string          `json:"saga_id" gorm:"not null;default:''"`
+	ZoneID              uint            `json:"zone_id,omitempty" gorm:"not null;default:0"` // Зона доставки для выбора склада при резервировании
+	Status              BackorderStatus `json:"status" gorm:"not null;default:'pending';index"`
+	ExpectedAvailableAt *time.Time      `json:"expected_available_at,omitempty"` // Не задана, если дата поступления какого-либо товара неизвестна
+	SagaData            []byte          `json:"-" gorm:"type:jsonb"`             // Данные шага reserve_warehouse для публикации его результата
+	Items               []BackorderItem `json:"items" gorm:"foreignKey:BackorderID"`
+	AllocatedAt         *time.Time      `json:"allocated_at,omitempty"`
+	CancelledAt         *time.Time      `json:"cancelled_at,omitempty"`
+	CreatedAt           time.Time       `json:"created_at"`
+	UpdatedAt           time.Time       `json:"updated_at"`
+}
+
+// BackorderItem позиция заказа под поступление
+type BackorderItem struct {
+	ID          uint  `json:"id" gorm:"primaryKey"`
+	BackorderID uint  `json:"backorder_id" gorm:"not null;index"`
+	ProductID   uint  `json:"product_id" gorm:"not null;index"`
+	Quantity    int   `json:"quantity" gorm:"not null"`
+	Shortage    int64 `json:"shortage" gorm:"not null;default:0"` // Недостающее количество на момент оформления
+}
+
+// SetBackorderPolicyRequest запрос на разрешение заказа товара под поступление.
+// Пустая дата поступления означает, что она неизвестна.
+type SetBackorderPolicyRequest struct {
+	Backorderable       *bool      `json:"backorderable" binding:"required"`
+	ExpectedAvailableAt *time.Time `json:"expected_available_at"`
+}
+
+// BackorderPolicyResponse параметры заказа товара под поступление
+type BackorderPolicyResponse struct {
+	ProductID           uint       `json:"product_id"`
+	Backorderable       bool       `json:"backorderable"`
+	ExpectedAvailableAt *time.Time `json:"expected_available_at,omitempty"`
+	Available           int64      `json:"available"`
+	PendingQuantity     int64      `json:"pending_quantity"`   // Количество товара, ожидаемое заказами в очереди
+	RescheduledOrders   int        `json:"rescheduled_orders"` // Заказы в очереди, для которых изменилась ожидаемая дата
+}
+
+// BackorderQuery параметры запроса очереди заказов под поступление
+type BackorderQuery struct {
+	Status    BackorderStatus `form:"status"`
+	ProductID uint            `form:"product_id"`
+	Limit     int             `form:"limit"`
+	Offset    int             `form:"offset"`
+}
+
+// ListBackordersResponse страница очереди заказов под поступление
+type ListBackordersResponse struct {
+	Backorders []Backorder `json:"backorders"`
+	Total      int64       `json:"total"`
+}
+
+// AllocateBackordersResponse результат распределения остатка между заказами под поступление
+type AllocateBackordersResponse struct {
+	Allocated int `json:"allocated"`
+	Pending   int `json:"pending"`
+}
+
+// BackorderScheduledMessage событие warehouse.backorder.scheduled: заказ поставлен в очередь под поступление
+// товара или у заказа в очереди изменилась ожидаемая дата поступления
+type BackorderScheduledMessage struct {
+	OrderID             uint            `json:"order_id"`
+	SagaID              string          `json:"saga_id"`
+	Items               []BackorderItem `json:"items"`
+	ExpectedAvailableAt *time.Time      `json:"expected_available_at,omitempty"`
+	Timestamp           int64           `json:"timestamp"`
+}
+
+// TableName задает имя таблицы заказов под поступление
+func (Backorder) TableName() string {
+	return "warehouse_backorders"
+}
+
+// TableName задает имя таблицы позиций заказов под поступление
+func (BackorderItem) TableName() string {
+	return "warehouse_backorder_items"
+}
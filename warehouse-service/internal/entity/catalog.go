@@ -0,0 +1,110 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/director74/dz8_shop/pkg/money"
+)
+
+// Category категория каталога товаров
+type Category struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	ParentID    *uint     `json:"parent_id,omitempty" gorm:"index"`
+	Name        string    `json:"name" gorm:"not null"`
+	Slug        string    `json:"slug" gorm:"not null;uniqueIndex"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ProductImage изображение товара каталога
+type ProductImage struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	WarehouseItemID uint      `json:"warehouse_item_id" gorm:"not null;index"`
+	URL             string    `json:"url" gorm:"not null"`
+	Position        int       `json:"position" gorm:"not null;default:0"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// CatalogSort порядок сортировки товаров каталога
+type CatalogSort string
+
+// Константы для порядка сортировки каталога
+const (
+	CatalogSortDefault   CatalogSort = "id"         // По порядку добавления
+	CatalogSortPriceAsc  CatalogSort = "price_asc"  // По возрастанию цены
+	CatalogSortPriceDesc CatalogSort = "price_desc" // По убыванию цены
+)
+
+// CatalogQuery параметры запроса каталога. Category принимает ID или slug категории,
+// Cursor - значение next_cursor предыдущей страницы
+type CatalogQuery struct {
+	Query    string        `form:"q"`
+	Category string        `form:"category"`
+	MinPrice *money.Amount `form:"min_price"`
+	MaxPrice *money.Amount `form:"max_price"`
+	InStock  bool          `form:"in_stock"`
+	Sort     CatalogSort   `form:"sort"`
+	Cursor   string        `form:"cursor"`
+	Limit    int           `form:"limit"`
+}
+
+// CatalogFilter параметры поиска товаров каталога
+type CatalogFilter struct {
+	Query      string
+	CategoryID *uint
+	MinPrice   *money.Amount
+	MaxPrice   *money.Amount
+	InStock    bool
+	Sort       CatalogSort
+	After      *CatalogCursor
+	Limit      int
+}
+
+// CatalogCursor позиция в выдаче каталога для курсорной пагинации:
+// значение ключа сортировки и ID последнего товара предыдущей страницы
+type CatalogCursor struct {
+	Sort  CatalogSort  `json:"s"`
+	Price money.Amount `json:"p,omitempty"`
+	ID    uint         `json:"i"`
+}
+
+// CatalogProductResponse товар в выдаче каталога
+type CatalogProductResponse struct {
+	ProductID   uint            `json:"product_id"`
+	SKU         string          `json:"sku"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Price       money.Amount    `json:"price"`
+	Available   int64           `json:"available"`
+	InStock     bool            `json:"in_stock"`
+	Status      WarehouseStatus `json:"status"`
+	Category    *Category       `json:"category,omitempty"`
+	Images      []string        `json:"images"`
+	// Backorderable товар можно заказать при отсутствии в наличии: заказ будет собран после поступления
+	Backorderable       bool       `json:"backorderable"`
+	ExpectedAvailableAt *time.Time `json:"expected_available_at,omitempty"` // Ожидаемая дата поступления товара
+}
+
+// CatalogPageResponse страница выдачи каталога
+type CatalogPageResponse struct {
+	Items      []CatalogProductResponse `json:"items"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+	Limit      int                      `json:"limit"`
+}
+
+// CreateCategoryRequest запрос на создание категории
+type CreateCategoryRequest struct {
+	ParentID    *uint  `json:"parent_id"`
+	Name        string `json:"name" binding:"required"`
+	Slug        string `json:"slug" binding:"required"`
+	Description string `json:"description"`
+}
+
+// UpdateCatalogProductRequest запрос на изменение карточки товара в каталоге.
+// Не переданные поля не изменяются; images заменяет весь список изображений
+type UpdateCatalogProductRequest struct {
+	Description *string   `json:"description"`
+	CategoryID  *uint     `json:"category_id"`
+	Images      *[]string `json:"images"`
+}
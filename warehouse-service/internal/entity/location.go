@@ -0,0 +1,105 @@
+package entity
+
+import "time"
+
+// Warehouse склад (фулфилмент-центр), на котором хранится товар.
+// Товар резервируется на складе, ближайшем к зоне доставки заказа (см. WarehouseZone).
+type Warehouse struct {
+	ID        uint            `json:"id" gorm:"primaryKey"`
+	Code      string          `json:"code" gorm:"not null;uniqueIndex"`
+	Name      string          `json:"name" gorm:"not null"`
+	Address   string          `json:"address" gorm:"not null;default:''"`
+	Active    bool            `json:"active" gorm:"not null;default:true"`      // Неактивный склад не участвует в резервировании
+	IsDefault bool            `json:"is_default" gorm:"not null;default:false"` // Основной склад для операций без указания склада
+	Zones     []WarehouseZone `json:"zones" gorm:"foreignKey:WarehouseID"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// WarehouseZone удаленность склада от зоны доставки: чем меньше Distance, тем ближе склад к зоне.
+// Склады без записи для зоны считаются самыми удаленными от нее.
+type WarehouseZone struct {
+	WarehouseID uint `json:"warehouse_id" gorm:"primaryKey;autoIncrement:false"`
+	ZoneID      uint `json:"zone_id" gorm:"primaryKey;autoIncrement:false"`
+	Distance    uint `json:"distance" gorm:"not null;default:0"`
+}
+
+// LocationStock остаток товара на конкретном складе.
+// Остаток и резерв WarehouseItem равны сумме остатков и резервов товара по всем складам.
+type LocationStock struct {
+	ID               uint      `json:"id" gorm:"primaryKey"`
+	WarehouseID      uint      `json:"warehouse_id" gorm:"not null;uniqueIndex:idx_warehouse_stocks_location_item"`
+	WarehouseItemID  uint      `json:"warehouse_item_id" gorm:"not null;uniqueIndex:idx_warehouse_stocks_location_item"`
+	ProductID        uint      `json:"product_id" gorm:"not null;index"`
+	Bin              string    `json:"bin" gorm:"not null;default:''"` // Место хранения внутри склада (секция, полка)
+	Quantity         int64     `json:"quantity" gorm:"type:bigint;not null;default:0"`
+	ReservedQuantity int64     `json:"reserved_quantity" gorm:"type:bigint;not null;default:0"`
+	Available        int64     `json:"available" gorm:"->;-:migration;-:update;column:available"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// TableName указывает имя таблицы для LocationStock
+func (LocationStock) TableName() string {
+	return "warehouse_stocks"
+}
+
+// StockAllocation часть количества товара заказа, резервируемая на конкретном складе
+type StockAllocation struct {
+	ProductID   uint `json:"product_id"`
+	WarehouseID uint `json:"warehouse_id"`
+	Quantity    int  `json:"quantity"`
+}
+
+// CreateWarehouseRequest запрос на создание склада
+type CreateWarehouseRequest struct {
+	Code      string `json:"code" binding:"required"`
+	Name      string `json:"name" binding:"required"`
+	Address   string `json:"address"`
+	Active    *bool  `json:"active"`
+	IsDefault bool   `json:"is_default"`
+}
+
+// UpdateWarehouseRequest запрос на изменение склада. Не переданные поля не меняются.
+type UpdateWarehouseRequest struct {
+	Name      *string `json:"name"`
+	Address   *string `json:"address"`
+	Active    *bool   `json:"active"`
+	IsDefault *bool   `json:"is_default"`
+}
+
+// SetWarehouseZonesRequest запрос на замену списка обслуживаемых складом зон доставки
+type SetWarehouseZonesRequest struct {
+	Zones []WarehouseZoneInput `json:"zones" binding:"dive"`
+}
+
+// WarehouseZoneInput удаленность склада от зоны доставки
+type WarehouseZoneInput struct {
+	ZoneID   uint `json:"zone_id" binding:"required"`
+	Distance uint `json:"distance"`
+}
+
+// ListWarehousesResponse список складов
+type ListWarehousesResponse struct {
+	Warehouses []Warehouse `json:"warehouses"`
+}
+
+// ProductStockResponse остатки товара по складам
+type ProductStockResponse struct {
+	ProductID uint                    `json:"product_id"`
+	Quantity  int64                   `json:"quantity"`
+	Available int64                   `json:"available"`
+	Locations []LocationStockResponse `json:"locations"`
+}
+
+// LocationStockResponse остаток товара на складе
+type LocationStockResponse struct {
+	WarehouseID      uint   `json:"warehouse_id"`
+	WarehouseCode    string `json:"warehouse_code"`
+	WarehouseName    string `json:"warehouse_name"`
+	Active           bool   `json:"active"`
+	Bin              string `json:"bin"`
+	Quantity         int64  `json:"quantity"`
+	ReservedQuantity int64  `json:"reserved_quantity"`
+	Available        int64  `json:"available"`
+}
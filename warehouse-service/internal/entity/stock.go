@@ -0,0 +1,190 @@
+package entity
+
+import "time"
+
+// StockMovementType тип движения товара на складе
+type StockMovementType string
+
+// Константы для типов движения товара
+const (
+	StockMovementReceipt    StockMovementType = "receipt"    // Приемка товара
+	StockMovementWriteOff   StockMovementType = "write_off"  // Списание (брак, порча, недостача)
+	StockMovementCount      StockMovementType = "count"      // Инвентаризация: остаток приведен к фактическому
+	StockMovementAdjustment StockMovementType = "adjustment" // Ручная корректировка остатка
+	StockMovementReserve    StockMovementType = "reserve"    // Резервирование под заказ
+	StockMovementRelease    StockMovementType = "release"    // Отмена резервирования
+	StockMovementExpire     StockMovementType = "expire"     // Истечение срока резервирования
+	StockMovementSale       StockMovementType = "sale"       // Продажа по подтвержденному резервированию
+)
+
+// StockActorSystem исполнитель движений, которые выполняет сам сервис (сага заказа, фоновые проверки)
+const StockActorSystem = "system"
+
+// StockLevel уровень доступного остатка товара относительно точки дозаказа и страхового запаса
+type StockLevel string
+
+// Константы для уровней остатка товара
+const (
+	StockLevelNormal   StockLevel = "normal"   // Остаток выше точки дозаказа или контроль отключен
+	StockLevelLow      StockLevel = "low"      // Остаток достиг точки дозаказа
+	StockLevelCritical StockLevel = "critical" // Остаток достиг страхового запаса
+)
+
+// Severity возвращает тяжесть уровня остатка для сравнения уровней: чем больше, тем хуже
+func (l StockLevel) Severity() int {
+	switch l {
+	case StockLevelLow:
+		return 1
+	case StockLevelCritical:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// StockMovement запись журнала движения товара. Журнал только пополняется: записи не изменяются и не удаляются.
+// Для каждого движения сохраняются остаток (Quantity) и резерв (ReservedQuantity) товара на складе WarehouseID
+// до и после изменения.
+type StockMovement struct {
+	ID              uint              `json:"id" gorm:"primaryKey"`
+	WarehouseID     *uint             `json:"warehouse_id,omitempty" gorm:"index"`
+	WarehouseItemID uint              `json:"warehouse_item_id" gorm:"not null;index"`
+	ProductID       uint              `json:"product_id" gorm:"not null;index"`
+	Type            StockMovementType `json:"type" gorm:"not null;index"`
+	QuantityDelta   int64             `json:"quantity_delta" gorm:"not null;default:0"`
+	QuantityBefore  int64             `json:"quantity_before" gorm:"not null"`
+	QuantityAfter   int64             `json:"quantity_after" gorm:"not null"`
+	ReservedDelta   int64             `json:"reserved_delta" gorm:"not null;default:0"`
+	ReservedBefore  int64             `json:"reserved_before" gorm:"not null"`
+	ReservedAfter   int64             `json:"reserved_after" gorm:"not null"`
+	Reason          string            `json:"reason,omitempty"`
+	Actor           string            `json:"actor" gorm:"not null"`
+	Reference       string            `json:"reference,omitempty"` // Номер накладной, акта списания или инвентаризации
+	OrderID         *uint             `json:"order_id,omitempty" gorm:"index"`
+	ReservationID   *uint             `json:"reservation_id,omitempty" gorm:"index"`
+	Principal       string            `json:"principal,omitempty" gorm:"not null;default:''"` // Вызывающий, прошедший проверку доступа к внутреннему API
+	CreatedAt       time.Time         `json:"created_at" gorm:"index"`
+}
+
+// ReceiveStockRequest запрос на приемку товара
+type ReceiveStockRequest struct {
+	ProductID   uint   `json:"product_id" binding:"required"`
+	WarehouseID uint   `json:"warehouse_id"` // Не указан - основной склад
+	Quantity    int64  `json:"quantity" binding:"required,gt=0"`
+	Actor       string `json:"actor" binding:"required"`
+	Principal   string `json:"-"` // Заполняется по проверке доступа к внутреннему API, а не из тела запроса
+	Reason      string `json:"reason"`
+	Reference   string `json:"reference"`
+}
+
+// WriteOffStockRequest запрос на списание товара
+type WriteOffStockRequest struct {
+	ProductID   uint   `json:"product_id" binding:"required"`
+	WarehouseID uint   `json:"warehouse_id"` // Не указан - основной склад
+	Quantity    int64  `json:"quantity" binding:"required,gt=0"`
+	Actor       string `json:"actor" binding:"required"`
+	Principal   string `json:"-"` // Заполняется по проверке доступа к внутреннему API, а не из тела запроса
+	Reason      string `json:"reason" binding:"required"`
+	Reference   string `json:"reference"`
+}
+
+// InventoryCountRequest результат инвентаризации: фактическое количество товара на складе
+type InventoryCountRequest struct {
+	ProductID       uint   `json:"product_id" binding:"required"`
+	WarehouseID     uint   `json:"warehouse_id"` // Не указан - основной склад
+	CountedQuantity *int64 `json:"counted_quantity" binding:"required,gte=0"`
+	Actor           string `json:"actor" binding:"required"`
+	Principal       string `json:"-"` // Заполняется по проверке доступа к внутреннему API, а не из тела запроса
+	Reason          string `json:"reason"`
+	Reference       string `json:"reference"`
+}
+
+// AdjustStockRequest запрос на ручную корректировку остатка на Delta (положительную или отрицательную)
+type AdjustStockRequest struct {
+	ProductID   uint   `json:"product_id" binding:"required"`
+	WarehouseID uint   `json:"warehouse_id"` // Не указан - основной склад
+	Delta       int64  `json:"delta" binding:"required"`
+	Actor       string `json:"actor" binding:"required"`
+	Principal   string `json:"-"` // Заполняется по проверке доступа к внутреннему API, а не из тела запроса
+	Reason      string `json:"reason" binding:"required"`
+	Reference   string `json:"reference"`
+}
+
+// StockOperationResponse результат операции с остатком: товар после изменения и запись журнала
+type StockOperationResponse struct {
+	Item     GetWarehouseResponse `json:"item"`
+	Stock    LocationStock        `json:"stock"`
+	Movement StockMovement        `json:"movement"`
+	// BackordersAllocated количество заказов из очереди под поступление, зарезервированных после приемки
+	BackordersAllocated int `json:"backorders_allocated,omitempty"`
+}
+
+// StockMovementQuery параметры запроса журнала движения товаров
+type StockMovementQuery struct {
+	ProductID   uint              `form:"product_id"`
+	WarehouseID uint              `form:"warehouse_id"`
+	Type        StockMovementType `form:"type"`
+	OrderID     uint              `form:"order_id"`
+	Actor       string            `form:"actor"`
+	From        *time.Time        `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To          *time.Time        `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit       int               `form:"limit"`
+	Offset      int               `form:"offset"`
+}
+
+// ListStockMovementsResponse страница журнала движения товаров
+type ListStockMovementsResponse struct {
+	Movements []StockMovement `json:"movements"`
+	Total     int64           `json:"total"`
+}
+
+// SetReorderPolicyRequest запрос на установку точки дозаказа и страхового запаса товара.
+// Нулевая точка дозаказа отключает контроль остатка товара.
+type SetReorderPolicyRequest struct {
+	ReorderPoint *int64 `json:"reorder_point" binding:"required,gte=0"`
+	SafetyStock  int64  `json:"safety_stock" binding:"gte=0"`
+}
+
+// ReorderPolicyResponse точка дозаказа, страховой запас и текущий уровень остатка товара
+type ReorderPolicyResponse struct {
+	ProductID    uint       `json:"product_id"`
+	ReorderPoint int64      `json:"reorder_point"`
+	SafetyStock  int64      `json:"safety_stock"`
+	Available    int64      `json:"available"`
+	Level        StockLevel `json:"level"`
+}
+
+// LowStockItem товар, доступный остаток которого достиг точки дозаказа
+type LowStockItem struct {
+	ProductID        uint       `json:"product_id"`
+	SKU              string     `json:"sku"`
+	Name             string     `json:"name"`
+	Level            StockLevel `json:"level"`
+	Quantity         int64      `json:"quantity"`
+	ReservedQuantity int64      `json:"reserved_quantity"`
+	Available        int64      `json:"available"`
+	ReorderPoint     int64      `json:"reorder_point"`
+	SafetyStock      int64      `json:"safety_stock"`
+	Shortfall        int64      `json:"shortfall"` // Сколько не хватает до точки дозаказа
+}
+
+// LowStockReportQuery параметры отчета о товарах с низким остатком
+type LowStockReportQuery struct {
+	Level StockLevel `form:"level"` // low или critical; не указан - оба уровня
+}
+
+// LowStockReportResponse отчет о товарах с низким остатком, начиная с самых критичных
+type LowStockReportResponse struct {
+	Items       []LowStockItem `json:"items"`
+	Total       int            `json:"total"`
+	GeneratedAt time.Time      `json:"generated_at"`
+}
+
+// LowStockMessage событие warehouse.stock.low: доступный остаток товара достиг точки дозаказа (low)
+// или страхового запаса (critical). Публикуется один раз при ухудшении уровня остатка.
+type LowStockMessage struct {
+	LowStockItem
+	PreviousLevel StockLevel `json:"previous_level"`
+	DetectedAt    time.Time  `json:"detected_at"`
+	Timestamp     int64      `json:"timestamp"`
+}
@@ -2,6 +2,8 @@ package entity
 
 import (
 	"time"
+
+	"github.com/director74/dz8_shop/pkg/money"
 )
 
 // WarehouseStatus статус товара на складе
@@ -17,20 +19,27 @@ const (
 
 // WarehouseItem представляет товар на складе
 type WarehouseItem struct {
-	ID               uint            `json:"id" gorm:"primaryKey"`
-	ProductID        uint            `json:"product_id" gorm:"not null;index"`
-	SKU              string          `json:"sku" gorm:"not null;uniqueIndex"`
-	Name             string          `json:"name" gorm:"not null"`
-	Description      string          `json:"description"`
-	Quantity         int64           `json:"quantity" gorm:"type:bigint;not null;default:0"`
-	Available        int64           `json:"available" gorm:"->;-:migration;-:update;column:available"`
-	ReservedQuantity int64           `json:"reserved_quantity" gorm:"column:reserved_quantity;type:bigint;not null;default:0"`
-	Price            float64         `json:"price" gorm:"not null"`
-	Status           WarehouseStatus `json:"status" gorm:"not null;default:'available'"`
-	Location         string          `json:"location" gorm:"not null;default:''"`
-	LastOrderID      *uint           `json:"last_order_id" gorm:"index"`
-	CreatedAt        time.Time       `json:"created_at"`
-	UpdatedAt        time.Time       `json:"updated_at"`
+	ID                  uint            `json:"id" gorm:"primaryKey"`
+	ProductID           uint            `json:"product_id" gorm:"not null;index"`
+	SKU                 string          `json:"sku" gorm:"not null;uniqueIndex"`
+	Name                string          `json:"name" gorm:"not null"`
+	Description         string          `json:"description"`
+	Quantity            int64           `json:"quantity" gorm:"type:bigint;not null;default:0"`
+	Available           int64           `json:"available" gorm:"->;-:migration;-:update;column:available"`
+	ReservedQuantity    int64           `json:"reserved_quantity" gorm:"column:reserved_quantity;type:bigint;not null;default:0"`
+	Price               money.Amount    `json:"price" gorm:"not null"`
+	Status              WarehouseStatus `json:"status" gorm:"not null;default:'available'"`
+	Location            string          `json:"location" gorm:"not null;default:''"`
+	CategoryID          *uint           `json:"category_id" gorm:"index"`
+	LastOrderID         *uint           `json:"last_order_id" gorm:"index"`
+	ReorderPoint        int64           `json:"reorder_point" gorm:"type:bigint;not null;default:0"` // Доступный остаток, при котором товар пора дозаказать; 0 - контроль отключен
+	SafetyStock         int64           `json:"safety_stock" gorm:"type:bigint;not null;default:0"`  // Страховой запас: остаток ниже него критичен
+	StockLevel          StockLevel      `json:"stock_level" gorm:"not null;default:'normal'"`        // Уровень остатка на момент последней проверки
+	StockLevelAt        *time.Time      `json:"stock_level_at,omitempty"`
+	Backorderable       bool            `json:"backorderable" gorm:"not null;default:false"` // Заказ принимается при нехватке остатка и ждет поступления товара
+	ExpectedAvailableAt *time.Time      `json:"expected_available_at,omitempty"`             // Ожидаемая дата поступления товара
+	CreatedAt           time.Time       `json:"created_at"`
+	UpdatedAt           time.Time       `json:"updated_at"`
 }
 
 // ReservationStatus статус резервирования
@@ -54,20 +63,21 @@ type WarehouseReservation struct {
 	WarehouseItemID   uint              `json:"warehouse_item_id" gorm:"not null"`
 	ProductID         uint              `json:"product_id" gorm:"not null"`
 	Quantity          int               `json:"quantity" gorm:"not null"`
+	WarehouseID       *uint             `json:"warehouse_id,omitempty" gorm:"index"` // Склад, на котором зарезервирован товар
 	Status            ReservationStatus `json:"status" gorm:"not null;default:'pending'"`
 	ReservedAt        time.Time         `json:"reserved_at"`
-	ReservationExpiry time.Time         `json:"reservation_expiry"`
+	ReservationExpiry time.Time         `json:"reservation_expiry" gorm:"index"`
 	CreatedAt         time.Time         `json:"created_at"`
 	UpdatedAt         time.Time         `json:"updated_at"`
 }
 
 // WarehouseReservationItem представляет товар в резервировании
 type WarehouseReservationItem struct {
-	ID            uint    `json:"id" db:"id"`
-	ReservationID uint    `json:"reservation_id" db:"reservation_id"`
-	ProductID     uint    `json:"product_id" db:"product_id"`
-	Quantity      uint    `json:"quantity" db:"quantity"`
-	Price         float64 `json:"price" db:"price"`
+	ID            uint         `json:"id" db:"id"`
+	ReservationID uint         `json:"reservation_id" db:"reservation_id"`
+	ProductID     uint         `json:"product_id" db:"product_id"`
+	Quantity      uint         `json:"quantity" db:"quantity"`
+	Price         money.Amount `json:"price" db:"price"`
 }
 
 // AvailabilityCheck представляет запрос на проверку наличия товаров
@@ -105,6 +115,7 @@ type ReserveWarehouseRequest struct {
 	UserID    uint           `json:"user_id" binding:"required"`
 	Items     []ReserveItem  `json:"items" binding:"required,dive"`
 	ExpiresIn *time.Duration `json:"expires_in,omitempty"`
+	ZoneID    uint           `json:"zone_id,omitempty"` // Зона доставки: товар резервируется на ближайшем к ней складе
 }
 
 // ReserveItem элемент для резервации
@@ -131,13 +142,26 @@ type WarehouseResponse struct {
 	Message       string             `json:"message,omitempty"`
 	OrderID       uint               `json:"order_id,omitempty"`
 	ReservedItems []ReservedItemInfo `json:"reserved_items,omitempty"`
+	// Backordered заказ поставлен в очередь под заказ: товары будут зарезервированы после поступления
+	Backordered         bool       `json:"backordered,omitempty"`
+	ExpectedAvailableAt *time.Time `json:"expected_available_at,omitempty"`
 }
 
 // ReservedItemInfo информация о зарезервированном товаре
 type ReservedItemInfo struct {
-	ProductID  uint `json:"product_id"`
-	Quantity   int  `json:"quantity"`
-	ReservedID uint `json:"reserved_id"`
+	ProductID   uint `json:"product_id"`
+	Quantity    int  `json:"quantity"`
+	ReservedID  uint `json:"reserved_id"`
+	WarehouseID uint `json:"warehouse_id,omitempty"`
+}
+
+// ReservationExpiredMessage событие warehouse.reservation.expired: резервирование товаров заказа
+// не было подтверждено или отменено в срок, товары возвращены в доступный остаток
+type ReservationExpiredMessage struct {
+	OrderID   uint               `json:"order_id"`
+	Items     []ReservedItemInfo `json:"items"`
+	ExpiredAt time.Time          `json:"expired_at"`
+	Timestamp int64              `json:"timestamp"`
 }
 
 // GetWarehouseResponse ответ на запрос информации о товаре
@@ -145,6 +169,8 @@ type GetWarehouseResponse struct {
 	ID          uint            `json:"id"`
 	ProductID   uint            `json:"product_id"`
 	SKU         string          `json:"sku"`
+	Name        string          `json:"name"`
+	Price       money.Amount    `json:"price"`
 	Quantity    int64           `json:"quantity"`
 	Available   int64           `json:"available"`
 	Status      WarehouseStatus `json:"status"`
@@ -169,11 +195,16 @@ type CheckWarehouseRequest struct {
 type CheckWarehouseResponse struct {
 	Available        bool              `json:"available"`
 	UnavailableItems []UnavailableItem `json:"unavailable_items,omitempty"`
+	// Backorderable все недостающие товары можно заказать под поступление
+	Backorderable       bool       `json:"backorderable,omitempty"`
+	ExpectedAvailableAt *time.Time `json:"expected_available_at,omitempty"` // Не задана, если дата поступления какого-либо товара неизвестна
 }
 
 // UnavailableItem информация о недоступном товаре
 type UnavailableItem struct {
-	ProductID         uint  `json:"product_id"`
-	RequestedQuantity int64 `json:"requested_quantity"`
-	AvailableQuantity int64 `json:"available_quantity"`
+	ProductID           uint       `json:"product_id"`
+	RequestedQuantity   int64      `json:"requested_quantity"`
+	AvailableQuantity   int64      `json:"available_quantity"` // Свободный остаток за вычетом товара, ожидаемого заказами под поступление
+	Backorderable       bool       `json:"backorderable,omitempty"`
+	ExpectedAvailableAt *time.Time `json:"expected_available_at,omitempty"`
 }
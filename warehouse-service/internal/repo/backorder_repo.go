@@ -0,0 +1,331 @@
+package repo
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrBackorderNotPending заказ под поступление уже зарезервирован или отменен
+var ErrBackorderNotPending = errors.New("заказ не ожидает поступления товара")
+
+// backorderQueueLockKey ключ advisory-блокировки PostgreSQL, под которой выполняются проходы по очереди
+// заказов под поступление
+const backorderQueueLockKey int64 = 0x6261636b6f72
+
+// SetBackorderPolicy разрешает или запрещает заказ товара productID под поступление
+// и задает ожидаемую дату поступления товара
+func (r *WarehouseRepo) SetBackorderPolicy(ctx context.Context, productID uint, backorderable bool, expectedAt *time.Time) (*entity.WarehouseItem, error) {
+	var item entity.WarehouseItem
+	if err := r.db.WithContext(ctx).Where("product_id = ?", productID).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: ID продукта %d", ErrWarehouseItemNotFound, productID)
+		}
+		return nil, err
+	}
+
+	item.Backorderable = backorderable
+	item.ExpectedAvailableAt = expectedAt
+	if err := r.db.WithContext(ctx).Model(&item).Select("backorderable", "expected_available_at", "updated_at").Updates(&item).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// PendingBackorderDemand возвращает количество товаров productIDs, ожидаемое заказами в очереди под поступление.
+// Этот остаток обещан заказам из очереди, поэтому новые заказы не могут его зарезервировать.
+func (r *WarehouseRepo) PendingBackorderDemand(ctx context.Context, productIDs []uint) (map[uint]int64, error) {
+	return pendingBackorderDemand(r.db.WithContext(ctx), productIDs)
+}
+
+// CreateBackorder ставит заказ в очередь под поступление товара вместе с позициями.
+// onCreated вызывается в той же транзакции (например, чтобы сохранить событие в outbox).
+func (r *WarehouseRepo) CreateBackorder(ctx context.Context, backorder *entity.Backorder, onCreated func(tx *gorm.DB) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(backorder).Error; err != nil {
+			return err
+		}
+		if onCreated != nil {
+			return onCreated(tx)
+		}
+		return nil
+	})
+}
+
+// GetBackorderByOrderID получает заказ под поступление по ID заказа
+func (r *WarehouseRepo) GetBackorderByOrderID(ctx context.Context, orderID uint) (*entity.Backorder, error) {
+	var backorder entity.Backorder
+	result := r.db.WithContext(ctx).Preload("Items").Where("order_id = ?", orderID).First(&backorder)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &backorder, nil
+}
+
+// GetPendingBackorders возвращает до limit заказов из очереди под поступление с ID больше afterID
+// в порядке постановки в очередь
+func (r *WarehouseRepo) GetPendingBackorders(ctx context.Context, afterID uint, limit int) ([]entity.Backorder, error) {
+	var backorders []entity.Backorder
+	err := r.db.WithContext(ctx).
+		Preload("Items").
+		Where("id > ? AND status = ?", afterID, entity.BackorderStatusPending).
+		Order("id").
+		Limit(limit).
+		Find(&backorders).Error
+	if err != nil {
+		return nil, err
+	}
+	return backorders, nil
+}
+
+// GetPendingBackordersByProduct возвращает заказы из очереди под поступление, ожидающие товар productID
+func (r *WarehouseRepo) GetPendingBackordersByProduct(ctx context.Context, productID uint) ([]entity.Backorder, error) {
+	var backorders []entity.Backorder
+	err := r.db.WithContext(ctx).
+		Preload("Items").
+		Where("status = ? AND id IN (?)", entity.BackorderStatusPending,
+			r.db.Model(&entity.BackorderItem{}).Select("backorder_id").Where("product_id = ?", productID)).
+		Order("id").
+		Find(&backorders).Error
+	if err != nil {
+		return nil, err
+	}
+	return backorders, nil
+}
+
+// ListBackorders возвращает страницу заказов под поступление, отфильтрованных по query, в порядке очереди,
+// и общее количество подходящих заказов
+func (r *WarehouseRepo) ListBackorders(ctx context.Context, query *entity.BackorderQuery) ([]entity.Backorder, int64, error) {
+	db := r.db.WithContext(ctx).Model(&entity.Backorder{})
+	if query.Status != "" {
+		db = db.Where("status = ?", query.Status)
+	}
+	if query.ProductID != 0 {
+		db = db.Where("id IN (?)", r.db.Model(&entity.BackorderItem{}).Select("backorder_id").Where("product_id = ?", query.ProductID))
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var backorders []entity.Backorder
+	if err := db.Preload("Items").Order("id").Limit(query.Limit).Offset(query.Offset).Find(&backorders).Error; err != nil {
+		return nil, 0, err
+	}
+	return backorders, total, nil
+}
+
+// AllocateBackorder резервирует товары заказа backorderID из очереди под поступление (см. ReserveOrderItems).
+// plan получает заказ и заблокированные остатки его товаров на всех складах. Если заказ уже не ожидает
+// поступления товара, возвращается ErrBackorderNotPending. После резервирования заказ отмечается
+// зарезервированным, а onAllocated вызывается в той же транзакции (например, чтобы сохранить результат
+// шага саги в outbox). Если plan или onAllocated возвращает ошибку, ничего не изменяется.
+func (r *WarehouseRepo) AllocateBackorder(ctx context.Context, backorderID uint, expiresIn *time.Duration, plan func(backorder *entity.Backorder, stocks []entity.LocationStock) ([]entity.StockAllocation, error), onAllocated func(tx *gorm.DB, backorder *entity.Backorder, reservations []entity.WarehouseReservation) error) ([]entity.WarehouseReservation, error) {
+	tx := r.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var backorder entity.Backorder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&backorder, backorderID).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if backorder.Status != entity.BackorderStatusPending {
+		tx.Rollback()
+		return nil, fmt.Errorf("%w: заказ %d в статусе %s", ErrBackorderNotPending, backorder.OrderID, backorder.Status)
+	}
+	if err := tx.Where("backorder_id = ?", backorder.ID).Order("id").Find(&backorder.Items).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	productIDs := make([]uint, 0, len(backorder.Items))
+	for _, item := range backorder.Items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	reservations, err := reserveOrderItems(tx, backorder.OrderID, productIDs, expiresIn, func(stocks []entity.LocationStock) ([]entity.StockAllocation, error) {
+		return plan(&backorder, stocks)
+	})
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	now := time.Now()
+	backorder.Status = entity.BackorderStatusAllocated
+	backorder.AllocatedAt = &now
+	if err := tx.Model(&backorder).Select("status", "allocated_at", "updated_at").Updates(&backorder).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if onAllocated != nil {
+		if err := onAllocated(tx, &backorder, reservations); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return reservations, tx.Commit().Error
+}
+
+// CancelBackorder отменяет заказ orderID в очереди под поступление и возвращает его статус до отмены.
+// Если заказа нет в очереди, возвращается пустой статус; повторная отмена ничего не меняет.
+// Резервирование уже распределенного заказа снимает вызывающий код.
+func (r *WarehouseRepo) CancelBackorder(ctx context.Context, orderID uint) (entity.BackorderStatus, error) {
+	tx := r.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return "", tx.Error
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var backorder entity.Backorder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_id = ?", orderID).First(&backorder).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	previous := backorder.Status
+	if previous == entity.BackorderStatusCancelled {
+		tx.Rollback()
+		return previous, nil
+	}
+
+	now := time.Now()
+	backorder.Status = entity.BackorderStatusCancelled
+	backorder.CancelledAt = &now
+	if err := tx.Model(&backorder).Select("status", "cancelled_at", "updated_at").Updates(&backorder).Error; err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	return previous, tx.Commit().Error
+}
+
+// LockBackorderQueue захватывает advisory-блокировку очереди заказов под поступление, дожидаясь, пока ее
+// освободит другой проход, в том числе с другого экземпляра сервиса. Блокировка держится на отдельном
+// соединении до вызова возвращенной функции unlock.
+func (r *WarehouseRepo) LockBackorderQueue(ctx context.Context) (func(), error) {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", backorderQueueLockKey); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ошибка блокировки очереди заказов под поступление: %w", err)
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", backorderQueueLockKey); err != nil {
+			// Соединение с неснятой блокировкой не возвращается в пул: блокировка снимется при его закрытии
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, nil
+}
+
+// RescheduleBackorder меняет ожидаемую дату поступления товаров заказа backorderID, если заказ еще
+// ожидает поступления и дата изменилась. onChange вызывается в той же транзакции с заказом после изменения.
+// Возвращает true, если дата изменилась.
+func (r *WarehouseRepo) RescheduleBackorder(ctx context.Context, backorderID uint, expectedAt *time.Time, onChange func(tx *gorm.DB, backorder *entity.Backorder) error) (bool, error) {
+	tx := r.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var backorder entity.Backorder
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&backorder, backorderID).Error; err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if backorder.Status != entity.BackorderStatusPending || sameTime(backorder.ExpectedAvailableAt, expectedAt) {
+		tx.Rollback()
+		return false, nil
+	}
+
+	backorder.ExpectedAvailableAt = expectedAt
+	if err := tx.Model(&backorder).Select("expected_available_at", "updated_at").Updates(&backorder).Error; err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err := tx.Where("backorder_id = ?", backorder.ID).Order("id").Find(&backorder.Items).Error; err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if onChange != nil {
+		if err := onChange(tx, &backorder); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+
+	return true, tx.Commit().Error
+}
+
+// pendingBackorderDemand суммирует количество товаров productIDs в заказах, ожидающих поступления
+func pendingBackorderDemand(db *gorm.DB, productIDs []uint) (map[uint]int64, error) {
+	var rows []struct {
+		ProductID uint
+		Quantity  int64
+	}
+	err := db.Model(&entity.BackorderItem{}).
+		Select("warehouse_backorder_items.product_id, SUM(warehouse_backorder_items.quantity) AS quantity").
+		Joins("JOIN warehouse_backorders ON warehouse_backorders.id = warehouse_backorder_items.backorder_id").
+		Where("warehouse_backorders.status = ? AND warehouse_backorder_items.product_id IN ?", entity.BackorderStatusPending, productIDs).
+		Group("warehouse_backorder_items.product_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	demand := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		demand[row.ProductID] = row.Quantity
+	}
+	return demand, nil
+}
+
+// sameTime сравнивает необязательные даты
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,190 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"gorm.io/gorm"
+)
+
+// catalogSearchVector выражение полнотекстового поиска по карточке товара.
+// Должно совпадать с выражением индекса idx_warehouse_items_search, иначе индекс не используется.
+const catalogSearchVector = "to_tsvector('russian', coalesce(name, '') || ' ' || coalesce(description, '') || ' ' || coalesce(sku, ''))"
+
+// categoryTreeQuery выбирает ID категории и всех ее подкатегорий
+const categoryTreeQuery = `WITH RECURSIVE category_tree AS (
+	SELECT id FROM categories WHERE id = ?
+	UNION ALL
+	SELECT c.id FROM categories c JOIN category_tree t ON c.parent_id = t.id
+) SELECT id FROM category_tree`
+
+// CatalogRepo репозиторий каталога товаров
+type CatalogRepo struct {
+	db *gorm.DB
+}
+
+// NewCatalogRepo создает новый репозиторий каталога
+func NewCatalogRepo(db *gorm.DB) *CatalogRepo {
+	return &CatalogRepo{
+		db: db,
+	}
+}
+
+// FindProducts возвращает товары каталога, подходящие под фильтр, в порядке filter.Sort
+// начиная с позиции filter.After
+func (r *CatalogRepo) FindProducts(ctx context.Context, filter entity.CatalogFilter) ([]entity.WarehouseItem, error) {
+	query := r.db.WithContext(ctx).Model(&entity.WarehouseItem{})
+
+	if filter.Query != "" {
+		query = query.Where(catalogSearchVector+" @@ websearch_to_tsquery('russian', ?)", filter.Query)
+	}
+	if filter.CategoryID != nil {
+		query = query.Where("category_id IN ("+categoryTreeQuery+")", *filter.CategoryID)
+	}
+	if filter.MinPrice != nil {
+		query = query.Where("price >= ?", *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		query = query.Where("price <= ?", *filter.MaxPrice)
+	}
+	if filter.InStock {
+		query = query.Where("available > 0 AND status = ?", entity.WarehouseStatusAvailable)
+	}
+
+	// Keyset-пагинация: ID замыкает порядок, чтобы позиция курсора была однозначной
+	switch filter.Sort {
+	case entity.CatalogSortPriceAsc:
+		if filter.After != nil {
+			query = query.Where("price > ? OR (price = ? AND id > ?)", filter.After.Price, filter.After.Price, filter.After.ID)
+		}
+		query = query.Order("price ASC, id ASC")
+	case entity.CatalogSortPriceDesc:
+		if filter.After != nil {
+			query = query.Where("price < ? OR (price = ? AND id > ?)", filter.After.Price, filter.After.Price, filter.After.ID)
+		}
+		query = query.Order("price DESC, id ASC")
+	default:
+		if filter.After != nil {
+			query = query.Where("id > ?", filter.After.ID)
+		}
+		query = query.Order("id ASC")
+	}
+
+	var items []entity.WarehouseItem
+	if err := query.Limit(filter.Limit).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetImages возвращает URL изображений товаров, сгруппированные по ID товара на складе
+func (r *CatalogRepo) GetImages(ctx context.Context, itemIDs []uint) (map[uint][]string, error) {
+	images := make(map[uint][]string)
+	if len(itemIDs) == 0 {
+		return images, nil
+	}
+
+	var rows []entity.ProductImage
+	err := r.db.WithContext(ctx).
+		Where("warehouse_item_id IN ?", itemIDs).
+		Order("warehouse_item_id, position, id").
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		images[row.WarehouseItemID] = append(images[row.WarehouseItemID], row.URL)
+	}
+	return images, nil
+}
+
+// GetCategoriesByIDs возвращает категории по списку ID
+func (r *CatalogRepo) GetCategoriesByIDs(ctx context.Context, ids []uint) (map[uint]*entity.Category, error) {
+	categories := make(map[uint]*entity.Category)
+	if len(ids) == 0 {
+		return categories, nil
+	}
+
+	var rows []entity.Category
+	if err := r.db.WithContext(ctx).Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for i := range rows {
+		categories[rows[i].ID] = &rows[i]
+	}
+	return categories, nil
+}
+
+// GetCategoryByID получает категорию по ID
+func (r *CatalogRepo) GetCategoryByID(ctx context.Context, id uint) (*entity.Category, error) {
+	var category entity.Category
+	result := r.db.WithContext(ctx).First(&category, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &category, nil
+}
+
+// GetCategoryBySlug получает категорию по slug
+func (r *CatalogRepo) GetCategoryBySlug(ctx context.Context, slug string) (*entity.Category, error) {
+	var category entity.Category
+	result := r.db.WithContext(ctx).Where("slug = ?", slug).First(&category)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &category, nil
+}
+
+// ListCategories получает список всех категорий
+func (r *CatalogRepo) ListCategories(ctx context.Context) ([]entity.Category, error) {
+	var categories []entity.Category
+	if err := r.db.WithContext(ctx).Order("parent_id NULLS FIRST, name").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// CreateCategory создает новую категорию
+func (r *CatalogRepo) CreateCategory(ctx context.Context, category *entity.Category) error {
+	return r.db.WithContext(ctx).Create(category).Error
+}
+
+// UpdateProduct изменяет карточку товара: описание и категорию из updates,
+// а при images != nil заменяет список изображений товара
+func (r *CatalogRepo) UpdateProduct(ctx context.Context, itemID uint, updates map[string]interface{}, images *[]string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			if err := tx.Model(&entity.WarehouseItem{}).Where("id = ?", itemID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		if images == nil {
+			return nil
+		}
+
+		if err := tx.Where("warehouse_item_id = ?", itemID).Delete(&entity.ProductImage{}).Error; err != nil {
+			return err
+		}
+		for position, url := range *images {
+			image := &entity.ProductImage{
+				WarehouseItemID: itemID,
+				URL:             url,
+				Position:        position,
+			}
+			if err := tx.Create(image).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,188 @@
+package repo
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/director74/dz8_shop/pkg/money"
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+)
+
+// newMockDB создает GORM поверх sqlmock с диалектом PostgreSQL
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("не удалось создать sqlmock: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("не удалось открыть gorm: %v", err)
+	}
+	return db, mock
+}
+
+func catalogRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "product_id", "name", "price", "available", "status"}).
+		AddRow(8, 108, "Чайник", "250.00", 3, entity.WarehouseStatusAvailable)
+}
+
+func uintPtr(v uint) *uint {
+	return &v
+}
+
+func amountPtr(v money.Amount) *money.Amount {
+	return &v
+}
+
+// TestFindProducts тестирует построение запроса каталога по фильтру
+func TestFindProducts(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter entity.CatalogFilter
+		sql    string
+		args   []driver.Value
+	}{
+		{
+			name:   "без фильтров по порядку добавления",
+			filter: entity.CatalogFilter{Sort: entity.CatalogSortDefault, Limit: 21},
+			sql:    `SELECT * FROM "warehouse_items" ORDER BY id ASC LIMIT $1`,
+			args:   []driver.Value{21},
+		},
+		{
+			name:   "полнотекстовый поиск",
+			filter: entity.CatalogFilter{Query: "электрический чайник", Sort: entity.CatalogSortDefault, Limit: 21},
+			sql: `SELECT * FROM "warehouse_items" WHERE ` + catalogSearchVector +
+				` @@ websearch_to_tsquery('russian', $1) ORDER BY id ASC LIMIT $2`,
+			args: []driver.Value{"электрический чайник", 21},
+		},
+		{
+			name:   "категория вместе с подкатегориями",
+			filter: entity.CatalogFilter{CategoryID: uintPtr(3), Sort: entity.CatalogSortDefault, Limit: 21},
+			sql:    `SELECT * FROM "warehouse_items" WHERE category_id IN (WITH RECURSIVE category_tree AS (`,
+			args:   []driver.Value{3, 21},
+		},
+		{
+			name: "диапазон цен и наличие",
+			filter: entity.CatalogFilter{
+				MinPrice: amountPtr(10000),
+				MaxPrice: amountPtr(500000),
+				InStock:  true,
+				Sort:     entity.CatalogSortDefault,
+				Limit:    21,
+			},
+			sql:  `SELECT * FROM "warehouse_items" WHERE price >= $1 AND price <= $2 AND (available > 0 AND status = $3) ORDER BY id ASC LIMIT $4`,
+			args: []driver.Value{"100.00", "5000.00", entity.WarehouseStatusAvailable, 21},
+		},
+		{
+			name:   "курсор по порядку добавления",
+			filter: entity.CatalogFilter{Sort: entity.CatalogSortDefault, After: &entity.CatalogCursor{ID: 7}, Limit: 21},
+			sql:    `SELECT * FROM "warehouse_items" WHERE id > $1 ORDER BY id ASC LIMIT $2`,
+			args:   []driver.Value{7, 21},
+		},
+		{
+			name: "курсор по возрастанию цены",
+			filter: entity.CatalogFilter{
+				Sort:  entity.CatalogSortPriceAsc,
+				After: &entity.CatalogCursor{Sort: entity.CatalogSortPriceAsc, Price: 25000, ID: 7},
+				Limit: 21,
+			},
+			sql:  `SELECT * FROM "warehouse_items" WHERE price > $1 OR (price = $2 AND id > $3) ORDER BY price ASC, id ASC LIMIT $4`,
+			args: []driver.Value{"250.00", "250.00", 7, 21},
+		},
+		{
+			name: "курсор по убыванию цены",
+			filter: entity.CatalogFilter{
+				Sort:  entity.CatalogSortPriceDesc,
+				After: &entity.CatalogCursor{Sort: entity.CatalogSortPriceDesc, Price: 25000, ID: 7},
+				Limit: 21,
+			},
+			sql:  `SELECT * FROM "warehouse_items" WHERE price < $1 OR (price = $2 AND id > $3) ORDER BY price DESC, id ASC LIMIT $4`,
+			args: []driver.Value{"250.00", "250.00", 7, 21},
+		},
+		{
+			name: "курсор вместе с фильтрами",
+			filter: entity.CatalogFilter{
+				MaxPrice: amountPtr(500000),
+				Sort:     entity.CatalogSortPriceAsc,
+				After:    &entity.CatalogCursor{Sort: entity.CatalogSortPriceAsc, Price: 25000, ID: 7},
+				Limit:    21,
+			},
+			sql:  `SELECT * FROM "warehouse_items" WHERE price <= $1 AND (price > $2 OR (price = $3 AND id > $4)) ORDER BY price ASC, id ASC LIMIT $5`,
+			args: []driver.Value{"5000.00", "250.00", "250.00", 7, 21},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(regexp.QuoteMeta(tt.sql)).WithArgs(tt.args...).WillReturnRows(catalogRows())
+
+			items, err := NewCatalogRepo(db).FindProducts(context.Background(), tt.filter)
+
+			assert.NoError(t, err)
+			if assert.Len(t, items, 1) {
+				assert.Equal(t, uint(8), items[0].ID)
+				assert.Equal(t, money.Amount(25000), items[0].Price)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// TestFindProducts_CategoryTree тестирует, что фильтр по категории включает все уровни подкатегорий
+func TestFindProducts_CategoryTree(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT c.id FROM categories c JOIN category_tree t ON c.parent_id = t.id`)).
+		WithArgs(3, 21).
+		WillReturnRows(catalogRows())
+
+	_, err := NewCatalogRepo(db).FindProducts(context.Background(), entity.CatalogFilter{
+		CategoryID: uintPtr(3),
+		Sort:       entity.CatalogSortDefault,
+		Limit:      21,
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestGetImages тестирует группировку изображений по товарам с сохранением порядка
+func TestGetImages(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "product_images" WHERE warehouse_item_id IN ($1,$2) ORDER BY warehouse_item_id, position, id`)).
+		WithArgs(8, 9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "warehouse_item_id", "url", "position"}).
+			AddRow(1, 8, "https://cdn/8-a.jpg", 0).
+			AddRow(2, 8, "https://cdn/8-b.jpg", 1).
+			AddRow(3, 9, "https://cdn/9-a.jpg", 0))
+
+	images, err := NewCatalogRepo(db).GetImages(context.Background(), []uint{8, 9})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[uint][]string{
+		8: {"https://cdn/8-a.jpg", "https://cdn/8-b.jpg"},
+		9: {"https://cdn/9-a.jpg"},
+	}, images)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestGetImages_Empty тестирует, что без товаров запрос к базе не выполняется
+func TestGetImages_Empty(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	images, err := NewCatalogRepo(db).GetImages(context.Background(), nil)
+
+	assert.NoError(t, err)
+	assert.Empty(t, images)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
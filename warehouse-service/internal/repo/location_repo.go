@@ -0,0 +1,179 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrWarehouseNotFound склад не найден (или не задан основной склад)
+var ErrWarehouseNotFound = errors.New("склад не найден")
+
+// ListWarehouses возвращает склады вместе с обслуживаемыми зонами доставки.
+// При activeOnly возвращаются только склады, участвующие в резервировании.
+func (r *WarehouseRepo) ListWarehouses(ctx context.Context, activeOnly bool) ([]entity.Warehouse, error) {
+	db := r.db.WithContext(ctx).Preload("Zones")
+	if activeOnly {
+		db = db.Where("active = ?", true)
+	}
+
+	var warehouses []entity.Warehouse
+	if err := db.Order("id").Find(&warehouses).Error; err != nil {
+		return nil, err
+	}
+	return warehouses, nil
+}
+
+// GetWarehouse получает склад по ID
+func (r *WarehouseRepo) GetWarehouse(ctx context.Context, id uint) (*entity.Warehouse, error) {
+	var warehouse entity.Warehouse
+	result := r.db.WithContext(ctx).Preload("Zones").First(&warehouse, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &warehouse, nil
+}
+
+// GetWarehouseByCode получает склад по коду
+func (r *WarehouseRepo) GetWarehouseByCode(ctx context.Context, code string) (*entity.Warehouse, error) {
+	var warehouse entity.Warehouse
+	result := r.db.WithContext(ctx).Where("code = ?", code).First(&warehouse)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &warehouse, nil
+}
+
+// SaveWarehouse создает или обновляет склад. Если склад отмечен основным, отметка снимается с остальных складов.
+func (r *WarehouseRepo) SaveWarehouse(ctx context.Context, warehouse *entity.Warehouse) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if warehouse.IsDefault {
+			if err := tx.Model(&entity.Warehouse{}).
+				Where("is_default = ? AND id <> ?", true, warehouse.ID).
+				Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+
+		// Поля перечислены явно, чтобы неактивный склад не получил значение active по умолчанию
+		if warehouse.ID == 0 {
+			return tx.Select("code", "name", "address", "active", "is_default", "created_at", "updated_at").Create(warehouse).Error
+		}
+		return tx.Model(warehouse).
+			Select("name", "address", "active", "is_default", "updated_at").
+			Updates(warehouse).Error
+	})
+}
+
+// SetWarehouseZones заменяет список зон доставки, которые обслуживает склад
+func (r *WarehouseRepo) SetWarehouseZones(ctx context.Context, warehouseID uint, zones []entity.WarehouseZone) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("warehouse_id = ?", warehouseID).Delete(&entity.WarehouseZone{}).Error; err != nil {
+			return err
+		}
+		if len(zones) == 0 {
+			return nil
+		}
+		return tx.Create(&zones).Error
+	})
+}
+
+// GetLocationStocks возвращает остатки товара productID по складам
+func (r *WarehouseRepo) GetLocationStocks(ctx context.Context, productID uint) ([]entity.LocationStock, error) {
+	var stocks []entity.LocationStock
+	if err := r.db.WithContext(ctx).Where("product_id = ?", productID).Order("warehouse_id").Find(&stocks).Error; err != nil {
+		return nil, err
+	}
+	return stocks, nil
+}
+
+// defaultWarehouseID возвращает ID основного склада
+func defaultWarehouseID(tx *gorm.DB) (uint, error) {
+	var warehouse entity.Warehouse
+	if err := tx.Where("is_default = ?", true).First(&warehouse).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, fmt.Errorf("%w: основной склад не задан", ErrWarehouseNotFound)
+		}
+		return 0, err
+	}
+	return warehouse.ID, nil
+}
+
+// lockLocationStock получает с блокировкой строки остаток товара item на складе warehouseID.
+// При create отсутствующий остаток создается нулевым (например, при первой приемке товара на склад).
+func lockLocationStock(tx *gorm.DB, warehouseID uint, item *entity.WarehouseItem, create bool) (*entity.LocationStock, error) {
+	var stock entity.LocationStock
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("warehouse_id = ? AND warehouse_item_id = ?", warehouseID, item.ID).
+		First(&stock).Error
+	if err == nil {
+		return &stock, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if !create {
+		return nil, fmt.Errorf("остаток товара с ID продукта %d на складе %d не найден", item.ProductID, warehouseID)
+	}
+
+	if err := tx.First(&entity.Warehouse{}, warehouseID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: ID %d", ErrWarehouseNotFound, warehouseID)
+		}
+		return nil, err
+	}
+
+	stock = entity.LocationStock{
+		WarehouseID:     warehouseID,
+		WarehouseItemID: item.ID,
+		ProductID:       item.ProductID,
+	}
+	if err := tx.Create(&stock).Error; err != nil {
+		return nil, err
+	}
+	return &stock, nil
+}
+
+// lockReservationStock получает с блокировкой строк товар резервации и его остаток на складе резервации.
+// Резервации, созданные до появления складов, относятся к основному складу.
+func lockReservationStock(tx *gorm.DB, reservation *entity.WarehouseReservation) (*entity.WarehouseItem, *entity.LocationStock, error) {
+	var item entity.WarehouseItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&item, reservation.WarehouseItemID).Error; err != nil {
+		return nil, nil, err
+	}
+
+	if reservation.WarehouseID == nil {
+		warehouseID, err := defaultWarehouseID(tx)
+		if err != nil {
+			return nil, nil, err
+		}
+		reservation.WarehouseID = &warehouseID
+	}
+
+	stock, err := lockLocationStock(tx, *reservation.WarehouseID, &item, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &item, stock, nil
+}
+
+// saveLocationStock сохраняет остаток и резерв товара на складе
+func saveLocationStock(tx *gorm.DB, stock *entity.LocationStock) error {
+	stock.UpdatedAt = time.Now()
+	if err := tx.Model(stock).Select("quantity", "reserved_quantity", "updated_at").Updates(stock).Error; err != nil {
+		return err
+	}
+	stock.Available = stock.Quantity - stock.ReservedQuantity
+	return nil
+}
@@ -0,0 +1,107 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SetReorderPolicy устанавливает точку дозаказа и страховой запас товара productID
+func (r *WarehouseRepo) SetReorderPolicy(ctx context.Context, productID uint, reorderPoint, safetyStock int64) (*entity.WarehouseItem, error) {
+	var item entity.WarehouseItem
+	if err := r.db.WithContext(ctx).Where("product_id = ?", productID).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: ID продукта %d", ErrWarehouseItemNotFound, productID)
+		}
+		return nil, err
+	}
+
+	item.ReorderPoint = reorderPoint
+	item.SafetyStock = safetyStock
+	if err := r.db.WithContext(ctx).Model(&item).Select("reorder_point", "safety_stock", "updated_at").Updates(&item).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// GetStockLevelCandidates возвращает до limit товаров с ID больше afterID, уровень остатка которых нужно проверить:
+// товары с заданной точкой дозаказа и товары, для которых ранее был зафиксирован низкий уровень остатка
+func (r *WarehouseRepo) GetStockLevelCandidates(ctx context.Context, afterID uint, limit int) ([]entity.WarehouseItem, error) {
+	var items []entity.WarehouseItem
+	err := r.db.WithContext(ctx).
+		Where("id > ? AND (reorder_point > 0 OR stock_level IN ?)", afterID, []entity.StockLevel{entity.StockLevelLow, entity.StockLevelCritical}).
+		Order("id").
+		Limit(limit).
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetLowStockItems возвращает товары, доступный остаток которых достиг точки дозаказа,
+// начиная с товаров с наименьшим запасом сверх страхового
+func (r *WarehouseRepo) GetLowStockItems(ctx context.Context) ([]entity.WarehouseItem, error) {
+	var items []entity.WarehouseItem
+	err := r.db.WithContext(ctx).
+		Where("reorder_point > 0 AND available <= reorder_point").
+		Order("available - safety_stock, id").
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// UpdateStockLevel пересчитывает уровень остатка товара itemID функцией level и сохраняет его, если он изменился.
+// Товар блокируется на время проверки. onChange вызывается в той же транзакции с товаром после изменения
+// и предыдущим уровнем (например, чтобы сохранить событие в outbox). Возвращает true, если уровень изменился.
+func (r *WarehouseRepo) UpdateStockLevel(ctx context.Context, itemID uint, now time.Time, level func(item *entity.WarehouseItem) entity.StockLevel, onChange func(tx *gorm.DB, item *entity.WarehouseItem, previous entity.StockLevel) error) (bool, error) {
+	tx := r.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var item entity.WarehouseItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&item, itemID).Error; err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	previous := item.StockLevel
+	if previous == "" {
+		previous = entity.StockLevelNormal
+	}
+	current := level(&item)
+	if current == previous {
+		tx.Rollback()
+		return false, nil
+	}
+
+	item.StockLevel = current
+	item.StockLevelAt = &now
+	if err := tx.Model(&item).Select("stock_level", "stock_level_at").Updates(&item).Error; err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if onChange != nil {
+		if err := onChange(tx, &item, previous); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+
+	return true, tx.Commit().Error
+}
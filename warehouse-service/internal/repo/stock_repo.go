@@ -0,0 +1,157 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrWarehouseItemNotFound товар с указанным ID продукта не найден на складе
+var ErrWarehouseItemNotFound = errors.New("товар не найден на складе")
+
+// ApplyStockMovement изменяет остаток товара productID на складе warehouseID функцией apply и записывает
+// изменение в журнал движения. Если warehouseID не задан, используется основной склад; отсутствующий остаток
+// на складе создается нулевым. Изменение остатка склада переносится в общий остаток товара.
+// Товар и остаток блокируются на время изменения, остаток до и после изменения заполняются в movement.
+// Если apply возвращает ошибку, ни остаток, ни журнал не изменяются.
+func (r *WarehouseRepo) ApplyStockMovement(ctx context.Context, productID, warehouseID uint, movement *entity.StockMovement, apply func(stock *entity.LocationStock) error) (*entity.WarehouseItem, *entity.LocationStock, error) {
+	tx := r.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, nil, tx.Error
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Получаем товар для обновления с блокировкой строки
+	var item entity.WarehouseItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("product_id = ?", productID).First(&item).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, fmt.Errorf("%w: ID продукта %d", ErrWarehouseItemNotFound, productID)
+		}
+		return nil, nil, err
+	}
+
+	if warehouseID == 0 {
+		defaultID, err := defaultWarehouseID(tx)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+		warehouseID = defaultID
+	}
+
+	stock, err := lockLocationStock(tx, warehouseID, &item, true)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	quantityBefore, reservedBefore := stock.Quantity, stock.ReservedQuantity
+	if err := apply(stock); err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	if err := saveLocationStock(tx, stock); err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	item.Quantity += stock.Quantity - quantityBefore
+	item.ReservedQuantity += stock.ReservedQuantity - reservedBefore
+	if err := saveStock(tx, &item); err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	if err := journalMovement(tx, movement, stock, quantityBefore, reservedBefore); err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, nil, err
+	}
+	return &item, stock, nil
+}
+
+// ListStockMovements возвращает страницу журнала движения товаров, начиная с последних записей
+func (r *WarehouseRepo) ListStockMovements(ctx context.Context, query *entity.StockMovementQuery) ([]entity.StockMovement, int64, error) {
+	db := r.db.WithContext(ctx).Model(&entity.StockMovement{})
+	if query.ProductID != 0 {
+		db = db.Where("product_id = ?", query.ProductID)
+	}
+	if query.WarehouseID != 0 {
+		db = db.Where("warehouse_id = ?", query.WarehouseID)
+	}
+	if query.Type != "" {
+		db = db.Where("type = ?", query.Type)
+	}
+	if query.OrderID != 0 {
+		db = db.Where("order_id = ?", query.OrderID)
+	}
+	if query.Actor != "" {
+		db = db.Where("actor = ?", query.Actor)
+	}
+	if query.From != nil {
+		db = db.Where("created_at >= ?", *query.From)
+	}
+	if query.To != nil {
+		db = db.Where("created_at < ?", *query.To)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var movements []entity.StockMovement
+	if err := db.Order("id DESC").Limit(query.Limit).Offset(query.Offset).Find(&movements).Error; err != nil {
+		return nil, 0, err
+	}
+	return movements, total, nil
+}
+
+// saveStock сохраняет остаток, резерв и последний заказ товара.
+// Поля перечислены явно, чтобы нулевой остаток или резерв тоже сохранялся; вычисляемое поле available не записывается.
+func saveStock(tx *gorm.DB, item *entity.WarehouseItem) error {
+	item.UpdatedAt = time.Now()
+	if err := tx.Model(item).Select("quantity", "reserved_quantity", "last_order_id", "updated_at").Updates(item).Error; err != nil {
+		return err
+	}
+	item.Available = item.Quantity - item.ReservedQuantity
+	return nil
+}
+
+// journalMovement дополняет движение складом, товаром и его остатком на складе до и после изменения
+// и сохраняет в журнал
+func journalMovement(tx *gorm.DB, movement *entity.StockMovement, stock *entity.LocationStock, quantityBefore, reservedBefore int64) error {
+	warehouseID := stock.WarehouseID
+	movement.ID = 0
+	movement.WarehouseID = &warehouseID
+	movement.WarehouseItemID = stock.WarehouseItemID
+	movement.ProductID = stock.ProductID
+	movement.QuantityBefore = quantityBefore
+	movement.QuantityAfter = stock.Quantity
+	movement.QuantityDelta = stock.Quantity - quantityBefore
+	movement.ReservedBefore = reservedBefore
+	movement.ReservedAfter = stock.ReservedQuantity
+	movement.ReservedDelta = stock.ReservedQuantity - reservedBefore
+	if movement.Actor == "" {
+		movement.Actor = entity.StockActorSystem
+	}
+	if err := tx.Create(movement).Error; err != nil {
+		return fmt.Errorf("ошибка записи в журнал движения товара: %w", err)
+	}
+	return nil
+}
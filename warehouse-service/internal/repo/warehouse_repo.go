@@ -8,8 +8,12 @@ import (
 
 	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrNoActiveReservations у заказа нет активных резерваций: они уже подтверждены, отменены или истекли
+var ErrNoActiveReservations = errors.New("активных резерваций не найдено")
+
 // WarehouseRepo репозиторий для работы со складом
 type WarehouseRepo struct {
 	db *gorm.DB
@@ -77,8 +81,11 @@ func (r *WarehouseRepo) DeleteWarehouseItem(id uint) error {
 	return r.db.Delete(&entity.WarehouseItem{}, id).Error
 }
 
-// ReserveWarehouseItem резервирует товар для заказа
-func (r *WarehouseRepo) ReserveWarehouseItem(ctx context.Context, orderID, productID uint, quantity int, expiresIn *time.Duration) (*entity.WarehouseReservation, error) {
+// ReserveOrderItems резервирует товары заказа по складам. plan получает заблокированные остатки товаров
+// productIDs на всех складах и возвращает распределение резервируемого количества по складам.
+// Для каждой части распределения создается резервация с указанием склада; резервы склада и общий резерв
+// товара увеличиваются в той же транзакции.
+func (r *WarehouseRepo) ReserveOrderItems(ctx context.Context, orderID uint, productIDs []uint, expiresIn *time.Duration, plan func(stocks []entity.LocationStock) ([]entity.StockAllocation, error)) ([]entity.WarehouseReservation, error) {
 	tx := r.db.WithContext(ctx).Begin()
 	if tx.Error != nil {
 		return nil, tx.Error
@@ -90,53 +97,114 @@ func (r *WarehouseRepo) ReserveWarehouseItem(ctx context.Context, orderID, produ
 		}
 	}()
 
-	// Получаем товар для обновления с блокировкой строки
-	var item entity.WarehouseItem
-	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("product_id = ?", productID).First(&item).Error; err != nil {
+	reservations, err := reserveOrderItems(tx, orderID, productIDs, expiresIn, plan)
+	if err != nil {
 		tx.Rollback()
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, fmt.Errorf("товар с ID продукта %d не найден", productID)
-		}
 		return nil, err
 	}
 
-	// Проверяем, достаточно ли товара
-	if item.Available < int64(quantity) {
-		tx.Rollback()
-		return nil, fmt.Errorf("недостаточно товара для резервации: запрошено %d, доступно %d", quantity, item.Available)
+	return reservations, tx.Commit().Error
+}
+
+// reserveOrderItems резервирует товары заказа по складам в транзакции tx (см. ReserveOrderItems)
+func reserveOrderItems(tx *gorm.DB, orderID uint, productIDs []uint, expiresIn *time.Duration, plan func(stocks []entity.LocationStock) ([]entity.StockAllocation, error)) ([]entity.WarehouseReservation, error) {
+	// Получаем товары для обновления с блокировкой строк. Строки блокируются в порядке ID,
+	// чтобы параллельные резервирования не блокировали друг друга взаимно
+	var items []entity.WarehouseItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("product_id IN ?", productIDs).Order("id").Find(&items).Error; err != nil {
+		return nil, err
 	}
 
-	// Обновляем количество зарезервированного товара
-	item.ReservedQuantity += int64(quantity)
-	item.UpdatedAt = time.Now()
+	itemsByProduct := make(map[uint]*entity.WarehouseItem, len(items))
+	itemIDs := make([]uint, 0, len(items))
+	for i := range items {
+		itemsByProduct[items[i].ProductID] = &items[i]
+		itemIDs = append(itemIDs, items[i].ID)
+	}
+	for _, productID := range productIDs {
+		if _, ok := itemsByProduct[productID]; !ok {
+			return nil, fmt.Errorf("товар с ID продукта %d не найден", productID)
+		}
+	}
 
-	// Обновляем товар, исключая поле available
-	if err := tx.Model(&item).Omit("available").Updates(item).Error; err != nil {
-		tx.Rollback()
+	// Остатки товаров по складам, также с блокировкой строк
+	var stocks []entity.LocationStock
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("warehouse_item_id IN ?", itemIDs).Order("id").Find(&stocks).Error; err != nil {
 		return nil, err
 	}
 
-	// Создаем запись о резервации
-	reservation := &entity.WarehouseReservation{
-		OrderID:         orderID,
-		WarehouseItemID: item.ID,
-		ProductID:       productID,
-		Quantity:        quantity,
-		ReservedAt:      time.Now(),
-		Status:          "active",
+	allocations, err := plan(stocks)
+	if err != nil {
+		return nil, err
 	}
 
-	// Устанавливаем время истечения резервации, если оно указано
-	if expiresIn != nil {
-		reservation.ReservationExpiry = time.Now().Add(*expiresIn)
+	stocksByLocation := make(map[[2]uint]*entity.LocationStock, len(stocks))
+	for i := range stocks {
+		stocksByLocation[[2]uint{stocks[i].WarehouseID, stocks[i].ProductID}] = &stocks[i]
 	}
 
-	if err := tx.Create(reservation).Error; err != nil {
-		tx.Rollback()
-		return nil, err
+	now := time.Now()
+	reservations := make([]entity.WarehouseReservation, 0, len(allocations))
+	for _, allocation := range allocations {
+		stock, ok := stocksByLocation[[2]uint{allocation.WarehouseID, allocation.ProductID}]
+		if !ok {
+			return nil, fmt.Errorf("товар с ID продукта %d отсутствует на складе %d", allocation.ProductID, allocation.WarehouseID)
+		}
+
+		// Проверяем, достаточно ли товара на складе
+		if stock.Quantity-stock.ReservedQuantity < int64(allocation.Quantity) {
+			return nil, fmt.Errorf("недостаточно товара для резервации на складе %d: запрошено %d, доступно %d",
+				allocation.WarehouseID, allocation.Quantity, stock.Quantity-stock.ReservedQuantity)
+		}
+
+		// Обновляем количество зарезервированного товара на складе
+		reservedBefore := stock.ReservedQuantity
+		stock.ReservedQuantity += int64(allocation.Quantity)
+		if err := saveLocationStock(tx, stock); err != nil {
+			return nil, err
+		}
+		itemsByProduct[allocation.ProductID].ReservedQuantity += int64(allocation.Quantity)
+
+		// Создаем запись о резервации
+		warehouseID := allocation.WarehouseID
+		reservation := entity.WarehouseReservation{
+			OrderID:         orderID,
+			WarehouseItemID: stock.WarehouseItemID,
+			ProductID:       allocation.ProductID,
+			Quantity:        allocation.Quantity,
+			WarehouseID:     &warehouseID,
+			ReservedAt:      now,
+			Status:          "active",
+		}
+
+		// Устанавливаем время истечения резервации, если оно указано
+		if expiresIn != nil {
+			reservation.ReservationExpiry = now.Add(*expiresIn)
+		}
+
+		if err := tx.Create(&reservation).Error; err != nil {
+			return nil, err
+		}
+
+		if err := journalMovement(tx, &entity.StockMovement{
+			Type:          entity.StockMovementReserve,
+			OrderID:       &orderID,
+			ReservationID: &reservation.ID,
+		}, stock, stock.Quantity, reservedBefore); err != nil {
+			return nil, err
+		}
+
+		reservations = append(reservations, reservation)
+	}
+
+	// Общий резерв товара равен сумме резервов по складам
+	for i := range items {
+		if err := saveStock(tx, &items[i]); err != nil {
+			return nil, err
+		}
 	}
 
-	return reservation, tx.Commit().Error
+	return reservations, nil
 }
 
 // ReleaseWarehouseItems освобождает резервацию товара
@@ -152,33 +220,36 @@ func (r *WarehouseRepo) ReleaseWarehouseItems(ctx context.Context, orderID uint)
 		}
 	}()
 
-	// Получаем все резервации для заказа
+	// Получаем все резервации для заказа с блокировкой, чтобы их не изменило истечение срока
 	var reservations []entity.WarehouseReservation
-	if err := tx.Where("order_id = ? AND status = ?", orderID, "active").Find(&reservations).Error; err != nil {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_id = ? AND status = ?", orderID, "active").Find(&reservations).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
 	if len(reservations) == 0 {
 		tx.Rollback()
-		return fmt.Errorf("активных резерваций для заказа %d не найдено", orderID)
+		return fmt.Errorf("%w для заказа %d", ErrNoActiveReservations, orderID)
 	}
 
 	// Обрабатываем каждую резервацию
 	for _, reservation := range reservations {
-		// Получаем товар для обновления с блокировкой строки
-		var item entity.WarehouseItem
-		if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&item, reservation.WarehouseItemID).Error; err != nil {
+		// Получаем товар и его остаток на складе резервации с блокировкой строк
+		item, stock, err := lockReservationStock(tx, &reservation)
+		if err != nil {
 			tx.Rollback()
 			return err
 		}
 
-		// Обновляем количество зарезервированного товара
+		// Обновляем количество зарезервированного товара на складе и общий резерв
+		reservedBefore := stock.ReservedQuantity
+		stock.ReservedQuantity -= int64(reservation.Quantity)
 		item.ReservedQuantity -= int64(reservation.Quantity)
-		item.UpdatedAt = time.Now()
-
-		// Обновляем товар, исключая поле available
-		if err := tx.Model(&item).Omit("available").Updates(item).Error; err != nil {
+		if err := saveLocationStock(tx, stock); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := saveStock(tx, item); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -189,6 +260,15 @@ func (r *WarehouseRepo) ReleaseWarehouseItems(ctx context.Context, orderID uint)
 			tx.Rollback()
 			return err
 		}
+
+		if err := journalMovement(tx, &entity.StockMovement{
+			Type:          entity.StockMovementRelease,
+			OrderID:       &orderID,
+			ReservationID: &reservation.ID,
+		}, stock, stock.Quantity, reservedBefore); err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 
 	return tx.Commit().Error
@@ -207,35 +287,39 @@ func (r *WarehouseRepo) ConfirmWarehouseItems(ctx context.Context, orderID uint)
 		}
 	}()
 
-	// Получаем все резервации для заказа
+	// Получаем все резервации для заказа с блокировкой, чтобы их не изменило истечение срока
 	var reservations []entity.WarehouseReservation
-	if err := tx.Where("order_id = ? AND status = ?", orderID, "active").Find(&reservations).Error; err != nil {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_id = ? AND status = ?", orderID, "active").Find(&reservations).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
 	if len(reservations) == 0 {
 		tx.Rollback()
-		return fmt.Errorf("активных резерваций для заказа %d не найдено", orderID)
+		return fmt.Errorf("%w для заказа %d", ErrNoActiveReservations, orderID)
 	}
 
 	// Обрабатываем каждую резервацию
 	for _, reservation := range reservations {
-		// Получаем товар для обновления с блокировкой строки
-		var item entity.WarehouseItem
-		if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&item, reservation.WarehouseItemID).Error; err != nil {
+		// Получаем товар и его остаток на складе резервации с блокировкой строк
+		item, stock, err := lockReservationStock(tx, &reservation)
+		if err != nil {
 			tx.Rollback()
 			return err
 		}
 
-		// Обновляем количество зарезервированного товара и общего количества
+		// Обновляем количество зарезервированного товара и общего количества на складе и в целом по товару
+		quantityBefore, reservedBefore := stock.Quantity, stock.ReservedQuantity
+		stock.ReservedQuantity -= int64(reservation.Quantity)
+		stock.Quantity -= int64(reservation.Quantity)
 		item.ReservedQuantity -= int64(reservation.Quantity)
 		item.Quantity -= int64(reservation.Quantity)
 		item.LastOrderID = &orderID
-		item.UpdatedAt = time.Now()
-
-		// Обновляем товар, исключая поле available
-		if err := tx.Model(&item).Omit("available").Updates(item).Error; err != nil {
+		if err := saveLocationStock(tx, stock); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := saveStock(tx, item); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -246,11 +330,115 @@ func (r *WarehouseRepo) ConfirmWarehouseItems(ctx context.Context, orderID uint)
 			tx.Rollback()
 			return err
 		}
+
+		if err := journalMovement(tx, &entity.StockMovement{
+			Type:          entity.StockMovementSale,
+			OrderID:       &orderID,
+			ReservationID: &reservation.ID,
+		}, stock, quantityBefore, reservedBefore); err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 
 	return tx.Commit().Error
 }
 
+// GetOrdersWithExpiredReservations возвращает до limit заказов, у которых есть активные резервации
+// с истекшим к моменту now сроком. Резервации без срока (ReservationExpiry не задан) не истекают.
+func (r *WarehouseRepo) GetOrdersWithExpiredReservations(ctx context.Context, now time.Time, limit int) ([]uint, error) {
+	var orderIDs []uint
+	err := r.db.WithContext(ctx).Model(&entity.WarehouseReservation{}).
+		Distinct("order_id").
+		Where("status = ? AND reservation_expiry > ? AND reservation_expiry <= ?", entity.ReservationStatusActive, time.Time{}, now).
+		Limit(limit).
+		Pluck("order_id", &orderIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return orderIDs, nil
+}
+
+// ExpireReservations переводит просроченные к моменту now активные резервации заказа в статус expired
+// и возвращает зарезервированное количество в доступный остаток. onExpired вызывается в той же транзакции
+// с истекшими резервациями (например, чтобы сохранить событие в outbox). Возвращает истекшие резервации;
+// пустой результат означает, что резервации уже подтверждены, отменены или истекли.
+func (r *WarehouseRepo) ExpireReservations(ctx context.Context, orderID uint, now time.Time, onExpired func(tx *gorm.DB, reservations []entity.WarehouseReservation) error) ([]entity.WarehouseReservation, error) {
+	tx := r.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Получаем просроченные резервации заказа с блокировкой, чтобы не пересечься с подтверждением или отменой
+	var reservations []entity.WarehouseReservation
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ? AND status = ? AND reservation_expiry > ? AND reservation_expiry <= ?", orderID, entity.ReservationStatusActive, time.Time{}, now).
+		Find(&reservations).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if len(reservations) == 0 {
+		tx.Rollback()
+		return nil, nil
+	}
+
+	for i := range reservations {
+		reservation := &reservations[i]
+
+		// Получаем товар и его остаток на складе резервации с блокировкой строк
+		item, stock, err := lockReservationStock(tx, reservation)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		// Возвращаем зарезервированное количество в доступный остаток склада
+		reservedBefore := stock.ReservedQuantity
+		stock.ReservedQuantity -= int64(reservation.Quantity)
+		item.ReservedQuantity -= int64(reservation.Quantity)
+		if err := saveLocationStock(tx, stock); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err := saveStock(tx, item); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		reservation.Status = entity.ReservationStatusExpired
+		if err := tx.Save(reservation).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		if err := journalMovement(tx, &entity.StockMovement{
+			Type:          entity.StockMovementExpire,
+			Reason:        "истек срок резервирования",
+			OrderID:       &orderID,
+			ReservationID: &reservation.ID,
+		}, stock, stock.Quantity, reservedBefore); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if onExpired != nil {
+		if err := onExpired(tx, reservations); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return reservations, tx.Commit().Error
+}
+
 // GetReservationsByOrderID получает все резервации для заказа
 func (r *WarehouseRepo) GetReservationsByOrderID(orderID uint) ([]entity.WarehouseReservation, error) {
 	var reservations []entity.WarehouseReservation
@@ -261,10 +449,21 @@ func (r *WarehouseRepo) GetReservationsByOrderID(orderID uint) ([]entity.Warehou
 	return reservations, nil
 }
 
-// CheckWarehouseAvailability проверяет наличие товара
+// CheckWarehouseAvailability проверяет наличие товара. Свободным считается доступный остаток за вычетом
+// количества, ожидаемого заказами в очереди под поступление: поступивший товар достается им в первую очередь.
+// Для недостающих товаров указывается, разрешен ли их заказ под поступление и ожидаемая дата поступления.
 func (r *WarehouseRepo) CheckWarehouseAvailability(items []entity.ReserveItem) (bool, []entity.UnavailableItem, error) {
 	var unavailableItems []entity.UnavailableItem
 
+	productIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	demand, err := pendingBackorderDemand(r.db, productIDs)
+	if err != nil {
+		return false, nil, err
+	}
+
 	// Проверяем каждый товар по отдельности
 	for _, item := range items {
 		var warehouseItem entity.WarehouseItem
@@ -282,13 +481,19 @@ func (r *WarehouseRepo) CheckWarehouseAvailability(items []entity.ReserveItem) (
 			return false, nil, result.Error
 		}
 
-		// Проверка наличия
-		if warehouseItem.Available < int64(item.Quantity) {
-			unavailableItems = append(unavailableItems, entity.UnavailableItem{
+		// Проверка наличия с учетом очереди заказов под поступление
+		available := max(warehouseItem.Available-demand[item.ProductID], 0)
+		if available < int64(item.Quantity) {
+			unavailableItem := entity.UnavailableItem{
 				ProductID:         item.ProductID,
 				RequestedQuantity: int64(item.Quantity),
-				AvailableQuantity: warehouseItem.Available,
-			})
+				AvailableQuantity: available,
+				Backorderable:     warehouseItem.Backorderable,
+			}
+			if warehouseItem.Backorderable {
+				unavailableItem.ExpectedAvailableAt = warehouseItem.ExpectedAvailableAt
+			}
+			unavailableItems = append(unavailableItems, unavailableItem)
 		}
 	}
 
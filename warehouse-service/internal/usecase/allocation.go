@@ -0,0 +1,182 @@
+package usecase
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+)
+
+// rankWarehouses упорядочивает склады по удаленности от зоны доставки zoneID: сначала склады,
+// обслуживающие зону, по возрастанию Distance, затем остальные, начиная с основного.
+// Склады с одинаковой удаленностью упорядочиваются по ID. Неактивные склады в ранжирование не попадают.
+func rankWarehouses(warehouses []entity.Warehouse, zoneID uint) []uint {
+	type rankedWarehouse struct {
+		id        uint
+		serves    bool
+		distance  uint
+		isDefault bool
+	}
+
+	ranked := make([]rankedWarehouse, 0, len(warehouses))
+	for _, warehouse := range warehouses {
+		if !warehouse.Active {
+			continue
+		}
+		candidate := rankedWarehouse{id: warehouse.ID, isDefault: warehouse.IsDefault}
+		if zoneID != 0 {
+			for _, zone := range warehouse.Zones {
+				if zone.ZoneID == zoneID {
+					candidate.serves = true
+					candidate.distance = zone.Distance
+					break
+				}
+			}
+		}
+		ranked = append(ranked, candidate)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.serves != b.serves {
+			return a.serves
+		}
+		if a.serves && a.distance != b.distance {
+			return a.distance < b.distance
+		}
+		if !a.serves && a.isDefault != b.isDefault {
+			return a.isDefault
+		}
+		return a.id < b.id
+	})
+
+	ids := make([]uint, 0, len(ranked))
+	for _, warehouse := range ranked {
+		ids = append(ids, warehouse.id)
+	}
+	return ids
+}
+
+// planAllocation распределяет товары заказа по складам ranking (упорядоченным от ближайшего к зоне доставки)
+// с учетом доступного остатка stocks:
+//   - если один склад может собрать весь заказ, все товары резервируются на ближайшем таком складе;
+//   - иначе каждый товар резервируется на ближайшем складе, где его достаточно;
+//   - товар, которого не хватает ни на одном складе целиком, делится между складами, начиная с ближайшего.
+//
+// Склады, отсутствующие в ranking (например, неактивные), не используются.
+func planAllocation(items []entity.ReserveItem, stocks []entity.LocationStock, ranking []uint) ([]entity.StockAllocation, error) {
+	// Объединяем повторяющиеся позиции заказа, сохраняя порядок товаров
+	var productIDs []uint
+	demand := make(map[uint]int64)
+	for _, item := range items {
+		if _, ok := demand[item.ProductID]; !ok {
+			productIDs = append(productIDs, item.ProductID)
+		}
+		demand[item.ProductID] += int64(item.Quantity)
+	}
+
+	available := make(map[[2]uint]int64, len(stocks))
+	for _, stock := range stocks {
+		available[[2]uint{stock.WarehouseID, stock.ProductID}] = stock.Quantity - stock.ReservedQuantity
+	}
+
+	// Ближайший склад, на котором есть весь заказ
+	for _, warehouseID := range ranking {
+		complete := true
+		for _, productID := range productIDs {
+			if available[[2]uint{warehouseID, productID}] < demand[productID] {
+				complete = false
+				break
+			}
+		}
+		if !complete {
+			continue
+		}
+
+		allocations := make([]entity.StockAllocation, 0, len(productIDs))
+		for _, productID := range productIDs {
+			allocations = append(allocations, entity.StockAllocation{
+				ProductID:   productID,
+				WarehouseID: warehouseID,
+				Quantity:    int(demand[productID]),
+			})
+		}
+		return allocations, nil
+	}
+
+	var allocations []entity.StockAllocation
+	for _, productID := range productIDs {
+		needed := demand[productID]
+
+		// Ближайший склад, на котором товара достаточно целиком
+		placed := false
+		for _, warehouseID := range ranking {
+			key := [2]uint{warehouseID, productID}
+			if available[key] >= needed {
+				available[key] -= needed
+				allocations = append(allocations, entity.StockAllocation{
+					ProductID:   productID,
+					WarehouseID: warehouseID,
+					Quantity:    int(needed),
+				})
+				placed = true
+				break
+			}
+		}
+		if placed {
+			continue
+		}
+
+		// Делим товар между складами, начиная с ближайшего
+		remaining := needed
+		var split []entity.StockAllocation
+		for _, warehouseID := range ranking {
+			key := [2]uint{warehouseID, productID}
+			if available[key] <= 0 {
+				continue
+			}
+			quantity := min(available[key], remaining)
+			available[key] -= quantity
+			remaining -= quantity
+			split = append(split, entity.StockAllocation{
+				ProductID:   productID,
+				WarehouseID: warehouseID,
+				Quantity:    int(quantity),
+			})
+			if remaining == 0 {
+				break
+			}
+		}
+		if remaining > 0 {
+			return nil, fmt.Errorf("%w: товар с ID продукта %d на складах: запрошено %d, доступно %d",
+				ErrInsufficientStock, productID, needed, needed-remaining)
+		}
+		allocations = append(allocations, split...)
+	}
+
+	return allocations, nil
+}
+
+// reservedItemInfo информация о зарезервированном товаре с указанием склада
+func reservedItemInfo(reservation entity.WarehouseReservation) entity.ReservedItemInfo {
+	info := entity.ReservedItemInfo{
+		ProductID:  reservation.ProductID,
+		Quantity:   reservation.Quantity,
+		ReservedID: reservation.ID,
+	}
+	if reservation.WarehouseID != nil {
+		info.WarehouseID = *reservation.WarehouseID
+	}
+	return info
+}
+
+// describeAllocation описывает распределение резерваций по складам для журнала сервиса
+func describeAllocation(reservations []entity.WarehouseReservation) string {
+	parts := make([]string, 0, len(reservations))
+	for _, reservation := range reservations {
+		info := reservedItemInfo(reservation)
+		parts = append(parts, fmt.Sprintf("товар %d x%d на складе %d", info.ProductID, info.Quantity, info.WarehouseID))
+	}
+	return strings.Join(parts, ", ")
+}
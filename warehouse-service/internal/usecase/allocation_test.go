@@ -0,0 +1,163 @@
+package usecase
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+)
+
+// testWarehouses склады 1 (основной), 2 и 3: зону 10 обслуживают склады 3 (ближе) и 2, склад 4 неактивен
+func testWarehouses() []entity.Warehouse {
+	return []entity.Warehouse{
+		{ID: 1, Active: true, IsDefault: true},
+		{ID: 2, Active: true, Zones: []entity.WarehouseZone{{WarehouseID: 2, ZoneID: 10, Distance: 20}}},
+		{ID: 3, Active: true, Zones: []entity.WarehouseZone{{WarehouseID: 3, ZoneID: 10, Distance: 5}, {WarehouseID: 3, ZoneID: 11, Distance: 1}}},
+		{ID: 4, Active: false, Zones: []entity.WarehouseZone{{WarehouseID: 4, ZoneID: 10, Distance: 1}}},
+	}
+}
+
+// TestRankWarehouses тестирует упорядочивание складов по удаленности от зоны доставки
+func TestRankWarehouses(t *testing.T) {
+	tests := []struct {
+		name   string
+		zoneID uint
+		want   []uint
+	}{
+		{name: "склады зоны по удаленности, затем основной", zoneID: 10, want: []uint{3, 2, 1}},
+		{name: "зону обслуживает один склад", zoneID: 11, want: []uint{3, 1, 2}},
+		{name: "зона не указана", zoneID: 0, want: []uint{1, 2, 3}},
+		{name: "зону не обслуживает ни один склад", zoneID: 99, want: []uint{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, rankWarehouses(testWarehouses(), tt.zoneID))
+		})
+	}
+}
+
+// TestRankWarehouses_EqualDistance тестирует упорядочивание равноудаленных складов по ID
+func TestRankWarehouses_EqualDistance(t *testing.T) {
+	warehouses := []entity.Warehouse{
+		{ID: 7, Active: true, Zones: []entity.WarehouseZone{{ZoneID: 10, Distance: 3}}},
+		{ID: 5, Active: true, Zones: []entity.WarehouseZone{{ZoneID: 10, Distance: 3}}},
+	}
+
+	assert.Equal(t, []uint{5, 7}, rankWarehouses(warehouses, 10))
+}
+
+// locationStock остаток товара productID на складе warehouseID
+func locationStock(warehouseID, productID uint, quantity, reserved int64) entity.LocationStock {
+	return entity.LocationStock{WarehouseID: warehouseID, ProductID: productID, Quantity: quantity, ReservedQuantity: reserved}
+}
+
+// TestPlanAllocation тестирует распределение товаров заказа по складам
+func TestPlanAllocation(t *testing.T) {
+	ranking := []uint{3, 2, 1}
+
+	tests := []struct {
+		name   string
+		items  []entity.ReserveItem
+		stocks []entity.LocationStock
+		want   []entity.StockAllocation
+	}{
+		{
+			name:  "весь заказ на ближайшем складе, где он есть целиком",
+			items: []entity.ReserveItem{{ProductID: 100, Quantity: 2}, {ProductID: 200, Quantity: 1}},
+			stocks: []entity.LocationStock{
+				locationStock(3, 100, 5, 0),
+				locationStock(2, 100, 5, 0),
+				locationStock(2, 200, 5, 0),
+				locationStock(1, 100, 5, 0),
+				locationStock(1, 200, 5, 0),
+			},
+			want: []entity.StockAllocation{
+				{ProductID: 100, WarehouseID: 2, Quantity: 2},
+				{ProductID: 200, WarehouseID: 2, Quantity: 1},
+			},
+		},
+		{
+			name:  "повторяющиеся позиции объединяются",
+			items: []entity.ReserveItem{{ProductID: 100, Quantity: 2}, {ProductID: 100, Quantity: 3}},
+			stocks: []entity.LocationStock{
+				locationStock(3, 100, 4, 0),
+				locationStock(1, 100, 5, 0),
+			},
+			want: []entity.StockAllocation{{ProductID: 100, WarehouseID: 1, Quantity: 5}},
+		},
+		{
+			name:  "каждый товар на ближайшем складе, где его достаточно",
+			items: []entity.ReserveItem{{ProductID: 100, Quantity: 2}, {ProductID: 200, Quantity: 3}},
+			stocks: []entity.LocationStock{
+				locationStock(3, 100, 2, 0),
+				locationStock(2, 200, 3, 0),
+				locationStock(1, 100, 9, 0),
+			},
+			want: []entity.StockAllocation{
+				{ProductID: 100, WarehouseID: 3, Quantity: 2},
+				{ProductID: 200, WarehouseID: 2, Quantity: 3},
+			},
+		},
+		{
+			name:  "товар делится между складами, начиная с ближайшего",
+			items: []entity.ReserveItem{{ProductID: 100, Quantity: 6}},
+			stocks: []entity.LocationStock{
+				locationStock(3, 100, 5, 2),
+				locationStock(2, 100, 2, 0),
+				locationStock(1, 100, 9, 8),
+			},
+			want: []entity.StockAllocation{
+				{ProductID: 100, WarehouseID: 3, Quantity: 3},
+				{ProductID: 100, WarehouseID: 2, Quantity: 2},
+				{ProductID: 100, WarehouseID: 1, Quantity: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocations, err := planAllocation(tt.items, tt.stocks, ranking)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, allocations)
+		})
+	}
+}
+
+// TestPlanAllocation_InsufficientStock тестирует отказ, когда товара не хватает на всех складах вместе
+func TestPlanAllocation_InsufficientStock(t *testing.T) {
+	stocks := []entity.LocationStock{
+		locationStock(3, 100, 5, 0),
+		locationStock(2, 100, 4, 2),
+		locationStock(1, 200, 3, 0),
+	}
+
+	allocations, err := planAllocation([]entity.ReserveItem{{ProductID: 200, Quantity: 1}, {ProductID: 100, Quantity: 8}}, stocks, []uint{3, 2, 1})
+
+	assert.ErrorIs(t, err, ErrInsufficientStock)
+	assert.Nil(t, allocations)
+}
+
+// TestPlanAllocation_InactiveWarehouse тестирует, что остаток неактивного склада не резервируется
+func TestPlanAllocation_InactiveWarehouse(t *testing.T) {
+	ranking := rankWarehouses(testWarehouses(), 10)
+	stocks := []entity.LocationStock{
+		locationStock(4, 100, 10, 0),
+		locationStock(3, 100, 1, 0),
+		locationStock(1, 100, 1, 0),
+	}
+
+	assert.NotContains(t, ranking, uint(4))
+
+	allocations, err := planAllocation([]entity.ReserveItem{{ProductID: 100, Quantity: 2}}, stocks, ranking)
+	assert.NoError(t, err)
+	assert.Equal(t, []entity.StockAllocation{
+		{ProductID: 100, WarehouseID: 3, Quantity: 1},
+		{ProductID: 100, WarehouseID: 1, Quantity: 1},
+	}, allocations)
+
+	_, err = planAllocation([]entity.ReserveItem{{ProductID: 100, Quantity: 3}}, stocks, ranking)
+	assert.ErrorIs(t, err, ErrInsufficientStock)
+}
@@ -0,0 +1,458 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/director74/dz8_shop/pkg/messaging"
+	"github.com/director74/dz8_shop/pkg/sagahandler"
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"github.com/director74/dz8_shop/warehouse-service/internal/repo"
+	"gorm.io/gorm"
+)
+
+const (
+	// backorderSagaExchange exchange, в который публикуется результат шага саги после резервирования заказа из очереди
+	backorderSagaExchange = "saga_exchange"
+	// backorderSagaStep шаг саги, результат которого откладывается до поступления товара
+	backorderSagaStep = "reserve_warehouse"
+	// backorderBatchSize количество заказов из очереди, обрабатываемых за один запрос к базе данных
+	backorderBatchSize = 100
+	// defaultBackordersLimit размер страницы очереди заказов по умолчанию
+	defaultBackordersLimit = 50
+	// maxBackordersLimit максимальный размер страницы очереди заказов
+	maxBackordersLimit = 500
+)
+
+// ReserveOrBackorder резервирует товары заказа саги sagaID. Если свободного остатка не хватает, но все
+// недостающие товары можно заказать под поступление, заказ ставится в очередь: sagaData сохраняется,
+// чтобы опубликовать результат шага reserve_warehouse, когда товар поступит, а сага получает событие
+// warehouse.backorder.scheduled с ожидаемой датой поступления. Повторный вызов для заказа, который уже
+// ждет поступления, возвращает тот же ответ.
+func (u *WarehouseUseCase) ReserveOrBackorder(ctx context.Context, req *entity.ReserveWarehouseRequest, sagaID string, sagaData []byte) (*entity.WarehouseResponse, error) {
+	existing, err := u.repo.GetBackorderByOrderID(ctx, req.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		switch existing.Status {
+		case entity.BackorderStatusPending:
+			return backorderedResponse(existing), nil
+		case entity.BackorderStatusAllocated:
+			return u.allocatedBackorderResponse(existing)
+		}
+	}
+
+	availability, err := u.CheckWarehouseAvailability(&entity.CheckWarehouseRequest{Items: req.Items})
+	if err != nil {
+		return nil, err
+	}
+	if availability.Available || !availability.Backorderable || existing != nil {
+		return u.ReserveWarehouseItems(ctx, req)
+	}
+
+	available := make(map[uint]int64, len(availability.UnavailableItems))
+	for _, item := range availability.UnavailableItems {
+		available[item.ProductID] = item.AvailableQuantity
+	}
+
+	backorder := &entity.Backorder{
+		OrderID:             req.OrderID,
+		UserID:              req.UserID,
+		SagaID:              sagaID,
+		ZoneID:              req.ZoneID,
+		Status:              entity.BackorderStatusPending,
+		ExpectedAvailableAt: availability.ExpectedAvailableAt,
+		SagaData:            sagaData,
+		Items:               make([]entity.BackorderItem, 0, len(req.Items)),
+	}
+	for _, item := range req.Items {
+		backorderItem := entity.BackorderItem{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+		}
+		if free, ok := available[item.ProductID]; ok {
+			backorderItem.Shortage = int64(item.Quantity) - free
+		}
+		backorder.Items = append(backorder.Items, backorderItem)
+	}
+
+	err = u.repo.CreateBackorder(ctx, backorder, func(tx *gorm.DB) error {
+		return u.publishBackorderScheduled(u.txPublisher(tx), backorder)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка постановки заказа %d в очередь под поступление: %w", req.OrderID, err)
+	}
+
+	log.Printf("Заказ %d поставлен в очередь под поступление товара, ожидаемая дата: %s", req.OrderID, describeExpectedDate(backorder.ExpectedAvailableAt))
+	return backorderedResponse(backorder), nil
+}
+
+// AllocateBackorders резервирует поступивший товар под заказы из очереди в порядке постановки в очередь.
+// Очередь соблюдается для каждого товара: если более раннему заказу товара не хватает, более поздние
+// заказы с этим товаром не резервируются, даже если им бы хватило. Проход выполняется под блокировкой
+// очереди в базе данных, поэтому проходы разных экземпляров сервиса не нарушают порядок. После резервирования
+// сага заказа получает результат шага reserve_warehouse. Возвращает количество зарезервированных и оставшихся
+// в очереди заказов.
+func (u *WarehouseUseCase) AllocateBackorders(ctx context.Context) (int, int, error) {
+	// Проходы по очереди всех экземпляров сервиса выполняются по одному, чтобы не нарушить ее порядок
+	unlock, err := u.repo.LockBackorderQueue(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+
+	warehouses, err := u.repo.ListWarehouses(ctx, true)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	expiry := u.reservationTTL
+	blocked := make(map[uint]bool)
+	allocated, pending := 0, 0
+
+	var afterID uint
+	for {
+		backorders, err := u.repo.GetPendingBackorders(ctx, afterID, backorderBatchSize)
+		if err != nil {
+			return allocated, pending, fmt.Errorf("ошибка получения очереди заказов под поступление: %w", err)
+		}
+
+		for i := range backorders {
+			backorder := &backorders[i]
+			afterID = backorder.ID
+
+			if backorderBlocked(backorder, blocked) {
+				blockBackorderProducts(backorder, blocked)
+				pending++
+				continue
+			}
+
+			ranking := rankWarehouses(warehouses, backorder.ZoneID)
+			reservations, err := u.repo.AllocateBackorder(ctx, backorder.ID, &expiry,
+				func(locked *entity.Backorder, stocks []entity.LocationStock) ([]entity.StockAllocation, error) {
+					return planAllocation(backorderReserveItems(locked), stocks, ranking)
+				},
+				func(tx *gorm.DB, locked *entity.Backorder, _ []entity.WarehouseReservation) error {
+					return u.publishBackorderAllocated(u.txPublisher(tx), locked)
+				})
+			if err != nil {
+				if errors.Is(err, repo.ErrBackorderNotPending) {
+					// Заказ отменен, пока выполнялся проход по очереди
+					continue
+				}
+				if !errors.Is(err, ErrInsufficientStock) {
+					log.Printf("Ошибка резервирования заказа %d из очереди под поступление: %v", backorder.OrderID, err)
+				}
+				blockBackorderProducts(backorder, blocked)
+				pending++
+				continue
+			}
+
+			log.Printf("Заказ %d из очереди под поступление зарезервирован: %s", backorder.OrderID, describeAllocation(reservations))
+			allocated++
+		}
+
+		if len(backorders) < backorderBatchSize {
+			return allocated, pending, nil
+		}
+	}
+}
+
+// RunBackorderAllocation периодически резервирует поступивший товар под заказы из очереди.
+// Блокирует вызывающую горутину до отмены ctx.
+func (u *WarehouseUseCase) RunBackorderAllocation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Распределение товара по заказам под поступление запущено (интервал %s)", interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Распределение товара по заказам под поступление остановлено")
+			return
+		case <-ticker.C:
+			if _, _, err := u.AllocateBackorders(ctx); err != nil {
+				log.Printf("Ошибка распределения товара по заказам под поступление: %v", err)
+			}
+		}
+	}
+}
+
+// CancelBackorder убирает заказ из очереди под поступление при компенсации саги.
+// Если товар уже был зарезервирован под заказ, резервирование снимается. Возвращает false,
+// если заказа не было в очереди.
+func (u *WarehouseUseCase) CancelBackorder(ctx context.Context, orderID uint) (bool, error) {
+	previous, err := u.repo.CancelBackorder(ctx, orderID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка отмены заказа %d в очереди под поступление: %w", orderID, err)
+	}
+	if previous == "" {
+		return false, nil
+	}
+
+	if previous == entity.BackorderStatusAllocated {
+		err := u.repo.ReleaseWarehouseItems(ctx, orderID)
+		if err != nil && !errors.Is(err, repo.ErrNoActiveReservations) {
+			return true, fmt.Errorf("ошибка снятия резервирования заказа %d из очереди под поступление: %w", orderID, err)
+		}
+	}
+
+	log.Printf("Заказ %d убран из очереди под поступление (статус до отмены: %s)", orderID, previous)
+	return true, nil
+}
+
+// SetBackorderPolicy разрешает или запрещает заказ товара под поступление. Если изменилась ожидаемая дата
+// поступления, она переносится на заказы в очереди с этим товаром, и их саги получают событие
+// warehouse.backorder.scheduled. Запрет не затрагивает заказы, уже стоящие в очереди.
+func (u *WarehouseUseCase) SetBackorderPolicy(ctx context.Context, productID uint, req *entity.SetBackorderPolicyRequest) (*entity.BackorderPolicyResponse, error) {
+	if req.Backorderable == nil {
+		return nil, fmt.Errorf("%w: не указано, разрешен ли заказ под поступление", ErrInvalidStockOperation)
+	}
+
+	item, err := u.repo.SetBackorderPolicy(ctx, productID, *req.Backorderable, req.ExpectedAvailableAt)
+	if err != nil {
+		if errors.Is(err, repo.ErrWarehouseItemNotFound) {
+			return nil, fmt.Errorf("%w: %v", ErrProductNotFound, err)
+		}
+		return nil, err
+	}
+
+	log.Printf("Заказ товара ProductID=%d под поступление: разрешен=%t, ожидаемая дата: %s",
+		productID, item.Backorderable, describeExpectedDate(item.ExpectedAvailableAt))
+
+	backorders, err := u.repo.GetPendingBackordersByProduct(ctx, productID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения очереди заказов товара %d: %w", productID, err)
+	}
+
+	response := &entity.BackorderPolicyResponse{
+		ProductID:           item.ProductID,
+		Backorderable:       item.Backorderable,
+		ExpectedAvailableAt: item.ExpectedAvailableAt,
+		Available:           item.Available,
+	}
+	for _, backorder := range backorders {
+		for _, backorderItem := range backorder.Items {
+			if backorderItem.ProductID == productID {
+				response.PendingQuantity += int64(backorderItem.Quantity)
+			}
+		}
+
+		expectedAt, err := u.backorderExpectedDate(ctx, &backorder)
+		if err != nil {
+			log.Printf("Ошибка расчета ожидаемой даты поступления товаров заказа %d: %v", backorder.OrderID, err)
+			continue
+		}
+		changed, err := u.repo.RescheduleBackorder(ctx, backorder.ID, expectedAt, func(tx *gorm.DB, locked *entity.Backorder) error {
+			return u.publishBackorderScheduled(u.txPublisher(tx), locked)
+		})
+		if err != nil {
+			log.Printf("Ошибка переноса ожидаемой даты поступления товаров заказа %d: %v", backorder.OrderID, err)
+			continue
+		}
+		if changed {
+			log.Printf("Ожидаемая дата поступления товаров заказа %d перенесена: %s", backorder.OrderID, describeExpectedDate(expectedAt))
+			response.RescheduledOrders++
+		}
+	}
+
+	return response, nil
+}
+
+// ListBackorders возвращает страницу очереди заказов под поступление
+func (u *WarehouseUseCase) ListBackorders(ctx context.Context, query *entity.BackorderQuery) (*entity.ListBackordersResponse, error) {
+	switch query.Status {
+	case "", entity.BackorderStatusPending, entity.BackorderStatusAllocated, entity.BackorderStatusCancelled:
+	default:
+		return nil, fmt.Errorf("%w: неизвестный статус заказа под поступление %q", ErrInvalidStockOperation, query.Status)
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultBackordersLimit
+	}
+	if query.Limit > maxBackordersLimit {
+		query.Limit = maxBackordersLimit
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	backorders, total, err := u.repo.ListBackorders(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &entity.ListBackordersResponse{
+		Backorders: backorders,
+		Total:      total,
+	}, nil
+}
+
+// allocatedBackorderResponse ответ на резервирование для заказа из очереди, товары которого уже зарезервированы
+func (u *WarehouseUseCase) allocatedBackorderResponse(backorder *entity.Backorder) (*entity.WarehouseResponse, error) {
+	reservations, err := u.repo.GetReservationsByOrderID(backorder.OrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &entity.WarehouseResponse{
+		Success: true,
+		Message: "Товары успешно зарезервированы",
+		OrderID: backorder.OrderID,
+	}
+	for _, reservation := range reservations {
+		if reservation.Status == entity.ReservationStatusActive {
+			response.ReservedItems = append(response.ReservedItems, reservedItemInfo(reservation))
+		}
+	}
+	return response, nil
+}
+
+// backorderExpectedDate рассчитывает ожидаемую дату поступления всех товаров заказа из очереди
+// по текущим датам поступления товаров
+func (u *WarehouseUseCase) backorderExpectedDate(ctx context.Context, backorder *entity.Backorder) (*time.Time, error) {
+	var latest *time.Time
+	for _, backorderItem := range backorder.Items {
+		if backorderItem.Shortage <= 0 {
+			continue
+		}
+		item, err := u.repo.GetWarehouseItemByProductID(backorderItem.ProductID)
+		if err != nil {
+			return nil, err
+		}
+		if item == nil || item.ExpectedAvailableAt == nil {
+			return nil, nil
+		}
+		if latest == nil || item.ExpectedAvailableAt.After(*latest) {
+			latest = item.ExpectedAvailableAt
+		}
+	}
+	return latest, nil
+}
+
+// publishBackorderScheduled публикует событие о постановке заказа в очередь под поступление
+// или переносе ожидаемой даты поступления через publisher
+func (u *WarehouseUseCase) publishBackorderScheduled(publisher messaging.MessagePublisher, backorder *entity.Backorder) error {
+	message := entity.BackorderScheduledMessage{
+		OrderID:             backorder.OrderID,
+		SagaID:              backorder.SagaID,
+		Items:               backorder.Items,
+		ExpectedAvailableAt: backorder.ExpectedAvailableAt,
+		Timestamp:           time.Now().Unix(),
+	}
+
+	err := messaging.PublishWithRetryAndLogging(publisher, u.exchangeName, "warehouse.backorder.scheduled", message, 3)
+	if err != nil {
+		return fmt.Errorf("ошибка публикации события о заказе под поступление: %w", err)
+	}
+	return nil
+}
+
+// publishBackorderAllocated публикует через publisher результат шага reserve_warehouse саги заказа,
+// товары которого зарезервированы после поступления
+func (u *WarehouseUseCase) publishBackorderAllocated(publisher messaging.MessagePublisher, backorder *entity.Backorder) error {
+	if backorder.SagaID == "" {
+		return nil
+	}
+
+	var sagaData sagahandler.SagaData
+	if err := json.Unmarshal(backorder.SagaData, &sagaData); err != nil {
+		return fmt.Errorf("ошибка десериализации данных саги заказа %d: %w", backorder.OrderID, err)
+	}
+	if sagaData.WarehouseInfo == nil {
+		sagaData.WarehouseInfo = &sagahandler.WarehouseInfo{}
+	}
+	// ID резервации совпадает с ID заказа, как и при обычном резервировании в саге
+	sagaData.WarehouseInfo.ReservationID = fmt.Sprintf("%d", backorder.OrderID)
+	sagaData.Status = "warehouse_reserved"
+	if sagaData.CompensatedSteps == nil {
+		sagaData.CompensatedSteps = make(map[string]bool)
+	}
+
+	message, err := sagahandler.NewSagaMessage(backorder.SagaID, backorderSagaStep, sagahandler.OperationExecute, sagahandler.StatusCompleted, sagaData)
+	if err != nil {
+		return err
+	}
+
+	routingKey := fmt.Sprintf("saga.%s.result", backorderSagaStep)
+	if err := messaging.PublishWithRetryAndLogging(publisher, backorderSagaExchange, routingKey, message, 3); err != nil {
+		return fmt.Errorf("ошибка публикации результата шага %s: %w", backorderSagaStep, err)
+	}
+	return nil
+}
+
+// backorderTerms определяет по недостающим товарам, можно ли принять заказ под поступление,
+// и ожидаемую дату поступления всех товаров: самую позднюю из дат, или пустую, если какая-либо дата неизвестна
+func backorderTerms(unavailableItems []entity.UnavailableItem) (bool, *time.Time) {
+	var latest *time.Time
+	known := true
+	for _, item := range unavailableItems {
+		if !item.Backorderable {
+			return false, nil
+		}
+		if item.ExpectedAvailableAt == nil {
+			known = false
+			continue
+		}
+		if latest == nil || item.ExpectedAvailableAt.After(*latest) {
+			latest = item.ExpectedAvailableAt
+		}
+	}
+	if !known {
+		return true, nil
+	}
+	return true, latest
+}
+
+// backorderedResponse ответ на резервирование для заказа, поставленного в очередь под поступление
+func backorderedResponse(backorder *entity.Backorder) *entity.WarehouseResponse {
+	return &entity.WarehouseResponse{
+		Success:             true,
+		Message:             "Заказ поставлен в очередь под поступление товара",
+		OrderID:             backorder.OrderID,
+		Backordered:         true,
+		ExpectedAvailableAt: backorder.ExpectedAvailableAt,
+	}
+}
+
+// backorderReserveItems позиции заказа из очереди для распределения по складам
+func backorderReserveItems(backorder *entity.Backorder) []entity.ReserveItem {
+	items := make([]entity.ReserveItem, 0, len(backorder.Items))
+	for _, item := range backorder.Items {
+		items = append(items, entity.ReserveItem{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+		})
+	}
+	return items
+}
+
+// backorderBlocked проверяет, ждет ли заказ товар, который не удалось выделить более раннему заказу
+func backorderBlocked(backorder *entity.Backorder, blocked map[uint]bool) bool {
+	for _, item := range backorder.Items {
+		if blocked[item.ProductID] {
+			return true
+		}
+	}
+	return false
+}
+
+// blockBackorderProducts отмечает товары заказа, который остается в очереди: более поздние заказы
+// не должны получить их раньше него
+func blockBackorderProducts(backorder *entity.Backorder, blocked map[uint]bool) {
+	for _, item := range backorder.Items {
+		blocked[item.ProductID] = true
+	}
+}
+
+// describeExpectedDate описывает ожидаемую дату поступления для лога
+func describeExpectedDate(expectedAt *time.Time) string {
+	if expectedAt == nil {
+		return "неизвестна"
+	}
+	return expectedAt.Format(time.DateOnly)
+}
@@ -0,0 +1,236 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+)
+
+// expectBackorderQueueLocked ожидает захват блокировки очереди заказов под поступление
+// и выборку активного основного склада 1
+func expectBackorderQueueLocked(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`SELECT pg_advisory_lock\(\$1\)`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT \* FROM "warehouses" WHERE active = \$1 ORDER BY id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "active", "is_default"}).AddRow(1, true, true))
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_zones"`).
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_id", "zone_id", "distance"}))
+}
+
+// expectBackorderLocked ожидает начало транзакции резервирования заказа backorderID из очереди с позицией
+// productID x quantity и блокировку товара (его ID равен ID продукта) и его остатка available на складе 1
+func expectBackorderLocked(mock sqlmock.Sqlmock, backorderID, orderID, productID uint, quantity int, available int64) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_backorders" WHERE "warehouse_backorders"."id" = \$1 .*FOR UPDATE`).
+		WithArgs(backorderID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "saga_id", "status", "saga_data"}).
+			AddRow(backorderID, orderID, "saga-9", entity.BackorderStatusPending, []byte(`{"order_id":9}`)))
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_backorder_items" WHERE backorder_id = \$1`).
+		WithArgs(backorderID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "backorder_id", "product_id", "quantity"}).
+			AddRow(backorderID, backorderID, productID, quantity))
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_items" WHERE product_id IN \(\$1\) ORDER BY id FOR UPDATE`).
+		WithArgs(productID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "quantity", "reserved_quantity"}).
+			AddRow(productID, productID, available, 0))
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_stocks" WHERE warehouse_item_id IN \(\$1\) ORDER BY id FOR UPDATE`).
+		WithArgs(productID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "warehouse_id", "warehouse_item_id", "product_id", "quantity", "reserved_quantity"}).
+			AddRow(productID, 1, productID, productID, available, 0))
+}
+
+// TestAllocateBackorders_BlocksLaterOrdersOfProduct тестирует очередь по каждому товару: более поздний заказ
+// товара, которого не хватило более раннему заказу, не резервируется, даже если ему хватило бы остатка,
+// а заказ другого товара резервируется
+func TestAllocateBackorders_BlocksLaterOrdersOfProduct(t *testing.T) {
+	uc, mock := newTestWarehouseUseCase(t)
+	expectBackorderQueueLocked(mock)
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_backorders" WHERE id > \$1 AND status = \$2 ORDER BY id LIMIT \$3`).
+		WithArgs(0, entity.BackorderStatusPending, backorderBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "status"}).
+			AddRow(1, 7, entity.BackorderStatusPending).
+			AddRow(2, 8, entity.BackorderStatusPending).
+			AddRow(3, 9, entity.BackorderStatusPending))
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_backorder_items" WHERE "warehouse_backorder_items"."backorder_id" IN \(\$1,\$2,\$3\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "backorder_id", "product_id", "quantity"}).
+			AddRow(1, 1, 103, 5).
+			AddRow(2, 2, 103, 1).
+			AddRow(3, 3, 104, 1))
+
+	// Первому заказу товара 103 не хватает трех поступивших единиц
+	expectBackorderLocked(mock, 1, 7, 103, 5, 3)
+	mock.ExpectRollback()
+
+	// Второй заказ товара 103 пропускается без обращения к базе данных, заказ товара 104 резервируется
+	expectBackorderLocked(mock, 3, 9, 104, 1, 2)
+	mock.ExpectExec(`UPDATE "warehouse_stocks" SET`).
+		WithArgs(int64(2), int64(1), sqlmock.AnyArg(), uint(104)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "warehouse_reservations"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21))
+	mock.ExpectQuery(`INSERT INTO "stock_movements"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`UPDATE "warehouse_items" SET`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "warehouse_backorders" SET "status"=\$1,"allocated_at"=\$2,"updated_at"=\$3 WHERE "id" = \$4`).
+		WithArgs(entity.BackorderStatusAllocated, sqlmock.AnyArg(), sqlmock.AnyArg(), uint(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WithArgs(backorderSagaExchange, "saga.reserve_warehouse.result", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	allocated, pending, err := uc.AllocateBackorders(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, allocated)
+	assert.Equal(t, 2, pending)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestAllocateBackorders_LockFailure тестирует, что без блокировки очереди проход не выполняется
+func TestAllocateBackorders_LockFailure(t *testing.T) {
+	uc, mock := newTestWarehouseUseCase(t)
+	mock.ExpectExec(`SELECT pg_advisory_lock\(\$1\)`).WillReturnError(context.DeadlineExceeded)
+
+	allocated, pending, err := uc.AllocateBackorders(context.Background())
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Zero(t, allocated)
+	assert.Zero(t, pending)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestReserveOrBackorder_Repeated тестирует, что повторное резервирование заказа из очереди
+// возвращает тот же ответ и не ставит заказ в очередь снова
+func TestReserveOrBackorder_Repeated(t *testing.T) {
+	expectedAt := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		status entity.BackorderStatus
+		expect func(mock sqlmock.Sqlmock)
+		want   *entity.WarehouseResponse
+	}{
+		{
+			name:   "заказ ждет поступления",
+			status: entity.BackorderStatusPending,
+			expect: func(sqlmock.Sqlmock) {},
+			want: &entity.WarehouseResponse{
+				Success:             true,
+				Message:             "Заказ поставлен в очередь под поступление товара",
+				OrderID:             7,
+				Backordered:         true,
+				ExpectedAvailableAt: &expectedAt,
+			},
+		},
+		{
+			name:   "товар уже зарезервирован под заказ",
+			status: entity.BackorderStatusAllocated,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT \* FROM "warehouse_reservations" WHERE order_id = \$1`).
+					WithArgs(7).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "product_id", "quantity", "warehouse_id", "status"}).
+						AddRow(11, 7, 103, 2, 1, entity.ReservationStatusActive).
+						AddRow(12, 7, 104, 1, 1, entity.ReservationStatusCancelled))
+			},
+			want: &entity.WarehouseResponse{
+				Success:       true,
+				Message:       "Товары успешно зарезервированы",
+				OrderID:       7,
+				ReservedItems: []entity.ReservedItemInfo{{ProductID: 103, Quantity: 2, ReservedID: 11, WarehouseID: 1}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc, mock := newTestWarehouseUseCase(t)
+			mock.ExpectQuery(`SELECT \* FROM "warehouse_backorders" WHERE order_id = \$1`).
+				WithArgs(7, 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "status", "expected_available_at"}).
+					AddRow(1, 7, tt.status, expectedAt))
+			mock.ExpectQuery(`SELECT \* FROM "warehouse_backorder_items" WHERE "warehouse_backorder_items"."backorder_id" = \$1`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "backorder_id", "product_id", "quantity"}).AddRow(1, 1, 103, 2))
+			tt.expect(mock)
+
+			response, err := uc.ReserveOrBackorder(context.Background(), &entity.ReserveWarehouseRequest{
+				OrderID: 7,
+				UserID:  1,
+				Items:   []entity.ReserveItem{{ProductID: 103, Quantity: 2}},
+			}, "saga-7", []byte(`{"order_id":7}`))
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, response)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// TestCancelBackorder_Allocated тестирует, что отмена заказа, товар под который уже зарезервирован,
+// возвращает резерв в доступный остаток
+func TestCancelBackorder_Allocated(t *testing.T) {
+	uc, mock := newTestWarehouseUseCase(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_backorders" WHERE order_id = \$1 .*FOR UPDATE`).
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "status"}).AddRow(1, 7, entity.BackorderStatusAllocated))
+	mock.ExpectExec(`UPDATE "warehouse_backorders" SET "status"=\$1,"cancelled_at"=\$2,"updated_at"=\$3 WHERE "id" = \$4`).
+		WithArgs(entity.BackorderStatusCancelled, sqlmock.AnyArg(), sqlmock.AnyArg(), uint(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_reservations" WHERE order_id = \$1 AND status = \$2 FOR UPDATE`).
+		WithArgs(7, "active").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "warehouse_item_id", "product_id", "quantity", "warehouse_id", "status"}).
+			AddRow(11, 7, 3, 103, 2, 1, entity.ReservationStatusActive))
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_items" WHERE "warehouse_items"."id" = \$1 .*FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "quantity", "reserved_quantity"}).AddRow(3, 103, 10, 2))
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_stocks" WHERE .*FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "warehouse_id", "warehouse_item_id", "product_id", "quantity", "reserved_quantity"}).
+			AddRow(5, 1, 3, 103, 10, 2))
+	mock.ExpectExec(`UPDATE "warehouse_stocks" SET`).
+		WithArgs(int64(10), int64(0), sqlmock.AnyArg(), uint(5)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "warehouse_items" SET`).
+		WithArgs(int64(10), int64(0), nil, sqlmock.AnyArg(), uint(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "warehouse_reservations" SET .*"status"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "stock_movements"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	cancelled, err := uc.CancelBackorder(context.Background(), 7)
+
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestCancelBackorder_Pending тестирует отмену заказа, ожидающего поступления: резерва у него еще нет
+func TestCancelBackorder_Pending(t *testing.T) {
+	uc, mock := newTestWarehouseUseCase(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_backorders" WHERE order_id = \$1 .*FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "status"}).AddRow(1, 7, entity.BackorderStatusPending))
+	mock.ExpectExec(`UPDATE "warehouse_backorders" SET`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	cancelled, err := uc.CancelBackorder(context.Background(), 7)
+
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,325 @@
+package usecase
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"github.com/director74/dz8_shop/warehouse-service/internal/repo"
+)
+
+const (
+	// defaultCatalogLimit размер страницы каталога по умолчанию
+	defaultCatalogLimit = 20
+	// maxCatalogLimit максимальный размер страницы каталога
+	maxCatalogLimit = 100
+)
+
+var (
+	// ErrInvalidCatalogQuery некорректные параметры запроса каталога
+	ErrInvalidCatalogQuery = errors.New("некорректные параметры запроса каталога")
+	// ErrCategoryNotFound категория не найдена
+	ErrCategoryNotFound = errors.New("категория не найдена")
+	// ErrCategoryExists категория с таким slug уже существует
+	ErrCategoryExists = errors.New("категория с таким slug уже существует")
+	// ErrProductNotFound товар не найден
+	ErrProductNotFound = errors.New("товар не найден")
+)
+
+// CatalogUseCase бизнес-логика публичного каталога товаров
+type CatalogUseCase struct {
+	repo          *repo.CatalogRepo
+	warehouseRepo *repo.WarehouseRepo
+}
+
+// NewCatalogUseCase создает новый use case каталога
+func NewCatalogUseCase(catalogRepo *repo.CatalogRepo, warehouseRepo *repo.WarehouseRepo) *CatalogUseCase {
+	return &CatalogUseCase{
+		repo:          catalogRepo,
+		warehouseRepo: warehouseRepo,
+	}
+}
+
+// ListProducts возвращает страницу каталога по параметрам запроса
+func (u *CatalogUseCase) ListProducts(ctx context.Context, query *entity.CatalogQuery) (*entity.CatalogPageResponse, error) {
+	filter, err := u.buildFilter(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Запрашиваем на один товар больше, чтобы узнать, есть ли следующая страница
+	pageSize := filter.Limit
+	filter.Limit++
+	items, err := u.repo.FindProducts(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &entity.CatalogPageResponse{
+		Items: []entity.CatalogProductResponse{},
+		Limit: pageSize,
+	}
+	if len(items) > pageSize {
+		items = items[:pageSize]
+		last := items[len(items)-1]
+		response.NextCursor = encodeCatalogCursor(entity.CatalogCursor{
+			Sort:  filter.Sort,
+			Price: last.Price,
+			ID:    last.ID,
+		})
+	}
+
+	products, err := u.toCatalogProducts(ctx, items)
+	if err != nil {
+		return nil, err
+	}
+	response.Items = append(response.Items, products...)
+
+	return response, nil
+}
+
+// GetProduct возвращает карточку товара каталога по ID продукта
+func (u *CatalogUseCase) GetProduct(ctx context.Context, productID uint) (*entity.CatalogProductResponse, error) {
+	item, err := u.warehouseRepo.GetWarehouseItemByProductID(productID)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, nil
+	}
+
+	products, err := u.toCatalogProducts(ctx, []entity.WarehouseItem{*item})
+	if err != nil {
+		return nil, err
+	}
+	return &products[0], nil
+}
+
+// ListCategories возвращает все категории каталога
+func (u *CatalogUseCase) ListCategories(ctx context.Context) ([]entity.Category, error) {
+	categories, err := u.repo.ListCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if categories == nil {
+		categories = []entity.Category{}
+	}
+	return categories, nil
+}
+
+// CreateCategory создает категорию каталога
+func (u *CatalogUseCase) CreateCategory(ctx context.Context, req *entity.CreateCategoryRequest) (*entity.Category, error) {
+	slug := strings.ToLower(strings.TrimSpace(req.Slug))
+	if slug == "" {
+		return nil, fmt.Errorf("%w: пустой slug", ErrInvalidCatalogQuery)
+	}
+	if _, err := strconv.ParseUint(slug, 10, 32); err == nil {
+		// Числовой slug неотличим от ID категории в фильтре каталога
+		return nil, fmt.Errorf("%w: slug не может состоять только из цифр", ErrInvalidCatalogQuery)
+	}
+
+	existing, err := u.repo.GetCategoryBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrCategoryExists
+	}
+
+	if req.ParentID != nil {
+		parent, err := u.repo.GetCategoryByID(ctx, *req.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			return nil, fmt.Errorf("%w: родительская категория %d", ErrCategoryNotFound, *req.ParentID)
+		}
+	}
+
+	category := &entity.Category{
+		ParentID:    req.ParentID,
+		Name:        req.Name,
+		Slug:        slug,
+		Description: req.Description,
+	}
+	if err := u.repo.CreateCategory(ctx, category); err != nil {
+		return nil, fmt.Errorf("ошибка при создании категории: %w", err)
+	}
+	return category, nil
+}
+
+// UpdateProduct изменяет описание, категорию и изображения товара каталога
+func (u *CatalogUseCase) UpdateProduct(ctx context.Context, productID uint, req *entity.UpdateCatalogProductRequest) (*entity.CatalogProductResponse, error) {
+	item, err := u.warehouseRepo.GetWarehouseItemByProductID(productID)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, ErrProductNotFound
+	}
+
+	updates := make(map[string]interface{})
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.CategoryID != nil {
+		category, err := u.repo.GetCategoryByID(ctx, *req.CategoryID)
+		if err != nil {
+			return nil, err
+		}
+		if category == nil {
+			return nil, ErrCategoryNotFound
+		}
+		updates["category_id"] = *req.CategoryID
+	}
+
+	if err := u.repo.UpdateProduct(ctx, item.ID, updates, req.Images); err != nil {
+		return nil, fmt.Errorf("ошибка при обновлении карточки товара: %w", err)
+	}
+
+	return u.GetProduct(ctx, productID)
+}
+
+// buildFilter проверяет параметры запроса и преобразует их в фильтр репозитория
+func (u *CatalogUseCase) buildFilter(ctx context.Context, query *entity.CatalogQuery) (entity.CatalogFilter, error) {
+	filter := entity.CatalogFilter{
+		Query:    strings.TrimSpace(query.Query),
+		MinPrice: query.MinPrice,
+		MaxPrice: query.MaxPrice,
+		InStock:  query.InStock,
+		Sort:     query.Sort,
+		Limit:    query.Limit,
+	}
+
+	switch filter.Sort {
+	case "":
+		filter.Sort = entity.CatalogSortDefault
+	case entity.CatalogSortDefault, entity.CatalogSortPriceAsc, entity.CatalogSortPriceDesc:
+	default:
+		return filter, fmt.Errorf("%w: неизвестная сортировка %q", ErrInvalidCatalogQuery, query.Sort)
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultCatalogLimit
+	}
+	if filter.Limit > maxCatalogLimit {
+		filter.Limit = maxCatalogLimit
+	}
+
+	if filter.MinPrice != nil && filter.MaxPrice != nil && *filter.MinPrice > *filter.MaxPrice {
+		return filter, fmt.Errorf("%w: min_price больше max_price", ErrInvalidCatalogQuery)
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeCatalogCursor(query.Cursor)
+		if err != nil {
+			return filter, err
+		}
+		if cursor.Sort != filter.Sort {
+			return filter, fmt.Errorf("%w: курсор получен для другой сортировки", ErrInvalidCatalogQuery)
+		}
+		filter.After = cursor
+	}
+
+	if query.Category != "" {
+		category, err := u.resolveCategory(ctx, query.Category)
+		if err != nil {
+			return filter, err
+		}
+		filter.CategoryID = &category.ID
+	}
+
+	return filter, nil
+}
+
+// resolveCategory находит категорию по ID или slug
+func (u *CatalogUseCase) resolveCategory(ctx context.Context, ref string) (*entity.Category, error) {
+	var category *entity.Category
+	var err error
+	if id, parseErr := strconv.ParseUint(ref, 10, 32); parseErr == nil {
+		category, err = u.repo.GetCategoryByID(ctx, uint(id))
+	} else {
+		category, err = u.repo.GetCategoryBySlug(ctx, strings.ToLower(ref))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if category == nil {
+		return nil, ErrCategoryNotFound
+	}
+	return category, nil
+}
+
+// toCatalogProducts собирает карточки каталога, подгружая категории и изображения товаров
+func (u *CatalogUseCase) toCatalogProducts(ctx context.Context, items []entity.WarehouseItem) ([]entity.CatalogProductResponse, error) {
+	itemIDs := make([]uint, 0, len(items))
+	var categoryIDs []uint
+	for _, item := range items {
+		itemIDs = append(itemIDs, item.ID)
+		if item.CategoryID != nil {
+			categoryIDs = append(categoryIDs, *item.CategoryID)
+		}
+	}
+
+	images, err := u.repo.GetImages(ctx, itemIDs)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := u.repo.GetCategoriesByIDs(ctx, categoryIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	products := make([]entity.CatalogProductResponse, 0, len(items))
+	for _, item := range items {
+		product := entity.CatalogProductResponse{
+			ProductID:     item.ProductID,
+			SKU:           item.SKU,
+			Name:          item.Name,
+			Description:   item.Description,
+			Price:         item.Price,
+			Available:     item.Available,
+			InStock:       item.Available > 0 && item.Status == entity.WarehouseStatusAvailable,
+			Status:        item.Status,
+			Images:        images[item.ID],
+			Backorderable: item.Backorderable,
+		}
+		// Дата поступления показывается только для товаров, которые можно заказать под поступление
+		if item.Backorderable {
+			product.ExpectedAvailableAt = item.ExpectedAvailableAt
+		}
+		if product.Images == nil {
+			product.Images = []string{}
+		}
+		if item.CategoryID != nil {
+			product.Category = categories[*item.CategoryID]
+		}
+		products = append(products, product)
+	}
+	return products, nil
+}
+
+// encodeCatalogCursor кодирует позицию выдачи каталога в непрозрачную строку
+func encodeCatalogCursor(cursor entity.CatalogCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCatalogCursor разбирает курсор, полученный от клиента
+func decodeCatalogCursor(value string) (*entity.CatalogCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: некорректный курсор", ErrInvalidCatalogQuery)
+	}
+
+	var cursor entity.CatalogCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == 0 {
+		return nil, fmt.Errorf("%w: некорректный курсор", ErrInvalidCatalogQuery)
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,69 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/director74/dz8_shop/pkg/money"
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+)
+
+// TestCatalogCursor_RoundTrip тестирует кодирование и разбор курсора каталога
+func TestCatalogCursor_RoundTrip(t *testing.T) {
+	cursor := entity.CatalogCursor{Sort: entity.CatalogSortPriceDesc, Price: money.Amount(25050), ID: 42}
+
+	decoded, err := decodeCatalogCursor(encodeCatalogCursor(cursor))
+
+	assert.NoError(t, err)
+	assert.Equal(t, &cursor, decoded)
+}
+
+// TestDecodeCatalogCursor_Invalid тестирует отказ для поврежденного курсора
+func TestDecodeCatalogCursor_Invalid(t *testing.T) {
+	for _, value := range []string{"не-base64", "e30", "bnVsbA"} {
+		_, err := decodeCatalogCursor(value)
+		assert.ErrorIs(t, err, ErrInvalidCatalogQuery, value)
+	}
+}
+
+// TestBuildFilter тестирует проверку параметров запроса каталога
+func TestBuildFilter(t *testing.T) {
+	u := &CatalogUseCase{}
+	minPrice, maxPrice := money.Amount(50000), money.Amount(10000)
+
+	tests := []struct {
+		name      string
+		query     entity.CatalogQuery
+		wantErr   error
+		wantSort  entity.CatalogSort
+		wantLimit int
+	}{
+		{name: "значения по умолчанию", query: entity.CatalogQuery{}, wantSort: entity.CatalogSortDefault, wantLimit: defaultCatalogLimit},
+		{name: "ограничение размера страницы", query: entity.CatalogQuery{Limit: 1000}, wantSort: entity.CatalogSortDefault, wantLimit: maxCatalogLimit},
+		{name: "неизвестная сортировка", query: entity.CatalogQuery{Sort: "name"}, wantErr: ErrInvalidCatalogQuery},
+		{name: "min_price больше max_price", query: entity.CatalogQuery{MinPrice: &minPrice, MaxPrice: &maxPrice}, wantErr: ErrInvalidCatalogQuery},
+		{
+			name: "курсор другой сортировки",
+			query: entity.CatalogQuery{
+				Sort:   entity.CatalogSortPriceAsc,
+				Cursor: encodeCatalogCursor(entity.CatalogCursor{Sort: entity.CatalogSortPriceDesc, ID: 1}),
+			},
+			wantErr: ErrInvalidCatalogQuery,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := u.buildFilter(context.Background(), &tt.query)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSort, filter.Sort)
+			assert.Equal(t, tt.wantLimit, filter.Limit)
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"github.com/director74/dz8_shop/warehouse-service/internal/repo"
+)
+
+var (
+	// ErrWarehouseNotFound склад не найден
+	ErrWarehouseNotFound = errors.New("склад не найден")
+	// ErrWarehouseExists склад с таким кодом уже существует
+	ErrWarehouseExists = errors.New("склад с таким кодом уже существует")
+	// ErrInvalidWarehouse некорректные параметры склада
+	ErrInvalidWarehouse = errors.New("некорректные параметры склада")
+)
+
+// LocationUseCase бизнес-логика управления складами (фулфилмент-центрами) и остатками товаров по складам
+type LocationUseCase struct {
+	repo *repo.WarehouseRepo
+}
+
+// NewLocationUseCase создает новый use case управления складами
+func NewLocationUseCase(warehouseRepo *repo.WarehouseRepo) *LocationUseCase {
+	return &LocationUseCase{
+		repo: warehouseRepo,
+	}
+}
+
+// ListWarehouses возвращает все склады
+func (u *LocationUseCase) ListWarehouses(ctx context.Context) (*entity.ListWarehousesResponse, error) {
+	warehouses, err := u.repo.ListWarehouses(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if warehouses == nil {
+		warehouses = []entity.Warehouse{}
+	}
+	return &entity.ListWarehousesResponse{Warehouses: warehouses}, nil
+}
+
+// CreateWarehouse создает склад. Новый склад активен, если не указано иное.
+func (u *LocationUseCase) CreateWarehouse(ctx context.Context, req *entity.CreateWarehouseRequest) (*entity.Warehouse, error) {
+	code := strings.ToUpper(strings.TrimSpace(req.Code))
+	if code == "" {
+		return nil, fmt.Errorf("%w: код склада не может быть пустым", ErrInvalidWarehouse)
+	}
+
+	existing, err := u.repo.GetWarehouseByCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrWarehouseExists
+	}
+
+	warehouse := &entity.Warehouse{
+		Code:      code,
+		Name:      req.Name,
+		Address:   req.Address,
+		Active:    true,
+		IsDefault: req.IsDefault,
+	}
+	if req.Active != nil {
+		warehouse.Active = *req.Active
+	}
+
+	if err := u.repo.SaveWarehouse(ctx, warehouse); err != nil {
+		return nil, err
+	}
+	warehouse.Zones = []entity.WarehouseZone{}
+	return warehouse, nil
+}
+
+// UpdateWarehouse изменяет склад. Основной склад нельзя отключить или снять с него отметку основного:
+// вместо этого основным отмечается другой склад.
+func (u *LocationUseCase) UpdateWarehouse(ctx context.Context, id uint, req *entity.UpdateWarehouseRequest) (*entity.Warehouse, error) {
+	warehouse, err := u.getWarehouse(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		warehouse.Name = *req.Name
+	}
+	if req.Address != nil {
+		warehouse.Address = *req.Address
+	}
+	if req.IsDefault != nil {
+		if warehouse.IsDefault && !*req.IsDefault {
+			return nil, fmt.Errorf("%w: отметьте основным другой склад", ErrInvalidWarehouse)
+		}
+		warehouse.IsDefault = *req.IsDefault
+	}
+	if req.Active != nil {
+		warehouse.Active = *req.Active
+	}
+	if warehouse.IsDefault && !warehouse.Active {
+		return nil, fmt.Errorf("%w: основной склад не может быть неактивным", ErrInvalidWarehouse)
+	}
+
+	if err := u.repo.SaveWarehouse(ctx, warehouse); err != nil {
+		return nil, err
+	}
+	return warehouse, nil
+}
+
+// SetWarehouseZones заменяет список зон доставки, которые обслуживает склад
+func (u *LocationUseCase) SetWarehouseZones(ctx context.Context, id uint, req *entity.SetWarehouseZonesRequest) (*entity.Warehouse, error) {
+	if _, err := u.getWarehouse(ctx, id); err != nil {
+		return nil, err
+	}
+
+	zones := make([]entity.WarehouseZone, 0, len(req.Zones))
+	seen := make(map[uint]bool, len(req.Zones))
+	for _, zone := range req.Zones {
+		if seen[zone.ZoneID] {
+			return nil, fmt.Errorf("%w: зона доставки %d указана несколько раз", ErrInvalidWarehouse, zone.ZoneID)
+		}
+		seen[zone.ZoneID] = true
+		zones = append(zones, entity.WarehouseZone{
+			WarehouseID: id,
+			ZoneID:      zone.ZoneID,
+			Distance:    zone.Distance,
+		})
+	}
+
+	if err := u.repo.SetWarehouseZones(ctx, id, zones); err != nil {
+		return nil, err
+	}
+	return u.getWarehouse(ctx, id)
+}
+
+// GetProductStock возвращает остатки товара по складам
+func (u *LocationUseCase) GetProductStock(ctx context.Context, productID uint) (*entity.ProductStockResponse, error) {
+	item, err := u.repo.GetWarehouseItemByProductID(productID)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, ErrProductNotFound
+	}
+
+	stocks, err := u.repo.GetLocationStocks(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	warehouses, err := u.repo.ListWarehouses(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	warehousesByID := make(map[uint]entity.Warehouse, len(warehouses))
+	for _, warehouse := range warehouses {
+		warehousesByID[warehouse.ID] = warehouse
+	}
+
+	response := &entity.ProductStockResponse{
+		ProductID: productID,
+		Quantity:  item.Quantity,
+		Available: item.Available,
+		Locations: make([]entity.LocationStockResponse, 0, len(stocks)),
+	}
+	for _, stock := range stocks {
+		warehouse := warehousesByID[stock.WarehouseID]
+		response.Locations = append(response.Locations, entity.LocationStockResponse{
+			WarehouseID:      stock.WarehouseID,
+			WarehouseCode:    warehouse.Code,
+			WarehouseName:    warehouse.Name,
+			Active:           warehouse.Active,
+			Bin:              stock.Bin,
+			Quantity:         stock.Quantity,
+			ReservedQuantity: stock.ReservedQuantity,
+			Available:        stock.Available,
+		})
+	}
+	return response, nil
+}
+
+// getWarehouse получает склад по ID или возвращает ErrWarehouseNotFound
+func (u *LocationUseCase) getWarehouse(ctx context.Context, id uint) (*entity.Warehouse, error) {
+	warehouse, err := u.repo.GetWarehouse(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if warehouse == nil {
+		return nil, ErrWarehouseNotFound
+	}
+	return warehouse, nil
+}
@@ -0,0 +1,191 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/director74/dz8_shop/pkg/messaging"
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"github.com/director74/dz8_shop/warehouse-service/internal/repo"
+	"gorm.io/gorm"
+)
+
+// stockLevelBatchSize количество товаров, проверяемых за один запрос к базе данных
+const stockLevelBatchSize = 200
+
+// SetReorderPolicy устанавливает точку дозаказа и страховой запас товара.
+// Страховой запас не может превышать точку дозаказа; нулевая точка дозаказа отключает контроль остатка.
+func (u *StockUseCase) SetReorderPolicy(ctx context.Context, productID uint, req *entity.SetReorderPolicyRequest) (*entity.ReorderPolicyResponse, error) {
+	if req.ReorderPoint == nil || *req.ReorderPoint < 0 || req.SafetyStock < 0 {
+		return nil, fmt.Errorf("%w: точка дозаказа и страховой запас не могут быть отрицательными", ErrInvalidStockOperation)
+	}
+	if req.SafetyStock > *req.ReorderPoint {
+		return nil, fmt.Errorf("%w: страховой запас %d больше точки дозаказа %d", ErrInvalidStockOperation, req.SafetyStock, *req.ReorderPoint)
+	}
+
+	item, err := u.repo.SetReorderPolicy(ctx, productID, *req.ReorderPoint, req.SafetyStock)
+	if err != nil {
+		if errors.Is(err, repo.ErrWarehouseItemNotFound) {
+			return nil, fmt.Errorf("%w: %v", ErrProductNotFound, err)
+		}
+		return nil, err
+	}
+
+	log.Printf("Точка дозаказа товара ProductID=%d: %d, страховой запас %d", productID, item.ReorderPoint, item.SafetyStock)
+
+	return &entity.ReorderPolicyResponse{
+		ProductID:    item.ProductID,
+		ReorderPoint: item.ReorderPoint,
+		SafetyStock:  item.SafetyStock,
+		Available:    item.Available,
+		Level:        stockLevel(item),
+	}, nil
+}
+
+// LowStockReport возвращает товары, доступный остаток которых достиг точки дозаказа
+func (u *StockUseCase) LowStockReport(ctx context.Context, query *entity.LowStockReportQuery) (*entity.LowStockReportResponse, error) {
+	switch query.Level {
+	case "", entity.StockLevelLow, entity.StockLevelCritical:
+	default:
+		return nil, fmt.Errorf("%w: неизвестный уровень остатка %q", ErrInvalidStockOperation, query.Level)
+	}
+
+	items, err := u.repo.GetLowStockItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &entity.LowStockReportResponse{
+		Items:       make([]entity.LowStockItem, 0, len(items)),
+		GeneratedAt: time.Now(),
+	}
+	for i := range items {
+		lowStockItem := newLowStockItem(&items[i])
+		if query.Level != "" && lowStockItem.Level != query.Level {
+			continue
+		}
+		response.Items = append(response.Items, lowStockItem)
+	}
+	response.Total = len(response.Items)
+	return response, nil
+}
+
+// EvaluateStockLevels пересчитывает уровень остатка товаров с заданной точкой дозаказа.
+// При ухудшении уровня (normal -> low, low -> critical) публикуется событие warehouse.stock.low;
+// восстановление уровня после пополнения только запоминается, чтобы следующее снижение снова вызвало событие.
+// Возвращает количество опубликованных событий.
+func (u *StockUseCase) EvaluateStockLevels(ctx context.Context) (int, error) {
+	now := time.Now()
+	alerts := 0
+
+	var afterID uint
+	for {
+		items, err := u.repo.GetStockLevelCandidates(ctx, afterID, stockLevelBatchSize)
+		if err != nil {
+			return alerts, fmt.Errorf("ошибка получения товаров для проверки остатка: %w", err)
+		}
+		if len(items) == 0 {
+			return alerts, nil
+		}
+
+		for _, item := range items {
+			afterID = item.ID
+			if stockLevel(&item).Severity() == item.StockLevel.Severity() {
+				continue
+			}
+
+			alerted := false
+			_, err := u.repo.UpdateStockLevel(ctx, item.ID, now, stockLevel, func(tx *gorm.DB, locked *entity.WarehouseItem, previous entity.StockLevel) error {
+				if locked.StockLevel.Severity() <= previous.Severity() {
+					return nil
+				}
+				alerted = true
+				return u.publishLowStock(publisherForTx(u.publisher, tx), locked, previous, now)
+			})
+			if err != nil {
+				log.Printf("Ошибка проверки остатка товара ProductID=%d: %v", item.ProductID, err)
+				continue
+			}
+			if alerted {
+				log.Printf("Низкий остаток товара ProductID=%d (%s): доступно %d, точка дозаказа %d, страховой запас %d",
+					item.ProductID, item.SKU, item.Available, item.ReorderPoint, item.SafetyStock)
+				alerts++
+			}
+		}
+
+		if len(items) < stockLevelBatchSize {
+			return alerts, nil
+		}
+	}
+}
+
+// RunLowStockEvaluation периодически проверяет уровень остатка товаров.
+// Блокирует вызывающую горутину до отмены ctx.
+func (u *StockUseCase) RunLowStockEvaluation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Проверка низкого остатка товаров запущена (интервал %s)", interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Проверка низкого остатка товаров остановлена")
+			return
+		case <-ticker.C:
+			if _, err := u.EvaluateStockLevels(ctx); err != nil {
+				log.Printf("Ошибка проверки низкого остатка товаров: %v", err)
+			}
+		}
+	}
+}
+
+// publishLowStock публикует событие о низком остатке товара через publisher
+func (u *StockUseCase) publishLowStock(publisher messaging.MessagePublisher, item *entity.WarehouseItem, previous entity.StockLevel, detectedAt time.Time) error {
+	message := entity.LowStockMessage{
+		LowStockItem:  newLowStockItem(item),
+		PreviousLevel: previous,
+		DetectedAt:    detectedAt,
+		Timestamp:     time.Now().Unix(),
+	}
+
+	err := messaging.PublishWithRetryAndLogging(publisher, u.exchangeName, "warehouse.stock.low", message, 3)
+	if err != nil {
+		return fmt.Errorf("ошибка публикации события о низком остатке: %w", err)
+	}
+	return nil
+}
+
+// stockLevel определяет уровень доступного остатка товара: critical - остаток не выше страхового запаса,
+// low - не выше точки дозаказа, normal - выше точки дозаказа или точка дозаказа не задана
+func stockLevel(item *entity.WarehouseItem) entity.StockLevel {
+	switch {
+	case item.ReorderPoint <= 0:
+		return entity.StockLevelNormal
+	case item.Available <= item.SafetyStock:
+		return entity.StockLevelCritical
+	case item.Available <= item.ReorderPoint:
+		return entity.StockLevelLow
+	default:
+		return entity.StockLevelNormal
+	}
+}
+
+// newLowStockItem формирует строку отчета о низком остатке товара
+func newLowStockItem(item *entity.WarehouseItem) entity.LowStockItem {
+	return entity.LowStockItem{
+		ProductID:        item.ProductID,
+		SKU:              item.SKU,
+		Name:             item.Name,
+		Level:            stockLevel(item),
+		Quantity:         item.Quantity,
+		ReservedQuantity: item.ReservedQuantity,
+		Available:        item.Available,
+		ReorderPoint:     item.ReorderPoint,
+		SafetyStock:      item.SafetyStock,
+		Shortfall:        max(item.ReorderPoint-item.Available, 0),
+	}
+}
@@ -0,0 +1,119 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+)
+
+// TestStockLevel тестирует определение уровня доступного остатка по точке дозаказа и страховому запасу
+func TestStockLevel(t *testing.T) {
+	tests := []struct {
+		name      string
+		reorder   int64
+		safety    int64
+		available int64
+		want      entity.StockLevel
+	}{
+		{name: "контроль отключен", reorder: 0, available: -1, want: entity.StockLevelNormal},
+		{name: "выше точки дозаказа", reorder: 10, safety: 3, available: 11, want: entity.StockLevelNormal},
+		{name: "на точке дозаказа", reorder: 10, safety: 3, available: 10, want: entity.StockLevelLow},
+		{name: "выше страхового запаса", reorder: 10, safety: 3, available: 4, want: entity.StockLevelLow},
+		{name: "на страховом запасе", reorder: 10, safety: 3, available: 3, want: entity.StockLevelCritical},
+		{name: "без страхового запаса", reorder: 10, available: 0, want: entity.StockLevelCritical},
+		{name: "резерв больше остатка", reorder: 10, safety: 3, available: -2, want: entity.StockLevelCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := &entity.WarehouseItem{ReorderPoint: tt.reorder, SafetyStock: tt.safety, Available: tt.available}
+			assert.Equal(t, tt.want, stockLevel(item))
+		})
+	}
+}
+
+// stockLevelRows строка товара 103 с точкой дозаказа 10, страховым запасом 3, сохраненным уровнем level
+// и доступным остатком available
+func stockLevelRows(level entity.StockLevel, available int64) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "product_id", "sku", "available", "reorder_point", "safety_stock", "stock_level"}).
+		AddRow(3, 103, "SKU-103", available, 10, 3, level)
+}
+
+// expectStockLevelCandidates ожидает выборку товаров для проверки остатка
+func expectStockLevelCandidates(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_items" WHERE id > \$1 AND \(reorder_point > 0 OR stock_level IN`).
+		WillReturnRows(rows)
+}
+
+// expectStockLevelChanged ожидает пересчет уровня остатка товара 3 под блокировкой и сохранение уровня want.
+// Если alert, в той же транзакции сохраняется событие warehouse.stock.low.
+func expectStockLevelChanged(mock sqlmock.Sqlmock, rows *sqlmock.Rows, want entity.StockLevel, alert bool) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_items" WHERE .*FOR UPDATE`).WillReturnRows(rows)
+	mock.ExpectExec(`UPDATE "warehouse_items" SET "stock_level"=\$1,"stock_level_at"=\$2,"updated_at"=\$3 WHERE "id" = \$4`).
+		WithArgs(want, sqlmock.AnyArg(), sqlmock.AnyArg(), uint(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if alert {
+		mock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+			WithArgs("warehouse_events", "warehouse.stock.low", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	}
+	mock.ExpectCommit()
+}
+
+// TestEvaluateStockLevels_Transitions тестирует событие при ухудшении уровня остатка, отсутствие повторного
+// события на том же уровне и сброс уровня после пополнения, после которого снижение снова вызывает событие
+func TestEvaluateStockLevels_Transitions(t *testing.T) {
+	steps := []struct {
+		name       string
+		stored     entity.StockLevel
+		available  int64
+		want       entity.StockLevel
+		wantAlerts int
+	}{
+		{name: "normal -> low", stored: entity.StockLevelNormal, available: 8, want: entity.StockLevelLow, wantAlerts: 1},
+		{name: "low без изменений", stored: entity.StockLevelLow, available: 6, want: entity.StockLevelLow},
+		{name: "low -> critical", stored: entity.StockLevelLow, available: 2, want: entity.StockLevelCritical, wantAlerts: 1},
+		{name: "critical без изменений", stored: entity.StockLevelCritical, available: 0, want: entity.StockLevelCritical},
+		{name: "critical -> low после частичного пополнения", stored: entity.StockLevelCritical, available: 5, want: entity.StockLevelLow},
+		{name: "low -> normal после пополнения", stored: entity.StockLevelLow, available: 50, want: entity.StockLevelNormal},
+		{name: "normal -> critical после сброса", stored: entity.StockLevelNormal, available: 1, want: entity.StockLevelCritical, wantAlerts: 1},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			uc, mock := newTestStockUseCase(t)
+			expectStockLevelCandidates(mock, stockLevelRows(step.stored, step.available))
+			if step.want != step.stored {
+				expectStockLevelChanged(mock, stockLevelRows(step.stored, step.available), step.want, step.wantAlerts > 0)
+			}
+
+			alerts, err := uc.EvaluateStockLevels(context.Background())
+
+			assert.NoError(t, err)
+			assert.Equal(t, step.wantAlerts, alerts)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// TestEvaluateStockLevels_ChangedConcurrently тестирует, что уровень, уже сохраненный другой проверкой,
+// не вызывает повторного события
+func TestEvaluateStockLevels_ChangedConcurrently(t *testing.T) {
+	uc, mock := newTestStockUseCase(t)
+	expectStockLevelCandidates(mock, stockLevelRows(entity.StockLevelNormal, 8))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_items" WHERE .*FOR UPDATE`).
+		WillReturnRows(stockLevelRows(entity.StockLevelLow, 8))
+	mock.ExpectRollback()
+
+	alerts, err := uc.EvaluateStockLevels(context.Background())
+
+	assert.NoError(t, err)
+	assert.Zero(t, alerts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,140 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/director74/dz8_shop/pkg/messaging"
+	"github.com/director74/dz8_shop/pkg/outbox"
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"github.com/director74/dz8_shop/warehouse-service/internal/repo"
+	"gorm.io/gorm"
+)
+
+// ErrReservationNotConfirmable резервирование заказа нельзя подтвердить: оно отменено или истекло
+var ErrReservationNotConfirmable = errors.New("резервирование нельзя подтвердить")
+
+const (
+	// defaultReservationTTL срок резервирования товаров для саги по умолчанию
+	defaultReservationTTL = 30 * time.Minute
+	// reservationExpiryBatchSize количество заказов с просроченными резервациями, обрабатываемых за одну проверку
+	reservationExpiryBatchSize = 100
+)
+
+// UseReservationTTL задает срок резервирования товаров для саги заказа
+func (u *WarehouseUseCase) UseReservationTTL(ttl time.Duration) {
+	if ttl > 0 {
+		u.reservationTTL = ttl
+	}
+}
+
+// ReservationTTL возвращает срок резервирования товаров для саги заказа
+func (u *WarehouseUseCase) ReservationTTL() time.Duration {
+	return u.reservationTTL
+}
+
+// ConfirmOrderReservation подтверждает резервирование товаров заказа при подтверждении заказа в саге.
+// Повторное подтверждение уже подтвержденного резервирования ничего не меняет. Если резервирование
+// отменено или истекло, возвращается ErrReservationNotConfirmable.
+func (u *WarehouseUseCase) ConfirmOrderReservation(ctx context.Context, orderID uint) error {
+	err := u.repo.ConfirmWarehouseItems(ctx, orderID)
+	if err == nil || !errors.Is(err, repo.ErrNoActiveReservations) {
+		return err
+	}
+
+	reservations, err := u.repo.GetReservationsByOrderID(orderID)
+	if err != nil {
+		return fmt.Errorf("ошибка получения резерваций заказа %d: %w", orderID, err)
+	}
+	for _, reservation := range reservations {
+		if reservation.Status == entity.ReservationStatusCompleted {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: у заказа %d нет активных резерваций", ErrReservationNotConfirmable, orderID)
+}
+
+// ExpireReservations снимает резервирование товаров, которое не было подтверждено или отменено до истечения срока:
+// резервации переводятся в статус expired, товары возвращаются в доступный остаток и публикуется событие
+// warehouse.reservation.expired. Возвращает количество заказов, резервирование которых истекло.
+func (u *WarehouseUseCase) ExpireReservations(ctx context.Context) (int, error) {
+	now := time.Now()
+	orderIDs, err := u.repo.GetOrdersWithExpiredReservations(ctx, now, reservationExpiryBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка поиска просроченных резерваций: %w", err)
+	}
+
+	expired := 0
+	for _, orderID := range orderIDs {
+		reservations, err := u.repo.ExpireReservations(ctx, orderID, now, func(tx *gorm.DB, reservations []entity.WarehouseReservation) error {
+			return u.publishReservationExpired(u.txPublisher(tx), orderID, reservations, now)
+		})
+		if err != nil {
+			log.Printf("Ошибка снятия просроченного резервирования заказа %d: %v", orderID, err)
+			continue
+		}
+		if len(reservations) > 0 {
+			log.Printf("Резервирование товаров заказа %d истекло, резерваций возвращено в остаток: %d", orderID, len(reservations))
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// RunReservationExpiry периодически снимает просроченные резервирования.
+// Блокирует вызывающую горутину до отмены ctx.
+func (u *WarehouseUseCase) RunReservationExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("Проверка просроченных резервирований запущена (интервал %s, срок резервирования %s)", interval, u.reservationTTL)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Проверка просроченных резервирований остановлена")
+			return
+		case <-ticker.C:
+			if _, err := u.ExpireReservations(ctx); err != nil {
+				log.Printf("Ошибка проверки просроченных резервирований: %v", err)
+			}
+		}
+	}
+}
+
+// txPublisher возвращает публикатор для транзакции tx.
+// Outbox сохраняет событие в той же транзакции, что и возврат товаров в остаток.
+func (u *WarehouseUseCase) txPublisher(tx *gorm.DB) messaging.MessagePublisher {
+	return publisherForTx(u.publisher, tx)
+}
+
+// publisherForTx возвращает публикатор, сохраняющий события в outbox в транзакции tx.
+// Публикатор без поддержки транзакций возвращается как есть.
+func publisherForTx(publisher messaging.MessagePublisher, tx *gorm.DB) messaging.MessagePublisher {
+	if p, ok := publisher.(*outbox.Publisher); ok {
+		return p.WithTx(tx)
+	}
+	return publisher
+}
+
+// publishReservationExpired публикует событие об истечении резервирования товаров заказа через publisher
+func (u *WarehouseUseCase) publishReservationExpired(publisher messaging.MessagePublisher, orderID uint, reservations []entity.WarehouseReservation, expiredAt time.Time) error {
+	message := entity.ReservationExpiredMessage{
+		OrderID:   orderID,
+		Items:     make([]entity.ReservedItemInfo, 0, len(reservations)),
+		ExpiredAt: expiredAt,
+		Timestamp: time.Now().Unix(),
+	}
+	for _, reservation := range reservations {
+		message.Items = append(message.Items, reservedItemInfo(reservation))
+	}
+
+	err := messaging.PublishWithRetryAndLogging(publisher, u.exchangeName, "warehouse.reservation.expired", message, 3)
+	if err != nil {
+		return fmt.Errorf("ошибка публикации события об истечении резервирования: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/director74/dz8_shop/pkg/outbox"
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"github.com/director74/dz8_shop/warehouse-service/internal/repo"
+)
+
+// newMockDB создает GORM поверх sqlmock с диалектом PostgreSQL
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("не удалось создать sqlmock: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("не удалось открыть gorm: %v", err)
+	}
+	return db, mock
+}
+
+// newTestWarehouseUseCase создает usecase склада поверх sqlmock с публикацией событий через outbox
+func newTestWarehouseUseCase(t *testing.T) (*WarehouseUseCase, sqlmock.Sqlmock) {
+	db, mock := newMockDB(t)
+	return NewWarehouseUseCase(repo.NewWarehouseRepo(db), outbox.NewPublisher(db), "warehouse_events"), mock
+}
+
+// expectExpiredReservationLocked ожидает выборку просроченной резервации заказа 7 на 2 единицы товара 3
+// со склада 1 и блокировку строк товара и его остатка
+func expectExpiredReservationLocked(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT DISTINCT "order_id" FROM "warehouse_reservations"`).
+		WillReturnRows(sqlmock.NewRows([]string{"order_id"}).AddRow(7))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_reservations" WHERE .*order_id = .* FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "warehouse_item_id", "product_id", "quantity", "warehouse_id", "status"}).
+			AddRow(11, 7, 3, 103, 2, 1, entity.ReservationStatusActive))
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_items" WHERE .*FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "quantity", "reserved_quantity"}).AddRow(3, 103, 10, 2))
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_stocks" WHERE .*FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "warehouse_id", "warehouse_item_id", "product_id", "quantity", "reserved_quantity"}).
+			AddRow(5, 1, 3, 103, 10, 2))
+}
+
+// TestExpireReservations_ReturnsStock тестирует возврат просроченного резерва в остаток
+// и сохранение события в outbox в той же транзакции
+func TestExpireReservations_ReturnsStock(t *testing.T) {
+	uc, mock := newTestWarehouseUseCase(t)
+	expectExpiredReservationLocked(mock)
+	// Резерв снимается и на складе, и по товару в целом, количество не меняется
+	mock.ExpectExec(`UPDATE "warehouse_stocks" SET`).
+		WithArgs(int64(10), int64(0), sqlmock.AnyArg(), uint(5)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "warehouse_items" SET`).
+		WithArgs(int64(10), int64(0), nil, sqlmock.AnyArg(), uint(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "warehouse_reservations" SET .*"status"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "stock_movements"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "outbox_messages"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	expired, err := uc.ExpireReservations(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestExpireReservations_OutboxFailureRollsBack тестирует, что без сохраненного события резерв не снимается
+func TestExpireReservations_OutboxFailureRollsBack(t *testing.T) {
+	uc, mock := newTestWarehouseUseCase(t)
+	expectExpiredReservationLocked(mock)
+	mock.ExpectExec(`UPDATE "warehouse_stocks" SET`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "warehouse_items" SET`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "warehouse_reservations" SET`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "stock_movements"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "outbox_messages"`).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	expired, err := uc.ExpireReservations(context.Background())
+
+	assert.NoError(t, err)
+	assert.Zero(t, expired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestExpireReservations_AlreadyConfirmed тестирует, что резервация, подтвержденная после выборки, не снимается
+func TestExpireReservations_AlreadyConfirmed(t *testing.T) {
+	uc, mock := newTestWarehouseUseCase(t)
+	mock.ExpectQuery(`SELECT DISTINCT "order_id" FROM "warehouse_reservations"`).
+		WillReturnRows(sqlmock.NewRows([]string{"order_id"}).AddRow(7))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_reservations" WHERE .*FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	expired, err := uc.ExpireReservations(context.Background())
+
+	assert.NoError(t, err)
+	assert.Zero(t, expired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestConfirmOrderReservation_NoActiveReservations тестирует подтверждение заказа без активных резерваций
+func TestConfirmOrderReservation_NoActiveReservations(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  entity.ReservationStatus
+		wantErr error
+	}{
+		{name: "резервирование истекло", status: entity.ReservationStatusExpired, wantErr: ErrReservationNotConfirmable},
+		{name: "резервирование отменено", status: entity.ReservationStatusCancelled, wantErr: ErrReservationNotConfirmable},
+		{name: "повторное подтверждение", status: entity.ReservationStatusCompleted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc, mock := newTestWarehouseUseCase(t)
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT \* FROM "warehouse_reservations" WHERE .*FOR UPDATE`).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectRollback()
+			mock.ExpectQuery(`SELECT \* FROM "warehouse_reservations" WHERE order_id = \$1`).
+				WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "status", "reservation_expiry"}).
+					AddRow(11, 7, tt.status, time.Now().Add(-time.Minute)))
+
+			err := uc.ConfirmOrderReservation(context.Background(), 7)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
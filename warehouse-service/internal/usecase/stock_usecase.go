@@ -0,0 +1,233 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/director74/dz8_shop/pkg/messaging"
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"github.com/director74/dz8_shop/warehouse-service/internal/repo"
+)
+
+const (
+	// defaultStockMovementsLimit размер страницы журнала движения по умолчанию
+	defaultStockMovementsLimit = 50
+	// maxStockMovementsLimit максимальный размер страницы журнала движения
+	maxStockMovementsLimit = 500
+)
+
+var (
+	// ErrInsufficientStock операция оставила бы на складе меньше товара, чем зарезервировано под заказы,
+	// или свободного остатка на складах не хватает для резервирования
+	ErrInsufficientStock = errors.New("недостаточно свободного остатка товара")
+	// ErrInvalidStockOperation некорректные параметры операции с остатком
+	ErrInvalidStockOperation = errors.New("некорректные параметры операции с остатком")
+)
+
+// StockUseCase бизнес-логика учета остатков: приемка, списание, инвентаризация и ручная корректировка,
+// а также контроль низкого остатка. Каждое изменение остатка записывается в журнал движения товаров.
+type StockUseCase struct {
+	repo         *repo.WarehouseRepo
+	publisher    messaging.MessagePublisher
+	exchangeName string
+	allocator    BackorderAllocator
+}
+
+// BackorderAllocator резервирует поступивший товар под заказы из очереди под поступление
+type BackorderAllocator interface {
+	AllocateBackorders(ctx context.Context) (allocated, pending int, err error)
+}
+
+// NewStockUseCase создает новый use case учета остатков. События о низком остатке публикуются
+// через publisher в exchangeName.
+func NewStockUseCase(warehouseRepo *repo.WarehouseRepo, publisher messaging.MessagePublisher, exchangeName string) *StockUseCase {
+	return &StockUseCase{
+		repo:         warehouseRepo,
+		publisher:    publisher,
+		exchangeName: exchangeName,
+	}
+}
+
+// UseBackorderAllocator задает распределение поступившего товара по заказам из очереди под поступление
+func (u *StockUseCase) UseBackorderAllocator(allocator BackorderAllocator) {
+	u.allocator = allocator
+}
+
+// ReceiveStock оприходует поступивший на склад товар. После приемки товар в первую очередь резервируется
+// под заказы из очереди под поступление; ошибка распределения не отменяет приемку и только логируется,
+// очередь будет обработана при следующей периодической проверке.
+func (u *StockUseCase) ReceiveStock(ctx context.Context, req *entity.ReceiveStockRequest) (*entity.StockOperationResponse, error) {
+	if req.Quantity <= 0 {
+		return nil, fmt.Errorf("%w: количество приемки должно быть положительным", ErrInvalidStockOperation)
+	}
+
+	movement := &entity.StockMovement{
+		Type:      entity.StockMovementReceipt,
+		Actor:     req.Actor,
+		Principal: req.Principal,
+		Reason:    req.Reason,
+		Reference: req.Reference,
+	}
+	response, err := u.apply(ctx, req.ProductID, req.WarehouseID, movement, func(stock *entity.LocationStock) error {
+		stock.Quantity += req.Quantity
+		return nil
+	})
+	if err != nil || u.allocator == nil {
+		return response, err
+	}
+
+	allocated, _, err := u.allocator.AllocateBackorders(ctx)
+	if err != nil {
+		log.Printf("Ошибка распределения поступившего товара ProductID=%d по заказам под поступление: %v", req.ProductID, err)
+	}
+	response.BackordersAllocated = allocated
+	return response, nil
+}
+
+// WriteOffStock списывает товар (брак, порча, недостача). Зарезервированный под заказы товар списать нельзя.
+func (u *StockUseCase) WriteOffStock(ctx context.Context, req *entity.WriteOffStockRequest) (*entity.StockOperationResponse, error) {
+	if req.Quantity <= 0 {
+		return nil, fmt.Errorf("%w: количество списания должно быть положительным", ErrInvalidStockOperation)
+	}
+
+	movement := &entity.StockMovement{
+		Type:      entity.StockMovementWriteOff,
+		Actor:     req.Actor,
+		Principal: req.Principal,
+		Reason:    req.Reason,
+		Reference: req.Reference,
+	}
+	return u.apply(ctx, req.ProductID, req.WarehouseID, movement, func(stock *entity.LocationStock) error {
+		return changeQuantity(stock, -req.Quantity)
+	})
+}
+
+// CountStock приводит остаток товара к количеству, посчитанному при инвентаризации.
+// Фактическое количество принимается, даже если оно меньше зарезервированного: расхождение
+// фиксируется в журнале, а новые резервирования станут невозможны до пополнения остатка.
+func (u *StockUseCase) CountStock(ctx context.Context, req *entity.InventoryCountRequest) (*entity.StockOperationResponse, error) {
+	if req.CountedQuantity == nil || *req.CountedQuantity < 0 {
+		return nil, fmt.Errorf("%w: фактическое количество не может быть отрицательным", ErrInvalidStockOperation)
+	}
+	counted := *req.CountedQuantity
+
+	movement := &entity.StockMovement{
+		Type:      entity.StockMovementCount,
+		Actor:     req.Actor,
+		Principal: req.Principal,
+		Reason:    req.Reason,
+		Reference: req.Reference,
+	}
+	response, err := u.apply(ctx, req.ProductID, req.WarehouseID, movement, func(stock *entity.LocationStock) error {
+		stock.Quantity = counted
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Movement.QuantityDelta != 0 {
+		log.Printf("Инвентаризация товара ProductID=%d: расхождение %+d (учет %d, факт %d)",
+			req.ProductID, response.Movement.QuantityDelta, response.Movement.QuantityBefore, counted)
+	}
+	if response.Stock.Available < 0 {
+		log.Printf("Инвентаризация товара ProductID=%d на складе %d: фактический остаток %d меньше зарезервированного %d",
+			req.ProductID, response.Stock.WarehouseID, counted, response.Movement.ReservedAfter)
+	}
+	return response, nil
+}
+
+// AdjustStock корректирует остаток товара на req.Delta вручную
+func (u *StockUseCase) AdjustStock(ctx context.Context, req *entity.AdjustStockRequest) (*entity.StockOperationResponse, error) {
+	if req.Delta == 0 {
+		return nil, fmt.Errorf("%w: корректировка не может быть нулевой", ErrInvalidStockOperation)
+	}
+
+	movement := &entity.StockMovement{
+		Type:      entity.StockMovementAdjustment,
+		Actor:     req.Actor,
+		Principal: req.Principal,
+		Reason:    req.Reason,
+		Reference: req.Reference,
+	}
+	return u.apply(ctx, req.ProductID, req.WarehouseID, movement, func(stock *entity.LocationStock) error {
+		return changeQuantity(stock, req.Delta)
+	})
+}
+
+// ListMovements возвращает страницу журнала движения товаров
+func (u *StockUseCase) ListMovements(ctx context.Context, query *entity.StockMovementQuery) (*entity.ListStockMovementsResponse, error) {
+	if query.Limit <= 0 {
+		query.Limit = defaultStockMovementsLimit
+	}
+	if query.Limit > maxStockMovementsLimit {
+		query.Limit = maxStockMovementsLimit
+	}
+	if query.Offset < 0 {
+		return nil, fmt.Errorf("%w: offset не может быть отрицательным", ErrInvalidStockOperation)
+	}
+
+	movements, total, err := u.repo.ListStockMovements(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if movements == nil {
+		movements = []entity.StockMovement{}
+	}
+
+	return &entity.ListStockMovementsResponse{
+		Movements: movements,
+		Total:     total,
+	}, nil
+}
+
+// apply изменяет остаток товара на складе warehouseID (0 - основной склад) и возвращает товар и остаток
+// на складе после изменения вместе с записью журнала
+func (u *StockUseCase) apply(ctx context.Context, productID, warehouseID uint, movement *entity.StockMovement, change func(stock *entity.LocationStock) error) (*entity.StockOperationResponse, error) {
+	item, stock, err := u.repo.ApplyStockMovement(ctx, productID, warehouseID, movement, change)
+	if err != nil {
+		if errors.Is(err, repo.ErrWarehouseItemNotFound) {
+			return nil, fmt.Errorf("%w: %v", ErrProductNotFound, err)
+		}
+		if errors.Is(err, repo.ErrWarehouseNotFound) {
+			return nil, fmt.Errorf("%w: %v", ErrWarehouseNotFound, err)
+		}
+		return nil, err
+	}
+
+	log.Printf("Движение товара ProductID=%d на складе %d: %s %+d (остаток %d -> %d), исполнитель %s (%s)",
+		productID, stock.WarehouseID, movement.Type, movement.QuantityDelta, movement.QuantityBefore, movement.QuantityAfter, movement.Actor, movement.Principal)
+
+	return &entity.StockOperationResponse{
+		Item: entity.GetWarehouseResponse{
+			ID:          item.ID,
+			ProductID:   item.ProductID,
+			SKU:         item.SKU,
+			Name:        item.Name,
+			Price:       item.Price,
+			Quantity:    item.Quantity,
+			Available:   item.Available,
+			Status:      item.Status,
+			Location:    item.Location,
+			LastOrderID: item.LastOrderID,
+			CreatedAt:   item.CreatedAt,
+			UpdatedAt:   item.UpdatedAt,
+		},
+		Stock:    *stock,
+		Movement: *movement,
+	}, nil
+}
+
+// changeQuantity изменяет остаток товара на складе на delta. Остаток не может стать меньше
+// зарезервированного на этом складе количества.
+func changeQuantity(stock *entity.LocationStock, delta int64) error {
+	quantity := stock.Quantity + delta
+	if quantity < stock.ReservedQuantity {
+		return fmt.Errorf("%w: остаток на складе %d, зарезервировано %d, изменение %+d",
+			ErrInsufficientStock, stock.Quantity, stock.ReservedQuantity, delta)
+	}
+	stock.Quantity = quantity
+	return nil
+}
@@ -0,0 +1,162 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/director74/dz8_shop/pkg/outbox"
+	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
+	"github.com/director74/dz8_shop/warehouse-service/internal/repo"
+)
+
+// TestChangeQuantity тестирует, что остаток на складе не может стать меньше зарезервированного
+func TestChangeQuantity(t *testing.T) {
+	tests := []struct {
+		name    string
+		delta   int64
+		want    int64
+		wantErr error
+	}{
+		{name: "приход", delta: 5, want: 15},
+		{name: "списание свободного остатка", delta: -3, want: 7},
+		{name: "списание до резерва", delta: -6, want: 4},
+		{name: "списание зарезервированного", delta: -7, want: 10, wantErr: ErrInsufficientStock},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stock := &entity.LocationStock{Quantity: 10, ReservedQuantity: 4}
+
+			err := changeQuantity(stock, tt.delta)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, stock.Quantity)
+			assert.Equal(t, int64(4), stock.ReservedQuantity)
+		})
+	}
+}
+
+// newTestStockUseCase создает usecase учета остатков поверх sqlmock с публикацией событий через outbox
+func newTestStockUseCase(t *testing.T) (*StockUseCase, sqlmock.Sqlmock) {
+	db, mock := newMockDB(t)
+	return NewStockUseCase(repo.NewWarehouseRepo(db), outbox.NewPublisher(db), "warehouse_events"), mock
+}
+
+// expectStockLocked ожидает блокировку товара 103 и его остатка на складе 1: 10 единиц, из них 4 в резерве
+func expectStockLocked(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_items" WHERE product_id = \$1 .*FOR UPDATE`).
+		WithArgs(103, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "quantity", "reserved_quantity"}).AddRow(3, 103, 10, 4))
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_stocks" WHERE .*FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "warehouse_id", "warehouse_item_id", "product_id", "quantity", "reserved_quantity"}).
+			AddRow(5, 1, 3, 103, 10, 4))
+}
+
+// TestWriteOffStock_JournalsPrincipal тестирует списание с записью в журнал исполнителя из запроса
+// и вызывающего, прошедшего проверку доступа
+func TestWriteOffStock_JournalsPrincipal(t *testing.T) {
+	uc, mock := newTestStockUseCase(t)
+	expectStockLocked(mock)
+	mock.ExpectExec(`UPDATE "warehouse_stocks" SET`).
+		WithArgs(int64(7), int64(4), sqlmock.AnyArg(), uint(5)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "warehouse_items" SET`).
+		WithArgs(int64(7), int64(4), nil, sqlmock.AnyArg(), uint(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "stock_movements"`).
+		WithArgs(uint(1), uint(3), uint(103), entity.StockMovementWriteOff, int64(-3), int64(10), int64(7), int64(0), int64(4), int64(4),
+			"брак", "кладовщик Иванов", "АКТ-1", nil, nil, "api-key@10.0.0.5", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	response, err := uc.WriteOffStock(context.Background(), &entity.WriteOffStockRequest{
+		ProductID:   103,
+		WarehouseID: 1,
+		Quantity:    3,
+		Actor:       "кладовщик Иванов",
+		Principal:   "api-key@10.0.0.5",
+		Reason:      "брак",
+		Reference:   "АКТ-1",
+	})
+
+	assert.NoError(t, err)
+	if assert.NotNil(t, response) {
+		assert.Equal(t, int64(3), response.Stock.Available)
+		assert.Equal(t, int64(-3), response.Movement.QuantityDelta)
+		assert.Equal(t, "api-key@10.0.0.5", response.Movement.Principal)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestWriteOffStock_BelowReserved тестирует отказ в списании зарезервированного товара без изменения остатка
+func TestWriteOffStock_BelowReserved(t *testing.T) {
+	uc, mock := newTestStockUseCase(t)
+	expectStockLocked(mock)
+	mock.ExpectRollback()
+
+	_, err := uc.WriteOffStock(context.Background(), &entity.WriteOffStockRequest{
+		ProductID:   103,
+		WarehouseID: 1,
+		Quantity:    7,
+		Actor:       "кладовщик Иванов",
+		Reason:      "брак",
+	})
+
+	assert.ErrorIs(t, err, ErrInsufficientStock)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestCountStock_BelowReserved тестирует, что инвентаризация фиксирует фактический остаток меньше резерва
+func TestCountStock_BelowReserved(t *testing.T) {
+	uc, mock := newTestStockUseCase(t)
+	expectStockLocked(mock)
+	mock.ExpectExec(`UPDATE "warehouse_stocks" SET`).
+		WithArgs(int64(2), int64(4), sqlmock.AnyArg(), uint(5)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "warehouse_items" SET`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "stock_movements"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	counted := int64(2)
+	response, err := uc.CountStock(context.Background(), &entity.InventoryCountRequest{
+		ProductID:       103,
+		WarehouseID:     1,
+		CountedQuantity: &counted,
+		Actor:           "кладовщик Иванов",
+	})
+
+	assert.NoError(t, err)
+	if assert.NotNil(t, response) {
+		assert.Equal(t, int64(-2), response.Stock.Available)
+		assert.Equal(t, int64(-8), response.Movement.QuantityDelta)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestApplyStockMovement_ProductNotFound тестирует операцию с неизвестным товаром
+func TestApplyStockMovement_ProductNotFound(t *testing.T) {
+	uc, mock := newTestStockUseCase(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "warehouse_items" WHERE product_id = \$1 .*FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	_, err := uc.AdjustStock(context.Background(), &entity.AdjustStockRequest{
+		ProductID: 404,
+		Delta:     1,
+		Actor:     "кладовщик Иванов",
+		Reason:    "пересорт",
+	})
+
+	assert.ErrorIs(t, err, ErrProductNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -4,21 +4,29 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
+	"github.com/director74/dz8_shop/pkg/messaging"
 	"github.com/director74/dz8_shop/warehouse-service/internal/entity"
 	"github.com/director74/dz8_shop/warehouse-service/internal/repo"
 )
 
 // WarehouseUseCase бизнес-логика для работы со складом
 type WarehouseUseCase struct {
-	repo *repo.WarehouseRepo
+	repo           *repo.WarehouseRepo
+	publisher      messaging.MessagePublisher
+	exchangeName   string
+	reservationTTL time.Duration
 }
 
 // NewWarehouseUseCase создает новый use case для склада
-func NewWarehouseUseCase(repo *repo.WarehouseRepo) *WarehouseUseCase {
+func NewWarehouseUseCase(repo *repo.WarehouseRepo, publisher messaging.MessagePublisher, exchangeName string) *WarehouseUseCase {
 	return &WarehouseUseCase{
-		repo: repo,
+		repo:           repo,
+		publisher:      publisher,
+		exchangeName:   exchangeName,
+		reservationTTL: defaultReservationTTL,
 	}
 }
 
@@ -37,6 +45,8 @@ func (u *WarehouseUseCase) GetWarehouseItemByID(id uint) (*entity.GetWarehouseRe
 		ID:          item.ID,
 		ProductID:   item.ProductID,
 		SKU:         item.SKU,
+		Name:        item.Name,
+		Price:       item.Price,
 		Quantity:    item.Quantity,
 		Available:   item.Available,
 		Status:      item.Status,
@@ -62,6 +72,8 @@ func (u *WarehouseUseCase) GetWarehouseItemByProductID(productID uint) (*entity.
 		ID:          item.ID,
 		ProductID:   item.ProductID,
 		SKU:         item.SKU,
+		Name:        item.Name,
+		Price:       item.Price,
 		Quantity:    item.Quantity,
 		Available:   item.Available,
 		Status:      item.Status,
@@ -91,6 +103,8 @@ func (u *WarehouseUseCase) GetAllWarehouseItems(limit, offset int) (*entity.List
 			ID:          item.ID,
 			ProductID:   item.ProductID,
 			SKU:         item.SKU,
+			Name:        item.Name,
+			Price:       item.Price,
 			Quantity:    item.Quantity,
 			Available:   item.Available,
 			Status:      item.Status,
@@ -111,10 +125,14 @@ func (u *WarehouseUseCase) CheckWarehouseAvailability(req *entity.CheckWarehouse
 		return nil, err
 	}
 
-	return &entity.CheckWarehouseResponse{
+	response := &entity.CheckWarehouseResponse{
 		Available:        available,
 		UnavailableItems: unavailableItems,
-	}, nil
+	}
+	if !available {
+		response.Backorderable, response.ExpectedAvailableAt = backorderTerms(unavailableItems)
+	}
+	return response, nil
 }
 
 // ReserveWarehouseItems резервирует товары для заказа
@@ -138,26 +156,32 @@ func (u *WarehouseUseCase) ReserveWarehouseItems(ctx context.Context, req *entit
 		return response, errors.New("недостаточно товаров для резервации")
 	}
 
-	// Резервируем каждый товар по отдельности
-	var reservedItems []entity.ReservedItemInfo
+	// Склады, участвующие в резервировании, от ближайшего к зоне доставки
+	warehouses, err := u.repo.ListWarehouses(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	ranking := rankWarehouses(warehouses, req.ZoneID)
+
+	productIDs := make([]uint, 0, len(req.Items))
 	for _, item := range req.Items {
-		reservation, err := u.repo.ReserveWarehouseItem(ctx, req.OrderID, item.ProductID, item.Quantity, req.ExpiresIn)
-		if err != nil {
-			// Если произошла ошибка, освобождаем уже зарезервированные товары
-			_ = u.ReleaseWarehouseItems(ctx, &entity.ReleaseWarehouseRequest{
-				OrderID: req.OrderID,
-				UserID:  req.UserID,
-			})
-			return nil, err
-		}
+		productIDs = append(productIDs, item.ProductID)
+	}
 
-		reservedItems = append(reservedItems, entity.ReservedItemInfo{
-			ProductID:  item.ProductID,
-			Quantity:   item.Quantity,
-			ReservedID: reservation.ID,
-		})
+	// Резервируем все товары заказа одной транзакцией, распределяя их по складам
+	reservations, err := u.repo.ReserveOrderItems(ctx, req.OrderID, productIDs, req.ExpiresIn, func(stocks []entity.LocationStock) ([]entity.StockAllocation, error) {
+		return planAllocation(req.Items, stocks, ranking)
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	var reservedItems []entity.ReservedItemInfo
+	for _, reservation := range reservations {
+		reservedItems = append(reservedItems, reservedItemInfo(reservation))
+	}
+	log.Printf("Товары заказа %d зарезервированы (зона доставки %d): %s", req.OrderID, req.ZoneID, describeAllocation(reservations))
+
 	response.Success = true
 	response.Message = "Товары успешно зарезервированы"
 	response.ReservedItems = reservedItems
@@ -230,7 +254,7 @@ func (u *WarehouseUseCase) ReserveForSaga(ctx context.Context, data interface{})
 	}
 
 	// Создаем запрос на резервацию
-	var expiry time.Duration = 30 * time.Minute // Резервация на 30 минут
+	expiry := u.reservationTTL
 	req := &entity.ReserveWarehouseRequest{
 		OrderID:   orderID,
 		UserID:    userID,
@@ -238,6 +262,11 @@ func (u *WarehouseUseCase) ReserveForSaga(ctx context.Context, data interface{})
 		ExpiresIn: &expiry,
 	}
 
+	// Зона доставки необязательна: без нее товар резервируется начиная с основного склада
+	if zoneID, ok := reqData["zone_id"].(uint); ok {
+		req.ZoneID = zoneID
+	}
+
 	// Выполняем резервацию
 	response, err := u.ReserveWarehouseItems(ctx, req)
 	if err != nil {